@@ -0,0 +1,319 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// op is an operation of a simulated client
+type op int
+
+const (
+	// opDiscover starts a new client: DISCOVER, or Solicit for DHCPv6
+	opDiscover op = iota
+	// opRequest requests the address offered to a client
+	opRequest
+	// opRenew renews the lease of a bound client
+	opRenew
+	// opRelease releases the lease of a bound client, which then leaves
+	opRelease
+	numOps
+)
+
+// client is a simulated client
+type client struct {
+	mac net.HardwareAddr
+	// ip is the address offered to the client, then leased
+	ip net.IP
+	// serverID is the encoded server identifier of the server which
+	// offered the address
+	serverID []byte
+}
+
+// result is what the reply to a message tells
+type result struct {
+	// ok is whether the server offered or leased an address
+	ok       bool
+	ip       net.IP
+	serverID []byte
+}
+
+// protocol builds the messages of the clients, and parses the replies
+type protocol interface {
+	// names returns the names of the operations
+	names() [numOps]string
+	// message returns the message of an operation of a client, and its
+	// transaction ID
+	message(o op, c *client) (uint32, []byte, error)
+	// parse returns the transaction ID and the result of a reply
+	parse(msg []byte) (uint32, result, error)
+	// answered returns whether an operation gets a reply
+	answered(o op) bool
+}
+
+// parseMix parses the weights of the operations, eg. discover=1,renew=8
+func parseMix(p protocol, value string) ([numOps]int, error) {
+	var mix [numOps]int
+	names := p.names()
+	total := 0
+	for _, field := range strings.Split(value, ",") {
+		sep := strings.IndexByte(field, '=')
+		if sep < 0 {
+			return mix, fmt.Errorf("invalid mix %q, expected <operation>=<weight>", field)
+		}
+		o := op(-1)
+		for i, name := range names {
+			if name == field[:sep] {
+				o = op(i)
+			}
+		}
+		if o < 0 {
+			return mix, fmt.Errorf("unknown operation %q, expected one of %s", field[:sep], strings.Join(names[:], ", "))
+		}
+		weight, err := strconv.Atoi(field[sep+1:])
+		if err != nil || weight < 0 {
+			return mix, fmt.Errorf("invalid weight %q", field[sep+1:])
+		}
+		mix[o] = weight
+		total += weight
+	}
+	if total == 0 {
+		return mix, fmt.Errorf("invalid mix %q, all the weights are zero", value)
+	}
+	return mix, nil
+}
+
+// transaction is a message waiting for its reply
+type transaction struct {
+	op   op
+	c    *client
+	sent time.Time
+}
+
+// bench simulates clients sending a mix of operations to a server
+type bench struct {
+	proto   protocol
+	conn    *net.UDPConn
+	server  *net.UDPAddr
+	timeout time.Duration
+	mix     [numOps]int
+	rng     *rand.Rand
+
+	mu      sync.Mutex
+	pending map[uint32]*transaction
+	// offered and bound hold the idle clients, by state
+	offered, bound []*client
+	clients        uint64
+	stats          [numOps]recorder
+}
+
+func newBench(p protocol, conn *net.UDPConn, server *net.UDPAddr, mix [numOps]int, timeout time.Duration) *bench {
+	return &bench{
+		proto:   p,
+		conn:    conn,
+		server:  server,
+		timeout: timeout,
+		mix:     mix,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		pending: make(map[uint32]*transaction),
+	}
+}
+
+// run sends operations at rate per second for duration, then waits for the
+// last replies
+func (b *bench) run(rate int, duration time.Duration) {
+	go b.receive()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(b.timeout / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				b.expire(now)
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i := int64(0); ; i++ {
+		at := time.Duration(i * int64(time.Second) / int64(rate))
+		if at >= duration {
+			break
+		}
+		if wait := time.Until(start.Add(at)); wait > 0 {
+			time.Sleep(wait)
+		}
+		b.step()
+	}
+	deadline := time.Now().Add(b.timeout)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		n := len(b.pending)
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	b.expire(time.Now().Add(b.timeout))
+}
+
+// pick draws an operation according to the mix
+func (b *bench) pick() op {
+	total := 0
+	for _, w := range b.mix {
+		total += w
+	}
+	n := b.rng.Intn(total)
+	for o, w := range b.mix {
+		if n < w {
+			return op(o)
+		}
+		n -= w
+	}
+	return opDiscover
+}
+
+// take removes a random client from a pool, nil if it is empty
+func (b *bench) take(pool *[]*client) *client {
+	n := len(*pool)
+	if n == 0 {
+		return nil
+	}
+	i := b.rng.Intn(n)
+	c := (*pool)[i]
+	(*pool)[i] = (*pool)[n-1]
+	*pool = (*pool)[:n-1]
+	return c
+}
+
+// step sends an operation. The operations without an idle client in the
+// required state start a new client instead
+func (b *bench) step() {
+	o := b.pick()
+	b.mu.Lock()
+	var c *client
+	switch o {
+	case opRequest:
+		c = b.take(&b.offered)
+	case opRenew, opRelease:
+		c = b.take(&b.bound)
+	}
+	if c == nil {
+		o = opDiscover
+		b.clients++
+		// Locally administered unicast addresses
+		mac := make(net.HardwareAddr, 8)
+		binary.BigEndian.PutUint64(mac, b.clients)
+		mac[2] = 0x02
+		c = &client{mac: mac[2:]}
+	}
+	b.mu.Unlock()
+
+	xid, msg, err := b.proto.message(o, c)
+	if err != nil {
+		log.Errorf("Could not build a message: %v", err)
+		return
+	}
+	b.mu.Lock()
+	b.stats[o].sent++
+	if b.proto.answered(o) {
+		b.pending[xid] = &transaction{op: o, c: c, sent: time.Now()}
+	}
+	b.mu.Unlock()
+	if _, err := b.conn.WriteToUDP(msg, b.server); err != nil {
+		log.Errorf("Could not send to %s: %v", b.server, err)
+	}
+}
+
+// receive matches the replies to their transactions
+func (b *bench) receive() {
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Errorf("Could not receive: %v", err)
+			return
+		}
+		now := time.Now()
+		xid, r, err := b.proto.parse(buf[:n])
+		if err != nil {
+			log.Debugf("Ignoring an invalid reply: %v", err)
+			continue
+		}
+		b.mu.Lock()
+		t, ok := b.pending[xid]
+		if !ok {
+			// Late, or for another client
+			b.mu.Unlock()
+			continue
+		}
+		delete(b.pending, xid)
+		s := &b.stats[t.op]
+		s.add(now.Sub(t.sent))
+		switch {
+		case t.op == opRelease:
+		case !r.ok:
+			// The client gives up
+			s.failed++
+		case t.op == opDiscover:
+			t.c.ip, t.c.serverID = r.ip, r.serverID
+			b.offered = append(b.offered, t.c)
+		default:
+			t.c.ip = r.ip
+			b.bound = append(b.bound, t.c)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// expire counts the transactions sent more than the timeout before now as
+// timed out, their clients give up
+func (b *bench) expire(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for xid, t := range b.pending {
+		if now.Sub(t.sent) >= b.timeout {
+			b.stats[t.op].timedOut++
+			delete(b.pending, xid)
+		}
+	}
+}
+
+// recorder holds the statistics of an operation
+type recorder struct {
+	sent, failed, timedOut int
+	latencies              []time.Duration
+}
+
+func (r *recorder) add(latency time.Duration) {
+	r.latencies = append(r.latencies, latency)
+}
+
+// percentile returns the latency below which are p percent of the replies
+func (r *recorder) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	i := int(p / 100 * float64(len(r.latencies)))
+	if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	}
+	return r.latencies[i]
+}
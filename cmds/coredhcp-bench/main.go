@@ -0,0 +1,121 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// coredhcp-bench simulates clients sending a mix of operations to a DHCP
+// server at a given rate, and reports the latencies of the replies, for
+// capacity planning and to catch performance regressions. See README.md
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+var log = logger.GetLogger("main")
+
+var (
+	flagV6       = flag.BoolP("v6", "6", false, "Simulate DHCPv6 clients instead of DHCPv4 ones")
+	flagServer   = flag.StringP("server", "s", "", "Address of the server, 127.0.0.1:67 or [::1]:547 by default")
+	flagRelay    = flag.StringP("relay", "r", "127.0.0.2", "DHCPv4 relay agent address to listen on (port 67), the replies are sent to it")
+	flagLocal    = flag.StringP("local", "l", "[::1]:0", "DHCPv6 address to send from and listen on")
+	flagRate     = flag.IntP("rate", "R", 100, "Operations sent per second")
+	flagDuration = flag.DurationP("duration", "d", 10*time.Second, "How long to send operations for")
+	flagTimeout  = flag.DurationP("timeout", "t", 2*time.Second, "How long to wait for a reply")
+	flagMix      = flag.StringP("mix", "m", "", "Weights of the operations, discover (solicit), request, renew and release, eg. discover=1,request=1,renew=8,release=1 (the default)")
+)
+
+func main() {
+	flag.Parse()
+	if *flagRate <= 0 {
+		log.Fatalf("Invalid rate %d", *flagRate)
+	}
+
+	var (
+		p      protocol
+		conn   *net.UDPConn
+		server *net.UDPAddr
+		err    error
+	)
+	if *flagV6 {
+		p = protocol6{}
+		if server, err = resolve("udp6", *flagServer, "[::1]:547"); err != nil {
+			log.Fatal(err)
+		}
+		local, err := net.ResolveUDPAddr("udp6", *flagLocal)
+		if err != nil {
+			log.Fatalf("Invalid local address: %v", err)
+		}
+		if conn, err = net.ListenUDP("udp6", local); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		relay := net.ParseIP(*flagRelay).To4()
+		if relay == nil {
+			log.Fatalf("Invalid relay agent address %q", *flagRelay)
+		}
+		p = &protocol4{relay: relay}
+		if server, err = resolve("udp4", *flagServer, "127.0.0.1:67"); err != nil {
+			log.Fatal(err)
+		}
+		if conn, err = net.ListenUDP("udp4", &net.UDPAddr{IP: relay, Port: 67}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	mixSpec := *flagMix
+	if mixSpec == "" {
+		names := p.names()
+		mixSpec = fmt.Sprintf("%s=1,request=1,renew=8,release=1", names[opDiscover])
+	}
+	mix, err := parseMix(p, mixSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	b := newBench(p, conn, server, mix, *flagTimeout)
+	log.Infof("Sending %d operations per second to %s for %v", *flagRate, server, *flagDuration)
+	start := time.Now()
+	b.run(*flagRate, *flagDuration)
+	b.report(time.Since(start))
+}
+
+func resolve(network, addr, fallback string) (*net.UDPAddr, error) {
+	if addr == "" {
+		addr = fallback
+	}
+	a, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	return a, nil
+}
+
+// report prints the statistics of the operations
+func (b *bench) report(elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "operation\tsent\treplies\tfailed\ttimed out\tp50\tp90\tp99\tmax\t")
+	var sent, replies int
+	names := b.proto.names()
+	for o := op(0); o < numOps; o++ {
+		s := &b.stats[o]
+		if s.sent == 0 {
+			continue
+		}
+		sent += s.sent
+		replies += len(s.latencies)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t\n", names[o], s.sent, len(s.latencies), s.failed, s.timedOut,
+			s.percentile(50), s.percentile(90), s.percentile(99), s.percentile(100))
+	}
+	w.Flush()
+	fmt.Printf("\n%d clients, %d messages sent in %v (%.0f/s), %d replies (%.0f/s)\n",
+		b.clients, sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds(), replies, float64(replies)/elapsed.Seconds())
+}
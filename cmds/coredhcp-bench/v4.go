@@ -0,0 +1,74 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// protocol4 simulates DHCPv4 clients behind a relay agent, the server
+// sending the replies to the relay agent address, on the server port. The
+// renewals are relayed too, as rebinding clients do
+type protocol4 struct {
+	relay net.IP
+}
+
+func (p *protocol4) names() [numOps]string {
+	return [numOps]string{"discover", "request", "renew", "release"}
+}
+
+func (p *protocol4) message(o op, c *client) (uint32, []byte, error) {
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithHwAddr(c.mac),
+		dhcpv4.WithGatewayIP(p.relay),
+	}
+	switch o {
+	case opDiscover:
+		mods = append(mods, dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	case opRequest:
+		mods = append(mods,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(c.ip)),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(c.serverID)),
+		)
+	case opRenew:
+		mods = append(mods,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithClientIP(c.ip),
+		)
+	case opRelease:
+		mods = append(mods,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+			dhcpv4.WithClientIP(c.ip),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(c.serverID)),
+		)
+	}
+	d, err := dhcpv4.New(mods...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(d.TransactionID[:]), d.ToBytes(), nil
+}
+
+func (p *protocol4) parse(msg []byte) (uint32, result, error) {
+	d, err := dhcpv4.FromBytes(msg)
+	if err != nil {
+		return 0, result{}, err
+	}
+	mt := d.MessageType()
+	r := result{
+		ok:       mt == dhcpv4.MessageTypeOffer || mt == dhcpv4.MessageTypeAck,
+		ip:       d.YourIPAddr,
+		serverID: d.ServerIdentifier(),
+	}
+	return binary.BigEndian.Uint32(d.TransactionID[:]), r, nil
+}
+
+func (p *protocol4) answered(o op) bool {
+	return o != opRelease
+}
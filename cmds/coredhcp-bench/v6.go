@@ -0,0 +1,74 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// protocol6 simulates DHCPv6 clients asking for an address (IA_NA), talking
+// to the server directly
+type protocol6 struct{}
+
+func (protocol6) names() [numOps]string {
+	return [numOps]string{"solicit", "request", "renew", "release"}
+}
+
+var messageTypes6 = [numOps]dhcpv6.MessageType{
+	opDiscover: dhcpv6.MessageTypeSolicit,
+	opRequest:  dhcpv6.MessageTypeRequest,
+	opRenew:    dhcpv6.MessageTypeRenew,
+	opRelease:  dhcpv6.MessageTypeRelease,
+}
+
+func (protocol6) message(o op, c *client) (uint32, []byte, error) {
+	m, err := dhcpv6.NewMessage()
+	if err != nil {
+		return 0, nil, err
+	}
+	m.MessageType = messageTypes6[o]
+	m.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: c.mac}))
+	m.AddOption(dhcpv6.OptElapsedTime(0))
+	ia := dhcpv6.OptIANA{}
+	copy(ia.IaId[:], c.mac[len(c.mac)-4:])
+	if o != opDiscover {
+		m.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionServerID, OptionData: c.serverID})
+		ia.Options.Add(&dhcpv6.OptIAAddress{IPv6Addr: c.ip})
+	}
+	m.AddOption(&ia)
+	return xid6(m.TransactionID), m.ToBytes(), nil
+}
+
+func (protocol6) parse(msg []byte) (uint32, result, error) {
+	m, err := dhcpv6.MessageFromBytes(msg)
+	if err != nil {
+		return 0, result{}, err
+	}
+	if m.MessageType != dhcpv6.MessageTypeAdvertise && m.MessageType != dhcpv6.MessageTypeReply {
+		return 0, result{}, errors.New("not an Advertise nor a Reply")
+	}
+	var r result
+	if sid := m.Options.ServerID(); sid != nil {
+		r.serverID = sid.ToBytes()
+	}
+	if ia := m.Options.OneIANA(); ia != nil {
+		status := ia.Options.Status()
+		if addr := ia.Options.OneAddress(); addr != nil && (status == nil || status.StatusCode == iana.StatusSuccess) {
+			r.ok, r.ip = true, addr.IPv6Addr
+		}
+	}
+	return xid6(m.TransactionID), r, nil
+}
+
+func (protocol6) answered(op) bool {
+	return true
+}
+
+func xid6(xid dhcpv6.TransactionID) uint32 {
+	return uint32(xid[0])<<16 | uint32(xid[1])<<8 | uint32(xid[2])
+}
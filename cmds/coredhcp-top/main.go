@@ -0,0 +1,200 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// coredhcp-top shows the status of a server, served by the status plugin:
+// the utilization of its pools, the counters of its plugins, its leases and
+// its recent lease events, refreshed live. See README.md
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/coredhcp/coredhcp/plugins/status"
+)
+
+var log = logger.GetLogger("main")
+
+var (
+	flagServer   = flag.StringP("server", "s", "127.0.0.1:8067", "Address of the status plugin of the server, or its URL")
+	flagInterval = flag.DurationP("interval", "i", time.Second, "How often to refresh")
+	flagRows     = flag.IntP("rows", "n", 10, "How many leases and events to show")
+	flagOnce     = flag.BoolP("once", "1", false, "Print the status once, without clearing the screen")
+	flagToken    = flag.StringP("token", "t", "", "Token of the status plugin, if it needs one")
+)
+
+// clear moves the cursor home and clears the terminal
+const clear = "\033[H\033[2J"
+
+func main() {
+	flag.Parse()
+	if *flagInterval <= 0 {
+		log.Fatalf("Invalid interval %v", *flagInterval)
+	}
+	url := *flagServer
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	url = strings.TrimSuffix(url, "/") + "/status"
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var prev *status.Status
+	for {
+		s, err := fetch(client, url)
+		var buf bytes.Buffer
+		if err != nil {
+			if *flagOnce {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(&buf, "%s: %v\n", url, err)
+		} else {
+			render(&buf, s, prev, *flagRows)
+			prev = s
+		}
+		if *flagOnce {
+			os.Stdout.Write(buf.Bytes())
+			return
+		}
+		os.Stdout.WriteString(clear)
+		os.Stdout.Write(buf.Bytes())
+		time.Sleep(*flagInterval)
+	}
+}
+
+func fetch(client *http.Client, url string) (*status.Status, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if *flagToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*flagToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var s status.Status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid status: %w", err)
+	}
+	return &s, nil
+}
+
+// render writes the status, with the rates of the counters since the previous
+// one, if any
+func render(buf *bytes.Buffer, s, prev *status.Status, rows int) {
+	fmt.Fprintf(buf, "coredhcp-top - %s - %d leases\n", s.Time.Local().Format("15:04:05"), len(s.Leases))
+
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	if len(s.Pools) > 0 {
+		fmt.Fprintln(w, "\nPOOL\tSIZE\tUSED\tUTILIZATION\t")
+		for _, name := range sortedKeys(s.Pools) {
+			p := s.Pools[name]
+			fmt.Fprintf(w, "%s\t%d\t%d\t%5.1f%% %s\t\n", name, p.Size, p.Used, 100*p.Utilization, bar(p.Utilization, 20))
+		}
+	}
+	w.Flush()
+
+	if len(s.Plugins) > 0 {
+		fmt.Fprintln(w, "\nPLUGIN\tCOUNTER\tVALUE\tRATE\t")
+		elapsed := 0.0
+		if prev != nil {
+			elapsed = s.Time.Sub(prev.Time).Seconds()
+		}
+		for _, plugin := range sortedKeys(s.Plugins) {
+			vars := s.Plugins[plugin]
+			for _, name := range sortedKeys(vars) {
+				rate := ""
+				if elapsed > 0 {
+					if old, ok := prev.Plugins[plugin][name]; ok {
+						rate = fmt.Sprintf("%.1f/s", (vars[name]-old)/elapsed)
+					}
+				}
+				fmt.Fprintf(w, "%s\t%s\t%g\t%s\t\n", plugin, name, vars[name], rate)
+			}
+		}
+	}
+	w.Flush()
+
+	if len(s.Leases) > 0 {
+		fmt.Fprintln(w, "\nADDRESS\tCLIENT\tHOSTNAME\tEXPIRES\t")
+		for i, l := range s.Leases {
+			if i == rows {
+				fmt.Fprintf(w, "... %d more\t\t\t\t\n", len(s.Leases)-rows)
+				break
+			}
+			expires := ""
+			if l.Expires != nil {
+				expires = l.Expires.Sub(s.Time).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", l.IP, client(l), l.Hostname, expires)
+		}
+	}
+	w.Flush()
+
+	if len(s.Events) > 0 {
+		fmt.Fprintln(w, "\nTIME\tEVENT\tADDRESS\tCLIENT\t")
+		for i, e := range s.Events {
+			if i == rows {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", e.Time.Local().Format("15:04:05"), e.Event, e.IP, client(e))
+		}
+	}
+	w.Flush()
+}
+
+// client returns the identifier of the client of an event
+func client(e leaseevents.Event) string {
+	if e.HWAddr != "" {
+		return e.HWAddr
+	}
+	return e.DUID
+}
+
+// bar draws a ratio as a bar of a given width
+func bar(ratio float64, width int) string {
+	n := int(ratio*float64(width) + 0.5)
+	if n < 0 {
+		n = 0
+	} else if n > width {
+		n = width
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", width-n) + "]"
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]status.Pool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]float64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]float64:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -10,26 +10,63 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/lab"
 	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/replay"
 	"github.com/coredhcp/coredhcp/server"
 
 	"github.com/coredhcp/coredhcp/plugins"
+	pl_anomaly "github.com/coredhcp/coredhcp/plugins/anomaly"
+	pl_audit "github.com/coredhcp/coredhcp/plugins/audit"
+	pl_auth "github.com/coredhcp/coredhcp/plugins/auth"
+	pl_bridge "github.com/coredhcp/coredhcp/plugins/bridge"
+	pl_captiveportal "github.com/coredhcp/coredhcp/plugins/captiveportal"
+	pl_ddns "github.com/coredhcp/coredhcp/plugins/ddns"
 	pl_dns "github.com/coredhcp/coredhcp/plugins/dns"
+	pl_eventlog "github.com/coredhcp/coredhcp/plugins/eventlog"
+	pl_exechook "github.com/coredhcp/coredhcp/plugins/exechook"
 	pl_file "github.com/coredhcp/coredhcp/plugins/file"
+	pl_ipv6only "github.com/coredhcp/coredhcp/plugins/ipv6only"
+	pl_kubernetes "github.com/coredhcp/coredhcp/plugins/kubernetes"
+	pl_leasequery "github.com/coredhcp/coredhcp/plugins/leasequery"
 	pl_leasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
+	pl_maxrt "github.com/coredhcp/coredhcp/plugins/maxrt"
+	pl_mud "github.com/coredhcp/coredhcp/plugins/mud"
 	pl_nbp "github.com/coredhcp/coredhcp/plugins/nbp"
+	pl_netbox "github.com/coredhcp/coredhcp/plugins/netbox"
 	pl_netmask "github.com/coredhcp/coredhcp/plugins/netmask"
+	pl_ntp "github.com/coredhcp/coredhcp/plugins/ntp"
+	pl_options "github.com/coredhcp/coredhcp/plugins/options"
+	pl_portlimit "github.com/coredhcp/coredhcp/plugins/portlimit"
+	pl_portmap "github.com/coredhcp/coredhcp/plugins/portmap"
 	pl_prefix "github.com/coredhcp/coredhcp/plugins/prefix"
+	pl_provision "github.com/coredhcp/coredhcp/plugins/provision"
+	pl_publisher "github.com/coredhcp/coredhcp/plugins/publisher"
 	pl_pxe "github.com/coredhcp/coredhcp/plugins/pxe"
+	pl_ra "github.com/coredhcp/coredhcp/plugins/ra"
+	pl_radius "github.com/coredhcp/coredhcp/plugins/radius"
 	pl_range "github.com/coredhcp/coredhcp/plugins/range"
+	pl_reconfigure "github.com/coredhcp/coredhcp/plugins/reconfigure"
 	pl_router "github.com/coredhcp/coredhcp/plugins/router"
+	pl_script "github.com/coredhcp/coredhcp/plugins/script"
 	pl_searchdomains "github.com/coredhcp/coredhcp/plugins/searchdomains"
 	pl_serverid "github.com/coredhcp/coredhcp/plugins/serverid"
+	pl_sip "github.com/coredhcp/coredhcp/plugins/sip"
 	pl_sleep "github.com/coredhcp/coredhcp/plugins/sleep"
+	pl_snmp "github.com/coredhcp/coredhcp/plugins/snmp"
 	pl_staticroute "github.com/coredhcp/coredhcp/plugins/staticroute"
+	pl_status "github.com/coredhcp/coredhcp/plugins/status"
+	pl_sync "github.com/coredhcp/coredhcp/plugins/sync"
+	pl_temporary "github.com/coredhcp/coredhcp/plugins/temporary"
+	pl_tftp "github.com/coredhcp/coredhcp/plugins/tftp"
+	pl_vendorinfo "github.com/coredhcp/coredhcp/plugins/vendorinfo"
+	pl_vivso "github.com/coredhcp/coredhcp/plugins/vivso"
+	pl_voip "github.com/coredhcp/coredhcp/plugins/voip"
+	pl_webhook "github.com/coredhcp/coredhcp/plugins/webhook"
 
 	"github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
@@ -39,8 +76,14 @@ var (
 	flagLogFile     = flag.StringP("logfile", "l", "", "Name of the log file to append to. Default: stdout/stderr only")
 	flagLogNoStdout = flag.BoolP("nostdout", "N", false, "Disable logging to stdout/stderr")
 	flagLogLevel    = flag.StringP("loglevel", "L", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
+	flagRedact      = flag.String("redact", logger.RedactNone, fmt.Sprintf("Redact the MAC addresses, UUIDs, DUIDs and host names of the clients in the logs. One of %v", []string{logger.RedactNone, logger.RedactHash, logger.RedactTruncate}))
+	flagRedactKey   = flag.String("redact-key-file", "", "File holding the key of the redaction hashes, to correlate them across restarts. Default: a random key")
+	flagSyslog      = flag.String("syslog", "", "Syslog server to send the logs to too, as udp://, tcp:// or tls://<host>[:<port>], in the RFC 5424 format")
 	flagConfig      = flag.StringP("conf", "c", "", "Use this configuration file instead of the default location")
 	flagPlugins     = flag.BoolP("plugins", "P", false, "list plugins")
+	flagReplay      = flag.StringSlice("replay", nil, "Replay the requests of these pcap files through the plugins instead of serving, and report how the replies differ from the captured ones")
+	flagLab         = flag.String("lab", "", "Serve DHCPv4 on this interface, autodetected if not given, with a generated configuration (see package lab) instead of a configuration file")
+	flagLabBoot     = flag.String("lab-boot", "pxelinux.0", "Boot file of the clients in lab mode, a URL or a file served by TFTP by the server")
 )
 
 var logLevels = map[string]func(*logrus.Logger){
@@ -61,22 +104,99 @@ func getLogLevels() []string {
 }
 
 var desiredPlugins = []*plugins.Plugin{
+	&pl_anomaly.Plugin,
+	&pl_audit.Plugin,
+	&pl_auth.Plugin,
+	&pl_bridge.Plugin,
+	&pl_captiveportal.Plugin,
+	&pl_ddns.Plugin,
 	&pl_dns.Plugin,
+	&pl_eventlog.Plugin,
+	&pl_exechook.Plugin,
 	&pl_file.Plugin,
+	&pl_ipv6only.Plugin,
+	&pl_kubernetes.Plugin,
+	&pl_leasequery.Plugin,
 	&pl_leasetime.Plugin,
+	&pl_maxrt.Plugin,
+	&pl_mud.Plugin,
 	&pl_nbp.Plugin,
+	&pl_netbox.Plugin,
 	&pl_netmask.Plugin,
+	&pl_ntp.Plugin,
+	&pl_options.Plugin,
+	&pl_portlimit.Plugin,
+	&pl_portmap.Plugin,
 	&pl_prefix.Plugin,
+	&pl_provision.Plugin,
+	&pl_publisher.Plugin,
 	&pl_pxe.Plugin,
+	&pl_ra.Plugin,
+	&pl_radius.Plugin,
 	&pl_range.Plugin,
+	&pl_reconfigure.Plugin,
 	&pl_router.Plugin,
+	&pl_script.Plugin,
 	&pl_searchdomains.Plugin,
 	&pl_serverid.Plugin,
+	&pl_sip.Plugin,
 	&pl_sleep.Plugin,
+	&pl_snmp.Plugin,
 	&pl_staticroute.Plugin,
+	&pl_status.Plugin,
+	&pl_sync.Plugin,
+	&pl_temporary.Plugin,
+	&pl_tftp.Plugin,
+	&pl_vendorinfo.Plugin,
+	&pl_vivso.Plugin,
+	&pl_voip.Plugin,
+	&pl_webhook.Plugin,
+}
+
+// loadConfig loads the configuration file, or generates the configuration of
+// lab mode
+func loadConfig() (*config.Config, error) {
+	if !flag.CommandLine.Changed("lab") {
+		return config.Load(*flagConfig)
+	}
+	iface := *flagLab
+	if iface == "auto" {
+		iface = ""
+	}
+	l, err := lab.New(iface, *flagLabBoot)
+	if err != nil {
+		return nil, err
+	}
+	l.Print()
+	return config.Parse(strings.NewReader(l.YAML()))
+}
+
+// runCommand runs the command given after the flags: `config migrate`
+// upgrades the configuration file to the current version of its schema, and
+// prints the changes
+func runCommand(args []string) int {
+	if len(args) != 2 || args[0] != "config" || args[1] != "migrate" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q, expected `config migrate`\n", strings.Join(args, " "))
+		return 2
+	}
+	path, err := config.Path(*flagConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find the configuration: %v\n", err)
+		return 1
+	}
+	changed, err := config.MigrateFile(path, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate %s: %v\n", path, err)
+		return 1
+	}
+	if !changed {
+		fmt.Printf("%s is up to date, at version %d\n", path, config.Version)
+	}
+	return 0
 }
 
 func main() {
+	flag.Lookup("lab").NoOptDefVal = "auto"
 	flag.Parse()
 
 	if *flagPlugins {
@@ -85,6 +205,9 @@ func main() {
 		}
 		os.Exit(0)
 	}
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
 
 	log := logger.GetLogger("main")
 	fn, ok := logLevels[*flagLogLevel]
@@ -93,15 +216,36 @@ func main() {
 	}
 	fn(log.Logger)
 	log.Infof("Setting log level to '%s'", *flagLogLevel)
+	if *flagRedact != logger.RedactNone {
+		var (
+			key []byte
+			err error
+		)
+		if *flagRedactKey != "" {
+			if key, err = ioutil.ReadFile(*flagRedactKey); err != nil {
+				log.Fatalf("Failed to read the redaction key: %v", err)
+			}
+		}
+		if err := logger.WithRedaction(log, *flagRedact, key); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Redacting the identifiers of the clients with %s", *flagRedact)
+	}
 	if *flagLogFile != "" {
 		log.Infof("Logging to file %s", *flagLogFile)
 		logger.WithFile(log, *flagLogFile)
 	}
+	if *flagSyslog != "" {
+		if err := logger.WithSyslog(log, *flagSyslog); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Logging to syslog server %s", *flagSyslog)
+	}
 	if *flagLogNoStdout {
 		log.Infof("Disabling logging to stdout/stderr")
 		logger.WithNoStdOutErr(log)
 	}
-	config, err := config.Load(*flagConfig)
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -112,6 +256,26 @@ func main() {
 		}
 	}
 
+	if len(*flagReplay) > 0 {
+		r, err := server.NewReplayer(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		differ := 0
+		for _, path := range *flagReplay {
+			stats, err := replay.File(r, path, os.Stdout)
+			if err != nil {
+				log.Fatalf("Failed to replay: %v", err)
+			}
+			log.Infof("%s: %d requests replayed, %d replies differ", path, stats.Requests, stats.Differ)
+			differ += stats.Differ
+		}
+		if differ > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// start server
 	srv, err := server.Start(config)
 	if err != nil {
@@ -0,0 +1,152 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ActionKind is what a plugin chain does after a plugin handled a request
+type ActionKind int
+
+// Chain actions
+const (
+	// ActionContinue runs the next plugin
+	ActionContinue ActionKind = iota
+	// ActionStop ends the chain, the reply being sent as it is
+	ActionStop
+	// ActionSkip runs the next plugin, but not the given ones
+	ActionSkip
+	// ActionGoto goes on with the plugins of a sub-chain, instead of the
+	// rest of the chain
+	ActionGoto
+)
+
+// Action is what a plugin chain does after a plugin handled a request:
+// `stop`, `continue`, `skip <plugin>...` or `goto <sub-chain>`
+type Action struct {
+	Kind ActionKind
+	// Plugins are the plugins not to run in the rest of the chain (skip)
+	Plugins []string
+	// Chain is the sub-chain to go on with (goto)
+	Chain string
+}
+
+// ParseAction parses the description of an action
+func ParseAction(s string) (*Action, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty action")
+	}
+	switch fields[0] {
+	case "continue", "stop":
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("invalid action %q, %s takes no argument", s, fields[0])
+		}
+		if fields[0] == "stop" {
+			return &Action{Kind: ActionStop}, nil
+		}
+		return &Action{Kind: ActionContinue}, nil
+	case "skip":
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid action %q, expected skip <plugin>...", s)
+		}
+		return &Action{Kind: ActionSkip, Plugins: fields[1:]}, nil
+	case "goto":
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid action %q, expected goto <sub-chain>", s)
+		}
+		return &Action{Kind: ActionGoto, Chain: fields[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown action %q, expected stop, continue, skip or goto", s)
+}
+
+// String returns the description of the action, as given to ParseAction
+func (a *Action) String() string {
+	switch a.Kind {
+	case ActionStop:
+		return "stop"
+	case ActionSkip:
+		return "skip " + strings.Join(a.Plugins, " ")
+	case ActionGoto:
+		return "goto " + a.Chain
+	}
+	return "continue"
+}
+
+// checkActions checks that the plugins skipped by the actions of a chain
+// follow them, and that the sub-chains they go to exist without looping
+func checkActions(ver protocolVersion, plugins []PluginConfig, subChains map[string][]PluginConfig) error {
+	check := func(chain string, plugins []PluginConfig) error {
+		for i, p := range plugins {
+			for _, a := range [...]*Action{p.OnMatch, p.OnMiss} {
+				switch {
+				case a == nil:
+				case a.Kind == ActionSkip:
+					for _, name := range a.Plugins {
+						if !hasPlugin(plugins[i+1:], name) {
+							return ConfigErrorFromString("dhcpv%d: %s: plugin `%s` skips `%s`, which does not follow it", ver, chain, p.Name, name)
+						}
+					}
+				case a.Kind == ActionGoto:
+					if _, ok := subChains[a.Chain]; !ok {
+						return ConfigErrorFromString("dhcpv%d: %s: plugin `%s` goes to unknown sub-chain `%s`", ver, chain, p.Name, a.Chain)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := check("plugins", plugins); err != nil {
+		return err
+	}
+	// Sub-chains going to sub-chains must not loop, depth-first
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(subChains))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return ConfigErrorFromString("dhcpv%d: sub-chain `%s` loops back to itself", ver, name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		plugins := subChains[name]
+		if err := check("sub-chain "+name, plugins); err != nil {
+			return err
+		}
+		for _, p := range plugins {
+			for _, a := range [...]*Action{p.OnMatch, p.OnMiss} {
+				if a != nil && a.Kind == ActionGoto {
+					if err := visit(a.Chain); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for name := range subChains {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasPlugin(plugins []PluginConfig, name string) bool {
+	for _, p := range plugins {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -7,9 +7,12 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -29,11 +32,36 @@ const (
 
 // Config holds the DHCPv6/v4 server configuration
 type Config struct {
-	v       *viper.Viper
+	v *viper.Viper
+	// Version is the version of the configuration schema, see Version
+	Version int
 	Server6 *ServerConfig
 	Server4 *ServerConfig
+	// User and Group are who the server runs as once its sockets are
+	// bound, when started as root (see the server package). Group defaults
+	// to the primary group of User
+	User, Group string
+	// Capabilities are the capabilities kept when running as User, see
+	// Capabilities
+	Capabilities []string
+	// secrets are the values of the references of the configuration, see
+	// interpolate.go
+	secrets []string
 }
 
+// The capabilities the server can keep when running as User
+const (
+	// CapNetBindService binds to the interfaces appearing later, see the
+	// interface patterns of the listen addresses
+	CapNetBindService = "net_bind_service"
+	// CapNetRaw opens raw sockets, to reply to the clients without an address
+	// yet
+	CapNetRaw = "net_raw"
+	// CapNetAdmin adds the clients without an address yet to the ARP cache,
+	// to reply to them through the UDP sockets
+	CapNetAdmin = "net_admin"
+)
+
 // New returns a new initialized instance of a Config object
 func New() *Config {
 	return &Config{v: viper.New()}
@@ -44,18 +72,151 @@ func New() *Config {
 type ServerConfig struct {
 	Addresses []net.UDPAddr
 	Plugins   []PluginConfig
+	// RapidCommit enables the 2-message exchange (RFC4039 for DHCPv4,
+	// RFC8415 §18.3.1 for DHCPv6) for clients that request it
+	RapidCommit bool
+	// Authoritative makes the DHCPv4 server NAK the requests for addresses it
+	// does not lease to the client (RFC2131 §4.3.2), instead of ignoring them
+	Authoritative bool
+	// VRF is the Linux VRF the addresses without interface are bound to
+	VRF string
+	// Workers is the number of requests handled concurrently, 0 for the
+	// default, see the server package
+	Workers int
+	// DedupWindow is how long the responses to DISCOVER and REQUEST (SOLICIT
+	// and REQUEST for DHCPv6) are kept to answer their retransmissions with,
+	// instead of running the plugins again. 0 disables it
+	DedupWindow time.Duration
+	// ShedThreshold is how full the queue of a worker is, in percent, when
+	// the requests of new clients (DISCOVER, SOLICIT) start being dropped to
+	// keep serving the others, eg. their renewals. 0 disables it
+	ShedThreshold int
+	// Malformed is what the server does with the malformed requests
+	Malformed MalformedPolicy
+	// VLANs are the VLANs of interfaces served without VLAN subinterfaces
+	// (DHCPv4 only)
+	VLANs []VLANs
+	// Relays are the addresses of the trusted relay agents: the relayed
+	// requests must come from one of them, and carry one of them as giaddr.
+	// All relays are trusted if empty (DHCPv4 only)
+	Relays []*net.IPNet
+	// RelayRate is the number of relayed requests per second accepted by
+	// giaddr, the others being dropped. 0 disables it (DHCPv4 only)
+	RelayRate int
+	// Reply is how the replies are sent to the clients (DHCPv4 only)
+	Reply ReplyPolicy
+	// Chains are other plugin chains, with their own addresses, eg. to serve
+	// several interfaces differently from a single process
+	Chains []*ServerConfig
+	// SubChains are named plugin chains the plugins can go to (see Action),
+	// instead of running the rest of their chain
+	SubChains map[string][]PluginConfig
+}
+
+// MalformedPolicy is what the server does with the requests failing its
+// sanity checks (see the server package): the options with inconsistent
+// lengths, nested options overflowing their option, absurd host names, ...
+type MalformedPolicy string
+
+// The malformed request policies
+const (
+	// MalformedLog logs the problems, and handles the request anyway. It
+	// is the default
+	MalformedLog MalformedPolicy = "log"
+	// MalformedDrop drops the request
+	MalformedDrop MalformedPolicy = "drop"
+	// MalformedBestEffort removes the malformed options, and handles the
+	// rest of the request. Requests which cannot be fixed are dropped
+	MalformedBestEffort MalformedPolicy = "best-effort"
+)
+
+// ReplyPolicy is how the server sends the DHCPv4 replies to the clients. The
+// replies to the relayed requests always go to the relay agent
+type ReplyPolicy string
+
+// The reply policies
+const (
+	// ReplyAuto follows RFC2131 §4.1: the NAKs are broadcast, the replies
+	// unicast to ciaddr if set, broadcast if the client sets the broadcast
+	// flag, and unicast to its hardware address and yiaddr otherwise. It is
+	// the default
+	ReplyAuto ReplyPolicy = "auto"
+	// ReplyBroadcast broadcasts all the replies, and sets the broadcast flag
+	// of the relayed ones for the relay agents to broadcast them too, for
+	// the clients ignoring the unicasts, eg. some PXE ROMs
+	ReplyBroadcast ReplyPolicy = "broadcast"
+	// ReplyUnicast ignores the broadcast flag, for the networks filtering
+	// the broadcasts, eg. some wireless access points
+	ReplyUnicast ReplyPolicy = "unicast"
+	// ReplyL2 sends the unicasts to the hardware address of the client,
+	// ciaddr included, rather than through the routing table and the ARP
+	// cache of the system
+	ReplyL2 ReplyPolicy = "l2"
+)
+
+// VLANs holds VLAN IDs of an interface, all of them if IDs is empty
+type VLANs struct {
+	Interface string
+	IDs       []VLANRange
+}
+
+// VLANRange holds a range of VLAN IDs, inclusive
+type VLANRange struct {
+	First, Last uint16
+}
+
+// Contains returns whether a VLAN ID is one of the VLANs
+func (v *VLANs) Contains(id uint16) bool {
+	if len(v.IDs) == 0 {
+		return id > 0 && id < 4095
+	}
+	for _, r := range v.IDs {
+		if id >= r.First && id <= r.Last {
+			return true
+		}
+	}
+	return false
 }
 
 // PluginConfig holds the configuration of a plugin
 type PluginConfig struct {
 	Name string
 	Args []string
+	// Settings are the settings of the plugin when given as a map instead of
+	// a string of arguments, eg. `range: {file: leases.txt}`. Args is then
+	// empty
+	Settings map[string]interface{}
+	// OnMatch is what the chain does after the plugin matched a request, ie.
+	// its handler asked to stop the chain. It stops if nil
+	OnMatch *Action
+	// OnMiss is what the chain does after the plugin let it go on. It goes
+	// on if nil
+	OnMiss *Action
 }
 
 // Load reads a configuration file and returns a Config object, or an error if
 // any.
 func Load(pathOverride string) (*Config, error) {
 	log.Print("Loading configuration")
+	c := newFile(pathOverride)
+	if err := c.v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return c.parse()
+}
+
+// Path returns the path of the configuration file Load reads
+func Path(pathOverride string) (string, error) {
+	c := newFile(pathOverride)
+	if err := c.v.ReadInConfig(); err != nil {
+		return "", err
+	}
+	return c.v.ConfigFileUsed(), nil
+}
+
+// newFile returns a Config reading the given file, or config.yml in the
+// default locations
+func newFile(pathOverride string) *Config {
 	c := New()
 	c.v.SetConfigType("yml")
 	if pathOverride != "" {
@@ -67,8 +228,28 @@ func Load(pathOverride string) (*Config, error) {
 		c.v.AddConfigPath("$HOME/.coredhcp/")
 		c.v.AddConfigPath("/etc/coredhcp/")
 	}
+	return c
+}
 
-	if err := c.v.ReadInConfig(); err != nil {
+// Parse reads a configuration in YAML, eg. one generated rather than read
+// from a file
+func Parse(r io.Reader) (*Config, error) {
+	c := New()
+	c.v.SetConfigType("yml")
+	if err := c.v.ReadConfig(r); err != nil {
+		return nil, err
+	}
+	return c.parse()
+}
+
+func (c *Config) parse() (*Config, error) {
+	if err := c.include(); err != nil {
+		return nil, err
+	}
+	if err := c.parseVersion(); err != nil {
+		return nil, err
+	}
+	if err := c.interpolate(); err != nil {
 		return nil, err
 	}
 	if err := c.parseConfig(protocolV6); err != nil {
@@ -80,9 +261,55 @@ func Load(pathOverride string) (*Config, error) {
 	if c.Server6 == nil && c.Server4 == nil {
 		return nil, ConfigErrorFromString("need at least one valid config for DHCPv6 or DHCPv4")
 	}
+	if err := c.parsePrivileges(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
 
+// parseVersion parses the version of the configuration schema, 1 if not set
+func (c *Config) parseVersion() error {
+	c.Version = 1
+	if version := c.v.Get("version"); version != nil {
+		v, err := cast.ToIntE(version)
+		if err != nil || v < 1 || v > Version {
+			return ConfigErrorFromString("unsupported configuration version %v, expected 1 to %d", version, Version)
+		}
+		c.Version = v
+	}
+	if c.Version < Version {
+		log.Warningf("The configuration is of version %d, run `coredhcp config migrate` to upgrade it to version %d", c.Version, Version)
+	}
+	return nil
+}
+
+// parsePrivileges parses the user the server runs as, and the capabilities it
+// keeps, all of them by default
+func (c *Config) parsePrivileges() error {
+	c.User = c.v.GetString("user")
+	c.Group = c.v.GetString("group")
+	if c.User == "" {
+		if c.Group != "" || c.v.IsSet("capabilities") {
+			return ConfigErrorFromString("`group` and `capabilities` need a `user`")
+		}
+		return nil
+	}
+	if !c.v.IsSet("capabilities") {
+		c.Capabilities = []string{CapNetBindService, CapNetRaw}
+		return nil
+	}
+	c.Capabilities = []string{}
+	for _, name := range c.v.GetStringSlice("capabilities") {
+		switch name = strings.TrimPrefix(strings.ToLower(name), "cap_"); name {
+		case CapNetBindService, CapNetRaw, CapNetAdmin:
+			c.Capabilities = append(c.Capabilities, name)
+		default:
+			return ConfigErrorFromString("invalid capability %q, expected %s, %s or %s", name, CapNetBindService, CapNetRaw, CapNetAdmin)
+		}
+	}
+	return nil
+}
+
 func protoVersionCheck(v protocolVersion) error {
 	if v != protocolV6 && v != protocolV4 {
 		return fmt.Errorf("invalid protocol version: %d", v)
@@ -97,22 +324,38 @@ func parsePlugins(pluginList []interface{}) ([]PluginConfig, error) {
 		if conf == nil {
 			return nil, ConfigErrorFromString("dhcpv6: plugin #%d is not a string map", idx)
 		}
-		// make sure that only one item is specified, since it's a
-		// map name -> args
-		if len(conf) != 1 {
-			return nil, ConfigErrorFromString("dhcpv6: exactly one plugin per item can be specified")
-		}
 		var (
-			name string
-			args []string
+			p     PluginConfig
+			names int
 		)
-		// only one item, as enforced above, so read just that
 		for k, v := range conf {
-			name = k
-			args = strings.Fields(cast.ToString(v))
-			break
+			var err error
+			switch k {
+			// the chain directives, next to the plugin
+			case "on_match":
+				p.OnMatch, err = ParseAction(cast.ToString(v))
+			case "on_miss":
+				p.OnMiss, err = ParseAction(cast.ToString(v))
+			default:
+				p.Name = k
+				switch v.(type) {
+				case map[string]interface{}, map[interface{}]interface{}:
+					p.Settings = cast.ToStringMap(v)
+				default:
+					p.Args = strings.Fields(cast.ToString(v))
+				}
+				names++
+			}
+			if err != nil {
+				return nil, ConfigErrorFromString("plugin #%d: %s: %v", idx, k, err)
+			}
+		}
+		// make sure that only one plugin is specified, since it's a
+		// map name -> args
+		if names != 1 {
+			return nil, ConfigErrorFromString("dhcpv6: exactly one plugin per item can be specified")
 		}
-		plugins = append(plugins, PluginConfig{Name: name, Args: args})
+		plugins = append(plugins, p)
 	}
 	return plugins, nil
 }
@@ -220,7 +463,14 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 		return err
 	}
 	for _, p := range plugins {
-		log.Printf("DHCPv%d: found plugin `%s` with %d args: %v", ver, p.Name, len(p.Args), p.Args)
+		log.Printf("DHCPv%d: found plugin `%s` with %d args: %v", ver, p.Name, len(p.Args), c.redactArgs(p.Args))
+	}
+	subChains, err := c.parseSubChains(ver)
+	if err != nil {
+		return err
+	}
+	if err := checkActions(ver, plugins, subChains); err != nil {
+		return err
 	}
 
 	listeners, err := c.parseListen(ver)
@@ -229,8 +479,62 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 	}
 
 	sc := ServerConfig{
-		Addresses: listeners,
-		Plugins:   plugins,
+		Addresses:   listeners,
+		Plugins:     plugins,
+		SubChains:   subChains,
+		RapidCommit: c.v.GetBool(fmt.Sprintf("server%d.rapid_commit", ver)),
+		VRF:         c.v.GetString(fmt.Sprintf("server%d.vrf", ver)),
+		Workers:     c.v.GetInt(fmt.Sprintf("server%d.workers", ver)),
+	}
+	if sc.Workers < 0 {
+		return ConfigErrorFromString("dhcpv%d: invalid number of workers %d", ver, sc.Workers)
+	}
+	if sc.ShedThreshold = c.v.GetInt(fmt.Sprintf("server%d.shed_threshold", ver)); sc.ShedThreshold < 0 || sc.ShedThreshold > 100 {
+		return ConfigErrorFromString("dhcpv%d: invalid shed_threshold %d, expected a percentage", ver, sc.ShedThreshold)
+	}
+	switch sc.Malformed = MalformedPolicy(c.v.GetString(fmt.Sprintf("server%d.malformed", ver))); sc.Malformed {
+	case "":
+		sc.Malformed = MalformedLog
+	case MalformedLog, MalformedDrop, MalformedBestEffort:
+	default:
+		return ConfigErrorFromString("dhcpv%d: invalid malformed policy %q, expected log, drop or best-effort", ver, sc.Malformed)
+	}
+	if window := c.v.Get(fmt.Sprintf("server%d.dedup_window", ver)); window != nil {
+		if sc.DedupWindow, err = cast.ToDurationE(window); err != nil || sc.DedupWindow < 0 {
+			return ConfigErrorFromString("dhcpv%d: invalid dedup_window %v", ver, window)
+		}
+	}
+	if ver == protocolV4 {
+		sc.Authoritative = c.v.GetBool("server4.authoritative")
+		if sc.VLANs, err = c.parseVLANs(); err != nil {
+			return err
+		}
+		if sc.Relays, err = c.parseRelays(); err != nil {
+			return err
+		}
+		if sc.RelayRate = c.v.GetInt("server4.relay_rate"); sc.RelayRate < 0 {
+			return ConfigErrorFromString("dhcpv4: invalid relay_rate %d", sc.RelayRate)
+		}
+		switch sc.Reply = ReplyPolicy(c.v.GetString("server4.reply")); sc.Reply {
+		case "":
+			sc.Reply = ReplyAuto
+		case ReplyAuto, ReplyBroadcast, ReplyUnicast, ReplyL2:
+		default:
+			return ConfigErrorFromString("dhcpv4: invalid reply policy %q, expected auto, broadcast, unicast or l2", sc.Reply)
+		}
+	}
+	if sc.VRF != "" {
+		for i := range sc.Addresses {
+			if sc.Addresses[i].Zone == "" {
+				sc.Addresses[i].Zone = sc.VRF
+			}
+		}
+	}
+	if sc.Chains, err = c.parseChains(ver); err != nil {
+		return err
+	}
+	if c.v.Get(fmt.Sprintf("server%d.listen", ver)) == nil && c.v.Get(fmt.Sprintf("server%d.interface", ver)) == nil {
+		sc.Addresses = leaveToChains(sc.Addresses, sc.Chains)
 	}
 	if ver == protocolV6 {
 		c.Server6 = &sc
@@ -240,12 +544,157 @@ func (c *Config) parseConfig(ver protocolVersion) error {
 	return nil
 }
 
-// BUG(Natolumin): When listening on link-local multicast addresses without
-// binding to a specific interface, new interfaces coming up after the server
-// starts will not be taken into account.
+// parseVLANs parses the VLANs section, a list of
+// <interface>[:<VLAN ID>[-<VLAN ID>][,...]]
+func (c *Config) parseVLANs() ([]VLANs, error) {
+	var vlans []VLANs
+	for _, spec := range c.v.GetStringSlice("server4.vlans") {
+		v := VLANs{Interface: spec}
+		if sep := strings.IndexByte(spec, ':'); sep >= 0 {
+			v.Interface = spec[:sep]
+			for _, r := range strings.Split(spec[sep+1:], ",") {
+				bounds := strings.SplitN(r, "-", 2)
+				first, err := strconv.ParseUint(bounds[0], 10, 12)
+				if err != nil || first == 0 || first == 4095 {
+					return nil, ConfigErrorFromString("dhcpv4: invalid VLAN ID in `vlans` directive: %s", spec)
+				}
+				last := first
+				if len(bounds) == 2 {
+					if last, err = strconv.ParseUint(bounds[1], 10, 12); err != nil || last < first || last == 4095 {
+						return nil, ConfigErrorFromString("dhcpv4: invalid VLAN ID in `vlans` directive: %s", spec)
+					}
+				}
+				v.IDs = append(v.IDs, VLANRange{First: uint16(first), Last: uint16(last)})
+			}
+		}
+		if v.Interface == "" {
+			return nil, ConfigErrorFromString("dhcpv4: no interface in `vlans` directive: %s", spec)
+		}
+		vlans = append(vlans, v)
+	}
+	return vlans, nil
+}
 
-func expandLLMulticast(addr *net.UDPAddr) ([]net.UDPAddr, error) {
-	if !addr.IP.IsLinkLocalMulticast() && !addr.IP.IsInterfaceLocalMulticast() {
+// parseRelays parses the addresses of the trusted relay agents, given as
+// addresses or prefixes
+func (c *Config) parseRelays() ([]*net.IPNet, error) {
+	var relays []*net.IPNet
+	for _, spec := range c.v.GetStringSlice("server4.relays") {
+		if _, prefix, err := net.ParseCIDR(spec); err == nil && prefix.IP.To4() != nil {
+			relays = append(relays, prefix)
+			continue
+		}
+		ip := net.ParseIP(spec).To4()
+		if ip == nil {
+			return nil, ConfigErrorFromString("dhcpv4: invalid relay %q, expected an IPv4 address or prefix", spec)
+		}
+		relays = append(relays, &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+	}
+	return relays, nil
+}
+
+// parseSubChains parses the sub-chains of a server, a map of names to plugin
+// lists
+func (c *Config) parseSubChains(ver protocolVersion) (map[string][]PluginConfig, error) {
+	conf := c.v.Get(fmt.Sprintf("server%d.subchains", ver))
+	if conf == nil {
+		return nil, nil
+	}
+	chainMap, err := cast.ToStringMapE(conf)
+	if err != nil {
+		return nil, ConfigErrorFromString("dhcpv%d: invalid subchains section, not a map", ver)
+	}
+	subChains := make(map[string][]PluginConfig, len(chainMap))
+	for name, val := range chainMap {
+		list, err := cast.ToSliceE(val)
+		if err != nil || len(list) == 0 {
+			return nil, ConfigErrorFromString("dhcpv%d: sub-chain `%s` is not a list of plugins", ver, name)
+		}
+		if subChains[name], err = parsePlugins(list); err != nil {
+			return nil, err
+		}
+	}
+	return subChains, nil
+}
+
+// parseChains parses the other plugin chains of a server, each of them
+// configured like the server itself (listen, plugins, ...)
+func (c *Config) parseChains(ver protocolVersion) ([]*ServerConfig, error) {
+	chainList := c.v.Get(fmt.Sprintf("server%d.chains", ver))
+	if chainList == nil {
+		return nil, nil
+	}
+	list, err := cast.ToSliceE(chainList)
+	if err != nil {
+		return nil, ConfigErrorFromString("dhcpv%d: invalid chains section, not a list", ver)
+	}
+	chains := make([]*ServerConfig, 0, len(list))
+	for idx, val := range list {
+		conf, err := cast.ToStringMapE(val)
+		if err != nil {
+			return nil, ConfigErrorFromString("dhcpv%d: chain #%d is not a map", ver, idx)
+		}
+		if _, ok := conf["chains"]; ok {
+			return nil, ConfigErrorFromString("dhcpv%d: chain #%d cannot have chains", ver, idx)
+		}
+		if _, ok := conf["listen"]; !ok {
+			return nil, ConfigErrorFromString("dhcpv%d: chain #%d has no listen section", ver, idx)
+		}
+		// Parse the chain as the only server of a configuration
+		sub := New()
+		sub.Version = c.Version
+		sub.secrets = c.secrets
+		sub.v.Set(fmt.Sprintf("server%d", ver), conf)
+		if err := sub.parseConfig(ver); err != nil {
+			return nil, err
+		}
+		if ver == protocolV6 {
+			chains = append(chains, sub.Server6)
+		} else {
+			chains = append(chains, sub.Server4)
+		}
+	}
+	return chains, nil
+}
+
+// leaveToChains removes the default listen addresses on the interfaces the
+// chains listen on, eg. the DHCPv6 multicast groups, the chains serving these
+// links: the requests received on an interface by several sockets bound to
+// the same address would go to either of them at random
+func leaveToChains(addrs []net.UDPAddr, chains []*ServerConfig) []net.UDPAddr {
+	kept := addrs[:0]
+	for _, a := range addrs {
+		claimed := false
+		for _, chain := range chains {
+			for _, ca := range chain.Addresses {
+				if a.Zone == "" {
+					continue
+				}
+				// The zones of the chains may be interface patterns
+				if matched, _ := path.Match(ca.Zone, a.Zone); matched {
+					claimed = true
+				}
+			}
+		}
+		if claimed {
+			log.Printf("Leaving %s to the chain listening on %s", a.String(), a.Zone)
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// BUG(Natolumin): When listening on multicast addresses without binding to a
+// specific interface, new interfaces coming up after the server starts will
+// not be taken into account. Use an interface pattern instead, eg.
+// `[ff02::1:2%eth*]:547`.
+
+// expandMulticast returns a multicast address on each interface supporting
+// multicast, as the groups are joined per interface: joined without one, the
+// group would only be on the interface of the default route
+func expandMulticast(addr *net.UDPAddr) ([]net.UDPAddr, error) {
+	if !addr.IP.IsMulticast() {
 		return nil, errors.New("Address is not multicast")
 	}
 	if addr.Zone != "" {
@@ -281,15 +730,17 @@ func defaultListen(ver protocolVersion) ([]net.UDPAddr, error) {
 	case protocolV4:
 		return []net.UDPAddr{{Port: dhcpv4.ServerPort}}, nil
 	case protocolV6:
-		l, err := expandLLMulticast(&net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort})
+		l, err := expandMulticast(&net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort})
 		if err != nil {
 			return nil, err
 		}
-		l = append(l,
-			net.UDPAddr{IP: dhcpv6.AllDHCPServers, Port: dhcpv6.DefaultServerPort},
-			// XXX: Do we want to listen on [::] as default ?
-		)
-		return l, nil
+		// The site-scoped group of the relays, on the same interfaces
+		servers, err := expandMulticast(&net.UDPAddr{IP: dhcpv6.AllDHCPServers, Port: dhcpv6.DefaultServerPort})
+		if err != nil {
+			return nil, err
+		}
+		// XXX: Do we want to listen on [::] as default ?
+		return append(l, servers...), nil
 	}
 	return nil, errors.New("defaultListen: Incorrect protocol version")
 }
@@ -302,7 +753,11 @@ func (c *Config) parseListen(ver protocolVersion) ([]net.UDPAddr, error) {
 	listen := c.v.Get(fmt.Sprintf("server%d.listen", ver))
 
 	// Provide an emulation of the old keyword "interface" to avoid breaking config files
-	if iface := c.v.Get(fmt.Sprintf("server%d.interface", ver)); iface != nil && listen != nil {
+	iface := c.v.Get(fmt.Sprintf("server%d.interface", ver))
+	if iface != nil && c.Version >= 2 {
+		return nil, ConfigErrorFromString("dhcpv%d: interface was replaced by listen in version 2 of the configuration", ver)
+	}
+	if iface != nil && listen != nil {
 		return nil, ConfigErrorFromString("interface is a deprecated alias for listen, " +
 			"both cannot be used at the same time. Choose one and remove the other.")
 	} else if iface != nil {
@@ -325,9 +780,9 @@ func (c *Config) parseListen(ver protocolVersion) ([]net.UDPAddr, error) {
 			return nil, err
 		}
 
-		if l.Zone == "" && (l.IP.IsLinkLocalMulticast() || l.IP.IsInterfaceLocalMulticast()) {
-			// link-local multicast specified without interface gets expanded to listen on all interfaces
-			expanded, err := expandLLMulticast(l)
+		if l.Zone == "" && l.IP.IsMulticast() {
+			// multicast specified without interface gets expanded to listen on all interfaces
+			expanded, err := expandMulticast(l)
 			if err != nil {
 				return nil, err
 			}
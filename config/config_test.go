@@ -4,7 +4,12 @@
 
 package config
 
-import "testing"
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestSplitHostPort(t *testing.T) {
 	testcases := []struct {
@@ -50,3 +55,619 @@ func TestSplitHostPort(t *testing.T) {
 		}
 	}
 }
+
+func TestRapidCommit(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server6:
+  listen: "[::]"
+  rapid_commit: true
+  plugins:
+    - server_id: LL 00:de:ad:be:ef:00
+server4:
+  listen: "0.0.0.0"
+  plugins:
+    - server_id: 10.0.0.1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV6); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Server6.RapidCommit {
+		t.Error("rapid_commit should be enabled for DHCPv6")
+	}
+	if c.Server4.RapidCommit {
+		t.Error("rapid_commit should default to disabled for DHCPv4")
+	}
+}
+
+func TestAuthoritative(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  listen: "0.0.0.0"
+  authoritative: true
+  plugins:
+    - server_id: 10.0.0.1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Server4.Authoritative {
+		t.Error("authoritative should be enabled for DHCPv4")
+	}
+}
+
+func TestChains(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  listen: "%eth0"
+  plugins:
+    - server_id: 10.0.0.1
+  chains:
+    - listen: ["%vlan*", "10.1.0.1"]
+      vrf: blue
+      authoritative: true
+      plugins:
+        - server_id: 10.1.0.1
+        - dns: 10.1.0.53
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Server4.Chains) != 1 {
+		t.Fatalf("expected 1 chain, got %d", len(c.Server4.Chains))
+	}
+	chain := c.Server4.Chains[0]
+	if len(chain.Plugins) != 2 || chain.Plugins[1].Name != "dns" {
+		t.Errorf("unexpected plugins %v", chain.Plugins)
+	}
+	if !chain.Authoritative || c.Server4.Authoritative {
+		t.Error("authoritative should only be enabled for the chain")
+	}
+	// The addresses without interface are bound to the VRF
+	if len(chain.Addresses) != 2 || chain.Addresses[0].Zone != "vlan*" || chain.Addresses[1].Zone != "blue" {
+		t.Errorf("unexpected addresses %v", chain.Addresses)
+	}
+
+	for _, conf := range []string{
+		// no listen section
+		`
+server4:
+  plugins:
+    - server_id: 10.0.0.1
+  chains:
+    - plugins:
+        - server_id: 10.1.0.1
+`,
+		// nested chains
+		`
+server4:
+  plugins:
+    - server_id: 10.0.0.1
+  chains:
+    - listen: "%eth1"
+      plugins:
+        - server_id: 10.1.0.1
+      chains:
+        - listen: "%eth2"
+`,
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		if err := c.v.ReadConfig(strings.NewReader(conf)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.parseConfig(protocolV4); err == nil {
+			t.Errorf("invalid chain accepted: %s", conf)
+		}
+	}
+}
+
+func TestLeaveToChains(t *testing.T) {
+	group := net.ParseIP("ff02::1:2")
+	addrs := []net.UDPAddr{
+		{IP: group, Port: 547, Zone: "eth0"},
+		{IP: group, Port: 547, Zone: "eth1"},
+		{IP: group, Port: 547, Zone: "vlan10"},
+		{IP: net.IPv6unspecified, Port: 547},
+	}
+	chains := []*ServerConfig{
+		{Addresses: []net.UDPAddr{{IP: group, Port: 547, Zone: "eth1"}}},
+		{Addresses: []net.UDPAddr{{IP: group, Port: 547, Zone: "vlan*"}}},
+	}
+	kept := leaveToChains(addrs, chains)
+	if len(kept) != 2 || kept[0].Zone != "eth0" || kept[1].Zone != "" {
+		t.Errorf("expected the addresses on eth0 and without interface, got %v", kept)
+	}
+}
+
+func TestVLANs(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  vlans: ["eth0:100-199,300", "eth1"]
+  plugins:
+    - server_id: 10.0.0.1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	vlans := c.Server4.VLANs
+	if len(vlans) != 2 || vlans[0].Interface != "eth0" || vlans[1].Interface != "eth1" {
+		t.Fatalf("unexpected VLANs %v", vlans)
+	}
+	for id, want := range map[uint16]bool{1: false, 100: true, 199: true, 200: false, 300: true} {
+		if vlans[0].Contains(id) != want {
+			t.Errorf("VLAN %d of eth0: got %v, expected %v", id, !want, want)
+		}
+	}
+	if !vlans[1].Contains(4094) || vlans[1].Contains(4095) {
+		t.Error("eth1 should have all the VLANs")
+	}
+
+	for _, spec := range []string{":100", "eth0:0", "eth0:4095", "eth0:200-100", "eth0:vlan"} {
+		c := New()
+		c.v.Set("server4.plugins", []interface{}{map[string]interface{}{"server_id": "10.0.0.1"}})
+		c.v.Set("server4.vlans", []string{spec})
+		if err := c.parseConfig(protocolV4); err == nil {
+			t.Errorf("invalid VLANs %q accepted", spec)
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		workers string
+		want    int
+		err     bool
+	}{
+		{"", 0, false},
+		{"workers: 8", 8, false},
+		{"workers: -1", 0, true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  listen: "0.0.0.0"
+  ` + tc.workers + `
+  plugins:
+    - server_id: 10.0.0.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV4)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.workers)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.workers, err)
+		}
+		if c.Server4.Workers != tc.want {
+			t.Errorf("%q: expected %d workers, got %d", tc.workers, tc.want, c.Server4.Workers)
+		}
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	for _, tc := range []struct {
+		window string
+		want   time.Duration
+		err    bool
+	}{
+		{"", 0, false},
+		{"dedup_window: 5s", 5 * time.Second, false},
+		{"dedup_window: soon", 0, true},
+		{"dedup_window: -1s", 0, true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  listen: "0.0.0.0"
+  ` + tc.window + `
+  plugins:
+    - server_id: 10.0.0.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV4)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.window, err)
+		}
+		if c.Server4.DedupWindow != tc.want {
+			t.Errorf("%q: expected %s, got %s", tc.window, tc.want, c.Server4.DedupWindow)
+		}
+	}
+}
+
+func TestShedThreshold(t *testing.T) {
+	for _, tc := range []struct {
+		threshold string
+		want      int
+		err       bool
+	}{
+		{"", 0, false},
+		{"shed_threshold: 75", 75, false},
+		{"shed_threshold: 101", 0, true},
+		{"shed_threshold: -1", 0, true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server6:
+  ` + tc.threshold + `
+  plugins:
+    - server_id: LL 00:de:ad:be:ef:00
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV6)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.threshold)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.threshold, err)
+		}
+		if c.Server6.ShedThreshold != tc.want {
+			t.Errorf("%q: expected %d, got %d", tc.threshold, tc.want, c.Server6.ShedThreshold)
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   MalformedPolicy
+		err    bool
+	}{
+		{"", MalformedLog, false},
+		{"malformed: drop", MalformedDrop, false},
+		{"malformed: best-effort", MalformedBestEffort, false},
+		{"malformed: ignore", "", true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  ` + tc.policy + `
+  plugins:
+    - server_id: 10.0.0.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV4)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.policy, err)
+		}
+		if c.Server4.Malformed != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.policy, tc.want, c.Server4.Malformed)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   ReplyPolicy
+		err    bool
+	}{
+		{"", ReplyAuto, false},
+		{"reply: broadcast", ReplyBroadcast, false},
+		{"reply: l2", ReplyL2, false},
+		{"reply: multicast", "", true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  ` + tc.policy + `
+  plugins:
+    - server_id: 10.0.0.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV4)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.policy, err)
+		}
+		if c.Server4.Reply != tc.want {
+			t.Errorf("%q: expected %q, got %q", tc.policy, tc.want, c.Server4.Reply)
+		}
+	}
+}
+
+func TestRelays(t *testing.T) {
+	for _, tc := range []struct {
+		relays string
+		want   []string
+		err    bool
+	}{
+		{"", nil, false},
+		{"relays: [10.0.0.1, 10.1.0.0/16]\n  relay_rate: 100", []string{"10.0.0.1/32", "10.1.0.0/16"}, false},
+		{"relays: [relay1]", nil, true},
+		{"relays: [2001:db8::1]", nil, true},
+		{"relay_rate: -1", nil, true},
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  ` + tc.relays + `
+  plugins:
+    - server_id: 10.0.0.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.parseConfig(protocolV4)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.relays)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", tc.relays, err)
+		}
+		var got []string
+		for _, r := range c.Server4.Relays {
+			got = append(got, r.String())
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%q: expected %v, got %v", tc.relays, tc.want, got)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q: expected %v, got %v", tc.relays, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  plugins:
+    - server_id: 10.0.0.1
+    - file: leases.txt
+      on_match: skip range
+      on_miss: goto pxe
+    - range: leases.txt 10.0.0.100 10.0.0.200 1h
+  subchains:
+    pxe:
+      - pxe: tftp://10.0.0.1/pxelinux.0
+        on_match: stop
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	plugins := c.Server4.Plugins
+	if len(plugins) != 3 || plugins[1].Name != "file" || len(plugins[1].Args) != 1 {
+		t.Fatalf("unexpected plugins %v", plugins)
+	}
+	if plugins[0].OnMatch != nil || plugins[0].OnMiss != nil {
+		t.Error("server_id should have no directives")
+	}
+	if a := plugins[1].OnMatch; a == nil || a.String() != "skip range" {
+		t.Errorf("unexpected on_match %v", a)
+	}
+	if a := plugins[1].OnMiss; a == nil || a.Kind != ActionGoto || a.Chain != "pxe" {
+		t.Errorf("unexpected on_miss %v", a)
+	}
+	pxe := c.Server4.SubChains["pxe"]
+	if len(pxe) != 1 || pxe[0].Name != "pxe" || pxe[0].OnMatch.Kind != ActionStop {
+		t.Errorf("unexpected sub-chain %v", pxe)
+	}
+
+	for _, conf := range []string{
+		// unknown action
+		`
+server4:
+  plugins:
+    - file: leases.txt
+      on_match: jump
+`,
+		// skipping a plugin that does not follow
+		`
+server4:
+  plugins:
+    - dns: 10.0.0.53
+    - file: leases.txt
+      on_match: skip dns
+`,
+		// unknown sub-chain
+		`
+server4:
+  plugins:
+    - file: leases.txt
+      on_miss: goto pxe
+`,
+		// looping sub-chains
+		`
+server4:
+  plugins:
+    - file: leases.txt
+      on_miss: goto a
+  subchains:
+    a:
+      - dns: 10.0.0.53
+        on_miss: goto b
+    b:
+      - router: 10.0.0.1
+        on_miss: goto a
+`,
+		// two plugins in an item
+		`
+server4:
+  plugins:
+    - file: leases.txt
+      dns: 10.0.0.53
+`,
+	} {
+		c := New()
+		c.v.SetConfigType("yml")
+		if err := c.v.ReadConfig(strings.NewReader(conf)); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.parseConfig(protocolV4); err == nil {
+			t.Errorf("invalid directives accepted: %s", conf)
+		}
+	}
+}
+
+func TestPluginSettings(t *testing.T) {
+	c := New()
+	c.v.SetConfigType("yml")
+	err := c.v.ReadConfig(strings.NewReader(`
+server4:
+  plugins:
+    - server_id: 10.0.0.1
+    - script:
+        path: /etc/coredhcp/policy.star
+        args: [192.0.2.10, pxelinux.0]
+      on_miss: stop
+    - lease_time:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseConfig(protocolV4); err != nil {
+		t.Fatal(err)
+	}
+	plugins := c.Server4.Plugins
+	if len(plugins) != 3 {
+		t.Fatalf("unexpected plugins %v", plugins)
+	}
+	if len(plugins[0].Args) != 1 || plugins[0].Settings != nil {
+		t.Errorf("unexpected server_id configuration %v", plugins[0])
+	}
+	script := plugins[1]
+	if len(script.Args) != 0 || script.Settings["path"] != "/etc/coredhcp/policy.star" {
+		t.Errorf("unexpected script configuration %v", script)
+	}
+	if args, ok := script.Settings["args"].([]interface{}); !ok || len(args) != 2 {
+		t.Errorf("unexpected script args %v", script.Settings["args"])
+	}
+	if script.OnMiss == nil || script.OnMiss.Kind != ActionStop {
+		t.Errorf("unexpected on_miss %v", script.OnMiss)
+	}
+	if len(plugins[2].Args) != 0 || plugins[2].Settings != nil {
+		t.Errorf("unexpected lease_time configuration %v", plugins[2])
+	}
+}
+
+func TestPrivileges(t *testing.T) {
+	testcases := []struct {
+		conf string
+		caps []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"user: coredhcp", []string{CapNetBindService, CapNetRaw}, false},
+		{"user: coredhcp\ngroup: dhcp\ncapabilities: [CAP_NET_RAW]", []string{CapNetRaw}, false},
+		{"user: coredhcp\ncapabilities: []", []string{}, false},
+		{"user: coredhcp\ncapabilities: [sys_admin]", nil, true},
+		{"group: dhcp", nil, true},
+	}
+	for _, tc := range testcases {
+		c := New()
+		c.v.SetConfigType("yml")
+		if err := c.v.ReadConfig(strings.NewReader(tc.conf)); err != nil {
+			t.Fatal(err)
+		}
+		err := c.parsePrivileges()
+		if tc.err != (err != nil) {
+			t.Errorf("%q: unexpected error %v", tc.conf, err)
+			continue
+		}
+		if err == nil && strings.Join(c.Capabilities, ",") != strings.Join(tc.caps, ",") {
+			t.Errorf("%q: expected capabilities %v, got %v", tc.conf, tc.caps, c.Capabilities)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	testcases := []struct {
+		conf    string
+		version int
+		err     bool
+	}{
+		{"server4:\n  interface: eth0\n  plugins:\n    - server_id: 10.0.0.1", 1, false},
+		{"version: 1\nserver4:\n  interface: eth0\n  plugins:\n    - server_id: 10.0.0.1", 1, false},
+		{"version: 2\nserver4:\n  listen: '%eth0'\n  plugins:\n    - server_id: 10.0.0.1", 2, false},
+		{"version: 2\nserver4:\n  interface: eth0\n  plugins:\n    - server_id: 10.0.0.1", 0, true},
+		{"version: 3\nserver4:\n  plugins:\n    - server_id: 10.0.0.1", 0, true},
+		{"version: two\nserver4:\n  plugins:\n    - server_id: 10.0.0.1", 0, true},
+	}
+	for _, tc := range testcases {
+		c, err := Parse(strings.NewReader(tc.conf))
+		if tc.err != (err != nil) {
+			t.Errorf("%q: unexpected error %v", tc.conf, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.Version != tc.version {
+			t.Errorf("%q: expected version %d, got %d", tc.conf, tc.version, c.Version)
+		}
+		if len(c.Server4.Addresses) != 1 || c.Server4.Addresses[0].Zone != "eth0" {
+			t.Errorf("%q: unexpected addresses %v", tc.conf, c.Server4.Addresses)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// include merges the files included by the configuration, given by the glob
+// patterns of its `include` key, eg. `include: conf.d/*.yml`, relative to the
+// directory of the configuration file. They are merged in the order of the
+// patterns, and of their names for each pattern: the maps are merged, the
+// lists appended to those of the previous files, eg. the chains of the
+// subnets, and the other values replaced. The included files cannot include
+// others, and take the version of the configuration
+func (c *Config) include() error {
+	if c.v.Get("include") == nil {
+		return nil
+	}
+	patterns, err := cast.ToStringSliceE(c.v.Get("include"))
+	if err != nil {
+		return ConfigErrorFromString("invalid include section, expected a list of files")
+	}
+	dir := "."
+	if file := c.v.ConfigFileUsed(); file != "" {
+		dir = filepath.Dir(file)
+	}
+	version := fileVersion(c.v)
+	merged := make(map[string]interface{})
+	for _, key := range topKeys(c.v) {
+		merged[key] = c.v.Get(key)
+	}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return ConfigErrorFromString("invalid include pattern %q: %v", pattern, err)
+		}
+		if len(files) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+			return ConfigErrorFromString("included file %s does not exist", pattern)
+		}
+		for _, file := range files {
+			inc := viper.New()
+			inc.SetConfigFile(file)
+			inc.SetConfigType("yml")
+			if err := inc.ReadInConfig(); err != nil {
+				return ConfigErrorFromString("%s: %v", file, err)
+			}
+			if inc.Get("include") != nil {
+				return ConfigErrorFromString("%s: included files cannot include others", file)
+			}
+			if v := fileVersion(inc); inc.Get("version") != nil && v != version {
+				return ConfigErrorFromString("%s: version %d differs from the one of the configuration, %d", file, v, version)
+			}
+			log.Printf("Including %s", file)
+			for _, key := range topKeys(inc) {
+				if key != "version" {
+					merged[key] = merge(file, key, merged[key], inc.Get(key))
+				}
+			}
+		}
+	}
+	for key, val := range merged {
+		c.v.Set(key, val)
+	}
+	return nil
+}
+
+// fileVersion returns the version of the schema a file sets, 1 if none
+func fileVersion(v *viper.Viper) int {
+	if version := v.Get("version"); version != nil {
+		return cast.ToInt(version)
+	}
+	return 1
+}
+
+// topKeys returns the top-level keys of a configuration
+func topKeys(v *viper.Viper) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range v.AllKeys() {
+		top := strings.SplitN(key, ".", 2)[0]
+		if !seen[top] {
+			seen[top] = true
+			keys = append(keys, top)
+		}
+	}
+	return keys
+}
+
+// merge returns the value of a key of the configuration merged with the one
+// of an included file: the maps are merged, the lists appended, and the other
+// values replaced
+func merge(file, key string, dst, src interface{}) interface{} {
+	if dst == nil {
+		return src
+	}
+	switch s := src.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		d, err := cast.ToStringMapE(dst)
+		if err != nil {
+			break
+		}
+		out := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			out[k] = v
+		}
+		for k, v := range cast.ToStringMap(s) {
+			out[k] = merge(file, key+"."+k, d[k], v)
+		}
+		return out
+	case []interface{}:
+		if d, ok := dst.([]interface{}); ok {
+			return append(append([]interface{}{}, d...), s...)
+		}
+	}
+	if !reflect.DeepEqual(dst, src) {
+		log.Printf("%s: overriding %s", file, key)
+	}
+	return src
+}
@@ -0,0 +1,92 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	main := write("config.yml", `
+version: 2
+include: conf.d/*.yml
+server4:
+  listen: "%eth0"
+  authoritative: true
+  plugins:
+    - server_id: 10.0.0.1
+`)
+	write("conf.d/20-site-b.yml", `
+server4:
+  chains:
+    - listen: "%eth2"
+      plugins:
+        - server_id: 10.0.2.1
+`)
+	write("conf.d/10-site-a.yml", `
+server4:
+  authoritative: false
+  chains:
+    - listen: "%eth1"
+      plugins:
+        - server_id: 10.0.1.1
+  subchains:
+    pxe:
+      - nbp: tftp://10.0.1.1/pxelinux.0
+`)
+	write("conf.d/ignored.txt", "server4: {workers: 4}")
+
+	c, err := Load(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := c.Server4
+	if len(s.Plugins) != 1 || s.Plugins[0].Args[0] != "10.0.0.1" {
+		t.Errorf("unexpected plugins %v", s.Plugins)
+	}
+	// The later files override the values, and append to the lists
+	if s.Authoritative {
+		t.Error("expected authoritative overridden by the included file")
+	}
+	if len(s.Chains) != 2 || s.Chains[0].Addresses[0].Zone != "eth1" || s.Chains[1].Addresses[0].Zone != "eth2" {
+		t.Errorf("unexpected chains %v", s.Chains)
+	}
+	if _, ok := s.SubChains["pxe"]; !ok || s.Workers != 0 {
+		t.Errorf("unexpected sub-chains %v or workers %d", s.SubChains, s.Workers)
+	}
+
+	for name, conf := range map[string]string{
+		"nested.yml":  "include: conf.d/*.yml\n",
+		"version.yml": "version: 1\n",
+		"missing.yml": "include: conf.d/missing.yml\nserver4:\n  plugins:\n    - server_id: 10.0.0.1\n",
+	} {
+		path := write(name, conf)
+		if name != "missing.yml" {
+			write("config.yml", "version: 2\ninclude: "+name+"\nserver4:\n  plugins:\n    - server_id: 10.0.0.1\n")
+			path = main
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
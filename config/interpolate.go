@@ -0,0 +1,171 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// The values of the configuration can reference environment variables and
+// files, eg. for the credentials not to be written in the configuration:
+// - ${NAME} is the value of the environment variable NAME, which must be set
+// - ${NAME:-default} is the default when NAME is unset or empty
+// - ${file:<path>} is the content of a file, without its trailing newline,
+// eg. a secret mounted by Docker or Kubernetes
+// - $${ is a literal ${
+// The references are resolved when the configuration is loaded, which fails
+// if some of them cannot be. The string arguments of the plugins being split
+// at the spaces, the values holding spaces must be given to the plugins as
+// maps, eg. `webhook: {url: ..., secret: "${SECRET}"}`
+
+// redacted replaces the arguments holding the values of references in the
+// logs
+const redacted = "***"
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolator resolves the references of the values of a configuration
+type interpolator struct {
+	// values are the non-empty values of the environment variables and
+	// files referenced, to redact them
+	values []string
+	errs   []string
+}
+
+// interpolate resolves the references of all the values of the
+// configuration, and returns an error listing those which cannot be
+func (c *Config) interpolate() error {
+	var x interpolator
+	for _, key := range topKeys(c.v) {
+		val := c.v.Get(key)
+		if expanded := x.walk(val); !reflect.DeepEqual(expanded, val) {
+			c.v.Set(key, expanded)
+		}
+	}
+	c.secrets = x.values
+	if len(x.errs) > 0 {
+		return ConfigErrorFromString("cannot resolve %s", strings.Join(x.errs, ", "))
+	}
+	return nil
+}
+
+// walk returns a value with its references resolved, and those of the values
+// it holds, the maps and lists being copied
+func (x *interpolator) walk(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return x.expand(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = x.walk(v[i])
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k := range v {
+			out[k] = x.walk(v[k])
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(v))
+		for k := range v {
+			out[k] = x.walk(v[k])
+		}
+		return out
+	}
+	return val
+}
+
+// expand resolves the references of a string. The unterminated ones are left
+// as they are
+func (x *interpolator) expand(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1] + "${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		b.WriteString(s[:start])
+		b.WriteString(x.resolve(s[start+2 : start+end]))
+		s = s[start+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// resolve returns the value of a reference, recording the errors
+func (x *interpolator) resolve(ref string) string {
+	var val string
+	if path := strings.TrimPrefix(ref, "file:"); path != ref {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			x.errs = append(x.errs, fmt.Sprintf("${%s}: %v", ref, err))
+			return ""
+		}
+		val = strings.TrimRight(string(data), "\r\n")
+	} else {
+		name, def := ref, ""
+		hasDefault := false
+		if sep := strings.Index(ref, ":-"); sep >= 0 {
+			name, def, hasDefault = ref[:sep], ref[sep+2:], true
+		}
+		if !envName.MatchString(name) {
+			x.errs = append(x.errs, fmt.Sprintf("${%s}: invalid reference", ref))
+			return ""
+		}
+		var ok bool
+		switch val, ok = os.LookupEnv(name); {
+		case (!ok || val == "") && hasDefault:
+			// Written in the configuration, not redacted
+			return def
+		case !ok:
+			x.errs = append(x.errs, fmt.Sprintf("${%s}: environment variable not set", ref))
+			return ""
+		}
+	}
+	if val != "" {
+		x.values = append(x.values, val)
+	}
+	return val
+}
+
+// redactArgs returns the arguments of a plugin for the logs, the values of
+// those holding the value of a reference being redacted
+func (c *Config) redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = arg
+		for _, secret := range c.secrets {
+			if !strings.Contains(arg, secret) {
+				continue
+			}
+			if sep := strings.IndexByte(arg, '='); sep > 0 {
+				out[i] = arg[:sep+1] + redacted
+			} else {
+				out[i] = redacted
+			}
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("COREDHCP_TEST_TOKEN", "s3cr3t")
+	os.Setenv("COREDHCP_TEST_EMPTY", "")
+	defer os.Unsetenv("COREDHCP_TEST_TOKEN")
+	defer os.Unsetenv("COREDHCP_TEST_EMPTY")
+	secret, err := ioutil.TempFile("", "coredhcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secret.Name())
+	if _, err := secret.WriteString("hmac-sha256:key:c2VjcmV0\n"); err != nil {
+		t.Fatal(err)
+	}
+	secret.Close()
+
+	testcases := []struct {
+		in, out string
+		err     bool
+	}{
+		{"plain", "plain", false},
+		{"token=${COREDHCP_TEST_TOKEN}", "token=s3cr3t", false},
+		{"${COREDHCP_TEST_TOKEN}${COREDHCP_TEST_TOKEN}", "s3cr3ts3cr3t", false},
+		{"${COREDHCP_TEST_EMPTY}", "", false},
+		{"${COREDHCP_TEST_EMPTY:-default}", "default", false},
+		{"${COREDHCP_TEST_UNSET:-}", "", false},
+		{"key=${file:" + secret.Name() + "}", "key=hmac-sha256:key:c2VjcmV0", false},
+		{"price=$5 $${COREDHCP_TEST_TOKEN}", "price=$5 ${COREDHCP_TEST_TOKEN}", false},
+		{"${unterminated", "${unterminated", false},
+		{"${COREDHCP_TEST_UNSET}", "", true},
+		{"${file:/nonexistent}", "", true},
+		{"${not a name}", "", true},
+	}
+	for _, tc := range testcases {
+		var x interpolator
+		out := x.expand(tc.in)
+		if tc.err != (len(x.errs) > 0) {
+			t.Errorf("%q: unexpected errors %v", tc.in, x.errs)
+			continue
+		}
+		if !tc.err && out != tc.out {
+			t.Errorf("%q: expected %q, got %q", tc.in, tc.out, out)
+		}
+	}
+}
+
+func TestInterpolateConfig(t *testing.T) {
+	os.Setenv("COREDHCP_TEST_TOKEN", "s3cr3t")
+	os.Setenv("COREDHCP_TEST_IFACE", "eth1")
+	defer os.Unsetenv("COREDHCP_TEST_TOKEN")
+	defer os.Unsetenv("COREDHCP_TEST_IFACE")
+	conf := `
+server4:
+  listen: "%${COREDHCP_TEST_IFACE}"
+  plugins:
+    - webhook: url=https://cmdb.example.org secret=${COREDHCP_TEST_TOKEN}
+    - script: {path: policy.star, args: ["${COREDHCP_TEST_TOKEN}"]}
+  chains:
+    - listen: "%eth2"
+      plugins:
+        - status: token=${COREDHCP_TEST_TOKEN}
+`
+	c, err := Parse(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone := c.Server4.Addresses[0].Zone; zone != "eth1" {
+		t.Errorf("expected to listen on eth1, got %s", zone)
+	}
+	if args := c.Server4.Plugins[0].Args; len(args) != 2 || args[1] != "secret=s3cr3t" {
+		t.Errorf("unexpected webhook args %v", args)
+	}
+	if args, ok := c.Server4.Plugins[1].Settings["args"].([]interface{}); !ok || len(args) != 1 || args[0] != "s3cr3t" {
+		t.Errorf("unexpected script settings %v", c.Server4.Plugins[1].Settings)
+	}
+	if args := c.Server4.Chains[0].Plugins[0].Args; len(args) != 1 || args[0] != "token=s3cr3t" {
+		t.Errorf("unexpected status args %v", args)
+	}
+	if args := c.redactArgs([]string{"url=https://cmdb.example.org", "secret=s3cr3t", "s3cr3t"}); strings.Join(args, " ") != "url=https://cmdb.example.org secret=*** ***" {
+		t.Errorf("unexpected redacted args %v", args)
+	}
+
+	// All the missing references are reported
+	_, err = Parse(strings.NewReader("server4:\n  plugins:\n    - webhook: secret=${COREDHCP_TEST_UNSET1}\n    - status: token=${COREDHCP_TEST_UNSET2}\n"))
+	if err == nil || !strings.Contains(err.Error(), "COREDHCP_TEST_UNSET1") || !strings.Contains(err.Error(), "COREDHCP_TEST_UNSET2") {
+		t.Errorf("expected the missing references reported, got %v", err)
+	}
+}
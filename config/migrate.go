@@ -0,0 +1,307 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the current version of the configuration schema, given by the
+// `version` key of the configuration files. The files without it are of
+// version 1, which is still read: Migrate upgrades them.
+//
+// Version 2 replaces the deprecated `interface` key with `listen`. The
+// migration also gives the settings of the plugins taking key=value arguments
+// as maps, eg. `netbox: {url: ..., prefix: "42"}`: the plugins set up from
+// arguments get them sorted by key (see the plugins package)
+const Version = 2
+
+// migrations upgrade the configurations of a version to the next one,
+// migrations[0] upgrading version 1
+var migrations = []func(m *migration) error{
+	migrateV1,
+}
+
+// migration edits the lines of a configuration, rather than encoding its
+// tree again, to keep its comments and layout as they are
+type migration struct {
+	lines []string
+	root  *yaml.Node
+}
+
+// Migrate upgrades a configuration in YAML to the current version of the
+// schema. The configurations already at the current version are returned as
+// they are
+func Migrate(in []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, ConfigErrorFromString("the configuration is not a map")
+	}
+	m := &migration{
+		lines: strings.Split(string(in), "\n"),
+		root:  doc.Content[0],
+	}
+	version := 1
+	if _, v := mapEntry(m.root, "version"); v != nil {
+		var err error
+		if version, err = strconv.Atoi(v.Value); err != nil || version < 1 || version > Version {
+			return nil, ConfigErrorFromString("unsupported configuration version %q, expected 1 to %d", v.Value, Version)
+		}
+	}
+	if version == Version {
+		return in, nil
+	}
+	for ; version < Version; version++ {
+		if err := migrations[version-1](m); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.setVersion(); err != nil {
+		return nil, err
+	}
+	return []byte(strings.Join(m.lines, "\n")), nil
+}
+
+// MigrateFile upgrades a configuration file to the current version of the
+// schema in place, and writes the unified diff of the changes to w. It tells
+// whether the file changed
+func MigrateFile(path string, w io.Writer) (bool, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	out, err := Migrate(in)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(in, out) {
+		return false, nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(in)),
+		B:        difflib.SplitLines(string(out)),
+		FromFile: path,
+		ToFile:   path,
+		Context:  3,
+	})
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	// Replace the file at once, next to it to stay on its file system
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return false, err
+	}
+	_, err = io.WriteString(w, diff)
+	return true, err
+}
+
+// migrateV1 replaces the `interface` keys with `listen`, and the key=value
+// arguments of the plugins with maps
+func migrateV1(m *migration) error {
+	for _, server := range []string{"server4", "server6"} {
+		_, conf := mapEntry(m.root, server)
+		if conf == nil || conf.Kind != yaml.MappingNode {
+			continue
+		}
+		if key, iface := mapEntry(conf, "interface"); iface != nil {
+			if listen, _ := mapEntry(conf, "listen"); listen != nil {
+				return ConfigErrorFromString("%s: interface is a deprecated alias for listen, "+
+					"both cannot be used at the same time. Choose one and remove the other.", server)
+			}
+			if !m.replace(key, iface, "listen", scalar("%"+iface.Value)) {
+				return ConfigErrorFromString("%s: line %d: cannot replace interface with listen", server, key.Line)
+			}
+		}
+		_, plugins := mapEntry(conf, "plugins")
+		m.migratePlugins(plugins)
+		if _, chains := mapEntry(conf, "chains"); chains != nil && chains.Kind == yaml.SequenceNode {
+			for _, chain := range chains.Content {
+				if chain.Kind == yaml.MappingNode {
+					_, plugins := mapEntry(chain, "plugins")
+					m.migratePlugins(plugins)
+				}
+			}
+		}
+		if _, subChains := mapEntry(conf, "subchains"); subChains != nil && subChains.Kind == yaml.MappingNode {
+			for i := 1; i < len(subChains.Content); i += 2 {
+				m.migratePlugins(subChains.Content[i])
+			}
+		}
+	}
+	return nil
+}
+
+// migratePlugins gives the key=value arguments of the plugins of a list as
+// maps. The plugins taking other arguments are left as they are
+func (m *migration) migratePlugins(plugins *yaml.Node) {
+	if plugins == nil || plugins.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, p := range plugins.Content {
+		if p.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(p.Content); i += 2 {
+			key, val := p.Content[i], p.Content[i+1]
+			if key.Value == "on_match" || key.Value == "on_miss" || val.Kind != yaml.ScalarNode {
+				continue
+			}
+			if settings := argsMap(val.Value); settings != nil {
+				m.replace(key, val, key.Value, settings)
+			}
+		}
+	}
+}
+
+// argsMap returns the settings of key=value arguments as a flow map, the
+// repeated keys getting lists, or nil if some argument is not a key=value
+func argsMap(args string) *yaml.Node {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return nil
+	}
+	settings := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+	values := make(map[string]*yaml.Node, len(fields))
+	for _, arg := range fields {
+		sep := strings.IndexByte(arg, '=')
+		if sep <= 0 {
+			return nil
+		}
+		key, value := arg[:sep], scalar(arg[sep+1:])
+		switch prev := values[key]; {
+		case prev == nil:
+			values[key] = value
+			settings.Content = append(settings.Content, scalar(key), value)
+		case prev.Kind == yaml.SequenceNode:
+			prev.Content = append(prev.Content, value)
+		default:
+			// The first value becomes a list of the values
+			values[key] = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle, Content: []*yaml.Node{prev, value}}
+			for j := 1; j < len(settings.Content); j += 2 {
+				if settings.Content[j] == prev {
+					settings.Content[j] = values[key]
+				}
+			}
+		}
+	}
+	return settings
+}
+
+// scalar returns a string, quoted if it would not be read as a string
+// otherwise, eg. a number. The configuration is read as YAML 1.1, where yes,
+// no, on and off are booleans too
+func scalar(s string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	switch strings.ToLower(s) {
+	case "y", "yes", "n", "no", "on", "off":
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+// setVersion sets the version of the configuration, replacing the `version`
+// key or adding it before the first one
+func (m *migration) setVersion() error {
+	version := strconv.Itoa(Version)
+	if key, val := mapEntry(m.root, "version"); val != nil {
+		if !m.replace(key, val, "version", &yaml.Node{Kind: yaml.ScalarNode, Value: version}) {
+			return ConfigErrorFromString("line %d: cannot set the version", key.Line)
+		}
+		return nil
+	}
+	if len(m.root.Content) == 0 {
+		return ConfigErrorFromString("the configuration is empty")
+	}
+	// Above the comment of the first key, if any
+	first := m.root.Content[0]
+	at := first.Line - 1
+	added := []string{"version: " + version}
+	if first.HeadComment != "" {
+		at -= strings.Count(first.HeadComment, "\n") + 1
+		added = append(added, "")
+	}
+	if at < 0 || at > len(m.lines) {
+		return ConfigErrorFromString("line %d: cannot set the version", first.Line)
+	}
+	m.lines = append(m.lines[:at], append(added, m.lines[at:]...)...)
+	return nil
+}
+
+// replace replaces an entry of a map with a key and value, on the line of the
+// entry, keeping its comment. The entries whose value does not fit on the
+// line of its key, eg. multi-line strings, cannot be replaced
+func (m *migration) replace(key, val *yaml.Node, newKey string, newVal *yaml.Node) bool {
+	if val.Kind != yaml.ScalarNode || val.Line != key.Line || key.Line > len(m.lines) {
+		return false
+	}
+	line := []rune(m.lines[key.Line-1])
+	if val.Column > len(line) {
+		return false
+	}
+	rest := string(line[val.Column-1:])
+	if val.LineComment != "" {
+		rest = strings.TrimSpace(strings.TrimSuffix(rest, val.LineComment))
+	}
+	// The rest of the line must be the whole value
+	var check map[string]string
+	if err := yaml.Unmarshal([]byte("v: "+rest), &check); err != nil || check["v"] != val.Value {
+		return false
+	}
+	out, err := yaml.Marshal(newVal)
+	if err != nil {
+		return false
+	}
+	replaced := string(line[:key.Column-1]) + newKey + ": " + strings.TrimSuffix(string(out), "\n")
+	if val.LineComment != "" {
+		replaced += " " + val.LineComment
+	}
+	m.lines[key.Line-1] = replaced
+	return true
+}
+
+// mapEntry returns the key and value of an entry of a map node, nil if there
+// is none
+func mapEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
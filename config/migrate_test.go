@@ -0,0 +1,121 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const configV1 = `# Site configuration
+server4:
+    interface: eth0 # the LAN
+    plugins:
+        - server_id: 10.0.0.1
+        # key=value arguments
+        - netbox: url=https://netbox.example.org prefix=42 token-file=/etc/netbox.token
+        - range: leases.txt 10.0.0.10 10.0.0.99 1h
+        - options: opt=1 opt=2 flag=yes
+          on_match: continue
+    subchains:
+        pxe:
+            - script: path=policy.star timeout=1s
+`
+
+const configV2 = `version: 2
+
+# Site configuration
+server4:
+    listen: '%eth0' # the LAN
+    plugins:
+        - server_id: 10.0.0.1
+        # key=value arguments
+        - netbox: {url: 'https://netbox.example.org', prefix: "42", token-file: /etc/netbox.token}
+        - range: leases.txt 10.0.0.10 10.0.0.99 1h
+        - options: {opt: ["1", "2"], flag: "yes"}
+          on_match: continue
+    subchains:
+        pxe:
+            - script: {path: policy.star, timeout: 1s}
+`
+
+func TestMigrate(t *testing.T) {
+	out, err := Migrate([]byte(configV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != configV2 {
+		t.Errorf("unexpected migration:\n%s", out)
+	}
+	c, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != Version || c.Server4.Addresses[0].Zone != "eth0" {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+	options := c.Server4.Plugins[3]
+	if opts, ok := options.Settings["opt"].([]interface{}); !ok || len(opts) != 2 || options.Settings["flag"] != "yes" {
+		t.Errorf("unexpected options settings %v", options.Settings)
+	}
+	if options.OnMatch == nil || options.OnMatch.Kind != ActionContinue {
+		t.Errorf("unexpected on_match %v", options.OnMatch)
+	}
+
+	// Up to date
+	if out, err = Migrate([]byte(configV2)); err != nil || string(out) != configV2 {
+		t.Errorf("expected the configuration unchanged, got %v:\n%s", err, out)
+	}
+	// An existing version is replaced
+	if out, err = Migrate([]byte("version: 1\nserver4:\n  interface: eth0\n")); err != nil || string(out) != "version: 2\nserver4:\n  listen: '%eth0'\n" {
+		t.Errorf("unexpected migration %v:\n%s", err, out)
+	}
+	for _, conf := range []string{
+		"version: 3\nserver4: {}\n",
+		"server4:\n  interface: eth0\n  listen: eth1\n",
+		"- server4\n",
+	} {
+		if _, err := Migrate([]byte(conf)); err == nil {
+			t.Errorf("%q: expected an error", conf)
+		}
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(configV1), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var diff bytes.Buffer
+	changed, err := MigrateFile(path, &diff)
+	if err != nil || !changed {
+		t.Fatalf("expected the file migrated, got %t, %v", changed, err)
+	}
+	if !strings.Contains(diff.String(), "-    interface: eth0 # the LAN\n+    listen: '%eth0' # the LAN\n") {
+		t.Errorf("unexpected diff:\n%s", diff.String())
+	}
+	out, err := ioutil.ReadFile(path)
+	if err != nil || string(out) != configV2 {
+		t.Errorf("unexpected file %v:\n%s", err, out)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("expected the mode of the file kept, got %v", info.Mode())
+	}
+
+	diff.Reset()
+	if changed, err = MigrateFile(path, &diff); err != nil || changed || diff.Len() != 0 {
+		t.Errorf("expected the file up to date, got %t, %v", changed, err)
+	}
+}
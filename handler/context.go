@@ -0,0 +1,223 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"context"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/metrics"
+)
+
+// Metadata holds the data plugins compute about a request (eg. the class of
+// the client, the subnet an address was allocated from) for the plugins
+// handling it after them, instead of keeping it in globals. A request is
+// handled by one plugin at a time, so Metadata has no locking.
+// The keys are namespaced by the plugin setting them, eg. "range.subnet", and
+// the plugins document the type of their values. The zero value is empty, and
+// the methods of a nil Metadata, that of a context without one, do nothing
+type Metadata struct {
+	values map[string]interface{}
+}
+
+// Get returns the value of a key, and whether it is set
+func (m *Metadata) Get(key string) (interface{}, bool) {
+	if m == nil {
+		return nil, false
+	}
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Set sets the value of a key
+func (m *Metadata) Set(key string, value interface{}) {
+	if m == nil {
+		return
+	}
+	if m.values == nil {
+		m.values = make(map[string]interface{})
+	}
+	m.values[key] = value
+}
+
+// Range calls f for each key and value, in no particular order, until it
+// returns false
+func (m *Metadata) Range(f func(key string, value interface{}) bool) {
+	if m == nil {
+		return
+	}
+	for k, v := range m.values {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// requestContext is the context of a request, carrying its Metadata with a
+// single allocation, and what the core tracks of the plugin handling it
+type requestContext struct {
+	context.Context
+	md      Metadata
+	metrics *metrics.Registry
+	err     error
+	// raw is the request as received, if known
+	raw []byte
+	// finish4 and finish6 are the functions finalizing the reply
+	finish4 []func(resp *dhcpv4.DHCPv4)
+	finish6 []func(resp dhcpv6.DHCPv6)
+	// decisions are how the plugins handled the request so far
+	decisions []Decision
+	// reason is why the plugin handling the request refused it, refusal
+	// and refusedBy the last reason given and by which plugin (see Refuse)
+	reason, refusal, refusedBy string
+}
+
+// Decision is how a plugin handled a request
+type Decision struct {
+	Plugin string `json:"plugin"`
+	// Outcome is served, dropped or passed, as counted in the metrics of
+	// the plugin (see package metrics)
+	Outcome string `json:"outcome"`
+	// Reason is why the plugin NAKed or dropped the request, if it told
+	// (see Refuse)
+	Reason string `json:"reason,omitempty"`
+}
+
+type (
+	metadataKey struct{}
+	requestKey  struct{}
+)
+
+func (c *requestContext) Value(key interface{}) interface{} {
+	switch key {
+	case metadataKey{}:
+		return &c.md
+	case requestKey{}:
+		return c
+	case metrics.ContextKey:
+		if c.metrics != nil {
+			return c.metrics
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// NewContext returns the context of a new request, with empty Metadata
+func NewContext(parent context.Context) context.Context {
+	return &requestContext{Context: parent}
+}
+
+// MetadataFromContext returns the Metadata of a request, nil if the context
+// has none
+func MetadataFromContext(ctx context.Context) *Metadata {
+	md, _ := ctx.Value(metadataKey{}).(*Metadata)
+	return md
+}
+
+// ReportError reports that the plugin handling a request failed to, for the
+// core to count the errors of each plugin (see package metrics). The handler
+// still returns what to do with the request, eg. drop it
+func ReportError(ctx context.Context, err error) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.err = err
+	}
+}
+
+// EnterPlugin is called by the core before a plugin handles a request, to
+// give it its Registry (see metrics.FromContext)
+func EnterPlugin(ctx context.Context, r *metrics.Registry) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.metrics, c.err, c.reason = r, nil, ""
+	}
+}
+
+// LeavePlugin is called by the core once a plugin handled a request. It
+// returns the error the plugin reported, if any
+func LeavePlugin(ctx context.Context) error {
+	c, ok := ctx.Value(requestKey{}).(*requestContext)
+	if !ok {
+		return nil
+	}
+	err := c.err
+	c.metrics, c.err = nil, nil
+	return err
+}
+
+// RecordDecision is called by the core once a plugin handled a request, with
+// its outcome
+func RecordDecision(ctx context.Context, plugin, outcome string) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.decisions = append(c.decisions, Decision{Plugin: plugin, Outcome: outcome, Reason: c.reason})
+		if c.reason != "" {
+			c.refusedBy, c.reason = plugin, ""
+		}
+	}
+}
+
+// Decisions returns how the plugins handled a request so far, in order, eg.
+// for auditing. It must not be modified
+func Decisions(ctx context.Context) []Decision {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		return c.decisions
+	}
+	return nil
+}
+
+// OnReply4 registers a function finalizing the DHCPv4 reply to a request, once
+// all the plugins handled it and the core checked it, right before it is
+// sent: eg. to sign it. The functions run in the order they were registered
+func OnReply4(ctx context.Context, f func(resp *dhcpv4.DHCPv4)) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.finish4 = append(c.finish4, f)
+	}
+}
+
+// FinishReply4 is called by the core on the DHCPv4 reply to a request, to
+// run the functions registered with OnReply4
+func FinishReply4(ctx context.Context, resp *dhcpv4.DHCPv4) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		for _, f := range c.finish4 {
+			f(resp)
+		}
+	}
+}
+
+// OnReply6 behaves like OnReply4, for the DHCPv6 reply to a request, before
+// it is encapsulated for the relays
+func OnReply6(ctx context.Context, f func(resp dhcpv6.DHCPv6)) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.finish6 = append(c.finish6, f)
+	}
+}
+
+// FinishReply6 is called by the core on the DHCPv6 reply to a request, to
+// run the functions registered with OnReply6
+func FinishReply6(ctx context.Context, resp dhcpv6.DHCPv6) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		for _, f := range c.finish6 {
+			f(resp)
+		}
+	}
+}
+
+// SetRawRequest is called by the core with the request as received, before
+// the plugins handle it
+func SetRawRequest(ctx context.Context, raw []byte) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.raw = raw
+	}
+}
+
+// RawRequest returns the request as received, eg. to authenticate it, nil if
+// unknown (eg. a DHCPv4 request received over DHCPv6). It is only valid while
+// the request is being handled, and must not be modified
+func RawRequest(ctx context.Context) []byte {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		return c.raw
+	}
+	return nil
+}
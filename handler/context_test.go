@@ -0,0 +1,137 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/metrics"
+)
+
+type testKey struct{}
+
+func TestMetadata(t *testing.T) {
+	parent := context.WithValue(context.Background(), testKey{}, "parent")
+	ctx := NewContext(parent)
+	if v := ctx.Value(testKey{}); v != "parent" {
+		t.Errorf("value of the parent context lost, got %v", v)
+	}
+
+	md := MetadataFromContext(ctx)
+	if _, ok := md.Get("test.class"); ok {
+		t.Error("metadata of a new request should be empty")
+	}
+	md.Set("test.class", "vendor:PXEClient")
+
+	// Derived contexts share the metadata of the request
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if v, _ := MetadataFromContext(child).Get("test.class"); v != "vendor:PXEClient" {
+		t.Errorf("expected vendor:PXEClient from a derived context, got %v", v)
+	}
+
+	// Other requests have their own
+	if _, ok := MetadataFromContext(NewContext(parent)).Get("test.class"); ok {
+		t.Error("metadata shared between requests")
+	}
+
+	// Without metadata, nothing is kept
+	none := MetadataFromContext(context.Background())
+	if none != nil {
+		t.Fatal("metadata in a context without")
+	}
+	none.Set("test.class", "vendor:PXEClient")
+	if _, ok := none.Get("test.class"); ok {
+		t.Error("metadata kept without a request context")
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := NewContext(context.Background())
+	r := metrics.Get("test-handler")
+	EnterPlugin(ctx, r)
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if got := metrics.FromContext(child); got != r {
+		t.Errorf("expected the registry of the plugin, got %v", got)
+	}
+	ReportError(child, errors.New("failed"))
+	if err := LeavePlugin(ctx); err == nil || err.Error() != "failed" {
+		t.Errorf("expected the reported error, got %v", err)
+	}
+	if got := metrics.FromContext(ctx); got != nil {
+		t.Errorf("registry kept after the plugin, got %v", got)
+	}
+	EnterPlugin(ctx, r)
+	if err := LeavePlugin(ctx); err != nil {
+		t.Errorf("error of the previous plugin kept, got %v", err)
+	}
+
+	// Without a request, nothing is kept
+	ReportError(context.Background(), errors.New("failed"))
+	if err := LeavePlugin(context.Background()); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestRefuse(t *testing.T) {
+	ctx := NewContext(context.Background())
+	EnterPlugin(ctx, nil)
+	Refuse(ctx, ReasonPoolExhausted)
+	LeavePlugin(ctx)
+	RecordDecision(ctx, "range", "dropped")
+	EnterPlugin(ctx, nil)
+	LeavePlugin(ctx)
+	RecordDecision(ctx, "dns", "passed")
+	want := []Decision{{Plugin: "range", Outcome: "dropped", Reason: ReasonPoolExhausted}, {Plugin: "dns", Outcome: "passed"}}
+	if got := Decisions(ctx); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected decisions %v, got %v", want, got)
+	}
+	if reason, plugin := Refusal(ctx); reason != ReasonPoolExhausted || plugin != "range" {
+		t.Errorf("expected a refusal by range, got %q by %q", reason, plugin)
+	}
+
+	// Refused by the core, once the plugins handled the request
+	Refuse(ctx, ReasonNotLeased)
+	if reason, plugin := Refusal(ctx); reason != ReasonNotLeased || plugin != "" {
+		t.Errorf("expected a refusal by the core, got %q by %q", reason, plugin)
+	}
+
+	Refuse(context.Background(), ReasonNotLeased)
+	if reason, _ := Refusal(context.Background()); reason != "" {
+		t.Errorf("refusal kept without a request context, got %q", reason)
+	}
+}
+
+func TestOnReply4(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var order []int
+	OnReply4(ctx, func(resp *dhcpv4.DHCPv4) { order = append(order, 1) })
+	OnReply4(ctx, func(resp *dhcpv4.DHCPv4) { order = append(order, 2) })
+	FinishReply4(ctx, &dhcpv4.DHCPv4{})
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Errorf("expected the functions to run in order, got %v", order)
+	}
+	// Without a request, nothing is registered
+	OnReply4(context.Background(), func(resp *dhcpv4.DHCPv4) { t.Error("unexpected call") })
+	FinishReply4(context.Background(), &dhcpv4.DHCPv4{})
+}
+
+func TestOnReply6(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var called bool
+	OnReply6(ctx, func(resp dhcpv6.DHCPv6) { called = true })
+	FinishReply6(ctx, &dhcpv6.Message{})
+	if !called {
+		t.Error("expected the function to run")
+	}
+	OnReply6(context.Background(), func(resp dhcpv6.DHCPv6) { t.Error("unexpected call") })
+	FinishReply6(context.Background(), &dhcpv6.Message{})
+}
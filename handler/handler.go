@@ -5,6 +5,8 @@
 package handler
 
 import (
+	"context"
+
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -21,3 +23,25 @@ type Handler6 func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool)
 
 // Handler4 behaves like Handler6, but for DHCPv4 packets.
 type Handler4 func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
+
+// Handler6Ctx behaves like Handler6, with the context of the request, which
+// carries its Metadata (see NewContext). Plugins set up with a Handler6Ctx
+// use it to share data with the other plugins handling the request.
+type Handler6Ctx func(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool)
+
+// Handler4Ctx behaves like Handler6Ctx, but for DHCPv4 packets.
+type Handler4Ctx func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool)
+
+// Adapt6 returns a Handler6Ctx running a handler without context
+func Adapt6(h Handler6) Handler6Ctx {
+	return func(_ context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		return h(req, resp)
+	}
+}
+
+// Adapt4 returns a Handler4Ctx running a handler without context
+func Adapt4(h Handler4) Handler4Ctx {
+	return func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		return h(req, resp)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import "context"
+
+// The reasons a request is NAKed or dropped, machine-readable for the
+// operators: they are logged, counted (see metrics.Refusals), and recorded
+// with the decision of the plugin refusing the request
+const (
+	// ReasonPoolExhausted: no address is left to allocate
+	ReasonPoolExhausted = "pool-exhausted"
+	// ReasonClassMismatch: the client matches the class of no range, or
+	// pool, which may serve it
+	ReasonClassMismatch = "class-mismatch"
+	// ReasonWrongNetwork: the address the client asks for is not on its
+	// network, eg. it moved to another link
+	ReasonWrongNetwork = "wrong-network"
+	// ReasonNotLeased: the address the client asks for is not leased to it
+	ReasonNotLeased = "not-leased"
+	// ReasonNoAddress: no plugin gave the client an address
+	ReasonNoAddress = "no-address"
+	// ReasonLeaseConflict: the lease is bound to another client identity
+	ReasonLeaseConflict = "lease-conflict"
+	// ReasonBlockedMAC: the hardware address of the client is blocked
+	ReasonBlockedMAC = "blocked-mac"
+	// ReasonBlockedPort: the relay agent port of the client is blocked
+	ReasonBlockedPort = "blocked-port"
+	// ReasonPortFull: the relay agent port of the client has too many
+	// clients
+	ReasonPortFull = "port-full"
+	// ReasonUnauthenticated: the request failed to authenticate
+	ReasonUnauthenticated = "unauthenticated"
+)
+
+// Refuse tells why the plugin handling a request NAKs or drops it, one of the
+// Reason constants, or why the core does once the plugins handled it. The
+// handler still returns the NAK, or nil
+func Refuse(ctx context.Context, reason string) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		c.reason, c.refusal, c.refusedBy = reason, reason, ""
+	}
+}
+
+// Refusal returns why a request was last refused, and by which plugin, ""
+// for the core. The reason is empty if none was given
+func Refusal(ctx context.Context) (reason, plugin string) {
+	if c, ok := ctx.Value(requestKey{}).(*requestContext); ok {
+		return c.refusal, c.refusedBy
+	}
+	return "", ""
+}
@@ -0,0 +1,62 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"bytes"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// StaticOptions4 holds DHCPv4 options which do not depend on the request, eg.
+// the DNS servers, encoded once when a plugin is set up. They are spliced
+// into the replies as they are, instead of being encoded for each request:
+// the replies share the encoded values, which must not be modified.
+// The zero value holds no options
+type StaticOptions4 struct {
+	options []staticOption4
+}
+
+type staticOption4 struct {
+	code  uint8
+	value []byte
+	// requested restricts the option to the clients requesting it
+	requested bool
+}
+
+// Add encodes an option sent to every client
+func (s *StaticOptions4) Add(opt dhcpv4.Option) {
+	s.add(opt, false)
+}
+
+// AddRequested encodes an option sent to the clients requesting it, as
+// IsOptionRequested tells: the ones listing it in their parameter request
+// list, or sending no such list
+func (s *StaticOptions4) AddRequested(opt dhcpv4.Option) {
+	s.add(opt, true)
+}
+
+func (s *StaticOptions4) add(opt dhcpv4.Option, requested bool) {
+	o := staticOption4{code: opt.Code.Code(), value: opt.Value.ToBytes(), requested: requested}
+	for i := range s.options {
+		if s.options[i].code == o.code {
+			s.options[i] = o
+			return
+		}
+	}
+	s.options = append(s.options, o)
+}
+
+// Splice sets the options in the reply to a request, replacing the ones of
+// the same codes
+func (s *StaticOptions4) Splice(req, resp *dhcpv4.DHCPv4) {
+	requested, listed := req.Options[dhcpv4.OptionParameterRequestList.Code()]
+	for _, o := range s.options {
+		if o.requested && listed && bytes.IndexByte(requested, o.code) < 0 {
+			continue
+		}
+		resp.Options[o.code] = o.value
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package handler
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestStaticOptions4(t *testing.T) {
+	var s StaticOptions4
+	s.Add(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 1)))
+	s.AddRequested(dhcpv4.OptDNS(net.IPv4(10, 0, 0, 2)))
+	// Added again, replacing the first value
+	s.Add(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 254)))
+
+	for _, tc := range []struct {
+		name      string
+		modifiers []dhcpv4.Modifier
+		dns       bool
+	}{
+		{"no parameter request list", nil, true},
+		{"DNS requested", []dhcpv4.Modifier{dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer)}, true},
+		{"DNS not requested", []dhcpv4.Modifier{dhcpv4.WithRequestedOptions(dhcpv4.OptionRouter)}, false},
+	} {
+		req, err := dhcpv4.New(tc.modifiers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Splice(req, resp)
+		if routers := resp.Router(); len(routers) != 1 || !routers[0].Equal(net.IPv4(10, 0, 0, 254)) {
+			t.Errorf("%s: got routers %v, want 10.0.0.254", tc.name, routers)
+		}
+		if got := resp.Options.Has(dhcpv4.OptionDomainNameServer); got != tc.dns {
+			t.Errorf("%s: got DNS option %v, want %v", tc.name, got, tc.dns)
+		}
+		if !bytes.Equal(resp.Options.Get(dhcpv4.OptionRouter), dhcpv4.OptRouter(net.IPv4(10, 0, 0, 254)).Value.ToBytes()) {
+			t.Errorf("%s: router option is not the encoded one", tc.name)
+		}
+	}
+}
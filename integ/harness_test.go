@@ -0,0 +1,284 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build integration
+
+package e2e_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netns"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/server"
+
+	// Plugins
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/netmask"
+	"github.com/coredhcp/coredhcp/plugins/pxe"
+	rangeplugin "github.com/coredhcp/coredhcp/plugins/range"
+	"github.com/coredhcp/coredhcp/plugins/router"
+	"github.com/coredhcp/coredhcp/plugins/serverid"
+)
+
+// The tests run the server and the clients in network namespaces, linked by
+// veth pairs, with two topologies:
+//
+// * 3 netns, for relay operations
+//  --------------------------------
+// | server (cdhcp_srv  <---------) | Upper netns
+//  -----------------------------|--
+//                               | (veth pair)
+//  -----------------------------|---
+// | relay upper (cdhcp_relay_u <-) |
+// |                                | Relay netns
+// | relay lower (cdhcp_relay_d <-) |
+//  -----------------------------|--
+//                               | (veth pair)
+// ------------------------------|--
+// |  client (cdhcp_cli <---------) | Lower netns
+// ---------------------------------
+//
+// * 2 netns, for direct operations: the same without the middle layer
+//
+// TestMain creates them, which requires CAP_NET_ADMIN and iproute2, and
+// deletes them at the end. Each test starts its servers, as processes of the
+// test binary run in their namespaces (see startServer), and its clients and
+// relay agents (see relay_test.go), in the test process, their sockets being
+// opened in their namespaces.
+
+// Interface names are limited to 15 chars (IFNAMSIZ=16)
+const (
+	ifServer    = "cdhcp_srv"
+	ifRelayUp   = "cdhcp_relay_u"
+	ifRelayDown = "cdhcp_relay_d"
+	ifClient    = "cdhcp_cli"
+
+	nsServer = "coredhcp-upper"
+	nsRelay  = "coredhcp-middle"
+	nsClient = "coredhcp-lower"
+
+	nsDirectServer = "coredhcp-direct-upper"
+	nsDirectClient = "coredhcp-direct-lower"
+
+	ulaPrefix = "fd4f:6b37:542c:b643"
+)
+
+var allNS = []string{nsServer, nsRelay, nsClient, nsDirectServer, nsDirectClient}
+
+// clientMAC is the MAC address of the client interfaces, matching the
+// leases files
+var clientMAC = "de:ad:be:ef:00:00"
+
+// serverConfigEnv holds the configuration file of a server process
+const serverConfigEnv = "COREDHCP_INTEG_CONFIG"
+
+func TestMain(m *testing.M) {
+	for _, pl := range []*plugins.Plugin{
+		&file.Plugin, &netmask.Plugin, &pxe.Plugin, &rangeplugin.Plugin, &router.Plugin, &serverid.Plugin,
+	} {
+		if err := plugins.RegisterPlugin(pl); err != nil {
+			log.Panicf("Failed to register plugin `%s`: %v", pl.Name, err)
+		}
+	}
+	if path := os.Getenv(serverConfigEnv); path != "" {
+		os.Exit(runServer(path))
+	}
+	teardownTopology()
+	if err := setupTopology(); err != nil {
+		teardownTopology()
+		log.Fatalf("Could not set up the namespaces: %v", err)
+	}
+	code := m.Run()
+	teardownTopology()
+	os.Exit(code)
+}
+
+// runServer runs a server process, until it is stopped by SIGTERM
+func runServer(path string) int {
+	conf, err := config.Load(path)
+	if err != nil {
+		log.Printf("Failed to load configuration: %v", err)
+		return 1
+	}
+	srv, err := server.Start(conf)
+	if err != nil {
+		log.Printf("Server could not start: %v", err)
+		return 1
+	}
+	if err := srv.Wait(); err != nil {
+		log.Printf("Server errored during run: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// ip runs an iproute2 command in a namespace
+func ip(nsName string, args ...string) error {
+	out, err := exec.Command("ip", append([]string{"-n", nsName}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip -n %s %s: %v: %s", nsName, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+func setupTopology() error {
+	for _, nsName := range allNS {
+		if out, err := exec.Command("ip", "netns", "add", nsName).CombinedOutput(); err != nil {
+			return fmt.Errorf("ip netns add %s: %v: %s", nsName, err, out)
+		}
+		// Duplicate address detection would delay the use of the addresses
+		if err := inNS(nsName, func() error {
+			return ioutil.WriteFile("/proc/sys/net/ipv6/conf/default/accept_dad", []byte("0"), 0644)
+		}); err != nil {
+			return err
+		}
+	}
+	cmds := []struct {
+		ns   string
+		args string
+	}{
+		// Create the links in one of the relevant netns, to ensure we don't
+		// pollute the main netns
+		{nsClient, "link add " + ifClient + " address " + clientMAC + " type veth peer name " + ifRelayDown},
+		{nsClient, "link set " + ifRelayDown + " netns " + nsRelay},
+		{nsServer, "link add " + ifServer + " type veth peer name " + ifRelayUp},
+		{nsServer, "link set " + ifRelayUp + " netns " + nsRelay},
+
+		{nsServer, "addr add " + ulaPrefix + ":a::1/80 dev " + ifServer},
+		{nsServer, "addr add 10.0.1.1/24 dev " + ifServer},
+		{nsServer, "link set " + ifServer + " up"},
+		// The replies are sent to the relay agent address on the client side
+		{nsServer, "route add 10.0.2.0/24 via 10.0.1.2"},
+		{nsServer, "route add " + ulaPrefix + ":b::/80 via " + ulaPrefix + ":a::2"},
+
+		{nsClient, "addr add " + ulaPrefix + ":b::1/80 dev " + ifClient},
+		{nsClient, "link set " + ifClient + " up"},
+
+		{nsRelay, "addr add " + ulaPrefix + ":b::2/80 dev " + ifRelayDown},
+		{nsRelay, "addr add " + ulaPrefix + ":a::2/80 dev " + ifRelayUp},
+		{nsRelay, "addr add 10.0.2.2/24 dev " + ifRelayDown},
+		{nsRelay, "addr add 10.0.1.2/24 dev " + ifRelayUp},
+		{nsRelay, "link set " + ifRelayDown + " up"},
+		{nsRelay, "link set " + ifRelayUp + " up"},
+
+		// Now setup the direct-attach ns (with the same addresses as in the
+		// relay scenario); with a larger subnet so they can link
+		{nsDirectClient, "link add " + ifClient + " address " + clientMAC + " type veth peer name " + ifServer},
+		{nsDirectClient, "link set " + ifServer + " netns " + nsDirectServer},
+
+		{nsDirectServer, "addr add " + ulaPrefix + ":a::1/64 dev " + ifServer},
+		{nsDirectServer, "addr add 10.0.1.1/16 dev " + ifServer},
+		{nsDirectServer, "link set " + ifServer + " up"},
+
+		{nsDirectClient, "addr add " + ulaPrefix + ":b::1/64 dev " + ifClient},
+		{nsDirectClient, "link set " + ifClient + " up"},
+	}
+	for _, c := range cmds {
+		if err := ip(c.ns, strings.Fields(c.args)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func teardownTopology() {
+	for _, nsName := range allNS {
+		// The veth pairs go with their namespaces
+		_ = exec.Command("ip", "netns", "delete", nsName).Run()
+	}
+}
+
+// inNS runs f in the namespace nsName. The sockets f opens stay in it.
+// Errors in switching back to the original namespace will panic
+func inNS(nsName string, f func() error) error {
+	runtime.LockOSThread()
+	backupNS, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not save handle to original NS: %v", err)
+	}
+	defer backupNS.Close()
+	ns, err := netns.GetFromName(nsName)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("netns `%s` not set up: %v", nsName, err)
+	}
+	defer ns.Close()
+	if err := netns.Set(ns); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to switch to netns `%s`: %v", nsName, err)
+	}
+	fErr := f()
+	if err := netns.Set(backupNS); err != nil {
+		// The thread stays locked, so that no other goroutine runs in the
+		// wrong namespace
+		panic(fmt.Sprintf("couldn't switch back to original NS: %v", err))
+	}
+	runtime.UnlockOSThread()
+	return fErr
+}
+
+// startServer starts a server process in a namespace, with a configuration
+// written in dir. It returns once the server is ready, the returned function
+// stops it. The servers run in their own processes so that the state of the
+// plugins doesn't leak from a test to the next one, and that all their
+// sockets are in their namespace
+func startServer(t *testing.T, nsName, dir, conf string) func() {
+	t.Helper()
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server tells it is ready as it does to a process it upgrades,
+	// see server/upgrade.go
+	ready, notify, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ready.Close()
+	cmd := exec.Command("ip", "netns", "exec", nsName, self)
+	cmd.Env = append(os.Environ(), serverConfigEnv+"="+path, "COREDHCP_READY_FD=3")
+	cmd.ExtraFiles = []*os.File{notify}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Start()
+	notify.Close()
+	if err != nil {
+		t.Fatalf("Server could not start: %v", err)
+	}
+	// The pipe is closed without a byte if the server exits
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		_ = cmd.Wait()
+		t.Fatal("Server could not start")
+	}
+	return func() {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		_ = cmd.Wait()
+	}
+}
+
+// tempDir returns a temporary directory, removed by the returned function
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "coredhcp-integ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
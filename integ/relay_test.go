@@ -0,0 +1,135 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build integration
+
+package e2e_test
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/dhcpv6/server6"
+	"golang.org/x/net/ipv6"
+)
+
+// relay is a minimal relay agent in the relay namespace, passing the
+// messages of the clients on the lower interface to the server, and the
+// replies back
+type relay struct {
+	down, up *net.UDPConn
+}
+
+// Close stops the relay agent
+func (r *relay) Close() {
+	r.down.Close()
+	r.up.Close()
+}
+
+// forward passes the messages read from one side to the other, rewritten by
+// f, which returns a nil destination to drop them
+func forward(from, to *net.UDPConn, f func(msg []byte, peer *net.UDPAddr) ([]byte, *net.UDPAddr)) {
+	buf := make([]byte, 1<<16)
+	for {
+		n, peer, err := from.ReadFromUDP(buf)
+		if err != nil {
+			// Closed
+			return
+		}
+		if msg, dest := f(buf[:n], peer); dest != nil {
+			_, _ = to.WriteToUDP(msg, dest)
+		}
+	}
+}
+
+// startRelay4 starts a DHCPv4 relay agent (RFC1542) for the server at
+// server, with the address of its lower interface as giaddr
+func startRelay4(server net.IP) (*relay, error) {
+	var r relay
+	err := inNS(nsRelay, func() (err error) {
+		if r.down, err = server4.NewIPv4UDPConn(ifRelayDown, &net.UDPAddr{Port: dhcpv4.ServerPort}); err != nil {
+			return err
+		}
+		if r.up, err = server4.NewIPv4UDPConn(ifRelayUp, &net.UDPAddr{Port: dhcpv4.ServerPort}); err != nil {
+			r.down.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	giaddr := net.IPv4(10, 0, 2, 2)
+	go forward(r.down, r.up, func(msg []byte, _ *net.UDPAddr) ([]byte, *net.UDPAddr) {
+		d, err := dhcpv4.FromBytes(msg)
+		if err != nil || d.OpCode != dhcpv4.OpcodeBootRequest {
+			return nil, nil
+		}
+		if d.GatewayIPAddr.IsUnspecified() {
+			d.GatewayIPAddr = giaddr
+		}
+		d.HopCount++
+		return d.ToBytes(), &net.UDPAddr{IP: server, Port: dhcpv4.ServerPort}
+	})
+	go forward(r.up, r.down, func(msg []byte, _ *net.UDPAddr) ([]byte, *net.UDPAddr) {
+		d, err := dhcpv4.FromBytes(msg)
+		if err != nil || d.OpCode != dhcpv4.OpcodeBootReply {
+			return nil, nil
+		}
+		// Broadcast, rather than sent to the client hardware address:
+		// the test clients don't mind
+		return msg, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	})
+	return &r, nil
+}
+
+// startRelay6 starts a DHCPv6 relay agent (RFC8415 §19) for the server at
+// server, with the address of its lower interface as link-address
+func startRelay6(server net.IP) (*relay, error) {
+	var r relay
+	err := inNS(nsRelay, func() (err error) {
+		if r.down, err = server6.NewIPv6UDPConn(ifRelayDown, &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultServerPort}); err != nil {
+			return err
+		}
+		ifi, err := net.InterfaceByName(ifRelayDown)
+		if err == nil {
+			err = ipv6.NewPacketConn(r.down).JoinGroup(ifi, &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers})
+		}
+		if err == nil {
+			r.up, err = server6.NewIPv6UDPConn(ifRelayUp, &net.UDPAddr{IP: net.IPv6unspecified, Port: dhcpv6.DefaultServerPort})
+		}
+		if err != nil {
+			r.down.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	linkAddr := net.ParseIP(ulaPrefix + ":b::2")
+	go forward(r.down, r.up, func(msg []byte, peer *net.UDPAddr) ([]byte, *net.UDPAddr) {
+		d, err := dhcpv6.FromBytes(msg)
+		if err != nil {
+			return nil, nil
+		}
+		relayed, err := dhcpv6.EncapsulateRelay(d, dhcpv6.MessageTypeRelayForward, linkAddr, peer.IP)
+		if err != nil {
+			return nil, nil
+		}
+		return relayed.ToBytes(), &net.UDPAddr{IP: server, Port: dhcpv6.DefaultServerPort}
+	})
+	go forward(r.up, r.down, func(msg []byte, _ *net.UDPAddr) ([]byte, *net.UDPAddr) {
+		rm, err := dhcpv6.RelayMessageFromBytes(msg)
+		if err != nil || rm.MessageType != dhcpv6.MessageTypeRelayReply {
+			return nil, nil
+		}
+		inner := rm.Options.RelayMessage()
+		if inner == nil {
+			return nil, nil
+		}
+		return inner.ToBytes(), &net.UDPAddr{IP: rm.PeerAddr, Port: dhcpv6.DefaultClientPort, Zone: ifRelayDown}
+	})
+	return &r, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build integration
+
+package e2e_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+	"github.com/stretchr/testify/require"
+)
+
+// runClient4 runs a DORA exchange from the client interface of a namespace
+func runClient4(nsName string, modifiers ...dhcpv4.Modifier) (*nclient4.Lease, error) {
+	var client *nclient4.Client
+	err := inNS(nsName, func() (err error) {
+		client, err = nclient4.New(ifClient, nclient4.WithTimeout(2*time.Second))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.Request(ctx, modifiers...)
+}
+
+// TestDORA4 leases an address from the range of a server on the same link
+func TestDORA4(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer startServer(t, nsDirectServer, dir, `
+server4:
+    listen: ["0.0.0.0%cdhcp_srv"]
+    plugins:
+        - server_id: 10.0.1.1
+        - router: 10.0.1.1
+        - netmask: 255.255.0.0
+        - range: `+filepath.Join(dir, "leases.txt")+` 10.0.2.100 10.0.2.150 60s
+`)()
+
+	lease, err := runClient4(nsDirectClient)
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeAck, lease.ACK.MessageType())
+	require.Equal(t, "10.0.2.100", lease.ACK.YourIPAddr.String())
+	require.Equal(t, "10.0.1.1", lease.ACK.ServerIdentifier().String())
+	require.Len(t, lease.ACK.Router(), 1)
+	require.Equal(t, "10.0.1.1", lease.ACK.Router()[0].String())
+	require.Equal(t, net.IPv4Mask(255, 255, 0, 0), lease.ACK.SubnetMask())
+	require.Equal(t, time.Minute, lease.ACK.IPAddressLeaseTime(0))
+
+	// The client gets the same address again
+	lease, err = runClient4(nsDirectClient)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.2.100", lease.ACK.YourIPAddr.String())
+}
+
+// TestRelay4 leases an address through a relay agent
+func TestRelay4(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer startServer(t, nsServer, dir, `
+server4:
+    listen: ["0.0.0.0%cdhcp_srv"]
+    plugins:
+        - server_id: 10.0.1.1
+        - router: 10.0.2.2
+        - netmask: 255.255.255.0
+        - range: `+filepath.Join(dir, "leases.txt")+` 10.0.2.100 10.0.2.150 60s
+`)()
+	r, err := startRelay4(net.IPv4(10, 0, 1, 1))
+	require.NoError(t, err)
+	defer r.Close()
+
+	lease, err := runClient4(nsClient)
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeAck, lease.ACK.MessageType())
+	require.Equal(t, "10.0.2.100", lease.ACK.YourIPAddr.String())
+	require.Equal(t, "10.0.2.2", lease.ACK.GatewayIPAddr.String())
+	require.Equal(t, "10.0.1.1", lease.ACK.ServerIdentifier().String())
+	require.Len(t, lease.ACK.Router(), 1)
+	require.Equal(t, "10.0.2.2", lease.ACK.Router()[0].String())
+}
+
+// TestPXE4 boots a PXE client with a static lease
+func TestPXE4(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	// The file plugin ends the chain
+	defer startServer(t, nsDirectServer, dir, `
+server4:
+    listen: ["0.0.0.0%cdhcp_srv"]
+    plugins:
+        - server_id: 10.0.1.1
+        - pxe: tftp://10.0.1.1/pxelinux.0
+        - file: leases-dhcpv4-test.txt
+`)()
+
+	// Client machine identifier: type 0, then the UUID
+	uuid := []byte{0, 0x2f, 0x6b, 0x1c, 0x4e, 0x3a, 0x5d, 0x11, 0xeb, 0x9e, 0x2c, 0x00, 0x0c, 0x29, 0x4f, 0x61, 0x5a}
+	lease, err := runClient4(nsDirectClient,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, uuid)),
+		dhcpv4.WithRequestedOptions(
+			dhcpv4.OptionVendorSpecificInformation,
+			dhcpv4.OptionClassIdentifier,
+			dhcpv4.OptionTFTPServerName,
+			dhcpv4.OptionBootfileName,
+		),
+	)
+	require.NoError(t, err)
+	for _, d := range []*dhcpv4.DHCPv4{lease.Offer, lease.ACK} {
+		require.Equal(t, "10.0.2.200", d.YourIPAddr.String())
+		require.Equal(t, "PXEClient", d.ClassIdentifier())
+		require.Equal(t, "10.0.1.1", d.TFTPServerName())
+		require.Equal(t, "/pxelinux.0", d.BootFileNameOption())
+		require.Equal(t, []byte{6, 1, 8, 255}, d.GetOneOption(dhcpv4.OptionVendorSpecificInformation))
+		require.Equal(t, uuid, d.GetOneOption(dhcpv4.OptionClientMachineIdentifier))
+	}
+}
@@ -7,118 +7,48 @@
 package e2e_test
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
-	"runtime"
 	"testing"
+	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/dhcpv6/client6"
+	"github.com/insomniacslk/dhcp/dhcpv6/nclient6"
 	"github.com/insomniacslk/dhcp/iana"
 	"github.com/stretchr/testify/require"
-	"github.com/vishvananda/netns"
-
-	"github.com/coredhcp/coredhcp/config"
-	"github.com/coredhcp/coredhcp/plugins"
-	"github.com/coredhcp/coredhcp/server"
-
-	// Plugins
-	"github.com/coredhcp/coredhcp/plugins/file"
-	"github.com/coredhcp/coredhcp/plugins/serverid"
 )
 
-var serverConfig = config.Config{
-	Server6: &config.ServerConfig{
-		Addresses: []net.UDPAddr{
-			{
-				IP:   net.ParseIP("ff02::1:2"),
-				Port: dhcpv6.DefaultServerPort,
-				Zone: "cdhcp_srv",
-			},
-		},
-		Plugins: []config.PluginConfig{
-			{Name: "server_id", Args: []string{"LL", "11:22:33:44:55:66"}},
-			{Name: "file", Args: []string{"./leases-dhcpv6-test.txt"}},
-		},
-	},
-}
-
-// This function *must* be run in its own routine
-// For now this assumes ns are created outside.
-// TODO: dynamically create NS and interfaces directly in the test program
-func runServer(readyCh chan<- struct{}, nsName string, desiredPlugins []*plugins.Plugin) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	ns, err := netns.GetFromName(nsName)
-	if err != nil {
-		log.Panicf("Netns `%s` not set up: %v", nsName, err)
-	}
-	if err := netns.Set(ns); err != nil {
-		log.Panicf("Failed to switch to netns `%s`: %v", nsName, err)
-	}
-	// register plugins
-	for _, pl := range desiredPlugins {
-		if err := plugins.RegisterPlugin(pl); err != nil {
-			log.Panicf("Failed to register plugin `%s`: %v", pl.Name, err)
-		}
-	}
-	// start DHCP server
-	srv, err := server.Start(&serverConfig)
-	if err != nil {
-		log.Panicf("Server could not start: %v", err)
-	}
-	readyCh <- struct{}{}
-	if err := srv.Wait(); err != nil {
-		log.Panicf("Server errored during run: %v", err)
-	}
-}
-
-// runInNs will execute the provided cmd in the namespace nsName.
-// It returns the error status of the cmd. Errors in NS management will panic
-func runClient6(nsName, iface string, modifiers ...dhcpv6.Modifier) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	backupNS, err := netns.Get()
-	if err != nil {
-		panic("Could not save handle to original NS")
-	}
-
-	ns, err := netns.GetFromName(nsName)
-	if err != nil {
-		panic("netns not set up")
-	}
-	if err := netns.Set(ns); err != nil {
-		panic(fmt.Sprintf("Couldn't switch to test NS: %v", err))
-	}
+// serverConfig6 is the configuration of the DHCPv6 servers, listening on
+// an address of the server interface
+const serverConfig6 = `
+server6:
+    listen: ["[%s%%cdhcp_srv]"]
+    plugins:
+        - server_id: LL 11:22:33:44:55:66
+        - file: leases-dhcpv6-test.txt
+`
 
-	client := client6.NewClient()
-	_, cErr := client.Exchange(iface, modifiers...)
-
-	if netns.Set(backupNS) != nil {
-		panic("couldn't switch back to original NS")
-	}
-
-	return cErr
+// runClient6 runs a client6 exchange from the client interface of a
+// namespace
+func runClient6(nsName string, modifiers ...dhcpv6.Modifier) error {
+	return inNS(nsName, func() error {
+		_, err := client6.NewClient().Exchange(ifClient, modifiers...)
+		return err
+	})
 }
 
 // TestDora creates a server and attempts to connect to it
 func TestDora(t *testing.T) {
-	readyCh := make(chan struct{}, 1)
-	go runServer(readyCh,
-		"coredhcp-direct-upper",
-		[]*plugins.Plugin{
-			&serverid.Plugin, &file.Plugin,
-		},
-	)
-	// wait for server to be ready before sending DHCP request
-	<-readyCh
-	mac, err := net.ParseMAC("de:ad:be:ef:00:00")
-	if err != nil {
-		panic(err)
-	}
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer startServer(t, nsDirectServer, dir, fmt.Sprintf(serverConfig6, dhcpv6.AllDHCPRelayAgentsAndServers))()
+
+	mac, err := net.ParseMAC(clientMAC)
+	require.NoError(t, err)
 	require.NoError(t, runClient6(
-		"coredhcp-direct-lower", "cdhcp_cli",
+		nsDirectClient,
 		dhcpv6.WithClientID(dhcpv6.Duid{
 			Type:          dhcpv6.DUID_LL,
 			HwType:        iana.HWTypeEthernet,
@@ -126,3 +56,33 @@ func TestDora(t *testing.T) {
 		}),
 	))
 }
+
+// TestRelay6 leases an address through a relay agent
+func TestRelay6(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	defer startServer(t, nsServer, dir, fmt.Sprintf(serverConfig6, net.IPv6unspecified))()
+	r, err := startRelay6(net.ParseIP(ulaPrefix + ":a::1"))
+	require.NoError(t, err)
+	defer r.Close()
+
+	var client *nclient6.Client
+	require.NoError(t, inNS(nsClient, func() (err error) {
+		client, err = nclient6.New(ifClient, nclient6.WithTimeout(2*time.Second))
+		return err
+	}))
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	adv, err := client.Solicit(ctx)
+	require.NoError(t, err)
+	reply, err := client.Request(ctx, adv)
+	require.NoError(t, err)
+	require.Equal(t, dhcpv6.MessageTypeReply, reply.MessageType)
+	ia := reply.Options.OneIANA()
+	require.NotNil(t, ia)
+	addr := ia.Options.OneAddress()
+	require.NotNil(t, addr)
+	require.Equal(t, "2001:db8::10:1", addr.IPv6Addr.String())
+}
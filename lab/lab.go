@@ -0,0 +1,280 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package lab generates the configuration of lab mode: a DHCPv4 server on a
+// single interface, eg. to netboot a machine on a bench, without writing one.
+//
+// The interface is the one given, or the first Ethernet interface which is up,
+// preferably with a private (RFC1918) address. With an address, the server
+// serves the half of its network the address is not in, otherwise it picks a
+// private /24 network no interface is on, the server being its first address,
+// which must be added to the interface. The clients get the server as their
+// router, the resolvers of the host (or the server, if they are local), and a
+// boot file from the server, by TFTP.
+package lab
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/logger"
+)
+
+var log = logger.GetLogger("lab")
+
+// LeaseTime is the lease time of the clients
+const LeaseTime = "1h"
+
+// virtual are the prefixes of the names of the virtual interfaces skipped by
+// the autodetection, eg. of containers
+var virtual = []string{"docker", "veth", "virbr", "br-", "cni", "flannel", "lxc", "tun", "tap", "wg"}
+
+// candidates are the networks lab mode picks from when the interface has no
+// address, the first one no interface is on
+var candidates = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for i := 100; i < 200; i++ {
+		nets = append(nets, &net.IPNet{IP: net.IPv4(192, 168, byte(i), 0).To4(), Mask: net.CIDRMask(24, 32)})
+	}
+	for i := 100; i < 200; i++ {
+		nets = append(nets, &net.IPNet{IP: net.IPv4(10, byte(i), 0, 0).To4(), Mask: net.CIDRMask(24, 32)})
+	}
+	return nets
+}()
+
+var private = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"} {
+		_, n, _ := net.ParseCIDR(s)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// Lab is the configuration of lab mode
+type Lab struct {
+	Interface string
+	// Server is the address of the server, Network the network served
+	Server  net.IP
+	Network *net.IPNet
+	// Start and End bound the range of the addresses of the clients
+	Start, End net.IP
+	DNS        []net.IP
+	// Assigned tells whether the interface has the address of the server
+	Assigned bool
+	// Boot is the URL of the boot file
+	Boot string
+	// Leases is the lease file
+	Leases string
+}
+
+// New returns the lab configuration for an interface, detected if empty, and
+// a boot file, a URL or a file name served by TFTP by the server
+func New(iface, boot string) (*Lab, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		l    Lab
+		used []*net.IPNet
+	)
+	for _, ifi := range ifaces {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+				used = append(used, n)
+			}
+		}
+	}
+	if iface == "" {
+		if iface, err = detect(ifaces); err != nil {
+			return nil, err
+		}
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	l.Interface = ifi.Name
+	if n := privateAddr(ifi); n != nil {
+		// The mask may be given in 16 bytes
+		ones, bits := n.Mask.Size()
+		mask := net.CIDRMask(ones-(bits-32), 32)
+		l.Server, l.Network, l.Assigned = n.IP.To4(), &net.IPNet{IP: n.IP.To4().Mask(mask), Mask: mask}, true
+	} else {
+		if l.Network = freeNetwork(used); l.Network == nil {
+			return nil, errors.New("no free private network")
+		}
+		l.Server = host(l.Network, 1)
+	}
+	if l.Start, l.End = pickRange(l.Network, l.Server); l.Start == nil {
+		return nil, fmt.Errorf("network %s of %s is too small", l.Network, l.Interface)
+	}
+	l.DNS = resolvers("/etc/resolv.conf")
+	if len(l.DNS) == 0 {
+		l.DNS = []net.IP{l.Server}
+	}
+	if !strings.Contains(boot, "://") {
+		boot = "tftp://" + l.Server.String() + "/" + strings.TrimPrefix(boot, "/")
+	}
+	l.Boot = boot
+	l.Leases = filepath.Join(os.TempDir(), "coredhcp-lab-"+l.Interface+".txt")
+	return &l, nil
+}
+
+// detect returns the first Ethernet interface which is up, preferably with a
+// private address
+func detect(ifaces []net.Interface) (string, error) {
+	var found string
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 || len(ifi.HardwareAddr) != 6 || isVirtual(ifi.Name) {
+			continue
+		}
+		if privateAddr(&ifi) != nil {
+			return ifi.Name, nil
+		}
+		if found == "" {
+			found = ifi.Name
+		}
+	}
+	if found == "" {
+		return "", errors.New("no Ethernet interface is up, name one")
+	}
+	return found, nil
+}
+
+func isVirtual(name string) bool {
+	for _, prefix := range virtual {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateAddr returns the first private IPv4 address of an interface, nil if
+// none
+func privateAddr(ifi *net.Interface) *net.IPNet {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() == nil {
+			continue
+		}
+		for _, p := range private {
+			if p.Contains(n.IP) {
+				return n
+			}
+		}
+	}
+	return nil
+}
+
+// freeNetwork returns the first candidate network overlapping none of the
+// used ones, nil if none
+func freeNetwork(used []*net.IPNet) *net.IPNet {
+next:
+	for _, c := range candidates {
+		for _, u := range used {
+			if u.Contains(c.IP) || c.Contains(u.IP) {
+				continue next
+			}
+		}
+		return c
+	}
+	return nil
+}
+
+// host returns the n-th address of a network
+func host(n *net.IPNet, i uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(n.IP.To4())+i)
+	return ip
+}
+
+// pickRange returns the half of the hosts of a network the server is not in,
+// nil if the network is too small
+func pickRange(n *net.IPNet, server net.IP) (net.IP, net.IP) {
+	ones, bits := n.Mask.Size()
+	if bits-ones < 3 {
+		return nil, nil
+	}
+	// Without the network and broadcast addresses
+	size := uint32(1)<<uint(bits-ones) - 2
+	offset := binary.BigEndian.Uint32(server.To4()) - binary.BigEndian.Uint32(n.IP.To4())
+	if offset <= size/2 {
+		return host(n, size/2+1), host(n, size)
+	}
+	return host(n, 1), host(n, size/2)
+}
+
+// resolvers returns the resolvers of a resolv.conf file, but the local ones,
+// which the clients can't reach
+func resolvers(path string) []net.IP {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var ips []net.IP
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]).To4(); ip != nil && !ip.IsLoopback() {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// YAML returns the configuration of the server
+func (l *Lab) YAML() string {
+	var dns []string
+	for _, ip := range l.DNS {
+		dns = append(dns, ip.String())
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "version: %d\n", config.Version)
+	fmt.Fprintf(&b, "server4:\n")
+	fmt.Fprintf(&b, "  listen: \"%%%s\"\n", l.Interface)
+	fmt.Fprintf(&b, "  plugins:\n")
+	fmt.Fprintf(&b, "    - server_id: %s\n", l.Server)
+	fmt.Fprintf(&b, "    - router: %s\n", l.Server)
+	fmt.Fprintf(&b, "    - netmask: %s\n", net.IP(l.Network.Mask))
+	fmt.Fprintf(&b, "    - dns: %s\n", strings.Join(dns, " "))
+	fmt.Fprintf(&b, "    - range: %s %s %s %s\n", l.Leases, l.Start, l.End, LeaseTime)
+	// Last, as it ends the chain
+	fmt.Fprintf(&b, "    - nbp: %s\n", l.Boot)
+	return b.String()
+}
+
+// Print prints the configuration, and what remains to be done
+func (l *Lab) Print() {
+	fmt.Printf("# Lab mode on %s, serving %s-%s of %s\n", l.Interface, l.Start, l.End, l.Network)
+	if !l.Assigned {
+		ones, _ := l.Network.Mask.Size()
+		fmt.Printf("# %s has no private address, add the address of the server:\n", l.Interface)
+		fmt.Printf("#   ip address add %s/%d dev %s\n", l.Server, ones, l.Interface)
+	}
+	fmt.Printf("# The boot file is %s, which the server must serve by TFTP\n", l.Boot)
+	fmt.Print(l.YAML())
+	log.Infof("Lab mode on %s, serving %s-%s", l.Interface, l.Start, l.End)
+}
@@ -0,0 +1,82 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package lab
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+func cidr(t *testing.T, s string) *net.IPNet {
+	ip, n, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	n.IP = ip
+	return n
+}
+
+func TestPickRange(t *testing.T) {
+	n := cidr(t, "192.168.1.0/24")
+	start, end := pickRange(n, net.IPv4(192, 168, 1, 1))
+	assert.Equal(t, "192.168.1.128", start.String())
+	assert.Equal(t, "192.168.1.254", end.String())
+	start, end = pickRange(n, net.IPv4(192, 168, 1, 200))
+	assert.Equal(t, "192.168.1.1", start.String())
+	assert.Equal(t, "192.168.1.127", end.String())
+
+	start, _ = pickRange(cidr(t, "192.168.1.0/30"), net.IPv4(192, 168, 1, 1))
+	assert.Nil(t, start)
+}
+
+func TestFreeNetwork(t *testing.T) {
+	n := freeNetwork([]*net.IPNet{cidr(t, "192.168.100.10/24"), cidr(t, "192.168.100.1/23")})
+	require.NotNil(t, n)
+	assert.Equal(t, "192.168.102.0/24", n.String())
+	n = freeNetwork([]*net.IPNet{cidr(t, "192.168.0.1/16")})
+	require.NotNil(t, n)
+	assert.Equal(t, "10.100.0.0/24", n.String())
+}
+
+func TestResolvers(t *testing.T) {
+	f, err := ioutil.TempFile("", "coredhcp-resolv")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("search example.org\nnameserver 127.0.0.53\nnameserver 192.0.2.53\nnameserver 2001:db8::53\n")
+	require.NoError(t, err)
+	f.Close()
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 53).To4()}, resolvers(f.Name()))
+}
+
+func TestYAML(t *testing.T) {
+	l := Lab{
+		Interface: "eth1",
+		Server:    net.IPv4(192, 168, 100, 1),
+		Network:   cidr(t, "192.168.100.0/24"),
+		Start:     net.IPv4(192, 168, 100, 128),
+		End:       net.IPv4(192, 168, 100, 254),
+		DNS:       []net.IP{net.IPv4(192, 0, 2, 53)},
+		Boot:      "tftp://192.168.100.1/pxelinux.0",
+		Leases:    "/tmp/leases.txt",
+	}
+	c, err := config.Parse(strings.NewReader(l.YAML()))
+	require.NoError(t, err)
+	require.NotNil(t, c.Server4)
+	assert.Nil(t, c.Server6)
+	assert.Equal(t, "eth1", c.Server4.Addresses[0].Zone)
+	var names []string
+	for _, p := range c.Server4.Plugins {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"server_id", "router", "netmask", "dns", "range", "nbp"}, names)
+	assert.Equal(t, []string{"255.255.255.0"}, c.Server4.Plugins[2].Args)
+	assert.Equal(t, []string{"/tmp/leases.txt", "192.168.100.128", "192.168.100.254", LeaseTime}, c.Server4.Plugins[4].Args)
+}
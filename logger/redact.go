@@ -0,0 +1,131 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Redaction modes, for the operators who must not store the identifiers of
+// the clients in their logs
+const (
+	// RedactNone logs the identifiers as they are
+	RedactNone = "none"
+	// RedactHash replaces the identifiers with their keyed hash, eg.
+	// pii:3fa2b1c9d0e1, the same for an identifier in all the messages
+	RedactHash = "hash"
+	// RedactTruncate keeps the first half of the identifiers, eg.
+	// aa:bb:cc… for a MAC address
+	RedactTruncate = "truncate"
+)
+
+// identifiers matches the identifiers redacted in all the messages: UUIDs,
+// MAC addresses, and the identifiers logged in hex (eg. DUIDs)
+var identifiers = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|(?:[0-9a-f]{2}[:-]){5}[0-9a-f]{2}|[0-9a-f]{20,})\b`)
+
+type redactor struct {
+	mode string
+	key  []byte
+}
+
+// redaction is the redaction of the logs, nil if none
+var redaction *redactor
+
+// identifier redacts an identifier
+func (r *redactor) identifier(id string) string {
+	if r.mode == RedactTruncate {
+		runes := []rune(id)
+		return string(runes[:len(runes)/2]) + "…"
+	}
+	// The same MAC address can be written in several ways
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(strings.ReplaceAll(strings.ToLower(id), "-", ":")))
+	return "pii:" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+func (r *redactor) text(s string) string {
+	return identifiers.ReplaceAllStringFunc(s, r.identifier)
+}
+
+// element redacts the values of a structured data element
+func (r *redactor) element(e SDElement) SDElement {
+	params := make([]SDParam, len(e.Params))
+	for i, p := range e.Params {
+		params[i] = SDParam{Name: p.Name, Value: r.text(p.Value)}
+	}
+	return SDElement{Name: e.Name, Params: params}
+}
+
+// Levels implements logrus.Hook
+func (r *redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook, redacting an entry before the other hooks and
+// the formatter see it
+func (r *redactor) Fire(e *logrus.Entry) error {
+	e.Message = r.text(e.Message)
+	// The fields are shared with the parent entry, copy them
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		switch v := v.(type) {
+		case string:
+			data[k] = r.text(v)
+		case error:
+			data[k] = r.text(v.Error())
+		case SDElement:
+			data[k] = r.element(v)
+		case *SDElement:
+			data[k] = r.element(*v)
+		default:
+			data[k] = v
+		}
+	}
+	e.Data = data
+	return nil
+}
+
+// WithRedaction redacts the identifiers of the clients in the logs, as told
+// by mode. The hashes are keyed with key, a random one if nil, so that they
+// are only correlatable across restarts with the same key. It must be called
+// before the other hooks are added (eg. WithFile), and before the server
+// logs concurrently
+func WithRedaction(log *logrus.Entry, mode string, key []byte) error {
+	switch mode {
+	case RedactNone:
+		return nil
+	case RedactHash:
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return fmt.Errorf("cannot generate a redaction key: %w", err)
+			}
+		}
+	case RedactTruncate:
+	default:
+		return fmt.Errorf("invalid redaction mode %q, expected %s, %s or %s", mode, RedactNone, RedactHash, RedactTruncate)
+	}
+	redaction = &redactor{mode: mode, key: key}
+	log.Logger.AddHook(redaction)
+	return nil
+}
+
+// Redact redacts an identifier which the logs can't recognize, eg. a host
+// name, as told to with WithRedaction. Without redaction, it is returned as
+// is
+func Redact(id string) string {
+	if redaction == nil || id == "" {
+		return id
+	}
+	return redaction.identifier(id)
+}
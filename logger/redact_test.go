@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logLine logs a message with a field with a redacting logger, and returns
+// its output
+func logLine(t *testing.T, mode string, key []byte, msg string, field interface{}) string {
+	var out bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&out)
+	l.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	entry := l.WithField("prefix", "test")
+	if err := WithRedaction(entry, mode, key); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { redaction = nil }()
+	entry.WithField("id", field).Info(msg)
+	if field, ok := entry.Data["id"]; ok {
+		t.Errorf("fields of the parent entry modified: %v", field)
+	}
+	return out.String()
+}
+
+func TestRedactHash(t *testing.T) {
+	key := []byte("key")
+	msg := "found IP address 10.0.0.2 for MAC aa:bb:cc:dd:ee:ff, DUID 00030001aabbccddeeff, UUID 123e4567-e89b-12d3-a456-426614174000"
+	out := logLine(t, RedactHash, key, msg, errors.New("client AA-BB-CC-DD-EE-FF"))
+	for _, id := range []string{"aa:bb:cc", "aabbccddeeff", "123e4567", "AA-BB"} {
+		if strings.Contains(out, id) {
+			t.Errorf("identifier %s not redacted: %s", id, out)
+		}
+	}
+	if !strings.Contains(out, "10.0.0.2") {
+		t.Errorf("address redacted: %s", out)
+	}
+	// Correlatable, whatever the way the MAC address is written
+	r := redactor{mode: RedactHash, key: key}
+	hashed := r.identifier("aa:bb:cc:dd:ee:ff")
+	if !strings.Contains(out, "MAC "+hashed) || !strings.Contains(out, "client "+hashed) {
+		t.Errorf("expected %s for the MAC address: %s", hashed, out)
+	}
+	if other := (&redactor{mode: RedactHash, key: []byte("other")}).identifier("aa:bb:cc:dd:ee:ff"); other == hashed {
+		t.Error("hash not keyed")
+	}
+}
+
+func TestRedactTruncate(t *testing.T) {
+	out := logLine(t, RedactTruncate, nil, "MAC aa:bb:cc:dd:ee:ff", "host")
+	if !strings.Contains(out, "MAC aa:bb:cc…") || strings.Contains(out, "dd:ee:ff") {
+		t.Errorf("expected a truncated MAC address: %s", out)
+	}
+}
+
+func TestRedactElement(t *testing.T) {
+	e := SDElement{Name: "lease", Params: []SDParam{{"hwaddr", "aa:bb:cc:dd:ee:ff"}}}
+	out := logLine(t, RedactTruncate, nil, "allocate", e)
+	if !strings.Contains(out, `hwaddr=\"aa:bb:cc…\"`) || strings.Contains(out, "dd:ee:ff") {
+		t.Errorf("expected a truncated MAC address: %s", out)
+	}
+	if e.Params[0].Value != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("element of the entry modified: %v", e)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	if got := Redact("laptop.example.org"); got != "laptop.example.org" {
+		t.Errorf("redacted without redaction: %s", got)
+	}
+	redaction = &redactor{mode: RedactTruncate}
+	defer func() { redaction = nil }()
+	if got := Redact("laptop.example.org"); got != "laptop.ex…" {
+		t.Errorf("expected a truncated name, got %s", got)
+	}
+	if err := WithRedaction(GetLogger("test"), "mask", nil); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
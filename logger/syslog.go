@@ -0,0 +1,271 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultEnterprise is the private enterprise number of the structured data
+// elements sent to syslog by default, the one reserved for documentation
+// (RFC 5612)
+const DefaultEnterprise = 32473
+
+// SDParam is a parameter of a structured data element
+type SDParam struct {
+	Name, Value string
+}
+
+// SDElement is a structured data element (RFC 5424 §6.3). Logged as the
+// value of a field, it is sent to syslog as an element named
+// <Name>@<enterprise number>, eg. the fields of a lease event, and written
+// as text by the other outputs
+type SDElement struct {
+	Name   string
+	Params []SDParam
+}
+
+// Add adds a parameter, unless its value is empty
+func (e *SDElement) Add(name, value string) {
+	if value != "" {
+		e.Params = append(e.Params, SDParam{Name: name, Value: value})
+	}
+}
+
+func (e SDElement) format(id string) string {
+	var b strings.Builder
+	b.WriteString("[" + sdName(id))
+	for _, p := range e.Params {
+		b.WriteString(" " + sdName(p.Name) + `="` + sdEscaper.Replace(p.Value) + `"`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// String formats the element as in syslog, without enterprise number
+func (e SDElement) String() string {
+	return e.format(e.Name)
+}
+
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// sdName returns a valid SD-NAME: up to 32 printable ASCII characters, but
+// '=', ' ', ']' and '"'
+func sdName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// severities are the syslog severities of the levels
+var severities = map[logrus.Level]int{
+	logrus.PanicLevel: 1,
+	logrus.FatalLevel: 2,
+	logrus.ErrorLevel: 3,
+	logrus.WarnLevel:  4,
+	logrus.InfoLevel:  6,
+	logrus.DebugLevel: 7,
+	logrus.TraceLevel: 7,
+}
+
+// facilityDaemon is the syslog facility of the messages
+const facilityDaemon = 3
+
+// syslogHook sends the entries to a syslog server, in the RFC 5424 format
+type syslogHook struct {
+	network, addr string
+	tls           *tls.Config
+	enterprise    int
+	hostname      string
+	pid           string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Levels implements logrus.Hook
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// format returns an entry in the RFC 5424 format, the prefix of the entry
+// being the MSGID, its fields its structured data
+func (h *syslogHook) format(e *logrus.Entry) string {
+	msgID := "-"
+	if prefix, ok := e.Data["prefix"].(string); ok && prefix != "" {
+		msgID = sdName(prefix)
+	}
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		if k != "prefix" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var (
+		sd     strings.Builder
+		fields = SDElement{Name: "fields"}
+	)
+	for _, k := range keys {
+		switch v := e.Data[k].(type) {
+		case SDElement:
+			sd.WriteString(v.format(v.Name + "@" + strconv.Itoa(h.enterprise)))
+		case *SDElement:
+			sd.WriteString(v.format(v.Name + "@" + strconv.Itoa(h.enterprise)))
+		default:
+			fields.Params = append(fields.Params, SDParam{Name: k, Value: fmt.Sprint(v)})
+		}
+	}
+	if len(fields.Params) > 0 {
+		sd.WriteString(fields.format(fields.Name + "@" + strconv.Itoa(h.enterprise)))
+	}
+	if sd.Len() == 0 {
+		sd.WriteString("-")
+	}
+	pri := facilityDaemon*8 + severities[e.Level]
+	return fmt.Sprintf("<%d>1 %s %s coredhcp %s %s %s %s", pri, e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		h.hostname, h.pid, msgID, sd.String(), e.Message)
+}
+
+func (h *syslogHook) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	if h.tls != nil {
+		return tls.DialWithDialer(d, "tcp", h.addr, h.tls)
+	}
+	return d.Dial(h.network, h.addr)
+}
+
+func (h *syslogHook) write(msg string) error {
+	if h.conn == nil {
+		conn, err := h.dial()
+		if err != nil {
+			return err
+		}
+		h.conn = conn
+	}
+	// The messages are framed by octet counting on streams (RFC 6587)
+	if h.network != "udp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_ = h.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := h.conn.Write([]byte(msg)); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Fire implements logrus.Hook. A message which can't be sent is retried once,
+// on a new connection
+func (h *syslogHook) Fire(e *logrus.Entry) error {
+	msg := h.format(e)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.write(msg); err != nil {
+		if err := h.write(msg); err != nil {
+			return fmt.Errorf("cannot send to syslog server %s: %w", h.addr, err)
+		}
+	}
+	return nil
+}
+
+// newSyslogHook returns the hook of a target, see WithSyslog
+func newSyslogHook(target string) (*syslogHook, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog target %q, expected udp://, tcp:// or tls://<host>[:<port>]", target)
+	}
+	h := syslogHook{network: u.Scheme, enterprise: DefaultEnterprise, hostname: "-", pid: strconv.Itoa(os.Getpid())}
+	var port string
+	switch u.Scheme {
+	case "udp":
+		port = "514"
+	case "tcp":
+		port = "601"
+	case "tls":
+		port = "6514"
+		h.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid syslog target %q, expected udp://, tcp:// or tls://<host>[:<port>]", target)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	h.addr = net.JoinHostPort(u.Hostname(), port)
+	q := u.Query()
+	if ca := q.Get("ca"); ca != "" {
+		if h.tls == nil {
+			return nil, errors.New("a CA is only used with tls://")
+		}
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		h.tls.RootCAs = x509.NewCertPool()
+		if !h.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", ca)
+		}
+	}
+	if pen := q.Get("enterprise"); pen != "" {
+		if h.enterprise, err = strconv.Atoi(pen); err != nil || h.enterprise <= 0 {
+			return nil, fmt.Errorf("invalid enterprise number %q", pen)
+		}
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		h.hostname = sdName(name)
+	}
+	return &h, nil
+}
+
+// WithSyslog sends the logs to a syslog server too, in the RFC 5424 format,
+// with their fields as structured data: the SDElement values as such (eg.
+// the lease events), the other ones in a fields element. The target is
+// udp://, tcp:// or tls://<host>[:<port>], the ports being 514, 601 and 6514
+// by default, with the query parameters:
+// - ca=<file>: the CA certificates of the server, for tls://. The system ones
+// by default
+// - enterprise=<number>: the private enterprise number of the structured
+// data elements, DefaultEnterprise by default
+// As for WithFile, it must be called after WithRedaction
+func WithSyslog(log *logrus.Entry, target string) error {
+	h, err := newSyslogHook(target)
+	if err != nil {
+		return err
+	}
+	// Fail early on a wrong address
+	h.mu.Lock()
+	h.conn, err = h.dial()
+	h.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot connect to syslog server %s: %w", h.addr, err)
+	}
+	log.Logger.AddHook(h)
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logger
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSDElement(t *testing.T) {
+	e := SDElement{Name: "lease"}
+	e.Add("hostname", `a "b" \c]`)
+	e.Add("empty", "")
+	e.Add("bad name", "x")
+	if got, want := e.String(), `[lease hostname="a \"b\" \\c\]" bad_name="x"]`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	h, err := newSyslogHook("udp://127.0.0.1?enterprise=99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.addr != "127.0.0.1:514" {
+		t.Errorf("expected the default port, got %s", h.addr)
+	}
+	h.hostname, h.pid = "host", "42"
+	e := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"prefix": "plugins/eventlog",
+		"lease":  SDElement{Name: "lease", Params: []SDParam{{"event", "allocate"}, {"ip", "192.0.2.100"}}},
+		"count":  3,
+	})
+	e.Time = time.Date(2020, 6, 2, 10, 12, 4, 0, time.UTC)
+	e.Level = logrus.InfoLevel
+	e.Message = "allocate 192.0.2.100"
+	want := `<30>1 2020-06-02T10:12:04.000000Z host coredhcp 42 plugins/eventlog [lease@99 event="allocate" ip="192.0.2.100"][fields@99 count="3"] allocate 192.0.2.100`
+	if got := h.format(e); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	e = logrus.NewEntry(logrus.New())
+	e.Level = logrus.ErrorLevel
+	if got := h.format(e); !strings.HasPrefix(got, "<27>1 ") || !strings.Contains(got, " 42 - - ") {
+		t.Errorf("expected no MSGID nor structured data: %s", got)
+	}
+
+	for _, target := range []string{"syslog.example.org", "http://syslog.example.org", "udp://syslog.example.org?ca=ca.pem", "tls://syslog.example.org?enterprise=x"} {
+		if _, err := newSyslogHook(target); err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString(']')
+		lines <- line
+	}()
+
+	l := logrus.New()
+	entry := l.WithField("prefix", "test")
+	if err := WithSyslog(entry, "tcp://"+ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	entry.WithField("lease", SDElement{Name: "lease", Params: []SDParam{{"event", "allocate"}}}).Info("allocate")
+	select {
+	case line := <-lines:
+		// Framed by octet counting
+		if !regexp.MustCompile(`^\d+ <30>1 \S+ \S+ coredhcp \d+ test \[lease@32473 event="allocate"\]$`).MatchString(line) {
+			t.Errorf("unexpected message %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package metrics holds the metrics of the plugins, published with expvar
+// under "plugins", by plugin name, eg.
+// {"range": {"served": 120, "dropped": 2, "passed": 0, "errors": 0, "leases": 80}}.
+//
+// The core counts how the plugins handled the requests: "served" (stopping
+// the chain with a response), "dropped", "passed" (to the next plugins), and
+// "errors" (reported with handler.ReportError). It gives the plugins their
+// Registry in the context of their Start hook and of the requests they
+// handle, see FromContext, for their own counters and gauges.
+//
+// The core also counts why the requests were NAKed or dropped, published
+// under "refusals", by reason, eg. {"pool-exhausted": 3, "blocked-mac": 12}.
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"sync"
+)
+
+// plugins holds the metrics of the plugins, by plugin name
+var plugins = expvar.NewMap("plugins")
+
+// refusals counts the refused requests, by reason
+var refusals = expvar.NewMap("refusals")
+
+var registries = struct {
+	sync.Mutex
+	byName map[string]*Registry
+}{byName: make(map[string]*Registry)}
+
+// Registry holds the metrics of a plugin. The methods of a nil Registry, that
+// of a context without one, return metrics which are not published
+type Registry struct {
+	mu   sync.Mutex
+	vars *expvar.Map
+}
+
+// Get returns the Registry of a plugin, creating it if needed
+func Get(plugin string) *Registry {
+	registries.Lock()
+	defer registries.Unlock()
+	r, ok := registries.byName[plugin]
+	if !ok {
+		r = &Registry{vars: new(expvar.Map).Init()}
+		registries.byName[plugin] = r
+		plugins.Set(plugin, r.vars)
+	}
+	return r
+}
+
+// Counter returns the counter of a plugin with a name, creating it if needed
+func (r *Registry) Counter(name string) *expvar.Int {
+	if r == nil {
+		return new(expvar.Int)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.vars.Get(name).(*expvar.Int); ok {
+		return v
+	}
+	v := new(expvar.Int)
+	r.vars.Set(name, v)
+	return v
+}
+
+// Gauge returns the gauge of a plugin with a name, creating it if needed
+func (r *Registry) Gauge(name string) *expvar.Float {
+	if r == nil {
+		return new(expvar.Float)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.vars.Get(name).(*expvar.Float); ok {
+		return v
+	}
+	v := new(expvar.Float)
+	r.vars.Set(name, v)
+	return v
+}
+
+// Func publishes a metric of a plugin computed when read, eg. the size of a
+// cache, replacing the one with the same name if any
+func (r *Registry) Func(name string, f func() interface{}) {
+	if r == nil {
+		return
+	}
+	r.vars.Set(name, expvar.Func(f))
+}
+
+// contextKey is the type of ContextKey, to be unique
+type contextKey struct{}
+
+// ContextKey is the context key of the Registry of a plugin. Contexts handing
+// out their own Registry, like those of the requests, answer it
+var ContextKey interface{} = contextKey{}
+
+// NewContext returns a context holding the Registry of a plugin
+func NewContext(parent context.Context, r *Registry) context.Context {
+	return context.WithValue(parent, ContextKey, r)
+}
+
+// FromContext returns the Registry of the plugin a context is given to, nil
+// if it has none
+func FromContext(ctx context.Context) *Registry {
+	r, _ := ctx.Value(ContextKey).(*Registry)
+	return r
+}
+
+// CountRefusal counts a request refused for a reason (see handler.Refuse)
+func CountRefusal(reason string) {
+	refusals.Add(reason, 1)
+}
+
+// Refusals returns how many requests were refused, by reason
+func Refusals() map[string]int64 {
+	counts := make(map[string]int64)
+	refusals.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			counts[kv.Key] = v.Value()
+		}
+	})
+	return counts
+}
@@ -0,0 +1,54 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := Get("test")
+	assert.Same(t, r, Get("test"))
+	r.Counter("requests").Add(2)
+	r.Counter("requests").Add(1)
+	r.Gauge("utilization").Set(0.5)
+	r.Func("entries", func() interface{} { return 42 })
+
+	var published map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("plugins").String()), &published))
+	assert.Equal(t, map[string]interface{}{
+		"requests":    float64(3),
+		"utilization": 0.5,
+		"entries":     float64(42),
+	}, published["test"])
+}
+
+func TestRefusals(t *testing.T) {
+	CountRefusal("test-reason")
+	CountRefusal("test-reason")
+	assert.Equal(t, int64(2), Refusals()["test-reason"])
+
+	var published map[string]int64
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("refusals").String()), &published))
+	assert.Equal(t, int64(2), published["test-reason"])
+}
+
+func TestContext(t *testing.T) {
+	r := Get("test-context")
+	assert.Same(t, r, FromContext(NewContext(context.Background(), r)))
+
+	// Nothing published without a registry
+	none := FromContext(context.Background())
+	assert.Nil(t, none)
+	none.Counter("requests").Add(1)
+	none.Gauge("utilization").Set(1)
+	none.Func("entries", func() interface{} { return 0 })
+}
@@ -57,7 +57,8 @@ func (a *IPv4Allocator) toOffset(ip net.IP) (uint, error) {
 	return uint(intIP - a.start), nil
 }
 
-// Allocate reserves an IP for a client
+// Allocate reserves an IP for a client: the hint if it is free, or else the
+// next free one after it, wrapping around
 func (a *IPv4Allocator) Allocate(hint net.IPNet) (n net.IPNet, err error) {
 	n.Mask = net.CIDRMask(32, 32)
 
@@ -72,8 +73,11 @@ func (a *IPv4Allocator) Allocate(hint net.IPNet) (n net.IPNet, err error) {
 	if !a.bitmap.Test(hintOffset) {
 		next = hintOffset
 	} else {
-		// Then any available address
-		avail, ok := a.bitmap.NextClear(0)
+		// Then the next available address
+		avail, ok := a.bitmap.NextClear(hintOffset)
+		if !ok {
+			avail, ok = a.bitmap.NextClear(0)
+		}
 		if !ok {
 			return n, allocators.ErrNoAddrAvail
 		}
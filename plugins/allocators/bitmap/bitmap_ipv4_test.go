@@ -61,3 +61,30 @@ func Test4OutOfPool(t *testing.T) {
 		t.Fatalf("Prefixes have wrong size %d/%d", prefLen, totalLen)
 	}
 }
+
+func Test4AllocAfterHint(t *testing.T) {
+	alloc := getv4Allocator()
+	hint := net.IPNet{IP: net.IPv4(192, 0, 2, 255), Mask: net.CIDRMask(32, 32)}
+
+	for _, want := range []net.IP{net.IPv4(192, 0, 2, 255), net.IPv4(192, 0, 2, 0), net.IPv4(192, 0, 2, 1)} {
+		res, err := alloc.Allocate(hint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.IP.Equal(want) {
+			t.Fatalf("Allocated %s, expected the next free address %s", res.IP, want)
+		}
+		hint.IP = net.IPv4(192, 0, 2, 0)
+	}
+
+	hint.IP = net.IPv4(192, 0, 2, 100)
+	for _, want := range []net.IP{net.IPv4(192, 0, 2, 100), net.IPv4(192, 0, 2, 101)} {
+		res, err := alloc.Allocate(hint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.IP.Equal(want) {
+			t.Fatalf("Allocated %s, expected the next free address %s", res.IP, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// This allocator returns prefixes of any size between the size of the pool
+// and a minimum size, using a buddy system: the pool is recursively split in
+// halves until a block of the requested size is found, and freed blocks are
+// merged back with their free sibling ("buddy"). This allows a single pool to
+// hand out prefixes of different lengths, eg. /56 to some clients and /60 to
+// others, with fragmentation bounded to the block boundaries.
+
+package buddy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+// Allocator is a prefix allocator handing out prefixes of variable sizes
+// carved out of a pool. Its memory use is proportional to the number of
+// allocated and free blocks, not to the size of the pool
+type Allocator struct {
+	containing net.IPNet
+	base       int // prefix length of the pool
+	leaf       int // longest prefix length that can be allocated
+	l          sync.Mutex
+	// free[o] and used[o] hold the indexes of the free and allocated
+	// blocks of order o, ie. of prefix length base+o
+	free []map[uint64]struct{}
+	used []map[uint64]struct{}
+}
+
+// NewBuddyAllocator creates a new allocator carving prefixes of length up to
+// `leaf` out of the given `pool` prefix
+func NewBuddyAllocator(pool net.IPNet, leaf int) (*Allocator, error) {
+	base, bits := pool.Mask.Size()
+	if bits != 128 {
+		return nil, errors.New("The buddy allocator only supports IPv6 pools")
+	}
+	if leaf < base || leaf > 128 {
+		return nil, fmt.Errorf("Invalid prefix length %d for a /%d pool", leaf, base)
+	}
+	if leaf-base >= 64 {
+		return nil, fmt.Errorf("A pool with more than 2^%d items is not representable", leaf-base)
+	}
+	a := Allocator{
+		containing: net.IPNet{IP: pool.IP.Mask(pool.Mask).To16(), Mask: pool.Mask},
+		base:       base,
+		leaf:       leaf,
+		free:       make([]map[uint64]struct{}, leaf-base+1),
+		used:       make([]map[uint64]struct{}, leaf-base+1),
+	}
+	for o := range a.free {
+		a.free[o] = make(map[uint64]struct{})
+		a.used[o] = make(map[uint64]struct{})
+	}
+	// The whole pool is a single free block of order 0
+	a.free[0][0] = struct{}{}
+	return &a, nil
+}
+
+// order returns the order of blocks of the requested size, clamped to the
+// sizes this allocator can hand out
+func (a *Allocator) order(hint net.IPNet) int {
+	size, bits := hint.Mask.Size()
+	if bits != 128 || size > a.leaf {
+		size = a.leaf
+	}
+	if size < a.base {
+		size = a.base
+	}
+	return size - a.base
+}
+
+func (a *Allocator) toPrefix(order int, idx uint64) (net.IPNet, error) {
+	size := a.base + order
+	ip, err := allocators.AddPrefixes(a.containing.IP, idx, uint64(size))
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	return net.IPNet{IP: ip, Mask: net.CIDRMask(size, 128)}, nil
+}
+
+// split takes the free block idx of order `from`, and splits it until the
+// block `target` of order `to` (contained in idx) is isolated. The other
+// halves are put back in the free lists
+func (a *Allocator) split(from int, idx uint64, to int, target uint64) {
+	delete(a.free[from], idx)
+	for o := from; o < to; o++ {
+		child := target >> uint(to-o-1)
+		a.free[o+1][child^1] = struct{}{}
+	}
+	a.used[to][target] = struct{}{}
+}
+
+// Allocate reserves a block of the size of the hint (clamped between the
+// size of the pool and the minimum size), at the hinted location if it is
+// available
+func (a *Allocator) Allocate(hint net.IPNet) (net.IPNet, error) {
+	want := a.order(hint)
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if hint.IP.To16() != nil && a.containing.Contains(hint.IP) {
+		target, err := allocators.Offset(hint.IP.To16(), a.containing.IP, a.base+want)
+		if err == nil {
+			// Find a free ancestor of the hinted block
+			for o := want; o >= 0; o-- {
+				ancestor := target >> uint(want-o)
+				if _, ok := a.free[o][ancestor]; ok {
+					a.split(o, ancestor, want, target)
+					return a.toPrefix(want, target)
+				}
+			}
+		}
+	}
+
+	// Take the lowest free block from the smallest order that can fit
+	for o := want; o >= 0; o-- {
+		if len(a.free[o]) == 0 {
+			continue
+		}
+		var idx uint64
+		first := true
+		for i := range a.free[o] {
+			if first || i < idx {
+				idx, first = i, false
+			}
+		}
+		target := idx << uint(want-o)
+		a.split(o, idx, want, target)
+		return a.toPrefix(want, target)
+	}
+	return net.IPNet{}, allocators.ErrNoAddrAvail
+}
+
+// Free returns the given prefix to the available pool, merging it with its
+// buddies when they are free as well
+func (a *Allocator) Free(prefix net.IPNet) error {
+	size, bits := prefix.Mask.Size()
+	if bits != 128 || size < a.base || size > a.leaf || !a.containing.Contains(prefix.IP) {
+		return fmt.Errorf("Could not find prefix %s in pool", &prefix)
+	}
+	o := size - a.base
+	idx, err := allocators.Offset(prefix.IP.To16(), a.containing.IP, size)
+	if err != nil {
+		return fmt.Errorf("Could not find prefix in pool: %w", err)
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, ok := a.used[o][idx]; !ok {
+		return &allocators.ErrDoubleFree{Loc: prefix}
+	}
+	delete(a.used[o], idx)
+	for o > 0 {
+		if _, ok := a.free[o][idx^1]; !ok {
+			break
+		}
+		delete(a.free[o], idx^1)
+		idx >>= 1
+		o--
+	}
+	a.free[o][idx] = struct{}{}
+	return nil
+}
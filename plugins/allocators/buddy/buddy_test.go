@@ -0,0 +1,139 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package buddy
+
+import (
+	"net"
+	"testing"
+)
+
+func mustPrefix(s string) net.IPNet {
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *prefix
+}
+
+func TestAllocFree(t *testing.T) {
+	alloc, err := NewBuddyAllocator(mustPrefix("2001:db8::/48"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := alloc.Allocate(net.IPNet{Mask: net.CIDRMask(56, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "2001:db8::/56" {
+		t.Fatalf("Expected the first /56 of the pool, got %s", &p)
+	}
+
+	if err := alloc.Free(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Free(p); err == nil {
+		t.Fatal("Expected DoubleFree error")
+	}
+	// Everything merged back into the pool
+	if len(alloc.free[0]) != 1 {
+		t.Fatalf("Free blocks were not merged: %v", alloc.free)
+	}
+}
+
+func TestMixedSizes(t *testing.T) {
+	alloc, err := NewBuddyAllocator(mustPrefix("2001:db8::/56"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	small, err := alloc.Allocate(net.IPNet{Mask: net.CIDRMask(64, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := alloc.Allocate(net.IPNet{Mask: net.CIDRMask(57, 128)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large.Contains(small.IP) {
+		t.Fatalf("Overlapping allocations %s and %s", &small, &large)
+	}
+	if large.String() != "2001:db8:0:80::/57" {
+		t.Fatalf("Expected the upper half of the pool, got %s", &large)
+	}
+
+	// The lower half only has 127 /64 left, so no other /57 fits
+	if _, err := alloc.Allocate(net.IPNet{Mask: net.CIDRMask(57, 128)}); err == nil {
+		t.Fatal("Allocated an overlapping /57")
+	}
+	if err := alloc.Free(small); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Allocate(net.IPNet{Mask: net.CIDRMask(57, 128)}); err != nil {
+		t.Fatalf("Could not allocate the /57 freed by merging: %v", err)
+	}
+}
+
+func TestHint(t *testing.T) {
+	alloc, err := NewBuddyAllocator(mustPrefix("2001:db8::/48"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hint := mustPrefix("2001:db8:0:4200::/56")
+	p, err := alloc.Allocate(hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != hint.String() {
+		t.Fatalf("Hint not honored: got %s, expected %s", &p, &hint)
+	}
+
+	// Same hint again: a different prefix of the same size is returned
+	p2, err := alloc.Allocate(hint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.String() == hint.String() {
+		t.Fatal("Allocated the same prefix twice")
+	}
+	if size, _ := p2.Mask.Size(); size != 56 {
+		t.Fatalf("Expected a /56, got %s", &p2)
+	}
+
+	// Hints longer than the minimum size are clamped
+	p3, err := alloc.Allocate(mustPrefix("2001:db8:0:ff00::/120"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p3.String() != "2001:db8:0:ff00::/64" {
+		t.Fatalf("Expected a clamped /64, got %s", &p3)
+	}
+}
+
+func TestExhaust(t *testing.T) {
+	alloc, err := NewBuddyAllocator(mustPrefix("2001:db8::/62"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+			t.Fatalf("Error before exhaustion: %v", err)
+		}
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("Successfully allocated more prefixes than there are in the pool")
+	}
+}
+
+func TestOutOfPool(t *testing.T) {
+	alloc, err := NewBuddyAllocator(mustPrefix("2001:db8::/48"), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.Free(mustPrefix("2001:db9::/64")); err == nil {
+		t.Fatal("Freed a prefix outside of the pool")
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package sparse provides an IPv4 allocator for large pools, up to millions
+// of addresses, as a compressed bitmap: the pool is split in blocks of 4096
+// addresses, and only the blocks partly allocated hold a bitmap, the free
+// blocks and the full ones taking no memory. Summaries of the blocks with
+// free addresses find the next free address in constant time for the pools
+// of IPv4, and allocating and freeing an address is O(1).
+//
+// The changes of the allocations can be persisted as they are made, without
+// writing the whole pool: WriteDeltas writes the blocks changed since the
+// last call, and ReadDeltas applies them in order to a new allocator.
+package sparse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+const (
+	// blockBits is the number of addresses of a block
+	blockBits  = 4096
+	blockWords = blockBits / 64
+)
+
+var (
+	errNotInRange = errors.New("IPv4 address outside of allowed range")
+	errInvalidIP  = errors.New("invalid IPv4 address passed as input")
+)
+
+// block is the bitmap of a block partly allocated
+type block struct {
+	words [blockWords]uint64
+	// free has a bit set for the words with a free address
+	free uint64
+	used int
+}
+
+// full is the shared block of the fully allocated blocks. It is never
+// modified
+var full = &block{used: blockBits}
+
+func init() {
+	for i := range full.words {
+		full.words[i] = ^uint64(0)
+	}
+}
+
+// nextClear returns the first free address of a block at or after i, -1 if
+// none
+func (b *block) nextClear(i int) int {
+	w := i / 64
+	if m := ^b.words[w] >> uint(i%64); m != 0 {
+		return i + bits.TrailingZeros64(m)
+	}
+	if w+1 == blockWords {
+		return -1
+	}
+	if m := b.free >> uint(w+1); m != 0 {
+		w += 1 + bits.TrailingZeros64(m)
+		return w*64 + bits.TrailingZeros64(^b.words[w])
+	}
+	return -1
+}
+
+// IPv4Allocator allocates the IPv4 addresses of a pool
+type IPv4Allocator struct {
+	start, end uint32
+
+	mu sync.Mutex
+	// blocks are the blocks of the pool, nil for the free ones and full for
+	// the full ones
+	blocks []*block
+	// avail has a bit set for the blocks with a free address, summary a bit
+	// set for the words of avail with one
+	avail, summary []uint64
+	// dirty are the blocks changed since the last WriteDeltas
+	dirty map[int]bool
+}
+
+// NewIPv4Allocator returns an allocator of the addresses from start to end,
+// inclusive
+func NewIPv4Allocator(start, end net.IP) (*IPv4Allocator, error) {
+	if start.To4() == nil || end.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 addresses given to create the allocator: [%s,%s]", start, end)
+	}
+	a := IPv4Allocator{
+		start: binary.BigEndian.Uint32(start.To4()),
+		end:   binary.BigEndian.Uint32(end.To4()),
+		dirty: make(map[int]bool),
+	}
+	if a.start > a.end {
+		return nil, errors.New("no IPs in the given range to allocate")
+	}
+	size := uint64(a.end-a.start) + 1
+	n := int((size + blockBits - 1) / blockBits)
+	a.blocks = make([]*block, n)
+	a.avail = make([]uint64, (n+63)/64)
+	a.summary = make([]uint64, (len(a.avail)+63)/64)
+	for i := 0; i < n; i++ {
+		a.setAvail(i, true)
+	}
+	// The addresses after the end of the pool, in its last block, are
+	// allocated for good
+	if rest := int(size % blockBits); rest != 0 {
+		for i := rest; i < blockBits; i++ {
+			a.set(uint64(n-1)*blockBits + uint64(i))
+		}
+	}
+	return &a, nil
+}
+
+func (a *IPv4Allocator) setAvail(b int, avail bool) {
+	w := b / 64
+	if avail {
+		a.avail[w] |= 1 << uint(b%64)
+		a.summary[w/64] |= 1 << uint(w%64)
+		return
+	}
+	a.avail[w] &^= 1 << uint(b%64)
+	if a.avail[w] == 0 {
+		a.summary[w/64] &^= 1 << uint(w%64)
+	}
+}
+
+// nextAvail returns the first block with a free address at or after b, -1 if
+// none
+func (a *IPv4Allocator) nextAvail(b int) int {
+	w := b / 64
+	if w >= len(a.avail) {
+		return -1
+	}
+	if m := a.avail[w] >> uint(b%64); m != 0 {
+		return b + bits.TrailingZeros64(m)
+	}
+	// The summary skips the words of avail without any
+	for w++; w < len(a.avail); {
+		m := a.summary[w/64] >> uint(w%64)
+		if m == 0 {
+			w = (w/64 + 1) * 64
+			continue
+		}
+		w += bits.TrailingZeros64(m)
+		return w*64 + bits.TrailingZeros64(a.avail[w])
+	}
+	return -1
+}
+
+// nextFree returns the offset of the first free address at or after an
+// offset, false if none
+func (a *IPv4Allocator) nextFree(offset uint64) (uint64, bool) {
+	b := int(offset / blockBits)
+	if b >= len(a.blocks) {
+		return 0, false
+	}
+	switch blk := a.blocks[b]; blk {
+	case nil:
+		return offset, true
+	case full:
+	default:
+		if i := blk.nextClear(int(offset % blockBits)); i >= 0 {
+			return uint64(b)*blockBits + uint64(i), true
+		}
+	}
+	if b = a.nextAvail(b + 1); b < 0 {
+		return 0, false
+	}
+	if blk := a.blocks[b]; blk != nil {
+		return uint64(b)*blockBits + uint64(blk.nextClear(0)), true
+	}
+	return uint64(b) * blockBits, true
+}
+
+// set allocates the address at an offset, which must be free
+func (a *IPv4Allocator) set(offset uint64) {
+	b, i := int(offset/blockBits), int(offset%blockBits)
+	blk := a.blocks[b]
+	if blk == nil {
+		blk = &block{free: ^uint64(0)}
+		a.blocks[b] = blk
+	}
+	blk.words[i/64] |= 1 << uint(i%64)
+	if blk.words[i/64] == ^uint64(0) {
+		blk.free &^= 1 << uint(i/64)
+	}
+	blk.used++
+	if blk.used == blockBits {
+		a.blocks[b] = full
+		a.setAvail(b, false)
+	}
+	a.dirty[b] = true
+}
+
+// clear frees the address at an offset, false if it was not allocated
+func (a *IPv4Allocator) clear(offset uint64) bool {
+	b, i := int(offset/blockBits), int(offset%blockBits)
+	blk := a.blocks[b]
+	switch blk {
+	case nil:
+		return false
+	case full:
+		blk = &block{used: blockBits}
+		blk.words = full.words
+		a.blocks[b] = blk
+		a.setAvail(b, true)
+	}
+	if blk.words[i/64]&(1<<uint(i%64)) == 0 {
+		return false
+	}
+	blk.words[i/64] &^= 1 << uint(i%64)
+	blk.free |= 1 << uint(i/64)
+	blk.used--
+	if blk.used == 0 {
+		a.blocks[b] = nil
+	}
+	a.dirty[b] = true
+	return true
+}
+
+func (a *IPv4Allocator) toOffset(ip net.IP) (uint64, error) {
+	if ip.To4() == nil {
+		return 0, errInvalidIP
+	}
+	n := binary.BigEndian.Uint32(ip.To4())
+	if n < a.start || n > a.end {
+		return 0, errNotInRange
+	}
+	return uint64(n - a.start), nil
+}
+
+func (a *IPv4Allocator) toIP(offset uint64) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, a.start+uint32(offset))
+	return ip
+}
+
+// Allocate reserves an IP for a client: the hint if it is free, or else the
+// next free one after it, wrapping around
+func (a *IPv4Allocator) Allocate(hint net.IPNet) (n net.IPNet, err error) {
+	n.Mask = net.CIDRMask(32, 32)
+	// This is just a hint, ignore any error with it
+	offset, _ := a.toOffset(hint.IP)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next, ok := a.nextFree(offset)
+	if !ok && offset > 0 {
+		next, ok = a.nextFree(0)
+	}
+	if !ok {
+		return n, allocators.ErrNoAddrAvail
+	}
+	a.set(next)
+	n.IP = a.toIP(next)
+	return n, nil
+}
+
+// Free releases the given IP
+func (a *IPv4Allocator) Free(n net.IPNet) error {
+	offset, err := a.toOffset(n.IP)
+	if err != nil {
+		return errNotInRange
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.clear(offset) {
+		return &allocators.ErrDoubleFree{Loc: n}
+	}
+	return nil
+}
+
+// The kinds of the blocks in the deltas
+const (
+	deltaFree byte = iota
+	deltaFull
+	deltaBitmap
+)
+
+// WriteDeltas writes the blocks changed since the last call, or since the
+// allocator was created, to w. Appended to each other, eg. in a file, the
+// deltas make up the state of the allocator, for ReadDeltas
+func (a *IPv4Allocator) WriteDeltas(w io.Writer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	changed := make([]int, 0, len(a.dirty))
+	for b := range a.dirty {
+		changed = append(changed, b)
+	}
+	sort.Ints(changed)
+	buf := make([]byte, 12, 12+len(changed)*5)
+	binary.BigEndian.PutUint32(buf[0:4], a.start)
+	binary.BigEndian.PutUint32(buf[4:8], a.end)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(changed)))
+	for _, b := range changed {
+		buf = append(buf, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-5:], uint32(b))
+		switch blk := a.blocks[b]; blk {
+		case nil:
+			buf[len(buf)-1] = deltaFree
+		case full:
+			buf[len(buf)-1] = deltaFull
+		default:
+			buf[len(buf)-1] = deltaBitmap
+			for _, word := range blk.words {
+				buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 0)
+				binary.BigEndian.PutUint64(buf[len(buf)-8:], word)
+			}
+		}
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	a.dirty = make(map[int]bool)
+	return nil
+}
+
+// ReadDeltas applies the deltas written by WriteDeltas, for the same pool, to
+// the allocator, until the end of r
+func (a *IPv4Allocator) ReadDeltas(r io.Reader) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("truncated deltas: %w", err)
+		}
+		if binary.BigEndian.Uint32(header[0:4]) != a.start || binary.BigEndian.Uint32(header[4:8]) != a.end {
+			return errors.New("the deltas are for another pool")
+		}
+		for n := binary.BigEndian.Uint32(header[8:12]); n > 0; n-- {
+			if err := a.readBlock(r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readBlock reads the delta of a block. The caller must hold the lock
+func (a *IPv4Allocator) readBlock(r io.Reader) error {
+	rec := make([]byte, 5)
+	if _, err := io.ReadFull(r, rec); err != nil {
+		return fmt.Errorf("truncated deltas: %w", err)
+	}
+	b := int(binary.BigEndian.Uint32(rec[0:4]))
+	if b >= len(a.blocks) {
+		return fmt.Errorf("invalid block %d in the deltas", b)
+	}
+	var blk *block
+	switch rec[4] {
+	case deltaFree:
+	case deltaFull:
+		blk = full
+	case deltaBitmap:
+		blk = new(block)
+		words := make([]byte, blockWords*8)
+		if _, err := io.ReadFull(r, words); err != nil {
+			return fmt.Errorf("truncated deltas: %w", err)
+		}
+		for i := range blk.words {
+			blk.words[i] = binary.BigEndian.Uint64(words[i*8:])
+			if blk.words[i] != ^uint64(0) {
+				blk.free |= 1 << uint(i)
+			}
+			blk.used += bits.OnesCount64(blk.words[i])
+		}
+		switch blk.used {
+		case 0:
+			blk = nil
+		case blockBits:
+			blk = full
+		}
+	default:
+		return fmt.Errorf("invalid kind %d of block %d in the deltas", rec[4], b)
+	}
+	a.blocks[b] = blk
+	a.setAvail(b, blk != full)
+	a.dirty[b] = true
+	return nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sparse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+func ipOf(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+func TestAllocate(t *testing.T) {
+	alloc, err := NewIPv4Allocator(net.IPv4(192, 0, 2, 0), net.IPv4(192, 0, 2, 255))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 256; i++ {
+		n, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[n.IP.String()] {
+			t.Fatalf("%s allocated twice", n.IP)
+		}
+		seen[n.IP.String()] = true
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err != allocators.ErrNoAddrAvail {
+		t.Fatalf("expected ErrNoAddrAvail from a full pool, got %v", err)
+	}
+
+	freed := net.IPNet{IP: net.IPv4(192, 0, 2, 42), Mask: net.CIDRMask(32, 32)}
+	if err := alloc.Free(freed); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := alloc.Free(freed).(*allocators.ErrDoubleFree); !ok {
+		t.Fatal("expected a double free error")
+	}
+	if err := alloc.Free(net.IPNet{IP: net.IPv4(192, 0, 3, 0)}); err == nil {
+		t.Fatal("expected an error freeing an address out of the pool")
+	}
+	n, err := alloc.Allocate(net.IPNet{IP: net.IPv4(192, 0, 2, 200)})
+	if err != nil || !n.IP.Equal(freed.IP) {
+		t.Fatalf("expected %s, wrapping around, got %s, %v", freed.IP, n.IP, err)
+	}
+}
+
+// model is the allocator as a plain set, to check the other against
+type model struct {
+	start, size uint32
+	used        map[uint32]bool
+}
+
+func (m *model) allocate(hint uint32) (uint32, bool) {
+	for i := uint32(0); i < m.size; i++ {
+		n := m.start + (hint-m.start+i)%m.size
+		if !m.used[n] {
+			m.used[n] = true
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func TestModel(t *testing.T) {
+	// Not a multiple of the blocks, with full blocks
+	start, size := uint32(10<<24), uint32(3*blockBits+100)
+	alloc, err := NewIPv4Allocator(ipOf(start), ipOf(start+size-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := model{start: start, size: size, used: make(map[uint32]bool)}
+	rng := rand.New(rand.NewSource(1))
+	var used []uint32
+	for i := 0; i < 50000; i++ {
+		if len(used) > 0 && rng.Intn(3) == 0 {
+			j := rng.Intn(len(used))
+			n := used[j]
+			used[j] = used[len(used)-1]
+			used = used[:len(used)-1]
+			delete(m.used, n)
+			if err := alloc.Free(net.IPNet{IP: ipOf(n)}); err != nil {
+				t.Fatalf("freeing %s: %v", ipOf(n), err)
+			}
+			continue
+		}
+		hint := start + uint32(rng.Intn(int(size)))
+		if rng.Intn(2) == 0 {
+			// Runs of sequential allocations, filling blocks
+			hint = start
+		}
+		want, ok := m.allocate(hint)
+		got, err := alloc.Allocate(net.IPNet{IP: ipOf(hint)})
+		if !ok {
+			if err != allocators.ErrNoAddrAvail {
+				t.Fatalf("expected ErrNoAddrAvail, got %s, %v", got.IP, err)
+			}
+			continue
+		}
+		if err != nil || !got.IP.Equal(ipOf(want)) {
+			t.Fatalf("allocation %d with hint %s: expected %s, got %s, %v", i, ipOf(hint), ipOf(want), got.IP, err)
+		}
+		used = append(used, want)
+	}
+}
+
+func TestLargePool(t *testing.T) {
+	// A /8, with a block left free at its end
+	alloc, err := NewIPv4Allocator(net.IPv4(10, 0, 0, 0), net.IPv4(10, 255, 255, 255))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1<<24-blockBits; i++ {
+		if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, blk := range alloc.blocks[:len(alloc.blocks)-1] {
+		if blk != full {
+			t.Fatal("the full blocks should share their bitmap")
+		}
+	}
+	n, err := alloc.Allocate(net.IPNet{IP: net.IPv4(10, 0, 0, 1)})
+	if err != nil || !n.IP.Equal(net.IPv4(10, 255, 240, 0)) {
+		t.Fatalf("expected the first address of the last block, got %s, %v", n.IP, err)
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	start, end := net.IPv4(10, 0, 0, 0), net.IPv4(10, 0, 255, 255)
+	alloc, err := NewIPv4Allocator(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var journal bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 5000; j++ {
+			if _, err := alloc.Allocate(net.IPNet{IP: ipOf(10<<24 + uint32(rng.Intn(1<<16)))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j := 0; j < 1000; j++ {
+			_ = alloc.Free(net.IPNet{IP: ipOf(10<<24 + uint32(rng.Intn(1<<16)))})
+		}
+		if err := alloc.WriteDeltas(&journal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the changed blocks are written
+	if _, err := alloc.Allocate(net.IPNet{}); err != nil {
+		t.Fatal(err)
+	}
+	size := journal.Len()
+	if err := alloc.WriteDeltas(&journal); err != nil {
+		t.Fatal(err)
+	}
+	if delta := journal.Len() - size; delta != 12+5+blockWords*8 {
+		t.Errorf("expected the delta of a block, got %d bytes", delta)
+	}
+
+	restored, err := NewIPv4Allocator(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.ReadDeltas(bytes.NewReader(journal.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(alloc.blocks, restored.blocks) || !reflect.DeepEqual(alloc.avail, restored.avail) {
+		t.Fatal("the deltas do not restore the allocations")
+	}
+
+	other, err := NewIPv4Allocator(start, net.IPv4(10, 0, 0, 255))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ReadDeltas(bytes.NewReader(journal.Bytes())); err == nil {
+		t.Fatal("expected an error applying the deltas of another pool")
+	}
+	if err := restored.ReadDeltas(bytes.NewReader(journal.Bytes()[:100])); err == nil {
+		t.Fatal("expected an error applying truncated deltas")
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package anomaly implements a plugin detecting rogue DHCPv4 clients:
+// - client_id: a hardware address cycling client identifiers, eg. to exhaust
+// the pools
+// - discover_flood: a flood of DISCOVERs from a port of a relay agent, as
+// identified by the giaddr and the Agent Circuit ID of option 82
+// - decline_flood: a client declining too many addresses
+//
+// Each anomaly is counted in the metrics of the plugin (see package metrics),
+// logged, posted as JSON to the alert URLs, if any, and optionally blocks the
+// offender, whose requests are dropped for a while.
+//
+// server4:
+//   plugins:
+//     - anomaly: window=1m discovers=50 block=10m url=https://noc.example.org/dhcp-alerts
+//     - server_id: 10.0.0.1
+//     - range: leases.txt 10.0.0.10 10.0.0.100 1h
+//
+// The arguments are:
+// - window=<duration>: the period over which the requests are counted, 1m by
+// default
+// - client_ids=<n>: the client identifiers a hardware address may use in a
+// window, 3 by default
+// - discovers=<n>: the DISCOVERs a port may send in a window, 100 by default
+// - declines=<n>: the DECLINEs a client may send in a window, 5 by default
+// - block=<duration>: how long the requests of an offender are dropped, the
+// hardware address or the port, not blocked by default
+// - url=<URL>: an endpoint to post the alerts to, can be repeated
+//
+// A limit of 0 disables the detection of its anomaly. The plugin should come
+// first, to drop the requests of the offenders before the other plugins
+// handle them.
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/metrics"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+)
+
+const pluginName = "anomaly"
+
+var log = logger.GetLogger("plugins/" + pluginName)
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:      pluginName,
+	Setup4Ctx: setup4,
+}
+
+// Anomalies
+const (
+	ClientIDCycling = "client_id"
+	DiscoverFlood   = "discover_flood"
+	DeclineFlood    = "decline_flood"
+)
+
+// alertQueue is how many alerts can wait to be posted before new ones are
+// dropped
+const alertQueue = 256
+
+// Alert describes an anomaly, as posted to the alert URLs
+type Alert struct {
+	Anomaly string    `json:"anomaly"`
+	Time    time.Time `json:"time"`
+	// HWAddr is set for the anomalies of a client, CircuitID and Relay for
+	// those of a port
+	HWAddr    string `json:"hwaddr,omitempty"`
+	CircuitID string `json:"circuit_id,omitempty"`
+	Relay     net.IP `json:"relay,omitempty"`
+	// Count is the number of client identifiers, DISCOVERs or DECLINEs seen
+	// in the window
+	Count int `json:"count"`
+	// Blocked is set when the requests of the offender are dropped
+	Blocked bool `json:"blocked"`
+}
+
+// counter counts the requests of a client or a port in a window
+type counter struct {
+	start time.Time
+	count int
+	// clientIDs are the client identifiers of a hardware address
+	clientIDs map[string]bool
+}
+
+// PluginState holds the limits and the counters of an instance of the plugin
+type PluginState struct {
+	window                     time.Duration
+	maxClientIDs, maxDiscovers int
+	maxDeclines                int
+	block                      time.Duration
+	urls                       []string
+	client                     *http.Client
+	alerts                     chan Alert
+	detected                   map[string]*expvar.Int
+	blockedRequests            *expvar.Int
+
+	mu sync.Mutex
+	// clients and ports are the counters of the hardware addresses and of
+	// the ports, blocked the end of the blocks of the offenders, by key
+	clients, ports map[string]*counter
+	blocked        map[string]time.Time
+	lastSweep      time.Time
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		window:       time.Minute,
+		maxClientIDs: 3,
+		maxDiscovers: 100,
+		maxDeclines:  5,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		clients:      make(map[string]*counter),
+		ports:        make(map[string]*counter),
+		blocked:      make(map[string]time.Time),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "window", "block":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s duration %q", key, value)
+			}
+			if key == "window" {
+				p.window = d
+			} else {
+				p.block = d
+			}
+		case "client_ids", "discovers", "declines":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid number of %s %q", key, value)
+			}
+			switch key {
+			case "client_ids":
+				p.maxClientIDs = n
+			case "discovers":
+				p.maxDiscovers = n
+			default:
+				p.maxDeclines = n
+			}
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid URL %q, expected an http or https URL", value)
+			}
+			p.urls = append(p.urls, value)
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	r := metrics.Get(pluginName)
+	p.detected = map[string]*expvar.Int{
+		ClientIDCycling: r.Counter(ClientIDCycling),
+		DiscoverFlood:   r.Counter(DiscoverFlood),
+		DeclineFlood:    r.Counter(DeclineFlood),
+	}
+	p.blockedRequests = r.Counter("blocked")
+	if len(p.urls) > 0 {
+		p.alerts = make(chan Alert, alertQueue)
+		go func() {
+			for a := range p.alerts {
+				p.deliver(a)
+			}
+		}()
+	}
+	log.Printf("loaded plugin for DHCPv4, counting the requests over %v", p.window)
+	return p.Handler4, nil
+}
+
+// Handler4 drops the requests of the offenders, and looks for anomalies in
+// the others
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if reason := p.inspect(req, time.Now()); reason != "" {
+		handler.Refuse(ctx, reason)
+		return nil, true
+	}
+	return resp, false
+}
+
+// portKey returns the key of the port of a relay agent a request comes from,
+// "" if it does not identify one
+func portKey(req *dhcpv4.DHCPv4) string {
+	circuit := relay.CircuitID4(req)
+	if circuit == nil {
+		return ""
+	}
+	return "port/" + req.GatewayIPAddr.String() + "/" + hex.EncodeToString(circuit)
+}
+
+// blockReason returns why the requests of a blocked offender are dropped
+func blockReason(key string) string {
+	if strings.HasPrefix(key, "port/") {
+		return handler.ReasonBlockedPort
+	}
+	return handler.ReasonBlockedMAC
+}
+
+// inspect counts a request, and returns why it is dropped, "" if it is not
+func (p *PluginState) inspect(req *dhcpv4.DHCPv4, now time.Time) string {
+	var (
+		alerts []Alert
+		// offender is the key of the last offender detected
+		offender string
+	)
+	defer func() {
+		// Outside of the lock
+		for _, a := range alerts {
+			p.raise(a)
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sweep(now)
+	client := "mac/" + req.ClientHWAddr.String()
+	port := portKey(req)
+	for _, key := range []string{client, port} {
+		if until, ok := p.blocked[key]; ok && now.Before(until) {
+			p.blockedRequests.Add(1)
+			log.Debugf("dropping request from %s: %s is blocked", req.ClientHWAddr, key)
+			return blockReason(key)
+		}
+	}
+
+	base := Alert{Time: now, HWAddr: req.ClientHWAddr.String()}
+	c := p.count(p.clients, client, now)
+	if id := req.Options.Get(dhcpv4.OptionClientIdentifier); id != nil && p.maxClientIDs > 0 {
+		if c.clientIDs == nil {
+			c.clientIDs = make(map[string]bool)
+		}
+		if !c.clientIDs[string(id)] {
+			c.clientIDs[string(id)] = true
+			if len(c.clientIDs) == p.maxClientIDs+1 {
+				a := base
+				a.Anomaly, a.Count = ClientIDCycling, len(c.clientIDs)
+				alerts = append(alerts, p.detect(a, client, now))
+				offender = client
+			}
+		}
+	}
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDecline:
+		c.count++
+		if p.maxDeclines > 0 && c.count == p.maxDeclines+1 {
+			a := base
+			a.Anomaly, a.Count = DeclineFlood, c.count
+			alerts = append(alerts, p.detect(a, client, now))
+			offender = client
+		}
+	case dhcpv4.MessageTypeDiscover:
+		if port == "" || p.maxDiscovers == 0 {
+			break
+		}
+		pc := p.count(p.ports, port, now)
+		pc.count++
+		if pc.count == p.maxDiscovers+1 {
+			a := Alert{
+				Anomaly:   DiscoverFlood,
+				Time:      now,
+				CircuitID: hex.EncodeToString(relay.CircuitID4(req)),
+				Relay:     req.GatewayIPAddr,
+				Count:     pc.count,
+			}
+			alerts = append(alerts, p.detect(a, port, now))
+			offender = port
+		}
+	}
+	if offender == "" || p.block == 0 {
+		return ""
+	}
+	return blockReason(offender)
+}
+
+// count returns the counter of a key for the current window, starting a new
+// one if needed
+func (p *PluginState) count(counters map[string]*counter, key string, now time.Time) *counter {
+	c, ok := counters[key]
+	if !ok || now.Sub(c.start) >= p.window {
+		c = &counter{start: now}
+		counters[key] = c
+	}
+	return c
+}
+
+// detect blocks the offender of an anomaly, if told to, and returns its
+// alert. Must be called with the lock held
+func (p *PluginState) detect(a Alert, key string, now time.Time) Alert {
+	if p.block > 0 {
+		p.blocked[key] = now.Add(p.block)
+		a.Blocked = true
+	}
+	return a
+}
+
+// sweep forgets the counters of the past windows and the ended blocks, once
+// per window. Must be called with the lock held
+func (p *PluginState) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.window {
+		return
+	}
+	p.lastSweep = now
+	for _, counters := range []map[string]*counter{p.clients, p.ports} {
+		for key, c := range counters {
+			if now.Sub(c.start) >= p.window {
+				delete(counters, key)
+			}
+		}
+	}
+	for key, until := range p.blocked {
+		if !now.Before(until) {
+			delete(p.blocked, key)
+		}
+	}
+}
+
+// raise reports an anomaly, without blocking the DHCP exchange
+func (p *PluginState) raise(a Alert) {
+	p.detected[a.Anomaly].Add(1)
+	offender := a.HWAddr
+	if offender == "" {
+		offender = "circuit " + a.CircuitID + " of relay " + a.Relay.String()
+	}
+	log.Warningf("%s anomaly from %s, count %d, blocked: %v", a.Anomaly, offender, a.Count, a.Blocked)
+	if p.alerts == nil {
+		return
+	}
+	select {
+	case p.alerts <- a:
+	default:
+		log.Warningf("too many pending alerts, dropping the %s alert of %s", a.Anomaly, offender)
+	}
+}
+
+// deliver posts an alert to all the endpoints
+func (p *PluginState) deliver(a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Errorf("could not encode the %s alert: %v", a.Anomaly, err)
+		return
+	}
+	for _, u := range p.urls {
+		resp, err := p.client.Post(u, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Warningf("could not post the %s alert to %s: %v", a.Anomaly, u, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Warningf("could not post the %s alert to %s: unexpected status %s", a.Anomaly, u, resp.Status)
+		}
+	}
+}
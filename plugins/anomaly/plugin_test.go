@@ -0,0 +1,149 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package anomaly
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+var clientMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("window=30s", "client_ids=0", "discovers=10", "block=5m", "url=http://localhost/alerts")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, p.window)
+	assert.Equal(t, 0, p.maxClientIDs)
+	assert.Equal(t, 10, p.maxDiscovers)
+	assert.Equal(t, 5, p.maxDeclines)
+	assert.Equal(t, 5*time.Minute, p.block)
+
+	for _, arg := range []string{"window=0s", "block=x", "declines=-1", "discovers=x", "url=ftp://x", "strict", "foo=1"} {
+		_, err := parseArgs(arg)
+		assert.Error(t, err, arg)
+	}
+}
+
+// newState returns a plugin with unpublished metrics, recording its alerts
+func newState(t *testing.T, args ...string) *PluginState {
+	p, err := parseArgs(args...)
+	require.NoError(t, err)
+	p.detected = map[string]*expvar.Int{
+		ClientIDCycling: new(expvar.Int),
+		DiscoverFlood:   new(expvar.Int),
+		DeclineFlood:    new(expvar.Int),
+	}
+	p.blockedRequests = new(expvar.Int)
+	p.alerts = make(chan Alert, alertQueue)
+	return p
+}
+
+func request(t *testing.T, mt dhcpv4.MessageType, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{
+		dhcpv4.WithHwAddr(clientMAC),
+		dhcpv4.WithMessageType(mt),
+	}, modifiers...)...)
+	require.NoError(t, err)
+	return req
+}
+
+func TestClientIDCycling(t *testing.T) {
+	p := newState(t, "client_ids=2")
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		req := request(t, dhcpv4.MessageTypeDiscover, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{1, byte(i)})))
+		assert.Empty(t, p.inspect(req, now))
+	}
+	require.Len(t, p.alerts, 1)
+	a := <-p.alerts
+	assert.Equal(t, ClientIDCycling, a.Anomaly)
+	assert.Equal(t, clientMAC.String(), a.HWAddr)
+	assert.Equal(t, 3, a.Count)
+	assert.False(t, a.Blocked)
+	assert.Equal(t, int64(1), p.detected[ClientIDCycling].Value())
+
+	// The same identifiers again, then in a new window
+	req := request(t, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{1, 0})))
+	p.inspect(req, now)
+	assert.Len(t, p.alerts, 0)
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		req := request(t, dhcpv4.MessageTypeDiscover, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{1, byte(i)})))
+		p.inspect(req, now)
+	}
+	assert.Len(t, p.alerts, 0)
+}
+
+func TestDiscoverFlood(t *testing.T) {
+	p := newState(t, "discovers=2", "block=10m")
+	now := time.Now()
+	rai := dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1"))))
+	relayed := func(mac net.HardwareAddr) *dhcpv4.DHCPv4 {
+		req := request(t, dhcpv4.MessageTypeDiscover, rai, dhcpv4.WithHwAddr(mac))
+		req.GatewayIPAddr = net.IPv4(10, 1, 0, 1)
+		return req
+	}
+	// From random hardware addresses, on the same port
+	assert.Empty(t, p.inspect(relayed(net.HardwareAddr{2, 0, 0, 0, 0, 1}), now))
+	assert.Empty(t, p.inspect(relayed(net.HardwareAddr{2, 0, 0, 0, 0, 2}), now))
+	assert.Equal(t, handler.ReasonBlockedPort, p.inspect(relayed(net.HardwareAddr{2, 0, 0, 0, 0, 3}), now))
+	require.Len(t, p.alerts, 1)
+	a := <-p.alerts
+	assert.Equal(t, DiscoverFlood, a.Anomaly)
+	assert.Equal(t, "657468302f31", a.CircuitID)
+	assert.True(t, a.Relay.Equal(net.IPv4(10, 1, 0, 1)))
+	assert.True(t, a.Blocked)
+
+	// The port is blocked, other ports and unrelayed clients are not
+	assert.Equal(t, handler.ReasonBlockedPort, p.inspect(relayed(net.HardwareAddr{2, 0, 0, 0, 0, 4}), now.Add(time.Minute)))
+	assert.Equal(t, int64(1), p.blockedRequests.Value())
+	assert.Empty(t, p.inspect(request(t, dhcpv4.MessageTypeDiscover), now))
+	other := relayed(clientMAC)
+	other.GatewayIPAddr = net.IPv4(10, 2, 0, 1)
+	assert.Empty(t, p.inspect(other, now))
+
+	// Until the block ends
+	assert.Empty(t, p.inspect(relayed(net.HardwareAddr{2, 0, 0, 0, 0, 5}), now.Add(10*time.Minute)))
+	assert.Len(t, p.blocked, 0)
+}
+
+func TestDeclineFlood(t *testing.T) {
+	p := newState(t, "declines=1", "block=1m")
+	now := time.Now()
+	assert.Empty(t, p.inspect(request(t, dhcpv4.MessageTypeDecline), now))
+	assert.Equal(t, handler.ReasonBlockedMAC, p.inspect(request(t, dhcpv4.MessageTypeDecline), now))
+	require.Len(t, p.alerts, 1)
+	assert.Equal(t, DeclineFlood, (<-p.alerts).Anomaly)
+	// All the requests of the client are dropped
+	assert.Equal(t, handler.ReasonBlockedMAC, p.inspect(request(t, dhcpv4.MessageTypeRequest), now))
+	assert.Empty(t, p.inspect(request(t, dhcpv4.MessageTypeRequest), now.Add(time.Minute)))
+}
+
+func TestDeliver(t *testing.T) {
+	alerts := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&a))
+		alerts <- a
+	}))
+	defer srv.Close()
+
+	p := newState(t, "url="+srv.URL)
+	p.deliver(Alert{Anomaly: DeclineFlood, Time: time.Now(), HWAddr: clientMAC.String(), Count: 6})
+	a := <-alerts
+	assert.Equal(t, DeclineFlood, a.Anomaly)
+	assert.Equal(t, 6, a.Count)
+}
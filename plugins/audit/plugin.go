@@ -0,0 +1,457 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package audit implements a plugin keeping an audit log of the replies of
+// the server, for compliance: which client got which address when, how the
+// plugins handled its request, the metadata they computed (eg. its class),
+// and the relay agent information (DHCPv4 option 82, DHCPv6 Interface-ID and
+// Remote-ID). The log is separate from the debug logging: it is a file of
+// JSON records, one per line, only ever appended to, eg. to be queried with
+// jq.
+//
+// server4:
+//   plugins:
+//     - audit: file=/var/log/coredhcp/audit4.jsonl key=0x00112233445566778899aabbccddeeff
+//     - server_id: 10.0.0.1
+//     - range: leases.txt 10.0.0.10 10.0.0.100 1h
+//
+// The arguments are:
+// - file=<path>: the audit log (mandatory)
+// - key=<secret>: signs the records, the secret being given in hex with a 0x
+// prefix, or as a string
+//
+// Signed records end with a "mac" field, the HMAC-SHA256 of the record
+// without it, chained with the HMAC of the previous record: records cannot be
+// modified, removed or reordered without the key (see Verify). Signed records
+// cannot be appended to a log of unsigned ones.
+//
+// The records are written once the reply is final, whatever the position of
+// the plugin: it can come first, to see the decisions of all the plugins.
+// Requests without a reply are not recorded.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/audit")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:      "audit",
+	Setup6Ctx: setup6,
+	Setup4Ctx: setup4,
+	Stop:      stop,
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// Record is a line of the audit log
+type Record struct {
+	Time time.Time `json:"time"`
+	// Message and Reply are the message types of the request and the reply
+	Message string `json:"message"`
+	Reply   string `json:"reply"`
+	// HWAddr and ClientID are set for DHCPv4 clients, DUID for DHCPv6
+	// clients
+	HWAddr   string `json:"hwaddr,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	DUID     string `json:"duid,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// Addresses are those given to the client, and the DHCPv6 prefixes
+	// delegated to it, in CIDR notation
+	Addresses []string `json:"addresses,omitempty"`
+	// LeaseTime is the lease time of the first address, in seconds
+	LeaseTime uint32 `json:"lease_time,omitempty"`
+	// Relay is the giaddr, or the link address of the DHCPv6 relay closest
+	// to the client. CircuitID and RemoteID are the Agent Circuit ID and
+	// Agent Remote ID of option 82, or the Interface-ID and Remote-ID of
+	// that relay, in hex
+	Relay     net.IP `json:"relay,omitempty"`
+	CircuitID string `json:"circuit_id,omitempty"`
+	RemoteID  string `json:"remote_id,omitempty"`
+	// Plugins are how the plugins handled the request, in order, with the
+	// reason of the plugin refusing it, if any
+	Plugins []handler.Decision `json:"plugins,omitempty"`
+	// Metadata is the metadata of the request (see handler.Metadata)
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// macField is the field of the HMAC ending the signed records
+const macField = `,"mac":"`
+
+// PluginState holds the audit log of an instance of the plugin
+type PluginState struct {
+	key []byte
+
+	mu sync.Mutex
+	// file is nil once closed
+	file *os.File
+	// last is the HMAC of the last record, chaining the next one
+	last []byte
+}
+
+func parseArgs(args ...string) (string, []byte, error) {
+	var (
+		filename string
+		key      []byte
+	)
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return "", nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch name, value := arg[:sep], arg[sep+1:]; name {
+		case "file":
+			filename = value
+		case "key":
+			key = []byte(value)
+			if strings.HasPrefix(value, "0x") {
+				var err error
+				if key, err = hex.DecodeString(value[2:]); err != nil {
+					return "", nil, fmt.Errorf("invalid key: %v", err)
+				}
+			}
+			if len(key) == 0 {
+				return "", nil, errors.New("empty key")
+			}
+		default:
+			return "", nil, fmt.Errorf("unknown argument %q", name)
+		}
+	}
+	if filename == "" {
+		return "", nil, errors.New("need an audit log file")
+	}
+	return filename, key, nil
+}
+
+// open opens an audit log, for appending records signed with key if not nil
+func open(filename string, key []byte) (*PluginState, error) {
+	p := PluginState{key: key}
+	if key != nil {
+		// Chain with the last record of the existing log
+		last, err := lastMAC(filename)
+		if err != nil {
+			return nil, err
+		}
+		p.last = last
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", filename, err)
+	}
+	p.file = file
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	filename, key, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p, err := open(filename, key)
+	if err != nil {
+		return nil, err
+	}
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("auditing the replies to %s, signed: %v", filename, key != nil)
+	return p, nil
+}
+
+// stop syncs and closes the audit logs of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	var err error
+	for _, p := range instances.list {
+		if cerr := p.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// close syncs and closes the audit log. The records are no longer written
+// afterwards
+func (p *PluginState) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	file := p.file
+	p.file = nil
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("cannot sync audit log %s: %w", file.Name(), err)
+	}
+	return file.Close()
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6Ctx, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// Handler4 records the reply to a DHCPv4 request, once final
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	handler.OnReply4(ctx, func(resp *dhcpv4.DHCPv4) {
+		p.write(record4(ctx, req, resp))
+	})
+	return resp, false
+}
+
+// Handler6 records the reply to a DHCPv6 request, once final
+func (p *PluginState) Handler6(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	handler.OnReply6(ctx, func(resp dhcpv6.DHCPv6) {
+		if r := record6(ctx, req, resp); r != nil {
+			p.write(r)
+		}
+	})
+	return resp, false
+}
+
+// newRecord returns a record with what the plugins decided about a request
+func newRecord(ctx context.Context) *Record {
+	r := Record{Time: time.Now().UTC()}
+	if decisions := handler.Decisions(ctx); len(decisions) > 0 {
+		r.Plugins = append([]handler.Decision(nil), decisions...)
+	}
+	handler.MetadataFromContext(ctx).Range(func(key string, value interface{}) bool {
+		if r.Metadata == nil {
+			r.Metadata = make(map[string]string)
+		}
+		r.Metadata[key] = fmt.Sprint(value)
+		return true
+	})
+	return &r
+}
+
+func record4(ctx context.Context, req, resp *dhcpv4.DHCPv4) *Record {
+	r := newRecord(ctx)
+	r.Message = req.MessageType().String()
+	r.Reply = resp.MessageType().String()
+	r.HWAddr = req.ClientHWAddr.String()
+	if id := req.Options.Get(dhcpv4.OptionClientIdentifier); id != nil {
+		r.ClientID = hex.EncodeToString(id)
+	}
+	r.Hostname = fqdn.HostName4(req)
+	if !resp.YourIPAddr.IsUnspecified() {
+		r.Addresses = []string{resp.YourIPAddr.String()}
+		if resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+			r.LeaseTime = uint32(resp.IPAddressLeaseTime(0) / time.Second)
+		}
+	}
+	if !req.GatewayIPAddr.IsUnspecified() {
+		r.Relay = req.GatewayIPAddr
+	}
+	// The reply may not carry option 82 back, eg. to a VLAN
+	if id := relay.CircuitID4(req); id != nil {
+		r.CircuitID = hex.EncodeToString(id)
+	}
+	if id := relay.RemoteID4(req); id != nil {
+		r.RemoteID = hex.EncodeToString(id)
+	}
+	return r
+}
+
+// record6 returns the record of the reply to a DHCPv6 request, nil if it is
+// not a message
+func record6(ctx context.Context, req, resp dhcpv6.DHCPv6) *Record {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		return nil
+	}
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		return nil
+	}
+	r := newRecord(ctx)
+	r.Message = msg.Type().String()
+	r.Reply = reply.Type().String()
+	if id := msg.Options.ClientID(); id != nil {
+		r.DUID = hex.EncodeToString(id.ToBytes())
+	}
+	if o, err := fqdn.Parse6(msg); err == nil && o != nil {
+		r.Hostname = fqdn.HostName(o.Name)
+	}
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			if r.Addresses == nil {
+				r.LeaseTime = uint32(addr.ValidLifetime / time.Second)
+			}
+			r.Addresses = append(r.Addresses, addr.IPv6Addr.String())
+		}
+	}
+	for _, iapd := range reply.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			if prefix.Prefix == nil {
+				continue
+			}
+			if r.Addresses == nil {
+				r.LeaseTime = uint32(prefix.ValidLifetime / time.Second)
+			}
+			r.Addresses = append(r.Addresses, prefix.Prefix.String())
+		}
+	}
+	if chain := relay.Chain6(req); len(chain) > 0 {
+		r.Relay = relay.LinkAddr6(req)
+		if chain[0].InterfaceID != nil {
+			r.CircuitID = hex.EncodeToString(chain[0].InterfaceID)
+		}
+		if chain[0].RemoteID != nil {
+			r.RemoteID = hex.EncodeToString(chain[0].RemoteID.RemoteID)
+		}
+	}
+	return r
+}
+
+// sign returns the HMAC of a record chained with that of the previous one
+func sign(key, previous, record []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(previous)
+	mac.Write(record)
+	return mac.Sum(nil)
+}
+
+// write appends a record to the audit log
+func (p *PluginState) write(r *Record) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("could not encode the audit record of %s%s: %v", r.HWAddr, r.DUID, err)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		log.Warningf("audit log closed, dropping the record of %s%s", r.HWAddr, r.DUID)
+		return
+	}
+	var mac []byte
+	if p.key != nil {
+		mac = sign(p.key, p.last, line)
+		line = append(line[:len(line)-1], macField+hex.EncodeToString(mac)+`"}`...)
+	}
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("could not write the audit record of %s%s: %v", r.HWAddr, r.DUID, err)
+		return
+	}
+	if mac != nil {
+		p.last = mac
+	}
+}
+
+// splitMAC splits a signed line of the audit log into the record and its
+// HMAC
+func splitMAC(line []byte) ([]byte, []byte, error) {
+	const macLength = sha256.Size * 2
+	end := len(line) - macLength - 2
+	if end < 1 || !bytes.HasSuffix(line, []byte(`"}`)) || !bytes.HasSuffix(line[:end], []byte(macField)) {
+		return nil, nil, errors.New("unsigned record")
+	}
+	mac, err := hex.DecodeString(string(line[end : end+macLength]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed HMAC: %w", err)
+	}
+	record := append(append([]byte(nil), line[:end-len(macField)]...), '}')
+	return record, mac, nil
+}
+
+// lastMAC returns the HMAC of the last record of an audit log, nil if it is
+// empty or does not exist
+func lastMAC(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audit log %s: %w", filename, err)
+	}
+	defer f.Close()
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read audit log %s: %w", filename, err)
+	}
+	if last == nil {
+		return nil, nil
+	}
+	_, mac, err := splitMAC(last)
+	if err != nil {
+		return nil, fmt.Errorf("cannot chain with the last record of %s: %w", filename, err)
+	}
+	return mac, nil
+}
+
+// Verify checks the records of a signed audit log with its key, and returns
+// the number of records. It fails at the first record that was modified,
+// removed, added or reordered
+func Verify(r io.Reader, key []byte) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var (
+		last []byte
+		n    int
+	)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		n++
+		record, mac, err := splitMAC(sc.Bytes())
+		if err != nil {
+			return n - 1, fmt.Errorf("record %d: %w", n, err)
+		}
+		if !hmac.Equal(mac, sign(key, last, record)) {
+			return n - 1, fmt.Errorf("record %d: invalid HMAC", n)
+		}
+		last = mac
+	}
+	return n, sc.Err()
+}
@@ -0,0 +1,173 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var clientMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestParseArgs(t *testing.T) {
+	filename, key, err := parseArgs("file=audit.jsonl", "key=0x0011")
+	require.NoError(t, err)
+	assert.Equal(t, "audit.jsonl", filename)
+	assert.Equal(t, []byte{0, 0x11}, key)
+	_, key, err = parseArgs("file=audit.jsonl", "key=secret")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	for _, args := range [][]string{{}, {"key=secret"}, {"file=a", "key="}, {"file=a", "key=0xzz"}, {"file=a", "sign"}, {"file=a", "foo=1"}} {
+		_, _, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "coredhcp-audit")
+	require.NoError(t, err)
+	return filepath.Join(dir, "audit.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+// exchange4 runs a DHCPv4 request through the plugin, as the core does
+func exchange4(t *testing.T, p *PluginState, req *dhcpv4.DHCPv4, ip net.IP) {
+	ctx := handler.NewContext(context.Background())
+	handler.MetadataFromContext(ctx).Set("class.name", "pxe")
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(ip),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	require.NoError(t, err)
+	resp, _ = p.Handler4(ctx, req, resp)
+	handler.RecordDecision(ctx, "audit", "passed")
+	handler.RecordDecision(ctx, "range", "served")
+	handler.FinishReply4(ctx, resp)
+}
+
+func request4(t *testing.T) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(clientMAC),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{1, 0xaa})),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+			dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte{1, 2}),
+		)),
+	)
+	require.NoError(t, err)
+	req.GatewayIPAddr = net.IPv4(10, 1, 0, 1)
+	return req
+}
+
+func TestRecord4(t *testing.T) {
+	filename, cleanup := tempLog(t)
+	defer cleanup()
+	p, err := open(filename, nil)
+	require.NoError(t, err)
+	exchange4(t, p, request4(t), net.IPv4(10, 1, 0, 10))
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	var r Record
+	require.NoError(t, json.Unmarshal(data, &r))
+	assert.Equal(t, "REQUEST", r.Message)
+	assert.Equal(t, "ACK", r.Reply)
+	assert.Equal(t, clientMAC.String(), r.HWAddr)
+	assert.Equal(t, "01aa", r.ClientID)
+	assert.Equal(t, []string{"10.1.0.10"}, r.Addresses)
+	assert.Equal(t, uint32(3600), r.LeaseTime)
+	assert.True(t, r.Relay.Equal(net.IPv4(10, 1, 0, 1)))
+	assert.Equal(t, "657468302f31", r.CircuitID)
+	assert.Equal(t, "0102", r.RemoteID)
+	assert.Equal(t, []handler.Decision{{Plugin: "audit", Outcome: "passed"}, {Plugin: "range", Outcome: "served"}}, r.Plugins)
+	assert.Equal(t, map[string]string{"class.name": "pxe"}, r.Metadata)
+	assert.NotContains(t, string(data), `"mac"`)
+}
+
+func TestRecord6(t *testing.T) {
+	solicit, err := dhcpv6.NewSolicit(clientMAC, dhcpv6.WithRapidCommit)
+	require.NoError(t, err)
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayed.AddOption(dhcpv6.OptInterfaceID([]byte("eth1")))
+	reply, err := dhcpv6.NewReplyFromMessage(solicit)
+	require.NoError(t, err)
+	reply.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8:1::10"), ValidLifetime: time.Hour},
+	}}})
+
+	r := record6(handler.NewContext(context.Background()), relayed, reply)
+	require.NotNil(t, r)
+	assert.Equal(t, "SOLICIT", r.Message)
+	assert.Equal(t, "REPLY", r.Reply)
+	assert.NotEmpty(t, r.DUID)
+	assert.Equal(t, []string{"2001:db8:1::10"}, r.Addresses)
+	assert.Equal(t, uint32(3600), r.LeaseTime)
+	assert.True(t, r.Relay.Equal(net.ParseIP("2001:db8:1::1")))
+	assert.Equal(t, "65746831", r.CircuitID)
+}
+
+func TestSigned(t *testing.T) {
+	filename, cleanup := tempLog(t)
+	defer cleanup()
+	key := []byte("secret")
+	p, err := open(filename, key)
+	require.NoError(t, err)
+	exchange4(t, p, request4(t), net.IPv4(10, 1, 0, 10))
+	exchange4(t, p, request4(t), net.IPv4(10, 1, 0, 11))
+	require.NoError(t, p.close())
+	// Closed, the records are dropped
+	exchange4(t, p, request4(t), net.IPv4(10, 1, 0, 99))
+	// Reopened, eg. after a restart, the chain goes on
+	p, err = open(filename, key)
+	require.NoError(t, err)
+	exchange4(t, p, request4(t), net.IPv4(10, 1, 0, 12))
+	require.NoError(t, p.close())
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	n, err := Verify(bytes.NewReader(data), key)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = Verify(bytes.NewReader(data), []byte("other"))
+	assert.Error(t, err)
+
+	lines := strings.SplitAfter(string(data), "\n")
+	// Modified
+	tampered := strings.Replace(string(data), "10.1.0.11", "10.1.0.99", 1)
+	n, err = Verify(strings.NewReader(tampered), key)
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	// Removed, or reordered
+	_, err = Verify(strings.NewReader(lines[0]+lines[2]), key)
+	assert.Error(t, err)
+	_, err = Verify(strings.NewReader(lines[1]+lines[0]+lines[2]), key)
+	assert.Error(t, err)
+	// Truncated at the end, which the chain can't tell
+	n, err = Verify(strings.NewReader(lines[0]+lines[1]), key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// An unsigned log can't be chained with
+	require.NoError(t, ioutil.WriteFile(filename, []byte(lines[0][:len(lines[0])-80]+"}\n"), 0600))
+	_, err = open(filename, key)
+	assert.Error(t, err)
+}
@@ -0,0 +1,310 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package auth implements the DHCPv4 Authentication option (RFC3118) with
+// the delayed authentication protocol and shared keys: the requests carrying
+// the option are authenticated, and their replies signed with the same key.
+//
+// server4:
+//   plugins:
+//     - auth: key=1:0x00112233445566778899aabbccddeeff key=2:secret require@link:10.1.0.0/16
+//     - server_id: 10.0.0.1
+//     - range: leases.txt 10.0.0.10 10.0.0.100 1h
+//
+// The arguments are:
+// - key=<secret id>:<secret>: a shared key, the secret being given in hex
+// with a 0x prefix, or as a string. The first key signs the replies to the
+// clients starting an authenticated exchange, with a DISCOVER carrying the
+// option without authentication information
+// - require: drops the requests of the clients not authenticating
+// - require@<class>: drops the requests of the clients of a class (see the
+// class package) not authenticating, eg. those of a locked-down segment
+//
+// A request with an invalid or replayed authentication is dropped
+// (RFC3118 §5.3). The HMAC covers the request as received, with the giaddr and
+// hops fields zeroed: relay agents must not modify the other fields nor the
+// options. The plugin should come first, to drop requests before the other
+// plugins handle them.
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+)
+
+var log = logger.GetLogger("plugins/auth")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:      "auth",
+	Setup4Ctx: setup4,
+}
+
+// optionAuthentication is the DHCPv4 Authentication option (RFC3118 §2)
+var optionAuthentication = dhcpv4.GenericOptionCode(90)
+
+// Authentication option fields of the delayed authentication protocol
+// (RFC3118 §2 and §5)
+const (
+	protocolDelayed  = 2
+	algorithmHMACMD5 = 1
+	rdmMonotonic     = 0
+	// headerLength is the length of the protocol, algorithm, RDM and
+	// replay detection fields
+	headerLength = 11
+	// secretIDLength is the length of the secret ID in the authentication
+	// information, followed by the HMAC
+	secretIDLength = 4
+)
+
+// PluginState holds the keys and the replay detection state of an instance
+// of the plugin
+type PluginState struct {
+	keys map[uint32][]byte
+	// defaultID is the ID of the key signing the replies to the clients
+	// starting an authenticated exchange
+	defaultID uint32
+	// requireAll requires all the clients to authenticate, require those
+	// of some classes
+	requireAll bool
+	require    []*class.Matcher
+
+	sync.Mutex
+	// replays are the last replay detection values of the clients, by
+	// hardware address
+	replays map[string]uint64
+	// lastReplay is the last replay detection value of the replies, based
+	// on the time to increase monotonically across restarts
+	lastReplay uint64
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{keys: make(map[uint32][]byte), replays: make(map[string]uint64)}
+	for _, arg := range args {
+		switch {
+		case arg == "require":
+			p.requireAll = true
+		case strings.HasPrefix(arg, "require@"):
+			m, err := class.Parse(strings.TrimPrefix(arg, "require@"))
+			if err != nil {
+				return nil, err
+			}
+			p.require = append(p.require, m)
+		case strings.HasPrefix(arg, "key="):
+			value := strings.TrimPrefix(arg, "key=")
+			sep := strings.IndexByte(value, ':')
+			if sep < 0 {
+				return nil, fmt.Errorf("invalid key %q, expected <secret id>:<secret>", value)
+			}
+			id, err := strconv.ParseUint(value[:sep], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid secret id %q", value[:sep])
+			}
+			secret := []byte(value[sep+1:])
+			if bytes.HasPrefix(secret, []byte("0x")) {
+				if secret, err = hex.DecodeString(value[sep+3:]); err != nil {
+					return nil, fmt.Errorf("invalid secret for id %d: %v", id, err)
+				}
+			}
+			if len(secret) == 0 {
+				return nil, fmt.Errorf("empty secret for id %d", id)
+			}
+			if _, ok := p.keys[uint32(id)]; ok {
+				return nil, fmt.Errorf("duplicate secret id %d", id)
+			}
+			if len(p.keys) == 0 {
+				p.defaultID = uint32(id)
+			}
+			p.keys[uint32(id)] = secret
+		default:
+			return nil, fmt.Errorf("unknown argument %q", arg)
+		}
+	}
+	if len(p.keys) == 0 {
+		return nil, errors.New("need at least one key")
+	}
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded plugin for DHCPv4 with %d keys", len(p.keys))
+	return p.Handler4, nil
+}
+
+// required returns whether a client must authenticate
+func (p *PluginState) required(req *dhcpv4.DHCPv4) bool {
+	if p.requireAll {
+		return true
+	}
+	for _, m := range p.require {
+		if m.Match4(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler4 authenticates the requests, and signs their replies
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	opt := req.Options.Get(optionAuthentication)
+	if opt == nil {
+		if p.required(req) {
+			log.Infof("dropping unauthenticated request from %s", req.ClientHWAddr)
+			handler.Refuse(ctx, handler.ReasonUnauthenticated)
+			return nil, true
+		}
+		return resp, false
+	}
+	realm, id, err := p.authenticate(req, handler.RawRequest(ctx))
+	if err != nil {
+		log.Warningf("dropping request from %s: %v", req.ClientHWAddr, err)
+		handler.Refuse(ctx, handler.ReasonUnauthenticated)
+		return nil, true
+	}
+	handler.OnReply4(ctx, func(resp *dhcpv4.DHCPv4) {
+		p.sign(resp, realm, id)
+	})
+	return resp, false
+}
+
+// authenticate checks the Authentication option of a request, given as
+// received if raw is not nil, and returns its realm and secret ID
+func (p *PluginState) authenticate(req *dhcpv4.DHCPv4, raw []byte) ([]byte, uint32, error) {
+	opt := req.Options.Get(optionAuthentication)
+	if len(opt) < headerLength {
+		return nil, 0, fmt.Errorf("authentication option of length %d", len(opt))
+	}
+	if opt[0] != protocolDelayed || opt[1] != algorithmHMACMD5 || opt[2] != rdmMonotonic {
+		return nil, 0, fmt.Errorf("unsupported authentication protocol %d, algorithm %d, RDM %d", opt[0], opt[1], opt[2])
+	}
+	info := opt[headerLength:]
+	if len(info) == 0 {
+		// RFC3118 §5.1: a DISCOVER only asks for authentication
+		if req.MessageType() != dhcpv4.MessageTypeDiscover {
+			return nil, 0, errors.New("no authentication information")
+		}
+		return nil, p.defaultID, nil
+	}
+	if len(info) < secretIDLength+md5.Size {
+		return nil, 0, fmt.Errorf("authentication information of length %d", len(info))
+	}
+	realm := info[:len(info)-secretIDLength-md5.Size]
+	id := binary.BigEndian.Uint32(info[len(realm):])
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown secret id %d", id)
+	}
+	if raw == nil {
+		raw = req.ToBytes()
+	}
+	digest, received, ok := hmacOf(raw, key)
+	if !ok {
+		return nil, 0, errors.New("cannot find the authentication option")
+	}
+	if !hmac.Equal(digest, received) {
+		return nil, 0, errors.New("invalid HMAC")
+	}
+
+	replay := binary.BigEndian.Uint64(opt[3:headerLength])
+	p.Lock()
+	defer p.Unlock()
+	client := string(req.ClientHWAddr)
+	if last, ok := p.replays[client]; ok && replay <= last {
+		return nil, 0, fmt.Errorf("replayed request, replay detection %d not after %d", replay, last)
+	}
+	p.replays[client] = replay
+	return realm, id, nil
+}
+
+// hmacOf returns the HMAC-MD5 of an encoded message, computed with the hops
+// and giaddr fields and the HMAC of the Authentication option set to zero
+// (RFC3118 §5.2), the HMAC of the option, and whether the message has an
+// Authentication option
+func hmacOf(msg, key []byte) ([]byte, []byte, bool) {
+	// The fixed header, the magic cookie, then the options
+	const optionsOffset = 240
+	if len(msg) < optionsOffset {
+		return nil, nil, false
+	}
+	buf := append([]byte(nil), msg...)
+	buf[3] = 0
+	copy(buf[24:28], []byte{0, 0, 0, 0})
+	var received []byte
+	for i := optionsOffset; i < len(buf) && buf[i] != 255; {
+		if buf[i] == 0 {
+			i++
+			continue
+		}
+		if i+1 >= len(buf) {
+			return nil, nil, false
+		}
+		code, length := buf[i], int(buf[i+1])
+		if i+2+length > len(buf) {
+			return nil, nil, false
+		}
+		if code == optionAuthentication.Code() && length >= headerLength+secretIDLength+md5.Size {
+			field := buf[i+2+length-md5.Size : i+2+length]
+			received = append([]byte(nil), field...)
+			copy(field, make([]byte, md5.Size))
+		}
+		i += 2 + length
+	}
+	if received == nil {
+		return nil, nil, false
+	}
+	mac := hmac.New(md5.New, key)
+	mac.Write(buf)
+	return mac.Sum(nil), received, true
+}
+
+// nextReplay returns a new replay detection value
+func (p *PluginState) nextReplay() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	replay := uint64(time.Now().UnixNano())
+	if replay <= p.lastReplay {
+		replay = p.lastReplay + 1
+	}
+	p.lastReplay = replay
+	return replay
+}
+
+// sign adds an Authentication option to a reply, with the key of the given
+// secret ID
+func (p *PluginState) sign(resp *dhcpv4.DHCPv4, realm []byte, id uint32) {
+	data := make([]byte, headerLength+len(realm)+secretIDLength+md5.Size)
+	data[0] = protocolDelayed
+	data[1] = algorithmHMACMD5
+	data[2] = rdmMonotonic
+	binary.BigEndian.PutUint64(data[3:headerLength], p.nextReplay())
+	copy(data[headerLength:], realm)
+	binary.BigEndian.PutUint32(data[headerLength+len(realm):], id)
+	resp.UpdateOption(dhcpv4.OptGeneric(optionAuthentication, data))
+	// The option keeps its length, so the reply is encoded the same once
+	// the HMAC is set
+	digest, _, _ := hmacOf(resp.ToBytes(), p.keys[id])
+	copy(data[len(data)-md5.Size:], digest)
+	resp.UpdateOption(dhcpv4.OptGeneric(optionAuthentication, data))
+}
@@ -0,0 +1,178 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package auth
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+var clientMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("key=7:0x0011", "key=1:secret", "require@link:10.1.0.0/16")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), p.defaultID)
+	assert.Equal(t, []byte{0, 0x11}, p.keys[7])
+	assert.Equal(t, []byte("secret"), p.keys[1])
+	assert.False(t, p.requireAll)
+	assert.Len(t, p.require, 1)
+
+	for _, args := range [][]string{
+		{},
+		{"require"},
+		{"key=1"},
+		{"key=x:secret"},
+		{"key=1:"},
+		{"key=1:0xzz"},
+		{"key=1:a", "key=1:b"},
+		{"key=1:a", "require@nope"},
+		{"key=1:a", "strict"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+// authOption returns the Authentication option of a client with the given
+// secret ID, the HMAC to be set by authenticated
+func authOption(replay uint64, id uint32) dhcpv4.Option {
+	data := make([]byte, headerLength+secretIDLength+md5.Size)
+	data[0], data[1], data[2] = protocolDelayed, algorithmHMACMD5, rdmMonotonic
+	binary.BigEndian.PutUint64(data[3:], replay)
+	binary.BigEndian.PutUint32(data[headerLength:], id)
+	return dhcpv4.OptGeneric(optionAuthentication, data)
+}
+
+// authenticated sets the HMAC of the Authentication option of an encoded
+// message, as a client does
+func authenticated(t *testing.T, msg, key []byte) []byte {
+	digest, _, ok := hmacOf(msg, key)
+	require.True(t, ok)
+	out := append([]byte(nil), msg...)
+	i := 240
+	for out[i] != optionAuthentication.Code() {
+		if out[i] == 0 {
+			i++
+			continue
+		}
+		i += 2 + int(out[i+1])
+	}
+	end := i + 2 + int(out[i+1])
+	copy(out[end-md5.Size:end], digest)
+	return out
+}
+
+func request(t *testing.T, replay uint64, id uint32) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(clientMAC),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(authOption(replay, id)),
+	)
+	require.NoError(t, err)
+	return req
+}
+
+func handle(p *PluginState, req *dhcpv4.DHCPv4, raw []byte) *dhcpv4.DHCPv4 {
+	ctx := handler.NewContext(context.Background())
+	handler.SetRawRequest(ctx, raw)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		panic(err)
+	}
+	resp, _ = p.Handler4(ctx, req, resp)
+	if resp != nil {
+		handler.FinishReply4(ctx, resp)
+	}
+	return resp
+}
+
+func TestAuthenticate(t *testing.T) {
+	p, err := parseArgs("key=1:first", "key=2:second")
+	require.NoError(t, err)
+
+	req := request(t, 1, 2)
+	raw := authenticated(t, req.ToBytes(), []byte("second"))
+	resp := handle(p, req, raw)
+	require.NotNil(t, resp)
+
+	// The reply is signed with the key of the client
+	opt := resp.Options.Get(optionAuthentication)
+	require.Len(t, opt, headerLength+secretIDLength+md5.Size)
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(opt[headerLength:]))
+	digest, received, ok := hmacOf(resp.ToBytes(), []byte("second"))
+	require.True(t, ok)
+	assert.Equal(t, digest, received)
+
+	// Replayed
+	assert.Nil(t, handle(p, req, raw))
+	req = request(t, 2, 2)
+	assert.NotNil(t, handle(p, req, authenticated(t, req.ToBytes(), []byte("second"))))
+
+	// Signed with another key, tampered, or with an unknown key
+	req = request(t, 3, 2)
+	assert.Nil(t, handle(p, req, authenticated(t, req.ToBytes(), []byte("first"))))
+	req = request(t, 4, 2)
+	raw = authenticated(t, req.ToBytes(), []byte("second"))
+	raw[10] ^= 0x80
+	assert.Nil(t, handle(p, req, raw))
+	req = request(t, 5, 3)
+	assert.Nil(t, handle(p, req, authenticated(t, req.ToBytes(), []byte("second"))))
+
+	// The relay agents change the giaddr and hops
+	req = request(t, 6, 2)
+	raw = authenticated(t, req.ToBytes(), []byte("second"))
+	raw[3] = 1
+	copy(raw[24:28], net.IPv4(10, 1, 0, 1).To4())
+	assert.NotNil(t, handle(p, req, raw))
+
+	// The HMAC covers the request as received, eg. with padding
+	req = request(t, 7, 2)
+	raw = authenticated(t, append(req.ToBytes(), 0, 0, 0, 0), []byte("second"))
+	assert.NotNil(t, handle(p, req, raw))
+	req = request(t, 8, 2)
+	raw = authenticated(t, append(req.ToBytes(), 0, 0, 0, 0), []byte("second"))
+	assert.Nil(t, handle(p, req, nil), "verified without the request as received")
+}
+
+func TestDiscover(t *testing.T) {
+	p, err := parseArgs("key=1:first", "key=2:second", "require@link:10.1.0.0/16")
+	require.NoError(t, err)
+
+	discover, err := dhcpv4.NewDiscovery(clientMAC)
+	require.NoError(t, err)
+	// Not required outside of the locked-down segment
+	resp := handle(p, discover, nil)
+	require.NotNil(t, resp)
+	assert.Nil(t, resp.Options.Get(optionAuthentication))
+	discover.GatewayIPAddr = net.IPv4(10, 1, 0, 1)
+	assert.Nil(t, handle(p, discover, nil))
+
+	// Starting an authenticated exchange, the reply signed with the first
+	// key
+	discover.UpdateOption(dhcpv4.OptGeneric(optionAuthentication, []byte{protocolDelayed, algorithmHMACMD5, rdmMonotonic, 0, 0, 0, 0, 0, 0, 0, 1}))
+	resp = handle(p, discover, nil)
+	require.NotNil(t, resp)
+	opt := resp.Options.Get(optionAuthentication)
+	require.NotNil(t, opt)
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(opt[headerLength:]))
+	digest, received, ok := hmacOf(resp.ToBytes(), []byte("first"))
+	require.True(t, ok)
+	assert.Equal(t, digest, received)
+
+	// Only the DISCOVER may come without authentication information
+	req := request(t, 1, 1)
+	req.UpdateOption(dhcpv4.OptGeneric(optionAuthentication, []byte{protocolDelayed, algorithmHMACMD5, rdmMonotonic, 0, 0, 0, 0, 0, 0, 0, 1}))
+	assert.Nil(t, handle(p, req, nil))
+}
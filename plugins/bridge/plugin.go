@@ -0,0 +1,300 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package bridge implements a plugin handing the requests to an external
+// plugin, a program run by the server, eg. to write plugins in other
+// languages than Go, or to deploy them without rebuilding CoreDHCP.
+//
+// External plugins are go-plugin (https://github.com/hashicorp/go-plugin)
+// gRPC plugins, serving the Plugin service of proto/bridge.proto with the
+// Handshake below. Plugins written in Go call Serve with a plugins.Plugin, as
+// for the plugins built in CoreDHCP. The others follow the go-plugin
+// handshake: the program is run with COREDHCP_PLUGIN=bridge in its
+// environment, and prints "1|1|unix|<socket path>|grpc" (or
+// "1|1|tcp|<address>:<port>|grpc") on its standard output when it listens.
+// It must also serve the grpc.health.v1.Health service, for the "plugin"
+// service name.
+//
+// Requests and responses are passed encoded as on the wire, with the metadata
+// of the request holding strings (see handler.Metadata). The plugin answers
+// with the response to pass to the next plugins, or none to drop the
+// request, and whether to stop the chain.
+//
+// Arguments are the settings of the bridge, then the path of the program,
+// then the arguments of the external plugin:
+// - timeout=<duration>: how long the plugin can take to handle a request, 2s
+// by default
+// - on-error=<continue|drop>: what to do when the plugin fails to handle a
+// request: pass the response as it is to the next plugins (by default), or
+// drop the request
+//
+// The program is set up when the server starts, and restarted if it exits. It
+// is killed when the server exits:
+//
+// server4:
+//   plugins:
+//     - bridge: timeout=500ms /usr/lib/coredhcp/inventory.py https://inventory.example.org
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/bridge/proto"
+)
+
+var log = logger.GetLogger("plugins/bridge")
+
+// Plugin wraps the bridge plugin information.
+var Plugin = plugins.Plugin{
+	Name:      "bridge",
+	Setup6Ctx: setup6,
+	Setup4Ctx: setup4,
+}
+
+// restartDelay is the minimum time between two starts of an external plugin
+var restartDelay = 5 * time.Second
+
+// PluginState holds the configuration of an instance of the plugin, and the
+// external plugin it runs
+type PluginState struct {
+	version uint32
+	program string
+	args    []string
+	timeout time.Duration
+	drop    bool
+	logger  hclog.Logger
+
+	mu      sync.Mutex
+	client  *plugin.Client
+	conn    proto.PluginClient
+	started time.Time
+}
+
+func parseArgs(version uint32, args ...string) (*PluginState, error) {
+	p := PluginState{version: version, timeout: 2 * time.Second}
+	for i, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			// The program, and its arguments
+			p.program, p.args = arg, args[i+1:]
+			break
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			p.timeout = timeout
+		case "on-error":
+			switch value {
+			case "continue":
+				p.drop = false
+			case "drop":
+				p.drop = true
+			default:
+				return nil, fmt.Errorf("invalid on-error %q, expected continue or drop", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.program == "" {
+		return nil, errors.New("need the path of the program to run")
+	}
+	return &p, nil
+}
+
+// start runs the external plugin and sets it up. It must be called with mu
+// held
+func (p *PluginState) start() error {
+	cmd := exec.Command(p.program)
+	setParentDeath(cmd)
+	p.client = plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          pluginSet(nil),
+		Cmd:              cmd,
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Stderr:           os.Stderr,
+		Logger:           p.logger,
+	})
+	p.started = time.Now()
+	rpc, err := p.client.Client()
+	if err != nil {
+		return fmt.Errorf("cannot start %s: %w", p.program, err)
+	}
+	raw, err := rpc.Dispense(pluginName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.program, err)
+	}
+	conn := raw.(proto.PluginClient)
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if _, err := conn.Setup(ctx, &proto.SetupRequest{Args: p.args, Version: p.version}); err != nil {
+		return fmt.Errorf("cannot set %s up: %w", p.program, err)
+	}
+	p.conn = conn
+	return nil
+}
+
+// connect returns the connection to the external plugin, restarting it if it
+// exited
+func (p *PluginState) connect() (proto.PluginClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil && !p.client.Exited() {
+		return p.conn, nil
+	}
+	if time.Since(p.started) < restartDelay {
+		return nil, fmt.Errorf("%s is not running", p.program)
+	}
+	log.Warningf("%s exited, restarting it", p.program)
+	p.client.Kill()
+	p.conn = nil
+	if err := p.start(); err != nil {
+		p.client.Kill()
+		return nil, err
+	}
+	return p.conn, nil
+}
+
+// handle passes a request to the external plugin
+func (p *PluginState) handle(ctx context.Context, req, resp []byte) (*proto.HandleResponse, error) {
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	in := proto.HandleRequest{Request: req, Response: resp}
+	handler.MetadataFromContext(ctx).Range(func(key string, value interface{}) bool {
+		if s, ok := value.(string); ok {
+			if in.Metadata == nil {
+				in.Metadata = make(map[string]string)
+			}
+			in.Metadata[key] = s
+		}
+		return true
+	})
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var out *proto.HandleResponse
+	if p.version == 4 {
+		out, err = conn.Handle4(ctx, &in)
+	} else {
+		out, err = conn.Handle6(ctx, &in)
+	}
+	if err != nil {
+		if status.Code(err) == codes.Unavailable {
+			// Exiting, restart it for the next requests
+			p.mu.Lock()
+			if p.conn == conn {
+				p.conn = nil
+			}
+			p.mu.Unlock()
+		}
+		return nil, fmt.Errorf("%s: %w", p.program, err)
+	}
+	md := handler.MetadataFromContext(ctx)
+	for key, value := range out.Metadata {
+		md.Set(key, value)
+	}
+	return out, nil
+}
+
+// Handler6 handles DHCPv6 packets for the bridge plugin
+func (p *PluginState) Handler6(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	out, err := p.handle(ctx, req.ToBytes(), resp.ToBytes())
+	if err == nil && len(out.Response) == 0 {
+		return nil, true
+	}
+	var d dhcpv6.DHCPv6
+	if err == nil {
+		d, err = dhcpv6.FromBytes(out.Response)
+	}
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	return d, out.Stop
+}
+
+// Handler4 handles DHCPv4 packets for the bridge plugin
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	out, err := p.handle(ctx, req.ToBytes(), resp.ToBytes())
+	if err == nil && len(out.Response) == 0 {
+		return nil, true
+	}
+	var d *dhcpv4.DHCPv4
+	if err == nil {
+		d, err = dhcpv4.FromBytes(out.Response)
+	}
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	return d, out.Stop
+}
+
+func setup(version uint32, args ...string) (*PluginState, error) {
+	p, err := parseArgs(version, args...)
+	if err != nil {
+		return nil, err
+	}
+	// go-plugin logs the errors of the plugin, not handled by the bridge
+	p.logger = hclog.New(&hclog.LoggerOptions{
+		Name:   p.program,
+		Level:  hclog.Warn,
+		Output: log.WriterLevel(logrus.WarnLevel),
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.start(); err != nil {
+		p.client.Kill()
+		return nil, err
+	}
+	log.Printf("DHCPv%d: running %s", version, p.program)
+	return p, nil
+}
+
+func setup6(args ...string) (handler.Handler6Ctx, error) {
+	p, err := setup(6, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := setup(4, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
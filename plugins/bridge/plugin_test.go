@@ -0,0 +1,144 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package bridge
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// The test binary is its own external plugin, when run by the bridge
+func TestMain(m *testing.M) {
+	if os.Getenv(Handshake.MagicCookieKey) == Handshake.MagicCookieValue {
+		Serve(&testPlugin)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testPlugin sets the boot file name to its argument, or the client MAC
+// address to the value of the "test.mac" metadata, and exits on requests from
+// de:ad:be:ef:ff:ff
+var testPlugin = plugins.Plugin{
+	Name: "test",
+	Setup4Ctx: func(args ...string) (handler.Handler4Ctx, error) {
+		return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			switch req.ClientHWAddr.String() {
+			case "de:ad:be:ef:ff:ff":
+				os.Exit(1)
+			case "de:ad:be:ef:00:01":
+				return nil, true
+			}
+			md := handler.MetadataFromContext(ctx)
+			if v, ok := md.Get("test.mac"); ok {
+				resp.BootFileName = v.(string)
+			} else {
+				resp.BootFileName = args[0]
+			}
+			md.Set("test.file", resp.BootFileName)
+			return resp, true
+		}, nil
+	},
+	Setup6: func(args ...string) (handler.Handler6, error) {
+		return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+			resp.AddOption(dhcpv6.OptBootFileURL(args[0]))
+			return resp, false
+		}, nil
+	},
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs(4, "timeout=1s", "on-error=drop", "/usr/lib/coredhcp/plugin", "timeout=2s", "arg")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/lib/coredhcp/plugin", p.program)
+	assert.Equal(t, []string{"timeout=2s", "arg"}, p.args)
+	assert.True(t, p.drop)
+
+	for _, args := range [][]string{
+		{},
+		{"timeout=1s"},
+		{"timeout=-1s", "plugin"},
+		{"on-error=retry", "plugin"},
+		{"retries=1", "plugin"},
+	} {
+		_, err := parseArgs(4, args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestBridge4(t *testing.T) {
+	h4, err := setup4(os.Args[0], "pxelinux.0")
+	require.NoError(t, err)
+
+	handle := func(mac net.HardwareAddr, md map[string]string) (*dhcpv4.DHCPv4, bool, *handler.Metadata) {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		for k, v := range md {
+			handler.MetadataFromContext(ctx).Set(k, v)
+		}
+		resp, stop := h4(ctx, req, resp)
+		return resp, stop, handler.MetadataFromContext(ctx)
+	}
+
+	resp, stop, md := handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}, nil)
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, "pxelinux.0", resp.BootFileName)
+	v, _ := md.Get("test.file")
+	assert.Equal(t, "pxelinux.0", v)
+
+	// The metadata goes both ways
+	resp, _, md = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}, map[string]string{"test.mac": "ipxe.efi"})
+	require.NotNil(t, resp)
+	assert.Equal(t, "ipxe.efi", resp.BootFileName)
+	v, _ = md.Get("test.file")
+	assert.Equal(t, "ipxe.efi", v)
+
+	// Dropped
+	resp, stop, _ = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 1}, nil)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	// The plugin exits: the response is passed on as it is, and the plugin
+	// restarted for the next requests
+	restartDelay = 0
+	resp, stop, _ = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0xff, 0xff}, nil)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, "", resp.BootFileName)
+	resp, _, _ = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}, nil)
+	require.NotNil(t, resp)
+	assert.Equal(t, "pxelinux.0", resp.BootFileName)
+}
+
+func TestBridge6(t *testing.T) {
+	h6, err := setup6("timeout=5s", os.Args[0], "http://[2001:db8::1]/boot.efi")
+	require.NoError(t, err)
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	advertise, err := dhcpv6.NewAdvertiseFromSolicit(solicit)
+	require.NoError(t, err)
+
+	resp, stop := h6(handler.NewContext(context.Background()), solicit, advertise)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	msg, err := resp.GetInnerMessage()
+	require.NoError(t, err)
+	assert.Equal(t, dhcpv6.MessageTypeAdvertise, msg.Type())
+	assert.Equal(t, "http://[2001:db8::1]/boot.efi", msg.Options.BootFileURL())
+}
@@ -0,0 +1,15 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package bridge
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setParentDeath has the external plugin killed when the server exits
+func setParentDeath(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,14 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !linux
+
+package bridge
+
+import (
+	"os/exec"
+)
+
+// setParentDeath does nothing: the external plugins outlive the server
+func setParentDeath(cmd *exec.Cmd) {}
@@ -0,0 +1,180 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"google.golang.org/grpc"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/bridge/proto"
+)
+
+// Handshake is the go-plugin handshake of the external plugins. The protocol
+// version changes with incompatible changes to proto/bridge.proto
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "COREDHCP_PLUGIN",
+	MagicCookieValue: "bridge",
+}
+
+// pluginName is the name of the Plugin service for go-plugin
+const pluginName = "plugin"
+
+// grpcPlugin is the go-plugin side of the Plugin service, serving it with
+// server in the external plugins
+type grpcPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	server proto.PluginServer
+}
+
+func (g *grpcPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	proto.RegisterPluginServer(s, g.server)
+	return nil
+}
+
+func (g *grpcPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return proto.NewPluginClient(c), nil
+}
+
+func pluginSet(server proto.PluginServer) plugin.PluginSet {
+	return plugin.PluginSet{pluginName: &grpcPlugin{server: server}}
+}
+
+// Serve serves a plugin as an external plugin, from the main function of its
+// program. The plugin is set up and used as it would be in CoreDHCP, from
+// the arguments given to the bridge plugin after the path of the program.
+// Serve does not return until the server stops the plugin
+func Serve(p *plugins.Plugin) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         pluginSet(&server{plugin: p}),
+		GRPCServer:      plugin.DefaultGRPCServer,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Level:      hclog.Warn,
+			Output:     os.Stderr,
+			JSONFormat: true,
+		}),
+	})
+}
+
+// server serves the Plugin service with a plugin
+type server struct {
+	proto.UnimplementedPluginServer
+	plugin *plugins.Plugin
+	h4     handler.Handler4Ctx
+	h6     handler.Handler6Ctx
+}
+
+func (s *server) Setup(_ context.Context, in *proto.SetupRequest) (*proto.SetupResponse, error) {
+	var err error
+	switch in.Version {
+	case 4:
+		switch {
+		case s.plugin.Setup4Ctx != nil:
+			s.h4, err = s.plugin.Setup4Ctx(in.Args...)
+		case s.plugin.Setup4 != nil:
+			var h4 handler.Handler4
+			if h4, err = s.plugin.Setup4(in.Args...); h4 != nil {
+				s.h4 = handler.Adapt4(h4)
+			}
+		default:
+			return nil, fmt.Errorf("plugin `%s` has no setup function for DHCPv4", s.plugin.Name)
+		}
+	case 6:
+		switch {
+		case s.plugin.Setup6Ctx != nil:
+			s.h6, err = s.plugin.Setup6Ctx(in.Args...)
+		case s.plugin.Setup6 != nil:
+			var h6 handler.Handler6
+			if h6, err = s.plugin.Setup6(in.Args...); h6 != nil {
+				s.h6 = handler.Adapt6(h6)
+			}
+		default:
+			return nil, fmt.Errorf("plugin `%s` has no setup function for DHCPv6", s.plugin.Name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid IP version %d", in.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proto.SetupResponse{}, nil
+}
+
+// requestContext returns the context of a request, with the metadata sent by the
+// bridge
+func requestContext(ctx context.Context, in *proto.HandleRequest) context.Context {
+	ctx = handler.NewContext(ctx)
+	md := handler.MetadataFromContext(ctx)
+	for key, value := range in.Metadata {
+		md.Set(key, value)
+	}
+	return ctx
+}
+
+// response returns the response of a plugin, with the metadata of the
+// request holding strings
+func response(ctx context.Context, resp []byte, stop bool) *proto.HandleResponse {
+	out := proto.HandleResponse{Response: resp, Stop: stop}
+	handler.MetadataFromContext(ctx).Range(func(key string, value interface{}) bool {
+		if s, ok := value.(string); ok {
+			if out.Metadata == nil {
+				out.Metadata = make(map[string]string)
+			}
+			out.Metadata[key] = s
+		}
+		return true
+	})
+	return &out
+}
+
+func (s *server) Handle4(ctx context.Context, in *proto.HandleRequest) (*proto.HandleResponse, error) {
+	if s.h4 == nil {
+		return nil, fmt.Errorf("plugin `%s` is not set up for DHCPv4", s.plugin.Name)
+	}
+	req, err := dhcpv4.FromBytes(in.Request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dhcpv4.FromBytes(in.Response)
+	if err != nil {
+		return nil, err
+	}
+	ctx = requestContext(ctx, in)
+	resp, stop := s.h4(ctx, req, resp)
+	if resp == nil {
+		return response(ctx, nil, true), nil
+	}
+	return response(ctx, resp.ToBytes(), stop), nil
+}
+
+func (s *server) Handle6(ctx context.Context, in *proto.HandleRequest) (*proto.HandleResponse, error) {
+	if s.h6 == nil {
+		return nil, fmt.Errorf("plugin `%s` is not set up for DHCPv6", s.plugin.Name)
+	}
+	req, err := dhcpv6.FromBytes(in.Request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dhcpv6.FromBytes(in.Response)
+	if err != nil {
+		return nil, err
+	}
+	ctx = requestContext(ctx, in)
+	resp, stop := s.h6(ctx, req, resp)
+	if resp == nil {
+		return response(ctx, nil, true), nil
+	}
+	return response(ctx, resp.ToBytes(), stop), nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package captiveportal implements a plugin sending the URI of the captive
+// portal API (RFC8910, RFC8908) to the clients requesting it, in option 114
+// in DHCPv4 and option 103 in DHCPv6.
+//
+// Each argument is either the default URI, or `<uri>@<class>` to give the
+// clients of a class another URI (see the class package for the syntax). The
+// first matching class applies; clients matching no class get the default
+// URI, or nothing if there is none. Using a `link:` class gives a different
+// portal to each subnet:
+//
+// server4:
+//   plugins:
+//     - captiveportal: https://portal.example.org/api https://guest.example.org/api@link:10.0.1.0/24
+//
+// The URI must use https, or be `urn:ietf:params:capport:unrestricted` to
+// tell clients that they are not behind a captive portal.
+package captiveportal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/captiveportal")
+
+// Plugin wraps the captive portal plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "captiveportal",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// unrestricted is the URI telling clients that there is no captive portal
+const unrestricted = "urn:ietf:params:capport:unrestricted"
+
+// optionCaptivePortal4 is the DHCPv4 option code assigned by RFC8910, which
+// used to be the URL option
+var optionCaptivePortal4 = dhcpv4.OptionURL
+
+type classURI struct {
+	*class.Matcher
+	uri string
+}
+
+// Handler holds the portal URIs of a plugin instance
+type Handler struct {
+	uri     string
+	classes []classURI
+}
+
+func checkURI(s string) error {
+	if s == unrestricted {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid captive portal URI %q: %v", s, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid captive portal URI %q, expected an https URI", s)
+	}
+	return nil
+}
+
+func setup(args ...string) (*Handler, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one captive portal URI")
+	}
+	var h Handler
+	for _, arg := range args {
+		uri := arg
+		var m *class.Matcher
+		// URIs may contain @ too, the argument has a class only if what
+		// follows the last @ parses as one
+		if sep := strings.LastIndexByte(arg, '@'); sep >= 0 {
+			if c, err := class.Parse(arg[sep+1:]); err == nil {
+				uri, m = arg[:sep], c
+			}
+		}
+		if err := checkURI(uri); err != nil {
+			return nil, err
+		}
+		if m != nil {
+			h.classes = append(h.classes, classURI{Matcher: m, uri: uri})
+			continue
+		}
+		if h.uri != "" {
+			return nil, fmt.Errorf("more than one default captive portal URI: %s and %s", h.uri, uri)
+		}
+		h.uri = uri
+	}
+	log.Infof("loaded captive portal URI %q and %d per-class URIs", h.uri, len(h.classes))
+	return &h, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	h, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return h.Handle6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	h, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return h.Handle4, nil
+}
+
+// Handle6 handles DHCPv6 packets for the captiveportal plugin
+func (h *Handler) Handle6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+
+	if !decap.IsOptionRequested(dhcpv6.OptionCaptivePortal) {
+		return resp, false
+	}
+	uri := h.uri
+	for _, c := range h.classes {
+		if c.Match6(req) {
+			uri = c.uri
+			break
+		}
+	}
+	if uri != "" {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCaptivePortal, OptionData: []byte(uri)})
+	}
+	return resp, false
+}
+
+// Handle4 handles DHCPv4 packets for the captiveportal plugin
+func (h *Handler) Handle4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if !req.IsOptionRequested(optionCaptivePortal4) {
+		return resp, false
+	}
+	uri := h.uri
+	for _, c := range h.classes {
+		if c.Match4(req) {
+			uri = c.uri
+			break
+		}
+	}
+	if uri != "" {
+		resp.UpdateOption(dhcpv4.OptGeneric(optionCaptivePortal4, []byte(uri)))
+	}
+	return resp, false
+}
@@ -0,0 +1,96 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package captiveportal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	h, err := setup("https://portal.example.org/api", "https://user@guest.example.org/api@link:10.0.1.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, "https://portal.example.org/api", h.uri)
+	require.Len(t, h.classes, 1)
+	assert.Equal(t, "https://user@guest.example.org/api", h.classes[0].uri)
+	assert.Equal(t, "link:10.0.1.0/24", h.classes[0].String())
+
+	_, err = setup(unrestricted)
+	assert.NoError(t, err)
+
+	for _, args := range [][]string{
+		{},
+		{"http://portal.example.org/api"},
+		{"portal.example.org"},
+		{"https://a.example.org/", "https://b.example.org/"},
+	} {
+		_, err := setup(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	h, err := setup("https://portal.example.org/api", "https://guest.example.org/api@link:10.0.1.0/24")
+	require.NoError(t, err)
+
+	for giaddr, want := range map[string]string{
+		"10.0.0.1": "https://portal.example.org/api",
+		"10.0.1.1": "https://guest.example.org/api",
+	} {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			dhcpv4.WithRequestedOptions(optionCaptivePortal4))
+		require.NoError(t, err)
+		req.GatewayIPAddr = net.ParseIP(giaddr)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+
+		resp, stop := h.Handle4(req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		assert.Equal(t, []byte(want), resp.Options.Get(optionCaptivePortal4), giaddr)
+	}
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ := h.Handle4(req, stub)
+	assert.Nil(t, resp.Options.Get(optionCaptivePortal4))
+}
+
+func TestHandle6(t *testing.T) {
+	h, err := setup("https://guest.example.org/api@user:guest")
+	require.NoError(t, err)
+
+	for userClass, want := range map[string][]byte{
+		"guest": []byte("https://guest.example.org/api"),
+		"staff": nil,
+	} {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionCaptivePortal))
+		req.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte(userClass)}})
+
+		stub, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		stub.MessageType = dhcpv6.MessageTypeReply
+
+		resp, stop := h.Handle6(req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		opt := resp.GetOneOption(dhcpv6.OptionCaptivePortal)
+		if want == nil {
+			assert.Nil(t, opt, userClass)
+		} else if assert.NotNil(t, opt, userClass) {
+			assert.Equal(t, want, opt.ToBytes(), userClass)
+		}
+	}
+}
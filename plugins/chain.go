@@ -0,0 +1,202 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+var (
+	stopAction     = &config.Action{Kind: config.ActionStop}
+	continueAction = &config.Action{Kind: config.ActionContinue}
+)
+
+// hasActions returns whether a plugin chain has chain directives, its plugins
+// being run in sequence until one of them stops the chain otherwise
+func hasActions(sc *config.ServerConfig) bool {
+	for _, p := range sc.Plugins {
+		if p.OnMatch != nil || p.OnMiss != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// actions returns what to do after a plugin, with the defaults
+func actions(p config.PluginConfig) (onMatch, onMiss *config.Action) {
+	onMatch, onMiss = p.OnMatch, p.OnMiss
+	if onMatch == nil {
+		onMatch = stopAction
+	}
+	if onMiss == nil {
+		onMiss = continueAction
+	}
+	return onMatch, onMiss
+}
+
+func skipped(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// step4 is a plugin of a DHCPv4 chain with directives
+type step4 struct {
+	name            string
+	handler         handler.Handler4Ctx
+	onMatch, onMiss *config.Action
+}
+
+// chain4 runs a DHCPv4 chain with directives as a single handler
+type chain4 struct {
+	main []step4
+	sub  map[string][]step4
+}
+
+// loadChain4 loads the plugins of a DHCPv4 chain with directives, and its
+// sub-chains
+func loadChain4(sc *config.ServerConfig) (handler.Handler4Ctx, error) {
+	load := func(confs []config.PluginConfig) ([]step4, error) {
+		enterScope()
+		steps := make([]step4, 0, len(confs))
+		for _, conf := range confs {
+			h4, err := loadPlugin4(conf)
+			if err != nil {
+				return nil, err
+			}
+			if h4 == nil {
+				continue
+			}
+			s := step4{name: conf.Name, handler: h4}
+			s.onMatch, s.onMiss = actions(conf)
+			steps = append(steps, s)
+		}
+		return steps, nil
+	}
+	c := chain4{sub: make(map[string][]step4, len(sc.SubChains))}
+	var err error
+	if c.main, err = load(sc.Plugins); err != nil {
+		return nil, err
+	}
+	for name, confs := range sc.SubChains {
+		if c.sub[name], err = load(confs); err != nil {
+			return nil, err
+		}
+	}
+	return c.handle, nil
+}
+
+func (c *chain4) handle(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	var skip []string
+	steps := c.main
+	for i := 0; i < len(steps); i++ {
+		s := &steps[i]
+		if skip != nil && skipped(skip, s.name) {
+			continue
+		}
+		var matched bool
+		if resp, matched = s.handler(ctx, req, resp); resp == nil {
+			// no reply
+			return nil, true
+		}
+		a := s.onMiss
+		if matched {
+			a = s.onMatch
+		}
+		switch a.Kind {
+		case config.ActionStop:
+			return resp, true
+		case config.ActionSkip:
+			skip = append(skip, a.Plugins...)
+		case config.ActionGoto:
+			steps, i, skip = c.sub[a.Chain], -1, nil
+		}
+	}
+	return resp, false
+}
+
+// step6 is a plugin of a DHCPv6 chain with directives
+type step6 struct {
+	name            string
+	handler         handler.Handler6Ctx
+	onMatch, onMiss *config.Action
+}
+
+// chain6 runs a DHCPv6 chain with directives as a single handler
+type chain6 struct {
+	main []step6
+	sub  map[string][]step6
+}
+
+// loadChain6 loads the plugins of a DHCPv6 chain with directives, and its
+// sub-chains
+func loadChain6(sc *config.ServerConfig) (handler.Handler6Ctx, error) {
+	load := func(confs []config.PluginConfig) ([]step6, error) {
+		enterScope()
+		steps := make([]step6, 0, len(confs))
+		for _, conf := range confs {
+			h6, err := loadPlugin6(conf)
+			if err != nil {
+				return nil, err
+			}
+			if h6 == nil {
+				continue
+			}
+			s := step6{name: conf.Name, handler: h6}
+			s.onMatch, s.onMiss = actions(conf)
+			steps = append(steps, s)
+		}
+		return steps, nil
+	}
+	c := chain6{sub: make(map[string][]step6, len(sc.SubChains))}
+	var err error
+	if c.main, err = load(sc.Plugins); err != nil {
+		return nil, err
+	}
+	for name, confs := range sc.SubChains {
+		if c.sub[name], err = load(confs); err != nil {
+			return nil, err
+		}
+	}
+	return c.handle, nil
+}
+
+func (c *chain6) handle(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	var skip []string
+	steps := c.main
+	for i := 0; i < len(steps); i++ {
+		s := &steps[i]
+		if skip != nil && skipped(skip, s.name) {
+			continue
+		}
+		var matched bool
+		if resp, matched = s.handler(ctx, req, resp); resp == nil {
+			// no reply
+			return nil, true
+		}
+		a := s.onMiss
+		if matched {
+			a = s.onMatch
+		}
+		switch a.Kind {
+		case config.ActionStop:
+			return resp, true
+		case config.ActionSkip:
+			skip = append(skip, a.Plugins...)
+		case config.ActionGoto:
+			steps, i, skip = c.sub[a.Chain], -1, nil
+		}
+	}
+	return resp, false
+}
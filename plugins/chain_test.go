@@ -0,0 +1,95 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// The test plugins append their argument to the boot file name, and match
+// when it is "match"
+func init() {
+	for _, name := range []string{"test-a", "test-b", "test-c"} {
+		_ = RegisterPlugin(&Plugin{
+			Name: name,
+			Setup4: func(args ...string) (handler.Handler4, error) {
+				return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+					resp.BootFileName += args[0] + ","
+					return resp, args[0] == "match"
+				}, nil
+			},
+		})
+	}
+}
+
+func action(t *testing.T, s string) *config.Action {
+	a, err := config.ParseAction(s)
+	require.NoError(t, err)
+	return a
+}
+
+func TestChain4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	run := func(sc *config.ServerConfig) string {
+		handlers, err := LoadPlugins4(sc)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		for _, h := range handlers {
+			var stop bool
+			if resp, stop = h(context.Background(), req, resp); stop {
+				break
+			}
+		}
+		return resp.BootFileName
+	}
+
+	// Without directives, the plugins are loaded as they are
+	sc := &config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-a", Args: []string{"1"}},
+		{Name: "test-b", Args: []string{"match"}},
+		{Name: "test-c", Args: []string{"3"}},
+	}}
+	handlers, err := LoadPlugins4(sc)
+	require.NoError(t, err)
+	assert.Len(t, handlers, 3)
+	assert.Equal(t, "1,match,", run(sc))
+
+	// continue after a match
+	sc.Plugins[1].OnMatch = action(t, "continue")
+	assert.Equal(t, "1,match,3,", run(sc))
+
+	// stop without a match
+	sc.Plugins[0].OnMiss = action(t, "stop")
+	assert.Equal(t, "1,", run(sc))
+
+	// skip
+	sc.Plugins[0].OnMiss = action(t, "skip test-b")
+	assert.Equal(t, "1,3,", run(sc))
+
+	// goto, the sub-chain replacing the rest of the chain
+	sc.Plugins[0].OnMiss = nil
+	sc.Plugins[1].OnMatch = action(t, "goto other")
+	sc.SubChains = map[string][]config.PluginConfig{
+		"other": {
+			{Name: "test-c", Args: []string{"match"}, OnMatch: action(t, "goto last")},
+			{Name: "test-a", Args: []string{"never"}},
+		},
+		"last": {
+			{Name: "test-a", Args: []string{"5"}},
+		},
+	}
+	assert.Equal(t, "1,match,match,5,", run(sc))
+}
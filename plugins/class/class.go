@@ -0,0 +1,207 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package class provides client classification for plugins offering
+// per-class behaviour. A class is described by a `kind:value` string in the
+// plugin arguments:
+//
+// - vendor:<string> matches clients whose vendor class contains the string
+// (option 60 in DHCPv4, option 16 in DHCPv6)
+// - user:<string> matches clients sending the string as one of their user
+// classes (option 77 in DHCPv4, option 15 in DHCPv6)
+// - mac:<prefix> matches clients whose hardware address starts with the given
+// bytes, eg. `mac:00:11:22` (in DHCPv6, as found by the duid package)
+// - enterprise:<number> matches clients sending a vendor class for the given
+// IANA enterprise number (option 124 in DHCPv4, option 16 in DHCPv6)
+//
+// Clients can also be classified by the relay agent they are behind:
+//
+// - circuit-id:<id> (or interface-id:<id>) matches the Agent Circuit ID
+// sub-option of option 82 in DHCPv4, and the Interface-ID option of any relay
+// in DHCPv6
+// - remote-id:<id> matches the Agent Remote ID sub-option of option 82 in
+// DHCPv4, and the Remote-ID option of any relay in DHCPv6
+// - link:<prefix> matches clients whose link is in the prefix: the giaddr in
+// DHCPv4, or the ciaddr of the clients renewing their lease by unicast, which
+// bypasses the relays, and the link address of the relays in DHCPv6 (see
+// relay.LinkAddr6)
+//
+// Identifiers are compared as strings, or as bytes when written in hex with a
+// 0x prefix, eg. `remote-id:0x0a0b0c`.
+package class
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+)
+
+// Matcher tells whether a client belongs to a class
+type Matcher struct {
+	kind  string
+	value string
+	mac   []byte
+	id    []byte
+	link  *net.IPNet
+	ent   uint32
+}
+
+// Parse parses a class description of the form `kind:value`
+func Parse(spec string) (*Matcher, error) {
+	sep := strings.IndexByte(spec, ':')
+	if sep < 0 {
+		return nil, fmt.Errorf("invalid class %q, expected kind:value", spec)
+	}
+	m := Matcher{kind: spec[:sep], value: spec[sep+1:]}
+	if m.value == "" {
+		return nil, fmt.Errorf("invalid class %q, empty value", spec)
+	}
+	switch m.kind {
+	case "vendor", "user":
+	case "mac":
+		mac, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(m.value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hardware address prefix in class %q: %v", spec, err)
+		}
+		m.mac = mac
+	case "interface-id":
+		m.kind = "circuit-id"
+		fallthrough
+	case "circuit-id", "remote-id":
+		m.id = []byte(m.value)
+		if strings.HasPrefix(m.value, "0x") {
+			id, err := hex.DecodeString(m.value[2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid identifier in class %q: %v", spec, err)
+			}
+			m.id = id
+		}
+	case "enterprise":
+		ent, err := strconv.ParseUint(m.value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enterprise number in class %q: %v", spec, err)
+		}
+		m.ent = uint32(ent)
+	case "link":
+		_, link, err := net.ParseCIDR(m.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix in class %q: %v", spec, err)
+		}
+		m.link = link
+	default:
+		return nil, fmt.Errorf("unknown class kind %q in %q", m.kind, spec)
+	}
+	return &m, nil
+}
+
+// String returns the class description, as given to Parse
+func (m *Matcher) String() string {
+	return m.kind + ":" + m.value
+}
+
+// Match4 returns whether a DHCPv4 request belongs to the class
+func (m *Matcher) Match4(req *dhcpv4.DHCPv4) bool {
+	switch m.kind {
+	case "vendor":
+		return strings.Contains(req.ClassIdentifier(), m.value)
+	case "user":
+		for _, uc := range req.UserClass() {
+			if uc == m.value {
+				return true
+			}
+		}
+	case "mac":
+		return bytes.HasPrefix(req.ClientHWAddr, m.mac)
+	case "enterprise":
+		for _, id := range req.VIVC() {
+			if id.EntID == m.ent {
+				return true
+			}
+		}
+	case "circuit-id":
+		return bytes.Equal(relay.CircuitID4(req), m.id)
+	case "remote-id":
+		return bytes.Equal(relay.RemoteID4(req), m.id)
+	case "link":
+		if !req.GatewayIPAddr.IsUnspecified() {
+			return m.link.Contains(req.GatewayIPAddr)
+		}
+		return !req.ClientIPAddr.IsUnspecified() && m.link.Contains(req.ClientIPAddr)
+	}
+	return false
+}
+
+// Match6 returns whether a DHCPv6 message, relayed or not, belongs to the
+// class
+func (m *Matcher) Match6(d dhcpv6.DHCPv6) bool {
+	switch m.kind {
+	case "circuit-id":
+		for _, hop := range relay.Chain6(d) {
+			if hop.InterfaceID != nil && bytes.Equal(hop.InterfaceID, m.id) {
+				return true
+			}
+		}
+		return false
+	case "remote-id":
+		for _, hop := range relay.Chain6(d) {
+			if hop.RemoteID != nil && bytes.Equal(hop.RemoteID.RemoteID, m.id) {
+				return true
+			}
+		}
+		return false
+	case "link":
+		link := relay.LinkAddr6(d)
+		return link != nil && m.link.Contains(link)
+	case "mac":
+		hw, _ := duid.HWAddr(d)
+		return hw != nil && bytes.HasPrefix(hw, m.mac)
+	}
+
+	msg, err := d.GetInnerMessage()
+	if err != nil {
+		return false
+	}
+	switch m.kind {
+	case "vendor":
+		for _, opt := range msg.Options.Get(dhcpv6.OptionVendorClass) {
+			vc, ok := opt.(*dhcpv6.OptVendorClass)
+			if !ok {
+				continue
+			}
+			for _, data := range vc.Data {
+				if strings.Contains(string(data), m.value) {
+					return true
+				}
+			}
+		}
+	case "user":
+		for _, uc := range msg.Options.UserClasses() {
+			if string(uc) == m.value {
+				return true
+			}
+		}
+	case "enterprise":
+		for _, opt := range msg.Options.Get(dhcpv6.OptionVendorClass) {
+			if vc, ok := opt.(*dhcpv6.OptVendorClass); ok && vc.EnterpriseNumber == m.ent {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HWAddr6 returns the hardware address of a DHCPv6 client, when its DUID
+// carries one
+func HWAddr6(msg *dhcpv6.Message) net.HardwareAddr {
+	return duid.HWAddrOf(msg.Options.ClientID())
+}
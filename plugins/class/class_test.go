@@ -0,0 +1,120 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package class
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{"vendor:MSFT", "user:iPXE", "mac:00:11:22", "circuit-id:eth0/1", "remote-id:0x0a0b", "link:10.0.0.0/24", "enterprise:3561"} {
+		m, err := Parse(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, spec, m.String())
+	}
+	for _, spec := range []string{"vendor", "vendor:", "color:blue", "mac:zz", "remote-id:0xzz", "link:10.0.0.1", "enterprise:ubnt"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMatch4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007")),
+		dhcpv4.WithUserClass("iPXE", true),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+			dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte{0x0a, 0x0b}),
+		)),
+		dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 3561, Data: []byte("\x03cpe")})),
+	)
+	require.NoError(t, err)
+	req.GatewayIPAddr = net.IPv4(10, 0, 0, 1)
+
+	for spec, want := range map[string]bool{
+		"vendor:PXEClient":  true,
+		"vendor:MSFT":       false,
+		"user:iPXE":         true,
+		"user:iPX":          false,
+		"mac:00:11:22":      true,
+		"mac:00-11-23":      false,
+		"circuit-id:eth0/1": true,
+		"interface-id:eth0": false,
+		"remote-id:0x0a0b":  true,
+		"link:10.0.0.0/24":  true,
+		"link:10.0.1.0/24":  false,
+		"enterprise:3561":   true,
+		"enterprise:4491":   false,
+	} {
+		m, err := Parse(spec)
+		require.NoError(t, err)
+		assert.Equal(t, want, m.Match4(req), spec)
+	}
+
+	// Renewing clients unicast to the server, their link is their address
+	req.GatewayIPAddr = net.IPv4zero
+	req.ClientIPAddr = net.IPv4(10, 0, 1, 23)
+	for spec, want := range map[string]bool{
+		"link:10.0.0.0/24": false,
+		"link:10.0.1.0/24": true,
+	} {
+		m, err := Parse(spec)
+		require.NoError(t, err)
+		assert.Equal(t, want, m.Match4(req), spec)
+	}
+}
+
+func TestMatch6(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	msg.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}))
+	msg.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 4491, Data: [][]byte{[]byte("docsis3.0")}})
+	msg.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte("lab")}})
+
+	for spec, want := range map[string]bool{
+		"vendor:docsis":   true,
+		"vendor:MSFT":     false,
+		"user:lab":        true,
+		"mac:00:11":       true,
+		"mac:aa":          false,
+		"enterprise:4491": true,
+		"enterprise:3561": false,
+	} {
+		m, err := Parse(spec)
+		require.NoError(t, err)
+		assert.Equal(t, want, m.Match6(msg), spec)
+	}
+
+	relayed, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayed.AddOption(dhcpv6.OptInterfaceID([]byte("olt1/1")))
+	relayed.AddOption(&dhcpv6.OptRemoteID{EnterpriseNumber: 3561, RemoteID: []byte("cpe42")})
+
+	for spec, want := range map[string]bool{
+		"user:lab":             true,
+		"interface-id:olt1/1":  true,
+		"circuit-id:olt1/2":    false,
+		"remote-id:cpe42":      true,
+		"link:2001:db8:1::/48": true,
+		"link:2001:db8:2::/48": false,
+	} {
+		m, err := Parse(spec)
+		require.NoError(t, err)
+		assert.Equal(t, want, m.Match6(relayed), spec)
+		assert.Equal(t, spec == "user:lab", m.Match6(msg), spec)
+	}
+}
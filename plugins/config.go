@@ -0,0 +1,151 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+// configTag is the struct tag naming the settings of the configurations of the
+// plugins, eg. `config:"file,required"`. Settings marked required must be set
+// to a non-zero value
+const configTag = "config"
+
+// Validator is implemented by the configurations of the plugins needing more
+// checks than the required settings, once decoded
+type Validator interface {
+	Validate() error
+}
+
+// typedConfig tells whether a plugin is set up from its decoded configuration,
+// rather than from arguments. Plugins taking both are set up from their
+// configuration when it is a map
+func typedConfig(hasConfigSetup, hasArgsSetup bool, pluginConf config.PluginConfig) bool {
+	return hasConfigSetup && (pluginConf.Settings != nil || !hasArgsSetup)
+}
+
+// decodeConfig returns the configuration of a plugin, from its settings or
+// from its key=value arguments, decoded and validated
+func decodeConfig(plugin *Plugin, pluginConf config.PluginConfig) (interface{}, error) {
+	if plugin.NewConfig == nil {
+		return nil, fmt.Errorf("plugin `%s` has no configuration", plugin.Name)
+	}
+	settings := pluginConf.Settings
+	if settings == nil {
+		var err error
+		if settings, err = argsSettings(pluginConf.Args); err != nil {
+			return nil, err
+		}
+	}
+	conf := plugin.NewConfig()
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToIPHookFunc(),
+			mapstructure.StringToIPNetHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		TagName:          configTag,
+		Result:           conf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(settings); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := checkRequired(conf); err != nil {
+		return nil, err
+	}
+	if v, ok := conf.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid configuration: %w", err)
+		}
+	}
+	return conf, nil
+}
+
+// checkRequired returns an error if a required setting is not set
+func checkRequired(conf interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(conf))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < v.NumField(); i++ {
+		parts := strings.Split(v.Type().Field(i).Tag.Get(configTag), ",")
+		for _, opt := range parts[1:] {
+			if opt == "required" && v.Field(i).IsZero() {
+				return fmt.Errorf("missing setting %q", parts[0])
+			}
+		}
+	}
+	return nil
+}
+
+// argsSettings converts key=value arguments to settings, the repeated keys
+// getting lists
+func argsSettings(args []string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected key=value", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch prev := settings[key].(type) {
+		case nil:
+			settings[key] = value
+		case string:
+			settings[key] = []interface{}{prev, value}
+		case []interface{}:
+			settings[key] = append(prev, value)
+		}
+	}
+	return settings, nil
+}
+
+// settingsArgs converts settings to key=value arguments, sorted by key, for
+// the plugins set up from arguments. Lists give an argument per value
+func settingsArgs(settings map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, k := range keys {
+		values, ok := settings[k].([]interface{})
+		if !ok {
+			values = []interface{}{settings[k]}
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+				return nil, fmt.Errorf("setting %q cannot be given as an argument", k)
+			case nil:
+				v = ""
+			}
+			args = append(args, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	return args, nil
+}
+
+// pluginArgs returns the arguments of a plugin set up from arguments
+func pluginArgs(pluginConf config.PluginConfig) ([]string, error) {
+	if pluginConf.Settings == nil {
+		return pluginConf.Args, nil
+	}
+	return settingsArgs(pluginConf.Settings)
+}
@@ -0,0 +1,154 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+type testConfig struct {
+	File    string        `config:"file,required"`
+	Servers []net.IP      `config:"servers"`
+	Timeout time.Duration `config:"timeout"`
+	Retries int           `config:"retries"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.Retries < 0 {
+		return errors.New("negative retries")
+	}
+	return nil
+}
+
+// test-config sets the boot file name from its configuration, test-typed
+// only takes a typed configuration
+func init() {
+	newConfig := func() interface{} {
+		return &testConfig{Timeout: time.Second}
+	}
+	setupConfig4 := func(conf interface{}) (handler.Handler4Ctx, error) {
+		c := conf.(*testConfig)
+		return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			resp.BootFileName = c.File
+			return resp, false
+		}, nil
+	}
+	_ = RegisterPlugin(&Plugin{
+		Name: "test-config",
+		Setup4Ctx: func(args ...string) (handler.Handler4Ctx, error) {
+			return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				resp.BootFileName = "args " + strings.Join(args, " ")
+				return resp, false
+			}, nil
+		},
+		NewConfig:    newConfig,
+		Setup4Config: setupConfig4,
+	})
+	_ = RegisterPlugin(&Plugin{
+		Name:         "test-typed",
+		NewConfig:    newConfig,
+		Setup4Config: setupConfig4,
+	})
+}
+
+func TestDecodeConfig(t *testing.T) {
+	plugin := RegisteredPlugins["test-typed"]
+	conf, err := decodeConfig(plugin, config.PluginConfig{Settings: map[string]interface{}{
+		"file":    "pxelinux.0",
+		"servers": []interface{}{"192.0.2.1", "192.0.2.2"},
+		"retries": "3",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, &testConfig{
+		File:    "pxelinux.0",
+		Servers: []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")},
+		Timeout: time.Second,
+		Retries: 3,
+	}, conf)
+
+	conf, err = decodeConfig(plugin, config.PluginConfig{Args: []string{"file=ipxe.efi", "timeout=5s"}})
+	require.NoError(t, err)
+	assert.Equal(t, &testConfig{File: "ipxe.efi", Timeout: 5 * time.Second}, conf)
+
+	for _, settings := range []map[string]interface{}{
+		{},
+		{"file": ""},
+		{"file": "pxelinux.0", "retry": 1},
+		{"file": "pxelinux.0", "retries": -1},
+		{"file": "pxelinux.0", "timeout": "soon"},
+	} {
+		_, err := decodeConfig(plugin, config.PluginConfig{Settings: settings})
+		assert.Error(t, err, settings)
+	}
+	_, err = decodeConfig(plugin, config.PluginConfig{Args: []string{"pxelinux.0"}})
+	assert.Error(t, err)
+}
+
+func TestSettingsArgs(t *testing.T) {
+	args, err := settingsArgs(map[string]interface{}{
+		"zone":    "example.org",
+		"reverse": []interface{}{"10.in-addr.arpa", "168.192.in-addr.arpa"},
+		"ttl":     300,
+		"dry-run": nil,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"dry-run=", "reverse=10.in-addr.arpa", "reverse=168.192.in-addr.arpa", "ttl=300", "zone=example.org",
+	}, args)
+
+	_, err = settingsArgs(map[string]interface{}{"tsig": map[string]interface{}{"key": "secret"}})
+	assert.Error(t, err)
+
+	settings, err := argsSettings([]string{"zone=example.org", "reverse=a", "reverse=b", "reverse=c"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"zone":    "example.org",
+		"reverse": []interface{}{"a", "b", "c"},
+	}, settings)
+}
+
+func TestLoadTyped(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	bootFile := func(pluginConf config.PluginConfig) string {
+		handlers, err := LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{pluginConf}})
+		require.NoError(t, err)
+		require.Len(t, handlers, 1)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ = handlers[0](context.Background(), req, resp)
+		return resp.BootFileName
+	}
+
+	// Maps are decoded, arguments kept for the plugins taking both
+	settings := map[string]interface{}{"file": "pxelinux.0"}
+	assert.Equal(t, "pxelinux.0", bootFile(config.PluginConfig{Name: "test-config", Settings: settings}))
+	assert.Equal(t, "args a b", bootFile(config.PluginConfig{Name: "test-config", Args: []string{"a", "b"}}))
+	assert.Equal(t, "ipxe.efi", bootFile(config.PluginConfig{Name: "test-typed", Args: []string{"file=ipxe.efi"}}))
+	_, err = LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{{Name: "test-typed"}}})
+	assert.Error(t, err)
+
+	// Plugins set up from arguments get maps as key=value arguments
+	loaded.instances = nil
+	calls = nil
+	_, err = LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-lifecycle", Settings: map[string]interface{}{"b": 2, "a": "1"}},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, Reload())
+	assert.Equal(t, []string{"reload a=1 b=2"}, calls)
+}
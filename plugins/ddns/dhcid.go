@@ -0,0 +1,40 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Identifier types of the DHCID RR, RFC4701 §3.3
+const (
+	dhcidHWAddr   = 0x0000
+	dhcidClientID = 0x0001
+	dhcidDUID     = 0x0002
+)
+
+// dhcidDigestSHA256 is the only digest type defined by RFC4701
+const dhcidDigestSHA256 = 1
+
+// dhcid computes the DHCID RR data for a client, as described in RFC4701
+// §3.5, in the base64 form used by dns.DHCID. For dhcidHWAddr, the
+// identifier is the hardware type followed by the hardware address
+func dhcid(idType uint16, id []byte, fqdn string) string {
+	name := make([]byte, 255)
+	n, err := dns.PackDomainName(dns.Fqdn(strings.ToLower(fqdn)), name, 0, nil, false)
+	if err != nil {
+		// Names are validated beforehand
+		panic(err)
+	}
+	h := sha256.New()
+	h.Write(id)
+	h.Write(name[:n])
+	rdata := append([]byte{byte(idType >> 8), byte(idType), dhcidDigestSHA256}, h.Sum(nil)...)
+	return base64.StdEncoding.EncodeToString(rdata)
+}
@@ -0,0 +1,24 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The examples of RFC4701 §3.6
+func TestDHCID(t *testing.T) {
+	assert.Equal(t, "AAIBY2/AuCccgoJbsaxcQc9TUapptP69lOjxfNuVAA2kjEA=", dhcid(dhcidDUID,
+		[]byte{0x00, 0x01, 0x00, 0x06, 0x41, 0x2d, 0xf1, 0x66, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		"chi6.example.com"))
+	assert.Equal(t, "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY=", dhcid(dhcidHWAddr,
+		[]byte{0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		"client.example.com"))
+	assert.Equal(t, "AAEBOSD+XR3Os/0LozeXVqcNc7FwCfQdWL3b/NaiUDlW2No=", dhcid(dhcidClientID,
+		[]byte{0x01, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c},
+		"chi.example.com."))
+}
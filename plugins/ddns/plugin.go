@@ -0,0 +1,581 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ddns implements a plugin registering the names of the clients in
+// the DNS with dynamic updates (RFC2136), when they get a lease, and removing
+// them when the lease is released or expires.
+//
+// Clients get an A (DHCPv4) or AAAA (DHCPv6) record for their host name in
+// the zone, taken from the first label of the Client FQDN option (81 in
+// DHCPv4, 39 in DHCPv6), or from the Host Name option (12) in DHCPv4. In
+// DHCPv4, a Host Name option set in the response by a previous plugin (eg.
+// range, with its naming policy) has precedence.
+// Optionally, PTR records are added in the reverse zones. As described in
+// RFC4703, a DHCID record (RFC4701) identifies the client owning a name, so
+// that a client can't take over the name of another one.
+//
+// Clients sending a Client FQDN option tell whether they update their A or
+// AAAA record themselves, or want no update at all (RFC4702, RFC4704). The
+// server then only adds the PTR records, or nothing, unless it overrides the
+// client. Either way, it answers with the option, giving the name in the zone
+// and the flags telling what it does.
+//
+// Arguments are:
+// - zone=<domain>: the zone the names are added to (mandatory)
+// - server=<address>[:port]: the primary server of the zones (mandatory)
+// - key=<algorithm>:<name>:<secret>: the TSIG key signing the updates, eg.
+// `key=hmac-sha256:dhcp-key:c2VjcmV0`, with a base64 secret
+// - reverse=<zone>: a reverse zone to add PTR records to, can be repeated
+// - ttl=<duration>: the TTL of the records, 5m by default
+// - override=<bool>: whether to update the A or AAAA records of the clients
+// that asked to do it themselves, false by default
+//
+// The plugin must come after the plugins assigning addresses. Use one
+// instance of the plugin per zone:
+//
+//	server4:
+//	  plugins:
+//	    - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//	    - ddns: zone=example.org server=192.0.2.53 key=hmac-sha256:dhcp-key:c2VjcmV0 reverse=2.0.192.in-addr.arpa
+//
+// The updates are sent in the background, in order. The pending ones are sent
+// when the server stops, for up to 5s. The registered names are kept in
+// memory only.
+package ddns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/miekg/dns"
+)
+
+var log = logger.GetLogger("plugins/ddns")
+
+// Plugin wraps the ddns plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "ddns",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+const (
+	defaultTTL = 5 * time.Minute
+	// defaultLeaseTime is used for DHCPv4 responses without a lease time
+	defaultLeaseTime = time.Hour
+	sweepInterval    = time.Minute
+	// maxPendingUpdates is how many updates can be queued before new ones
+	// are dropped, eg. when the DNS server is unreachable
+	maxPendingUpdates = 1024
+	// stopTimeout bounds how long the pending updates are waited for when
+	// the server stops
+	stopTimeout = 5 * time.Second
+)
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// binding is a name registered for an address of a client
+type binding struct {
+	name  string
+	ip    net.IP
+	dhcid string
+	// forward is unset when the client updates its A or AAAA record itself,
+	// and only the PTR record is added
+	forward bool
+	expires time.Time
+}
+
+func (b *binding) equal(other *binding) bool {
+	return b.name == other.name && b.ip.Equal(other.ip) && b.forward == other.forward
+}
+
+// PluginState holds the names registered by an instance of the plugin
+type PluginState struct {
+	sync.Mutex
+	updater *updater
+	zone    string
+	reverse []string
+	// override is set to update the A or AAAA records of the clients which
+	// asked to do it themselves
+	override bool
+	// bindings are keyed by client identifier
+	bindings map[string][]*binding
+	// updates queues the updates to send, until the plugin stops. done is
+	// closed once they are all sent
+	updates chan func()
+	done    chan struct{}
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		updater:  &updater{client: &dns.Client{Timeout: 5 * time.Second}, ttl: uint32(defaultTTL / time.Second)},
+		bindings: make(map[string][]*binding),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "zone":
+			if _, ok := dns.IsDomainName(value); !ok {
+				return nil, fmt.Errorf("invalid zone %q", value)
+			}
+			// The names of the clients, a host name of up to 63 bytes in
+			// the zone, must fit in 255 bytes
+			name := make([]byte, 255)
+			if n, err := dns.PackDomainName(dns.Fqdn(value), name, 0, nil, false); err != nil || n > 255-64 {
+				return nil, fmt.Errorf("zone %q is too long for the names of the clients", value)
+			}
+			p.zone = dns.CanonicalName(value)
+		case "reverse":
+			if _, ok := dns.IsDomainName(value); !ok {
+				return nil, fmt.Errorf("invalid reverse zone %q", value)
+			}
+			p.reverse = append(p.reverse, dns.CanonicalName(value))
+		case "server":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				value = net.JoinHostPort(value, "53")
+			}
+			p.updater.server = value
+		case "key":
+			fields := strings.SplitN(value, ":", 3)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("invalid key %q, expected <algorithm>:<name>:<secret>", value)
+			}
+			if _, err := base64.StdEncoding.DecodeString(fields[2]); err != nil {
+				return nil, fmt.Errorf("invalid key secret, expected base64: %v", err)
+			}
+			p.updater.keyAlgorithm = dns.Fqdn(strings.ToLower(fields[0]))
+			switch p.updater.keyAlgorithm {
+			case dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512:
+			default:
+				return nil, fmt.Errorf("unsupported key algorithm %q", fields[0])
+			}
+			p.updater.keyName = dns.CanonicalName(fields[1])
+			p.updater.client.TsigSecret = map[string]string{p.updater.keyName: fields[2]}
+		case "ttl":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < time.Second {
+				return nil, fmt.Errorf("invalid TTL %q", value)
+			}
+			p.updater.ttl = uint32(ttl / time.Second)
+		case "override":
+			override, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid override value %q", value)
+			}
+			p.override = override
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.zone == "" || p.updater.server == "" {
+		return nil, errors.New("need at least a zone and a server")
+	}
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.updates = make(chan func(), maxPendingUpdates)
+	p.done = make(chan struct{})
+	go func() {
+		for update := range p.updates {
+			update()
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("sending updates for %s to %s", p.zone, p.updater.server)
+	return p, nil
+}
+
+// start starts sweeping the expired names of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.sweeper(ctx)
+	}
+	return nil
+}
+
+// stop sends the pending updates of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.Lock()
+		if p.updates != nil {
+			close(p.updates)
+			p.updates = nil
+		}
+		p.Unlock()
+	}
+	deadline := time.After(stopTimeout)
+	for _, p := range instances.list {
+		select {
+		case <-p.done:
+		case <-deadline:
+			return fmt.Errorf("gave up sending the pending updates after %v", stopTimeout)
+		}
+	}
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// fqdn returns the name of a client in the zone, from its host name, or ""
+// if it has none
+func (p *PluginState) fqdn(host string) string {
+	if host == "" {
+		return ""
+	}
+	return host + "." + p.zone
+}
+
+// negotiate returns the flags of the Client FQDN option answering the flags
+// sent by a client, and whether the server performs the forward update, and
+// any update at all
+func (p *PluginState) negotiate(flags uint8) (uint8, bool, bool) {
+	switch {
+	case flags&fqdn.FlagN != 0:
+		return fqdn.FlagN, false, false
+	case flags&fqdn.FlagS != 0:
+		return fqdn.FlagS, true, true
+	case p.override:
+		return fqdn.FlagS | fqdn.FlagO, true, true
+	default:
+		// The client updates its A or AAAA record, the server still adds
+		// the PTR record (RFC4702 §4)
+		return 0, false, true
+	}
+}
+
+// reverseZone returns the reverse name of an address, and the configured
+// reverse zone it belongs to, if any
+func (p *PluginState) reverseZone(ip net.IP) (string, string) {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", ""
+	}
+	for _, zone := range p.reverse {
+		if dns.IsSubDomain(zone, name) {
+			return name, zone
+		}
+	}
+	return "", ""
+}
+
+// enqueue queues an update, without blocking the DHCP exchange. The caller
+// must hold the lock
+func (p *PluginState) enqueue(update func()) {
+	if p.updates == nil {
+		// Stopped
+		return
+	}
+	select {
+	case p.updates <- update:
+	default:
+		log.Warning("too many pending updates, dropping one")
+	}
+}
+
+func (p *PluginState) register(b binding) {
+	p.enqueue(func() {
+		if b.forward {
+			if err := p.updater.addName(p.zone, b.name, b.ip, b.dhcid); err != nil {
+				log.Warningf("could not register %s for %s: %v", logger.Redact(b.name), b.ip, err)
+				return
+			}
+			log.Infof("registered %s for %s", logger.Redact(b.name), b.ip)
+		}
+		if reverse, zone := p.reverseZone(b.ip); zone != "" {
+			if err := p.updater.setPTR(zone, reverse, b.name); err != nil {
+				log.Warningf("could not register the PTR of %s: %v", b.ip, err)
+			}
+		}
+	})
+}
+
+func (p *PluginState) unregister(b binding) {
+	p.enqueue(func() {
+		if b.forward {
+			if err := p.updater.removeName(p.zone, b.name, b.ip, b.dhcid); err != nil {
+				log.Warningf("could not remove %s for %s: %v", logger.Redact(b.name), b.ip, err)
+				return
+			}
+			log.Infof("removed %s for %s", logger.Redact(b.name), b.ip)
+		}
+		if reverse, zone := p.reverseZone(b.ip); zone != "" {
+			if err := p.updater.removePTR(zone, reverse, b.name); err != nil {
+				log.Warningf("could not remove the PTR of %s: %v", b.ip, err)
+			}
+		}
+	})
+}
+
+// commit records the addresses a client got under a name, registering the
+// new ones and removing those it doesn't have anymore
+func (p *PluginState) commit(client string, bindings []*binding) {
+	p.Lock()
+	defer p.Unlock()
+	for _, old := range p.bindings[client] {
+		kept := false
+		for _, b := range bindings {
+			if b.equal(old) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			p.unregister(*old)
+		}
+	}
+	for _, b := range bindings {
+		known := false
+		for _, old := range p.bindings[client] {
+			if b.equal(old) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			p.register(*b)
+		}
+	}
+	if len(bindings) == 0 {
+		delete(p.bindings, client)
+	} else {
+		p.bindings[client] = bindings
+	}
+}
+
+// release removes the names of a client
+func (p *PluginState) release(client string) {
+	p.commit(client, nil)
+}
+
+// sweeper sweeps the expired names periodically, until ctx is done
+func (p *PluginState) sweeper(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweep removes the names whose lease expired
+func (p *PluginState) sweep(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	for client, bindings := range p.bindings {
+		var live []*binding
+		for _, b := range bindings {
+			if now.After(b.expires) {
+				p.unregister(*b)
+			} else {
+				live = append(live, b)
+			}
+		}
+		if len(live) == 0 {
+			delete(p.bindings, client)
+		} else {
+			p.bindings[client] = live
+		}
+	}
+}
+
+// identifier4 returns the DHCID identifier of a DHCPv4 client, its client
+// identifier if it sent one, its hardware address otherwise
+func identifier4(req *dhcpv4.DHCPv4) (uint16, []byte) {
+	if id := req.Options.Get(dhcpv4.OptionClientIdentifier); len(id) > 0 {
+		return dhcidClientID, id
+	}
+	return dhcidHWAddr, append([]byte{byte(req.HWType)}, req.ClientHWAddr...)
+}
+
+// answer returns the Client FQDN option answering the one of a client, and
+// whether the server performs the forward update, and any update at all
+func (p *PluginState) answer(opt *fqdn.Option, name string) (*fqdn.Option, bool, bool) {
+	if opt == nil {
+		// Clients not sending the option get both updates
+		return nil, true, name != ""
+	}
+	if name == "" {
+		return &fqdn.Option{Flags: fqdn.FlagN | opt.Flags&fqdn.FlagE}, false, false
+	}
+	flags, forward, update := p.negotiate(opt.Flags)
+	return &fqdn.Option{Flags: flags | opt.Flags&fqdn.FlagE, Name: strings.TrimSuffix(name, ".")}, forward, update
+}
+
+// Handler4 handles DHCPv4 packets for the ddns plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	idType, id := identifier4(req)
+	client := fmt.Sprintf("4/%d/%x", idType, id)
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		p.release(client)
+		return resp, false
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	default:
+		return resp, false
+	}
+	if resp == nil {
+		return resp, false
+	}
+	mt := resp.MessageType()
+	if mt != dhcpv4.MessageTypeOffer && mt != dhcpv4.MessageTypeAck {
+		return resp, false
+	}
+
+	opt, err := fqdn.Parse4(req)
+	if err != nil {
+		log.Warningf("ignoring the invalid client FQDN option from %s: %v", req.ClientHWAddr, err)
+	}
+	// A host name in the response was set by the plugin holding the lease,
+	// eg. sanitized or generated by range, and has precedence
+	host := fqdn.HostName(resp.HostName())
+	if host == "" {
+		host = fqdn.HostName4(req)
+	}
+	name := p.fqdn(host)
+	answer, forward, update := p.answer(opt, name)
+	if answer != nil {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, answer.ToBytes4()))
+	}
+	// Only commit when the client gets its address: on ACK, which can be a
+	// response to a DISCOVER with rapid commit
+	if mt != dhcpv4.MessageTypeAck || resp.YourIPAddr.IsUnspecified() {
+		return resp, false
+	}
+	if !update {
+		p.release(client)
+		return resp, false
+	}
+	p.commit(client, []*binding{{
+		name:    name,
+		ip:      resp.YourIPAddr.To4(),
+		dhcid:   dhcid(idType, id, name),
+		forward: forward,
+		expires: time.Now().Add(resp.IPAddressLeaseTime(defaultLeaseTime)),
+	}})
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the ddns plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+	duid := msg.Options.ClientID()
+	if duid == nil {
+		return resp, false
+	}
+	id := duid.ToBytes()
+	client := fmt.Sprintf("6/%x", id)
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		p.release(client)
+		return resp, false
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		return resp, false
+	}
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok || reply.Type() != dhcpv6.MessageTypeAdvertise && reply.Type() != dhcpv6.MessageTypeReply {
+		return resp, false
+	}
+
+	// Only clients sending the option get a name (RFC4704 §5)
+	opt, err := fqdn.Parse6(msg)
+	if err != nil {
+		log.Warningf("ignoring the invalid client FQDN option from %s: %v", duid, err)
+	}
+	if opt == nil {
+		return resp, false
+	}
+	name := p.fqdn(fqdn.HostName(opt.Name))
+	answer, forward, update := p.answer(opt, name)
+	// There is no E flag in DHCPv6, names are always in wire format
+	answer.Flags &^= fqdn.FlagE
+	reply.UpdateOption(answer.ToOption6())
+	// A Reply to a Solicit is a rapid commit
+	if reply.Type() != dhcpv6.MessageTypeReply {
+		return resp, false
+	}
+	if !update {
+		p.release(client)
+		return resp, false
+	}
+
+	var bindings []*binding
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			if addr.ValidLifetime == 0 {
+				continue
+			}
+			bindings = append(bindings, &binding{
+				name:    name,
+				ip:      addr.IPv6Addr,
+				dhcid:   dhcid(dhcidDUID, id, name),
+				forward: forward,
+				expires: time.Now().Add(addr.ValidLifetime),
+			})
+		}
+	}
+	if len(bindings) > 0 {
+		p.commit(client, bindings)
+	}
+	return resp, false
+}
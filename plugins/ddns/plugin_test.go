@@ -0,0 +1,326 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "c2VjcmV0"
+
+// fakeServer records the updates it receives, and answers with the rcodes
+// it is given, in order, or NOERROR
+type fakeServer struct {
+	sync.Mutex
+	updates []*dns.Msg
+	rcodes  []int
+	signed  bool
+}
+
+func (f *fakeServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	f.Lock()
+	defer f.Unlock()
+	f.updates = append(f.updates, r)
+	f.signed = r.IsTsig() != nil && w.TsigStatus() == nil
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if len(f.rcodes) > 0 {
+		m.Rcode, f.rcodes = f.rcodes[0], f.rcodes[1:]
+	}
+	if r.IsTsig() != nil {
+		m.SetTsig(r.IsTsig().Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	_ = w.WriteMsg(m)
+}
+
+// snapshot returns the updates received so far, and whether the last one was
+// signed
+func (f *fakeServer) snapshot() ([]*dns.Msg, bool) {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*dns.Msg(nil), f.updates...), f.signed
+}
+
+// wait waits for the server to have received n updates, and returns them
+// like snapshot
+func (f *fakeServer) wait(t *testing.T, n int) ([]*dns.Msg, bool) {
+	require.Eventually(t, func() bool {
+		updates, _ := f.snapshot()
+		return len(updates) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	updates, signed := f.snapshot()
+	require.Len(t, updates, n)
+	return updates, signed
+}
+
+func startServer(t *testing.T, f *fakeServer) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           f,
+		TsigSecret:        map[string]string{"dhcp-key.": testSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default rejects updates
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// newTestState returns a plugin state whose updates are run by flush
+func newTestState(t *testing.T, args ...string) *PluginState {
+	p, err := parseArgs(args...)
+	require.NoError(t, err)
+	p.updates = make(chan func(), 16)
+	return p
+}
+
+func flush(p *PluginState) {
+	for {
+		select {
+		case update := <-p.updates:
+			update()
+		default:
+			return
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("zone=Example.org", "server=192.0.2.53",
+		"key=hmac-sha256:dhcp-key:"+testSecret, "reverse=2.0.192.in-addr.arpa", "ttl=1h")
+	require.NoError(t, err)
+	assert.Equal(t, "example.org.", p.zone)
+	assert.Equal(t, "192.0.2.53:53", p.updater.server)
+	assert.Equal(t, "dhcp-key.", p.updater.keyName)
+	assert.Equal(t, dns.HmacSHA256, p.updater.keyAlgorithm)
+	assert.Equal(t, []string{"2.0.192.in-addr.arpa."}, p.reverse)
+	assert.Equal(t, uint32(3600), p.updater.ttl)
+
+	// The longest zone still fits the names of the clients
+	long := strings.Repeat(strings.Repeat("a", 63)+".", 2) + strings.Repeat("a", 61)
+	p, err = parseArgs("zone="+long, "server=192.0.2.53")
+	require.NoError(t, err)
+	assert.NotPanics(t, func() { dhcid(dhcidHWAddr, []byte{1}, p.fqdn(strings.Repeat("h", 63))) })
+
+	for _, args := range [][]string{
+		{"zone=example.org"},
+		{"server=192.0.2.53"},
+		{"zone=example.org", "server=192.0.2.53", "key=hmac-md4:dhcp-key:" + testSecret},
+		{"zone=example.org", "server=192.0.2.53", "key=hmac-sha256:dhcp-key:!!"},
+		{"zone=example.org", "server=192.0.2.53", "key=dhcp-key"},
+		{"zone=example.org", "server=192.0.2.53", "ttl=0s"},
+		{"zone=example.org", "server=192.0.2.53", "port=53"},
+		{"zone=example.org", "192.0.2.53"},
+		{"zone=" + strings.Repeat(strings.Repeat("a", 63)+".", 3) + "org", "server=192.0.2.53"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	p := PluginState{zone: "example.org."}
+	for _, tt := range []struct {
+		flags, answer   uint8
+		forward, update bool
+	}{
+		{fqdn.FlagS, fqdn.FlagS, true, true},
+		{0, 0, false, true},
+		{fqdn.FlagN, fqdn.FlagN, false, false},
+	} {
+		answer, forward, update := p.negotiate(tt.flags)
+		assert.Equal(t, tt.answer, answer, tt.flags)
+		assert.Equal(t, tt.forward, forward, tt.flags)
+		assert.Equal(t, tt.update, update, tt.flags)
+	}
+	p.override = true
+	answer, forward, update := p.negotiate(0)
+	assert.Equal(t, fqdn.FlagS|fqdn.FlagO, answer)
+	assert.True(t, forward)
+	assert.True(t, update)
+	// N is always honored
+	answer, _, update = p.negotiate(fqdn.FlagN)
+	assert.Equal(t, fqdn.FlagN, answer)
+	assert.False(t, update)
+}
+
+func newRequest4(t *testing.T, mt dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host")),
+	)
+	require.NoError(t, err)
+	return req
+}
+
+func TestHandler4(t *testing.T) {
+	f := &fakeServer{}
+	p := newTestState(t, "zone=example.org", "server="+startServer(t, f),
+		"key=hmac-sha256:dhcp-key:"+testSecret, "reverse=2.0.192.in-addr.arpa")
+
+	req := newRequest4(t, dhcpv4.MessageTypeRequest)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	require.NoError(t, err)
+	result, stop := p.Handler4(req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stop)
+	flush(p)
+
+	updates, signed := f.wait(t, 2)
+	assert.True(t, signed)
+	add := updates[0]
+	assert.Equal(t, "example.org.", add.Question[0].Name)
+	require.Len(t, add.Ns, 2)
+	assert.Equal(t, "host.example.org.", add.Ns[0].Header().Name)
+	assert.Equal(t, net.IPv4(192, 0, 2, 100).To4(), add.Ns[0].(*dns.A).A)
+	assert.Equal(t, dns.TypeDHCID, add.Ns[1].Header().Rrtype)
+	ptr := updates[1]
+	assert.Equal(t, "2.0.192.in-addr.arpa.", ptr.Question[0].Name)
+	assert.Equal(t, "host.example.org.", ptr.Ns[len(ptr.Ns)-1].(*dns.PTR).Ptr)
+
+	// Renewing the same lease doesn't send updates
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	updates, _ = f.snapshot()
+	assert.Len(t, updates, 2)
+
+	// Releasing removes the name and the PTR, without reply
+	release := newRequest4(t, dhcpv4.MessageTypeRelease)
+	result, stop = p.Handler4(release, nil)
+	assert.Nil(t, result)
+	assert.False(t, stop)
+	flush(p)
+	updates, _ = f.wait(t, 5)
+	assert.Equal(t, "example.org.", updates[2].Question[0].Name)
+	assert.Equal(t, "2.0.192.in-addr.arpa.", updates[4].Question[0].Name)
+	assert.Empty(t, p.bindings)
+}
+
+func TestHandler4FQDN(t *testing.T) {
+	f := &fakeServer{}
+	p := newTestState(t, "zone=example.org", "server="+startServer(t, f), "reverse=2.0.192.in-addr.arpa")
+
+	// The client updates its A record itself
+	req := newRequest4(t, dhcpv4.MessageTypeRequest)
+	req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, []byte{fqdn.FlagE, 0, 0, 4, 'h', 'o', 's', 't'}))
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	answer := []byte{fqdn.FlagE, 255, 255, 4, 'h', 'o', 's', 't', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'o', 'r', 'g', 0}
+	assert.Equal(t, answer, resp.Options.Get(dhcpv4.OptionFQDN))
+	updates, _ := f.wait(t, 1)
+	assert.Equal(t, "2.0.192.in-addr.arpa.", updates[0].Question[0].Name)
+
+	// It now wants no update, the PTR is removed
+	req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{fqdn.FlagN, 0, 0}, "host"...)))
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	assert.Equal(t, []byte{fqdn.FlagN, 255, 255, 'h', 'o', 's', 't', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'o', 'r', 'g', '.'},
+		resp.Options.Get(dhcpv4.OptionFQDN))
+	updates, _ = f.wait(t, 2)
+	assert.Equal(t, "2.0.192.in-addr.arpa.", updates[1].Question[0].Name)
+	assert.Empty(t, p.bindings)
+}
+
+func TestHandler4ResponseHostname(t *testing.T) {
+	f := &fakeServer{}
+	p := newTestState(t, "zone=example.org", "server="+startServer(t, f))
+
+	// The name set by range takes precedence over the client's
+	req := newRequest4(t, dhcpv4.MessageTypeRequest)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host-2")),
+	)
+	require.NoError(t, err)
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	updates, _ := f.wait(t, 1)
+	assert.Equal(t, "host-2.example.org.", updates[0].Ns[0].Header().Name)
+}
+
+func TestHandler4Conflict(t *testing.T) {
+	// The name exists, and its DHCID is not the client's
+	f := &fakeServer{rcodes: []int{dns.RcodeYXDomain, dns.RcodeNXRrset}}
+	p := newTestState(t, "zone=example.org", "server="+startServer(t, f), "reverse=2.0.192.in-addr.arpa")
+
+	req := newRequest4(t, dhcpv4.MessageTypeRequest)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	_, _ = p.Handler4(req, resp)
+	flush(p)
+	// No PTR is added for a name the client doesn't own
+	updates, signed := f.wait(t, 2)
+	assert.False(t, signed)
+	assert.Equal(t, "example.org.", updates[1].Question[0].Name)
+	assert.Equal(t, dns.TypeDHCID, updates[1].Answer[0].Header().Rrtype)
+}
+
+func TestHandler6(t *testing.T) {
+	f := &fakeServer{}
+	p := newTestState(t, "zone=example.org", "server="+startServer(t, f))
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+	}))
+	req.AddOption(&dhcpv6.OptFQDN{Flags: fqdn.FlagS, DomainName: &rfc1035label.Labels{Labels: []string{"host.other.org"}}})
+
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::100"), ValidLifetime: time.Hour},
+	}}})
+	_, stop := p.Handler6(req, resp)
+	assert.False(t, stop)
+	flush(p)
+
+	answer := []byte{fqdn.FlagS, 4, 'h', 'o', 's', 't', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'o', 'r', 'g', 0}
+	assert.Equal(t, answer, resp.Options.GetOne(dhcpv6.OptionFQDN).ToBytes())
+	updates, _ := f.wait(t, 1)
+	assert.Equal(t, "host.example.org.", updates[0].Ns[0].Header().Name)
+	assert.Equal(t, net.ParseIP("2001:db8::100"), updates[0].Ns[0].(*dns.AAAA).AAAA)
+
+	// The lease expires
+	p.sweep(time.Now().Add(2 * time.Hour))
+	flush(p)
+	f.wait(t, 3)
+	assert.Empty(t, p.bindings)
+}
@@ -0,0 +1,170 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// errConflict is returned when the name belongs to another client
+var errConflict = errors.New("name is in use by another client")
+
+// updater sends RFC2136 updates to the primary server of the zones
+type updater struct {
+	server string
+	client *dns.Client
+	// keyName and keyAlgorithm are empty when updates are not signed
+	keyName      string
+	keyAlgorithm string
+	ttl          uint32
+}
+
+// exchange signs and sends an update, and returns the response code
+func (u *updater) exchange(m *dns.Msg) (int, error) {
+	if u.keyName != "" {
+		m.SetTsig(u.keyName, u.keyAlgorithm, 300, time.Now().Unix())
+	}
+	r, _, err := u.client.Exchange(m, u.server)
+	if err != nil {
+		return 0, err
+	}
+	return r.Rcode, nil
+}
+
+func (u *updater) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: u.ttl}
+}
+
+// addrRR returns the A or AAAA record of an address
+func (u *updater) addrRR(name string, ip net.IP) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &dns.A{Hdr: u.header(name, dns.TypeA), A: ip4}
+	}
+	return &dns.AAAA{Hdr: u.header(name, dns.TypeAAAA), AAAA: ip}
+}
+
+func (u *updater) dhcidRR(name, id string) dns.RR {
+	return &dns.DHCID{Hdr: u.header(name, dns.TypeDHCID), Digest: id}
+}
+
+// dhcidPrereq returns the prerequisite that the DHCID of a name is id. Unlike
+// records to add, prerequisites have a zero TTL (RFC2136 §2.4.2)
+func dhcidPrereq(name, id string) dns.RR {
+	return &dns.DHCID{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeDHCID, Class: dns.ClassINET}, Digest: id}
+}
+
+// addName points a name to the address of a client, following RFC4703 §5.3:
+// the name is only taken over if it is unused or already belongs to the
+// client, as told by its DHCID record
+func (u *updater) addName(zone, name string, ip net.IP, id string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.NameNotUsed([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: name}}})
+	m.Insert([]dns.RR{u.addrRR(name, ip), u.dhcidRR(name, id)})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeYXDomain:
+	default:
+		return fmt.Errorf("adding %s: %s", name, dns.RcodeToString[rcode])
+	}
+
+	// The name exists, replace its addresses if it is ours
+	addr := u.addrRR(name, ip)
+	m = new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Used([]dns.RR{dhcidPrereq(name, id)})
+	m.RemoveRRset([]dns.RR{addr})
+	m.Insert([]dns.RR{addr})
+	if rcode, err = u.exchange(m); err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeNXRrset:
+		return errConflict
+	}
+	return fmt.Errorf("updating %s: %s", name, dns.RcodeToString[rcode])
+}
+
+// removeName removes the address of a client from a name, and the DHCID
+// record once the name has no address left, following RFC4703 §5.5
+func (u *updater) removeName(zone, name string, ip net.IP, id string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Used([]dns.RR{dhcidPrereq(name, id)})
+	m.Remove([]dns.RR{u.addrRR(name, ip)})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	switch rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNXRrset:
+		return errConflict
+	default:
+		return fmt.Errorf("removing %s: %s", name, dns.RcodeToString[rcode])
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Used([]dns.RR{dhcidPrereq(name, id)})
+	m.RRsetNotUsed([]dns.RR{
+		&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassANY}},
+		&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassANY}},
+	})
+	m.RemoveRRset([]dns.RR{dhcidPrereq(name, id)})
+	if rcode, err = u.exchange(m); err != nil {
+		return err
+	}
+	// YXRRSET means that the client still has other addresses
+	if rcode != dns.RcodeSuccess && rcode != dns.RcodeYXRrset {
+		return fmt.Errorf("removing the DHCID of %s: %s", name, dns.RcodeToString[rcode])
+	}
+	return nil
+}
+
+// setPTR points the reverse name of an address to a name, replacing any
+// previous PTR record
+func (u *updater) setPTR(zone, reverse, name string) error {
+	ptr := &dns.PTR{Hdr: u.header(reverse, dns.TypePTR), Ptr: name}
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.RemoveRRset([]dns.RR{ptr})
+	m.Insert([]dns.RR{ptr})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	if rcode != dns.RcodeSuccess {
+		return fmt.Errorf("adding PTR %s: %s", reverse, dns.RcodeToString[rcode])
+	}
+	return nil
+}
+
+// removePTR removes the PTR record of an address, if it points to the name
+func (u *updater) removePTR(zone, reverse, name string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Remove([]dns.RR{&dns.PTR{Hdr: u.header(reverse, dns.TypePTR), Ptr: name}})
+	rcode, err := u.exchange(m)
+	if err != nil {
+		return err
+	}
+	if rcode != dns.RcodeSuccess {
+		return fmt.Errorf("removing PTR %s: %s", reverse, dns.RcodeToString[rcode])
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/ra"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -27,6 +28,7 @@ var Plugin = plugins.Plugin{
 var (
 	dnsServers6 []net.IP
 	dnsServers4 []net.IP
+	options4    handler.StaticOptions4
 )
 
 func setup6(args ...string) (handler.Handler6, error) {
@@ -39,6 +41,7 @@ func setup6(args ...string) (handler.Handler6, error) {
 			return Handler6, errors.New("expected an DNS server address, got: " + arg)
 		}
 		dnsServers6 = append(dnsServers6, server)
+		ra.AdvertiseDNS(server)
 	}
 	log.Infof("loaded %d DNS servers.", len(dnsServers6))
 	return Handler6, nil
@@ -56,6 +59,7 @@ func setup4(args ...string) (handler.Handler4, error) {
 		}
 		dnsServers4 = append(dnsServers4, DNSServer)
 	}
+	options4.AddRequested(dhcpv4.OptDNS(dnsServers4...))
 	log.Infof("loaded %d DNS servers.", len(dnsServers4))
 	return Handler4, nil
 }
@@ -76,8 +80,6 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 
 //Handler4 handles DHCPv4 packets for the dns plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
-		resp.Options.Update(dhcpv4.OptDNS(dnsServers4...))
-	}
+	options4.Splice(req, resp)
 	return resp, false
 }
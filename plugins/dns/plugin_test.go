@@ -10,6 +10,8 @@ import (
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
 )
 
 func TestAddServer6(t *testing.T) {
@@ -97,9 +99,9 @@ func TestAddServer4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
-		net.ParseIP("192.0.2.1"),
-		net.ParseIP("192.0.2.3"),
+	dnsServers4, options4 = nil, handler.StaticOptions4{}
+	if _, err := setup4("192.0.2.1", "192.0.2.3"); err != nil {
+		t.Fatal(err)
 	}
 
 	resp, stop := Handler4(req, stub)
@@ -130,8 +132,9 @@ func TestNotRequested4(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dnsServers4 = []net.IP{
-		net.ParseIP("192.0.2.1"),
+	dnsServers4, options4 = nil, handler.StaticOptions4{}
+	if _, err := setup4("192.0.2.1"); err != nil {
+		t.Fatal(err)
 	}
 	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionBroadcastAddress))
 
@@ -0,0 +1,105 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package duid identifies the DHCPv6 clients for the plugins: it finds their
+// hardware address, so that the reservations of a machine can be keyed by
+// MAC address for DHCPv4 and DHCPv6 alike, and normalizes their DUIDs.
+//
+// The hardware address of a client is looked for, in order, in:
+// - the Client Link-Layer Address option (79, RFC6939) of the relay closest
+// to the client, which saw the frame of the client
+// - the DUID of the client, if a DUID-LL or DUID-LLT. The address may be the
+// one of another interface of the client, which generated its DUID from it
+// - the link-local address of the client, as seen by the relay closest to
+// it, if derived from its hardware address (modified EUI-64)
+//
+// Only Ethernet addresses are returned.
+package duid
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// Source tells where the hardware address of a client was found
+type Source string
+
+// Sources of the hardware addresses
+const (
+	SourceClientLinkLayer Source = "client-link-layer-address"
+	SourceDUID            Source = "duid"
+	SourcePeerAddress     Source = "peer-address"
+)
+
+// HWAddr returns the hardware address of a DHCPv6 client, relayed or not,
+// and where it was found, or nil if none is known
+func HWAddr(d dhcpv6.DHCPv6) (net.HardwareAddr, Source) {
+	var closest *dhcpv6.RelayMessage
+	for d != nil && d.IsRelay() {
+		r, ok := d.(*dhcpv6.RelayMessage)
+		if !ok {
+			break
+		}
+		closest = r
+		d = r.Options.RelayMessage()
+	}
+	if closest != nil {
+		if typ, mac := closest.Options.ClientLinkLayerAddress(); typ == iana.HWTypeEthernet && len(mac) == 6 {
+			return mac, SourceClientLinkLayer
+		}
+	}
+	if msg, ok := d.(*dhcpv6.Message); ok {
+		if mac := HWAddrOf(msg.Options.ClientID()); mac != nil {
+			return mac, SourceDUID
+		}
+	}
+	if closest != nil && closest.PeerAddr.IsLinkLocalUnicast() {
+		if mac, err := dhcpv6.GetMacAddressFromEUI64(closest.PeerAddr); err == nil {
+			return mac, SourcePeerAddress
+		}
+	}
+	return nil, ""
+}
+
+// HWAddrOf returns the Ethernet address of a DUID-LL or DUID-LLT, nil for the
+// other DUIDs
+func HWAddrOf(duid *dhcpv6.Duid) net.HardwareAddr {
+	if duid == nil || (duid.Type != dhcpv6.DUID_LL && duid.Type != dhcpv6.DUID_LLT) {
+		return nil
+	}
+	if duid.HwType != iana.HWTypeEthernet || len(duid.LinkLayerAddr) != 6 {
+		return nil
+	}
+	return duid.LinkLayerAddr
+}
+
+// Key returns the normalized form of a DUID, for the plugins keying their data
+// by client:
+// - ll:<hardware type>:<address> for a DUID-LL or DUID-LLT, without the time
+// of the DUID-LLT, which changes when a client generates its DUID again
+// - en:<enterprise number>:<identifier in hexadecimal> for a DUID-EN
+// - uuid:<UUID> for a DUID-UUID, in the canonical form of RFC4122
+// - the DUID in hexadecimal otherwise
+func Key(duid *dhcpv6.Duid) string {
+	if duid == nil {
+		return ""
+	}
+	switch duid.Type {
+	case dhcpv6.DUID_LL, dhcpv6.DUID_LLT:
+		return "ll:" + strconv.Itoa(int(duid.HwType)) + ":" + duid.LinkLayerAddr.String()
+	case dhcpv6.DUID_EN:
+		return "en:" + strconv.FormatUint(uint64(duid.EnterpriseNumber), 10) + ":" + hex.EncodeToString(duid.EnterpriseIdentifier)
+	case dhcpv6.DUID_UUID:
+		if len(duid.Uuid) == 16 {
+			u := duid.Uuid
+			return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+		}
+	}
+	return hex.EncodeToString(duid.ToBytes())
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package duid
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	duidMAC  = net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	relayMAC = net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x66}
+	// The link-local address of a client, derived from its hardware address
+	peerMAC = net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x77}
+	peer    = net.ParseIP("fe80::211:22ff:fe33:4477")
+)
+
+func solicit(t *testing.T, duid *dhcpv6.Duid) *dhcpv6.Message {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	msg.MessageType = dhcpv6.MessageTypeSolicit
+	if duid != nil {
+		msg.AddOption(dhcpv6.OptClientID(*duid))
+	}
+	return msg
+}
+
+func relayed(t *testing.T, msg *dhcpv6.Message, peerAddr net.IP, lla net.HardwareAddr) dhcpv6.DHCPv6 {
+	r, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), peerAddr)
+	require.NoError(t, err)
+	if lla != nil {
+		r.AddOption(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, lla))
+	}
+	// Through a second relay
+	outer, err := dhcpv6.EncapsulateRelay(r, dhcpv6.MessageTypeRelayForward, net.IPv6zero, net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	return outer
+}
+
+func TestHWAddr(t *testing.T) {
+	ll := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: duidMAC}
+	uuid := &dhcpv6.Duid{Type: dhcpv6.DUID_UUID, Uuid: make([]byte, 16)}
+
+	for _, tc := range []struct {
+		name   string
+		msg    dhcpv6.DHCPv6
+		mac    net.HardwareAddr
+		source Source
+	}{
+		{"DUID-LL", solicit(t, ll), duidMAC, SourceDUID},
+		{"DUID-LLT", solicit(t, &dhcpv6.Duid{Type: dhcpv6.DUID_LLT, HwType: iana.HWTypeEthernet, Time: 1, LinkLayerAddr: duidMAC}), duidMAC, SourceDUID},
+		{"DUID-UUID", solicit(t, uuid), nil, ""},
+		{"non-Ethernet DUID", solicit(t, &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeInfiniband, LinkLayerAddr: duidMAC}), nil, ""},
+		{"client link-layer address", relayed(t, solicit(t, ll), peer, relayMAC), relayMAC, SourceClientLinkLayer},
+		{"DUID before the peer address", relayed(t, solicit(t, ll), peer, nil), duidMAC, SourceDUID},
+		{"peer address", relayed(t, solicit(t, uuid), peer, nil), peerMAC, SourcePeerAddress},
+		{"global peer address", relayed(t, solicit(t, uuid), net.ParseIP("2001:db8::211:22ff:fe33:4477"), nil), nil, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mac, source := HWAddr(tc.msg)
+			assert.Equal(t, tc.mac, mac)
+			assert.Equal(t, tc.source, source)
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "", Key(nil))
+	ll := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: duidMAC}
+	llt := &dhcpv6.Duid{Type: dhcpv6.DUID_LLT, HwType: iana.HWTypeEthernet, Time: 12345, LinkLayerAddr: duidMAC}
+	assert.Equal(t, "ll:1:00:11:22:33:44:55", Key(ll))
+	assert.Equal(t, Key(ll), Key(llt))
+	assert.Equal(t, "en:9:0a0b", Key(&dhcpv6.Duid{Type: dhcpv6.DUID_EN, EnterpriseNumber: 9, EnterpriseIdentifier: []byte{10, 11}}))
+	assert.Equal(t, "uuid:00112233-4455-6677-8899-aabbccddeeff", Key(&dhcpv6.Duid{
+		Type: dhcpv6.DUID_UUID,
+		Uuid: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}))
+	assert.Equal(t, "0005abcd", Key(&dhcpv6.Duid{Type: 5, Opaque: []byte{0xab, 0xcd}}))
+}
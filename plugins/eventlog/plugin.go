@@ -0,0 +1,225 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package eventlog implements a plugin logging the lease events (see
+// plugins/leaseevents), with their fields in a structured data element named
+// lease: sent to syslog (see the --syslog flag), they are RFC 5424
+// structured data, eg.
+//
+//  <30>1 2020-06-02T10:12:04.000000Z dhcp1 coredhcp 1234 plugins/eventlog
+//  [lease@32473 event="allocate" ip="192.0.2.100" hwaddr="00:11:22:33:44:55"
+//  hostname="laptop" expires="2020-06-02T11:12:04Z"] allocate 192.0.2.100 for 00:11:22:33:44:55
+//
+// so that SIEMs read the allocations without parsing the messages. The
+// parameters are event, ip, hwaddr or duid, hostname, vendor_class, expires
+// and boot_file, when set.
+//
+// The only argument is events=<event>[,<event>...], the events to log, all by
+// default. The plugin must come after the plugins assigning addresses and
+// boot files:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - eventlog: events=allocate,release,expire
+//
+// The events are logged in the background: when the logs can't keep up, eg.
+// with a slow syslog server, the events beyond 1024 waiting ones are dropped.
+package eventlog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+var log = logger.GetLogger("plugins/eventlog")
+
+// Plugin wraps the eventlog plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "eventlog",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+// queueSize is how many events can wait to be logged
+const queueSize = 1024
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances = struct {
+	sync.Mutex
+	list []*PluginState
+}{}
+
+// PluginState holds an instance of the plugin
+type PluginState struct {
+	events  map[string]bool
+	tracker *leaseevents.Tracker
+	queue   chan leaseevents.Event
+	done    chan struct{}
+
+	// mu guards stopped, set once the queue is closed
+	mu      sync.Mutex
+	stopped bool
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{events: make(map[string]bool)}
+	for _, event := range leaseevents.All {
+		p.events[event] = true
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "events":
+			events := make(map[string]bool)
+			for _, event := range strings.Split(value, ",") {
+				if _, ok := p.events[event]; !ok {
+					return nil, fmt.Errorf("unknown event %q", event)
+				}
+				events[event] = true
+			}
+			p.events = events
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	p.queue = make(chan leaseevents.Event, queueSize)
+	p.done = make(chan struct{})
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.tracker = leaseevents.NewTracker(p.send)
+	go func() {
+		for e := range p.queue {
+			write(e)
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop logs the pending events of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+		<-p.done
+	}
+	instances.list = nil
+	return nil
+}
+
+// send queues an event to be logged, if it is logged. It is called with the
+// lock of the tracker held, and must not block
+func (p *PluginState) send(e leaseevents.Event) {
+	if !p.events[e.Event] {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Warningf("too many events waiting, dropping %s of %s", e.Event, e.IP)
+	}
+}
+
+// element returns the structured data element of an event
+func element(e leaseevents.Event) logger.SDElement {
+	el := logger.SDElement{Name: "lease"}
+	el.Add("event", e.Event)
+	if e.IP != nil {
+		el.Add("ip", e.IP.String())
+	}
+	el.Add("hwaddr", e.HWAddr)
+	el.Add("duid", e.DUID)
+	el.Add("hostname", logger.Redact(e.Hostname))
+	el.Add("vendor_class", e.VendorClass)
+	if e.Expires != nil {
+		el.Add("expires", e.Expires.UTC().Format(time.RFC3339))
+	}
+	el.Add("boot_file", e.BootFile)
+	return el
+}
+
+// write logs an event
+func write(e leaseevents.Event) {
+	client := e.HWAddr
+	if client == "" {
+		client = e.DUID
+	}
+	log.WithField("lease", element(e)).Infof("%s %s for %s", e.Event, e.IP, client)
+}
+
+// Handler4 follows the leases of DHCPv4 exchanges
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.tracker.Handle4(req, resp)
+	return resp, false
+}
+
+// Handler6 follows the leases of DHCPv6 exchanges
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.tracker.Handle6(req, resp)
+	return resp, false
+}
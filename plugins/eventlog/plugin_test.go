@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package eventlog
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs()
+	require.NoError(t, err)
+	assert.Len(t, p.events, len(leaseevents.All))
+
+	p, err = parseArgs("events=allocate,expire")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{leaseevents.Allocate: true, leaseevents.Expire: true}, p.events)
+
+	for _, args := range [][]string{{"events=allocate,request"}, {"events"}, {"queue=1"}} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestElement(t *testing.T) {
+	expires := time.Date(2020, 6, 2, 11, 12, 4, 0, time.UTC)
+	e := element(leaseevents.Event{
+		Event:    leaseevents.Allocate,
+		IP:       net.IPv4(192, 0, 2, 100),
+		HWAddr:   "00:11:22:33:44:55",
+		Hostname: "laptop",
+		Expires:  &expires,
+	})
+	assert.Equal(t, `[lease event="allocate" ip="192.0.2.100" hwaddr="00:11:22:33:44:55" hostname="laptop" expires="2020-06-02T11:12:04Z"]`, e.String())
+}
+
+func TestHandler4(t *testing.T) {
+	hook := test.NewLocal(log.Logger)
+	defer hook.Reset()
+	p, err := setup("events=allocate")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	result, stopped := p.Handler4(req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stopped)
+	// The pending events are logged when stopping
+	require.NoError(t, stop())
+
+	var entries []string
+	for _, e := range hook.AllEntries() {
+		if el, ok := e.Data["lease"].(logger.SDElement); ok {
+			entries = append(entries, e.Message)
+			assert.Contains(t, el.String(), `hwaddr="00:01:02:03:04:05"`)
+		}
+	}
+	assert.Equal(t, []string{"allocate 192.0.2.100 for 00:01:02:03:04:05"}, entries)
+}
@@ -0,0 +1,320 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package exechook implements a plugin running an external program on lease
+// events, like the --dhcp-script option of dnsmasq. The events are allocate,
+// renew, release, expire, reclaim and pxe, see plugins/leaseevents.
+//
+// The program is run as
+//   <program> <event> <MAC address or DUID> <IP address> [<host name>]
+// with the environment of the server, and these variables describing the
+// lease, set when known:
+// - COREDHCP_EVENT
+// - COREDHCP_HWADDR: the MAC address of a DHCPv4 client
+// - COREDHCP_DUID: the DUID of a DHCPv6 client, in hexadecimal
+// - COREDHCP_IP
+// - COREDHCP_HOSTNAME
+// - COREDHCP_VENDOR_CLASS: the vendor class identifier of the client
+// - COREDHCP_EXPIRES: the end of the lease, in RFC3339 format
+// - COREDHCP_BOOT_FILE: the boot file given to a PXE client
+//
+// The output of the program is discarded, its error output goes to the error
+// output of the server.
+//
+// Arguments are the path of the program, then:
+// - events=<event>[,<event>...]: the events to run the program for, all by
+// default
+// - concurrency=<n>: how many instances of the program can run at the same
+// time, 1 by default. With more than one, the events can be handled out of
+// order
+// - timeout=<duration>: how long the program can run before being killed,
+// 10s by default
+// - queue=<n>: how many events can wait for the program before new ones are
+// dropped, 1024 by default
+//
+// The plugin must come after the plugins assigning addresses and boot files:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - exechook: /usr/local/bin/lease-hook events=allocate,release,expire
+package exechook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/exechook")
+
+// Plugin wraps the exechook plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "exechook",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+// stopTimeout bounds how long the pending events are waited for when the
+// server stops, the program running for each of them
+const stopTimeout = 30 * time.Second
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds the configuration of an instance of the plugin
+type PluginState struct {
+	program     string
+	events      map[string]bool
+	concurrency int
+	timeout     time.Duration
+	queue       chan leaseevents.Event
+	tracker     *leaseevents.Tracker
+	// done is closed once the program ran for all the events, after the
+	// plugin stopped
+	done chan struct{}
+
+	// mu guards stopped, set once the queue is closed
+	mu      sync.Mutex
+	stopped bool
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need the path of the program to run")
+	}
+	info, err := os.Stat(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid program: %w", err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("%s is not an executable file", args[0])
+	}
+	p := PluginState{
+		program:     args[0],
+		events:      make(map[string]bool),
+		concurrency: 1,
+		timeout:     10 * time.Second,
+	}
+	for _, event := range leaseevents.All {
+		p.events[event] = true
+	}
+	queueSize := 1024
+	for _, arg := range args[1:] {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "events":
+			events := make(map[string]bool)
+			for _, event := range strings.Split(value, ",") {
+				if _, ok := p.events[event]; !ok {
+					return nil, fmt.Errorf("unknown event %q", event)
+				}
+				events[event] = true
+			}
+			p.events = events
+		case "concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid concurrency %q", value)
+			}
+			p.concurrency = n
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			p.timeout = timeout
+		case "queue":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("invalid queue size %q", value)
+			}
+			queueSize = size
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	p.queue = make(chan leaseevents.Event, queueSize)
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.tracker = leaseevents.NewTracker(p.send)
+	p.done = make(chan struct{})
+	var workers sync.WaitGroup
+	workers.Add(p.concurrency)
+	for i := 0; i < p.concurrency; i++ {
+		go func() {
+			defer workers.Done()
+			for e := range p.queue {
+				p.run(e)
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("running %s on lease events", p.program)
+	return p, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop runs the program for the pending events of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+	}
+	deadline := time.After(stopTimeout)
+	for _, p := range instances.list {
+		select {
+		case <-p.done:
+		case <-deadline:
+			return fmt.Errorf("gave up running %s for the pending events after %v", p.program, stopTimeout)
+		}
+	}
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// send queues an event, without blocking the DHCP exchange
+func (p *PluginState) send(e leaseevents.Event) {
+	if !p.events[e.Event] {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Warningf("too many pending events, dropping the %s event of %s", e.Event, e.IP)
+	}
+}
+
+// command returns the arguments and the environment of the program for an
+// event
+func command(e leaseevents.Event) ([]string, []string) {
+	client := e.HWAddr
+	if client == "" {
+		client = e.DUID
+	}
+	var ip string
+	if e.IP != nil {
+		ip = e.IP.String()
+	}
+	args := []string{e.Event, client, ip}
+	if e.Hostname != "" {
+		args = append(args, e.Hostname)
+	}
+
+	env := []string{"COREDHCP_EVENT=" + e.Event}
+	for name, value := range map[string]string{
+		"COREDHCP_HWADDR":       e.HWAddr,
+		"COREDHCP_DUID":         e.DUID,
+		"COREDHCP_IP":           ip,
+		"COREDHCP_HOSTNAME":     e.Hostname,
+		"COREDHCP_VENDOR_CLASS": e.VendorClass,
+		"COREDHCP_BOOT_FILE":    e.BootFile,
+	} {
+		if value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	if e.Expires != nil {
+		env = append(env, "COREDHCP_EXPIRES="+e.Expires.UTC().Format(time.RFC3339))
+	}
+	return args, env
+}
+
+// run runs the program for an event
+func (p *PluginState) run(e leaseevents.Event) {
+	args, env := command(e)
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.program, args...)
+	cmd.Env = append(os.Environ(), env...)
+	// Not a pipe, which would keep Wait blocked after the timeout until the
+	// children of the program exit
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Warningf("%s failed for the %s event of %s: %v", p.program, e.Event, e.IP, err)
+		return
+	}
+	log.Debugf("ran %s for the %s event of %s", p.program, e.Event, e.IP)
+}
+
+// Handler4 handles DHCPv4 packets for the exechook plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.tracker.Handle4(req, resp)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the exechook plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.tracker.Handle6(req, resp)
+	return resp, false
+}
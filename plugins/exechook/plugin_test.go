@@ -0,0 +1,103 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package exechook
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeHook writes a shell script running body
+func writeHook(t *testing.T, body string) string {
+	dir := tempDir(t)
+	program := filepath.Join(dir, "hook")
+	require.NoError(t, ioutil.WriteFile(program, []byte("#!/bin/sh\n"+body+"\n"), 0755))
+	return program
+}
+
+func TestParseArgs(t *testing.T) {
+	program := writeHook(t, "true")
+	p, err := parseArgs(program, "events=allocate,release", "concurrency=4", "timeout=1m", "queue=8")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{leaseevents.Allocate: true, leaseevents.Release: true}, p.events)
+	assert.Equal(t, 4, p.concurrency)
+	assert.Equal(t, time.Minute, p.timeout)
+	assert.Equal(t, 8, cap(p.queue))
+
+	notExecutable := filepath.Join(tempDir(t), "hook")
+	require.NoError(t, ioutil.WriteFile(notExecutable, nil, 0644))
+	for _, args := range [][]string{
+		{},
+		{"/nonexistent"},
+		{notExecutable},
+		{filepath.Dir(program)},
+		{program, "events=boot"},
+		{program, "concurrency=0"},
+		{program, "timeout=never"},
+		{program, "allocate"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestRun(t *testing.T) {
+	out := filepath.Join(tempDir(t), "out")
+	program := writeHook(t, `echo "$@" >> `+out+`; env | grep ^COREDHCP_ | sort >> `+out)
+	p, err := parseArgs(program)
+	require.NoError(t, err)
+
+	expires := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.run(leaseevents.Event{
+		Event:       leaseevents.Allocate,
+		IP:          net.IPv4(192, 0, 2, 100),
+		HWAddr:      "00:01:02:03:04:05",
+		Hostname:    "host",
+		VendorClass: "MSFT 5.0",
+		Expires:     &expires,
+	})
+	p.run(leaseevents.Event{Event: leaseevents.Release, IP: net.ParseIP("2001:db8::100"), DUID: "00030001000102030405"})
+
+	data, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"allocate 00:01:02:03:04:05 192.0.2.100 host",
+		"COREDHCP_EVENT=allocate",
+		"COREDHCP_EXPIRES=2021-01-01T12:00:00Z",
+		"COREDHCP_HOSTNAME=host",
+		"COREDHCP_HWADDR=00:01:02:03:04:05",
+		"COREDHCP_IP=192.0.2.100",
+		"COREDHCP_VENDOR_CLASS=MSFT 5.0",
+		"release 00030001000102030405 2001:db8::100",
+		"COREDHCP_DUID=00030001000102030405",
+		"COREDHCP_EVENT=release",
+		"COREDHCP_IP=2001:db8::100",
+		"",
+	}, "\n"), string(data))
+}
+
+func TestTimeout(t *testing.T) {
+	p, err := parseArgs(writeHook(t, "exec sleep 10"), "timeout=50ms")
+	require.NoError(t, err)
+	start := time.Now()
+	p.run(leaseevents.Event{Event: leaseevents.Allocate})
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
@@ -22,6 +22,13 @@
 //     ...
 //
 // If the file path is not absolute, it is relative to the cwd where coredhcp is run.
+//
+// Records can be added while the server runs with AddRecord, eg. from the web
+// UI of the status plugin: they are appended to the file.
+//
+// For DHCPv4, the static bindings are also served to plain BOOTP clients (no
+// DHCP message type option); those bindings have no lease time and never
+// expire.
 package file
 
 import (
@@ -30,12 +37,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/ra"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -52,6 +63,14 @@ var Plugin = plugins.Plugin{
 // StaticRecords holds a MAC -> IP address mapping
 var StaticRecords map[string]net.IP
 
+// recordsLock protects StaticRecords, loaded from recordsFile, with IPv6
+// addresses if recordsV6
+var (
+	recordsLock sync.RWMutex
+	recordsFile string
+	recordsV6   bool
+)
+
 // DHCPv6Records and DHCPv4Records are mappings between MAC addresses in
 // form of a string, to network configurations.
 var (
@@ -138,14 +157,16 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		return resp, false
 	}
 
-	mac, err := dhcpv6.ExtractMAC(req)
-	if err != nil {
+	mac, source := duid.HWAddr(req)
+	if mac == nil {
 		log.Warningf("Could not find client MAC, passing")
 		return resp, false
 	}
-	log.Debugf("looking up an IP address for MAC %s", mac.String())
+	log.Debugf("looking up an IP address for MAC %s, from the %s", mac.String(), source)
 
+	recordsLock.RLock()
 	ipaddr, ok := StaticRecords[mac.String()]
+	recordsLock.RUnlock()
 	if !ok {
 		log.Warningf("MAC address %s is unknown", mac.String())
 		return resp, false
@@ -167,7 +188,16 @@ func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 
 // Handler4 handles DHCPv4 packets for the file plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeNone:
+	default:
+		// No address is assigned in reply to other messages (eg. INFORM);
+		// let the following plugins handle them
+		return resp, false
+	}
+	recordsLock.RLock()
 	ipaddr, ok := StaticRecords[req.ClientHWAddr.String()]
+	recordsLock.RUnlock()
 	if !ok {
 		log.Warningf("MAC address %s is unknown", req.ClientHWAddr.String())
 		return resp, false
@@ -179,6 +209,9 @@ func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 
 func setup6(args ...string) (handler.Handler6, error) {
 	h6, _, err := setupFile(true, args...)
+	if err == nil {
+		ra.AdvertiseAddresses()
+	}
 	return h6, err
 }
 
@@ -205,7 +238,67 @@ func setupFile(v6 bool, args ...string) (handler.Handler6, handler.Handler4, err
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to load DHCPv6 records: %v", err)
 	}
-	StaticRecords = records
+	recordsLock.Lock()
+	StaticRecords, recordsFile, recordsV6 = records, filename, v6
+	recordsLock.Unlock()
 	log.Infof("loaded %d leases from %s", len(records), filename)
 	return Handler6, Handler4, nil
 }
+
+// Records returns a copy of the static records, and whether their addresses
+// are IPv6 ones
+func Records() (map[string]net.IP, bool) {
+	recordsLock.RLock()
+	defer recordsLock.RUnlock()
+	records := make(map[string]net.IP, len(StaticRecords))
+	for mac, ip := range StaticRecords {
+		records[mac] = ip
+	}
+	return records, recordsV6
+}
+
+// AddRecord adds a static record for a MAC address without one, and appends it
+// to the file the records were loaded from
+func AddRecord(mac net.HardwareAddr, ip net.IP) error {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+	if recordsFile == "" {
+		return errors.New("no file of records loaded")
+	}
+	if recordsV6 && (ip.To16() == nil || ip.To4() != nil) {
+		return fmt.Errorf("expected an IPv6 address, got: %v", ip)
+	}
+	if !recordsV6 && ip.To4() == nil {
+		return fmt.Errorf("expected an IPv4 address, got: %v", ip)
+	}
+	if _, ok := StaticRecords[mac.String()]; ok {
+		return fmt.Errorf("MAC address %s has a record already", mac)
+	}
+	for m, other := range StaticRecords {
+		if other.Equal(ip) {
+			return fmt.Errorf("address %s is recorded for %s already", ip, m)
+		}
+	}
+	data, err := ioutil.ReadFile(recordsFile)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("%s %s\n", mac, ip)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	f, err := os.OpenFile(recordsFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	StaticRecords[mac.String()] = ip
+	log.Infof("added record %s %s to %s", mac, ip, recordsFile)
+	return nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package fqdn decodes and encodes the Client FQDN options, option 81 in
+// DHCPv4 (RFC4702) and option 39 in DHCPv6 (RFC4704), through which clients
+// send their name and negotiate with the server who updates the DNS, and
+// gives plugins the host name of the clients.
+package fqdn
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Flags of the Client FQDN options
+const (
+	// FlagS is set when the server performs the A or AAAA update
+	FlagS uint8 = 1 << 0
+	// FlagO is set by the server when it overrides the S flag of the client
+	FlagO uint8 = 1 << 1
+	// FlagE is set when the name is in DNS wire format, DHCPv4 only. It is
+	// always set in DHCPv6
+	FlagE uint8 = 1 << 2
+	// FlagN is set when the server must not perform any update
+	FlagN uint8 = 1 << 3
+)
+
+// Option is a Client FQDN option
+type Option struct {
+	Flags uint8
+	// Name is the domain name, without trailing dot. Partial names (RFC4702
+	// §2.3.1) are returned as is, eg. a single label
+	Name string
+	// Partial is set when Name is not fully qualified
+	Partial bool
+}
+
+// decodeName reads a name in DNS wire format, without compression, which is
+// partial if it is missing the terminating root label
+func decodeName(data []byte) (string, bool, error) {
+	var labels []string
+	for len(data) > 0 {
+		length := int(data[0])
+		if length == 0 {
+			if len(data) > 1 {
+				return "", false, errors.New("trailing data after the domain name")
+			}
+			return strings.Join(labels, "."), false, nil
+		}
+		if length > 63 || length+1 > len(data) {
+			return "", false, errors.New("invalid label in the domain name")
+		}
+		labels = append(labels, string(data[1:1+length]))
+		data = data[1+length:]
+	}
+	return strings.Join(labels, "."), true, nil
+}
+
+// encodeName writes a name in DNS wire format, omitting the root label for
+// partial names
+func encodeName(name string, partial bool) []byte {
+	var data []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+	}
+	if !partial {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// Parse4 returns the Client FQDN option of a DHCPv4 request, or nil if there
+// is none
+func Parse4(req *dhcpv4.DHCPv4) (*Option, error) {
+	data := req.Options.Get(dhcpv4.OptionFQDN)
+	if data == nil {
+		return nil, nil
+	}
+	// Flags, and the deprecated RCODE1 and RCODE2
+	if len(data) < 3 {
+		return nil, errors.New("client FQDN option too short")
+	}
+	o := Option{Flags: data[0]}
+	if o.Flags&FlagE == 0 {
+		// Deprecated ASCII encoding, fully qualified if it ends with a dot
+		o.Name = string(data[3:])
+		o.Partial = !strings.HasSuffix(o.Name, ".")
+		o.Name = strings.TrimSuffix(o.Name, ".")
+		return &o, nil
+	}
+	var err error
+	o.Name, o.Partial, err = decodeName(data[3:])
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ToBytes4 returns the payload of a DHCPv4 Client FQDN option. The name is
+// encoded in wire format when the E flag is set
+func (o *Option) ToBytes4() []byte {
+	// RCODE1 and RCODE2 are 255 in responses (RFC4702 §2.2)
+	data := []byte{o.Flags, 255, 255}
+	if o.Flags&FlagE != 0 {
+		return append(data, encodeName(o.Name, o.Partial)...)
+	}
+	data = append(data, o.Name...)
+	if !o.Partial && o.Name != "" {
+		data = append(data, '.')
+	}
+	return data
+}
+
+// Parse6 returns the Client FQDN option of a DHCPv6 message, or nil if there
+// is none
+func Parse6(msg *dhcpv6.Message) (*Option, error) {
+	opt := msg.Options.GetOne(dhcpv6.OptionFQDN)
+	if opt == nil {
+		return nil, nil
+	}
+	// The library drops partial names when parsing the option, but keeps its
+	// original payload: decode it again
+	data := opt.ToBytes()
+	if len(data) < 1 {
+		return nil, errors.New("client FQDN option too short")
+	}
+	o := Option{Flags: data[0]}
+	var err error
+	o.Name, o.Partial, err = decodeName(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// ToOption6 returns the DHCPv6 Client FQDN option
+func (o *Option) ToOption6() dhcpv6.Option {
+	return &dhcpv6.OptionGeneric{
+		OptionCode: dhcpv6.OptionFQDN,
+		OptionData: append([]byte{o.Flags}, encodeName(o.Name, o.Partial)...),
+	}
+}
+
+// HostName returns the first label of a name if it is a valid host name
+// (RFC1123), lowercased, or ""
+func HostName(name string) string {
+	host := strings.ToLower(strings.SplitN(name, ".", 2)[0])
+	if len(host) == 0 || len(host) > 63 || host[0] == '-' || host[len(host)-1] == '-' {
+		return ""
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return ""
+		}
+	}
+	return host
+}
+
+// Sanitize turns the first label of a name into a valid host name: it is
+// lowercased, runs of invalid characters are replaced with a hyphen, and it is
+// truncated to 63 characters. It is "" if nothing is left
+func Sanitize(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(strings.SplitN(name, ".", 2)[0]) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			b.WriteRune(c)
+			hyphen = false
+		} else if !hyphen {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	host := strings.Trim(b.String(), "-")
+	if len(host) > 63 {
+		host = strings.TrimRight(host[:63], "-")
+	}
+	return host
+}
+
+// Name4 returns the name sent by a DHCPv4 client, as is, in its Client FQDN
+// option, or its Host Name option (12)
+func Name4(req *dhcpv4.DHCPv4) string {
+	if o, err := Parse4(req); err == nil && o != nil {
+		return o.Name
+	}
+	return req.HostName()
+}
+
+// HostName4 returns the host name of a DHCPv4 client, from its Client FQDN
+// option, or its Host Name option (12). It is "" if the client sent none, or
+// an invalid one
+func HostName4(req *dhcpv4.DHCPv4) string {
+	return HostName(Name4(req))
+}
+
+// HostName6 returns the host name of a DHCPv6 client, from its Client FQDN
+// option. It is "" if the client sent none, or an invalid one
+func HostName6(msg *dhcpv6.Message) string {
+	if o, err := Parse6(msg); err == nil && o != nil {
+		return HostName(o.Name)
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fqdn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest4(t *testing.T, opts ...dhcpv4.Option) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New()
+	require.NoError(t, err)
+	for _, opt := range opts {
+		req.UpdateOption(opt)
+	}
+	return req
+}
+
+func TestParse4(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		want Option
+	}{
+		{[]byte{FlagS | FlagE, 0, 0, 4, 'h', 'o', 's', 't', 3, 'o', 'r', 'g', 0},
+			Option{Flags: FlagS | FlagE, Name: "host.org"}},
+		{[]byte{FlagE, 0, 0, 4, 'h', 'o', 's', 't'},
+			Option{Flags: FlagE, Name: "host", Partial: true}},
+		{append([]byte{FlagN, 0, 0}, "host.org."...),
+			Option{Flags: FlagN, Name: "host.org"}},
+		{append([]byte{0, 0, 0}, "host"...),
+			Option{Name: "host", Partial: true}},
+	} {
+		o, err := Parse4(newRequest4(t, dhcpv4.OptGeneric(dhcpv4.OptionFQDN, tt.data)))
+		require.NoError(t, err)
+		assert.Equal(t, &tt.want, o)
+		// Answers have the same format, with RCODE1 and RCODE2 set
+		answer := append([]byte{tt.data[0], 255, 255}, tt.data[3:]...)
+		assert.Equal(t, answer, o.ToBytes4())
+	}
+
+	o, err := Parse4(newRequest4(t))
+	assert.NoError(t, err)
+	assert.Nil(t, o)
+	for _, data := range [][]byte{
+		{FlagE, 0},
+		{FlagE, 0, 0, 5, 'h', 'o', 's', 't'},
+		{FlagE, 0, 0, 4, 'h', 'o', 's', 't', 0, 0},
+	} {
+		_, err := Parse4(newRequest4(t, dhcpv4.OptGeneric(dhcpv4.OptionFQDN, data)))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestParse6(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		want Option
+	}{
+		{[]byte{FlagS, 4, 'h', 'o', 's', 't', 3, 'o', 'r', 'g', 0},
+			Option{Flags: FlagS, Name: "host.org"}},
+		{[]byte{0, 4, 'h', 'o', 's', 't'},
+			Option{Name: "host", Partial: true}},
+	} {
+		// Parse the message from the wire, as the library loses partial
+		// names when parsing the option
+		data := append([]byte{byte(dhcpv6.MessageTypeRequest), 0, 0, 1, 0, byte(dhcpv6.OptionFQDN), 0, byte(len(tt.data))}, tt.data...)
+		msg, err := dhcpv6.MessageFromBytes(data)
+		require.NoError(t, err)
+		o, err := Parse6(msg)
+		require.NoError(t, err)
+		assert.Equal(t, &tt.want, o)
+		assert.Equal(t, tt.data, o.ToOption6().ToBytes())
+	}
+}
+
+func TestHostName(t *testing.T) {
+	assert.Equal(t, "host-1", HostName("Host-1.example.org"))
+	for _, name := range []string{"", "-host", "host-", "host_1", ".org", string(make([]byte, 64))} {
+		assert.Equal(t, "", HostName(name), name)
+	}
+
+	assert.Equal(t, "my-host-1", Sanitize("My_Host  1.example.org"))
+	assert.Equal(t, "host", Sanitize("-host-"))
+	assert.Equal(t, "", Sanitize("__"))
+	assert.Len(t, Sanitize(strings.Repeat("a", 62)+"-b"), 62)
+
+	// The Client FQDN option has precedence over the Host Name option
+	assert.Equal(t, "host", HostName4(newRequest4(t, dhcpv4.OptHostName("Host"))))
+	assert.Equal(t, "fqdn", HostName4(newRequest4(t,
+		dhcpv4.OptHostName("host"),
+		dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{0, 0, 0}, "fqdn.example.org."...)),
+	)))
+	assert.Equal(t, "", HostName4(newRequest4(t)))
+}
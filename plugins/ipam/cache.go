@@ -0,0 +1,239 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ipam
+
+import (
+	"container/list"
+	"expvar"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics holds the statistics of the caches, by cache name, eg.
+// "http:https://ipam.example.org/pools/1": {"hits": 80, "misses": 20,
+// "hit_rate": 0.8, ...}. It is published with expvar
+var metrics = expvar.NewMap("ipam_cache")
+
+// Cache is a driver answering the lookups from memory, in front of another
+// driver. It keeps the most recently used addresses, and the clients without
+// address (negative entries), each for a limited time. The writes go through
+// to the driver and update the cache, so that it only misses the changes
+// made by others, until its entries expire
+type Cache struct {
+	driver      Driver
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu sync.Mutex
+	// entries holds the elements of lru, by MAC address. The most
+	// recently used entries are at the front of lru
+	entries map[string]*list.Element
+	lru     *list.List
+
+	hits, negativeHits, misses expvar.Int
+}
+
+type cacheEntry struct {
+	mac string
+	// ip is nil for a client without address
+	ip      net.IP
+	expires time.Time
+}
+
+// NewCache returns a cache of at most size entries in front of a driver.
+// The addresses are kept for ttl, the absence of address for negativeTTL,
+// which disables the negative entries if it is zero. The statistics of the
+// cache are published under name
+func NewCache(d Driver, name string, size int, ttl, negativeTTL time.Duration) *Cache {
+	c := Cache{
+		driver:      d,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+	stats := new(expvar.Map).Init()
+	stats.Set("hits", &c.hits)
+	stats.Set("negative_hits", &c.negativeHits)
+	stats.Set("misses", &c.misses)
+	stats.Set("entries", expvar.Func(func() interface{} {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.lru.Len()
+	}))
+	stats.Set("hit_rate", expvar.Func(func() interface{} { return c.HitRate() }))
+	metrics.Set(name, stats)
+	return &c
+}
+
+// ParseCache parses the settings of a cache, <size>[:<TTL>[:<negative TTL>]].
+// The TTLs default to a minute and 10 seconds
+func ParseCache(value string) (size int, ttl, negativeTTL time.Duration, err error) {
+	ttl, negativeTTL = time.Minute, 10*time.Second
+	fields := strings.Split(value, ":")
+	if len(fields) > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid cache %q, expected <size>[:<TTL>[:<negative TTL>]]", value)
+	}
+	if size, err = strconv.Atoi(fields[0]); err != nil || size <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid cache size %q", fields[0])
+	}
+	if len(fields) > 1 {
+		if ttl, err = time.ParseDuration(fields[1]); err != nil || ttl <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid cache TTL %q", fields[1])
+		}
+	}
+	if len(fields) > 2 {
+		if negativeTTL, err = time.ParseDuration(fields[2]); err != nil || negativeTTL < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid cache negative TTL %q", fields[2])
+		}
+	}
+	return size, ttl, negativeTTL, nil
+}
+
+// HitRate returns the share of the lookups answered from the cache
+func (c *Cache) HitRate() float64 {
+	hits := c.hits.Value() + c.negativeHits.Value()
+	if total := hits + c.misses.Value(); total > 0 {
+		return float64(hits) / float64(total)
+	}
+	return 0
+}
+
+// get returns the cached address of a client, and whether it is cached
+func (c *Cache) get(mac string, now time.Time) (net.IP, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[mac]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, mac)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.ip, true
+}
+
+// set caches the address of a client, nil for none
+func (c *Cache) set(mac string, ip net.IP, now time.Time) {
+	ttl := c.ttl
+	if ip == nil {
+		ttl = c.negativeTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl == 0 {
+		c.remove(mac)
+		return
+	}
+	entry := &cacheEntry{mac: mac, ip: ip, expires: now.Add(ttl)}
+	if e, ok := c.entries[mac]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[mac] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).mac)
+	}
+}
+
+// invalidate forgets what is known of a client
+func (c *Cache) invalidate(mac string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(mac)
+}
+
+// remove removes the entry of a client. The caller must hold the lock
+func (c *Cache) remove(mac string) {
+	if e, ok := c.entries[mac]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, mac)
+	}
+}
+
+// Lookup implements Driver.Lookup
+func (c *Cache) Lookup(hwaddr net.HardwareAddr) (net.IP, error) {
+	mac, now := hwaddr.String(), time.Now()
+	if ip, ok := c.get(mac, now); ok {
+		if ip == nil {
+			c.negativeHits.Add(1)
+		} else {
+			c.hits.Add(1)
+		}
+		return ip, nil
+	}
+	c.misses.Add(1)
+	ip, err := c.driver.Lookup(hwaddr)
+	if err != nil {
+		return nil, err
+	}
+	c.set(mac, ip, now)
+	return ip, nil
+}
+
+// Allocate implements Driver.Allocate
+func (c *Cache) Allocate(lease Lease) (net.IP, error) {
+	ip, err := c.driver.Allocate(lease)
+	c.update(lease.HWAddr, ip, err)
+	return ip, err
+}
+
+// Renew implements Driver.Renew
+func (c *Cache) Renew(lease Lease) error {
+	err := c.driver.Renew(lease)
+	c.update(lease.HWAddr, lease.IP, err)
+	return err
+}
+
+// Release implements Driver.Release
+func (c *Cache) Release(lease Lease) error {
+	err := c.driver.Release(lease)
+	c.update(lease.HWAddr, nil, err)
+	return err
+}
+
+// Leases implements Lister.Leases, for the drivers implementing it, and
+// caches the addresses of the active leases, to warm the cache up. The last
+// ones listed are kept if they do not all fit
+func (c *Cache) Leases() ([]Lease, error) {
+	l, ok := c.driver.(Lister)
+	if !ok {
+		return nil, ErrNoList
+	}
+	leases, err := l.Leases()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, lease := range leases {
+		if lease.Expires.IsZero() || lease.Expires.After(now) {
+			c.set(lease.HWAddr.String(), lease.IP, now)
+		}
+	}
+	return leases, nil
+}
+
+// update caches the address of a client after a write, or forgets it if
+// the write failed, as the driver may have applied it or not
+func (c *Cache) update(hwaddr net.HardwareAddr, ip net.IP, err error) {
+	if err != nil {
+		c.invalidate(hwaddr.String())
+		return
+	}
+	c.set(hwaddr.String(), ip, time.Now())
+}
@@ -0,0 +1,123 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ipam
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDriver knows the addresses of ips, and counts the lookups
+type countingDriver struct {
+	ips     map[string]net.IP
+	lookups int
+	err     error
+}
+
+func (d *countingDriver) Lookup(hwaddr net.HardwareAddr) (net.IP, error) {
+	d.lookups++
+	return d.ips[hwaddr.String()], nil
+}
+
+func (d *countingDriver) Allocate(lease Lease) (net.IP, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	d.ips[lease.HWAddr.String()] = lease.IP
+	return lease.IP, nil
+}
+
+func (d *countingDriver) Renew(lease Lease) error { return d.err }
+
+func (d *countingDriver) Release(lease Lease) error {
+	delete(d.ips, lease.HWAddr.String())
+	return d.err
+}
+
+func TestCache(t *testing.T) {
+	known := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	unknown := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x66}
+	d := &countingDriver{ips: map[string]net.IP{known.String(): net.IPv4(10, 0, 0, 10)}}
+	c := NewCache(d, "test", 2, time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		ip, err := c.Lookup(known)
+		require.NoError(t, err)
+		assert.True(t, ip.Equal(net.IPv4(10, 0, 0, 10)))
+		ip, err = c.Lookup(unknown)
+		require.NoError(t, err)
+		assert.Nil(t, ip)
+	}
+	assert.Equal(t, 2, d.lookups, "the lookups should be cached, including the negative ones")
+	assert.InDelta(t, 4.0/6, c.HitRate(), 0.001)
+
+	// The writes update the cache
+	_, err := c.Allocate(Lease{HWAddr: unknown, IP: net.IPv4(10, 0, 0, 11)})
+	require.NoError(t, err)
+	ip, err := c.Lookup(unknown)
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.IPv4(10, 0, 0, 11)))
+	require.NoError(t, c.Release(Lease{HWAddr: known, IP: net.IPv4(10, 0, 0, 10)}))
+	ip, err = c.Lookup(known)
+	require.NoError(t, err)
+	assert.Nil(t, ip)
+	assert.Equal(t, 2, d.lookups)
+
+	// A failed write invalidates the entry
+	d.err = errors.New("unavailable")
+	assert.Error(t, c.Renew(Lease{HWAddr: unknown, IP: net.IPv4(10, 0, 0, 11)}))
+	_, err = c.Lookup(unknown)
+	require.NoError(t, err)
+	assert.Equal(t, 3, d.lookups)
+
+	// The least recently used entry is evicted
+	third := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x77}
+	_, err = c.Lookup(third)
+	require.NoError(t, err)
+	_, err = c.Lookup(known)
+	require.NoError(t, err)
+	assert.Equal(t, 5, d.lookups)
+}
+
+func TestCacheExpiry(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	d := &countingDriver{ips: map[string]net.IP{}}
+	// No negative entries
+	c := NewCache(d, "test-expiry", 10, time.Minute, 0)
+	for i := 0; i < 2; i++ {
+		_, err := c.Lookup(mac)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, d.lookups)
+
+	c.set(mac.String(), net.IPv4(10, 0, 0, 10), time.Now().Add(-2*time.Minute))
+	_, err := c.Lookup(mac)
+	require.NoError(t, err)
+	assert.Equal(t, 3, d.lookups, "expired entries should be looked up again")
+}
+
+func TestParseCache(t *testing.T) {
+	size, ttl, negativeTTL, err := ParseCache("1000")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, size)
+	assert.Equal(t, time.Minute, ttl)
+	assert.Equal(t, 10*time.Second, negativeTTL)
+
+	size, ttl, negativeTTL, err = ParseCache("10:5m:0s")
+	require.NoError(t, err)
+	assert.Equal(t, 10, size)
+	assert.Equal(t, 5*time.Minute, ttl)
+	assert.Equal(t, time.Duration(0), negativeTTL)
+
+	for _, value := range []string{"", "0", "-1", "10:0s", "10:1m:-1s", "10:1m:1s:1s", "ten"} {
+		_, _, _, err := ParseCache(value)
+		assert.Error(t, err, value)
+	}
+}
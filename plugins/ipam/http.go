@@ -0,0 +1,185 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ipam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds the requests of the http driver
+const httpTimeout = 5 * time.Second
+
+// httpLease is the JSON encoding of a Lease
+type httpLease struct {
+	HWAddr   string     `json:"hwaddr"`
+	IP       string     `json:"ip,omitempty"`
+	Hostname string     `json:"hostname,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+func toHTTPLease(l Lease) httpLease {
+	h := httpLease{HWAddr: l.HWAddr.String(), Hostname: l.Hostname}
+	if l.IP != nil {
+		h.IP = l.IP.String()
+	}
+	if !l.Expires.IsZero() {
+		h.Expires = &l.Expires
+	}
+	return h
+}
+
+// HTTPDriver is a reference driver, for IPAMs exposing (or proxied by) a
+// small JSON API. Relative to its base URL:
+// - GET lookup?hwaddr=<MAC> returns {"ip": "<address>"}, or 404 when the
+// client has no address
+// - POST allocate with {"hwaddr", "ip" (the hint), "hostname", "expires"}
+// returns {"ip": "<address>"}
+// - POST renew and POST release with {"hwaddr", "ip", "hostname", "expires"}
+// return any 2xx status
+// - GET leases, optional, returns the leases of the pool as a list of
+// {"hwaddr", "ip", "hostname", "expires"}, for the preloading
+//
+// Credentials can be passed in the URL, for HTTP basic authentication.
+type HTTPDriver struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// NewHTTPDriver returns an http driver for the API at base
+func NewHTTPDriver(base string) (*HTTPDriver, error) {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, expected an http(s) URL", base)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &HTTPDriver{base: u, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+// do sends a request to the endpoint, and decodes the ip of the response if
+// ip is not nil. ip is left nil when a GET endpoint answers 404
+func (d *HTTPDriver) do(method, endpoint string, query url.Values, body interface{}, ip *net.IP) error {
+	u := d.base.ResolveReference(&url.URL{Path: endpoint, RawQuery: query.Encode()})
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: unexpected status %s: %s", endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	if ip == nil {
+		return nil
+	}
+	var answer struct {
+		IP string `json:"ip"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return fmt.Errorf("%s: invalid answer: %w", endpoint, err)
+	}
+	if *ip = net.ParseIP(answer.IP); *ip == nil {
+		return fmt.Errorf("%s: invalid IP address %q", endpoint, answer.IP)
+	}
+	return nil
+}
+
+// Lookup implements Driver.Lookup
+func (d *HTTPDriver) Lookup(hwaddr net.HardwareAddr) (net.IP, error) {
+	var ip net.IP
+	if err := d.do(http.MethodGet, "lookup", url.Values{"hwaddr": {hwaddr.String()}}, nil, &ip); err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+// Allocate implements Driver.Allocate
+func (d *HTTPDriver) Allocate(lease Lease) (net.IP, error) {
+	var ip net.IP
+	if err := d.do(http.MethodPost, "allocate", nil, toHTTPLease(lease), &ip); err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+// Renew implements Driver.Renew
+func (d *HTTPDriver) Renew(lease Lease) error {
+	return d.do(http.MethodPost, "renew", nil, toHTTPLease(lease), nil)
+}
+
+// Release implements Driver.Release
+func (d *HTTPDriver) Release(lease Lease) error {
+	return d.do(http.MethodPost, "release", nil, toHTTPLease(lease), nil)
+}
+
+// Leases implements Lister.Leases
+func (d *HTTPDriver) Leases() ([]Lease, error) {
+	u := d.base.ResolveReference(&url.URL{Path: "leases"})
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	// The pools may be large, the timeout of the other requests does not
+	// apply
+	resp, err := (&http.Client{Transport: d.client.Transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("leases: unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var answer []httpLease
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("leases: invalid answer: %w", err)
+	}
+	leases := make([]Lease, 0, len(answer))
+	for _, h := range answer {
+		hwaddr, err := net.ParseMAC(h.HWAddr)
+		if err != nil {
+			return nil, fmt.Errorf("leases: invalid hardware address %q", h.HWAddr)
+		}
+		l := Lease{HWAddr: hwaddr, IP: net.ParseIP(h.IP), Hostname: h.Hostname}
+		if l.IP == nil {
+			return nil, fmt.Errorf("leases: invalid IP address %q of %s", h.IP, h.HWAddr)
+		}
+		if h.Expires != nil {
+			l.Expires = *h.Expires
+		}
+		leases = append(leases, l)
+	}
+	return leases, nil
+}
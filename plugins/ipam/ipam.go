@@ -0,0 +1,107 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ipam provides the interface to the external IP address management
+// systems backing the range plugin, and a registry of their drivers.
+//
+// Drivers are registered by name, usually from the init function of their
+// package, and selected with the ipam=<driver>[:<argument>] argument of the
+// range plugin. This package provides the http driver, see NewHTTPDriver,
+// and a cache to put in front of the drivers, see NewCache. The drivers
+// implementing Lister can have their leases preloaded at startup.
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lease describes the lease of a client, as sent to the drivers
+type Lease struct {
+	HWAddr net.HardwareAddr
+	// IP is the address of the lease. For Allocate, it is the address the
+	// client asked for, if any, that the driver MAY return
+	IP       net.IP
+	Hostname string
+	Expires  time.Time
+}
+
+// Driver is the interface to an external IPAM. The calls are made while
+// handling the requests of the clients, drivers should answer quickly
+type Driver interface {
+	// Lookup returns the address assigned to a client, nil if it has none
+	Lookup(hwaddr net.HardwareAddr) (net.IP, error)
+	// Allocate assigns an address to a client, and returns it
+	Allocate(lease Lease) (net.IP, error)
+	// Renew extends the lease of a client
+	Renew(lease Lease) error
+	// Release returns the address of a client to the IPAM
+	Release(lease Lease) error
+}
+
+// Lister is implemented by the drivers able to list the leases of their
+// pool, for the range plugin to preload them at startup (preload=<max>),
+// before it handles requests
+type Lister interface {
+	// Leases returns the leases of the pool. Expires is zero for the leases
+	// without an end
+	Leases() ([]Lease, error)
+}
+
+// ErrNoList is returned when preloading the leases of a driver which cannot
+// list them
+var ErrNoList = errors.New("the IPAM driver cannot list its leases")
+
+// Factory creates a driver from its argument, which the driver defines
+type Factory func(arg string) (Driver, error)
+
+var (
+	driversMu sync.Mutex
+	drivers   = map[string]Factory{
+		"http": func(arg string) (Driver, error) { return NewHTTPDriver(arg) },
+	}
+)
+
+// Register registers a driver. It panics if a driver is already registered
+// with the same name
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("ipam: driver %q registered twice", name))
+	}
+	drivers[name] = factory
+}
+
+// New creates a driver from a <driver>[:<argument>] specification
+func New(spec string) (Driver, error) {
+	name, arg := spec, ""
+	if sep := strings.IndexByte(spec, ':'); sep >= 0 {
+		name, arg = spec[:sep], spec[sep+1:]
+	}
+	driversMu.Lock()
+	factory, ok := drivers[name]
+	driversMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown IPAM driver %q, known drivers: %s", name, strings.Join(Drivers(), ", "))
+	}
+	return factory(arg)
+}
+
+// Drivers returns the names of the registered drivers
+func Drivers() []string {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,158 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ipam
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopDriver struct{}
+
+func (nopDriver) Lookup(net.HardwareAddr) (net.IP, error) { return nil, nil }
+func (nopDriver) Allocate(Lease) (net.IP, error)          { return nil, nil }
+func (nopDriver) Renew(Lease) error                       { return nil }
+func (nopDriver) Release(Lease) error                     { return nil }
+
+func TestRegistry(t *testing.T) {
+	var arg string
+	Register("nop", func(a string) (Driver, error) {
+		arg = a
+		return nopDriver{}, nil
+	})
+	defer func() {
+		driversMu.Lock()
+		delete(drivers, "nop")
+		driversMu.Unlock()
+	}()
+	assert.Panics(t, func() { Register("nop", nil) })
+	assert.Equal(t, []string{"http", "nop"}, Drivers())
+
+	d, err := New("nop:a:b")
+	require.NoError(t, err)
+	assert.Equal(t, nopDriver{}, d)
+	assert.Equal(t, "a:b", arg)
+
+	_, err = New("phpipam:https://ipam.example.org")
+	assert.Error(t, err)
+	_, err = New("http:ftp://ipam.example.org")
+	assert.Error(t, err)
+}
+
+func TestHTTPDriver(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	expires := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "dhcp:s3cr3t", user+":"+pass)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/pools/1/lookup":
+			if r.URL.Query().Get("hwaddr") != mac.String() {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"ip": "10.0.0.10"}`))
+		case "/pools/1/allocate":
+			var l httpLease
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&l))
+			assert.Equal(t, httpLease{HWAddr: mac.String(), IP: "10.0.0.11", Hostname: "node-1", Expires: &expires}, l)
+			_, _ = w.Write([]byte(`{"ip": "10.0.0.11"}`))
+		case "/pools/1/renew":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("unknown lease"))
+		}
+	}))
+	defer srv.Close()
+
+	d, err := NewHTTPDriver("http://dhcp:s3cr3t@" + srv.Listener.Addr().String() + "/pools/1")
+	require.NoError(t, err)
+	ip, err := d.Lookup(mac)
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 10), ip)
+	ip, err = d.Lookup(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x56})
+	require.NoError(t, err)
+	assert.Nil(t, ip)
+
+	ip, err = d.Allocate(Lease{HWAddr: mac, IP: net.IPv4(10, 0, 0, 11), Hostname: "node-1", Expires: expires})
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 11), ip)
+	assert.NoError(t, d.Renew(Lease{HWAddr: mac, IP: ip, Expires: expires}))
+	err = d.Release(Lease{HWAddr: mac, IP: ip})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown lease")
+
+	assert.Equal(t, []string{
+		"GET /pools/1/lookup",
+		"GET /pools/1/lookup",
+		"POST /pools/1/allocate",
+		"POST /pools/1/renew",
+		"POST /pools/1/release",
+	}, requests)
+}
+
+func TestHTTPLeases(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/pools/1/leases":
+			_, _ = w.Write([]byte(`[
+				{"hwaddr": "00:11:22:33:44:55", "ip": "10.0.0.10", "hostname": "node-1", "expires": "2021-03-01T12:00:00Z"},
+				{"hwaddr": "00:11:22:33:44:56", "ip": "10.0.0.11"}
+			]`))
+		case "/pools/2/leases":
+			_, _ = w.Write([]byte(`[{"hwaddr": "00:11:22:33:44:55", "ip": "10.0.0"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	d, err := NewHTTPDriver("http://" + srv.Listener.Addr().String() + "/pools/1")
+	require.NoError(t, err)
+	leases, err := d.Leases()
+	require.NoError(t, err)
+	assert.Equal(t, []Lease{
+		{
+			HWAddr:   net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			IP:       net.ParseIP("10.0.0.10"),
+			Hostname: "node-1",
+			Expires:  time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{HWAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x56}, IP: net.ParseIP("10.0.0.11")},
+	}, leases)
+
+	// The active leases warm the cache up
+	c := NewCache(d, "test-leases", 10, time.Minute, time.Minute)
+	_, err = c.Leases()
+	require.NoError(t, err)
+	requests = 0
+	ip, err := c.Lookup(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x56})
+	require.NoError(t, err)
+	assert.True(t, ip.Equal(net.IPv4(10, 0, 0, 11)))
+	_, err = c.Lookup(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests, "only the expired lease should be looked up")
+
+	_, err = NewCache(nopDriver{}, "test-nolist", 10, time.Minute, 0).Leases()
+	assert.Equal(t, ErrNoList, err)
+	for _, pool := range []string{"/pools/2", "/pools/3"} {
+		d, err := NewHTTPDriver("http://" + srv.Listener.Addr().String() + pool)
+		require.NoError(t, err)
+		_, err = d.Leases()
+		assert.Error(t, err, pool)
+	}
+}
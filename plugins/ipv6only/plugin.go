@@ -0,0 +1,128 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ipv6only implements a plugin sending the IPv6-Only Preferred option
+// (RFC8925, option 108) to DHCPv4 clients, telling those that can work in an
+// IPv6-only network to disable their IPv4 stack.
+//
+// The option is only sent to clients that request it in their Parameter
+// Request List. The arguments are optional:
+// - wait=<duration>: the V6ONLY_WAIT value sent to clients, how long they
+// should go without IPv4 before asking again. It defaults to 30m and can't
+// be shorter than 300s (MIN_V6ONLY_WAIT)
+// - <class>: only send the option to the clients of the class (see the class
+// package for the syntax). With several classes, clients matching any of
+// them get the option
+//
+// server4:
+//   plugins:
+//     - ipv6only: wait=1h link:10.0.1.0/24
+//
+// The number of clients that were told to go IPv6-only is logged.
+package ipv6only
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/ipv6only")
+
+// Plugin wraps the ipv6only plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "ipv6only",
+	Setup4: setup4,
+}
+
+const (
+	optionIPv6OnlyPreferred = 108
+	defaultWait             = 30 * time.Minute
+	minWait                 = 300 * time.Second
+)
+
+// Handler holds the configuration of a plugin instance
+type Handler struct {
+	wait    time.Duration
+	classes []*class.Matcher
+	// optedIn counts the clients that were sent the option
+	optedIn uint64
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	h := Handler{wait: defaultWait}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "wait=") {
+			wait, err := time.ParseDuration(strings.TrimPrefix(arg, "wait="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid V6ONLY_WAIT %q: %v", arg, err)
+			}
+			if wait < minWait {
+				return nil, fmt.Errorf("V6ONLY_WAIT %s is shorter than the minimum of %s", wait, minWait)
+			}
+			h.wait = wait
+			continue
+		}
+		m, err := class.Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		h.classes = append(h.classes, m)
+	}
+	log.Printf("loaded plugin for DHCPv4, V6ONLY_WAIT %s, %d classes", h.wait, len(h.classes))
+	return h.Handle4, nil
+}
+
+// requested returns whether the option is in the Parameter Request List of
+// req. Unlike req.IsOptionRequested, a missing list doesn't count as a
+// request for all options: RFC8925 requires an explicit request
+func requested(req *dhcpv4.DHCPv4, code uint8) bool {
+	for _, c := range req.ParameterRequestList() {
+		if c.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// eligible returns whether the option should be sent to the client
+func (h *Handler) eligible(req *dhcpv4.DHCPv4) bool {
+	if !requested(req, optionIPv6OnlyPreferred) {
+		return false
+	}
+	if len(h.classes) == 0 {
+		return true
+	}
+	for _, c := range h.classes {
+		if c.Match4(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle4 handles DHCPv4 packets for the ipv6only plugin
+func (h *Handler) Handle4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if !h.eligible(req) {
+		return resp, false
+	}
+	wait := make([]byte, 4)
+	binary.BigEndian.PutUint32(wait, uint32(h.wait/time.Second))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(optionIPv6OnlyPreferred), wait))
+
+	// Only count the final answer, not the offers
+	if resp.MessageType() == dhcpv4.MessageTypeAck {
+		n := atomic.AddUint64(&h.optedIn, 1)
+		log.Infof("client %s told to go IPv6-only, %d clients so far", req.ClientHWAddr, n)
+	}
+	return resp, false
+}
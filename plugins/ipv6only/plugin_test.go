@@ -0,0 +1,82 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ipv6only
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(t *testing.T, giaddr net.IP, codes ...dhcpv4.OptionCode) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv4.OptParameterRequestList(codes...))
+	req.GatewayIPAddr = giaddr
+	// Go through the wire format, as the server does
+	req, err = dhcpv4.FromBytes(req.ToBytes())
+	require.NoError(t, err)
+	return req
+}
+
+func TestSetup(t *testing.T) {
+	_, err := setup4()
+	assert.NoError(t, err)
+	_, err = setup4("wait=1h", "link:10.0.1.0/24", "vendor:MSFT")
+	assert.NoError(t, err)
+
+	for _, arg := range []string{"wait=10s", "wait=soon", "color:blue"} {
+		_, err := setup4(arg)
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	m := dhcpv4.GenericOptionCode(optionIPv6OnlyPreferred)
+	h := Handler{wait: defaultWait}
+
+	for _, tt := range []struct {
+		req  *dhcpv4.DHCPv4
+		want bool
+	}{
+		{request(t, net.IPv4zero, dhcpv4.OptionRouter, m), true},
+		{request(t, net.IPv4zero, dhcpv4.OptionRouter), false},
+	} {
+		stub, err := dhcpv4.NewReplyFromRequest(tt.req)
+		require.NoError(t, err)
+		resp, stop := h.Handle4(tt.req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		if tt.want {
+			assert.Equal(t, []byte{0, 0, 0x07, 0x08}, resp.Options.Get(m))
+		} else {
+			assert.Nil(t, resp.Options.Get(m))
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	handler, err := setup4("wait=5m", "link:10.0.1.0/24")
+	require.NoError(t, err)
+	m := dhcpv4.GenericOptionCode(optionIPv6OnlyPreferred)
+
+	for giaddr, want := range map[string]bool{
+		"10.0.1.1": true,
+		"10.0.2.1": false,
+	} {
+		req := request(t, net.ParseIP(giaddr), m)
+		stub, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+		require.NoError(t, err)
+		resp, _ := handler(req, stub)
+		if want {
+			assert.Equal(t, []byte{0, 0, 0x01, 0x2c}, resp.Options.Get(m), giaddr)
+		} else {
+			assert.Nil(t, resp.Options.Get(m), giaddr)
+		}
+	}
+}
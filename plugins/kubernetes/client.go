@@ -0,0 +1,240 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// apiPrefix is the path of the API group of the custom resources
+	apiPrefix = "/apis/dhcp.coredhcp.io/v1alpha1"
+
+	// Resources watched by the plugin
+	resourceReservations = "dhcpreservations"
+	resourcePools        = "dhcppools"
+
+	// Where the service account credentials are mounted in pods
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCA    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// listTimeout bounds how long listing the resources can take
+	listTimeout = time.Minute
+	// watchTimeout is how long the API server keeps a watch open, after
+	// which it is restarted from the last resource version
+	watchTimeout = 5 * time.Minute
+)
+
+// errExpired is returned when the resource version to watch from is too
+// old, and the resources must be listed again
+var errExpired = errors.New("resource version expired")
+
+// objectMeta holds the metadata of a resource used by the plugin
+type objectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// object is a custom resource, with its spec left for the caller to decode
+type object struct {
+	Metadata objectMeta      `json:"metadata"`
+	Spec     json.RawMessage `json:"spec"`
+}
+
+// key identifies an object in its resource
+func (o *object) key() string {
+	return o.Metadata.Namespace + "/" + o.Metadata.Name
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []object `json:"items"`
+}
+
+// watchEvent is a change notification of a watch. Object is an object for
+// ADDED, MODIFIED, DELETED and BOOKMARK events, and a Status for ERROR events
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// client is a minimal client of the Kubernetes API, listing and watching
+// the custom resources of the plugin
+type client struct {
+	server string
+	// namespace restricts the resources to a namespace, all of them if empty
+	namespace string
+	// tokenFile holds the bearer token. It is read on each request, since
+	// service account tokens are rotated
+	tokenFile string
+	http      *http.Client
+}
+
+// newClient returns a client of the API server at server, or of the cluster
+// the server runs in if empty
+func newClient(server, tokenFile, caFile, namespace string) (*client, error) {
+	if server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster, the API server must be set")
+		}
+		server = "https://" + joinHostPort(host, port)
+		if tokenFile == "" {
+			tokenFile = serviceAccountToken
+		}
+		if caFile == "" {
+			caFile = serviceAccountCA
+		}
+	}
+	if _, err := url.Parse(server); err != nil {
+		return nil, fmt.Errorf("invalid API server URL %q: %w", server, err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &client{
+		server:    strings.TrimSuffix(server, "/"),
+		namespace: namespace,
+		tokenFile: tokenFile,
+		http:      &http.Client{Transport: transport},
+	}, nil
+}
+
+func joinHostPort(host, port string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]:" + port
+	}
+	return host + ":" + port
+}
+
+func (c *client) url(resource string, query url.Values) string {
+	u := c.server + apiPrefix
+	if c.namespace != "" {
+		u += "/namespaces/" + url.PathEscape(c.namespace)
+	}
+	return u + "/" + resource + "?" + query.Encode()
+}
+
+// get sends a GET request, and returns the response if it is successful
+func (c *client) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var s status
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&s)
+		if resp.StatusCode == http.StatusGone {
+			return nil, errExpired
+		}
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, s.Message)
+	}
+	return resp, nil
+}
+
+// list returns the objects of a resource, and the resource version to watch
+// them from
+func (c *client) list(resource string) ([]object, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+	resp, err := c.get(ctx, c.url(resource, nil))
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var l objectList
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, "", err
+	}
+	return l.Items, l.Metadata.ResourceVersion, nil
+}
+
+// watch calls handle for the changes to a resource since the resource
+// version rv, until the API server ends the watch. It returns the resource
+// version to watch from next
+func (c *client) watch(resource, rv string, handle func(typ string, o *object)) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout+listTimeout)
+	defer cancel()
+	resp, err := c.get(ctx, c.url(resource, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {rv},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(watchTimeout / time.Second))},
+	}))
+	if err != nil {
+		return rv, err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev watchEvent
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return rv, nil
+			}
+			return rv, err
+		}
+		if ev.Type == "ERROR" {
+			var s status
+			if err := json.Unmarshal(ev.Object, &s); err == nil && s.Code == http.StatusGone {
+				return rv, errExpired
+			}
+			return rv, fmt.Errorf("watch error: %s", ev.Object)
+		}
+		var o object
+		if err := json.Unmarshal(ev.Object, &o); err != nil {
+			return rv, err
+		}
+		if o.Metadata.ResourceVersion != "" {
+			rv = o.Metadata.ResourceVersion
+		}
+		if ev.Type != "BOOKMARK" {
+			handle(ev.Type, &o)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package kubernetes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, apiPrefix+"/namespaces/provisioning/dhcpreservations", r.URL.Path)
+		assert.Equal(t, "Bearer s3cr3t", r.Header.Get("Authorization"))
+		fmt.Fprint(w, `{"metadata":{"resourceVersion":"42"},"items":[
+			{"metadata":{"name":"node-1","namespace":"provisioning","resourceVersion":"40"},"spec":{"macAddress":"00:11:22:33:44:55","ipAddress":"10.0.0.10"}}
+		]}`)
+	}))
+	defer srv.Close()
+
+	c, err := newClient(srv.URL, writeFile(t, "s3cr3t\n"), "", "provisioning")
+	require.NoError(t, err)
+	objects, rv, err := c.list(resourceReservations)
+	require.NoError(t, err)
+	assert.Equal(t, "42", rv)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "provisioning/node-1", objects[0].key())
+}
+
+func TestWatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, apiPrefix+"/dhcppools", r.URL.Path)
+		assert.Equal(t, "1", r.URL.Query().Get("watch"))
+		switch r.URL.Query().Get("resourceVersion") {
+		case "42":
+			fmt.Fprint(w, `{"type":"ADDED","object":{"metadata":{"name":"a","namespace":"ns","resourceVersion":"43"},"spec":{}}}
+{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"45"}}}
+{"type":"DELETED","object":{"metadata":{"name":"a","namespace":"ns","resourceVersion":"46"},"spec":{}}}
+`)
+		case "46":
+			fmt.Fprint(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"message":"too old resource version"}}`)
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := newClient(srv.URL, "", "", "")
+	require.NoError(t, err)
+	var events []string
+	rv, err := c.watch(resourcePools, "42", func(typ string, o *object) {
+		events = append(events, typ+" "+o.key())
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "46", rv)
+	assert.Equal(t, []string{"ADDED ns/a", "DELETED ns/a"}, events)
+
+	_, err = c.watch(resourcePools, "46", nil)
+	assert.Equal(t, errExpired, err)
+	_, err = c.watch(resourcePools, "1", nil)
+	assert.Equal(t, errExpired, err)
+}
+
+func TestNewClient(t *testing.T) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	defer os.Setenv("KUBERNETES_SERVICE_HOST", host)
+	_, err := newClient("", "", "", "")
+	assert.Error(t, err)
+	_, err = newClient("https://10.0.0.1:6443", "", writeFile(t, "not a certificate"), "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,501 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package kubernetes implements a plugin serving host reservations and
+// address pools defined as Kubernetes custom resources, for bare-metal
+// provisioning stacks running in a cluster. The resources are watched, and
+// changes are applied live.
+//
+// The custom resources (see crds.yaml) are DHCPReservations:
+//
+//  apiVersion: dhcp.coredhcp.io/v1alpha1
+//  kind: DHCPReservation
+//  metadata:
+//    name: node-1
+//  spec:
+//    macAddress: "00:11:22:33:44:55"
+//    ipAddress: 10.0.0.10
+//    hostname: node-1
+//    options: ["67=string:ipxe.efi"]
+//
+// and, for DHCPv4 only, DHCPPools:
+//
+//  apiVersion: dhcp.coredhcp.io/v1alpha1
+//  kind: DHCPPool
+//  metadata:
+//    name: provisioning
+//  spec:
+//    start: 10.0.0.100
+//    end: 10.0.0.199
+//    leaseTime: 1h
+//    options: ["3=ip:10.0.0.1"]
+//
+// The options are overrides with the syntax of the options plugin. The
+// server4 instance serves the IPv4 reservations, the server6 instance the IPv6
+// ones. Reservations take precedence over pools, and the pools are used in
+// the order of their namespace and name. Invalid resources are logged and
+// ignored, as are the reservations conflicting with a previous one.
+//
+// Arguments are key=value pairs, all optional:
+// - server=<URL>: the URL of the API server, the one of the cluster the
+// server runs in by default (with the credentials of its service account)
+// - token-file=<file>: a file holding a bearer token to authenticate with
+// - ca-file=<file>: the CA certificates to verify the API server with
+// - namespace=<namespace>: only watch the resources of a namespace
+//
+// server4:
+//   plugins:
+//     - kubernetes: namespace=provisioning
+//
+// The service account of the server needs to get, list and watch the
+// dhcpreservations and dhcppools resources of the dhcp.coredhcp.io group.
+//
+// As for the file plugin, the DHCPv4 clients with a reservation are not
+// handled by the following plugins. The pool leases are only kept in memory:
+// after a restart, clients get their address back when requesting it again,
+// if it is still free.
+package kubernetes
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/ra"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/kubernetes")
+
+// Plugin wraps the kubernetes plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "kubernetes",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// retryDelay is the delay before watching the resources again after an error
+var retryDelay = 5 * time.Second
+
+// lease is an address allocated from a pool
+type lease struct {
+	ip      net.IP
+	expires time.Time
+	pool    *pool
+}
+
+// PluginState holds the resources watched by an instance of the plugin
+type PluginState struct {
+	sync.Mutex
+	v6     bool
+	client *client
+	// reservationObjects and poolObjects hold the valid resources by
+	// namespace/name, as received from the API server
+	reservationObjects map[string]*reservation
+	poolObjects        map[string]*pool
+	// reservations holds the reservations by MAC address, and pools the
+	// pools in the order they are used, as indexed from the resources
+	reservations map[string]*reservation
+	pools        []*pool
+	// leases holds the pool leases by MAC address
+	leases map[string]*lease
+}
+
+func newPluginState(v6 bool, c *client) *PluginState {
+	return &PluginState{
+		v6:                 v6,
+		client:             c,
+		reservationObjects: make(map[string]*reservation),
+		poolObjects:        make(map[string]*pool),
+		reservations:       make(map[string]*reservation),
+		leases:             make(map[string]*lease),
+	}
+}
+
+func parseArgs(args ...string) (*client, error) {
+	var server, tokenFile, caFile, namespace string
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "server":
+			server = value
+		case "token-file":
+			tokenFile = value
+		case "ca-file":
+			caFile = value
+		case "namespace":
+			namespace = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	return newClient(server, tokenFile, caFile, namespace)
+}
+
+// resources returns the resources watched by the plugin
+func (p *PluginState) resources() []string {
+	if p.v6 {
+		return []string{resourceReservations}
+	}
+	return []string{resourceReservations, resourcePools}
+}
+
+// apply applies a change to a resource
+func (p *PluginState) apply(resource, typ string, o *object) {
+	p.Lock()
+	defer p.Unlock()
+	p.set(resource, typ, o)
+	p.index()
+}
+
+// replace replaces all the objects of a resource
+func (p *PluginState) replace(resource string, objects []object) {
+	p.Lock()
+	defer p.Unlock()
+	switch resource {
+	case resourceReservations:
+		p.reservationObjects = make(map[string]*reservation)
+	case resourcePools:
+		p.poolObjects = make(map[string]*pool)
+	}
+	for i := range objects {
+		p.set(resource, "ADDED", &objects[i])
+	}
+	p.index()
+}
+
+// set records an object, or removes it if it was deleted or is invalid. The
+// caller must hold the lock
+func (p *PluginState) set(resource, typ string, o *object) {
+	key := o.key()
+	switch resource {
+	case resourceReservations:
+		delete(p.reservationObjects, key)
+		if typ == "DELETED" {
+			return
+		}
+		r, err := parseReservation(o, p.v6)
+		if err != nil {
+			log.Warningf("ignoring DHCPReservation %s: %v", key, err)
+			return
+		}
+		if r != nil {
+			p.reservationObjects[key] = r
+		}
+	case resourcePools:
+		delete(p.poolObjects, key)
+		if typ == "DELETED" {
+			return
+		}
+		pl, err := parsePool(o)
+		if err != nil {
+			log.Warningf("ignoring DHCPPool %s: %v", key, err)
+			return
+		}
+		p.poolObjects[key] = pl
+	}
+}
+
+// mark marks ip as allocated in a pool
+func mark(pl *pool, ip net.IP) bool {
+	n, err := pl.allocator.Allocate(net.IPNet{IP: ip})
+	if err != nil {
+		return false
+	}
+	if !n.IP.Equal(ip) {
+		_ = pl.allocator.Free(n)
+		return false
+	}
+	return true
+}
+
+// index rebuilds the reservations and the pools from the resources, keeping
+// the leases still in a pool. The caller must hold the lock
+func (p *PluginState) index() {
+	p.reservations = make(map[string]*reservation)
+	owners := make(map[string]string)
+	keys := make([]string, 0, len(p.reservationObjects))
+	for key := range p.reservationObjects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r := p.reservationObjects[key]
+		mac := r.mac.String()
+		if _, ok := p.reservations[mac]; ok {
+			log.Warningf("ignoring DHCPReservation %s: duplicate reservation for %s", key, mac)
+			continue
+		}
+		if owner, ok := owners[r.ip.String()]; ok {
+			log.Warningf("ignoring DHCPReservation %s: %s is already reserved for %s", key, r.ip, owner)
+			continue
+		}
+		owners[r.ip.String()] = mac
+		p.reservations[mac] = r
+	}
+
+	p.pools = nil
+	keys = keys[:0]
+	for key := range p.poolObjects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pl := p.poolObjects[key]
+		overlaps := false
+		for _, other := range p.pools {
+			if other.contains(pl.start) || pl.contains(other.start) {
+				overlaps = true
+			}
+		}
+		if overlaps {
+			log.Warningf("ignoring DHCPPool %s: it overlaps another pool", key)
+			continue
+		}
+		// The allocators are created again, as the reservations changed
+		alloc, err := bitmap.NewIPv4Allocator(pl.start, pl.end)
+		if err != nil {
+			log.Warningf("ignoring DHCPPool %s: %v", key, err)
+			continue
+		}
+		pl.allocator = alloc
+		p.pools = append(p.pools, pl)
+	}
+	for _, r := range p.reservations {
+		if pl := p.poolOf(r.ip); pl != nil {
+			mark(pl, r.ip)
+		}
+	}
+	for mac, l := range p.leases {
+		// Drop the leases out of the pools, or of clients with a reservation
+		l.pool = p.poolOf(l.ip)
+		if _, ok := p.reservations[mac]; ok || l.pool == nil || !mark(l.pool, l.ip) {
+			delete(p.leases, mac)
+		}
+	}
+}
+
+// poolOf returns the pool containing ip, if any
+func (p *PluginState) poolOf(ip net.IP) *pool {
+	for _, pl := range p.pools {
+		if pl.contains(ip) {
+			return pl
+		}
+	}
+	return nil
+}
+
+// list lists the objects of a resource, and returns the resource version to
+// watch them from
+func (p *PluginState) list(resource string) (string, error) {
+	objects, rv, err := p.client.list(resource)
+	if err != nil {
+		return "", fmt.Errorf("could not list %s: %w", resource, err)
+	}
+	p.replace(resource, objects)
+	log.Infof("loaded %d %s", len(objects), resource)
+	return rv, nil
+}
+
+// watch applies the changes to a resource, from the resource version rv
+func (p *PluginState) watch(resource, rv string) {
+	for {
+		if rv == "" {
+			var err error
+			if rv, err = p.list(resource); err != nil {
+				log.Errorf("%v", err)
+				time.Sleep(retryDelay)
+				continue
+			}
+		}
+		next, err := p.client.watch(resource, rv, func(typ string, o *object) {
+			p.apply(resource, typ, o)
+		})
+		switch {
+		case err == errExpired:
+			rv = ""
+		case err != nil:
+			log.Warningf("watching %s: %v", resource, err)
+			time.Sleep(retryDelay)
+			rv = next
+		default:
+			rv = next
+		}
+	}
+}
+
+func setup(v6 bool, args ...string) (*PluginState, error) {
+	c, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p := newPluginState(v6, c)
+	for _, resource := range p.resources() {
+		rv, err := p.list(resource)
+		if err != nil {
+			return nil, err
+		}
+		go p.watch(resource, rv)
+	}
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(false, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(true, args...)
+	if err != nil {
+		return nil, err
+	}
+	ra.AdvertiseAddresses()
+	return p.Handler6, nil
+}
+
+// allocate returns the lease of a client, allocating one from the pools if
+// needed. The caller must hold the lock
+func (p *PluginState) allocate(req *dhcpv4.DHCPv4) *lease {
+	mac := req.ClientHWAddr.String()
+	if l, ok := p.leases[mac]; ok {
+		l.expires = time.Now().Add(l.pool.leaseTime)
+		return l
+	}
+	hint := req.RequestedIPAddress()
+	if hint == nil {
+		hint = req.ClientIPAddr
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		for _, pl := range p.pools {
+			var n net.IPNet
+			if pl.contains(hint) {
+				n.IP = hint.To4()
+			}
+			ip, err := pl.allocator.Allocate(n)
+			if err != nil {
+				continue
+			}
+			l := lease{ip: ip.IP.To4(), expires: time.Now().Add(pl.leaseTime), pool: pl}
+			p.leases[mac] = &l
+			return &l
+		}
+		// All the pools are full, reclaim the expired leases and try again
+		p.reclaim(time.Now())
+	}
+	return nil
+}
+
+// reclaim frees the expired leases. The caller must hold the lock
+func (p *PluginState) reclaim(now time.Time) {
+	for mac, l := range p.leases {
+		if l.expires.Before(now) {
+			_ = l.pool.allocator.Free(net.IPNet{IP: l.ip, Mask: net.CIDRMask(32, 32)})
+			delete(p.leases, mac)
+		}
+	}
+}
+
+// release frees the lease of a client, if any. The caller must hold the lock
+func (p *PluginState) release(mac string) {
+	if l, ok := p.leases[mac]; ok {
+		_ = l.pool.allocator.Free(net.IPNet{IP: l.ip, Mask: net.CIDRMask(32, 32)})
+		delete(p.leases, mac)
+	}
+}
+
+// Handler4 handles DHCPv4 packets for the kubernetes plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.Lock()
+	defer p.Unlock()
+	mac := req.ClientHWAddr.String()
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeNone:
+	case dhcpv4.MessageTypeRelease:
+		p.release(mac)
+		return resp, false
+	default:
+		return resp, false
+	}
+	if r, ok := p.reservations[mac]; ok {
+		resp.YourIPAddr = r.ip
+		if r.hostname != "" {
+			resp.UpdateOption(dhcpv4.OptHostName(r.hostname))
+		}
+		for _, o := range r.options {
+			resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(o.code), o.value))
+		}
+		log.Debugf("found IP address %s for MAC %s", r.ip, mac)
+		return resp, true
+	}
+	// BOOTP clients never release their address, they are only served
+	// reservations
+	if req.MessageType() == dhcpv4.MessageTypeNone || len(p.pools) == 0 {
+		return resp, false
+	}
+	l := p.allocate(req)
+	if l == nil {
+		log.Errorf("Could not allocate IP for MAC %s: all the pools are full", mac)
+		return nil, true
+	}
+	resp.YourIPAddr = l.ip
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(l.pool.leaseTime.Round(time.Second)))
+	for _, o := range l.pool.options {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(o.code), o.value))
+	}
+	log.Debugf("leased IP address %s to MAC %s", l.ip, mac)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the kubernetes plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	if m.Options.OneIANA() == nil {
+		return resp, false
+	}
+	mac, _ := duid.HWAddr(req)
+	if mac == nil {
+		return resp, false
+	}
+	p.Lock()
+	r, ok := p.reservations[mac.String()]
+	p.Unlock()
+	if !ok {
+		return resp, false
+	}
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: m.Options.OneIANA().IaId,
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{
+				IPv6Addr:          r.ip,
+				PreferredLifetime: 3600 * time.Second,
+				ValidLifetime:     3600 * time.Second,
+			},
+		}},
+	})
+	for _, o := range r.options {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(o.code), OptionData: o.value})
+	}
+	log.Debugf("found IP address %s for MAC %s", r.ip, mac)
+	return resp, false
+}
@@ -0,0 +1,178 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes data to a temporary file, removed at the end of the test
+func writeFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+func newObject(t *testing.T, name string, spec interface{}) *object {
+	data, err := json.Marshal(spec)
+	require.NoError(t, err)
+	return &object{Metadata: objectMeta{Name: name, Namespace: "ns"}, Spec: data}
+}
+
+func request(t *testing.T, mac net.HardwareAddr, mt dhcpv4.MessageType, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(mt)}, modifiers...)...)
+	require.NoError(t, err)
+	return req
+}
+
+func handle4(t *testing.T, p *PluginState, req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	return p.Handler4(req, stub)
+}
+
+func TestParseArgs(t *testing.T) {
+	c, err := parseArgs("server=http://127.0.0.1:8001/", "namespace=provisioning")
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8001"+apiPrefix+"/namespaces/provisioning/dhcppools?", c.url(resourcePools, nil))
+
+	for _, args := range [][]string{
+		{"server"},
+		{"kubeconfig=/etc/kubeconfig"},
+		{"server=http://127.0.0.1:8001", "ca-file=/nonexistent"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestReservations4(t *testing.T) {
+	p := newPluginState(false, nil)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	p.replace(resourceReservations, []object{
+		*newObject(t, "node-1", reservationSpec{MACAddress: mac.String(), IPAddress: "10.0.0.10", Hostname: "node-1", Options: []string{"67=string:ipxe.efi"}}),
+		*newObject(t, "node-1-v6", reservationSpec{MACAddress: mac.String(), IPAddress: "2001:db8::10"}),
+		*newObject(t, "node-2", reservationSpec{MACAddress: "00:11:22:33:44:56", IPAddress: "10.0.0.10"}),
+		*newObject(t, "node-3", reservationSpec{MACAddress: "00:11:22:33:44:57", IPAddress: "10.0.0.300"}),
+	})
+	assert.Len(t, p.reservations, 1)
+
+	resp, stop := handle4(t, p, request(t, mac, dhcpv4.MessageTypeDiscover))
+	assert.True(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 10).To4(), resp.YourIPAddr)
+	assert.Equal(t, "node-1", resp.HostName())
+	assert.Equal(t, "ipxe.efi", resp.BootFileNameOption())
+
+	// Changes are applied live
+	p.apply(resourceReservations, "MODIFIED", newObject(t, "node-1", reservationSpec{MACAddress: mac.String(), IPAddress: "10.0.0.11"}))
+	resp, _ = handle4(t, p, request(t, mac, dhcpv4.MessageTypeRequest))
+	assert.Equal(t, net.IPv4(10, 0, 0, 11).To4(), resp.YourIPAddr)
+	p.apply(resourceReservations, "DELETED", newObject(t, "node-1", nil))
+	resp, stop = handle4(t, p, request(t, mac, dhcpv4.MessageTypeRequest))
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
+
+func TestPools(t *testing.T) {
+	p := newPluginState(false, nil)
+	p.replace(resourcePools, []object{
+		*newObject(t, "a", poolSpec{Start: "10.0.0.100", End: "10.0.0.101", LeaseTime: "10m", Options: []string{"3=ip:10.0.0.1"}}),
+		*newObject(t, "b", poolSpec{Start: "10.0.0.101", End: "10.0.0.110"}),
+		*newObject(t, "c", poolSpec{Start: "10.0.0.102", End: "10.0.0.100"}),
+	})
+	require.Len(t, p.pools, 1)
+	p.replace(resourceReservations, []object{
+		*newObject(t, "node-1", reservationSpec{MACAddress: "00:11:22:33:44:55", IPAddress: "10.0.0.100"}),
+	})
+
+	mac1 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x56}
+	mac2 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x57}
+	// The reserved address is not leased
+	resp, stop := handle4(t, p, request(t, mac1, dhcpv4.MessageTypeDiscover))
+	assert.False(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 101).To4(), resp.YourIPAddr)
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+	resp, _ = handle4(t, p, request(t, mac1, dhcpv4.MessageTypeRequest))
+	assert.Equal(t, net.IPv4(10, 0, 0, 101).To4(), resp.YourIPAddr)
+
+	// The pool is full
+	resp, stop = handle4(t, p, request(t, mac2, dhcpv4.MessageTypeDiscover))
+	assert.True(t, stop)
+	assert.Nil(t, resp)
+
+	// Growing the pool keeps the leases
+	p.apply(resourcePools, "MODIFIED", newObject(t, "a", poolSpec{Start: "10.0.0.100", End: "10.0.0.110"}))
+	resp, _ = handle4(t, p, request(t, mac2, dhcpv4.MessageTypeDiscover, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(10, 0, 0, 105)))))
+	assert.Equal(t, net.IPv4(10, 0, 0, 105).To4(), resp.YourIPAddr)
+	resp, _ = handle4(t, p, request(t, mac1, dhcpv4.MessageTypeRequest))
+	assert.Equal(t, net.IPv4(10, 0, 0, 101).To4(), resp.YourIPAddr)
+
+	// Released addresses can be leased again
+	handle4(t, p, request(t, mac2, dhcpv4.MessageTypeRelease))
+	assert.Len(t, p.leases, 1)
+	resp, _ = handle4(t, p, request(t, mac1, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(10, 0, 0, 105)))))
+	assert.Equal(t, net.IPv4(10, 0, 0, 101).To4(), resp.YourIPAddr)
+
+	// Leases out of the pools are dropped, b no longer overlaps a
+	p.apply(resourcePools, "DELETED", newObject(t, "a", nil))
+	assert.Len(t, p.leases, 1)
+	p.apply(resourcePools, "DELETED", newObject(t, "b", nil))
+	assert.Empty(t, p.leases)
+	resp, stop = handle4(t, p, request(t, mac1, dhcpv4.MessageTypeRequest))
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
+
+func TestReclaim(t *testing.T) {
+	p := newPluginState(false, nil)
+	p.replace(resourcePools, []object{*newObject(t, "a", poolSpec{Start: "10.0.0.100", End: "10.0.0.100"})})
+	mac1 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x56}
+	mac2 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x57}
+	resp, _ := handle4(t, p, request(t, mac1, dhcpv4.MessageTypeDiscover))
+	require.NotNil(t, resp)
+	p.leases[mac1.String()].expires = time.Now().Add(-time.Second)
+	resp, _ = handle4(t, p, request(t, mac2, dhcpv4.MessageTypeDiscover))
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 100).To4(), resp.YourIPAddr)
+}
+
+func TestHandler6(t *testing.T) {
+	p := newPluginState(true, nil)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	p.replace(resourceReservations, []object{
+		*newObject(t, "node-1", reservationSpec{MACAddress: mac.String(), IPAddress: "2001:db8::10"}),
+		*newObject(t, "node-1-v4", reservationSpec{MACAddress: mac.String(), IPAddress: "10.0.0.10"}),
+	})
+	require.Len(t, p.reservations, 1)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{1, 2, 3, 4}})
+	stub, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp, stop := p.Handler6(req, stub)
+	assert.False(t, stop)
+	ia := resp.(*dhcpv6.Message).Options.OneIANA()
+	require.NotNil(t, ia)
+	assert.Equal(t, net.ParseIP("2001:db8::10"), ia.Options.OneAddress().IPv6Addr)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/options"
+)
+
+// reservationSpec is the spec of a DHCPReservation
+type reservationSpec struct {
+	MACAddress string   `json:"macAddress"`
+	IPAddress  string   `json:"ipAddress"`
+	Hostname   string   `json:"hostname"`
+	Options    []string `json:"options"`
+}
+
+// poolSpec is the spec of a DHCPPool
+type poolSpec struct {
+	Start     string   `json:"start"`
+	End       string   `json:"end"`
+	LeaseTime string   `json:"leaseTime"`
+	Options   []string `json:"options"`
+}
+
+// option is an option override, with the syntax of plugins/options
+type option struct {
+	code  uint64
+	value []byte
+}
+
+func parseOptions(specs []string, v6 bool) ([]option, error) {
+	var opts []option
+	for _, spec := range specs {
+		code, value, err := options.Parse(spec, v6)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option{code: code, value: value})
+	}
+	return opts, nil
+}
+
+// reservation is a validated DHCPReservation
+type reservation struct {
+	mac      net.HardwareAddr
+	ip       net.IP
+	hostname string
+	options  []option
+}
+
+// parseReservation validates a DHCPReservation. It returns nil for the
+// reservations of the other IP family, which are served by the other server
+func parseReservation(o *object, v6 bool) (*reservation, error) {
+	var spec reservationSpec
+	if err := json.Unmarshal(o.Spec, &spec); err != nil {
+		return nil, err
+	}
+	mac, err := net.ParseMAC(spec.MACAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %q", spec.MACAddress)
+	}
+	ip := net.ParseIP(spec.IPAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", spec.IPAddress)
+	}
+	if (ip.To4() == nil) != v6 {
+		return nil, nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	if spec.Hostname != "" && fqdn.HostName(spec.Hostname) != spec.Hostname {
+		return nil, fmt.Errorf("invalid host name %q", spec.Hostname)
+	}
+	opts, err := parseOptions(spec.Options, v6)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation{mac: mac, ip: ip, hostname: spec.Hostname, options: opts}, nil
+}
+
+// pool is a validated DHCPPool
+type pool struct {
+	start, end net.IP
+	leaseTime  time.Duration
+	options    []option
+	// allocator is created when the pools are indexed
+	allocator *bitmap.IPv4Allocator
+}
+
+func parsePool(o *object) (*pool, error) {
+	var spec poolSpec
+	if err := json.Unmarshal(o.Spec, &spec); err != nil {
+		return nil, err
+	}
+	start, end := net.ParseIP(spec.Start).To4(), net.ParseIP(spec.End).To4()
+	if start == nil || end == nil {
+		return nil, fmt.Errorf("invalid IPv4 range %q-%q", spec.Start, spec.End)
+	}
+	if bytes.Compare(start, end) > 0 {
+		return nil, errors.New("start of IP range has to be lower than the end of an IP range")
+	}
+	p := pool{start: start, end: end, leaseTime: time.Hour}
+	if spec.LeaseTime != "" {
+		d, err := time.ParseDuration(spec.LeaseTime)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid lease time %q", spec.LeaseTime)
+		}
+		p.leaseTime = d
+	}
+	opts, err := parseOptions(spec.Options, false)
+	if err != nil {
+		return nil, err
+	}
+	p.options = opts
+	return &p, nil
+}
+
+func (p *pool) contains(ip net.IP) bool {
+	ip = ip.To4()
+	return ip != nil && bytes.Compare(ip, p.start) >= 0 && bytes.Compare(ip, p.end) <= 0
+}
@@ -0,0 +1,141 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package leasecrypt encrypts the lease files of the plugins at rest, as they
+// hold personal data (hardware addresses, DUIDs, host names). Each line is
+// encrypted on its own with AES-256-GCM, so the files can still be appended
+// to, and stored as "enc:" followed by the nonce and the ciphertext in
+// base64.
+//
+// The key is 32 bytes in hex, read from a file or an environment variable,
+// eg. where a secret or key management service delivers it:
+// - file:<path>
+// - env:<variable>
+//
+// The lines which are not encrypted are read as is, so that a lease file can
+// be encrypted by turning encryption on: its leases are encrypted as they are
+// written again.
+package leasecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Prefix starts the encrypted lines
+const Prefix = "enc:"
+
+// keyLength is the length of the AES-256 keys
+const keyLength = 32
+
+// Cipher encrypts and decrypts the lines of a lease file. The methods of a
+// nil Cipher, when encryption is off, leave the lines as they are
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New returns a Cipher with an AES-256 key
+func New(key []byte) (*Cipher, error) {
+	if len(key) != keyLength {
+		return nil, fmt.Errorf("invalid key length %d, expected %d bytes", len(key), keyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Load returns a Cipher with the key given by spec, file:<path> or
+// env:<variable>
+func Load(spec string) (*Cipher, error) {
+	var value string
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(spec, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the lease key: %w", err)
+		}
+		value = string(data)
+	case strings.HasPrefix(spec, "env:"):
+		name := strings.TrimPrefix(spec, "env:")
+		var ok bool
+		if value, ok = os.LookupEnv(name); !ok {
+			return nil, fmt.Errorf("lease key variable %s is not set", name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid lease key %q, expected file:<path> or env:<variable>", spec)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.New("invalid lease key, expected 32 bytes in hex")
+	}
+	return New(key)
+}
+
+// Seal encrypts a line
+func (c *Cipher) Seal(line string) string {
+	if c == nil {
+		return line
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		// The system has no randomness left: don't reuse a nonce
+		panic(fmt.Sprintf("cannot generate a nonce: %v", err))
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(line), nil))
+}
+
+// Open decrypts a line, if encrypted
+func (c *Cipher) Open(line string) (string, error) {
+	if !strings.HasPrefix(line, Prefix) {
+		return line, nil
+	}
+	if c == nil {
+		return "", errors.New("encrypted lease, but no key")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, Prefix))
+	if err != nil || len(data) < c.aead.NonceSize() {
+		return "", errors.New("malformed encrypted lease")
+	}
+	size := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt lease, wrong key or corrupted line")
+	}
+	return string(plain), nil
+}
+
+// Decrypt returns the lines of a lease file decrypted
+func (c *Cipher) Decrypt(r io.Reader) (io.Reader, error) {
+	var out bytes.Buffer
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, err := c.Open(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
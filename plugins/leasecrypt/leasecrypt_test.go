@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasecrypt
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "coredhcp-leasekey")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(testKey + "\n")
+	require.NoError(t, err)
+	f.Close()
+
+	_, err = Load("file:" + f.Name())
+	assert.NoError(t, err)
+	os.Setenv("COREDHCP_TEST_LEASE_KEY", testKey)
+	defer os.Unsetenv("COREDHCP_TEST_LEASE_KEY")
+	_, err = Load("env:COREDHCP_TEST_LEASE_KEY")
+	assert.NoError(t, err)
+
+	for _, spec := range []string{
+		testKey,
+		"env:COREDHCP_TEST_UNSET",
+		"file:/nonexistent",
+	} {
+		_, err := Load(spec)
+		assert.Error(t, err, spec)
+	}
+	os.Setenv("COREDHCP_TEST_LEASE_KEY", "0011")
+	_, err = Load("env:COREDHCP_TEST_LEASE_KEY")
+	assert.Error(t, err, "short key")
+	os.Setenv("COREDHCP_TEST_LEASE_KEY", "not hex")
+	_, err = Load("env:COREDHCP_TEST_LEASE_KEY")
+	assert.Error(t, err, "invalid key")
+}
+
+func TestSealOpen(t *testing.T) {
+	c, err := New(make([]byte, keyLength))
+	require.NoError(t, err)
+	line := "02:00:00:00:00:06 10.0.0.6 2000-01-01T00:00:00Z host6"
+	sealed := c.Seal(line)
+	assert.True(t, strings.HasPrefix(sealed, Prefix))
+	assert.NotContains(t, sealed, "host6")
+	assert.NotEqual(t, sealed, c.Seal(line), "nonce reused")
+	opened, err := c.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, line, opened)
+
+	// Unencrypted lines are read as is, but not encrypted ones without the
+	// right key
+	opened, err = c.Open(line)
+	require.NoError(t, err)
+	assert.Equal(t, line, opened)
+	var none *Cipher
+	assert.Equal(t, line, none.Seal(line))
+	_, err = none.Open(sealed)
+	assert.Error(t, err)
+	other, err := New([]byte(strings.Repeat("k", keyLength)))
+	require.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.Error(t, err)
+	_, err = c.Open(sealed[:len(sealed)-4])
+	assert.Error(t, err)
+}
+
+func TestDecrypt(t *testing.T) {
+	c, err := New(make([]byte, keyLength))
+	require.NoError(t, err)
+	r, err := c.Decrypt(strings.NewReader(c.Seal("first") + "\nsecond\n\n" + c.Seal("third") + "\n"))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "first\nsecond\n\nthird\n", string(data))
+
+	_, err = c.Decrypt(strings.NewReader("first\nenc:garbage\n"))
+	assert.EqualError(t, err, "line 2: malformed encrypted lease")
+}
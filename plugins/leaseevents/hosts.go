@@ -0,0 +1,134 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leaseevents
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"sort"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Host links the DHCPv4 and DHCPv6 leases of a machine, so that both its
+// addresses are seen at a glance. The leases are linked when they have the
+// same hardware address, as found in the DHCPv6 messages by plugins/duid, or
+// the same DUID, which DHCPv4 clients send in their client identifier when
+// following RFC 4361
+type Host struct {
+	HWAddr   string   `json:"hwaddr,omitempty"`
+	DUIDs    []string `json:"duids,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	IPv4     []net.IP `json:"ipv4,omitempty"`
+	IPv6     []net.IP `json:"ipv6,omitempty"`
+	// Leases are the last events of the leases of the host, by address
+	Leases []Event `json:"leases"`
+}
+
+// clientDUID4 returns the DUID of a DHCPv4 client identifier of RFC 4361
+// (type 255, IAID, DUID) in hexadecimal, empty for the other identifiers
+func clientDUID4(req *dhcpv4.DHCPv4) string {
+	id := req.Options.Get(dhcpv4.OptionClientIdentifier)
+	if len(id) <= 5 || id[0] != 255 {
+		return ""
+	}
+	return hex.EncodeToString(id[5:])
+}
+
+// Hosts returns the leases being tracked, linked by host. A host without a
+// known hardware address nor DUID has a single lease
+func (t *Tracker) Hosts() []Host {
+	t.Lock()
+	leases := make([]lease, 0, len(t.leases))
+	for _, l := range t.leases {
+		leases = append(leases, *l)
+	}
+	t.Unlock()
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(leases[i].event.IP.To16(), leases[j].event.IP.To16()) < 0
+	})
+
+	// Union-find of the leases, sharing a hardware address or a DUID
+	parent := make([]int, len(leases))
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owner := make(map[string]int)
+	for i, l := range leases {
+		parent[i] = i
+		for _, id := range identities(l) {
+			if j, ok := owner[id]; ok {
+				// The first lease of a host, by address, is its root
+				a, b := find(i), find(j)
+				if b < a {
+					a, b = b, a
+				}
+				parent[b] = a
+				continue
+			}
+			owner[id] = i
+		}
+	}
+
+	var hosts []Host
+	index := make(map[int]int)
+	for i, l := range leases {
+		root := find(i)
+		n, ok := index[root]
+		if !ok {
+			n = len(hosts)
+			index[root] = n
+			hosts = append(hosts, Host{})
+		}
+		h := &hosts[n]
+		e := l.event
+		if h.HWAddr == "" {
+			h.HWAddr = e.HWAddr
+		}
+		if h.Hostname == "" {
+			h.Hostname = e.Hostname
+		}
+		for _, duid := range []string{e.DUID, l.duid} {
+			if duid != "" && !contains(h.DUIDs, duid) {
+				h.DUIDs = append(h.DUIDs, duid)
+			}
+		}
+		if e.IP.To4() != nil {
+			h.IPv4 = append(h.IPv4, e.IP)
+		} else {
+			h.IPv6 = append(h.IPv6, e.IP)
+		}
+		h.Leases = append(h.Leases, e)
+	}
+	return hosts
+}
+
+// identities returns what identifies the host of a lease
+func identities(l lease) []string {
+	var ids []string
+	if l.event.HWAddr != "" {
+		ids = append(ids, "hwaddr/"+l.event.HWAddr)
+	}
+	for _, duid := range []string{l.event.DUID, l.duid} {
+		if duid != "" {
+			ids = append(ids, "duid/"+duid)
+		}
+	}
+	return ids
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
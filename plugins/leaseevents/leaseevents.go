@@ -0,0 +1,367 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package leaseevents follows the leases through the DHCP exchanges, for the
+// plugins reporting them to other systems (eg. plugins/webhook), along with
+// the requests of the clients. Plugins using it must come after the plugins
+// assigning addresses and boot files.
+//
+// The leases are tracked in memory only: leases that were allocated before a
+// restart are reported as allocated again when they are renewed.
+package leaseevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Lease events
+const (
+	// Allocate is sent when a client gets a new address
+	Allocate = "allocate"
+	// Renew is sent when a client extends the lease of its address
+	Renew = "renew"
+	// Release is sent when a client releases or declines its address
+	Release = "release"
+	// Expire is sent when the lease of a client ends without renewal
+	Expire = "expire"
+	// Reclaim is sent when a lease backend takes the address of an expired
+	// lease back, see Publish
+	Reclaim = "reclaim"
+	// PXE is sent when a network booting client gets a boot file
+	PXE = "pxe"
+	// Request is sent for each message from a client. It is not a lease
+	// event, and not in All
+	Request = "request"
+)
+
+// All lists the lease events
+var All = []string{Allocate, Renew, Release, Expire, Reclaim, PXE}
+
+const (
+	// defaultLeaseTime is used for DHCPv4 responses without a lease time
+	defaultLeaseTime = time.Hour
+	sweepInterval    = time.Minute
+)
+
+// Event describes a lease event
+type Event struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	IP    net.IP    `json:"ip,omitempty"`
+	// HWAddr is the hardware address of the client, as found by plugins/duid
+	// for the DHCPv6 clients, which also have a DUID
+	HWAddr      string     `json:"hwaddr,omitempty"`
+	DUID        string     `json:"duid,omitempty"`
+	Hostname    string     `json:"hostname,omitempty"`
+	VendorClass string     `json:"vendor_class,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	// BootFile is the boot file name or URL given to PXE clients
+	BootFile string `json:"boot_file,omitempty"`
+	// MessageType and Relay, the address of the relay agent closest to the
+	// client, are set for request events
+	MessageType string `json:"message_type,omitempty"`
+	Relay       net.IP `json:"relay,omitempty"`
+}
+
+// lease is the last event of a lease being tracked
+type lease struct {
+	event   Event
+	expires time.Time
+	// duid is the DUID of a DHCPv4 client sending it as client identifier
+	// (RFC 4361), linking its leases to those of its DHCPv6 client
+	duid string
+}
+
+// Tracker follows the leases, and reports their events
+type Tracker struct {
+	sync.Mutex
+	// notify is called for each event, with the lock held: it must not block
+	notify func(Event)
+	// leases are keyed by client and address
+	leases map[string]*lease
+}
+
+// NewTracker returns a tracker reporting the lease events to notify, which
+// must not block. The expired leases are looked for by Run
+func NewTracker(notify func(Event)) *Tracker {
+	t := newTracker(notify)
+	register(t)
+	return t
+}
+
+func newTracker(notify func(Event)) *Tracker {
+	return &Tracker{notify: notify, leases: make(map[string]*lease)}
+}
+
+var (
+	trackersMu sync.Mutex
+	// trackers are the trackers receiving the published events
+	trackers []*Tracker
+)
+
+func register(t *Tracker) {
+	trackersMu.Lock()
+	defer trackersMu.Unlock()
+	trackers = append(trackers, t)
+}
+
+// Publish reports an event of a lease backend, which the trackers can't see
+// in the exchanges (eg. the range plugin reclaiming the address of an expired
+// lease), to all the trackers. The tracked lease of the client for this
+// address, if any, is forgotten
+func Publish(e Event) {
+	trackersMu.Lock()
+	defer trackersMu.Unlock()
+	for _, t := range trackers {
+		t.publish(e)
+	}
+}
+
+func (t *Tracker) publish(e Event) {
+	t.Lock()
+	defer t.Unlock()
+	key := "4/" + e.HWAddr
+	if e.DUID != "" {
+		key = "6/" + e.DUID + "/" + e.IP.String()
+	}
+	if l, ok := t.leases[key]; ok && l.event.IP.Equal(e.IP) {
+		delete(t.leases, key)
+	}
+	t.send(e)
+}
+
+func (t *Tracker) send(e Event) {
+	e.Time = time.Now().UTC()
+	t.notify(e)
+}
+
+// commit records the lease of an address, and sends an allocate or renew
+// event
+func (t *Tracker) commit(key string, e Event, expires time.Time, duid string) {
+	t.Lock()
+	defer t.Unlock()
+	e.Event = Allocate
+	if old, ok := t.leases[key]; ok && old.event.IP.Equal(e.IP) {
+		e.Event = Renew
+	}
+	e.Expires = &expires
+	t.leases[key] = &lease{event: e, expires: expires, duid: duid}
+	t.send(e)
+}
+
+// release forgets the lease of an address, and sends a release event. The
+// given event is sent if the lease is not tracked
+func (t *Tracker) release(key string, e Event) {
+	t.Lock()
+	defer t.Unlock()
+	if l, ok := t.leases[key]; ok {
+		e = l.event
+		delete(t.leases, key)
+	}
+	if e.IP == nil || e.IP.IsUnspecified() {
+		return
+	}
+	e.Event, e.Expires = Release, nil
+	t.send(e)
+}
+
+// Sweep forgets the leases expired at the given time, and sends expire events
+func (t *Tracker) Sweep(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	for key, l := range t.leases {
+		if now.After(l.expires) {
+			e := l.event
+			e.Event = Expire
+			t.send(e)
+			delete(t.leases, key)
+		}
+	}
+}
+
+// Run looks for the expired leases every minute, until ctx is done. The
+// plugins run it from their Start hook
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.Sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Leases returns the last events of the leases being tracked, by address
+func (t *Tracker) Leases() []Event {
+	t.Lock()
+	events := make([]Event, 0, len(t.leases))
+	for _, l := range t.leases {
+		events = append(events, l.event)
+	}
+	t.Unlock()
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].IP.To16(), events[j].IP.To16()) < 0
+	})
+	return events
+}
+
+// Len returns the number of leases being tracked
+func (t *Tracker) Len() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.leases)
+}
+
+// Handle4 reports the events of a DHCPv4 exchange
+func (t *Tracker) Handle4(req, resp *dhcpv4.DHCPv4) {
+	hwaddr := req.ClientHWAddr.String()
+	key := "4/" + hwaddr
+
+	r := Event{
+		Event:       Request,
+		IP:          req.ClientIPAddr,
+		HWAddr:      hwaddr,
+		Hostname:    fqdn.HostName4(req),
+		VendorClass: req.ClassIdentifier(),
+		MessageType: req.MessageType().String(),
+	}
+	if r.IP.IsUnspecified() {
+		r.IP = nil
+	}
+	if !req.GatewayIPAddr.IsUnspecified() {
+		r.Relay = req.GatewayIPAddr
+	}
+	t.Lock()
+	t.send(r)
+	t.Unlock()
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		t.release(key, Event{IP: req.ClientIPAddr, HWAddr: hwaddr})
+		return
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	default:
+		return
+	}
+	// The lease is committed on ACK, which can be a response to a DISCOVER
+	// with rapid commit
+	if resp == nil || resp.MessageType() != dhcpv4.MessageTypeAck || resp.YourIPAddr.IsUnspecified() {
+		return
+	}
+	hostname := fqdn.HostName(resp.HostName())
+	if hostname == "" {
+		hostname = fqdn.HostName4(req)
+	}
+	e := Event{IP: resp.YourIPAddr.To4(), HWAddr: hwaddr, Hostname: hostname, VendorClass: req.ClassIdentifier()}
+	t.commit(key, e, time.Now().Add(resp.IPAddressLeaseTime(defaultLeaseTime)), clientDUID4(req))
+
+	if strings.HasPrefix(req.ClassIdentifier(), "PXEClient") {
+		bootFile := resp.BootFileNameOption()
+		if bootFile == "" {
+			bootFile = resp.BootFileName
+		}
+		if bootFile != "" {
+			e.Event, e.BootFile = PXE, bootFile
+			t.Lock()
+			t.send(e)
+			t.Unlock()
+		}
+	}
+}
+
+// vendorClass6 returns the first vendor class of a DHCPv6 client, if any
+func vendorClass6(msg *dhcpv6.Message) string {
+	opt := msg.GetOneOption(dhcpv6.OptionVendorClass)
+	if vc, ok := opt.(*dhcpv6.OptVendorClass); ok && len(vc.Data) > 0 {
+		return string(vc.Data[0])
+	}
+	return ""
+}
+
+// Handle6 reports the events of a DHCPv6 exchange
+func (t *Tracker) Handle6(req, resp dhcpv6.DHCPv6) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		return
+	}
+	clientID := msg.Options.ClientID()
+	if clientID == nil {
+		return
+	}
+	var hwaddr string
+	if mac, _ := duid.HWAddr(req); mac != nil {
+		hwaddr = mac.String()
+	}
+	duid := hex.EncodeToString(clientID.ToBytes())
+	key := func(ip net.IP) string { return "6/" + duid + "/" + ip.String() }
+
+	var hostname string
+	if o, err := fqdn.Parse6(msg); err == nil && o != nil {
+		hostname = fqdn.HostName(o.Name)
+	}
+	vendorClass := vendorClass6(msg)
+	t.Lock()
+	t.send(Event{
+		Event:       Request,
+		HWAddr:      hwaddr,
+		DUID:        duid,
+		Hostname:    hostname,
+		VendorClass: vendorClass,
+		MessageType: msg.Type().String(),
+		Relay:       relay.LinkAddr6(req),
+	})
+	t.Unlock()
+
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+		for _, iana := range msg.Options.IANA() {
+			for _, addr := range iana.Options.Addresses() {
+				t.release(key(addr.IPv6Addr), Event{IP: addr.IPv6Addr, HWAddr: hwaddr, DUID: duid})
+			}
+		}
+		return
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	default:
+		return
+	}
+	// A Reply to a Solicit is a rapid commit
+	reply, ok := resp.(*dhcpv6.Message)
+	if !ok || reply.Type() != dhcpv6.MessageTypeReply {
+		return
+	}
+	var first net.IP
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			if addr.ValidLifetime == 0 {
+				continue
+			}
+			if first == nil {
+				first = addr.IPv6Addr
+			}
+			e := Event{IP: addr.IPv6Addr, HWAddr: hwaddr, DUID: duid, Hostname: hostname, VendorClass: vendorClass}
+			t.commit(key(addr.IPv6Addr), e, time.Now().Add(addr.ValidLifetime), "")
+		}
+	}
+	if bootFile := reply.Options.BootFileURL(); bootFile != "" {
+		t.Lock()
+		t.send(Event{Event: PXE, IP: first, HWAddr: hwaddr, DUID: duid, Hostname: hostname, VendorClass: vendorClass, BootFile: bootFile})
+		t.Unlock()
+	}
+}
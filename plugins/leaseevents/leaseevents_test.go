@@ -0,0 +1,228 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leaseevents
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// record returns a notify function appending the lease events to events, and
+// the request events to requests
+func record(events, requests *[]Event) func(Event) {
+	return func(e Event) {
+		if e.Event == Request {
+			*requests = append(*requests, e)
+		} else {
+			*events = append(*events, e)
+		}
+	}
+}
+
+func names(events []Event) []string {
+	var names []string
+	for _, e := range events {
+		names = append(names, e.Event)
+	}
+	return names
+}
+
+func newRequest4(t *testing.T, mt dhcpv4.MessageType, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+	}, modifiers...)...)
+	require.NoError(t, err)
+	return req
+}
+
+func TestHandle4(t *testing.T) {
+	var events, requests []Event
+	tr := newTracker(record(&events, &requests))
+
+	req := newRequest4(t, dhcpv4.MessageTypeRequest,
+		dhcpv4.WithOption(dhcpv4.OptHostName("host")),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+	)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+		dhcpv4.WithOption(dhcpv4.OptBootFileName("pxelinux.0")),
+	)
+	require.NoError(t, err)
+	tr.Handle4(req, resp)
+	tr.Handle4(req, resp)
+	leases := tr.Leases()
+	require.Len(t, leases, 1)
+	assert.Equal(t, Renew, leases[0].Event)
+	assert.Equal(t, net.IPv4(192, 0, 2, 100).To4(), leases[0].IP)
+	tr.Handle4(newRequest4(t, dhcpv4.MessageTypeRelease), nil)
+
+	assert.Equal(t, []string{Allocate, PXE, Renew, PXE, Release}, names(events))
+	allocate := events[0]
+	assert.Equal(t, net.IPv4(192, 0, 2, 100).To4(), allocate.IP)
+	assert.Equal(t, "00:01:02:03:04:05", allocate.HWAddr)
+	assert.Equal(t, "host", allocate.Hostname)
+	assert.Equal(t, "PXEClient:Arch:00000:UNDI:002001", allocate.VendorClass)
+	require.NotNil(t, allocate.Expires)
+	assert.WithinDuration(t, time.Now().Add(defaultLeaseTime), *allocate.Expires, time.Minute)
+	assert.Equal(t, "pxelinux.0", events[1].BootFile)
+	assert.Nil(t, events[4].Expires)
+	assert.Zero(t, tr.Len())
+
+	// Releases of unknown leases are reported with the client's address
+	release := newRequest4(t, dhcpv4.MessageTypeRelease, dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 101)))
+	tr.Handle4(release, nil)
+	require.Len(t, events, 6)
+	assert.Equal(t, net.IPv4(192, 0, 2, 101).To4(), events[5].IP.To4())
+
+	require.Len(t, requests, 4)
+	assert.Equal(t, "REQUEST", requests[0].MessageType)
+	assert.Equal(t, "host", requests[0].Hostname)
+	assert.Nil(t, requests[0].IP)
+	assert.Equal(t, "RELEASE", requests[3].MessageType)
+	assert.Equal(t, net.IPv4(192, 0, 2, 101).To4(), requests[3].IP.To4())
+}
+
+func TestHandle6(t *testing.T) {
+	var events, requests []Event
+	tr := newTracker(record(&events, &requests))
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+	}))
+	req.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 343, Data: [][]byte{[]byte("HTTPClient")}})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::100"), ValidLifetime: time.Hour},
+	}}})
+	resp.AddOption(dhcpv6.OptBootFileURL("http://[2001:db8::1]/nbp"))
+	tr.Handle6(req, resp)
+
+	// The lease expires
+	tr.Sweep(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, []string{Allocate, PXE, Expire}, names(events))
+	assert.Equal(t, "00030001000102030405", events[0].DUID)
+	assert.Equal(t, "HTTPClient", events[0].VendorClass)
+	assert.Equal(t, "http://[2001:db8::1]/nbp", events[1].BootFile)
+	assert.Equal(t, net.ParseIP("2001:db8::100"), events[2].IP)
+	assert.Zero(t, tr.Len())
+
+	require.Len(t, requests, 1)
+	assert.Equal(t, "REQUEST", requests[0].MessageType)
+	assert.Equal(t, "00030001000102030405", requests[0].DUID)
+	assert.Nil(t, requests[0].Relay)
+}
+
+func TestPublish(t *testing.T) {
+	var events, requests []Event
+	tr := newTracker(record(&events, &requests))
+	register(tr)
+	t.Cleanup(func() { trackers = nil })
+
+	req := newRequest4(t, dhcpv4.MessageTypeRequest)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	tr.Handle4(req, resp)
+	require.Equal(t, 1, tr.Len())
+
+	// The reclaimed lease is forgotten, it does not expire later
+	Publish(Event{Event: Reclaim, IP: net.IPv4(192, 0, 2, 100).To4(), HWAddr: "00:01:02:03:04:05"})
+	assert.Zero(t, tr.Len())
+	tr.Sweep(time.Now().Add(2 * defaultLeaseTime))
+	assert.Equal(t, []string{Allocate, Reclaim}, names(events))
+	assert.False(t, events[1].Time.IsZero())
+}
+
+func TestHosts(t *testing.T) {
+	var events, requests []Event
+	tr := newTracker(record(&events, &requests))
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	ack4 := func(req *dhcpv4.DHCPv4, ip net.IP) {
+		resp, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.WithYourIP(ip),
+		)
+		require.NoError(t, err)
+		tr.Handle4(req, resp)
+	}
+	reply6 := func(duid dhcpv6.Duid, ip net.IP) {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		req.AddOption(dhcpv6.OptClientID(duid))
+		resp, err := dhcpv6.NewReplyFromMessage(req)
+		require.NoError(t, err)
+		resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: ip, ValidLifetime: time.Hour},
+		}}})
+		tr.Handle6(req, resp)
+	}
+
+	// Linked by hardware address, from a DUID-LLT
+	ack4(newRequest4(t, dhcpv4.MessageTypeRequest, dhcpv4.WithOption(dhcpv4.OptHostName("host"))), net.IPv4(192, 0, 2, 100))
+	reply6(dhcpv6.Duid{Type: dhcpv6.DUID_LLT, HwType: iana.HWTypeEthernet, Time: 1, LinkLayerAddr: mac}, net.ParseIP("2001:db8::100"))
+	// Linked by DUID, the DHCPv4 client identifier holding an IAID and the
+	// DUID-UUID of the client
+	uuid := dhcpv6.Duid{Type: dhcpv6.DUID_UUID, Uuid: make([]byte, 16)}
+	clientID := append([]byte{255, 0, 0, 0, 1}, uuid.ToBytes()...)
+	ack4(newRequest4(t, dhcpv4.MessageTypeRequest,
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 6}),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier(clientID)),
+	), net.IPv4(192, 0, 2, 101))
+	reply6(uuid, net.ParseIP("2001:db8::101"))
+	// Alone
+	reply6(dhcpv6.Duid{Type: dhcpv6.DUID_EN, EnterpriseNumber: 9, EnterpriseIdentifier: []byte{1}}, net.ParseIP("2001:db8::102"))
+
+	assert.Equal(t, "00:01:02:03:04:05", events[1].HWAddr)
+	hosts := tr.Hosts()
+	require.Len(t, hosts, 3)
+	assert.Equal(t, "00:01:02:03:04:05", hosts[0].HWAddr)
+	assert.Equal(t, "host", hosts[0].Hostname)
+	assert.Equal(t, []string{"0001000100000001000102030405"}, hosts[0].DUIDs)
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 100).To4()}, hosts[0].IPv4)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::100")}, hosts[0].IPv6)
+	assert.Len(t, hosts[0].Leases, 2)
+
+	assert.Equal(t, "00:01:02:03:04:06", hosts[1].HWAddr)
+	assert.Equal(t, []string{"000400000000000000000000000000000000"}, hosts[1].DUIDs)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::101")}, hosts[1].IPv6)
+
+	assert.Empty(t, hosts[2].HWAddr)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::102")}, hosts[2].IPv6)
+}
+
+func TestRunStops(t *testing.T) {
+	tr := newTracker(func(Event) {})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tr.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return once the context was done")
+	}
+}
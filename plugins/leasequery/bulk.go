@@ -0,0 +1,231 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasequery
+
+// Bulk Leasequery (RFC6926): relays connect over TCP and stream the bindings
+// they are interested in, typically all of them after a reboot.
+// Messages are framed by a 2-byte length in network order, and a query is
+// answered by one DHCPLEASEACTIVE or DHCPLEASEUNASSIGNED message per binding,
+// followed by a DHCPLEASEQUERYDONE. Errors are reported with a
+// DHCPLEASEQUERYSTATUS message carrying a status-code option.
+//
+// Queries by IP address, hardware address or client identifier are supported,
+// as well as queries without any criteria (all bindings). Queries by relay-id
+// or remote-id are refused, since the stores don't keep relay information.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// DHCP message types defined by RFC6926 §6.1
+const (
+	MessageTypeBulkLeaseQuery   dhcpv4.MessageType = 14
+	MessageTypeLeaseQueryDone   dhcpv4.MessageType = 15
+	MessageTypeLeaseQueryStatus dhcpv4.MessageType = 17
+)
+
+// Options defined by RFC6926 §6.2
+var (
+	optionStatusCode = dhcpv4.GenericOptionCode(151)
+	optionDHCPState  = dhcpv4.GenericOptionCode(156)
+)
+
+// Values of the status-code option (RFC6926 §6.2.2)
+const (
+	statusSuccess        = 0
+	statusMalformedQuery = 3
+	statusNotAllowed     = 4
+)
+
+// Values of the dhcp-state option (RFC6926 §6.2.7)
+const (
+	stateActive  = 2
+	stateExpired = 3
+)
+
+// bulkIdleTimeout is how long a bulk leasequery connection may stay idle
+// before it is closed
+const bulkIdleTimeout = 2 * time.Minute
+
+func (p *pluginState) listenBulk(addr string) error {
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for bulk leasequery: %w", err)
+	}
+	log.Printf("Listening for bulk leasequery on %s", l.Addr())
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Errorf("bulk leasequery listener stopped: %v", err)
+				return
+			}
+			go p.serveBulk(conn)
+		}
+	}()
+	return nil
+}
+
+// serveBulk handles the queries of one bulk leasequery connection, in order,
+// until the peer closes it
+func (p *pluginState) serveBulk(conn net.Conn) {
+	defer conn.Close()
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && !p.allowed(addr.IP) {
+		log.Warningf("refusing bulk leasequery connection from unauthorized relay %s", addr.IP)
+		return
+	}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		if err := conn.SetDeadline(time.Now().Add(bulkIdleTimeout)); err != nil {
+			log.Errorf("bulk leasequery: %v", err)
+			return
+		}
+		req, err := readMessage(r)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Warningf("bulk leasequery from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := bulkQuery(w, req, time.Now()); err != nil {
+			log.Warningf("bulk leasequery to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Warningf("bulk leasequery to %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func readMessage(r io.Reader) (*dhcpv4.DHCPv4, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return dhcpv4.FromBytes(buf)
+}
+
+func writeMessage(w io.Writer, m *dhcpv4.DHCPv4) error {
+	data := m.ToBytes()
+	if len(data) > 0xffff {
+		return errors.New("message too large for bulk leasequery framing")
+	}
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func replyTo(req *dhcpv4.DHCPv4, mt dhcpv4.MessageType) (*dhcpv4.DHCPv4, error) {
+	return dhcpv4.New(
+		dhcpv4.WithReply(req),
+		dhcpv4.WithMessageType(mt),
+	)
+}
+
+func writeStatus(w io.Writer, req *dhcpv4.DHCPv4, status uint8, message string) error {
+	resp, err := replyTo(req, MessageTypeLeaseQueryStatus)
+	if err != nil {
+		return err
+	}
+	resp.UpdateOption(dhcpv4.OptGeneric(optionStatusCode, append([]byte{status}, message...)))
+	return writeMessage(w, resp)
+}
+
+// bulkQuery answers one bulk leasequery, writing all the replies to w
+func bulkQuery(w io.Writer, req *dhcpv4.DHCPv4, now time.Time) error {
+	if req.MessageType() != MessageTypeBulkLeaseQuery {
+		return writeStatus(w, req, statusMalformedQuery, "expected a DHCPBULKLEASEQUERY")
+	}
+	if req.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+		return writeStatus(w, req, statusNotAllowed, "query by relay-id or remote-id is not supported")
+	}
+
+	var bindings []Binding
+	hwaddr := req.ClientHWAddr
+	switch {
+	case !req.ClientIPAddr.IsUnspecified():
+		for _, s := range getStores() {
+			if b := s.LookupIP(req.ClientIPAddr); b != nil {
+				bindings = append(bindings, *b)
+			}
+		}
+	case req.Options.Has(dhcpv4.OptionClientIdentifier):
+		cid := req.Options.Get(dhcpv4.OptionClientIdentifier)
+		if len(cid) != 1+6 || iana.HWType(cid[0]) != iana.HWTypeEthernet {
+			return writeStatus(w, req, statusNotAllowed, "unsupported client identifier")
+		}
+		hwaddr = net.HardwareAddr(cid[1:])
+		fallthrough
+	case len(hwaddr) != 0 && !isZero(hwaddr):
+		for _, s := range getStores() {
+			bindings = append(bindings, s.LookupHWAddr(hwaddr)...)
+		}
+	default:
+		for _, s := range getStores() {
+			bindings = append(bindings, s.Bindings()...)
+		}
+	}
+
+	for _, b := range bindings {
+		mt, state := MessageTypeLeaseActive, byte(stateActive)
+		if !b.Active(now) {
+			mt, state = MessageTypeLeaseUnassigned, stateExpired
+		}
+		resp, err := replyTo(req, mt)
+		if err != nil {
+			return err
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(optionDHCPState, []byte{state}))
+		if state == stateActive {
+			setBinding(resp, &b, now)
+		} else {
+			resp.ClientIPAddr = b.IP
+			resp.ClientHWAddr = b.HWAddr
+			resp.HWType = iana.HWTypeEthernet
+			if b.ClientID != nil {
+				resp.UpdateOption(dhcpv4.OptClientIdentifier(b.ClientID))
+			}
+			setLastTransaction(resp, &b, now)
+		}
+		if err := writeMessage(w, resp); err != nil {
+			return err
+		}
+	}
+
+	done, err := replyTo(req, MessageTypeLeaseQueryDone)
+	if err != nil {
+		return err
+	}
+	done.UpdateOption(dhcpv4.OptGeneric(optionStatusCode, []byte{statusSuccess}))
+	return writeMessage(w, done)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
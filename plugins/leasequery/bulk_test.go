@@ -0,0 +1,73 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasequery
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runBulkQuery(t *testing.T, modifiers ...dhcpv4.Modifier) []*dhcpv4.DHCPv4 {
+	modifiers = append([]dhcpv4.Modifier{dhcpv4.WithMessageType(MessageTypeBulkLeaseQuery)}, modifiers...)
+	req, err := dhcpv4.New(modifiers...)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, bulkQuery(&buf, req, time.Now()))
+
+	var replies []*dhcpv4.DHCPv4
+	for buf.Len() > 0 {
+		m, err := readMessage(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, req.TransactionID, m.TransactionID)
+		replies = append(replies, m)
+	}
+	return replies
+}
+
+func TestBulkQueryAll(t *testing.T) {
+	setupStore(t)
+
+	replies := runBulkQuery(t)
+	require.Len(t, replies, 3)
+	types := map[dhcpv4.MessageType]int{}
+	for _, r := range replies[:2] {
+		types[r.MessageType()]++
+	}
+	assert.Equal(t, map[dhcpv4.MessageType]int{
+		MessageTypeLeaseActive:     1,
+		MessageTypeLeaseUnassigned: 1,
+	}, types)
+	assert.Equal(t, MessageTypeLeaseQueryDone, replies[2].MessageType())
+}
+
+func TestBulkQueryHWAddr(t *testing.T) {
+	setupStore(t)
+
+	replies := runBulkQuery(t, dhcpv4.WithHwAddr(activeMAC))
+	require.Len(t, replies, 2)
+	assert.Equal(t, MessageTypeLeaseActive, replies[0].MessageType())
+	assert.True(t, replies[0].ClientIPAddr.Equal(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, []byte{stateActive}, replies[0].Options.Get(optionDHCPState))
+	assert.Equal(t, []byte{0, 0, 0, 60}, replies[0].Options.Get(optionClientLastTransactionTime))
+	assert.Equal(t, MessageTypeLeaseQueryDone, replies[1].MessageType())
+}
+
+func TestBulkQueryRelayID(t *testing.T) {
+	setupStore(t)
+
+	replies := runBulkQuery(t, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(12), []byte("relay")),
+	)))
+	require.Len(t, replies, 1)
+	assert.Equal(t, MessageTypeLeaseQueryStatus, replies[0].MessageType())
+	assert.Equal(t, byte(statusNotAllowed), replies[0].Options.Get(optionStatusCode)[0])
+}
@@ -0,0 +1,295 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package leasequery implements a DHCPv4 Leasequery (RFC4388) responder.
+// Access concentrators and relay agents send DHCPLEASEQUERY messages to learn
+// the binding of an IP address, a hardware address or a client identifier,
+// for example to rebuild their state after a reboot.
+//
+// The plugin does not hold any lease itself: it answers from the bindings of
+// the plugins that register a Store with RegisterStore (eg. the range plugin).
+//
+// As mandated by the RFC, only relayed queries (with a non-zero giaddr) are
+// answered. The accepted relays can further be restricted by listing their
+// addresses as arguments; with no argument, any relay may query the server.
+// The plugin should come after server_id, which sets the mandatory server
+// identifier, and before the plugins allocating leases:
+//
+// server4:
+//   plugins:
+//     - server_id: 10.0.0.1
+//     - leasequery: 10.0.0.254 10.0.1.254
+//     - range: leases.txt 10.0.0.100 10.0.0.200 1h
+//
+// Bulk leasequery (RFC6926) over TCP is enabled with a `bulk=<address>`
+// argument, eg. `- leasequery: bulk=0.0.0.0:67 10.0.0.254`. The same relay
+// restrictions apply, checked against the source address of the connection.
+//
+// The replies about an active binding carry the time since the last
+// transaction of the client (client-last-transaction-time, option 91), when
+// its store knows it, and all the addresses bound to the client, when it has
+// several (associated-ip, option 92), for the relays to rebuild their state.
+package leasequery
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+var log = logger.GetLogger("plugins/leasequery")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "leasequery",
+	Setup4: setup4,
+}
+
+// DHCP message types defined by RFC4388 §6.1
+const (
+	MessageTypeLeaseQuery      dhcpv4.MessageType = 10
+	MessageTypeLeaseUnassigned dhcpv4.MessageType = 11
+	MessageTypeLeaseUnknown    dhcpv4.MessageType = 12
+	MessageTypeLeaseActive     dhcpv4.MessageType = 13
+)
+
+// Options defined by RFC4388 §6.1
+var (
+	optionClientLastTransactionTime = dhcpv4.GenericOptionCode(91)
+	optionAssociatedIP              = dhcpv4.GenericOptionCode(92)
+)
+
+// Binding is the state of a lease, as known to a Store
+type Binding struct {
+	IP       net.IP
+	HWAddr   net.HardwareAddr
+	ClientID []byte
+	Expires  time.Time
+	// LastTransaction is the time of the last message of the client about
+	// the binding, zero if unknown
+	LastTransaction time.Time
+}
+
+// Active returns whether the binding is still valid at the given time
+func (b *Binding) Active(now time.Time) bool {
+	return b.Expires.After(now)
+}
+
+// Store is implemented by plugins holding DHCPv4 bindings, to make them
+// available to leasequeries
+type Store interface {
+	// Contains returns whether the given address is managed by this store,
+	// whether it is currently leased or not
+	Contains(ip net.IP) bool
+	// LookupIP returns the binding of the given address, or nil if there is
+	// none
+	LookupIP(ip net.IP) *Binding
+	// LookupHWAddr returns all the bindings of the given hardware address
+	LookupHWAddr(hwaddr net.HardwareAddr) []Binding
+	// Bindings returns a snapshot of all the bindings in the store, including
+	// expired ones
+	Bindings() []Binding
+}
+
+var (
+	storesLock sync.RWMutex
+	stores     []Store
+)
+
+// RegisterStore makes the bindings of a Store available to the leasequery
+// plugin. It is normally called from the setup function of a plugin
+func RegisterStore(s Store) {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	stores = append(stores, s)
+}
+
+func getStores() []Store {
+	storesLock.RLock()
+	defer storesLock.RUnlock()
+	return stores
+}
+
+type pluginState struct {
+	// relays is the list of relays allowed to query the server. Empty means
+	// any relay is allowed
+	relays []net.IP
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	var (
+		p        pluginState
+		bulkAddr string
+	)
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "bulk=") {
+			bulkAddr = strings.TrimPrefix(arg, "bulk=")
+			continue
+		}
+		relay := net.ParseIP(arg)
+		if relay.To4() == nil {
+			return nil, errors.New("expected a relay IPv4 address, got: " + arg)
+		}
+		p.relays = append(p.relays, relay.To4())
+	}
+	if bulkAddr != "" {
+		if err := p.listenBulk(bulkAddr); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("loaded plugin for DHCPv4, %d allowed relays", len(p.relays))
+	return p.Handler4, nil
+}
+
+func (p *pluginState) allowed(relay net.IP) bool {
+	if len(p.relays) == 0 {
+		return true
+	}
+	for _, r := range p.relays {
+		if r.Equal(relay) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler4 answers DHCPLEASEQUERY messages, and lets any other message through
+func (p *pluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.MessageType() != MessageTypeLeaseQuery {
+		return resp, false
+	}
+	// RFC4388 §6.1: a leasequery with a zero giaddr must be silently discarded
+	if req.GatewayIPAddr == nil || req.GatewayIPAddr.IsUnspecified() {
+		log.Warningf("dropping leasequery without giaddr from %s", req.ClientHWAddr)
+		return nil, true
+	}
+	if !p.allowed(req.GatewayIPAddr) {
+		log.Warningf("dropping leasequery from unauthorized relay %s", req.GatewayIPAddr)
+		return nil, true
+	}
+
+	mt, b := query(req, time.Now())
+	resp.UpdateOption(dhcpv4.OptMessageType(mt))
+	if b != nil {
+		setBinding(resp, b, time.Now())
+	} else if !req.ClientIPAddr.IsUnspecified() {
+		// Query by IP: the reply reflects the queried address
+		resp.ClientIPAddr = req.ClientIPAddr
+	}
+	log.Debugf("leasequery from %s answered with %s", req.GatewayIPAddr, mt)
+	return resp, true
+}
+
+// setBinding describes an active binding in a reply
+func setBinding(resp *dhcpv4.DHCPv4, b *Binding, now time.Time) {
+	resp.ClientIPAddr = b.IP
+	resp.ClientHWAddr = b.HWAddr
+	resp.HWType = iana.HWTypeEthernet
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(b.Expires.Sub(now).Round(time.Second)))
+	if b.ClientID != nil {
+		resp.UpdateOption(dhcpv4.OptClientIdentifier(b.ClientID))
+	}
+	setLastTransaction(resp, b, now)
+	// RFC4388 §6.4.2: a client with several bindings has them all listed
+	if ips := associatedIPs(b.HWAddr, now); len(ips) > 1 {
+		var value []byte
+		for _, ip := range ips {
+			value = append(value, ip.To4()...)
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(optionAssociatedIP, value))
+	}
+}
+
+// setLastTransaction sets the time since the last transaction of the client
+// of a binding, if known
+func setLastTransaction(resp *dhcpv4.DHCPv4, b *Binding, now time.Time) {
+	if b.LastTransaction.IsZero() {
+		return
+	}
+	var value [4]byte
+	if elapsed := now.Sub(b.LastTransaction); elapsed > 0 {
+		binary.BigEndian.PutUint32(value[:], uint32(elapsed/time.Second))
+	}
+	resp.UpdateOption(dhcpv4.OptGeneric(optionClientLastTransactionTime, value[:]))
+}
+
+// associatedIPs returns the addresses of the active bindings of a client, in
+// all the stores
+func associatedIPs(hwaddr net.HardwareAddr, now time.Time) []net.IP {
+	var ips []net.IP
+	for _, s := range getStores() {
+		for _, b := range s.LookupHWAddr(hwaddr) {
+			if b.Active(now) {
+				ips = append(ips, b.IP)
+			}
+		}
+	}
+	return ips
+}
+
+// query resolves a leasequery against the registered stores. The binding is
+// only returned for an active lease
+func query(req *dhcpv4.DHCPv4, now time.Time) (dhcpv4.MessageType, *Binding) {
+	// RFC4388 §6.4: the query is by IP address if ciaddr is set, otherwise
+	// by client identifier if present, otherwise by hardware address
+	if !req.ClientIPAddr.IsUnspecified() {
+		return queryIP(req.ClientIPAddr, now)
+	}
+	hwaddr := req.ClientHWAddr
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); cid != nil {
+		// Stores are keyed by hardware address, so only the common
+		// "hardware type + address" form of client identifiers is supported
+		if len(cid) != 1+6 || iana.HWType(cid[0]) != iana.HWTypeEthernet {
+			return MessageTypeLeaseUnknown, nil
+		}
+		hwaddr = net.HardwareAddr(cid[1:])
+	}
+	if len(hwaddr) == 0 || isZero(hwaddr) {
+		return MessageTypeLeaseUnknown, nil
+	}
+	return queryHWAddr(hwaddr, now)
+}
+
+func queryIP(ip net.IP, now time.Time) (dhcpv4.MessageType, *Binding) {
+	managed := false
+	for _, s := range getStores() {
+		if !s.Contains(ip) {
+			continue
+		}
+		managed = true
+		if b := s.LookupIP(ip); b != nil && b.Active(now) {
+			return MessageTypeLeaseActive, b
+		}
+	}
+	if managed {
+		return MessageTypeLeaseUnassigned, nil
+	}
+	return MessageTypeLeaseUnknown, nil
+}
+
+func queryHWAddr(hwaddr net.HardwareAddr, now time.Time) (dhcpv4.MessageType, *Binding) {
+	var latest *Binding
+	for _, s := range getStores() {
+		bindings := s.LookupHWAddr(hwaddr)
+		for i := range bindings {
+			// RFC4388 §6.4.2: with several bindings, return the most recent one
+			if bindings[i].Active(now) && (latest == nil || bindings[i].Expires.After(latest.Expires)) {
+				latest = &bindings[i]
+			}
+		}
+	}
+	if latest == nil {
+		return MessageTypeLeaseUnknown, nil
+	}
+	return MessageTypeLeaseActive, latest
+}
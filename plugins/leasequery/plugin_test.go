@@ -0,0 +1,157 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasequery
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testStore struct {
+	subnet   *net.IPNet
+	bindings []Binding
+}
+
+func (s *testStore) Contains(ip net.IP) bool { return s.subnet.Contains(ip) }
+
+func (s *testStore) LookupIP(ip net.IP) *Binding {
+	for i := range s.bindings {
+		if s.bindings[i].IP.Equal(ip) {
+			return &s.bindings[i]
+		}
+	}
+	return nil
+}
+
+func (s *testStore) Bindings() []Binding { return s.bindings }
+
+func (s *testStore) LookupHWAddr(hwaddr net.HardwareAddr) []Binding {
+	var ret []Binding
+	for _, b := range s.bindings {
+		if b.HWAddr.String() == hwaddr.String() {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+var (
+	activeMAC  = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	expiredMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	relay      = net.IPv4(10, 0, 0, 254)
+)
+
+func setupStore(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+	stores = []Store{&testStore{
+		subnet: subnet,
+		bindings: []Binding{
+			{IP: net.IPv4(10, 0, 0, 1), HWAddr: activeMAC, Expires: time.Now().Add(time.Hour), LastTransaction: time.Now().Add(-time.Minute)},
+			{IP: net.IPv4(10, 0, 0, 2), HWAddr: expiredMAC, Expires: time.Now().Add(-time.Hour)},
+		},
+	}}
+}
+
+func leasequery(t *testing.T, modifiers ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, bool) {
+	modifiers = append([]dhcpv4.Modifier{
+		dhcpv4.WithMessageType(MessageTypeLeaseQuery),
+		dhcpv4.WithGatewayIP(relay),
+	}, modifiers...)
+	req, err := dhcpv4.New(modifiers...)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	p := pluginState{}
+	return p.Handler4(req, stub)
+}
+
+func TestQueryByIP(t *testing.T) {
+	setupStore(t)
+
+	resp, stop := leasequery(t, dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 1)))
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, MessageTypeLeaseActive, resp.MessageType())
+	assert.Equal(t, activeMAC, resp.ClientHWAddr)
+	assert.True(t, resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime))
+	assert.Equal(t, []byte{0, 0, 0, 60}, resp.Options.Get(optionClientLastTransactionTime))
+	assert.False(t, resp.Options.Has(optionAssociatedIP), "single binding")
+
+	resp, _ = leasequery(t, dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 2)))
+	assert.Equal(t, MessageTypeLeaseUnassigned, resp.MessageType(), "expired lease")
+
+	resp, _ = leasequery(t, dhcpv4.WithClientIP(net.IPv4(192, 0, 2, 1)))
+	assert.Equal(t, MessageTypeLeaseUnknown, resp.MessageType(), "address out of all stores")
+	assert.True(t, resp.ClientIPAddr.Equal(net.IPv4(192, 0, 2, 1)))
+}
+
+func TestQueryByHWAddr(t *testing.T) {
+	setupStore(t)
+
+	resp, _ := leasequery(t, dhcpv4.WithHwAddr(activeMAC))
+	assert.Equal(t, MessageTypeLeaseActive, resp.MessageType())
+	assert.True(t, resp.ClientIPAddr.Equal(net.IPv4(10, 0, 0, 1)))
+
+	resp, _ = leasequery(t, dhcpv4.WithHwAddr(expiredMAC))
+	assert.Equal(t, MessageTypeLeaseUnknown, resp.MessageType())
+}
+
+func TestQueryByClientID(t *testing.T) {
+	setupStore(t)
+
+	cid := append([]byte{1}, activeMAC...)
+	resp, _ := leasequery(t, dhcpv4.WithOption(dhcpv4.OptClientIdentifier(cid)))
+	assert.Equal(t, MessageTypeLeaseActive, resp.MessageType())
+	assert.True(t, resp.ClientIPAddr.Equal(net.IPv4(10, 0, 0, 1)))
+}
+
+func TestDropUnrelayed(t *testing.T) {
+	setupStore(t)
+
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(MessageTypeLeaseQuery),
+		dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 1)),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	p := pluginState{}
+	resp, stop := p.Handler4(req, stub)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	req.GatewayIPAddr = relay
+	p.relays = []net.IP{net.IPv4(192, 0, 2, 1)}
+	resp, _ = p.Handler4(req, stub)
+	assert.Nil(t, resp, "leasequery from a relay that isn't allowed")
+}
+
+func TestAssociatedIP(t *testing.T) {
+	setupStore(t)
+	_, subnet, err := net.ParseCIDR("10.0.1.0/24")
+	require.NoError(t, err)
+	stores = append(stores, &testStore{
+		subnet: subnet,
+		bindings: []Binding{
+			{IP: net.IPv4(10, 0, 1, 1), HWAddr: activeMAC, Expires: time.Now().Add(2 * time.Hour)},
+		},
+	})
+
+	resp, _ := leasequery(t, dhcpv4.WithClientIP(net.IPv4(10, 0, 1, 1)))
+	assert.Equal(t, MessageTypeLeaseActive, resp.MessageType())
+	assert.Equal(t, []byte{10, 0, 0, 1, 10, 0, 1, 1}, resp.Options.Get(optionAssociatedIP))
+	assert.False(t, resp.Options.Has(optionClientLastTransactionTime), "unknown last transaction")
+
+	// The most recent binding of the client
+	resp, _ = leasequery(t, dhcpv4.WithHwAddr(activeMAC))
+	assert.True(t, resp.ClientIPAddr.Equal(net.IPv4(10, 0, 1, 1)))
+	assert.Equal(t, []byte{10, 0, 0, 1, 10, 0, 1, 1}, resp.Options.Get(optionAssociatedIP))
+}
@@ -2,15 +2,45 @@
 // This source code is licensed under the MIT license found in the
 // LICENSE file in the root directory of this source tree.
 
+// Package leasetime implements the lease_time plugin, setting the lease time
+// given to the DHCPv4 clients, and their renewal (T1, option 58) and
+// rebinding (T2, option 59) times.
+//
+// The first argument is the default lease time, only set when no previous
+// plugin set one. The other arguments set the lease time in more specific
+// cases, overriding the previous plugins, by order of precedence:
+// - <duration>@<class>: for the clients of a class (see the class package for
+// the syntax), the first matching one applies
+// - <duration>@<start IP>-<end IP>: for the clients given an address of the
+// pool, by the previous plugins
+// - renew=<duration>: for the clients renewing or rebinding their lease
+// - offer=<duration>: for the clients getting a new lease
+// - t1=<duration> and t2=<duration>: the renewal and rebinding times, either
+// as durations or as percentages of the lease time, eg. t1=50% t2=87.5%
+//
+// To apply to the addresses they allocate, the plugin must come after the
+// allocating plugins (eg. range). The addresses are then reserved for the
+// lease time of these plugins, which should be the longest.
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 10.0.0.100 10.0.0.200 24h
+//     - lease_time: 12h 1h@vendor:android 10m@10.0.0.180-10.0.0.200 offer=1h t1=50% t2=87.5%
 package leasetime
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -22,36 +52,205 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
-	log         = logger.GetLogger("plugins/lease_time")
-	v4LeaseTime time.Duration
-)
+var log = logger.GetLogger("plugins/lease_time")
+
+type classLeaseTime struct {
+	*class.Matcher
+	leaseTime time.Duration
+}
+
+type poolLeaseTime struct {
+	start, end net.IP
+	leaseTime  time.Duration
+}
+
+func (p *poolLeaseTime) contains(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && bytes.Compare(ip4, p.start) >= 0 && bytes.Compare(ip4, p.end) <= 0
+}
+
+// timer is a T1 or T2 time, either a duration or a share of the lease time
+type timer struct {
+	duration time.Duration
+	percent  float64
+}
+
+func parseTimer(value string) (*timer, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return nil, fmt.Errorf("invalid percentage %q", value)
+		}
+		return &timer{percent: percent}, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid duration %q", value)
+	}
+	return &timer{duration: d}, nil
+}
+
+// of returns the time for a lease time
+func (t *timer) of(leaseTime time.Duration) time.Duration {
+	if t.duration != 0 {
+		return t.duration
+	}
+	return time.Duration(float64(leaseTime) * t.percent / 100).Round(time.Second)
+}
+
+// PluginState holds the lease times of an instance of the lease_time plugin
+type PluginState struct {
+	defaultLeaseTime time.Duration
+	classes          []classLeaseTime
+	pools            []poolLeaseTime
+	offer, renew     time.Duration
+	t1, t2           *timer
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need a default lease time")
+	}
+	var (
+		p   PluginState
+		err error
+	)
+	if p.defaultLeaseTime, err = parseDuration(args[0]); err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		if sep := strings.IndexByte(arg, '@'); sep >= 0 {
+			leaseTime, err := parseDuration(arg[:sep])
+			if err != nil {
+				return nil, err
+			}
+			// Classes always have a kind: prefix, IPv4 pools never do
+			target := arg[sep+1:]
+			if strings.Contains(target, ":") {
+				m, err := class.Parse(target)
+				if err != nil {
+					return nil, err
+				}
+				p.classes = append(p.classes, classLeaseTime{Matcher: m, leaseTime: leaseTime})
+				continue
+			}
+			bounds := strings.SplitN(target, "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("invalid argument %q, expected <duration>@<class> or <duration>@<start IP>-<end IP>", arg)
+			}
+			pool := poolLeaseTime{start: net.ParseIP(bounds[0]).To4(), end: net.ParseIP(bounds[1]).To4(), leaseTime: leaseTime}
+			if pool.start == nil || pool.end == nil || bytes.Compare(pool.start, pool.end) > 0 {
+				return nil, fmt.Errorf("invalid IPv4 range %q", target)
+			}
+			p.pools = append(p.pools, pool)
+			continue
+		}
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "offer":
+			if p.offer, err = parseDuration(value); err != nil {
+				return nil, err
+			}
+		case "renew":
+			if p.renew, err = parseDuration(value); err != nil {
+				return nil, err
+			}
+		case "t1":
+			if p.t1, err = parseTimer(value); err != nil {
+				return nil, fmt.Errorf("invalid T1: %w", err)
+			}
+		case "t2":
+			if p.t2, err = parseTimer(value); err != nil {
+				return nil, fmt.Errorf("invalid T2: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.t1 != nil && p.t2 != nil && p.t1.percent != 0 && p.t2.percent != 0 && p.t1.percent >= p.t2.percent {
+		return nil, errors.New("T1 must be lower than T2")
+	}
+	return &p, nil
+}
+
+// leaseTime returns the lease time overriding the one of the previous
+// plugins, if any
+func (p *PluginState) leaseTime(req, resp *dhcpv4.DHCPv4) (time.Duration, bool) {
+	for _, c := range p.classes {
+		if c.Match4(req) {
+			return c.leaseTime, true
+		}
+	}
+	for _, pool := range p.pools {
+		if pool.contains(resp.YourIPAddr) {
+			return pool.leaseTime, true
+		}
+	}
+	// Renewing and rebinding clients send their address in ciaddr
+	// (RFC2131 §4.3.2), new ones in the requested IP option, if any
+	renewing := req.MessageType() == dhcpv4.MessageTypeRequest && !req.ClientIPAddr.IsUnspecified()
+	if renewing && p.renew != 0 {
+		return p.renew, true
+	}
+	if !renewing && p.offer != 0 {
+		return p.offer, true
+	}
+	return 0, false
+}
 
 // Handler4 handles DHCPv4 packets for the lease_time plugin.
-func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if req.OpCode != dhcpv4.OpcodeBootRequest {
 		return resp, false
 	}
-	// Set lease time unless it has already been set
-	if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
-		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(v4LeaseTime))
+	// Only offers and acks carry a lease time: RFC2131 §4.3.5 forbids it in
+	// the reply to an INFORM, and BOOTP clients have no notion of leases
+	if mt := req.MessageType(); mt != dhcpv4.MessageTypeDiscover && mt != dhcpv4.MessageTypeRequest {
+		return resp, false
+	}
+	if leaseTime, ok := p.leaseTime(req, resp); ok {
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(leaseTime))
+	} else if !resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+		// Set lease time unless it has already been set
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.defaultLeaseTime))
+	}
+	leaseTime := resp.IPAddressLeaseTime(p.defaultLeaseTime)
+	var t1, t2 time.Duration
+	if p.t1 != nil {
+		t1 = p.t1.of(leaseTime)
+	}
+	if p.t2 != nil {
+		t2 = p.t2.of(leaseTime)
+	}
+	// Durations too long for the lease are left to the clients defaults,
+	// 50% and 87.5% of the lease time (RFC2131 §4.4.5)
+	if t2 != 0 && t2 < leaseTime {
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionRebindingTimeValue, Value: dhcpv4.Duration(t2)})
+	} else {
+		t2 = leaseTime
+	}
+	if t1 != 0 && t1 < t2 {
+		resp.Options.Update(dhcpv4.Option{Code: dhcpv4.OptionRenewTimeValue, Value: dhcpv4.Duration(t1)})
 	}
 	return resp, false
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Print("loading `lease_time` plugin for DHCPv4")
-	if len(args) < 1 {
-		log.Error("No default lease time provided")
-		return nil, errors.New("lease_time failed to initialize")
-	}
-
-	leaseTime, err := time.ParseDuration(args[0])
+	p, err := parseArgs(args...)
 	if err != nil {
-		log.Errorf("invalid duration: %v", args[0])
-		return nil, errors.New("lease_time failed to initialize")
+		return nil, err
 	}
-	v4LeaseTime = leaseTime
-
-	return Handler4, nil
+	return p.Handler4, nil
 }
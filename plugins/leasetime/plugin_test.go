@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"1 hour"},
+		{"1h", "10m@vendor"},
+		{"1h", "10m@10.0.0.20-10.0.0.10"},
+		{"1h", "-10m@vendor:android"},
+		{"1h", "offer"},
+		{"1h", "renew=0s"},
+		{"1h", "t1=100%"},
+		{"1h", "t1=90%", "t2=80%"},
+		{"1h", "rebind=30m"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	p, err := parseArgs("12h", "1h@vendor:android", "10m@10.0.0.180-10.0.0.200", "offer=2h", "renew=6h", "t1=50%", "t2=87.5%")
+	require.NoError(t, err)
+
+	handle := func(yiaddr net.IP, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		// As set by the range plugin
+		stub, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithYourIP(yiaddr),
+			dhcpv4.WithLeaseTime(uint32((24 * time.Hour).Seconds())),
+		)
+		require.NoError(t, err)
+		resp, stop := p.Handler4(req, stub)
+		assert.False(t, stop)
+		return resp
+	}
+
+	discover := dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover)
+	resp := handle(net.IPv4(10, 0, 0, 100), discover)
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, time.Hour, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, 105*time.Minute, resp.IPAddressRebindingTime(0))
+
+	// Renewing clients send their address in ciaddr
+	resp = handle(net.IPv4(10, 0, 0, 100), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 100)))
+	assert.Equal(t, 6*time.Hour, resp.IPAddressLeaseTime(0))
+
+	// Pools come before the renewing or new state
+	resp = handle(net.IPv4(10, 0, 0, 190), discover)
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+
+	// And classes before pools
+	resp = handle(net.IPv4(10, 0, 0, 190), discover, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("android-dhcp-11")))
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+
+	// INFORM replies carry no lease time
+	resp = handle(net.IPv4zero, dhcpv4.WithMessageType(dhcpv4.MessageTypeInform))
+	assert.Equal(t, 24*time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, time.Duration(0), resp.IPAddressRenewalTime(0))
+}
+
+func TestDefault(t *testing.T) {
+	p, err := parseArgs("1h", "t1=20m", "t2=2h")
+	require.NoError(t, err)
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, _ := p.Handler4(req, stub)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, 20*time.Minute, resp.IPAddressRenewalTime(0))
+	// T2 is longer than the lease, it is left out
+	assert.Equal(t, time.Duration(0), resp.IPAddressRebindingTime(0))
+}
@@ -0,0 +1,118 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/coredhcp/coredhcp/metrics"
+)
+
+// instance is a plugin set up from the configuration
+type instance struct {
+	plugin *Plugin
+	args   []string
+}
+
+// loaded holds the plugins set up, in order, for their lifecycle hooks
+var loaded struct {
+	sync.Mutex
+	instances []instance
+}
+
+func addLoaded(plugin *Plugin, args []string) {
+	loaded.Lock()
+	defer loaded.Unlock()
+	loaded.instances = append(loaded.instances, instance{plugin: plugin, args: args})
+}
+
+// loadedPlugins returns the plugins set up, once each, in the order they were
+// first set up
+func loadedPlugins() []*Plugin {
+	loaded.Lock()
+	defer loaded.Unlock()
+	var plugins []*Plugin
+	seen := make(map[*Plugin]bool)
+	for _, i := range loaded.instances {
+		if !seen[i.plugin] {
+			seen[i.plugin] = true
+			plugins = append(plugins, i.plugin)
+		}
+	}
+	return plugins
+}
+
+// Start calls the Start hooks of the plugins set up, once the server is set
+// up. Their background work stops when ctx is done. It holds their
+// metrics.Registry
+func Start(ctx context.Context) error {
+	for _, p := range loadedPlugins() {
+		if p.Start == nil {
+			continue
+		}
+		log.Debugf("Starting plugin `%s`", p.Name)
+		if err := p.Start(metrics.NewContext(ctx, metrics.Get(p.Name))); err != nil {
+			return fmt.Errorf("cannot start plugin `%s`: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// Stop calls the Stop hooks of the plugins set up, in the reverse order,
+// once the server stopped handling requests
+func Stop() {
+	plugins := loadedPlugins()
+	for i := len(plugins) - 1; i >= 0; i-- {
+		p := plugins[i]
+		if p.Stop == nil {
+			continue
+		}
+		log.Debugf("Stopping plugin `%s`", p.Name)
+		if err := p.Stop(); err != nil {
+			log.Errorf("Plugin `%s` did not stop cleanly: %v", p.Name, err)
+		}
+	}
+}
+
+// Reload calls the Reload hooks of the plugins set up, once for each time
+// they were set up, with the arguments they were set up with. The errors are
+// logged, the other plugins being reloaded anyway
+func Reload() error {
+	loaded.Lock()
+	instances := append([]instance(nil), loaded.instances...)
+	loaded.Unlock()
+	failed := 0
+	for _, i := range instances {
+		if i.plugin.Reload == nil {
+			continue
+		}
+		log.Infof("Reloading plugin `%s`", i.plugin.Name)
+		if err := i.plugin.Reload(i.args...); err != nil {
+			log.Errorf("Cannot reload plugin `%s`: %v", i.plugin.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d plugins could not be reloaded", failed)
+	}
+	return nil
+}
+
+// Health returns the errors of the Health hooks of the plugins set up, by
+// plugin name. It is empty when they are all healthy
+func Health() map[string]error {
+	errs := make(map[string]error)
+	for _, p := range loadedPlugins() {
+		if p.Health == nil {
+			continue
+		}
+		if err := p.Health(); err != nil {
+			errs[p.Name] = err
+		}
+	}
+	return errs
+}
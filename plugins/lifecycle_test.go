@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// calls records the calls to the hooks of test-lifecycle
+var calls []string
+
+func init() {
+	_ = RegisterPlugin(&Plugin{
+		Name: "test-lifecycle",
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				return resp, false
+			}, nil
+		},
+		Setup6: func(args ...string) (handler.Handler6, error) {
+			return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+				return resp, false
+			}, nil
+		},
+		Start: func(ctx context.Context) error {
+			calls = append(calls, "start")
+			return nil
+		},
+		Stop: func() error {
+			calls = append(calls, "stop")
+			return nil
+		},
+		Reload: func(args ...string) error {
+			calls = append(calls, "reload "+strings.Join(args, " "))
+			if args[0] == "fail" {
+				return errors.New("failed")
+			}
+			return nil
+		},
+		Health: func() error {
+			return errors.New("unhealthy")
+		},
+	})
+}
+
+func TestLifecycle(t *testing.T) {
+	loaded.instances = nil
+	calls = nil
+	_, err := LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-lifecycle", Args: []string{"a", "b"}},
+		{Name: "test-a", Args: []string{"1"}},
+	}})
+	require.NoError(t, err)
+	_, err = LoadPlugins6(&config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-lifecycle", Args: []string{"c"}},
+	}})
+	require.NoError(t, err)
+
+	// Once per plugin
+	require.NoError(t, Start(context.Background()))
+	assert.Equal(t, []string{"start"}, calls)
+	assert.Equal(t, map[string]error{"test-lifecycle": errors.New("unhealthy")}, Health())
+
+	// Once per instance
+	require.NoError(t, Reload())
+	assert.Equal(t, []string{"start", "reload a b", "reload c"}, calls)
+	Stop()
+	assert.Equal(t, []string{"start", "reload a b", "reload c", "stop"}, calls)
+
+	_, err = LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-lifecycle", Args: []string{"fail"}},
+	}})
+	require.NoError(t, err)
+	assert.Error(t, Reload())
+}
@@ -0,0 +1,108 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package maxrt implements a plugin overriding the maximum retransmission
+// times of DHCPv6 clients (RFC8415, section 21.24 and 21.25), eg. to make
+// clients that got no addresses retry less often.
+//
+// Arguments are `sol=<duration>` for SOL_MAX_RT, which applies to Solicit
+// messages, and `inf=<duration>` for INF_MAX_RT, which applies to
+// Information-request messages. Both must be between 60s and 86400s.
+//
+// server6:
+//   plugins:
+//     - maxrt: sol=1h inf=1h
+package maxrt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/maxrt")
+
+// Plugin wraps the maxrt plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "maxrt",
+	Setup6: setup6,
+}
+
+// Bounds of SOL_MAX_RT and INF_MAX_RT, RFC8415 section 21.24
+const (
+	minMaxRT = 60 * time.Second
+	maxMaxRT = 86400 * time.Second
+)
+
+var (
+	solMaxRT time.Duration
+	infMaxRT time.Duration
+)
+
+func parseMaxRT(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+	if d < minMaxRT || d > maxMaxRT {
+		return 0, fmt.Errorf("duration %s out of range [%s, %s]", d, minMaxRT, maxMaxRT)
+	}
+	return d, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one of sol=<duration> or inf=<duration>")
+	}
+	for _, arg := range args {
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "sol="):
+			solMaxRT, err = parseMaxRT(strings.TrimPrefix(arg, "sol="))
+		case strings.HasPrefix(arg, "inf="):
+			infMaxRT, err = parseMaxRT(strings.TrimPrefix(arg, "inf="))
+		default:
+			err = fmt.Errorf("unknown argument: %s", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("loaded plugin for DHCPv6, SOL_MAX_RT %s, INF_MAX_RT %s", solMaxRT, infMaxRT)
+	return Handler6, nil
+}
+
+// maxRTOption builds a SOL_MAX_RT or INF_MAX_RT option, a 32 bits number of
+// seconds
+func maxRTOption(code dhcpv6.OptionCode, d time.Duration) dhcpv6.Option {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(d/time.Second))
+	return &dhcpv6.OptionGeneric{OptionCode: code, OptionData: data}
+}
+
+// Handler6 handles DHCPv6 packets for the maxrt plugin
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+
+	// INF_MAX_RT is only meaningful in replies to Information-requests
+	if solMaxRT != 0 && decap.IsOptionRequested(dhcpv6.OptionSolMaxRT) {
+		resp.UpdateOption(maxRTOption(dhcpv6.OptionSolMaxRT, solMaxRT))
+	}
+	if infMaxRT != 0 && decap.Type() == dhcpv6.MessageTypeInformationRequest &&
+		decap.IsOptionRequested(dhcpv6.OptionInfMaxRT) {
+		resp.UpdateOption(maxRTOption(dhcpv6.OptionInfMaxRT, infMaxRT))
+	}
+	return resp, false
+}
@@ -0,0 +1,64 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package maxrt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	_, err := setup6("sol=1h", "inf=2h")
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, solMaxRT)
+	assert.Equal(t, 2*time.Hour, infMaxRT)
+
+	for _, arg := range []string{"sol=10s", "inf=48h", "sol=forever", "max=1h"} {
+		_, err := setup6(arg)
+		assert.Error(t, err, arg)
+	}
+	_, err = setup6()
+	assert.Error(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	solMaxRT, infMaxRT = time.Hour, 2*time.Hour
+
+	for _, tt := range []struct {
+		msgType  dhcpv6.MessageType
+		sol, inf bool
+	}{
+		{dhcpv6.MessageTypeSolicit, true, false},
+		{dhcpv6.MessageTypeInformationRequest, true, true},
+	} {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = tt.msgType
+		req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionSolMaxRT, dhcpv6.OptionInfMaxRT))
+
+		stub, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		stub.MessageType = dhcpv6.MessageTypeReply
+
+		resp, stop := Handler6(req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+
+		sol := resp.GetOneOption(dhcpv6.OptionSolMaxRT)
+		inf := resp.GetOneOption(dhcpv6.OptionInfMaxRT)
+		assert.Equal(t, tt.sol, sol != nil, tt.msgType)
+		assert.Equal(t, tt.inf, inf != nil, tt.msgType)
+		if sol != nil {
+			assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, sol.ToBytes())
+		}
+		if inf != nil {
+			assert.Equal(t, []byte{0, 0, 0x1c, 0x20}, inf.ToBytes())
+		}
+	}
+}
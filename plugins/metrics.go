@@ -0,0 +1,76 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"expvar"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/metrics"
+)
+
+// outcomes counts how the instances of a plugin handled the requests
+type outcomes struct {
+	name                            string
+	registry                        *metrics.Registry
+	served, dropped, passed, errors *expvar.Int
+}
+
+func newOutcomes(name string) *outcomes {
+	r := metrics.Get(name)
+	return &outcomes{
+		name:     name,
+		registry: r,
+		served:   r.Counter("served"),
+		dropped:  r.Counter("dropped"),
+		passed:   r.Counter("passed"),
+		errors:   r.Counter("errors"),
+	}
+}
+
+// leave counts how a plugin handled a request
+func (o *outcomes) leave(ctx context.Context, dropped, stop bool) {
+	if err := handler.LeavePlugin(ctx); err != nil {
+		o.errors.Add(1)
+	}
+	switch {
+	case dropped:
+		o.dropped.Add(1)
+		handler.RecordDecision(ctx, o.name, "dropped")
+	case stop:
+		o.served.Add(1)
+		handler.RecordDecision(ctx, o.name, "served")
+	default:
+		o.passed.Add(1)
+		handler.RecordDecision(ctx, o.name, "passed")
+	}
+}
+
+// instrument6 returns a handler running that of a plugin, giving it its
+// metrics and counting how it handled the requests
+func instrument6(name string, h handler.Handler6Ctx) handler.Handler6Ctx {
+	o := newOutcomes(name)
+	return func(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		handler.EnterPlugin(ctx, o.registry)
+		resp, stop := h(ctx, req, resp)
+		o.leave(ctx, stop && resp == nil, stop)
+		return resp, stop
+	}
+}
+
+// instrument4 behaves like instrument6, for DHCPv4 handlers
+func instrument4(name string, h handler.Handler4Ctx) handler.Handler4Ctx {
+	o := newOutcomes(name)
+	return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		handler.EnterPlugin(ctx, o.registry)
+		resp, stop := h(ctx, req, resp)
+		o.leave(ctx, stop && resp == nil, stop)
+		return resp, stop
+	}
+}
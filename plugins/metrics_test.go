@@ -0,0 +1,65 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/metrics"
+)
+
+// test-outcome handles the requests as its argument says, counting them
+func init() {
+	_ = RegisterPlugin(&Plugin{
+		Name: "test-outcome",
+		Setup4Ctx: func(args ...string) (handler.Handler4Ctx, error) {
+			return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				metrics.FromContext(ctx).Counter("requests").Add(1)
+				switch args[0] {
+				case "serve":
+					return resp, true
+				case "drop":
+					return nil, true
+				case "fail":
+					handler.ReportError(ctx, errors.New("failed"))
+				}
+				return resp, false
+			}, nil
+		},
+	})
+}
+
+func TestOutcomes(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	for i, arg := range []string{"serve", "drop", "pass", "fail", "serve"} {
+		handlers, err := LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{
+			{Name: "test-outcome", Args: []string{arg}},
+		}})
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		handlers[0](ctx, req, resp)
+		outcome := []string{"served", "dropped", "passed", "passed", "served"}[i]
+		assert.Equal(t, []handler.Decision{{Plugin: "test-outcome", Outcome: outcome}}, handler.Decisions(ctx), arg)
+	}
+
+	r := metrics.Get("test-outcome")
+	for name, want := range map[string]int64{
+		"served": 2, "dropped": 1, "passed": 2, "errors": 1, "requests": 5,
+	} {
+		assert.Equal(t, want, r.Counter(name).Value(), name)
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package mud implements a plugin for the Manufacturer Usage Description URLs
+// (RFC 8520) which IoT devices send in DHCPv4 option 161 or DHCPv6 option
+// 112, telling where the description of the network access they need is. The
+// plugin logs the URLs, and can post them, as JSON, with the address and the
+// hardware address or DUID of the devices, to a MUD controller, which fetches
+// the descriptions and applies their policies.
+//
+// Arguments, all optional, are:
+// - url=<URL>: an endpoint of the MUD controller to post the URLs to, can be
+// repeated
+// - secret=<string>: a key signing the posts with HMAC-SHA256, as for the
+// webhook plugin
+// - retries=<n>: how many times to retry a failed post, 3 by default
+// - timeout=<duration>: the timeout of a post, 5s by default
+//
+// The plugin must come after the plugins assigning addresses:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - mud: url=https://mud.example.org/dhcp secret=s3cr3t
+//
+// A URL is logged and posted when its device gets an address, then again
+// only when the URL or the address changes. RFC 8520 requires https URLs: the
+// others are logged, and not posted. Posts are sent in the background, in
+// order; beyond 1024 waiting ones, new ones are dropped. The pending ones are
+// posted when the server stops, for up to 5s. The plugin is unhealthy while
+// the last post to an endpoint failed.
+package mud
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/webhook"
+)
+
+var log = logger.GetLogger("plugins/mud")
+
+// Plugin wraps the mud plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "mud",
+	Setup6: setup6,
+	Setup4: setup4,
+	Stop:   stop,
+	Health: health,
+}
+
+// OptionMUDURL4 is the DHCPv4 option carrying the MUD URL, RFC 8520 §10
+var OptionMUDURL4 = dhcpv4.GenericOptionCode(161)
+
+// queueSize is how many posts can wait to be sent
+const queueSize = 1024
+
+// retryDelay is the delay before the first retry of a post, doubled for each
+// of the following ones
+var retryDelay = time.Second
+
+// stopTimeout bounds how long the pending posts are waited for when the
+// server stops
+const stopTimeout = 5 * time.Second
+
+// Announcement is what is posted to the MUD controller
+type Announcement struct {
+	MUDURL string    `json:"mud_url"`
+	Time   time.Time `json:"time"`
+	IP     net.IP    `json:"ip"`
+	// HWAddr is set for DHCPv4 clients, DUID for DHCPv6 clients
+	HWAddr string `json:"hwaddr,omitempty"`
+	DUID   string `json:"duid,omitempty"`
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds the configuration of an instance of the plugin
+type PluginState struct {
+	urls    []string
+	secret  []byte
+	retries int
+	client  *http.Client
+	queue   chan Announcement
+	// done is closed once the announcements are all posted, after the
+	// plugin stopped
+	done chan struct{}
+
+	// mu guards seen, the last URL and address announced by each client,
+	// stopped, set once the queue is closed, and failures, the errors of
+	// the last posts to the endpoints, by URL, when they failed
+	mu       sync.Mutex
+	seen     map[string]string
+	stopped  bool
+	failures map[string]error
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		retries:  3,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		queue:    make(chan Announcement, queueSize),
+		seen:     make(map[string]string),
+		failures: make(map[string]error),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid URL %q, expected an http or https URL", value)
+			}
+			p.urls = append(p.urls, value)
+		case "secret":
+			if value == "" {
+				return nil, errors.New("empty secret")
+			}
+			p.secret = []byte(value)
+		case "retries":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("invalid number of retries %q", value)
+			}
+			p.retries = retries
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			p.client.Timeout = timeout
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.secret != nil && len(p.urls) == 0 {
+		return nil, errors.New("a secret needs a URL to post to")
+	}
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.done = make(chan struct{})
+	go func() {
+		for a := range p.queue {
+			p.deliver(a)
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	if len(p.urls) > 0 {
+		log.Printf("posting MUD URLs to %s", strings.Join(p.urls, ", "))
+	} else {
+		log.Printf("logging MUD URLs")
+	}
+	return p, nil
+}
+
+// stop posts the pending announcements of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+	}
+	deadline := time.After(stopTimeout)
+	for _, p := range instances.list {
+		select {
+		case <-p.done:
+		case <-deadline:
+			return fmt.Errorf("gave up posting the pending MUD URLs after %v", stopTimeout)
+		}
+	}
+	return nil
+}
+
+// health returns the error of the last post to an endpoint, if it failed
+func health() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		for _, u := range p.urls {
+			if err := p.failures[u]; err != nil {
+				p.mu.Unlock()
+				return fmt.Errorf("cannot post to %s: %w", u, err)
+			}
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// validURL tells whether a MUD URL is an https URL, as RFC 8520 §3 requires
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// announce logs and queues the MUD URL of a client, client being its
+// hardware address or DUID, unless it announced it already with that address
+func (p *PluginState) announce(client string, a Announcement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seen := a.MUDURL + " " + a.IP.String()
+	if p.seen[client] == seen {
+		return
+	}
+	p.seen[client] = seen
+	if !validURL(a.MUDURL) {
+		log.Warningf("%s at %s announces MUD URL %q, which is not an https URL, ignoring it", client, a.IP, a.MUDURL)
+		return
+	}
+	log.Infof("%s at %s announces MUD URL %s", client, a.IP, a.MUDURL)
+	if len(p.urls) == 0 || p.stopped {
+		return
+	}
+	a.Time = time.Now()
+	select {
+	case p.queue <- a:
+	default:
+		log.Warningf("too many pending posts, dropping the MUD URL of %s", client)
+	}
+}
+
+// deliver posts an announcement to all the endpoints
+func (p *PluginState) deliver(a Announcement) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Errorf("could not encode the MUD URL of %s: %v", a.IP, err)
+		return
+	}
+	for _, u := range p.urls {
+		var err error
+		for attempt := 0; ; attempt++ {
+			if err = p.post(u, body); err == nil {
+				break
+			}
+			if attempt >= p.retries {
+				log.Warningf("could not post the MUD URL of %s to %s: %v", a.IP, u, err)
+				break
+			}
+			time.Sleep(retryDelay << attempt)
+		}
+		p.mu.Lock()
+		p.failures[u] = err
+		p.mu.Unlock()
+	}
+}
+
+func (p *PluginState) post(u string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.secret != nil {
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write(body)
+		req.Header.Set(webhook.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Handler4 handles DHCPv4 packets for the mud plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	mudURL := req.Options.Get(OptionMUDURL4)
+	if len(mudURL) == 0 || resp.MessageType() != dhcpv4.MessageTypeAck || resp.YourIPAddr.IsUnspecified() {
+		return resp, false
+	}
+	hwaddr := req.ClientHWAddr.String()
+	p.announce(hwaddr, Announcement{MUDURL: string(mudURL), IP: resp.YourIPAddr.To4(), HWAddr: hwaddr})
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the mud plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		return resp, false
+	}
+	opt := msg.GetOneOption(dhcpv6.OptionMUDUrlV6)
+	clientID := msg.Options.ClientID()
+	reply, ok := resp.(*dhcpv6.Message)
+	if opt == nil || clientID == nil || !ok || reply.Type() != dhcpv6.MessageTypeReply {
+		return resp, false
+	}
+	for _, iana := range reply.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			if addr.ValidLifetime == 0 {
+				continue
+			}
+			duid := hex.EncodeToString(clientID.ToBytes())
+			p.announce(duid, Announcement{MUDURL: string(opt.ToBytes()), IP: addr.IPv6Addr, DUID: duid})
+			return resp, false
+		}
+	}
+	return resp, false
+}
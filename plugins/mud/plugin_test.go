@@ -0,0 +1,207 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mud
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/plugins/webhook"
+)
+
+// controller records the announcements it gets, failing the first posts as
+// told
+type controller struct {
+	sync.Mutex
+	announcements []Announcement
+	failures      int
+	posts         int
+	badSigs       int
+}
+
+func (c *controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	c.posts++
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(body)
+	if r.Header.Get(webhook.SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		c.badSigs++
+	}
+	var a Announcement
+	if err := json.Unmarshal(body, &a); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.announcements = append(c.announcements, a)
+}
+
+// newTestState returns a plugin state posting to a test controller, whose
+// announcements are delivered by flush
+func newTestState(t *testing.T, c *controller, args ...string) *PluginState {
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	p, err := parseArgs(append([]string{"url=" + srv.URL, "secret=s3cr3t"}, args...)...)
+	require.NoError(t, err)
+	return p
+}
+
+func flush(p *PluginState) {
+	for {
+		select {
+		case a := <-p.queue:
+			p.deliver(a)
+		default:
+			return
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs()
+	require.NoError(t, err)
+	assert.Empty(t, p.urls)
+
+	p, err = parseArgs("url=https://mud.example.org/dhcp", "retries=0", "timeout=1s")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://mud.example.org/dhcp"}, p.urls)
+	assert.Equal(t, 0, p.retries)
+	assert.Equal(t, time.Second, p.client.Timeout)
+
+	for _, args := range [][]string{
+		{"secret=s3cr3t"},
+		{"url=ftp://mud.example.org"},
+		{"url=https://mud.example.org", "retries=-1"},
+		{"url=https://mud.example.org", "timeout=0s"},
+		{"https://mud.example.org"},
+		{"manager=https://mud.example.org"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func ack4(t *testing.T, mudURL string, ip net.IP) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(OptionMUDURL4, []byte(mudURL))),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(ip),
+	)
+	require.NoError(t, err)
+	return req, resp
+}
+
+func TestHandler4(t *testing.T) {
+	c := &controller{}
+	p := newTestState(t, c)
+
+	req, resp := ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 100))
+	result, stop := p.Handler4(req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stop)
+	// Announced once
+	p.Handler4(req, resp)
+	flush(p)
+	require.Len(t, c.announcements, 1)
+	assert.Zero(t, c.badSigs)
+	assert.Equal(t, "https://things.example.com/lightbulb2000", c.announcements[0].MUDURL)
+	assert.Equal(t, "00:01:02:03:04:05", c.announcements[0].HWAddr)
+	assert.True(t, net.IPv4(192, 0, 2, 100).Equal(c.announcements[0].IP))
+
+	// Again with a new address
+	req, resp = ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 101))
+	p.Handler4(req, resp)
+	// Not an https URL
+	req, resp = ack4(t, "http://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 101))
+	p.Handler4(req, resp)
+	flush(p)
+	assert.Len(t, c.announcements, 2)
+}
+
+func TestHandler6(t *testing.T) {
+	c := &controller{}
+	p := newTestState(t, c)
+
+	duid := &dhcpv6.Duid{Type: dhcpv6.DUID_LL, HwType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(*duid))
+	req.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionMUDUrlV6, OptionData: []byte("https://things.example.com/thermostat")})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	ip := net.ParseIP("2001:db8::100")
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: [4]byte{1},
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: ip, PreferredLifetime: time.Hour, ValidLifetime: time.Hour},
+		}},
+	})
+	p.Handler6(req, resp)
+	flush(p)
+	require.Len(t, c.announcements, 1)
+	assert.Equal(t, "https://things.example.com/thermostat", c.announcements[0].MUDURL)
+	assert.Equal(t, hex.EncodeToString(duid.ToBytes()), c.announcements[0].DUID)
+	assert.True(t, ip.Equal(c.announcements[0].IP))
+}
+
+func TestStopHealth(t *testing.T) {
+	retryDelay = time.Millisecond
+	c := &controller{failures: 1}
+	p := newTestState(t, c, "retries=0")
+	instances.list = []*PluginState{p}
+
+	// Unhealthy until a post succeeds
+	req, resp := ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 100))
+	p.Handler4(req, resp)
+	flush(p)
+	assert.Error(t, health())
+	req, resp = ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 101))
+	p.Handler4(req, resp)
+	flush(p)
+	assert.NoError(t, health())
+
+	// The pending posts are sent when stopping, the next ones dropped
+	req, resp = ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 102))
+	p.Handler4(req, resp)
+	p.done = make(chan struct{})
+	go func() {
+		for a := range p.queue {
+			p.deliver(a)
+		}
+		close(p.done)
+	}()
+	require.NoError(t, stop())
+	req, resp = ack4(t, "https://things.example.com/lightbulb2000", net.IPv4(192, 0, 2, 103))
+	p.Handler4(req, resp)
+	assert.Equal(t, 3, c.posts)
+	assert.Len(t, c.announcements, 2)
+}
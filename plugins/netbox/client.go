@@ -0,0 +1,139 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package netbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ipAddress is an IP address object of NetBox
+type ipAddress struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+	Status  struct {
+		Value string `json:"value"`
+	} `json:"status"`
+}
+
+// ip returns the address, without its prefix length
+func (a *ipAddress) ip() (net.IP, error) {
+	ip, _, err := net.ParseCIDR(a.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q for IP address %d", a.Address, a.ID)
+	}
+	return ip.To4(), nil
+}
+
+// newIPAddress is the body of the requests creating IP addresses
+type newIPAddress struct {
+	Status      string `json:"status"`
+	Description string `json:"description"`
+	DNSName     string `json:"dns_name,omitempty"`
+}
+
+// client is a minimal client of the NetBox REST API
+type client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func newClient(u, token string, timeout time.Duration) *client {
+	return &client{
+		url:   strings.TrimSuffix(u, "/"),
+		token: token,
+		http:  &http.Client{Timeout: timeout},
+	}
+}
+
+// do sends a request to the API, and decodes the response into out if not nil
+func (c *client) do(method, path string, query url.Values, body, out interface{}) error {
+	u := c.url + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: unexpected status %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// prefix returns the prefix, in CIDR notation, of the prefix object id
+func (c *client) prefix(id int) (string, error) {
+	var p struct {
+		Prefix string `json:"prefix"`
+	}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/api/ipam/prefixes/%d/", id), nil, nil, &p); err != nil {
+		return "", err
+	}
+	return p.Prefix, nil
+}
+
+// find returns the IP address within parent assigned to a client, nil if
+// there is none
+func (c *client) find(parent, mac string) (*ipAddress, error) {
+	var l struct {
+		Results []ipAddress `json:"results"`
+	}
+	query := url.Values{"parent": {parent}, "description": {mac}, "family": {"4"}}
+	if err := c.do(http.MethodGet, "/api/ipam/ip-addresses/", query, nil, &l); err != nil {
+		return nil, err
+	}
+	if len(l.Results) == 0 {
+		return nil, nil
+	}
+	return &l.Results[0], nil
+}
+
+// create assigns the next available IP address of the prefix object id
+func (c *client) create(id int, a *newIPAddress) (*ipAddress, error) {
+	var ip ipAddress
+	if err := c.do(http.MethodPost, fmt.Sprintf("/api/ipam/prefixes/%d/available-ips/", id), nil, a, &ip); err != nil {
+		return nil, err
+	}
+	return &ip, nil
+}
+
+// delete deletes the IP address object id
+func (c *client) delete(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/ipam/ip-addresses/%d/", id), nil, nil, nil)
+}
@@ -0,0 +1,290 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package netbox implements a plugin allocating DHCPv4 addresses in NetBox,
+// so NetBox remains the source of truth of the address assignments.
+//
+// The address of a client is the IP address, within the configured NetBox
+// prefix, whose description is the MAC address of the client (eg.
+// 00:11:22:33:44:55). When there is none, the plugin assigns the next
+// available address of the prefix, with the "dhcp" status, the MAC address as
+// description, and the host name of the client as DNS name. Addresses can be reserved for clients by creating
+// them in NetBox with the MAC address as description.
+//
+// The addresses created by the plugin are deleted when the clients release
+// them, or when their leases expire. The leases are only kept in memory: after
+// a restart, the addresses are only deleted when the clients release them
+// again.
+//
+// Arguments are key=value pairs:
+// - url=<URL>: the URL of NetBox, required
+// - prefix=<id>: the ID of the NetBox prefix to allocate addresses from,
+// required
+// - token=<token> or token-file=<file>: the API token, or a file holding it
+// - leasetime=<duration>: the lease duration, 1h by default
+// - status=<status>: the status of the addresses created by the plugin,
+// dhcp by default
+// - timeout=<duration>: the timeout of the API requests, 5s by default
+//
+// server4:
+//   plugins:
+//     - netbox: url=https://netbox.example.org prefix=42 token-file=/etc/coredhcp/netbox.token
+//
+// Like the range plugin, it must come after the plugins serving static bindings.
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/netbox")
+
+// Plugin wraps the netbox plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "netbox",
+	Setup4: setup4,
+	Start:  start,
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// refreshInterval is how long the address of a client is used before being
+// looked up in NetBox again, to follow the changes made there
+const refreshInterval = time.Minute
+
+// sweepInterval is how often the expired leases are released
+const sweepInterval = time.Minute
+
+// binding is the address of a client, as found in NetBox
+type binding struct {
+	ip net.IP
+	// id is the ID of the IP address object
+	id int
+	// owned is set for the addresses created by the plugin, which it
+	// deletes when they are released
+	owned   bool
+	expires time.Time
+	checked time.Time
+}
+
+// PluginState holds the state of an instance of the netbox plugin
+type PluginState struct {
+	// The lock serializes the requests to NetBox, so concurrent requests of a
+	// client can't allocate two addresses
+	sync.Mutex
+	client    *client
+	prefixID  int
+	prefix    string
+	leaseTime time.Duration
+	status    string
+	bindings  map[string]*binding
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	var (
+		u, token string
+		timeout  = 5 * time.Second
+		err      error
+	)
+	p := PluginState{
+		leaseTime: time.Hour,
+		status:    "dhcp",
+		bindings:  make(map[string]*binding),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "url":
+			u = value
+		case "prefix":
+			if p.prefixID, err = strconv.Atoi(value); err != nil || p.prefixID <= 0 {
+				return nil, fmt.Errorf("invalid prefix ID %q", value)
+			}
+		case "token":
+			token = value
+		case "token-file":
+			data, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		case "leasetime":
+			if p.leaseTime, err = time.ParseDuration(value); err != nil || p.leaseTime < time.Second {
+				return nil, fmt.Errorf("invalid lease time %q", value)
+			}
+		case "status":
+			if value == "" {
+				return nil, errors.New("status cannot be empty")
+			}
+			p.status = value
+		case "timeout":
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return nil, fmt.Errorf("need the http(s) URL of NetBox, got %q", u)
+	}
+	if p.prefixID == 0 {
+		return nil, errors.New("need the ID of the prefix to allocate addresses from")
+	}
+	p.client = newClient(u, token, timeout)
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	if p.prefix, err = p.client.prefix(p.prefixID); err != nil {
+		return nil, fmt.Errorf("could not get prefix %d: %w", p.prefixID, err)
+	}
+	if _, n, err := net.ParseCIDR(p.prefix); err != nil || n.IP.To4() == nil {
+		return nil, fmt.Errorf("prefix %d is not an IPv4 prefix: %q", p.prefixID, p.prefix)
+	}
+	log.Printf("allocating addresses from NetBox prefix %s", p.prefix)
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	return p.Handler4, nil
+}
+
+// start starts releasing the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.sweeper(ctx)
+	}
+	return nil
+}
+
+// sweeper releases the expired leases periodically, until ctx is done
+func (p *PluginState) sweeper(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lookup returns the address of a client, from NetBox when it wasn't checked
+// recently, assigning one if needed. The caller must hold the lock
+func (p *PluginState) lookup(req *dhcpv4.DHCPv4, now time.Time) (*binding, error) {
+	mac := req.ClientHWAddr.String()
+	b := p.bindings[mac]
+	if b != nil && now.Sub(b.checked) < refreshInterval {
+		return b, nil
+	}
+	a, err := p.client.find(p.prefix, mac)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		a, err = p.client.create(p.prefixID, &newIPAddress{
+			Status:      p.status,
+			Description: mac,
+			DNSName:     fqdn.HostName4(req),
+		})
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("assigned %s to MAC %s in NetBox", a.Address, mac)
+		a.Status.Value = p.status
+	}
+	ip, err := a.ip()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil || b.id != a.ID {
+		b = &binding{}
+	}
+	b.ip, b.id, b.owned, b.checked = ip, a.ID, a.Status.Value == p.status, now
+	p.bindings[mac] = b
+	return b, nil
+}
+
+// release deletes the address of a client created by the plugin. The caller
+// must hold the lock
+func (p *PluginState) release(mac string) {
+	b, ok := p.bindings[mac]
+	if !ok {
+		return
+	}
+	delete(p.bindings, mac)
+	if !b.owned {
+		return
+	}
+	if err := p.client.delete(b.id); err != nil {
+		log.Errorf("Could not delete %s of MAC %s from NetBox: %v", b.ip, mac, err)
+		return
+	}
+	log.Printf("deleted %s of MAC %s from NetBox", b.ip, mac)
+}
+
+// sweep releases the expired leases
+func (p *PluginState) sweep(now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	for mac, b := range p.bindings {
+		if b.expires.Before(now) {
+			p.release(mac)
+		}
+	}
+}
+
+// Handler4 handles DHCPv4 packets for the netbox plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.Lock()
+	defer p.Unlock()
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	case dhcpv4.MessageTypeRelease:
+		p.release(req.ClientHWAddr.String())
+		return resp, false
+	default:
+		return resp, false
+	}
+	now := time.Now()
+	b, err := p.lookup(req, now)
+	if err != nil {
+		log.Errorf("Could not get an address for MAC %s from NetBox: %v", req.ClientHWAddr, err)
+		return nil, true
+	}
+	b.expires = now.Add(p.leaseTime)
+	resp.YourIPAddr = b.ip
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.leaseTime.Round(time.Second)))
+	log.Debugf("found IP address %s for MAC %s", b.ip, req.ClientHWAddr)
+	return resp, false
+}
@@ -0,0 +1,180 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNetBox implements the parts of the NetBox API used by the plugin, for
+// prefix 1 (10.0.0.0/29)
+type fakeNetBox struct {
+	sync.Mutex
+	t         *testing.T
+	addresses map[int]*storedAddress
+	nextID    int
+}
+
+type storedAddress struct {
+	ip          net.IP
+	status      string
+	description string
+	dnsName     string
+}
+
+func encode(id int, a *storedAddress) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       id,
+		"address":  a.ip.String() + "/29",
+		"status":   map[string]string{"value": a.status},
+		"dns_name": a.dnsName,
+	}
+}
+
+func (f *fakeNetBox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	assert.Equal(f.t, "Token s3cr3t", r.Header.Get("Authorization"))
+	var id int
+	_, err := fmt.Sscanf(r.URL.Path, "/api/ipam/ip-addresses/%d/", &id)
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/prefixes/1/":
+		fmt.Fprint(w, `{"id":1,"prefix":"10.0.0.0/29"}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/ipam/ip-addresses/":
+		assert.Equal(f.t, "10.0.0.0/29", r.URL.Query().Get("parent"))
+		results := []interface{}{}
+		for id, a := range f.addresses {
+			if a.description == r.URL.Query().Get("description") {
+				results = append(results, encode(id, a))
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"count": len(results), "results": results})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/ipam/prefixes/1/available-ips/":
+		var body newIPAddress
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&body))
+		for i := 1; i < 7; i++ {
+			ip := net.IPv4(10, 0, 0, byte(i)).To4()
+			used := false
+			for _, a := range f.addresses {
+				used = used || a.ip.Equal(ip)
+			}
+			if !used {
+				f.nextID++
+				a := &storedAddress{ip: ip, status: body.Status, description: body.Description, dnsName: body.DNSName}
+				f.addresses[f.nextID] = a
+				w.WriteHeader(http.StatusCreated)
+				_ = json.NewEncoder(w).Encode(encode(f.nextID, a))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"detail":"An insufficient number of IP addresses are available within prefix 10.0.0.0/29 (1 requested)"}`)
+	case r.Method == http.MethodDelete && err == nil:
+		if _, ok := f.addresses[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.addresses, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestState(t *testing.T) (*PluginState, *fakeNetBox) {
+	f := &fakeNetBox{t: t, addresses: make(map[int]*storedAddress)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	p, err := parseArgs("url="+srv.URL, "prefix=1", "token=s3cr3t", "leasetime=10m")
+	require.NoError(t, err)
+	p.prefix, err = p.client.prefix(p.prefixID)
+	require.NoError(t, err)
+	return p, f
+}
+
+func request(t *testing.T, mac net.HardwareAddr, mt dhcpv4.MessageType, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(mt)}, modifiers...)...)
+	require.NoError(t, err)
+	return req
+}
+
+func handle(t *testing.T, p *PluginState, req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	return p.Handler4(req, stub)
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"prefix=1"},
+		{"url=netbox.example.org", "prefix=1"},
+		{"url=https://netbox.example.org"},
+		{"url=https://netbox.example.org", "prefix=-1"},
+		{"url=https://netbox.example.org", "prefix=1", "leasetime=0"},
+		{"url=https://netbox.example.org", "prefix=1", "token-file=/nonexistent"},
+		{"url=https://netbox.example.org", "prefix=1", "vrf=1"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	p, f := newTestState(t)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	resp, stop := handle(t, p, request(t, mac, dhcpv4.MessageTypeDiscover, dhcpv4.WithOption(dhcpv4.OptHostName("node-1"))))
+	assert.False(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), resp.YourIPAddr)
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+	require.Len(t, f.addresses, 1)
+	assert.Equal(t, &storedAddress{ip: net.IPv4(10, 0, 0, 1).To4(), status: "dhcp", description: mac.String(), dnsName: "node-1"}, f.addresses[1])
+
+	// The client keeps its address, even when looked up again
+	p.bindings[mac.String()].checked = time.Time{}
+	resp, _ = handle(t, p, request(t, mac, dhcpv4.MessageTypeRequest))
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), resp.YourIPAddr)
+	assert.Len(t, f.addresses, 1)
+
+	// Released and expired addresses are deleted
+	handle(t, p, request(t, mac, dhcpv4.MessageTypeRelease))
+	assert.Empty(t, f.addresses)
+	handle(t, p, request(t, mac, dhcpv4.MessageTypeDiscover))
+	assert.Len(t, f.addresses, 1)
+	p.sweep(time.Now().Add(time.Hour))
+	assert.Empty(t, f.addresses)
+}
+
+func TestReserved(t *testing.T) {
+	p, f := newTestState(t)
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	f.addresses[100] = &storedAddress{ip: net.IPv4(10, 0, 0, 5).To4(), status: "reserved", description: mac.String()}
+	resp, _ := handle(t, p, request(t, mac, dhcpv4.MessageTypeDiscover))
+	assert.Equal(t, net.IPv4(10, 0, 0, 5).To4(), resp.YourIPAddr)
+
+	// Addresses not created by the plugin are kept
+	handle(t, p, request(t, mac, dhcpv4.MessageTypeRelease))
+	assert.Len(t, f.addresses, 1)
+}
+
+func TestExhausted(t *testing.T) {
+	p, f := newTestState(t)
+	for i := 1; i < 7; i++ {
+		f.addresses[100+i] = &storedAddress{ip: net.IPv4(10, 0, 0, byte(i)).To4(), status: "active"}
+	}
+	resp, stop := handle(t, p, request(t, net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, dhcpv4.MessageTypeDiscover))
+	assert.True(t, stop)
+	assert.Nil(t, resp)
+}
@@ -25,6 +25,7 @@ var Plugin = plugins.Plugin{
 
 var (
 	netmask net.IPMask
+	options handler.StaticOptions4
 )
 
 func setup4(args ...string) (handler.Handler4, error) {
@@ -44,13 +45,14 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if !checkValidNetmask(netmask) {
 		return nil, errors.New("netmask is not valid, got: " + args[1])
 	}
+	options.Add(dhcpv4.OptSubnetMask(netmask))
 	log.Printf("loaded client netmask")
 	return Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the netmask plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptSubnetMask(netmask))
+	options.Splice(req, resp)
 	return resp, false
 }
 
@@ -0,0 +1,130 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ntp implements a plugin sending NTP servers to clients.
+//
+// Servers are given as addresses or domain names. In DHCPv6 they are sent in
+// the NTP Server option (RFC5908), and the addresses are also sent in the
+// SNTP Servers option (RFC4075) to the clients requesting it. In DHCPv4 only
+// addresses can be sent, in the NTP Servers option (42).
+//
+// server6:
+//   plugins:
+//     - ntp: 2001:db8::123 ntp.example.org
+package ntp
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+var log = logger.GetLogger("plugins/ntp")
+
+// Plugin wraps the NTP plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "ntp",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// Sub-options of the DHCPv6 NTP Server option (RFC5908)
+const (
+	ntpSubOptionSrvAddr = 1
+	ntpSubOptionSrvFQDN = 3
+)
+
+var (
+	ntpServers6 []net.IP
+	ntpNames6   []string
+	ntpServers4 []net.IP
+)
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one NTP server")
+	}
+	for _, arg := range args {
+		if ip := net.ParseIP(arg); ip != nil {
+			if ip.To4() != nil {
+				return nil, errors.New("expected an IPv6 NTP server address, got: " + arg)
+			}
+			ntpServers6 = append(ntpServers6, ip)
+			continue
+		}
+		if strings.ContainsAny(arg, ":/ ") {
+			return nil, errors.New("expected an NTP server address or name, got: " + arg)
+		}
+		ntpNames6 = append(ntpNames6, strings.TrimSuffix(arg, "."))
+	}
+	log.Infof("loaded %d NTP servers.", len(ntpServers6)+len(ntpNames6))
+	return Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one NTP server")
+	}
+	for _, arg := range args {
+		server := net.ParseIP(arg)
+		if server.To4() == nil {
+			return nil, errors.New("expected an NTP server address, got: " + arg)
+		}
+		ntpServers4 = append(ntpServers4, server)
+	}
+	log.Infof("loaded %d NTP servers.", len(ntpServers4))
+	return Handler4, nil
+}
+
+// ntpOption6 builds the NTP Server option, with one sub-option per server
+func ntpOption6() dhcpv6.Option {
+	var data []byte
+	subopt := func(code uint16, value []byte) {
+		data = append(data, byte(code>>8), byte(code), byte(len(value)>>8), byte(len(value)))
+		data = append(data, value...)
+	}
+	for _, ip := range ntpServers6 {
+		subopt(ntpSubOptionSrvAddr, ip.To16())
+	}
+	for _, name := range ntpNames6 {
+		subopt(ntpSubOptionSrvFQDN, (&rfc1035label.Labels{Labels: []string{name}}).ToBytes())
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionNTPServer, OptionData: data}
+}
+
+// Handler6 handles DHCPv6 packets for the ntp plugin
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+
+	if decap.IsOptionRequested(dhcpv6.OptionNTPServer) {
+		resp.UpdateOption(ntpOption6())
+	}
+	if len(ntpServers6) > 0 && decap.IsOptionRequested(dhcpv6.OptionSNTPServerList) {
+		var data []byte
+		for _, ip := range ntpServers6 {
+			data = append(data, ip.To16()...)
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionSNTPServerList, OptionData: data})
+	}
+	return resp, false
+}
+
+// Handler4 handles DHCPv4 packets for the ntp plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionNTPServers) {
+		resp.Options.Update(dhcpv4.OptNTPServers(ntpServers4...))
+	}
+	return resp, false
+}
@@ -0,0 +1,94 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ntp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup6(t *testing.T) {
+	ntpServers6, ntpNames6 = nil, nil
+	_, err := setup6("2001:db8::123", "ntp.example.org.")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::123")}, ntpServers6)
+	assert.Equal(t, []string{"ntp.example.org"}, ntpNames6)
+
+	for _, arg := range []string{"192.0.2.1", "2001:db8::/64"} {
+		_, err := setup6(arg)
+		assert.Error(t, err, arg)
+	}
+}
+
+func TestAddServer6(t *testing.T) {
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionNTPServer, dhcpv6.OptionSNTPServerList))
+
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	ntpServers6 = []net.IP{net.ParseIP("2001:db8::123")}
+	ntpNames6 = []string{"ntp.example.org"}
+
+	resp, stop := Handler6(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+
+	ntp := resp.GetOneOption(dhcpv6.OptionNTPServer)
+	require.NotNil(t, ntp)
+	want := []byte{0, 1, 0, 16}
+	want = append(want, net.ParseIP("2001:db8::123")...)
+	want = append(want, 0, 3, 0, 17)
+	want = append(want, "\x03ntp\x07example\x03org\x00"...)
+	assert.Equal(t, want, ntp.ToBytes())
+
+	sntp := resp.GetOneOption(dhcpv6.OptionSNTPServerList)
+	require.NotNil(t, sntp)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::123")), sntp.ToBytes())
+}
+
+func TestNotRequested6(t *testing.T) {
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption())
+
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	ntpServers6 = []net.IP{net.ParseIP("2001:db8::123")}
+
+	resp, stop := Handler6(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionNTPServer))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSNTPServerList))
+}
+
+func TestAddServer4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionNTPServers))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	ntpServers4 = []net.IP{net.IPv4(192, 0, 2, 123)}
+
+	resp, stop := Handler4(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	servers := resp.NTPServers()
+	require.Len(t, servers, 1)
+	assert.True(t, servers[0].Equal(ntpServers4[0]))
+}
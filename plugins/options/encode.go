@@ -0,0 +1,100 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package options
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// Encode encodes a `<type>:<value>` option value, see the package
+// documentation for the types. Addresses are IPv6 when v6 is set, IPv4
+// otherwise
+func Encode(typed string, v6 bool) ([]byte, error) {
+	sep := strings.IndexByte(typed, ':')
+	if sep < 0 {
+		return nil, fmt.Errorf("expected <type>:<value>")
+	}
+	kind, value := typed[:sep], typed[sep+1:]
+	switch kind {
+	case "ip":
+		return encodeIP(value, v6)
+	case "ip-list":
+		var buf []byte
+		for _, s := range strings.Split(value, ",") {
+			ip, err := encodeIP(s, v6)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, ip...)
+		}
+		return buf, nil
+	case "string":
+		return []byte(value), nil
+	case "uint8", "uint16", "uint32":
+		bits, _ := strconv.Atoi(kind[len("uint"):])
+		n, err := strconv.ParseUint(value, 0, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", kind, value)
+		}
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, uint32(n))
+		return buf[4-bits/8:], nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", value)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case "hex":
+		buf, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex %q: %v", value, err)
+		}
+		return buf, nil
+	case "fqdn":
+		var names []string
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSuffix(name, ".")
+			if name == "" {
+				return nil, fmt.Errorf("empty domain name in %q", value)
+			}
+			for _, label := range strings.Split(name, ".") {
+				if label == "" || len(label) > 63 {
+					return nil, fmt.Errorf("invalid domain name %q", name)
+				}
+			}
+			names = append(names, name)
+		}
+		return (&rfc1035label.Labels{Labels: names}).ToBytes(), nil
+	}
+	return nil, fmt.Errorf("unknown type %q", kind)
+}
+
+func encodeIP(s string, v6 bool) ([]byte, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if v6 {
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("expected an IPv6 address, got %s", s)
+		}
+		return ip.To16(), nil
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("expected an IPv4 address, got %s", s)
+	}
+	return ip.To4(), nil
+}
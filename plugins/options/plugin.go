@@ -0,0 +1,146 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package options implements a plugin setting arbitrary options in the
+// responses, for the options that have no plugin of their own.
+//
+// Each argument is an option, in the form `<code>=<type>:<value>`, where type
+// is one of:
+// - ip: an address, IPv4 for DHCPv4 and IPv6 for DHCPv6
+// - ip-list: comma-separated addresses
+// - string: the value as is
+// - uint8, uint16, uint32: a big-endian number
+// - bool: true or false, as a single byte
+// - hex: bytes in hex, optionally separated by colons
+// - fqdn: comma-separated domain names, in the DNS wire format (RFC1035)
+//
+// server4:
+//   plugins:
+//     - options: 42=ip-list:192.0.2.1,192.0.2.2 252=string:http://wpad.example.org/wpad.dat
+//
+// An option can also be given as `<code>=suppress`, to remove it from the
+// responses, eg. when set by an earlier plugin or at a broader scope.
+//
+// The options given first are global, set for all the clients. They are
+// followed by scopes, each one made of a scope and its options:
+// - subnet:<prefix>: the clients getting an address in the prefix, or
+// renewing one, in DHCPv6 the clients whose link is in the prefix when they
+// get no address (see relay.LinkAddr6)
+// - a class (see the class package), eg. vendor:MSFT
+// - host:<hardware address>: a single client, in DHCPv6 the client whose
+// hardware address is found by the duid package
+//
+// server4:
+//   plugins:
+//     - options: 42=ip:192.0.2.1 subnet:192.0.2.128/25 42=ip:192.0.2.129 vendor:MSFT 252=suppress host:00:11:22:33:44:55 42=ip:192.0.2.10
+//
+// The options are resolved with an explicit precedence, whatever the order of
+// the arguments: global, then subnet, then class, then host, the option of
+// the narrowest matching scope, including a suppression, winning. Within a
+// level, the last matching scope wins. The resolved options are set in the
+// responses, replacing any option with the same code set by an earlier
+// plugin, so the plugin must come after the plugins setting the options it
+// overrides or suppresses.
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/options")
+
+// Plugin wraps the options plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "options",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// Parse parses an option given as `<code>=<type>:<value>`, and returns its
+// code and encoded value. It is also used by plugins building encapsulated
+// options, whose sub-option codes have the same range as DHCPv4 options
+func Parse(arg string, v6 bool) (uint64, []byte, error) {
+	sep := strings.IndexByte(arg, '=')
+	if sep < 0 {
+		return 0, nil, fmt.Errorf("invalid option %q, expected <code>=<type>:<value>", arg)
+	}
+	code, err := parseCode(arg[:sep], v6)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid option code in %q", arg)
+	}
+	value, err := Encode(arg[sep+1:], v6)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid option %q: %v", arg, err)
+	}
+	return code, value, nil
+}
+
+// The largest usable option codes, 255 being the DHCPv4 End option
+const (
+	maxCode4 = 254
+	maxCode6 = 65535
+)
+
+func parseCode(s string, v6 bool) (uint64, error) {
+	maxCode := uint64(maxCode4)
+	if v6 {
+		maxCode = maxCode6
+	}
+	code, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || code == 0 || code > maxCode {
+		return 0, fmt.Errorf("invalid option code %q", s)
+	}
+	return code, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	scopes, n, err := parseArgs(false, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded %d DHCPv4 options in %d scopes", n, len(scopes))
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		for _, s := range resolve(scopes, func(sc *scope) bool { return sc.match4(req, resp) }) {
+			code := dhcpv4.GenericOptionCode(s.code)
+			if s.suppress {
+				delete(resp.Options, code.Code())
+				continue
+			}
+			resp.UpdateOption(dhcpv4.OptGeneric(code, s.value))
+		}
+		return resp, false
+	}, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	scopes, n, err := parseArgs(true, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded %d DHCPv6 options in %d scopes", n, len(scopes))
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		msg, err := resp.GetInnerMessage()
+		if err != nil {
+			log.Errorf("BUG: could not decapsulate: %v", err)
+			return resp, false
+		}
+		for _, s := range resolve(scopes, func(sc *scope) bool { return sc.match6(req, msg) }) {
+			code := dhcpv6.OptionCode(s.code)
+			if s.suppress {
+				msg.Options.Del(code)
+				continue
+			}
+			msg.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: code, OptionData: s.value})
+		}
+		return resp, false
+	}, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package options
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	for typed, want := range map[string][]byte{
+		"ip:192.0.2.1":              {192, 0, 2, 1},
+		"ip-list:192.0.2.1,1.2.3.4": {192, 0, 2, 1, 1, 2, 3, 4},
+		"string:hello":              []byte("hello"),
+		"uint8:200":                 {200},
+		"uint16:0x1234":             {0x12, 0x34},
+		"uint32:3600":               {0, 0, 0x0e, 0x10},
+		"bool:true":                 {1},
+		"bool:false":                {0},
+		"hex:01:02:0a":              {1, 2, 10},
+		"hex:deadbeef":              {0xde, 0xad, 0xbe, 0xef},
+		"fqdn:example.org.,a.b":     []byte("\x07example\x03org\x00\x01a\x01b\x00"),
+	} {
+		got, err := Encode(typed, false)
+		require.NoError(t, err, typed)
+		assert.Equal(t, want, got, typed)
+	}
+
+	got, err := Encode("ip:2001:db8::1", true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::1")), got)
+
+	for _, typed := range []string{
+		"192.0.2.1",
+		"ip:2001:db8::1",
+		"ip-list:192.0.2.1,",
+		"uint8:256",
+		"uint16:-1",
+		"bool:maybe",
+		"hex:0g",
+		"fqdn:a..b",
+		"float:1.0",
+	} {
+		_, err := Encode(typed, false)
+		assert.Error(t, err, typed)
+	}
+	_, err = Encode("ip:192.0.2.1", true)
+	assert.Error(t, err)
+}
+
+func TestSetup(t *testing.T) {
+	for _, arg := range []string{"0=uint8:1", "255=uint8:1", "42", "x=uint8:1"} {
+		_, err := setup4(arg)
+		assert.Error(t, err, arg)
+	}
+	_, err := setup6("65536=uint8:1")
+	assert.Error(t, err)
+	_, err = setup6()
+	assert.Error(t, err)
+}
+
+func TestHandler4(t *testing.T) {
+	handler, err := setup4("42=ip-list:192.0.2.1,192.0.2.2", "252=string:http://wpad.example.org/wpad.dat")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := handler(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 1).To4(), net.IPv4(192, 0, 2, 2).To4()}, resp.NTPServers())
+	assert.Equal(t, []byte("http://wpad.example.org/wpad.dat"), resp.Options.Get(dhcpv4.GenericOptionCode(252)))
+}
+
+func TestHandler6(t *testing.T) {
+	handler, err := setup6("82=uint32:3600")
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	resp, stop := handler(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	opt := resp.GetOneOption(dhcpv6.OptionSolMaxRT)
+	require.NotNil(t, opt)
+	assert.Equal(t, []byte{0, 0, 0x0e, 0x10}, opt.ToBytes())
+}
+
+func TestParseArgs(t *testing.T) {
+	scopes, n, err := parseArgs(false, "42=ip:192.0.2.1", "subnet:192.0.2.128/25", "42=ip:192.0.2.129", "vendor:MSFT", "252=suppress", "host:00:11:22:33:44:55", "42=suppress", "3=ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.Len(t, scopes, 4)
+	assert.Equal(t, []string{"global", "subnet:192.0.2.128/25", "vendor:MSFT", "host:00:11:22:33:44:55"},
+		[]string{scopes[0].String(), scopes[1].String(), scopes[2].String(), scopes[3].String()})
+	assert.Equal(t, []setting{{code: 252, suppress: true}}, scopes[2].settings)
+
+	for _, args := range [][]string{
+		{"vendor:MSFT"},
+		{"42=ip:192.0.2.1", "vendor:MSFT"},
+		{"subnet:192.0.2.0", "42=ip:192.0.2.1"},
+		{"host:00:11", "42=ip:192.0.2.1"},
+		{"color:blue", "42=ip:192.0.2.1"},
+		{"0=suppress"},
+	} {
+		_, _, err := parseArgs(false, args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestPrecedence4(t *testing.T) {
+	// Given from the narrowest scope, to the broadest one
+	handler, err := setup4(
+		"host:aa:bb:cc:dd:ee:ff", "42=ip:192.0.2.10",
+		"vendor:MSFT", "42=ip:192.0.2.20", "252=suppress",
+		"subnet:192.0.2.128/25", "42=ip:192.0.2.129", "252=string:http://wpad.example.org/wpad.dat",
+	)
+	require.NoError(t, err)
+
+	exchange := func(mac net.HardwareAddr, vendor string, ip net.IP) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendor)))
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithYourIP(ip),
+			// Set by an earlier plugin
+			dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(252), []byte("earlier"))),
+		)
+		require.NoError(t, err)
+		resp, _ := handler(req, stub)
+		return resp
+	}
+	inSubnet := net.IPv4(192, 0, 2, 200)
+	other := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+
+	resp := exchange(other, "", net.IPv4(192, 0, 2, 100))
+	assert.Empty(t, resp.NTPServers())
+	assert.Equal(t, []byte("earlier"), resp.Options.Get(dhcpv4.GenericOptionCode(252)))
+
+	resp = exchange(other, "", inSubnet)
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 129).To4()}, resp.NTPServers())
+	assert.Equal(t, []byte("http://wpad.example.org/wpad.dat"), resp.Options.Get(dhcpv4.GenericOptionCode(252)))
+
+	resp = exchange(other, "MSFT 5.0", inSubnet)
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 20).To4()}, resp.NTPServers())
+	assert.False(t, resp.Options.Has(dhcpv4.GenericOptionCode(252)))
+
+	resp = exchange(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, "MSFT 5.0", inSubnet)
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 10).To4()}, resp.NTPServers())
+	assert.False(t, resp.Options.Has(dhcpv4.GenericOptionCode(252)))
+}
+
+func TestPrecedence6(t *testing.T) {
+	handler, err := setup6("82=uint32:3600", "subnet:2001:db8:1::/64", "82=suppress")
+	require.NoError(t, err)
+
+	exchange := func(ip net.IP) *dhcpv6.Message {
+		req, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		stub, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		stub.MessageType = dhcpv6.MessageTypeReply
+		stub.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: ip, ValidLifetime: time.Hour},
+		}}})
+		resp, _ := handler(req, stub)
+		return resp.(*dhcpv6.Message)
+	}
+	assert.NotNil(t, exchange(net.ParseIP("2001:db8::1")).GetOneOption(dhcpv6.OptionSolMaxRT))
+	assert.Nil(t, exchange(net.ParseIP("2001:db8:1::1")).GetOneOption(dhcpv6.OptionSolMaxRT))
+}
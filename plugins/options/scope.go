@@ -0,0 +1,196 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package options
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+)
+
+// The levels of the scopes, by increasing precedence
+const (
+	levelGlobal = iota
+	levelSubnet
+	levelClass
+	levelHost
+	levels
+)
+
+// suppress is the value of the options to remove from the responses
+const suppress = "suppress"
+
+// setting is an option of a scope, to set or to remove
+type setting struct {
+	code     uint64
+	value    []byte
+	suppress bool
+}
+
+// scope holds the options of the clients of a subnet, a class or a host, or
+// of all the clients
+type scope struct {
+	level    int
+	subnet   *net.IPNet
+	class    *class.Matcher
+	hwaddr   net.HardwareAddr
+	settings []setting
+}
+
+// parseArgs returns the scopes of the arguments, the global one first, and
+// how many options they hold
+func parseArgs(v6 bool, args ...string) ([]*scope, int, error) {
+	scopes := []*scope{{level: levelGlobal}}
+	n := 0
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			sc, err := parseScope(arg)
+			if err != nil {
+				return nil, 0, err
+			}
+			scopes = append(scopes, sc)
+			continue
+		}
+		var s setting
+		if arg[sep+1:] == suppress {
+			code, err := parseCode(arg[:sep], v6)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid option code in %q", arg)
+			}
+			s = setting{code: code, suppress: true}
+		} else {
+			code, value, err := Parse(arg, v6)
+			if err != nil {
+				return nil, 0, err
+			}
+			s = setting{code: code, value: value}
+		}
+		sc := scopes[len(scopes)-1]
+		sc.settings = append(sc.settings, s)
+		n++
+	}
+	for _, sc := range scopes[1:] {
+		if len(sc.settings) == 0 {
+			return nil, 0, fmt.Errorf("scope %s has no option", sc)
+		}
+	}
+	if n == 0 {
+		return nil, 0, errors.New("need at least one option")
+	}
+	return scopes, n, nil
+}
+
+// parseScope parses a subnet:<prefix>, host:<hardware address> or class scope
+func parseScope(arg string) (*scope, error) {
+	switch {
+	case strings.HasPrefix(arg, "subnet:"):
+		_, subnet, err := net.ParseCIDR(strings.TrimPrefix(arg, "subnet:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet in %q: %v", arg, err)
+		}
+		return &scope{level: levelSubnet, subnet: subnet}, nil
+	case strings.HasPrefix(arg, "host:"):
+		hwaddr, err := net.ParseMAC(strings.TrimPrefix(arg, "host:"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hardware address in %q: %v", arg, err)
+		}
+		return &scope{level: levelHost, hwaddr: hwaddr}, nil
+	}
+	m, err := class.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &scope{level: levelClass, class: m}, nil
+}
+
+// String returns the scope, as given in the arguments
+func (sc *scope) String() string {
+	switch sc.level {
+	case levelSubnet:
+		return "subnet:" + sc.subnet.String()
+	case levelClass:
+		return sc.class.String()
+	case levelHost:
+		return "host:" + sc.hwaddr.String()
+	}
+	return "global"
+}
+
+// match4 tells whether a DHCPv4 client is in the scope
+func (sc *scope) match4(req, resp *dhcpv4.DHCPv4) bool {
+	switch sc.level {
+	case levelSubnet:
+		ip := resp.YourIPAddr
+		if ip.IsUnspecified() {
+			ip = req.ClientIPAddr
+		}
+		return !ip.IsUnspecified() && sc.subnet.Contains(ip)
+	case levelClass:
+		return sc.class.Match4(req)
+	case levelHost:
+		return bytes.Equal(req.ClientHWAddr, sc.hwaddr)
+	}
+	return true
+}
+
+// match6 tells whether a DHCPv6 client is in the scope, given the response
+// built so far
+func (sc *scope) match6(req dhcpv6.DHCPv6, resp *dhcpv6.Message) bool {
+	switch sc.level {
+	case levelSubnet:
+		var assigned bool
+		for _, iana := range resp.Options.IANA() {
+			for _, addr := range iana.Options.Addresses() {
+				if sc.subnet.Contains(addr.IPv6Addr) {
+					return true
+				}
+				assigned = true
+			}
+		}
+		link := relay.LinkAddr6(req)
+		return !assigned && link != nil && sc.subnet.Contains(link)
+	case levelClass:
+		return sc.class.Match6(req)
+	case levelHost:
+		hwaddr, _ := duid.HWAddr(req)
+		return hwaddr != nil && bytes.Equal(hwaddr, sc.hwaddr)
+	}
+	return true
+}
+
+// resolve returns the options of a client, in the order they were first
+// given, each one from the scope with the highest precedence it matches
+func resolve(scopes []*scope, match func(*scope) bool) []setting {
+	var order []uint64
+	resolved := make(map[uint64]setting)
+	for level := levelGlobal; level < levels; level++ {
+		for _, sc := range scopes {
+			if sc.level != level || !match(sc) {
+				continue
+			}
+			for _, s := range sc.settings {
+				if _, ok := resolved[s.code]; !ok {
+					order = append(order, s.code)
+				}
+				resolved[s.code] = s
+			}
+		}
+	}
+	settings := make([]setting, 0, len(order))
+	for _, code := range order {
+		settings = append(settings, resolved[code])
+	}
+	return settings
+}
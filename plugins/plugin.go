@@ -5,7 +5,9 @@
 package plugins
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/coredhcp/coredhcp/config"
 	"github.com/coredhcp/coredhcp/handler"
@@ -17,10 +19,45 @@ var log = logger.GetLogger("plugins")
 // Plugin represents a plugin object.
 // Setup6 and Setup4 are the setup functions for DHCPv6 and DHCPv4 handlers
 // respectively. Both setup functions can be nil.
+// Setup6Ctx and Setup4Ctx set up handlers taking the context of the
+// requests, eg. to share data with the other plugins (see handler.Metadata).
+// They are used instead of Setup6 and Setup4 if set. The handlers get the
+// metrics of the plugin in their context, and are counted (see package
+// metrics).
+//
+// Setup6Config and Setup4Config set plugins up from a typed configuration
+// instead of arguments: NewConfig returns a pointer to a struct holding the
+// defaults, which the settings of the plugin are decoded into (see
+// config.go). They are used when the plugin is configured with a map, eg.
+// `script: {path: policy.star, timeout: 1s}`, or when the plugin has no other
+// setup function, its arguments being then key=value settings. Plugins set up
+// from arguments get the settings given as a map as key=value arguments.
+//
+// The other functions are optional lifecycle hooks, for all the instances of
+// the plugin (see lifecycle.go):
+// - Start is called once the server is set up, before it serves. The
+// background work of the plugin, eg. timers, stops when ctx is done
+// - Stop is called when the server stops, once it stopped handling requests,
+// eg. to flush pending work
+// - Reload is called on SIGHUP once for each instance, with the arguments it
+// was set up with (none for a typed configuration), eg. to read its files again
+// - Health returns an error when the plugin cannot work properly, eg. when
+// a backend is unreachable
 type Plugin struct {
-	Name   string
-	Setup6 SetupFunc6
-	Setup4 SetupFunc4
+	Name      string
+	Setup6    SetupFunc6
+	Setup4    SetupFunc4
+	Setup6Ctx SetupFunc6Ctx
+	Setup4Ctx SetupFunc4Ctx
+	// typed configuration
+	NewConfig    func() interface{}
+	Setup6Config SetupFunc6Config
+	Setup4Config SetupFunc4Config
+	// lifecycle hooks
+	Start  func(ctx context.Context) error
+	Stop   func() error
+	Reload func(args ...string) error
+	Health func() error
 }
 
 // RegisteredPlugins maps a plugin name to a Plugin instance.
@@ -32,6 +69,22 @@ type SetupFunc6 func(args ...string) (handler.Handler6, error)
 // SetupFunc4 defines a plugin setup function for DHCPv6
 type SetupFunc4 func(args ...string) (handler.Handler4, error)
 
+// SetupFunc6Ctx defines a plugin setup function for DHCPv6 handlers taking
+// the context of the requests
+type SetupFunc6Ctx func(args ...string) (handler.Handler6Ctx, error)
+
+// SetupFunc4Ctx defines a plugin setup function for DHCPv4 handlers taking
+// the context of the requests
+type SetupFunc4Ctx func(args ...string) (handler.Handler4Ctx, error)
+
+// SetupFunc6Config defines a plugin setup function for DHCPv6 taking the
+// configuration returned by Plugin.NewConfig, decoded
+type SetupFunc6Config func(conf interface{}) (handler.Handler6Ctx, error)
+
+// SetupFunc4Config defines a plugin setup function for DHCPv4 taking the
+// configuration returned by Plugin.NewConfig, decoded
+type SetupFunc4Config func(conf interface{}) (handler.Handler4Ctx, error)
+
 // RegisterPlugin registers a plugin.
 func RegisterPlugin(plugin *Plugin) error {
 	if plugin == nil {
@@ -52,63 +105,170 @@ func RegisterPlugin(plugin *Plugin) error {
 // previously registered with plugins.RegisterPlugin. This is normally done at
 // plugin import time.
 // This function returns the list of loaded v6 plugins, the list of loaded v4
-// plugins, and an error if any.
-func LoadPlugins(conf *config.Config) ([]handler.Handler4, []handler.Handler6, error) {
+// plugins, and an error if any. The other chains of the servers are loaded
+// with LoadPlugins4 and LoadPlugins6.
+func LoadPlugins(conf *config.Config) ([]handler.Handler4Ctx, []handler.Handler6Ctx, error) {
 	log.Print("Loading plugins...")
-	handlers4 := make([]handler.Handler4, 0)
-	handlers6 := make([]handler.Handler6, 0)
+	handlers4 := make([]handler.Handler4Ctx, 0)
+	handlers6 := make([]handler.Handler6Ctx, 0)
 
 	if conf.Server6 == nil && conf.Server4 == nil {
 		return nil, nil, errors.New("no configuration found for either DHCPv6 or DHCPv4")
 	}
 
-	// now load the plugins. We need to call its setup function with
-	// the arguments extracted above. The setup function is mapped in
-	// plugins.RegisteredPlugins .
-
-	// Load DHCPv6 plugins.
+	var err error
 	if conf.Server6 != nil {
-		for _, pluginConf := range conf.Server6.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv6: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup6 == nil {
-					log.Warningf("DHCPv6: plugin `%s` has no setup function for DHCPv6", pluginConf.Name)
-					continue
-				}
-				h6, err := plugin.Setup6(pluginConf.Args...)
-				if err != nil {
-					return nil, nil, err
-				} else if h6 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv6 handler for plugin %s", pluginConf.Name)
-				}
-				handlers6 = append(handlers6, h6)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv6: unknown plugin `%s`", pluginConf.Name)
-			}
+		if handlers6, err = LoadPlugins6(conf.Server6); err != nil {
+			return nil, nil, err
 		}
 	}
-	// Load DHCPv4 plugins. Yes, duplicated code, there's not really much that
-	// can be deduplicated here.
 	if conf.Server4 != nil {
-		for _, pluginConf := range conf.Server4.Plugins {
-			if plugin, ok := RegisteredPlugins[pluginConf.Name]; ok {
-				log.Printf("DHCPv4: loading plugin `%s`", pluginConf.Name)
-				if plugin.Setup4 == nil {
-					log.Warningf("DHCPv4: plugin `%s` has no setup function for DHCPv4", pluginConf.Name)
-					continue
-				}
-				h4, err := plugin.Setup4(pluginConf.Args...)
-				if err != nil {
-					return nil, nil, err
-				} else if h4 == nil {
-					return nil, nil, config.ConfigErrorFromString("no DHCPv4 handler for plugin %s", pluginConf.Name)
-				}
-				handlers4 = append(handlers4, h4)
-			} else {
-				return nil, nil, config.ConfigErrorFromString("DHCPv4: unknown plugin `%s`", pluginConf.Name)
-			}
+		if handlers4, err = LoadPlugins4(conf.Server4); err != nil {
+			return nil, nil, err
 		}
 	}
-
 	return handlers4, handlers6, nil
 }
+
+// LoadPlugins6 loads a DHCPv6 plugin chain. We need to call the setup
+// function of each plugin with the arguments extracted from the
+// configuration. The setup function is mapped in plugins.RegisteredPlugins.
+// A chain with directives (see config.Action) is returned as a single
+// handler running them.
+func LoadPlugins6(sc *config.ServerConfig) ([]handler.Handler6Ctx, error) {
+	if hasActions(sc) {
+		h6, err := loadChain6(sc)
+		if err != nil {
+			return nil, err
+		}
+		return []handler.Handler6Ctx{h6}, nil
+	}
+	enterScope()
+	handlers6 := make([]handler.Handler6Ctx, 0, len(sc.Plugins))
+	for _, pluginConf := range sc.Plugins {
+		h6, err := loadPlugin6(pluginConf)
+		if err != nil {
+			return nil, err
+		}
+		if h6 != nil {
+			handlers6 = append(handlers6, h6)
+		}
+	}
+	return handlers6, nil
+}
+
+// loadPlugin6 sets a DHCPv6 plugin up, and returns its handler, nil if it
+// has none
+func loadPlugin6(pluginConf config.PluginConfig) (handler.Handler6Ctx, error) {
+	plugin, ok := RegisteredPlugins[pluginConf.Name]
+	if !ok {
+		return nil, config.ConfigErrorFromString("DHCPv6: unknown plugin `%s`", pluginConf.Name)
+	}
+	log.Printf("DHCPv6: loading plugin `%s`", pluginConf.Name)
+	var (
+		h6   handler.Handler6Ctx
+		args []string
+		err  error
+	)
+	typed := typedConfig(plugin.Setup6Config != nil, plugin.Setup6Ctx != nil || plugin.Setup6 != nil, pluginConf)
+	if !typed {
+		if args, err = pluginArgs(pluginConf); err != nil {
+			return nil, fmt.Errorf("plugin `%s`: %w", pluginConf.Name, err)
+		}
+	}
+	switch {
+	case typed:
+		var conf interface{}
+		if conf, err = decodeConfig(plugin, pluginConf); err != nil {
+			return nil, fmt.Errorf("plugin `%s`: %w", pluginConf.Name, err)
+		}
+		h6, err = plugin.Setup6Config(conf)
+	case plugin.Setup6Ctx != nil:
+		h6, err = plugin.Setup6Ctx(args...)
+	case plugin.Setup6 != nil:
+		var h handler.Handler6
+		if h, err = plugin.Setup6(args...); h != nil {
+			h6 = handler.Adapt6(h)
+		}
+	default:
+		log.Warningf("DHCPv6: plugin `%s` has no setup function for DHCPv6", pluginConf.Name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	} else if h6 == nil {
+		return nil, config.ConfigErrorFromString("no DHCPv6 handler for plugin %s", pluginConf.Name)
+	}
+	addLoaded(plugin, args)
+	return instrument6(plugin.Name, h6), nil
+}
+
+// LoadPlugins4 loads a DHCPv4 plugin chain. Yes, duplicated code, there's not
+// really much that can be deduplicated here.
+func LoadPlugins4(sc *config.ServerConfig) ([]handler.Handler4Ctx, error) {
+	if hasActions(sc) {
+		h4, err := loadChain4(sc)
+		if err != nil {
+			return nil, err
+		}
+		return []handler.Handler4Ctx{h4}, nil
+	}
+	enterScope()
+	handlers4 := make([]handler.Handler4Ctx, 0, len(sc.Plugins))
+	for _, pluginConf := range sc.Plugins {
+		h4, err := loadPlugin4(pluginConf)
+		if err != nil {
+			return nil, err
+		}
+		if h4 != nil {
+			handlers4 = append(handlers4, h4)
+		}
+	}
+	return handlers4, nil
+}
+
+// loadPlugin4 sets a DHCPv4 plugin up, and returns its handler, nil if it
+// has none
+func loadPlugin4(pluginConf config.PluginConfig) (handler.Handler4Ctx, error) {
+	plugin, ok := RegisteredPlugins[pluginConf.Name]
+	if !ok {
+		return nil, config.ConfigErrorFromString("DHCPv4: unknown plugin `%s`", pluginConf.Name)
+	}
+	log.Printf("DHCPv4: loading plugin `%s`", pluginConf.Name)
+	var (
+		h4   handler.Handler4Ctx
+		args []string
+		err  error
+	)
+	typed := typedConfig(plugin.Setup4Config != nil, plugin.Setup4Ctx != nil || plugin.Setup4 != nil, pluginConf)
+	if !typed {
+		if args, err = pluginArgs(pluginConf); err != nil {
+			return nil, fmt.Errorf("plugin `%s`: %w", pluginConf.Name, err)
+		}
+	}
+	switch {
+	case typed:
+		var conf interface{}
+		if conf, err = decodeConfig(plugin, pluginConf); err != nil {
+			return nil, fmt.Errorf("plugin `%s`: %w", pluginConf.Name, err)
+		}
+		h4, err = plugin.Setup4Config(conf)
+	case plugin.Setup4Ctx != nil:
+		h4, err = plugin.Setup4Ctx(args...)
+	case plugin.Setup4 != nil:
+		var h handler.Handler4
+		if h, err = plugin.Setup4(args...); h != nil {
+			h4 = handler.Adapt4(h)
+		}
+	default:
+		log.Warningf("DHCPv4: plugin `%s` has no setup function for DHCPv4", pluginConf.Name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	} else if h4 == nil {
+		return nil, config.ConfigErrorFromString("no DHCPv4 handler for plugin %s", pluginConf.Name)
+	}
+	addLoaded(plugin, args)
+	return instrument4(plugin.Name, h4), nil
+}
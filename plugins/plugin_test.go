@@ -0,0 +1,75 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// test-set sets its argument in the metadata of the requests, which test-get
+// copies to the boot file name
+func init() {
+	_ = RegisterPlugin(&Plugin{
+		Name: "test-set",
+		Setup4Ctx: func(args ...string) (handler.Handler4Ctx, error) {
+			return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				handler.MetadataFromContext(ctx).Set("test.value", args[0])
+				return resp, false
+			}, nil
+		},
+		// Not used, Setup4Ctx taking precedence
+		Setup4: func(args ...string) (handler.Handler4, error) {
+			return nil, nil
+		},
+	})
+	_ = RegisterPlugin(&Plugin{
+		Name: "test-get",
+		Setup4Ctx: func(args ...string) (handler.Handler4Ctx, error) {
+			return func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+				if v, ok := handler.MetadataFromContext(ctx).Get("test.value"); ok {
+					resp.BootFileName = v.(string)
+				}
+				return resp, false
+			}, nil
+		},
+	})
+}
+
+func TestLoadPlugins4Ctx(t *testing.T) {
+	handlers, err := LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{
+		{Name: "test-set", Args: []string{"pxelinux.0"}},
+		{Name: "test-a", Args: []string{"1"}},
+		{Name: "test-get"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, handlers, 3)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	ctx := handler.NewContext(context.Background())
+	for _, h := range handlers {
+		resp, _ = h(ctx, req, resp)
+	}
+	assert.Equal(t, "pxelinux.0", resp.BootFileName)
+
+	// Without metadata, the values are lost
+	resp, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	for _, h := range handlers {
+		resp, _ = h(context.Background(), req, resp)
+	}
+	assert.Equal(t, "1,", resp.BootFileName)
+}
@@ -0,0 +1,251 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package portlimit implements a plugin limiting the number of clients
+// holding a lease behind each port of the relay agents, as identified by the
+// giaddr and the Agent Circuit ID of option 82, eg. a single device per
+// switch port in a residential building.
+//
+// server4:
+//   plugins:
+//     - server_id: 10.0.0.1
+//     - range: leases.txt 10.0.0.10 10.0.0.100 1h
+//     - portlimit: max=1 circuit-id:uplink-3 max=4
+//
+// The arguments are:
+// - max=<n>: how many clients may hold a lease behind a port, 1 by default
+// - action=nak|ignore: what the extra clients get: a DHCPNAK for their
+// DHCPREQUESTs, their DISCOVERs being ignored, which is the default, or no
+// answer at all
+//
+// They can be followed by per-port overrides, made of a class (see the class
+// package), usually circuit-id:<id> or remote-id:<id>, and its max=<n>. The
+// first matching class applies.
+//
+// A client is counted from the DHCPACK of its lease until it releases or
+// declines it, or its lease expires. The clients of a full port keep
+// renewing their leases; new clients are turned away until one of them
+// leaves. The plugin must come after the plugins assigning the addresses, to
+// see the leases: an address an extra client was offered stays with the
+// allocator until it expires. Requests without an Agent Circuit ID are not
+// limited. The counts are kept in memory only, and start empty when the
+// server starts.
+package portlimit
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/metrics"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/relay"
+)
+
+const pluginName = "portlimit"
+
+var log = logger.GetLogger("plugins/" + pluginName)
+
+// Plugin wraps the portlimit plugin information.
+var Plugin = plugins.Plugin{
+	Name:      pluginName,
+	Setup4Ctx: setup4,
+}
+
+// Actions on the extra clients
+const (
+	ActionNAK    = "nak"
+	ActionIgnore = "ignore"
+)
+
+// defaultLeaseTime is used for the DHCPACKs without a lease time
+const defaultLeaseTime = time.Hour
+
+// override is the limit of the ports of a class
+type override struct {
+	*class.Matcher
+	max int
+}
+
+// PluginState holds the limits and the clients of the ports of an instance
+// of the plugin
+type PluginState struct {
+	max       int
+	action    string
+	overrides []override
+	refused   *expvar.Int
+
+	mu sync.Mutex
+	// ports holds the end of the leases of the clients, by port and hardware
+	// address
+	ports map[string]map[string]time.Time
+}
+
+func parseMax(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid number of clients %q, expected at least 1", value)
+	}
+	return n, nil
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		max:     1,
+		action:  ActionNAK,
+		refused: new(expvar.Int),
+		ports:   make(map[string]map[string]time.Time),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			m, err := class.Parse(arg)
+			if err != nil {
+				return nil, err
+			}
+			p.overrides = append(p.overrides, override{Matcher: m})
+			continue
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "max":
+			n, err := parseMax(value)
+			if err != nil {
+				return nil, err
+			}
+			if len(p.overrides) > 0 {
+				p.overrides[len(p.overrides)-1].max = n
+			} else {
+				p.max = n
+			}
+		case "action":
+			if len(p.overrides) > 0 {
+				return nil, errors.New("the action applies to all the ports, it must come before the classes")
+			}
+			if value != ActionNAK && value != ActionIgnore {
+				return nil, fmt.Errorf("invalid action %q, expected %s or %s", value, ActionNAK, ActionIgnore)
+			}
+			p.action = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	for _, o := range p.overrides {
+		if o.max == 0 {
+			return nil, fmt.Errorf("class %s needs a limit, max=<n>", o)
+		}
+	}
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.refused = metrics.Get(pluginName).Counter("refused")
+	log.Printf("loaded plugin for DHCPv4, %d clients per port, %d overrides", p.max, len(p.overrides))
+	return p.Handler4, nil
+}
+
+// portKey returns the key of the port of a relay agent a request comes from,
+// "" if it does not identify one
+func portKey(req *dhcpv4.DHCPv4) string {
+	circuit := relay.CircuitID4(req)
+	if circuit == nil {
+		return ""
+	}
+	return req.GatewayIPAddr.String() + "/" + hex.EncodeToString(circuit)
+}
+
+// limit returns how many clients may hold a lease behind the port of a
+// request
+func (p *PluginState) limit(req *dhcpv4.DHCPv4) int {
+	for _, o := range p.overrides {
+		if o.Match4(req) {
+			return o.max
+		}
+	}
+	return p.max
+}
+
+// admit tells whether a client may get or keep a lease behind a port, and
+// records its lease if given one. Must be called with the lock held
+func (p *PluginState) admit(port string, req, resp *dhcpv4.DHCPv4, now time.Time) bool {
+	clients := p.ports[port]
+	for hwaddr, end := range clients {
+		if !now.Before(end) {
+			delete(clients, hwaddr)
+		}
+	}
+	hwaddr := req.ClientHWAddr.String()
+	if _, ok := clients[hwaddr]; !ok && len(clients) >= p.limit(req) {
+		return false
+	}
+	if resp.MessageType() == dhcpv4.MessageTypeAck && !resp.YourIPAddr.IsUnspecified() {
+		if clients == nil {
+			clients = make(map[string]time.Time)
+			p.ports[port] = clients
+		}
+		clients[hwaddr] = now.Add(resp.IPAddressLeaseTime(defaultLeaseTime))
+	}
+	if len(clients) == 0 {
+		delete(p.ports, port)
+	}
+	return true
+}
+
+// release forgets the lease of a client
+func (p *PluginState) release(port string, req *dhcpv4.DHCPv4) {
+	if clients, ok := p.ports[port]; ok {
+		delete(clients, req.ClientHWAddr.String())
+		if len(clients) == 0 {
+			delete(p.ports, port)
+		}
+	}
+}
+
+// Handler4 turns away the clients of the full ports
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	port := portKey(req)
+	if port == "" || resp == nil {
+		return resp, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		p.release(port, req)
+		return resp, false
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	default:
+		return resp, false
+	}
+	if p.admit(port, req, resp, time.Now()) {
+		return resp, false
+	}
+	p.refused.Add(1)
+	log.Infof("refusing %s on port %s of relay %s: the port has %d clients already",
+		req.ClientHWAddr, hex.EncodeToString(relay.CircuitID4(req)), req.GatewayIPAddr, len(p.ports[port]))
+	handler.Refuse(ctx, handler.ReasonPortFull)
+	if p.action == ActionNAK && req.MessageType() == dhcpv4.MessageTypeRequest {
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+		resp.UpdateOption(dhcpv4.OptMessage("too many clients on the port"))
+		resp.YourIPAddr = net.IPv4zero
+		return resp, true
+	}
+	return nil, true
+}
@@ -0,0 +1,132 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package portlimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var relayAddr = net.IPv4(192, 0, 2, 1)
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("max=2", "action=ignore", "circuit-id:uplink", "max=4")
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.max)
+	assert.Equal(t, ActionIgnore, p.action)
+	require.Len(t, p.overrides, 1)
+	assert.Equal(t, 4, p.overrides[0].max)
+
+	p, err = parseArgs()
+	require.NoError(t, err)
+	assert.Equal(t, 1, p.max)
+	assert.Equal(t, ActionNAK, p.action)
+
+	for _, args := range [][]string{
+		{"max=0"},
+		{"max=x"},
+		{"action=drop"},
+		{"circuit-id:uplink"},
+		{"circuit-id:uplink", "max=4", "action=ignore"},
+		{"color:blue", "max=4"},
+		{"strict"},
+		{"foo=1"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+// exchange runs a request from a port through the plugin, the allocator
+// having acknowledged it
+func exchange(t *testing.T, p *PluginState, mt dhcpv4.MessageType, mac byte, circuit string) (*dhcpv4.DHCPv4, bool) {
+	modifiers := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, mac}),
+		dhcpv4.WithGatewayIP(relayAddr),
+	}
+	if circuit != "" {
+		modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+			dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit)),
+		)))
+	}
+	req, err := dhcpv4.New(modifiers...)
+	require.NoError(t, err)
+	reply := dhcpv4.MessageTypeAck
+	if mt == dhcpv4.MessageTypeDiscover {
+		reply = dhcpv4.MessageTypeOffer
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(reply),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100+mac)),
+		dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(time.Hour)),
+	)
+	require.NoError(t, err)
+	return p.Handler4(context.Background(), req, resp)
+}
+
+func TestLimit(t *testing.T) {
+	p, err := parseArgs("circuit-id:uplink", "max=2")
+	require.NoError(t, err)
+
+	resp, stop := exchange(t, p, dhcpv4.MessageTypeRequest, 1, "port1")
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+
+	// A second client on the port
+	resp, stop = exchange(t, p, dhcpv4.MessageTypeDiscover, 2, "port1")
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+	resp, stop = exchange(t, p, dhcpv4.MessageTypeRequest, 2, "port1")
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	assert.Equal(t, int64(2), p.refused.Value())
+
+	// The first one renews, others use other ports or no port
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 1, "port1")
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 2, "port2")
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 3, "")
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+
+	// The port is free once the first one leaves
+	exchange(t, p, dhcpv4.MessageTypeRelease, 1, "port1")
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 2, "port1")
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+
+	// Overridden
+	for mac := byte(1); mac <= 2; mac++ {
+		resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, mac, "uplink")
+		assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+	}
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 3, "uplink")
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+}
+
+func TestExpire(t *testing.T) {
+	p, err := parseArgs("action=ignore")
+	require.NoError(t, err)
+	exchange(t, p, dhcpv4.MessageTypeRequest, 1, "port1")
+	resp, stop := exchange(t, p, dhcpv4.MessageTypeRequest, 2, "port1")
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	// The lease of the first one ended
+	for hwaddr := range p.ports[relayAddr.String()+"/706f727431"] {
+		p.ports[relayAddr.String()+"/706f727431"][hwaddr] = time.Now()
+	}
+	resp, _ = exchange(t, p, dhcpv4.MessageTypeRequest, 2, "port1")
+	assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+}
@@ -0,0 +1,80 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package portmap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nftTimeout bounds how long the nft command can run
+const nftTimeout = 10 * time.Second
+
+// nft adds the forwardings as DNAT rules of a chain of nftables
+type nft struct {
+	table, chain string
+}
+
+// nftHandle finds the handle of a rule in the output of nft --echo --handle
+var nftHandle = regexp.MustCompile(`# handle (\d+)`)
+
+// runNFT runs the nft command, and returns its output
+var runNFT = func(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nftTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "nft", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("nft %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func parseNFT(value string) (*nft, error) {
+	sep := strings.IndexByte(value, '/')
+	if sep <= 0 || sep == len(value)-1 {
+		return nil, fmt.Errorf("invalid nftables chain %q, expected <table>/<chain>", value)
+	}
+	return &nft{table: value[:sep], chain: value[sep+1:]}, nil
+}
+
+// flush removes the rules of the chain, left by a previous run
+func (n *nft) flush() error {
+	_, err := runNFT("flush", "chain", "ip", n.table, n.chain)
+	return err
+}
+
+// Map adds the rule of a forwarding, which does not expire: renewals leave it
+func (n *nft) Map(m *Mapping, lifetime time.Duration) error {
+	if m.handle != "" {
+		return nil
+	}
+	out, err := runNFT("--echo", "--handle", "add", "rule", "ip", n.table, n.chain,
+		m.Proto, "dport", strconv.Itoa(int(m.External)),
+		"dnat", "to", m.IP.String()+":"+strconv.Itoa(int(m.Port)))
+	if err != nil {
+		return err
+	}
+	match := nftHandle.FindStringSubmatch(out)
+	if match == nil {
+		return fmt.Errorf("no handle in the output of nft: %q", strings.TrimSpace(out))
+	}
+	m.handle, m.Assigned = match[1], m.External
+	return nil
+}
+
+// Unmap deletes the rule of a forwarding
+func (n *nft) Unmap(m *Mapping) error {
+	_, err := runNFT("delete", "rule", "ip", n.table, n.chain, "handle", m.handle)
+	return err
+}
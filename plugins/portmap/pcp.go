@@ -0,0 +1,151 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package portmap
+
+// A minimal PCP client (RFC 6887), sending MAP requests with the THIRD_PARTY
+// option, as the forwardings are for the clients rather than for the server.
+// A mapping is identified by its nonce, protocol and internal port: it is
+// renewed by the same request with a new lifetime, and deleted with a zero
+// lifetime.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	pcpPort    = 5351
+	pcpVersion = 2
+	pcpOpMap   = 1
+	// pcpResponse is the R bit of the opcode of the responses
+	pcpResponse      = 0x80
+	pcpOptThirdParty = 1
+	pcpRequestSize   = 24 + 36 + 20
+	pcpResponseSize  = 24 + 36
+)
+
+// pcpTries is how many times a request is sent before giving up, waiting
+// twice as long as before for each retry
+var (
+	pcpTries   = 3
+	pcpTimeout = time.Second
+)
+
+// pcpResults are the result codes of RFC 6887 §7.4
+var pcpResults = []string{
+	"SUCCESS", "UNSUPP_VERSION", "NOT_AUTHORIZED", "MALFORMED_REQUEST",
+	"UNSUPP_OPCODE", "UNSUPP_OPTION", "MALFORMED_OPTION", "NETWORK_FAILURE",
+	"NO_RESOURCES", "UNSUPP_PROTOCOL", "USER_EX_QUOTA", "CANNOT_PROVIDE_EXTERNAL",
+	"ADDRESS_MISMATCH", "EXCESSIVE_REMOTE_PEERS",
+}
+
+var pcpProtocols = map[string]byte{"tcp": 6, "udp": 17}
+
+// pcp requests the forwardings from a PCP server
+type pcp struct {
+	server *net.UDPAddr
+}
+
+func parsePCP(value string) (*pcp, error) {
+	host, port := value, strconv.Itoa(pcpPort)
+	if h, p, err := net.SplitHostPort(value); err == nil {
+		host, port = h, p
+	}
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PCP server %q: %v", value, err)
+	}
+	return &pcp{server: addr}, nil
+}
+
+// Map requests a mapping for a lifetime, or renews it
+func (c *pcp) Map(m *Mapping, lifetime time.Duration) error {
+	if lifetime < time.Second {
+		lifetime = time.Second
+	}
+	assigned, err := c.request(m, uint32(lifetime/time.Second))
+	if err != nil {
+		return err
+	}
+	m.Assigned = assigned
+	return nil
+}
+
+// Unmap deletes a mapping
+func (c *pcp) Unmap(m *Mapping) error {
+	_, err := c.request(m, 0)
+	return err
+}
+
+// ipv4Mapped returns an IPv4 address in the 16 bytes form of PCP
+func ipv4Mapped(ip net.IP) []byte {
+	b := make([]byte, 16)
+	b[10], b[11] = 0xff, 0xff
+	copy(b[12:], ip.To4())
+	return b
+}
+
+// request sends a MAP request, and returns the external port assigned
+func (c *pcp) request(m *Mapping, lifetime uint32) (uint16, error) {
+	conn, err := net.DialUDP("udp4", nil, c.server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, pcpRequestSize)
+	req[0], req[1] = pcpVersion, pcpOpMap
+	binary.BigEndian.PutUint32(req[4:8], lifetime)
+	copy(req[8:24], ipv4Mapped(conn.LocalAddr().(*net.UDPAddr).IP))
+	copy(req[24:36], m.nonce[:])
+	req[36] = pcpProtocols[m.Proto]
+	binary.BigEndian.PutUint16(req[40:42], m.Port)
+	binary.BigEndian.PutUint16(req[42:44], m.External)
+	// No preference for the external address
+	copy(req[44:60], ipv4Mapped(net.IPv4zero))
+	req[60] = pcpOptThirdParty
+	binary.BigEndian.PutUint16(req[62:64], 16)
+	copy(req[64:80], ipv4Mapped(m.IP))
+
+	timeout := pcpTimeout
+	buf := make([]byte, 1100)
+	for try := 0; try < pcpTries; try, timeout = try+1, timeout*2 {
+		if _, err := conn.Write(req); err != nil {
+			return 0, err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return 0, err
+			}
+			resp := buf[:n]
+			// Ignore what does not answer this request
+			if n < pcpResponseSize || resp[1] != pcpResponse|pcpOpMap || string(resp[24:36]) != string(m.nonce[:]) {
+				continue
+			}
+			if resp[0] != pcpVersion {
+				return 0, fmt.Errorf("unsupported PCP version %d", resp[0])
+			}
+			if result := int(resp[3]); result != 0 {
+				if result < len(pcpResults) {
+					return 0, fmt.Errorf("PCP server refused the mapping: %s", pcpResults[result])
+				}
+				return 0, fmt.Errorf("PCP server refused the mapping: result %d", result)
+			}
+			return binary.BigEndian.Uint16(resp[42:44]), nil
+		}
+	}
+	return 0, errors.New("no answer from the PCP server")
+}
@@ -0,0 +1,424 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package portmap implements a plugin forwarding ports of the NAT gateway to
+// the clients of some classes (see the class package), eg. cameras or game
+// consoles, for as long as they hold their lease: when a client gets an
+// address, the plugin programs a forwarding from an external port of a pool
+// for each port of its class, and tears them down when the lease is released
+// or expires.
+//
+// The forwardings are programmed by one of these backends:
+// - nft=<table>/<chain>: rules added to a chain of an ip table of nftables,
+// with the nft command. The chain must be dedicated to the plugin, which
+// flushes it when starting, eg.
+//   table ip coredhcp { chain portmap { type nat hook prerouting priority dstnat; } }
+// - pcp=<address>[:<port>]: mappings requested from a PCP server (RFC 6887),
+// eg. a CGNAT or a home gateway, on behalf of the clients (THIRD_PARTY
+// option), for the remaining time of their leases
+//
+// The other arguments are ports=<first>-<last>, the external ports of the
+// forwardings (mandatory), then groups made of a class followed by
+// forward=<protocol>/<port>[,<protocol>/<port>...], the ports of the clients
+// to forward, the protocol being tcp or udp:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - portmap: nft=coredhcp/portmap ports=20000-20999 vendor:Camera forward=tcp/554,tcp/80
+//
+// The plugin must come after the plugins assigning addresses. The first
+// matching class applies. The PCP server may assign other external ports than
+// the ones of the pool, which are logged. The leases are tracked in memory
+// (see the leaseevents package): after a restart, the forwardings are
+// programmed again when the clients renew, possibly from other ports. The
+// backend is called in the background, in order; beyond 1024 waiting lease
+// events, new ones are dropped. The plugin is unhealthy while the last call
+// to the backend failed.
+package portmap
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+var log = logger.GetLogger("plugins/portmap")
+
+// Plugin wraps the portmap plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "portmap",
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+	Health: health,
+}
+
+// queueSize is how many lease events can wait for the backend
+const queueSize = 1024
+
+// Mapping is a port forwarding to a client
+type Mapping struct {
+	// Proto is tcp or udp
+	Proto string
+	IP    net.IP
+	Port  uint16
+	// External is the port of the pool, Assigned the one the backend
+	// forwards, which a PCP server may choose
+	External uint16
+	Assigned uint16
+	// nonce identifies a PCP mapping, handle an nftables rule
+	nonce  [12]byte
+	handle string
+}
+
+func (m *Mapping) String() string {
+	return fmt.Sprintf("%s/%d to %s:%d", m.Proto, m.Assigned, m.IP, m.Port)
+}
+
+// backend programs the forwardings
+type backend interface {
+	// Map programs a forwarding for a lifetime, and again, for the new
+	// lifetime, when the lease is renewed
+	Map(m *Mapping, lifetime time.Duration) error
+	// Unmap tears a forwarding down
+	Unmap(m *Mapping) error
+}
+
+type rule struct {
+	proto string
+	port  uint16
+}
+
+type group struct {
+	*class.Matcher
+	rules []rule
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds an instance of the plugin
+type PluginState struct {
+	groups      []group
+	backend     backend
+	first, last uint16
+	tracker     *leaseevents.Tracker
+	queue       chan leaseevents.Event
+	done        chan struct{}
+
+	// mu guards clients, the groups of the clients which matched a class,
+	// by hardware address, stopped, set once the queue is closed, and
+	// failure, the error of the last call to the backend, if it failed
+	mu      sync.Mutex
+	clients map[string]*group
+	stopped bool
+	failure error
+
+	// mappings, by hardware address, and used, the external ports in use,
+	// belong to the goroutine calling the backend
+	mappings map[string][]*Mapping
+	used     map[uint16]bool
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		queue:    make(chan leaseevents.Event, queueSize),
+		clients:  make(map[string]*group),
+		mappings: make(map[string][]*Mapping),
+		used:     make(map[uint16]bool),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			m, err := class.Parse(arg)
+			if err != nil {
+				return nil, err
+			}
+			p.groups = append(p.groups, group{Matcher: m})
+			continue
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		if key == "forward" {
+			if len(p.groups) == 0 {
+				return nil, fmt.Errorf("argument %q must follow a class", arg)
+			}
+			rules, err := parseRules(value)
+			if err != nil {
+				return nil, err
+			}
+			p.groups[len(p.groups)-1].rules = rules
+			continue
+		}
+		if len(p.groups) > 0 {
+			return nil, fmt.Errorf("argument %q must come before the classes", arg)
+		}
+		switch key {
+		case "nft", "pcp":
+			if p.backend != nil {
+				return nil, errors.New("only one backend can be given")
+			}
+			var err error
+			if key == "nft" {
+				p.backend, err = parseNFT(value)
+			} else {
+				p.backend, err = parsePCP(value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case "ports":
+			bounds := strings.SplitN(value, "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("invalid port range %q, expected <first>-<last>", value)
+			}
+			first, err1 := strconv.ParseUint(bounds[0], 10, 16)
+			last, err2 := strconv.ParseUint(bounds[1], 10, 16)
+			if err1 != nil || err2 != nil || first == 0 || first > last {
+				return nil, fmt.Errorf("invalid port range %q", value)
+			}
+			p.first, p.last = uint16(first), uint16(last)
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.backend == nil {
+		return nil, errors.New("need a backend, nft=<table>/<chain> or pcp=<address>")
+	}
+	if p.first == 0 {
+		return nil, errors.New("need the external ports, ports=<first>-<last>")
+	}
+	if len(p.groups) == 0 {
+		return nil, errors.New("need at least a class and its ports")
+	}
+	for _, g := range p.groups {
+		if len(g.rules) == 0 {
+			return nil, fmt.Errorf("no ports to forward for class %s", g)
+		}
+	}
+	return &p, nil
+}
+
+func parseRules(value string) ([]rule, error) {
+	var rules []rule
+	for _, r := range strings.Split(value, ",") {
+		sep := strings.IndexByte(r, '/')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid port %q, expected <protocol>/<port>", r)
+		}
+		proto := r[:sep]
+		if proto != "tcp" && proto != "udp" {
+			return nil, fmt.Errorf("invalid protocol %q, expected tcp or udp", proto)
+		}
+		port, err := strconv.ParseUint(r[sep+1:], 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port %q", r[sep+1:])
+		}
+		rules = append(rules, rule{proto: proto, port: uint16(port)})
+	}
+	return rules, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := p.backend.(*nft); ok {
+		if err := n.flush(); err != nil {
+			return nil, err
+		}
+	}
+	p.tracker = leaseevents.NewTracker(p.send)
+	p.done = make(chan struct{})
+	go func() {
+		for e := range p.queue {
+			p.apply(e)
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("forwarding ports %d-%d to the clients of %d classes", p.first, p.last, len(p.groups))
+	return p.Handler4, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop stops calling the backends, once the pending lease events are applied
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+	}
+	for _, p := range instances.list {
+		<-p.done
+	}
+	return nil
+}
+
+// health returns the error of the last call to a backend, if it failed
+func health() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		err := p.failure
+		p.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send queues a lease event, without blocking the DHCP exchange
+func (p *PluginState) send(e leaseevents.Event) {
+	switch e.Event {
+	case leaseevents.Allocate, leaseevents.Renew, leaseevents.Release, leaseevents.Expire, leaseevents.Reclaim:
+	default:
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Warningf("too many pending lease events, dropping the %s event of %s", e.Event, e.IP)
+	}
+}
+
+// apply programs or tears down the forwardings of a client on a lease event
+func (p *PluginState) apply(e leaseevents.Event) {
+	mappings := p.mappings[e.HWAddr]
+	if len(mappings) > 0 && (!mappings[0].IP.Equal(e.IP) || e.Expires == nil) {
+		p.unmap(e.HWAddr)
+		mappings = nil
+	}
+	if e.Expires == nil {
+		return
+	}
+	if mappings == nil {
+		p.mu.Lock()
+		g := p.clients[e.HWAddr]
+		p.mu.Unlock()
+		if g == nil {
+			return
+		}
+		for _, r := range g.rules {
+			port, ok := p.allocate()
+			if !ok {
+				log.Warningf("no external port left to forward %s/%d to %s", r.proto, r.port, e.IP)
+				break
+			}
+			m := &Mapping{Proto: r.proto, IP: e.IP, Port: r.port, External: port}
+			if _, err := rand.Read(m.nonce[:]); err != nil {
+				log.Errorf("cannot generate a PCP nonce: %v", err)
+			}
+			mappings = append(mappings, m)
+		}
+		p.mappings[e.HWAddr] = mappings
+	}
+	lifetime := time.Until(*e.Expires)
+	for _, m := range mappings {
+		programmed := m.Assigned != 0
+		err := p.backend.Map(m, lifetime)
+		p.setFailure(err)
+		if err != nil {
+			log.Warningf("could not forward %s/%d to %s:%d: %v", m.Proto, m.External, m.IP, m.Port, err)
+			continue
+		}
+		if !programmed {
+			log.Infof("forwarding %s for %s", m, e.HWAddr)
+		}
+	}
+}
+
+// unmap tears down the forwardings of a client
+func (p *PluginState) unmap(hwaddr string) {
+	for _, m := range p.mappings[hwaddr] {
+		if m.Assigned != 0 {
+			err := p.backend.Unmap(m)
+			p.setFailure(err)
+			if err != nil {
+				log.Warningf("could not stop forwarding %s: %v", m, err)
+			} else {
+				log.Infof("stopped forwarding %s for %s", m, hwaddr)
+			}
+		}
+		delete(p.used, m.External)
+	}
+	delete(p.mappings, hwaddr)
+}
+
+// allocate returns a free external port
+func (p *PluginState) allocate() (uint16, bool) {
+	for port := uint32(p.first); port <= uint32(p.last); port++ {
+		if !p.used[uint16(port)] {
+			p.used[uint16(port)] = true
+			return uint16(port), true
+		}
+	}
+	return 0, false
+}
+
+func (p *PluginState) setFailure(err error) {
+	p.mu.Lock()
+	p.failure = err
+	p.mu.Unlock()
+}
+
+// Handler4 handles DHCPv4 packets for the portmap plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	hwaddr := req.ClientHWAddr.String()
+	p.mu.Lock()
+	_, known := p.clients[hwaddr]
+	for i := range p.groups {
+		if p.groups[i].Match4(req) {
+			p.clients[hwaddr], known = &p.groups[i], true
+			break
+		}
+	}
+	p.mu.Unlock()
+	// The releases may not carry what the class matches
+	if known {
+		p.tracker.Handle4(req, resp)
+	}
+	return resp, false
+}
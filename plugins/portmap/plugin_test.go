@@ -0,0 +1,222 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package portmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+// fakeBackend records the forwardings
+type fakeBackend struct {
+	mapped map[string]time.Duration
+	maps   int
+}
+
+func (b *fakeBackend) Map(m *Mapping, lifetime time.Duration) error {
+	b.maps++
+	m.Assigned = m.External
+	b.mapped[m.String()] = lifetime
+	return nil
+}
+
+func (b *fakeBackend) Unmap(m *Mapping) error {
+	delete(b.mapped, m.String())
+	return nil
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("nft=coredhcp/portmap", "ports=20000-20001", "vendor:Camera", "forward=tcp/554,udp/5000", "mac:00:11:22", "forward=tcp/80")
+	require.NoError(t, err)
+	assert.Equal(t, &nft{table: "coredhcp", chain: "portmap"}, p.backend)
+	assert.Equal(t, uint16(20000), p.first)
+	assert.Equal(t, uint16(20001), p.last)
+	require.Len(t, p.groups, 2)
+	assert.Equal(t, []rule{{"tcp", 554}, {"udp", 5000}}, p.groups[0].rules)
+
+	p, err = parseArgs("pcp=192.0.2.1", "ports=20000-20999", "vendor:Camera", "forward=tcp/554")
+	require.NoError(t, err)
+	assert.Equal(t, pcpPort, p.backend.(*pcp).server.Port)
+
+	for _, args := range [][]string{
+		{"ports=20000-20999", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "ports=20000-20999"},
+		{"nft=coredhcp/portmap", "ports=20000-20999", "vendor:Camera"},
+		{"nft=coredhcp/portmap", "pcp=192.0.2.1", "ports=20000-20999", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp", "ports=20000-20999", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "ports=20999-20000", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "ports=20000", "vendor:Camera", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "ports=20000-20999", "vendor:Camera", "forward=sctp/554"},
+		{"nft=coredhcp/portmap", "ports=20000-20999", "vendor:Camera", "forward=tcp/0"},
+		{"nft=coredhcp/portmap", "ports=20000-20999", "forward=tcp/554"},
+		{"nft=coredhcp/portmap", "vendor:Camera", "forward=tcp/554", "ports=20000-20999"},
+		{"nft=coredhcp/portmap", "ports=20000-20999", "vendor:Camera", "forward=tcp/554", "color=blue"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func exchange(t *testing.T, p *PluginState, mt dhcpv4.MessageType, mac net.HardwareAddr, vendor string, ip net.IP) {
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithClientIP(ip),
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendor)),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(ip),
+		dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(time.Hour)),
+	)
+	require.NoError(t, err)
+	_, stop := p.Handler4(req, resp)
+	assert.False(t, stop)
+	for {
+		select {
+		case e := <-p.queue:
+			p.apply(e)
+		default:
+			return
+		}
+	}
+}
+
+func TestForwardings(t *testing.T) {
+	p, err := parseArgs("nft=coredhcp/portmap", "ports=20000-20002", "vendor:Camera", "forward=tcp/554,tcp/80")
+	require.NoError(t, err)
+	b := &fakeBackend{mapped: make(map[string]time.Duration)}
+	p.backend = b
+	p.tracker = leaseevents.NewTracker(p.send)
+
+	camera := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	ip := net.IPv4(192, 0, 2, 100)
+	exchange(t, p, dhcpv4.MessageTypeRequest, camera, "Camera", ip)
+	require.Len(t, b.mapped, 2)
+	assert.InDelta(t, time.Hour, b.mapped["tcp/20000 to 192.0.2.100:554"], float64(time.Second))
+	assert.Contains(t, b.mapped, "tcp/20001 to 192.0.2.100:80")
+
+	// Not in the class
+	exchange(t, p, dhcpv4.MessageTypeRequest, net.HardwareAddr{0, 1, 2, 3, 4, 6}, "Laptop", net.IPv4(192, 0, 2, 101))
+	assert.Len(t, b.mapped, 2)
+
+	// Renewed, on the same ports
+	exchange(t, p, dhcpv4.MessageTypeRequest, camera, "Camera", ip)
+	assert.Equal(t, 4, b.maps)
+	assert.Len(t, b.mapped, 2)
+
+	// Torn down on release, without the vendor class
+	exchange(t, p, dhcpv4.MessageTypeRelease, camera, "", ip)
+	assert.Empty(t, b.mapped)
+	assert.Empty(t, p.used)
+
+	// A single port left for the next camera
+	p.used[20000], p.used[20001] = true, true
+	exchange(t, p, dhcpv4.MessageTypeRequest, camera, "Camera", net.IPv4(192, 0, 2, 102))
+	assert.Equal(t, map[string]time.Duration{"tcp/20002 to 192.0.2.102:554": b.mapped["tcp/20002 to 192.0.2.102:554"]}, b.mapped)
+}
+
+func TestNFT(t *testing.T) {
+	var commands []string
+	runNFT = func(args ...string) (string, error) {
+		commands = append(commands, strings.Join(args, " "))
+		if args[0] == "--echo" {
+			return "add rule ip coredhcp portmap tcp dport 20000 dnat to 192.0.2.100:554 # handle 7\n", nil
+		}
+		return "", nil
+	}
+	n := &nft{table: "coredhcp", chain: "portmap"}
+	require.NoError(t, n.flush())
+	m := &Mapping{Proto: "tcp", IP: net.IPv4(192, 0, 2, 100), Port: 554, External: 20000}
+	require.NoError(t, n.Map(m, time.Hour))
+	assert.Equal(t, "7", m.handle)
+	assert.Equal(t, uint16(20000), m.Assigned)
+	// Renewals leave the rule
+	require.NoError(t, n.Map(m, time.Hour))
+	require.NoError(t, n.Unmap(m))
+	assert.Equal(t, []string{
+		"flush chain ip coredhcp portmap",
+		"--echo --handle add rule ip coredhcp portmap tcp dport 20000 dnat to 192.0.2.100:554",
+		"delete rule ip coredhcp portmap handle 7",
+	}, commands)
+}
+
+// pcpServer answers MAP requests, assigning the suggested port plus one
+func pcpServer(t *testing.T, result byte) (*net.UDPAddr, chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	requests := make(chan []byte, 10)
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := append([]byte{}, buf[:n]...)
+			requests <- req
+			resp := make([]byte, pcpResponseSize)
+			resp[0], resp[1], resp[3] = pcpVersion, pcpResponse|pcpOpMap, result
+			copy(resp[4:8], req[4:8])
+			copy(resp[24:44], req[24:44])
+			binary.BigEndian.PutUint16(resp[42:44], binary.BigEndian.Uint16(req[42:44])+1)
+			if _, err := conn.WriteToUDP(resp, addr); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), requests
+}
+
+func TestPCP(t *testing.T) {
+	addr, requests := pcpServer(t, 0)
+	c, err := parsePCP(addr.String())
+	require.NoError(t, err)
+
+	m := &Mapping{Proto: "udp", IP: net.IPv4(192, 0, 2, 100), Port: 5000, External: 20000, nonce: [12]byte{1, 2, 3}}
+	require.NoError(t, c.Map(m, time.Hour))
+	assert.Equal(t, uint16(20001), m.Assigned)
+	req := <-requests
+	require.Len(t, req, pcpRequestSize)
+	assert.Equal(t, []byte{pcpVersion, pcpOpMap}, req[:2])
+	assert.Equal(t, uint32(3600), binary.BigEndian.Uint32(req[4:8]))
+	assert.Equal(t, m.nonce[:], req[24:36])
+	assert.Equal(t, byte(17), req[36])
+	assert.Equal(t, uint16(5000), binary.BigEndian.Uint16(req[40:42]))
+	assert.Equal(t, uint16(20000), binary.BigEndian.Uint16(req[42:44]))
+	assert.Equal(t, byte(pcpOptThirdParty), req[60])
+	assert.Equal(t, ipv4Mapped(m.IP), req[64:80])
+
+	require.NoError(t, c.Unmap(m))
+	assert.Zero(t, binary.BigEndian.Uint32((<-requests)[4:8]))
+
+	addr, _ = pcpServer(t, 8)
+	c, err = parsePCP(addr.String())
+	require.NoError(t, err)
+	assert.EqualError(t, c.Map(m, time.Hour), "PCP server refused the mapping: NO_RESOURCES")
+}
+
+func TestPCPTimeout(t *testing.T) {
+	pcpTries, pcpTimeout = 2, 10*time.Millisecond
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	c, err := parsePCP(fmt.Sprint(conn.LocalAddr()))
+	require.NoError(t, err)
+	assert.Error(t, c.Map(&Mapping{Proto: "tcp", IP: net.IPv4(192, 0, 2, 100), Port: 554, External: 20000}, time.Hour))
+}
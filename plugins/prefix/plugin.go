@@ -7,19 +7,33 @@
 //
 // Arguments for the plugin configuration are as follows, in this order:
 // - prefix: The base prefix from which assigned prefixes are carved
-// - max: maximum size of the prefix delegated to clients. When a client requests a larger prefix
+// - length: size of the prefix delegated to clients. When a client requests a larger prefix
 // than this, this is the size of the offered prefix
+//
+// They can be followed by any of these optional arguments:
+// - <length>@<class>: size of the prefix delegated to the clients of a class (see the class
+// package for the syntax), eg. `56@vendor:homegw` or `60@interface-id:olt1`. The first matching
+// class applies
+// - exclude=<length>: exclude the first /<length> of each delegated prefix (RFC6603), for use on
+// the link between the delegating and the requesting router. It is only sent to clients that
+// request the OPTION_PD_EXCLUDE option
+// - file=<path>: persist the delegations in the given file, so they survive restarts
+// - key=<file:<path>|env:<variable>>: encrypt the file at rest, see the leasecrypt package
+//
+// Prefixes of different sizes are carved out of the same pool. A client may hold several IA_PDs,
+// each with its own prefixes.
 package prefix
 
-// FIXME: various settings will be hardcoded (default size, minimum size, lease times) pending a
-// better configuration system
+// FIXME: various settings will be hardcoded (lease times) pending a better configuration system
 
 import (
 	"bytes"
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,7 +45,9 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
-	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/allocators/buddy"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/leasecrypt"
 )
 
 var log = logger.GetLogger("plugins/prefix")
@@ -44,6 +60,14 @@ var Plugin = plugins.Plugin{
 
 const leaseDuration = 3600 * time.Second
 
+func parseLength(s string) (int, error) {
+	length, err := strconv.Atoi(s)
+	if err != nil || length > 128 || length < 0 {
+		return 0, fmt.Errorf("Invalid prefix length: %s", s)
+	}
+	return length, nil
+}
+
 func setupPrefix(args ...string) (handler.Handler6, error) {
 	// - prefix: 2001:db8::/48 64
 	if len(args) < 2 {
@@ -55,26 +79,91 @@ func setupPrefix(args ...string) (handler.Handler6, error) {
 		return nil, fmt.Errorf("Invalid pool subnet: %v", err)
 	}
 
-	allocSize, err := strconv.Atoi(args[1])
-	if err != nil || allocSize > 128 || allocSize < 0 {
-		return nil, fmt.Errorf("Invalid prefix length: %v", err)
+	allocSize, err := parseLength(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	h := Handler{
+		Records: make(map[string][]lease),
+		length:  allocSize,
+	}
+	leaf := allocSize
+	var filename string
+	for _, arg := range args[2:] {
+		switch {
+		case strings.HasPrefix(arg, "exclude="):
+			if h.exclude, err = parseLength(strings.TrimPrefix(arg, "exclude=")); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "file="):
+			filename = strings.TrimPrefix(arg, "file=")
+		case strings.HasPrefix(arg, "key="):
+			if h.cipher, err = leasecrypt.Load(strings.TrimPrefix(arg, "key=")); err != nil {
+				return nil, err
+			}
+		case strings.Contains(arg, "@"):
+			sep := strings.IndexByte(arg, '@')
+			length, err := parseLength(arg[:sep])
+			if err != nil {
+				return nil, err
+			}
+			m, err := class.Parse(arg[sep+1:])
+			if err != nil {
+				return nil, err
+			}
+			h.classes = append(h.classes, classLength{Matcher: m, length: length})
+			if length > leaf {
+				leaf = length
+			}
+		default:
+			return nil, fmt.Errorf("Unknown argument: %s", arg)
+		}
 	}
 
-	// TODO: select allocators based on heuristics or user configuration
-	alloc, err := bitmap.NewBitmapAllocator(*prefix, allocSize)
+	// The buddy allocator is used so that different classes can get prefixes of different sizes
+	// out of the same pool
+	alloc, err := buddy.NewBuddyAllocator(*prefix, leaf)
 	if err != nil {
 		return nil, fmt.Errorf("Could not initialize prefix allocator: %v", err)
 	}
+	h.allocator = alloc
 
-	return (&Handler{
-		Records:   make(map[string][]lease),
-		allocator: alloc,
-	}).Handle, nil
+	if filename != "" {
+		records, err := loadRecordsFromFile(filename, h.cipher)
+		if err != nil {
+			return nil, err
+		}
+		for key, leases := range records {
+			for _, l := range leases {
+				if got, err := alloc.Allocate(l.Prefix); err != nil || !samePrefix(&got, &l.Prefix) {
+					log.Warningf("Ignoring stored lease for %s, it conflicts with another lease or is outside of the pool", &l.Prefix)
+					if err == nil {
+						_ = alloc.Free(got)
+					}
+					continue
+				}
+				h.Records[key] = append(h.Records[key], l)
+			}
+		}
+		if err := h.registerBackingFile(filename); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d delegations from %s", len(h.Records), filename)
+	}
+
+	return h.Handle, nil
+}
+
+type classLength struct {
+	*class.Matcher
+	length int
 }
 
 type lease struct {
 	Prefix net.IPNet
 	Expire time.Time
+	IAID   [4]byte
 }
 
 // Handler holds state of allocations for the plugin
@@ -86,6 +175,12 @@ type Handler struct {
 	// Since it's not valid utf-8 we can't use any other string function though
 	Records   map[string][]lease
 	allocator allocators.Allocator
+	length    int
+	classes   []classLength
+	exclude   int
+	leasefile *os.File
+	// cipher encrypts the lease file, if set
+	cipher *leasecrypt.Cipher
 }
 
 // samePrefix returns true if both prefixes are defined and equal
@@ -102,6 +197,16 @@ func recordKey(d *dhcpv6.Duid) string {
 	return string(d.ToBytes())
 }
 
+// lengthFor returns the size of the prefixes delegated to the client sending req
+func (h *Handler) lengthFor(req dhcpv6.DHCPv6) int {
+	for _, c := range h.classes {
+		if c.Match6(req) {
+			return c.length
+		}
+	}
+	return h.length
+}
+
 // Handle processes DHCPv6 packets for the prefix plugin for a given allocator/leaseset
 func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	msg, err := req.GetInnerMessage()
@@ -116,14 +221,14 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		return nil, true
 	}
 
+	length := h.lengthFor(req)
+	exclude := 0
+	if h.exclude != 0 && msg.Options.RequestedOptions().Contains(dhcpv6.OptionPDExclude) {
+		exclude = h.exclude
+	}
+
 	// Each request IA_PD requires an IA_PD response
 	for _, iapd := range msg.Options.IAPD() {
-		if err != nil {
-			log.Errorf("Malformed IAPD received: %v", err)
-			resp.AddOption(&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusMalformedQuery})
-			return resp, true
-		}
-
 		iapdResp := &dhcpv6.OptIAPD{
 			IaId: iapd.IaId,
 		}
@@ -143,7 +248,15 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// A possible simple optimization here would be to be able to lock single map values
 		// individually instead of the whole map, since we lock for some amount of time
 		h.Lock()
-		knownLeases := h.Records[recordKey(client)]
+		// Only the leases of this IA_PD are considered, a client can have several of them
+		var knownLeases, otherLeases []lease
+		for _, l := range h.Records[recordKey(client)] {
+			if l.IAID == iapd.IaId {
+				knownLeases = append(knownLeases, l)
+			} else {
+				otherLeases = append(otherLeases, l)
+			}
+		}
 		// Bitmap to track which leases are already given in this exchange
 		givenOut := bitset.New(uint(len(knownLeases)))
 
@@ -162,7 +275,6 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 					}
 					satisfied.Set(uint(hintIdx))
 					givenOut.Set(uint(leaseIdx))
-					addPrefix(iapdResp, knownLeases[leaseIdx])
 				}
 			}
 		}
@@ -171,7 +283,7 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// have already assigned to this client
 		for hintIdx, h := range hints {
 			if satisfied.Test(uint(hintIdx)) ||
-				(h.Prefix != nil && !h.Prefix.IP.Equal(net.IPv6zero)) {
+				(h.Prefix != nil && h.Prefix.IP != nil && !h.Prefix.IP.Equal(net.IPv6zero)) {
 				continue
 			}
 			for leaseIdx, l := range knownLeases {
@@ -181,10 +293,12 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 
 				// If a length was requested, only give out prefixes of that length
 				// This is a bad heuristic depending on the allocator behavior, to be improved
-				if hintPrefixLen, _ := h.Prefix.Mask.Size(); hintPrefixLen != 0 {
-					leasePrefixLen, _ := l.Prefix.Mask.Size()
-					if hintPrefixLen != leasePrefixLen {
-						continue
+				if h.Prefix != nil {
+					if hintPrefixLen, _ := h.Prefix.Mask.Size(); hintPrefixLen != 0 {
+						leasePrefixLen, _ := l.Prefix.Mask.Size()
+						if hintPrefixLen != leasePrefixLen {
+							continue
+						}
 					}
 				}
 				expire := time.Now().Add(leaseDuration)
@@ -193,7 +307,6 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 				}
 				satisfied.Set(uint(hintIdx))
 				givenOut.Set(uint(leaseIdx))
-				addPrefix(iapdResp, knownLeases[leaseIdx])
 			}
 		}
 
@@ -206,18 +319,22 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 		// with an empty, or length-only hint)
 
 		// Assign a new lease to satisfy the request
-		var newLeases []lease
 		for i, prefix := range hints {
 			if satisfied.Test(uint(i)) {
 				continue
 			}
 
-			if prefix.Prefix == nil {
-				// XXX: replace usage of dhcp.OptIAPrefix with a better struct in this inner
-				// function to avoid repeated nullpointer checks
-				prefix.Prefix = &net.IPNet{}
+			// Clients may ask for a smaller prefix than configured, not for a larger one
+			hint := net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(length, 128)}
+			if prefix.Prefix != nil {
+				if size, _ := prefix.Prefix.Mask.Size(); size > length {
+					hint.Mask = prefix.Prefix.Mask
+				}
+				if prefix.Prefix.IP != nil {
+					hint.IP = prefix.Prefix.IP
+				}
 			}
-			allocated, err := h.allocator.Allocate(*prefix.Prefix)
+			allocated, err := h.allocator.Allocate(hint)
 			if err != nil {
 				log.Debugf("Nothing allocated for hinted prefix %s", prefix)
 				continue
@@ -225,16 +342,24 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 			l := lease{
 				Expire: time.Now().Add(leaseDuration),
 				Prefix: allocated,
+				IAID:   iapd.IaId,
 			}
 
-			addPrefix(iapdResp, l)
-			newLeases = append(knownLeases, l)
+			knownLeases = append(knownLeases, l)
+			givenOut.Set(uint(len(knownLeases) - 1))
 			log.Debugf("Allocated %s to %s (IAID: %x)", &allocated, client, iapd.IaId)
 		}
 
-		if newLeases != nil {
-			h.Records[recordKey(client)] = newLeases
+		for leaseIdx, l := range knownLeases {
+			if !givenOut.Test(uint(leaseIdx)) {
+				continue
+			}
+			addPrefix(iapdResp, l, exclude)
+			if err := h.saveLease(client, l); err != nil {
+				log.Errorf("Could not persist lease %s for %s: %v", &l.Prefix, client, err)
+			}
 		}
+		h.Records[recordKey(client)] = append(otherLeases, knownLeases...)
 		h.Unlock()
 
 		if len(iapdResp.Options.Options) == 0 {
@@ -250,14 +375,37 @@ func (h *Handler) Handle(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	return resp, false
 }
 
-func addPrefix(resp *dhcpv6.OptIAPD, l lease) {
+func addPrefix(resp *dhcpv6.OptIAPD, l lease, exclude int) {
 	lifetime := time.Until(l.Expire)
 
-	resp.Options.Add(&dhcpv6.OptIAPrefix{
+	iaprefix := &dhcpv6.OptIAPrefix{
 		PreferredLifetime: lifetime,
 		ValidLifetime:     lifetime,
 		Prefix:            dup(&l.Prefix),
-	})
+	}
+	if size, _ := l.Prefix.Mask.Size(); exclude > size {
+		excluded := net.IPNet{IP: l.Prefix.IP, Mask: net.CIDRMask(exclude, 128)}
+		iaprefix.Options.Add(pdExclude(&l.Prefix, &excluded))
+	}
+	resp.Options.Add(iaprefix)
+}
+
+// pdExclude builds an OPTION_PD_EXCLUDE (RFC6603 §4.2) excluding `excluded` from the
+// `delegated` prefix. Only the bits of the excluded prefix past the delegated prefix length (the
+// "IPv6 subnet ID") are encoded
+func pdExclude(delegated, excluded *net.IPNet) dhcpv6.Option {
+	plen, _ := delegated.Mask.Size()
+	elen, _ := excluded.Mask.Size()
+	ip := excluded.IP.To16()
+	data := make([]byte, 1+(elen-plen-1)/8+1)
+	data[0] = byte(elen)
+	for i := 0; i < elen-plen; i++ {
+		bit := plen + i
+		if ip[bit/8]&(0x80>>uint(bit%8)) != 0 {
+			data[1+i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionPDExclude, OptionData: data}
 }
 
 func dup(src *net.IPNet) (dst *net.IPNet) {
@@ -91,3 +91,103 @@ func TestDup(t *testing.T) {
 		t.Fatalf("dup doesn't work: got %v expected %v", dupPrefix, prefix)
 	}
 }
+
+func solicitWithIAPDs(t *testing.T, iaids ...[4]byte) (*dhcpv6.Message, *dhcpv6.Message) {
+	req, err := dhcpv6.NewMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        dhcpIana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}))
+	for _, iaid := range iaids {
+		req.AddOption(&dhcpv6.OptIAPD{IaId: iaid})
+	}
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req, resp
+}
+
+func TestMultipleIAPD(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, resp := solicitWithIAPDs(t, [4]byte{0, 0, 0, 1}, [4]byte{0, 0, 0, 2})
+	result, _ := handler(req, resp)
+
+	iapds := result.(*dhcpv6.Message).Options.IAPD()
+	if len(iapds) != 2 {
+		t.Fatalf("Expected 2 IA_PDs, got %d", len(iapds))
+	}
+	first, second := iapds[0].Options.Prefixes(), iapds[1].Options.Prefixes()
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("Expected one prefix per IA_PD, got %v and %v", first, second)
+	}
+	if samePrefix(first[0].Prefix, second[0].Prefix) {
+		t.Fatalf("Both IA_PDs got the same prefix %s", first[0].Prefix)
+	}
+
+	// The same IA_PDs get the same prefixes on the next exchange
+	req, resp = solicitWithIAPDs(t, [4]byte{0, 0, 0, 2})
+	result, _ = handler(req, resp)
+	again := result.(*dhcpv6.Message).Options.OneIAPD().Options.Prefixes()
+	if len(again) != 1 || !samePrefix(again[0].Prefix, second[0].Prefix) {
+		t.Fatalf("Expected %s again, got %v", second[0].Prefix, again)
+	}
+}
+
+func TestClassLength(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "60", "56@vendor:homegw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, resp := solicitWithIAPDs(t, [4]byte{0, 0, 0, 1})
+	req.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 1, Data: [][]byte{[]byte("acme homegw v2")}})
+	result, _ := handler(req, resp)
+
+	prefixes := result.(*dhcpv6.Message).Options.OneIAPD().Options.Prefixes()
+	if len(prefixes) != 1 {
+		t.Fatalf("Expected one prefix, got %v", prefixes)
+	}
+	if size, _ := prefixes[0].Prefix.Mask.Size(); size != 56 {
+		t.Fatalf("Expected a /56 for the homegw class, got %s", prefixes[0].Prefix)
+	}
+}
+
+func TestPDExclude(t *testing.T) {
+	handler, err := setupPrefix("2001:db8::/48", "56", "exclude=64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, resp := solicitWithIAPDs(t, [4]byte{0, 0, 0, 1})
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionPDExclude))
+	result, _ := handler(req, resp)
+
+	prefixes := result.(*dhcpv6.Message).Options.OneIAPD().Options.Prefixes()
+	if len(prefixes) != 1 {
+		t.Fatalf("Expected one prefix, got %v", prefixes)
+	}
+	opt := prefixes[0].Options.GetOne(dhcpv6.OptionPDExclude)
+	if opt == nil {
+		t.Fatal("No OPTION_PD_EXCLUDE in the delegated prefix")
+	}
+	// prefix-len 64, then the 8-bit subnet ID (64 - 56) of the first /64
+	if data := opt.ToBytes(); len(data) != 2 || data[0] != 64 || data[1] != 0 {
+		t.Fatalf("Malformed OPTION_PD_EXCLUDE: %x", data)
+	}
+}
+
+func TestPDExcludeEncoding(t *testing.T) {
+	_, delegated, _ := net.ParseCIDR("2001:db8:0:ab00::/56")
+	_, excluded, _ := net.ParseCIDR("2001:db8:0:ab34::/62")
+	data := pdExclude(delegated, excluded).ToBytes()
+	// 6 bits of subnet ID (0x34 >> 2 = 0b001101), left-aligned in one byte
+	if len(data) != 2 || data[0] != 62 || data[1] != 0x34 {
+		t.Fatalf("Malformed OPTION_PD_EXCLUDE: %x", data)
+	}
+}
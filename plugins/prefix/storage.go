@@ -0,0 +1,116 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/plugins/leasecrypt"
+)
+
+// loadRecords loads the delegations stored in the given reader. There is one
+// delegation per line: the client DUID and the IAID in hex, the prefix and its
+// expiry. Later lines for the same prefix update the earlier ones
+func loadRecords(r io.Reader) (map[string][]lease, error) {
+	sc := bufio.NewScanner(r)
+	records := make(map[string][]lease)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) == 0 {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 4 {
+			return nil, fmt.Errorf("malformed line, want 4 fields, got %d: %s", len(tokens), line)
+		}
+		duid, err := hex.DecodeString(tokens[0])
+		if err != nil || len(duid) == 0 {
+			return nil, fmt.Errorf("malformed DUID: %s", tokens[0])
+		}
+		iaid, err := hex.DecodeString(tokens[1])
+		if err != nil || len(iaid) != 4 {
+			return nil, fmt.Errorf("malformed IAID: %s", tokens[1])
+		}
+		_, prefix, err := net.ParseCIDR(tokens[2])
+		if err != nil || prefix.IP.To4() != nil {
+			return nil, fmt.Errorf("expected an IPv6 prefix, got: %s", tokens[2])
+		}
+		expires, err := time.Parse(time.RFC3339, tokens[3])
+		if err != nil {
+			return nil, fmt.Errorf("expected time of expiry in RFC3339 format, got: %v", tokens[3])
+		}
+
+		l := lease{Prefix: *prefix, Expire: expires}
+		copy(l.IAID[:], iaid)
+		key := string(duid)
+		updated := false
+		for i := range records[key] {
+			if samePrefix(&records[key][i].Prefix, &l.Prefix) {
+				records[key][i] = l
+				updated = true
+			}
+		}
+		if !updated {
+			records[key] = append(records[key], l)
+		}
+	}
+	return records, sc.Err()
+}
+
+// loadRecordsFromFile loads the delegations of a lease file, decrypted with c
+func loadRecordsFromFile(filename string, c *leasecrypt.Cipher) (map[string][]lease, error) {
+	reader, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lease file %s: %w", filename, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warningf("Failed to close file %s: %v", filename, err)
+		}
+	}()
+	lines, err := c.Decrypt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lease file %s: %w", filename, err)
+	}
+	return loadRecords(lines)
+}
+
+// saveLease writes out a delegation to storage, if there is one
+func (h *Handler) saveLease(client *dhcpv6.Duid, l lease) error {
+	if h.leasefile == nil {
+		return nil
+	}
+	_, err := h.leasefile.WriteString(h.cipher.Seal(hex.EncodeToString(client.ToBytes())+" "+
+		hex.EncodeToString(l.IAID[:])+" "+l.Prefix.String()+" "+
+		l.Expire.Format(time.RFC3339)) + "\n")
+	if err != nil {
+		return err
+	}
+	return h.leasefile.Sync()
+}
+
+// registerBackingFile installs a file as the backing store for delegations
+func (h *Handler) registerBackingFile(filename string) error {
+	if h.leasefile != nil {
+		return errors.New("cannot swap out a lease storage file while running")
+	}
+	// We never close this, but that's ok because plugins are never stopped/unregistered
+	leasefile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open lease file %s: %w", filename, err)
+	}
+	h.leasefile = leasefile
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestLoadRecords(t *testing.T) {
+	records, err := loadRecords(strings.NewReader(`
+00030001aabbccddeeff 00000001 2001:db8:0:100::/56 2020-01-01T00:00:00Z
+00030001aabbccddeeff 00000002 2001:db8:0:200::/56 2020-01-01T00:00:00Z
+00030001aabbccddeeff 00000001 2001:db8:0:100::/56 2021-01-01T00:00:00Z
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leases := records[string([]byte{0, 3, 0, 1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})]
+	if len(leases) != 2 {
+		t.Fatalf("Expected 2 leases, got %v", leases)
+	}
+	if leases[0].IAID != [4]byte{0, 0, 0, 1} || leases[0].Expire.Year() != 2021 {
+		t.Fatalf("Later line did not update the lease: %v", leases[0])
+	}
+
+	if _, err := loadRecords(strings.NewReader("00030001aabbccddeeff 01 2001:db8::/56 2020-01-01T00:00:00Z")); err == nil {
+		t.Fatal("Expected an error for a malformed IAID")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "coredhcp-prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	handler, err := setupPrefix("2001:db8::/48", "56", "file="+tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, resp := solicitWithIAPDs(t, [4]byte{0, 0, 0, 1})
+	result, _ := handler(req, resp)
+	given := result.(*dhcpv6.Message).Options.OneIAPD().Options.Prefixes()
+
+	// A restarted server gives the same prefix, and not to another client
+	handler, err = setupPrefix("2001:db8::/48", "56", "file="+tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, resp = solicitWithIAPDs(t, [4]byte{0, 0, 0, 1})
+	result, _ = handler(req, resp)
+	again := result.(*dhcpv6.Message).Options.OneIAPD().Options.Prefixes()
+	if len(given) != 1 || len(again) != 1 || !samePrefix(given[0].Prefix, again[0].Prefix) {
+		t.Fatalf("Delegation not restored: got %v, expected %v", again, given)
+	}
+}
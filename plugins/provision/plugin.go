@@ -0,0 +1,281 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package provision implements a plugin asking a provisioning backend (eg.
+// MAAS, Tinkerbell, Foreman) about the DHCPv4 clients, to decide whether they
+// network boot, which boot program they get, and their address.
+//
+// The backend is queried with an HTTP GET of the URL, where {mac} is replaced
+// by the MAC address of the client. It answers 404 for the unknown machines,
+// which are left to the following plugins, or a JSON object with the
+// optional fields:
+//
+//  {
+//    "boot": true,
+//    "bootfile": "ipxe.efi",
+//    "next_server": "10.0.0.2",
+//    "ip": "10.0.0.10",
+//    "hostname": "node-1"
+//  }
+//
+// Machines not allowed to boot ("boot": false) get no boot information, even
+// when previous plugins set some: it must come after the plugins setting them
+// (eg. nbp or pxe). Machines given an address are not handled by the
+// following plugins, which should come after (eg. range). Backends with
+// another API can be adapted with a small HTTP service.
+//
+// Arguments are the URL of the backend, then key=value pairs:
+// - failure=<open|closed>: when the backend can't be reached, either handle
+// the clients as unknown (open, the default), or drop their requests (closed)
+// - cache=<duration>: how long answers are cached, 30s by default, 0 to
+// disable caching
+// - timeout=<duration>: the timeout of the queries, 2s by default
+// - token-file=<file>: a file holding a bearer token sent to the backend
+//
+// server4:
+//   plugins:
+//     - nbp: tftp://10.0.0.2/ipxe.efi
+//     - provision: https://provisioning.example.org/dhcp/{mac} failure=closed
+//     - range: leases.txt 10.0.0.100 10.0.0.200 1h
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/provision")
+
+// Plugin wraps the provision plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "provision",
+	Setup4: setup4,
+}
+
+// answer is the answer of the backend about a machine
+type answer struct {
+	Boot       *bool  `json:"boot"`
+	Bootfile   string `json:"bootfile"`
+	NextServer string `json:"next_server"`
+	IP         string `json:"ip"`
+	Hostname   string `json:"hostname"`
+}
+
+// machine is a validated answer of the backend
+type machine struct {
+	// boot is false for the machines not allowed to boot
+	boot       bool
+	bootfile   string
+	nextServer net.IP
+	ip         net.IP
+	hostname   string
+}
+
+func parseAnswer(a *answer) (*machine, error) {
+	m := machine{boot: a.Boot == nil || *a.Boot, bootfile: a.Bootfile, hostname: a.Hostname}
+	if a.NextServer != "" {
+		if m.nextServer = net.ParseIP(a.NextServer).To4(); m.nextServer == nil {
+			return nil, fmt.Errorf("invalid next server %q", a.NextServer)
+		}
+	}
+	if a.IP != "" {
+		if m.ip = net.ParseIP(a.IP).To4(); m.ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", a.IP)
+		}
+	}
+	if a.Hostname != "" && fqdn.HostName(a.Hostname) != a.Hostname {
+		return nil, fmt.Errorf("invalid host name %q", a.Hostname)
+	}
+	return &m, nil
+}
+
+// entry is a cached answer, machine is nil for unknown machines
+type entry struct {
+	machine *machine
+	expires time.Time
+}
+
+// PluginState holds the state of an instance of the provision plugin
+type PluginState struct {
+	url        string
+	token      string
+	client     *http.Client
+	failClosed bool
+	cacheTime  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need the URL of the provisioning backend")
+	}
+	u, err := url.Parse(strings.Replace(args[0], "{mac}", "00:00:00:00:00:00", -1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, expected an http(s) URL", args[0])
+	}
+	p := PluginState{
+		url:       args[0],
+		client:    &http.Client{Timeout: 2 * time.Second},
+		cacheTime: 30 * time.Second,
+		cache:     make(map[string]entry),
+	}
+	for _, arg := range args[1:] {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "failure":
+			switch value {
+			case "open":
+				p.failClosed = false
+			case "closed":
+				p.failClosed = true
+			default:
+				return nil, fmt.Errorf("invalid failure policy %q, expected open or closed", value)
+			}
+		case "cache":
+			if p.cacheTime, err = time.ParseDuration(value); err != nil || p.cacheTime < 0 {
+				return nil, fmt.Errorf("invalid cache duration %q", value)
+			}
+		case "timeout":
+			if p.client.Timeout, err = time.ParseDuration(value); err != nil || p.client.Timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+		case "token-file":
+			data, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, err
+			}
+			p.token = strings.TrimSpace(string(data))
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+// query asks the backend about a machine, it returns nil for unknown machines
+func (p *PluginState) query(mac net.HardwareAddr) (*machine, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.Replace(p.url, "{mac}", url.PathEscape(mac.String()), -1), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var a answer
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return nil, fmt.Errorf("invalid answer: %w", err)
+	}
+	return parseAnswer(&a)
+}
+
+// lookup returns the cached answer about a machine, or asks the backend
+func (p *PluginState) lookup(mac net.HardwareAddr) (*machine, error) {
+	now := time.Now()
+	p.mu.Lock()
+	e, ok := p.cache[mac.String()]
+	p.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.machine, nil
+	}
+	m, err := p.query(mac)
+	if err != nil {
+		return nil, err
+	}
+	if p.cacheTime > 0 {
+		p.mu.Lock()
+		// Drop the expired answers while here, to bound the cache
+		for k, e := range p.cache {
+			if !now.Before(e.expires) {
+				delete(p.cache, k)
+			}
+		}
+		p.cache[mac.String()] = entry{machine: m, expires: now.Add(p.cacheTime)}
+		p.mu.Unlock()
+	}
+	return m, nil
+}
+
+// Handler4 handles DHCPv4 packets for the provision plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	default:
+		return resp, false
+	}
+	m, err := p.lookup(req.ClientHWAddr)
+	if err != nil {
+		if p.failClosed {
+			log.Errorf("Dropping the request of MAC %s, the backend failed: %v", req.ClientHWAddr, err)
+			return nil, true
+		}
+		log.Warningf("Could not query the backend about MAC %s, handling it as unknown: %v", req.ClientHWAddr, err)
+		return resp, false
+	}
+	if m == nil {
+		return resp, false
+	}
+	if !m.boot {
+		delete(resp.Options, dhcpv4.OptionTFTPServerName.Code())
+		delete(resp.Options, dhcpv4.OptionBootfileName.Code())
+		resp.BootFileName = ""
+		resp.ServerHostName = ""
+		resp.ServerIPAddr = net.IPv4zero
+	} else {
+		if m.bootfile != "" {
+			resp.UpdateOption(dhcpv4.OptBootFileName(m.bootfile))
+		}
+		if m.nextServer != nil {
+			resp.ServerIPAddr = m.nextServer
+		}
+	}
+	if m.hostname != "" {
+		resp.UpdateOption(dhcpv4.OptHostName(m.hostname))
+	}
+	if m.ip == nil {
+		return resp, false
+	}
+	resp.YourIPAddr = m.ip
+	log.Debugf("found IP address %s for MAC %s", m.ip, req.ClientHWAddr)
+	return resp, true
+}
@@ -0,0 +1,132 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package provision
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend answers for 3 machines, and counts the queries
+type backend struct {
+	queries int
+	down    bool
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.queries++
+	if b.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/machines/00:11:22:33:44:01":
+		fmt.Fprint(w, `{"boot": true, "bootfile": "ipxe.efi", "next_server": "10.0.0.2", "ip": "10.0.0.10", "hostname": "node-1"}`)
+	case "/machines/00:11:22:33:44:02":
+		fmt.Fprint(w, `{"boot": false}`)
+	case "/machines/00:11:22:33:44:03":
+		fmt.Fprint(w, `{"ip": "10.0.0.300"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestState(t *testing.T, args ...string) (*PluginState, *backend) {
+	b := &backend{}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	p, err := parseArgs(append([]string{srv.URL + "/machines/{mac}"}, args...)...)
+	require.NoError(t, err)
+	return p, b
+}
+
+func handle(t *testing.T, p *PluginState, mac byte) (*dhcpv4.DHCPv4, bool) {
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, mac}),
+	)
+	require.NoError(t, err)
+	// As set by the nbp plugin
+	stub, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithOption(dhcpv4.OptTFTPServerName("10.0.0.3")),
+		dhcpv4.WithOption(dhcpv4.OptBootFileName("pxelinux.0")),
+	)
+	require.NoError(t, err)
+	return p.Handler4(req, stub)
+}
+
+func TestParseArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"provisioning.example.org/{mac}"},
+		{"https://provisioning.example.org/{mac}", "failure=ajar"},
+		{"https://provisioning.example.org/{mac}", "cache=-1s"},
+		{"https://provisioning.example.org/{mac}", "timeout=0"},
+		{"https://provisioning.example.org/{mac}", "backend=maas"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	p, _ := newTestState(t)
+
+	resp, stop := handle(t, p, 1)
+	assert.True(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 10).To4(), resp.YourIPAddr)
+	assert.Equal(t, net.IPv4(10, 0, 0, 2).To4(), resp.ServerIPAddr)
+	assert.Equal(t, "ipxe.efi", resp.BootFileNameOption())
+	assert.Equal(t, "node-1", resp.HostName())
+
+	// Machines not allowed to boot get no boot information
+	resp, stop = handle(t, p, 2)
+	assert.False(t, stop)
+	assert.Empty(t, resp.BootFileNameOption())
+	assert.Empty(t, resp.TFTPServerName())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+
+	// Unknown machines, and invalid answers, are left as is
+	for _, mac := range []byte{3, 4} {
+		resp, stop = handle(t, p, mac)
+		assert.False(t, stop)
+		assert.Equal(t, "pxelinux.0", resp.BootFileNameOption())
+	}
+}
+
+func TestCache(t *testing.T) {
+	p, b := newTestState(t)
+	handle(t, p, 1)
+	handle(t, p, 1)
+	handle(t, p, 4)
+	handle(t, p, 4)
+	assert.Equal(t, 2, b.queries)
+
+	p, b = newTestState(t, "cache=0")
+	handle(t, p, 1)
+	handle(t, p, 1)
+	assert.Equal(t, 2, b.queries)
+}
+
+func TestFailurePolicy(t *testing.T) {
+	p, b := newTestState(t)
+	b.down = true
+	resp, stop := handle(t, p, 1)
+	assert.False(t, stop)
+	assert.Equal(t, "pxelinux.0", resp.BootFileNameOption())
+
+	p, b = newTestState(t, "failure=closed")
+	b.down = true
+	resp, stop = handle(t, p, 1)
+	assert.True(t, stop)
+	assert.Nil(t, resp)
+}
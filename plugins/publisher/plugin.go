@@ -0,0 +1,270 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package publisher implements a plugin publishing the lease events, and
+// optionally the requests of the clients, to a topic of a message bus, so
+// that network analytics pipelines can follow the DHCP activity. The events
+// are allocate, renew, release, expire, reclaim and pxe (see
+// plugins/leaseevents), and request.
+//
+// Arguments are:
+// - url=<URL>: the message bus and topic to publish to (mandatory), one of
+//   - nats://[user:password@]<host>:<port>/<subject>, or tls:// for NATS
+//     over TLS
+//   - mqtt://[user:password@]<host>:<port>/<topic>, or mqtts:// for MQTT
+//     over TLS
+//   - kafka://<broker>[,<broker>...]/<topic>
+// - format=<json|protobuf>: how the events are encoded, json by default. The
+// protobuf messages are described in event.proto
+// - events=<event>[,<event>...]: the events to publish, all the lease events
+// by default. Request events are only published when listed
+// - queue=<n>: how many events can wait to be published before new ones are
+// dropped, 1024 by default
+//
+// The plugin must come after the plugins assigning addresses and boot files:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - publisher: url=kafka://kafka1:9092,kafka2:9092/dhcp format=protobuf events=allocate,release,expire,request
+//
+// Kafka messages are keyed with the MAC address or the DUID of the client.
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/publisher")
+
+// Plugin wraps the publisher plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "publisher",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+// stopTimeout bounds how long the pending events are waited for when the
+// server stops
+const stopTimeout = 5 * time.Second
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds the configuration of an instance of the plugin
+type PluginState struct {
+	url       *url.URL
+	serialize serializer
+	events    map[string]bool
+	queue     chan leaseevents.Event
+	transport transport
+	tracker   *leaseevents.Tracker
+	// done is closed once the events are all published, after the plugin
+	// stopped
+	done chan struct{}
+
+	// mu guards stopped, set once the queue is closed
+	mu      sync.Mutex
+	stopped bool
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		serialize: serializeJSON,
+		events:    make(map[string]bool),
+	}
+	for _, event := range leaseevents.All {
+		p.events[event] = true
+	}
+	queueSize := 1024
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL %q: %v", value, err)
+			}
+			p.url = u
+		case "format":
+			serialize, ok := serializers[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown format %q, expected json or protobuf", value)
+			}
+			p.serialize = serialize
+		case "events":
+			events := make(map[string]bool)
+			for _, event := range strings.Split(value, ",") {
+				if _, ok := p.events[event]; !ok && event != leaseevents.Request {
+					return nil, fmt.Errorf("unknown event %q", event)
+				}
+				events[event] = true
+			}
+			p.events = events
+		case "queue":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("invalid queue size %q", value)
+			}
+			queueSize = size
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.url == nil {
+		return nil, fmt.Errorf("missing url argument")
+	}
+	p.queue = make(chan leaseevents.Event, queueSize)
+	return &p, nil
+}
+
+// redacted returns a URL without its password, for logging
+func redacted(u *url.URL) string {
+	if _, ok := u.User.Password(); !ok {
+		return u.String()
+	}
+	r := *u
+	r.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return r.String()
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.transport, err = newTransport(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", redacted(p.url), err)
+	}
+	p.tracker = leaseevents.NewTracker(p.send)
+	p.done = make(chan struct{})
+	go func() {
+		for e := range p.queue {
+			p.publish(e)
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("publishing events to %s", redacted(p.url))
+	return p, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop publishes the pending events of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+	}
+	deadline := time.After(stopTimeout)
+	for _, p := range instances.list {
+		select {
+		case <-p.done:
+		case <-deadline:
+			return fmt.Errorf("gave up publishing the pending events after %v", stopTimeout)
+		}
+	}
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// send queues an event, without blocking the DHCP exchange
+func (p *PluginState) send(e leaseevents.Event) {
+	if !p.events[e.Event] {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Warningf("too many pending events, dropping the %s event of %s", e.Event, e.IP)
+	}
+}
+
+// publish encodes and publishes an event
+func (p *PluginState) publish(e leaseevents.Event) {
+	payload, err := p.serialize(e)
+	if err != nil {
+		log.Errorf("could not encode the %s event of %s: %v", e.Event, e.IP, err)
+		return
+	}
+	key := e.HWAddr
+	if key == "" {
+		key = e.DUID
+	}
+	if err := p.transport.Publish(key, payload); err != nil {
+		log.Warningf("could not publish the %s event of %s: %v", e.Event, e.IP, err)
+	}
+}
+
+// Handler4 handles DHCPv4 packets for the publisher plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.tracker.Handle4(req, resp)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the publisher plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.tracker.Handle6(req, resp)
+	return resp, false
+}
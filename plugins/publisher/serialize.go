@@ -0,0 +1,98 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package publisher
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// serializer encodes the events into messages
+type serializer func(leaseevents.Event) ([]byte, error)
+
+var serializers = map[string]serializer{
+	"json":     serializeJSON,
+	"protobuf": serializeProtobuf,
+}
+
+func serializeJSON(e leaseevents.Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Field numbers of the Event message, see event.proto
+const (
+	fieldEvent protowire.Number = iota + 1
+	fieldTime
+	fieldIP
+	fieldHWAddr
+	fieldDUID
+	fieldHostname
+	fieldVendorClass
+	fieldExpires
+	fieldBootFile
+	fieldMessageType
+	fieldRelay
+)
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendTimestamp appends a google.protobuf.Timestamp
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	var ts []byte
+	if s := t.Unix(); s != 0 {
+		ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(s))
+	}
+	if ns := t.Nanosecond(); ns != 0 {
+		ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(ns))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+// serializeProtobuf encodes an event as the Event message of event.proto
+func serializeProtobuf(e leaseevents.Event) ([]byte, error) {
+	var b []byte
+	b = appendString(b, fieldEvent, e.Event)
+	b = appendTimestamp(b, fieldTime, e.Time)
+	if ip4 := e.IP.To4(); ip4 != nil {
+		b = appendBytes(b, fieldIP, ip4)
+	} else {
+		b = appendBytes(b, fieldIP, e.IP)
+	}
+	b = appendString(b, fieldHWAddr, e.HWAddr)
+	b = appendString(b, fieldDUID, e.DUID)
+	b = appendString(b, fieldHostname, e.Hostname)
+	b = appendString(b, fieldVendorClass, e.VendorClass)
+	if e.Expires != nil {
+		b = appendTimestamp(b, fieldExpires, *e.Expires)
+	}
+	b = appendString(b, fieldBootFile, e.BootFile)
+	b = appendString(b, fieldMessageType, e.MessageType)
+	if ip4 := e.Relay.To4(); ip4 != nil {
+		b = appendBytes(b, fieldRelay, ip4)
+	} else {
+		b = appendBytes(b, fieldRelay, e.Relay)
+	}
+	return b, nil
+}
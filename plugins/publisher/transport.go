@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// publishTimeout bounds how long a message can take to be published
+const publishTimeout = 5 * time.Second
+
+// transport publishes messages to a topic of a message bus
+type transport interface {
+	// Publish sends a message. The key identifies the client the message is
+	// about, for the buses partitioning topics
+	Publish(key string, payload []byte) error
+}
+
+// newTransport connects to the message bus of a URL, whose path is the topic
+func newTransport(u *url.URL) (transport, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	if topic == "" {
+		return nil, errors.New("missing topic in the URL path")
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+		return newNATS(u, topic)
+	case "mqtt", "mqtts":
+		return newMQTT(u, topic)
+	case "kafka":
+		return newKafka(u, topic), nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, expected nats, tls, mqtt, mqtts or kafka", u.Scheme)
+	}
+}
+
+type natsTransport struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATS(u *url.URL, subject string) (*natsTransport, error) {
+	server := url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}
+	conn, err := nats.Connect(server.String(),
+		nats.Name("coredhcp"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsTransport{conn: conn, subject: subject}, nil
+}
+
+func (t *natsTransport) Publish(key string, payload []byte) error {
+	return t.conn.Publish(t.subject, payload)
+}
+
+type mqttTransport struct {
+	client mqtt.Client
+	topic  string
+}
+
+func newMQTT(u *url.URL, topic string) (*mqttTransport, error) {
+	broker := url.URL{Scheme: "tcp", Host: u.Host}
+	if u.Scheme == "mqtts" {
+		broker.Scheme = "ssl"
+	}
+	hostname, _ := os.Hostname()
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker.String()).
+		SetClientID("coredhcp-" + hostname).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	if u.User != nil {
+		opts.SetUsername(u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			opts.SetPassword(password)
+		}
+	}
+	client := mqtt.NewClient(opts)
+	// With connection retries, this only fails on invalid options
+	if token := client.Connect(); token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		return nil, token.Error()
+	}
+	return &mqttTransport{client: client, topic: topic}, nil
+}
+
+func (t *mqttTransport) Publish(key string, payload []byte) error {
+	token := t.client.Publish(t.topic, 1, false, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return errors.New("timeout")
+	}
+	return token.Error()
+}
+
+type kafkaTransport struct {
+	writer *kafka.Writer
+}
+
+// newKafka returns a transport writing to a Kafka topic. The host of the URL
+// is a comma separated list of brokers
+func newKafka(u *url.URL, topic string) *kafkaTransport {
+	return &kafkaTransport{writer: &kafka.Writer{
+		Addr:  kafka.TCP(strings.Split(u.Host, ",")...),
+		Topic: topic,
+		// Keep the messages about a client in order, in one partition
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+func (t *kafkaTransport) Publish(key string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return t.writer.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: payload})
+}
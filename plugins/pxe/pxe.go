@@ -160,9 +160,7 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-var (
-	opt43, opt60, opt66, opt67 *dhcpv4.Option
-)
+var options handler.StaticOptions4
 
 func parseArgs(args ...string) (*url.URL, error) {
 	if len(args) != 1 {
@@ -178,17 +176,13 @@ func setup4(args ...string) (handler.Handler4, error) {
 		return nil, err
 	}
 
-	otsn := dhcpv4.OptTFTPServerName(u.Host)
-	opt66 = &otsn
-	obfn := dhcpv4.OptBootFileName(u.Path)
-	opt67 = &obfn
-	oci := dhcpv4.OptClassIdentifier("PXEClient")
-	opt60 = &oci
-
 	pxe_opt6 := []byte{6, 1, 8} // PXE_DISCOVERY
 	pxe_opt255 := []byte{255}   // PXE_END
-	ovsi := dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, append(pxe_opt6[:], pxe_opt255[:]...))
-	opt43 = &ovsi
+
+	options.Add(dhcpv4.OptClassIdentifier("PXEClient"))
+	options.Add(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, append(pxe_opt6[:], pxe_opt255[:]...)))
+	options.Add(dhcpv4.OptTFTPServerName(u.Host))
+	options.Add(dhcpv4.OptBootFileName(u.Path))
 
 	log.Printf("loaded PXE plugin for DHCPv4.")
 	return pxeHandler4, nil
@@ -206,11 +200,8 @@ func pxeHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 		return nil, true // skip reply
 	}
 
-	resp.Options.Update(*opt60) // PXEClient
+	options.Splice(req, resp) // PXEClient, PXE options, server and filename
 	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientMachineIdentifier, cmi)) // Duplicate
-	resp.UpdateOption(*opt43) // PXE options
-	resp.UpdateOption(*opt66) // Server
-	resp.UpdateOption(*opt67) // Filename
 
 
 	log.Debugf("Added PXE options to request")
@@ -0,0 +1,162 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ra
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Router advertisement flags and options, RFC 4861 §4.2 and §4.6, RFC 8106
+const (
+	flagManaged = 0x80
+	flagOther   = 0x40
+
+	optSourceLinkLayerAddress = 1
+	optPrefixInformation      = 3
+	optRDNSS                  = 25
+	optDNSSL                  = 31
+
+	prefixOnLink     = 0x80
+	prefixAutonomous = 0x40
+)
+
+// The lifetimes of the prefixes, the defaults of RFC 4861 §6.2.1
+const (
+	validLifetime     = 30 * 24 * time.Hour
+	preferredLifetime = 7 * 24 * time.Hour
+)
+
+// curHopLimit is the hop limit the hosts should use
+const curHopLimit = 64
+
+// settings are what the DHCPv6 plugins serve, see snapshot
+type settings struct {
+	managed bool
+	dns     []net.IP
+	domains []string
+}
+
+// dnsLifetime returns the lifetime of the RDNSS and DNSSL options, at least 3
+// times the interval, RFC 8106 §5.1
+func (p *PluginState) dnsLifetime() time.Duration {
+	return 3 * p.interval
+}
+
+// autonomous tells whether a prefix is for address autoconfiguration: only
+// /64 prefixes are, when the DHCPv6 plugins don't assign addresses
+func autonomous(s settings, prefix *net.IPNet) bool {
+	ones, _ := prefix.Mask.Size()
+	return !s.managed && ones == 64
+}
+
+func seconds(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+// message returns the body of a router advertisement, after the ICMPv6
+// header, with a router lifetime
+func (p *PluginState) message(s settings, lifetime time.Duration) []byte {
+	b := make([]byte, 12)
+	b[0] = curHopLimit
+	b[1] = flagOther
+	if s.managed {
+		b[1] |= flagManaged
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(seconds(lifetime)))
+	// Reachable time and retrans timer are left to the hosts
+
+	if p.ifi != nil && len(p.ifi.HardwareAddr) == 6 {
+		b = append(b, optSourceLinkLayerAddress, 1)
+		b = append(b, p.ifi.HardwareAddr...)
+	}
+	for _, prefix := range p.prefixes {
+		opt := make([]byte, 32)
+		opt[0], opt[1] = optPrefixInformation, 4
+		ones, _ := prefix.Mask.Size()
+		opt[2], opt[3] = byte(ones), prefixOnLink
+		if autonomous(s, prefix) {
+			opt[3] |= prefixAutonomous
+		}
+		binary.BigEndian.PutUint32(opt[4:8], seconds(validLifetime))
+		binary.BigEndian.PutUint32(opt[8:12], seconds(preferredLifetime))
+		copy(opt[16:32], prefix.IP.To16())
+		b = append(b, opt...)
+	}
+	if len(s.dns) > 0 {
+		opt := make([]byte, 8, 8+16*len(s.dns))
+		opt[0], opt[1] = optRDNSS, byte(1+2*len(s.dns))
+		binary.BigEndian.PutUint32(opt[4:8], seconds(p.dnsLifetime()))
+		for _, ip := range s.dns {
+			opt = append(opt, ip.To16()...)
+		}
+		b = append(b, opt...)
+	}
+	if len(s.domains) > 0 {
+		opt := make([]byte, 8)
+		opt[0] = optDNSSL
+		binary.BigEndian.PutUint32(opt[4:8], seconds(p.dnsLifetime()))
+		for _, domain := range s.domains {
+			for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+				opt = append(opt, byte(len(label)))
+				opt = append(opt, label...)
+			}
+			opt = append(opt, 0)
+		}
+		// Padded with zeros to a multiple of 8 bytes
+		for len(opt)%8 != 0 {
+			opt = append(opt, 0)
+		}
+		opt[1] = byte(len(opt) / 8)
+		b = append(b, opt...)
+	}
+	return b
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// radvdConfig returns the configuration of radvd for the interface
+func (p *PluginState) radvdConfig(s settings) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by coredhcp from its configuration\n")
+	fmt.Fprintf(&b, "interface %s {\n", p.iface)
+	fmt.Fprintf(&b, "\tAdvSendAdvert on;\n")
+	fmt.Fprintf(&b, "\tAdvManagedFlag %s;\n", onOff(s.managed))
+	fmt.Fprintf(&b, "\tAdvOtherConfigFlag on;\n")
+	fmt.Fprintf(&b, "\tMaxRtrAdvInterval %d;\n", seconds(p.interval))
+	fmt.Fprintf(&b, "\tAdvDefaultLifetime %d;\n", seconds(p.lifetime))
+	for _, prefix := range p.prefixes {
+		fmt.Fprintf(&b, "\tprefix %s {\n", prefix)
+		fmt.Fprintf(&b, "\t\tAdvOnLink on;\n")
+		fmt.Fprintf(&b, "\t\tAdvAutonomous %s;\n", onOff(autonomous(s, prefix)))
+		fmt.Fprintf(&b, "\t\tAdvValidLifetime %d;\n", seconds(validLifetime))
+		fmt.Fprintf(&b, "\t\tAdvPreferredLifetime %d;\n", seconds(preferredLifetime))
+		fmt.Fprintf(&b, "\t};\n")
+	}
+	if len(s.dns) > 0 {
+		var servers []string
+		for _, ip := range s.dns {
+			servers = append(servers, ip.String())
+		}
+		fmt.Fprintf(&b, "\tRDNSS %s {\n", strings.Join(servers, " "))
+		fmt.Fprintf(&b, "\t\tAdvRDNSSLifetime %d;\n", seconds(p.dnsLifetime()))
+		fmt.Fprintf(&b, "\t};\n")
+	}
+	if len(s.domains) > 0 {
+		fmt.Fprintf(&b, "\tDNSSL %s {\n", strings.Join(s.domains, " "))
+		fmt.Fprintf(&b, "\t\tAdvDNSSLLifetime %d;\n", seconds(p.dnsLifetime()))
+		fmt.Fprintf(&b, "\t};\n")
+	}
+	fmt.Fprintf(&b, "};\n")
+	return b.String()
+}
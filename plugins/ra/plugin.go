@@ -0,0 +1,361 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package ra implements a plugin sending the router advertisements (RFC 4861)
+// of an interface, consistent with what the DHCPv6 plugins serve, so that a
+// single configuration drives both:
+// - the M (managed) flag is set when a plugin assigns addresses (eg. file or
+// temporary), and the O (other configuration) flag is always set
+// - the prefixes are announced on-link, and for address autoconfiguration
+// (SLAAC) only without the M flag
+// - the DNS servers of the dns plugin and the domains of the searchdomains
+// plugin are announced as RDNSS and DNSSL options (RFC 8106), for the
+// clients which don't ask DHCPv6
+//
+// Arguments are:
+// - interface=<name>: the interface to advertise on (mandatory)
+// - prefix=<prefix>[,<prefix>...]: the prefixes of the link
+// - interval=<duration>: the maximum interval between unsolicited
+// advertisements, 10m by default, the minimum one being a third of it
+// - lifetime=<duration>: the router lifetime, 3 times the interval by
+// default; 0 to advertise without being a default router
+// - radvd=<path>: write the configuration of radvd to the file rather than
+// sending the advertisements, for radvd to send them
+//
+// server6:
+//   plugins:
+//     - server_id: LL 00:de:ad:be:ef:00
+//     - file: leases.txt
+//     - dns: 2001:db8::53
+//     - ra: interface=eth0 prefix=2001:db8:0:1::/64
+//
+// The advertisements are sent at random intervals and in answer to the router
+// solicitations; when the server stops, a last one with a zero router
+// lifetime tells the hosts the router is gone. Sending them needs the
+// net_raw capability. Other daemons, eg. radvd, must not advertise on the
+// interface at the same time.
+package ra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+var log = logger.GetLogger("plugins/ra")
+
+// Plugin wraps the ra plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "ra",
+	Setup6: setup6,
+	Start:  start,
+	Stop:   stop,
+}
+
+// minDelayBetweenRAs is the minimum delay between two multicast router
+// advertisements, RFC 4861 §10
+const minDelayBetweenRAs = 3 * time.Second
+
+// advertised holds what the DHCPv6 plugins serve, as they tell it from their
+// setup
+var advertised struct {
+	sync.Mutex
+	managed bool
+	dns     []net.IP
+	domains []string
+}
+
+// AdvertiseAddresses tells the router advertisements that a DHCPv6 plugin
+// assigns addresses, setting their M flag
+func AdvertiseAddresses() {
+	advertised.Lock()
+	defer advertised.Unlock()
+	advertised.managed = true
+}
+
+// AdvertiseDNS adds DNS servers to the RDNSS option of the router
+// advertisements
+func AdvertiseDNS(servers ...net.IP) {
+	advertised.Lock()
+	defer advertised.Unlock()
+	advertised.dns = append(advertised.dns, servers...)
+}
+
+// AdvertiseDomains adds domains to the DNSSL option of the router
+// advertisements
+func AdvertiseDomains(domains ...string) {
+	advertised.Lock()
+	defer advertised.Unlock()
+	advertised.domains = append(advertised.domains, domains...)
+}
+
+// instances holds the instances of the plugin, started once all the plugins
+// are set up, so that they know what the others serve
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds an instance of the plugin
+type PluginState struct {
+	iface    string
+	prefixes []*net.IPNet
+	interval time.Duration
+	lifetime time.Duration
+	radvd    string
+
+	// The socket, and when the last advertisement was sent, are set once
+	// started
+	mu     sync.Mutex
+	conn   *icmp.PacketConn
+	ifi    *net.Interface
+	last   time.Time
+	done   chan struct{}
+	closed bool
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{interval: 10 * time.Minute, lifetime: -1}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "interface":
+			if value == "" {
+				return nil, errors.New("empty interface name")
+			}
+			p.iface = value
+		case "prefix":
+			for _, s := range strings.Split(value, ",") {
+				ip, prefix, err := net.ParseCIDR(s)
+				if err != nil || ip.To4() != nil {
+					return nil, fmt.Errorf("invalid IPv6 prefix %q", s)
+				}
+				p.prefixes = append(p.prefixes, prefix)
+			}
+		case "interval":
+			interval, err := time.ParseDuration(value)
+			// RFC 4861 §6.2.1
+			if err != nil || interval < 4*time.Second || interval > 1800*time.Second {
+				return nil, fmt.Errorf("invalid interval %q, expected 4s to 30m", value)
+			}
+			p.interval = interval
+		case "lifetime":
+			lifetime, err := time.ParseDuration(value)
+			if err != nil || lifetime < 0 || lifetime > 9000*time.Second {
+				return nil, fmt.Errorf("invalid router lifetime %q, expected 0 to 2h30m", value)
+			}
+			p.lifetime = lifetime
+		case "radvd":
+			if value == "" {
+				return nil, errors.New("empty radvd configuration path")
+			}
+			p.radvd = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.iface == "" {
+		return nil, errors.New("need an interface, interface=<name>")
+	}
+	if p.lifetime < 0 {
+		p.lifetime = 3 * p.interval
+		if p.lifetime > 9000*time.Second {
+			p.lifetime = 9000 * time.Second
+		}
+	}
+	return &p, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	return Handler6, nil
+}
+
+// start writes the radvd configurations, and starts advertising on the
+// interfaces of the other instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		if p.radvd != "" {
+			conf := p.radvdConfig(snapshot())
+			if err := ioutil.WriteFile(p.radvd, []byte(conf), 0644); err != nil {
+				return fmt.Errorf("cannot write the radvd configuration: %w", err)
+			}
+			log.Printf("wrote the radvd configuration of %s to %s", p.iface, p.radvd)
+			continue
+		}
+		if err := p.listen(); err != nil {
+			return err
+		}
+		go p.advertise(ctx)
+		go p.answer()
+		log.Printf("advertising on %s", p.iface)
+	}
+	return nil
+}
+
+// stop tells the hosts the routers are gone
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if p.conn != nil && !p.closed {
+			p.closed = true
+			close(p.done)
+			if err := p.send(0); err != nil {
+				log.Warningf("could not send the last advertisement on %s: %v", p.iface, err)
+			}
+			p.conn.Close()
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+// snapshot returns what the DHCPv6 plugins serve
+func snapshot() settings {
+	advertised.Lock()
+	defer advertised.Unlock()
+	return settings{
+		managed: advertised.managed,
+		dns:     append([]net.IP{}, advertised.dns...),
+		domains: append([]string{}, advertised.domains...),
+	}
+}
+
+func (p *PluginState) listen() error {
+	ifi, err := net.InterfaceByName(p.iface)
+	if err != nil {
+		return err
+	}
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return fmt.Errorf("cannot listen for router solicitations: %w", err)
+	}
+	pc := conn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	// RFC 4861 §6.1.2: the advertisements have a hop limit of 255
+	for _, err := range []error{
+		pc.SetICMPFilter(&filter),
+		pc.SetControlMessage(ipv6.FlagInterface, true),
+		pc.SetMulticastInterface(ifi),
+		pc.SetMulticastHopLimit(255),
+		pc.SetHopLimit(255),
+		pc.SetMulticastLoopback(false),
+		pc.JoinGroup(ifi, &net.IPAddr{IP: net.IPv6linklocalallrouters}),
+	} {
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("cannot set up advertising on %s: %w", p.iface, err)
+		}
+	}
+	p.mu.Lock()
+	p.conn, p.ifi, p.done = conn, ifi, make(chan struct{})
+	p.mu.Unlock()
+	return nil
+}
+
+// advertise sends the unsolicited advertisements, at random intervals
+// between a third of the interval and the interval (RFC 4861 §6.2.4)
+func (p *PluginState) advertise(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		if err := p.send(p.lifetime); err != nil {
+			log.Warningf("could not advertise on %s: %v", p.iface, err)
+		}
+		p.mu.Unlock()
+		min := p.interval / 3
+		delay := min + time.Duration(rand.Int63n(int64(p.interval-min)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// answer sends an advertisement for the router solicitations, unless one was
+// sent lately
+func (p *PluginState) answer() {
+	pc := p.conn.IPv6PacketConn()
+	buf := make([]byte, 1500)
+	for {
+		_, cm, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if !closed {
+				log.Errorf("stopped answering the router solicitations on %s: %v", p.iface, err)
+			}
+			return
+		}
+		if cm == nil || cm.IfIndex != p.ifi.Index {
+			continue
+		}
+		p.mu.Lock()
+		if !p.closed && time.Since(p.last) >= minDelayBetweenRAs {
+			if err := p.send(p.lifetime); err != nil {
+				log.Warningf("could not advertise on %s: %v", p.iface, err)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// send multicasts an advertisement with a router lifetime. The caller must
+// hold the lock
+func (p *PluginState) send(lifetime time.Duration) error {
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{Data: p.message(snapshot(), lifetime)},
+	}
+	// The kernel computes the checksum
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.conn.WriteTo(b, &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: p.iface})
+	p.last = time.Now()
+	return err
+}
+
+// Handler6 lets the DHCPv6 packets through: the plugin only advertises
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	return resp, false
+}
@@ -0,0 +1,117 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ra
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("interface=eth0", "prefix=2001:db8:0:1::/64,2001:db8:0:2::/64", "interval=1m")
+	require.NoError(t, err)
+	assert.Equal(t, "eth0", p.iface)
+	assert.Len(t, p.prefixes, 2)
+	assert.Equal(t, time.Minute, p.interval)
+	assert.Equal(t, 3*time.Minute, p.lifetime)
+
+	p, err = parseArgs("interface=eth0", "lifetime=0s")
+	require.NoError(t, err)
+	assert.Zero(t, p.lifetime)
+	assert.Equal(t, 10*time.Minute, p.interval)
+
+	for _, args := range [][]string{
+		{},
+		{"prefix=2001:db8::/64"},
+		{"interface=eth0", "prefix=10.0.0.0/24"},
+		{"interface=eth0", "interval=1s"},
+		{"interface=eth0", "lifetime=3h"},
+		{"interface=eth0", "radvd="},
+		{"interface=eth0", "mtu=1500"},
+		{"eth0"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	p, err := parseArgs("interface=eth0", "prefix=2001:db8:0:1::/64", "interval=1m")
+	require.NoError(t, err)
+	p.ifi = &net.Interface{HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}
+
+	// Stateless: SLAAC
+	b := p.message(settings{}, p.lifetime)
+	require.Len(t, b, 12+8+32)
+	assert.Equal(t, []byte{curHopLimit, flagOther, 0, 180}, b[:4])
+	assert.Equal(t, []byte{optSourceLinkLayerAddress, 1, 0, 1, 2, 3, 4, 5}, b[12:20])
+	prefix := b[20:]
+	assert.Equal(t, []byte{optPrefixInformation, 4, 64, prefixOnLink | prefixAutonomous}, prefix[:4])
+	assert.Equal(t, net.ParseIP("2001:db8:0:1::").To16(), net.IP(prefix[16:32]))
+
+	// Stateful, with DNS
+	b = p.message(settings{
+		managed: true,
+		dns:     []net.IP{net.ParseIP("2001:db8::53")},
+		domains: []string{"example.org"},
+	}, 0)
+	require.Len(t, b, 12+8+32+24+24)
+	assert.Equal(t, []byte{curHopLimit, flagManaged | flagOther, 0, 0}, b[:4])
+	assert.Equal(t, byte(prefixOnLink), b[20+3])
+	rdnss := b[52:76]
+	assert.Equal(t, []byte{optRDNSS, 3, 0, 0, 0, 0, 0, 180}, rdnss[:8])
+	assert.Equal(t, net.ParseIP("2001:db8::53").To16(), net.IP(rdnss[8:]))
+	dnssl := b[76:]
+	assert.Equal(t, []byte{optDNSSL, 3, 0, 0, 0, 0, 0, 180}, dnssl[:8])
+	assert.Equal(t, append([]byte("\x07example\x03org\x00"), 0, 0, 0), dnssl[8:])
+}
+
+func TestRadvd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-ra")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "radvd.conf")
+
+	p, err := parseArgs("interface=eth0", "prefix=2001:db8:0:1::/64", "radvd="+path)
+	require.NoError(t, err)
+	instances.list = []*PluginState{p}
+	AdvertiseAddresses()
+	AdvertiseDNS(net.ParseIP("2001:db8::53"))
+	AdvertiseDomains("example.org")
+	require.NoError(t, start(context.Background()))
+
+	conf, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by coredhcp from its configuration
+interface eth0 {
+	AdvSendAdvert on;
+	AdvManagedFlag on;
+	AdvOtherConfigFlag on;
+	MaxRtrAdvInterval 600;
+	AdvDefaultLifetime 1800;
+	prefix 2001:db8:0:1::/64 {
+		AdvOnLink on;
+		AdvAutonomous off;
+		AdvValidLifetime 2592000;
+		AdvPreferredLifetime 604800;
+	};
+	RDNSS 2001:db8::53 {
+		AdvRDNSSLifetime 1800;
+	};
+	DNSSL example.org {
+		AdvDNSSLLifetime 1800;
+	};
+};
+`, string(conf))
+	assert.NoError(t, stop())
+}
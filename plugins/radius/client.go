@@ -0,0 +1,236 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Packet codes (RFC 2865, section 3)
+const (
+	codeAccessRequest = 1
+	codeAccessAccept  = 2
+	codeAccessReject  = 3
+)
+
+// Attribute types used by the plugin (RFC 2865, section 5, and RFC 3579)
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrServiceType          = 6
+	attrFramedIPAddress      = 8
+	attrSessionTimeout       = 27
+	attrCallingStationID     = 31
+	attrNASIdentifier        = 32
+	attrFramedPool           = 88
+	attrMessageAuthenticator = 80
+)
+
+// serviceTypeCallCheck is the Service-Type of MAC address authentication
+const serviceTypeCallCheck = 10
+
+const (
+	headerLen = 20
+	maxLen    = 4096
+)
+
+// attribute is a RADIUS attribute
+type attribute struct {
+	typ   byte
+	value []byte
+}
+
+// packet is a RADIUS packet
+type packet struct {
+	code          byte
+	id            byte
+	authenticator [16]byte
+	attributes    []attribute
+}
+
+// get returns the value of the first attribute of a type, nil if there is none
+func (p *packet) get(typ byte) []byte {
+	for _, a := range p.attributes {
+		if a.typ == typ {
+			return a.value
+		}
+	}
+	return nil
+}
+
+func (p *packet) encode() ([]byte, error) {
+	b := make([]byte, headerLen, 256)
+	b[0], b[1] = p.code, p.id
+	copy(b[4:20], p.authenticator[:])
+	for _, a := range p.attributes {
+		if len(a.value) > 253 {
+			return nil, fmt.Errorf("attribute %d is too long", a.typ)
+		}
+		b = append(b, a.typ, byte(len(a.value)+2))
+		b = append(b, a.value...)
+	}
+	if len(b) > maxLen {
+		return nil, errors.New("packet is too long")
+	}
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	return b, nil
+}
+
+func decode(b []byte) (*packet, error) {
+	if len(b) < headerLen {
+		return nil, errors.New("short packet")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < headerLen || length > len(b) {
+		return nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	p := packet{code: b[0], id: b[1]}
+	copy(p.authenticator[:], b[4:20])
+	for attrs := b[headerLen:length]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("malformed attribute")
+		}
+		p.attributes = append(p.attributes, attribute{typ: attrs[0], value: attrs[2:attrs[1]]})
+		attrs = attrs[attrs[1]:]
+	}
+	return &p, nil
+}
+
+// hidePassword hides a User-Password (RFC 2865, section 5.2)
+func hidePassword(password, secret []byte, authenticator [16]byte) []byte {
+	padded := make([]byte, (len(password)+15)/16*16)
+	if len(padded) == 0 {
+		padded = make([]byte, 16)
+	}
+	copy(padded, password)
+	prev := authenticator[:]
+	for i := 0; i < len(padded); i += 16 {
+		h := md5.New()
+		h.Write(secret)
+		h.Write(prev)
+		sum := h.Sum(nil)
+		for j := range sum {
+			padded[i+j] ^= sum[j]
+		}
+		prev = padded[i : i+16]
+	}
+	return padded
+}
+
+// sign sets the Message-Authenticator of an encoded packet (RFC 3579,
+// section 3.2), computed with auth in the authenticator field
+func sign(b, secret []byte, auth []byte) {
+	msg := append([]byte(nil), b...)
+	copy(msg[4:20], auth)
+	off := headerLen
+	for off+2 <= len(msg) && msg[off+1] >= 2 {
+		if msg[off] == attrMessageAuthenticator && msg[off+1] == 18 {
+			for i := off + 2; i < off+18; i++ {
+				msg[i] = 0
+			}
+			mac := hmac.New(md5.New, secret)
+			mac.Write(msg)
+			copy(b[off+2:off+18], mac.Sum(nil))
+			return
+		}
+		off += int(msg[off+1])
+	}
+}
+
+// verify checks the Response Authenticator of a response, and its
+// Message-Authenticator if it has one
+func verify(b, secret []byte, request *packet) bool {
+	resp, err := decode(b)
+	if err != nil {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	h := md5.New()
+	h.Write(b[:4])
+	h.Write(request.authenticator[:])
+	h.Write(b[headerLen:length])
+	h.Write(secret)
+	if !hmac.Equal(h.Sum(nil), b[4:20]) {
+		return false
+	}
+	if ma := resp.get(attrMessageAuthenticator); ma != nil {
+		signed := append([]byte(nil), b[:length]...)
+		sign(signed, secret, request.authenticator[:])
+		return bytes.Equal(signed, b[:length])
+	}
+	return true
+}
+
+// client sends Access-Requests to a RADIUS server
+type client struct {
+	server  string
+	secret  []byte
+	timeout time.Duration
+	retries int
+}
+
+// exchange sends a request, and returns the verified response. Requests are
+// sent again when there is no answer within the timeout
+func (c *client) exchange(attrs []attribute) (*packet, error) {
+	req := packet{code: codeAccessRequest}
+	var random [17]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	req.id = random[0]
+	copy(req.authenticator[:], random[1:])
+	for _, a := range attrs {
+		if a.typ == attrUserPassword {
+			a.value = hidePassword(a.value, c.secret, req.authenticator)
+		}
+		req.attributes = append(req.attributes, a)
+	}
+	req.attributes = append(req.attributes, attribute{typ: attrMessageAuthenticator, value: make([]byte, 16)})
+	b, err := req.encode()
+	if err != nil {
+		return nil, err
+	}
+	sign(b, c.secret, req.authenticator[:])
+
+	conn, err := net.Dial("udp", c.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	buf := make([]byte, maxLen)
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(c.timeout)
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var nerr net.Error
+				if errors.As(err, &nerr) && nerr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			// Ignore the late answers to other requests, and forgeries
+			if n < headerLen || buf[1] != req.id || !verify(buf[:n], c.secret, &req) {
+				continue
+			}
+			return decode(buf[:n])
+		}
+	}
+	return nil, fmt.Errorf("no answer from %s", c.server)
+}
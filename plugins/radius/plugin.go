@@ -0,0 +1,388 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package radius implements a plugin authorizing the DHCPv4 clients with a
+// RADIUS server before offering them an address, for NAC-integrated DHCP.
+//
+// The plugin sends an Access-Request for the MAC address of the client, as
+// done for MAC authentication: the User-Name and User-Password are the MAC
+// address as lowercase hexadecimal digits (eg. 001122334455), the
+// Calling-Station-Id is the MAC address in the RFC 3580 format (eg.
+// 00-11-22-33-44-55), and the Service-Type is Call-Check.
+//
+// Rejected clients are not answered. For accepted clients, the attributes of
+// the Access-Accept drive the allocation:
+// - Framed-IP-Address: the address of the client
+// - Framed-Pool: the pool (see the pool argument) to allocate the address of
+// the client from
+// - Session-Timeout: the lease time, 1h by default for the pool addresses
+// Clients given an address are not handled by the following plugins. The
+// others are left to the following plugins (eg. range), which may override
+// the lease time.
+//
+// Arguments are key=value pairs:
+// - server=<host>[:port]: the RADIUS server, required. The port is 1812 by
+// default
+// - secret=<secret> or secret-file=<file>: the shared secret, required
+// - nas-identifier=<identifier>: the NAS-Identifier to send
+// - timeout=<duration>: how long to wait for an answer, 3s by default
+// - retries=<n>: how many times requests are sent again, 2 by default
+// - cache=<duration>: how long the answers are cached, 1m by default
+// - failure=<open|closed>: when the server can't be reached, either let the
+// clients through (open), or drop their requests (closed, the default)
+// - pool=<name>:<start IP>-<end IP>: an address pool, for Framed-Pool.
+// Repeatable
+//
+// server4:
+//   plugins:
+//     - radius: server=10.0.0.5 secret-file=/etc/coredhcp/radius.secret pool=guests:10.0.8.10-10.0.8.250
+//
+// The pool leases are only kept in memory.
+package radius
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/radius")
+
+// Plugin wraps the radius plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "radius",
+	Setup4: setup4,
+}
+
+// pool is an address pool, selected with Framed-Pool
+type pool struct {
+	start, end net.IP
+	allocator  *bitmap.IPv4Allocator
+}
+
+func (p *pool) contains(ip net.IP) bool {
+	ip = ip.To4()
+	return ip != nil && bytes.Compare(ip, p.start) >= 0 && bytes.Compare(ip, p.end) <= 0
+}
+
+// lease is an address allocated from a pool
+type lease struct {
+	ip      net.IP
+	pool    *pool
+	expires time.Time
+}
+
+// decision is the answer of the RADIUS server about a client
+type decision struct {
+	accept    bool
+	ip        net.IP
+	pool      string
+	leaseTime time.Duration
+	expires   time.Time
+}
+
+// PluginState holds the state of an instance of the radius plugin
+type PluginState struct {
+	sync.Mutex
+	client        *client
+	nasIdentifier string
+	cacheTime     time.Duration
+	failOpen      bool
+	pools         map[string]*pool
+	// cache holds the decisions by MAC address
+	cache map[string]*decision
+	// leases holds the pool leases by MAC address
+	leases map[string]*lease
+}
+
+func parsePool(value string) (string, *pool, error) {
+	sep := strings.IndexByte(value, ':')
+	if sep <= 0 {
+		return "", nil, fmt.Errorf("invalid pool %q, expected <name>:<start IP>-<end IP>", value)
+	}
+	name, bounds := value[:sep], strings.SplitN(value[sep+1:], "-", 2)
+	if len(bounds) != 2 {
+		return "", nil, fmt.Errorf("invalid pool %q, expected <name>:<start IP>-<end IP>", value)
+	}
+	start, end := net.ParseIP(bounds[0]).To4(), net.ParseIP(bounds[1]).To4()
+	if start == nil || end == nil {
+		return "", nil, fmt.Errorf("invalid IPv4 range in pool %q", value)
+	}
+	alloc, err := bitmap.NewIPv4Allocator(start, end)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pool %q: %w", value, err)
+	}
+	return name, &pool{start: start, end: end, allocator: alloc}, nil
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	var (
+		server string
+		secret []byte
+		err    error
+	)
+	p := PluginState{
+		client:    &client{timeout: 3 * time.Second, retries: 2},
+		cacheTime: time.Minute,
+		pools:     make(map[string]*pool),
+		cache:     make(map[string]*decision),
+		leases:    make(map[string]*lease),
+	}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "server":
+			server = value
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				server = net.JoinHostPort(strings.Trim(value, "[]"), "1812")
+			}
+		case "secret":
+			secret = []byte(value)
+		case "secret-file":
+			data, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, err
+			}
+			secret = bytes.TrimSpace(data)
+		case "nas-identifier":
+			p.nasIdentifier = value
+		case "timeout":
+			if p.client.timeout, err = time.ParseDuration(value); err != nil || p.client.timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+		case "retries":
+			if p.client.retries, err = strconv.Atoi(value); err != nil || p.client.retries < 0 {
+				return nil, fmt.Errorf("invalid number of retries %q", value)
+			}
+		case "cache":
+			if p.cacheTime, err = time.ParseDuration(value); err != nil || p.cacheTime < 0 {
+				return nil, fmt.Errorf("invalid cache duration %q", value)
+			}
+		case "failure":
+			switch value {
+			case "open":
+				p.failOpen = true
+			case "closed":
+				p.failOpen = false
+			default:
+				return nil, fmt.Errorf("invalid failure policy %q, expected open or closed", value)
+			}
+		case "pool":
+			name, pl, err := parsePool(value)
+			if err != nil {
+				return nil, err
+			}
+			for other, o := range p.pools {
+				if other == name {
+					return nil, fmt.Errorf("duplicate pool %q", name)
+				}
+				if o.contains(pl.start) || pl.contains(o.start) {
+					return nil, fmt.Errorf("pool %q overlaps pool %q", name, other)
+				}
+			}
+			p.pools[name] = pl
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if server == "" {
+		return nil, errors.New("need the RADIUS server")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("need the shared secret of the RADIUS server")
+	}
+	p.client.server, p.client.secret = server, secret
+	return &p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+// authorize asks the RADIUS server about a client
+func (p *PluginState) authorize(mac net.HardwareAddr) (*decision, error) {
+	user := []byte(hex.EncodeToString(mac))
+	station := strings.ToUpper(strings.Replace(mac.String(), ":", "-", -1))
+	serviceType := make([]byte, 4)
+	binary.BigEndian.PutUint32(serviceType, serviceTypeCallCheck)
+	attrs := []attribute{
+		{typ: attrUserName, value: user},
+		{typ: attrUserPassword, value: user},
+		{typ: attrCallingStationID, value: []byte(station)},
+		{typ: attrServiceType, value: serviceType},
+	}
+	if p.nasIdentifier != "" {
+		attrs = append(attrs, attribute{typ: attrNASIdentifier, value: []byte(p.nasIdentifier)})
+	}
+	resp, err := p.client.exchange(attrs)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.code {
+	case codeAccessReject:
+		return &decision{}, nil
+	case codeAccessAccept:
+	default:
+		return nil, fmt.Errorf("unexpected RADIUS code %d", resp.code)
+	}
+	d := decision{accept: true, pool: string(resp.get(attrFramedPool))}
+	if v := resp.get(attrFramedIPAddress); v != nil {
+		if len(v) != net.IPv4len {
+			return nil, errors.New("invalid Framed-IP-Address")
+		}
+		d.ip = net.IP(append([]byte(nil), v...))
+	}
+	if v := resp.get(attrSessionTimeout); v != nil {
+		if len(v) != 4 {
+			return nil, errors.New("invalid Session-Timeout")
+		}
+		d.leaseTime = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return &d, nil
+}
+
+// decide returns the cached decision about a client, or asks the server. The
+// caller must hold the lock
+func (p *PluginState) decide(mac net.HardwareAddr, now time.Time) (*decision, error) {
+	if d, ok := p.cache[mac.String()]; ok && now.Before(d.expires) {
+		return d, nil
+	}
+	// The lock is released while waiting for the server
+	p.Unlock()
+	d, err := p.authorize(mac)
+	p.Lock()
+	if err != nil {
+		return nil, err
+	}
+	if p.cacheTime > 0 {
+		for k, c := range p.cache {
+			if !now.Before(c.expires) {
+				delete(p.cache, k)
+			}
+		}
+		d.expires = now.Add(p.cacheTime)
+		p.cache[mac.String()] = d
+	}
+	return d, nil
+}
+
+// allocate returns the lease of a client in a pool, allocating it if needed.
+// The caller must hold the lock
+func (p *PluginState) allocate(mac string, pl *pool, leaseTime time.Duration, hint net.IP) (*lease, error) {
+	now := time.Now()
+	if l, ok := p.leases[mac]; ok {
+		if l.pool == pl {
+			l.expires = now.Add(leaseTime)
+			return l, nil
+		}
+		// The client moved to another pool
+		p.release(mac)
+	}
+	var n net.IPNet
+	if pl.contains(hint) {
+		n.IP = hint.To4()
+	}
+	ip, err := pl.allocator.Allocate(n)
+	if err != nil {
+		// Reclaim the expired leases, and try again
+		for m, l := range p.leases {
+			if l.expires.Before(now) {
+				p.release(m)
+			}
+		}
+		if ip, err = pl.allocator.Allocate(n); err != nil {
+			return nil, err
+		}
+	}
+	l := lease{ip: ip.IP.To4(), pool: pl, expires: now.Add(leaseTime)}
+	p.leases[mac] = &l
+	return &l, nil
+}
+
+// release frees the pool lease of a client, if any. The caller must hold the
+// lock
+func (p *PluginState) release(mac string) {
+	if l, ok := p.leases[mac]; ok {
+		_ = l.pool.allocator.Free(net.IPNet{IP: l.ip, Mask: net.CIDRMask(32, 32)})
+		delete(p.leases, mac)
+	}
+}
+
+// Handler4 handles DHCPv4 packets for the radius plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	mac := req.ClientHWAddr
+	p.Lock()
+	defer p.Unlock()
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	case dhcpv4.MessageTypeRelease:
+		p.release(mac.String())
+		return resp, false
+	default:
+		return resp, false
+	}
+	d, err := p.decide(mac, time.Now())
+	if err != nil {
+		if p.failOpen {
+			log.Warningf("Could not authorize MAC %s, letting it through: %v", mac, err)
+			return resp, false
+		}
+		log.Errorf("Could not authorize MAC %s, dropping the request: %v", mac, err)
+		return nil, true
+	}
+	if !d.accept {
+		log.Infof("MAC %s was rejected by the RADIUS server", mac)
+		p.release(mac.String())
+		return nil, true
+	}
+	leaseTime := d.leaseTime
+	if leaseTime > 0 {
+		resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(leaseTime))
+	}
+	switch {
+	case d.ip != nil:
+		resp.YourIPAddr = d.ip
+	case d.pool != "":
+		pl, ok := p.pools[d.pool]
+		if !ok {
+			log.Errorf("Unknown Framed-Pool %q for MAC %s", d.pool, mac)
+			return nil, true
+		}
+		if leaseTime == 0 {
+			leaseTime = time.Hour
+			resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(leaseTime))
+		}
+		l, err := p.allocate(mac.String(), pl, leaseTime, req.RequestedIPAddress())
+		if err != nil {
+			log.Errorf("Could not allocate IP for MAC %s from pool %s: %v", mac, d.pool, err)
+			return nil, true
+		}
+		resp.YourIPAddr = l.ip
+	default:
+		return resp, false
+	}
+	log.Debugf("found IP address %s for MAC %s", resp.YourIPAddr, mac)
+	return resp, true
+}
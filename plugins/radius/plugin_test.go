@@ -0,0 +1,194 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package radius
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "s3cr3t"
+
+// fakeServer is a RADIUS server answering with the attributes of users
+type fakeServer struct {
+	t    *testing.T
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	users    map[string][]attribute
+	requests int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{t: t, conn: conn, users: make(map[string][]attribute)}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	buf := make([]byte, maxLen)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := decode(buf[:n])
+		if !assert.NoError(s.t, err) {
+			continue
+		}
+		// Check the Message-Authenticator and the password of the request
+		signed := append([]byte(nil), buf[:n]...)
+		sign(signed, []byte(testSecret), req.authenticator[:])
+		assert.Equal(s.t, signed, buf[:n])
+		user := string(req.get(attrUserName))
+		assert.Equal(s.t, hidePassword([]byte(user), []byte(testSecret), req.authenticator), req.get(attrUserPassword))
+		assert.Equal(s.t, []byte{0, 0, 0, serviceTypeCallCheck}, req.get(attrServiceType))
+
+		s.mu.Lock()
+		s.requests++
+		attrs, ok := s.users[user]
+		s.mu.Unlock()
+		resp := packet{code: codeAccessReject, id: req.id, authenticator: req.authenticator}
+		if ok {
+			resp.code = codeAccessAccept
+			resp.attributes = append(resp.attributes, attrs...)
+		}
+		resp.attributes = append(resp.attributes, attribute{typ: attrMessageAuthenticator, value: make([]byte, 16)})
+		b, err := resp.encode()
+		require.NoError(s.t, err)
+		sign(b, []byte(testSecret), req.authenticator[:])
+		sum := md5.Sum(append(append([]byte(nil), b...), testSecret...))
+		copy(b[4:20], sum[:])
+		_, _ = s.conn.WriteTo(b, addr)
+	}
+}
+
+func uint32Attr(typ byte, v uint32) attribute {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return attribute{typ: typ, value: b}
+}
+
+func handle(t *testing.T, p *PluginState, mac net.HardwareAddr, mt dhcpv4.MessageType) (*dhcpv4.DHCPv4, bool) {
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(mt), dhcpv4.WithHwAddr(mac))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	return p.Handler4(req, stub)
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("server=10.0.0.5", "secret=s3cr3t", "pool=a:10.0.0.10-10.0.0.20", "pool=b:10.0.1.10-10.0.1.20")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.5:1812", p.client.server)
+	assert.Len(t, p.pools, 2)
+
+	for _, args := range [][]string{
+		{"secret=s3cr3t"},
+		{"server=10.0.0.5"},
+		{"server=10.0.0.5", "secret=s3cr3t", "retries=-1"},
+		{"server=10.0.0.5", "secret=s3cr3t", "failure=maybe"},
+		{"server=10.0.0.5", "secret=s3cr3t", "pool=10.0.0.10-10.0.0.20"},
+		{"server=10.0.0.5", "secret=s3cr3t", "pool=a:10.0.0.20-10.0.0.10"},
+		{"server=10.0.0.5", "secret=s3cr3t", "pool=a:10.0.0.10-10.0.0.20", "pool=b:10.0.0.15-10.0.0.30"},
+		{"server=10.0.0.5", "secret=s3cr3t", "vlan=10"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestPacket(t *testing.T) {
+	p := packet{code: codeAccessAccept, id: 7, attributes: []attribute{{typ: attrFramedPool, value: []byte("guests")}}}
+	b, err := p.encode()
+	require.NoError(t, err)
+	decoded, err := decode(b)
+	require.NoError(t, err)
+	assert.Equal(t, &p, decoded)
+
+	for _, b := range [][]byte{
+		{2, 7, 0},
+		append([]byte{2, 7, 0, 30}, make([]byte, 16)...),
+		append(append([]byte{2, 7, 0, 23}, make([]byte, 16)...), 88, 1, 0),
+	} {
+		_, err := decode(b)
+		assert.Error(t, err)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	srv := newFakeServer(t)
+	srv.users["001122334401"] = []attribute{{typ: attrFramedIPAddress, value: []byte{10, 0, 0, 10}}, uint32Attr(attrSessionTimeout, 600)}
+	srv.users["001122334402"] = []attribute{{typ: attrFramedPool, value: []byte("guests")}}
+	srv.users["001122334403"] = nil
+	srv.users["001122334404"] = []attribute{{typ: attrFramedPool, value: []byte("staff")}}
+	p, err := parseArgs("server="+srv.conn.LocalAddr().String(), "secret="+testSecret, "pool=guests:10.0.8.10-10.0.8.10")
+	require.NoError(t, err)
+
+	resp, stop := handle(t, p, net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x01}, dhcpv4.MessageTypeDiscover)
+	assert.True(t, stop)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 10).To4(), resp.YourIPAddr.To4())
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+
+	guest := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x02}
+	resp, stop = handle(t, p, guest, dhcpv4.MessageTypeDiscover)
+	assert.True(t, stop)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 8, 10).To4(), resp.YourIPAddr)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+	// The answer is cached
+	resp, _ = handle(t, p, guest, dhcpv4.MessageTypeRequest)
+	assert.Equal(t, net.IPv4(10, 0, 8, 10).To4(), resp.YourIPAddr)
+	srv.mu.Lock()
+	assert.Equal(t, 2, srv.requests)
+	srv.mu.Unlock()
+	handle(t, p, guest, dhcpv4.MessageTypeRelease)
+	assert.Empty(t, p.leases)
+
+	// Accepted clients without address are left to the next plugins
+	resp, stop = handle(t, p, net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x03}, dhcpv4.MessageTypeDiscover)
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+
+	// Rejected clients, and unknown pools, are dropped
+	for _, mac := range []byte{0x04, 0x05} {
+		resp, stop = handle(t, p, net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, mac}, dhcpv4.MessageTypeDiscover)
+		assert.True(t, stop)
+		assert.Nil(t, resp)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	// Nothing listens there, the requests fail
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := conn.LocalAddr().String()
+	conn.Close()
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x01}
+
+	p, err := parseArgs("server="+server, "secret="+testSecret, "timeout=10ms", "retries=1")
+	require.NoError(t, err)
+	resp, stop := handle(t, p, mac, dhcpv4.MessageTypeDiscover)
+	assert.True(t, stop)
+	assert.Nil(t, resp)
+
+	p, err = parseArgs("server="+server, "secret="+testSecret, "timeout=10ms", "failure=open")
+	require.NoError(t, err)
+	resp, stop = handle(t, p, mac, dhcpv4.MessageTypeDiscover)
+	assert.False(t, stop)
+	assert.NotNil(t, resp)
+}
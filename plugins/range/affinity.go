@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+)
+
+// The clients keep the address of their last lease, even once it expired:
+// the addresses of expired leases are only given to other clients when the
+// range, its overflow range and subnets are exhausted, the least recently
+// expired first, and only once they expired for longer than the affinity
+// window.
+
+// allocatorOf returns the allocator an address belongs to, if any
+func (p *PluginState) allocatorOf(ip net.IP) allocators.Allocator {
+	if p.inRange(ip) {
+		return p.allocator
+	}
+	if p.overflow != nil && p.overflow.contains(ip) {
+		return p.overflow.allocator
+	}
+	if s := p.subnetOf(ip); s != nil {
+		return s.allocator
+	}
+	return nil
+}
+
+// markRecords marks the addresses of the loaded leases as allocated. An
+// address found in several leases, after it was reclaimed, belongs to the
+// latest one, the others are dropped. The caller must hold the lock
+func (p *PluginState) markRecords() {
+	macs := make([]string, 0, len(p.Recordsv4))
+	for mac := range p.Recordsv4 {
+		macs = append(macs, mac)
+	}
+	sort.Slice(macs, func(i, j int) bool {
+		return p.Recordsv4[macs[i]].expires.After(p.Recordsv4[macs[j]].expires)
+	})
+	for _, mac := range macs {
+		rec := p.Recordsv4[mac]
+		alloc := p.allocatorOf(rec.IP)
+		if alloc == nil {
+			// Out of the range, eg. after it was changed: the client
+			// gets a new address when it comes back
+			continue
+		}
+		ip, err := alloc.Allocate(net.IPNet{IP: rec.IP})
+		if err == nil && ip.IP.Equal(rec.IP) {
+			continue
+		}
+		if err == nil {
+			_ = alloc.Free(ip)
+		}
+		log.Debugf("Dropping the lease of MAC %s, %s was reclaimed", mac, rec.IP)
+		delete(p.Recordsv4, mac)
+	}
+}
+
+// reclaim takes the address of the least recently expired lease, expired for
+// longer than the affinity window, from its client. The caller must hold the
+// lock
+func (p *PluginState) reclaim(now time.Time) (net.IP, error) {
+	var (
+		oldest string
+		rec    *Record
+	)
+	for mac, r := range p.Recordsv4 {
+		if !r.expires.Add(p.affinity).Before(now) || p.allocatorOf(r.IP) == nil {
+			continue
+		}
+		if rec == nil || r.expires.Before(rec.expires) {
+			oldest, rec = mac, r
+		}
+	}
+	if rec == nil {
+		return nil, allocators.ErrNoAddrAvail
+	}
+	log.Infof("Reclaiming %s from MAC %s, its lease expired at %s", rec.IP, oldest, wallTime(rec.expires).Format(time.RFC3339))
+	p.deleteRecord(oldest)
+	return rec.IP.To4(), nil
+}
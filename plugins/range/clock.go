@@ -0,0 +1,61 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import "time"
+
+// The leases end at a wall clock time, the one written to the lease file and
+// given to the IPAM and the other plugins, but the wall clock may jump, eg.
+// when NTP steps it or a virtual machine resumes. The expiries held in memory
+// carry a monotonic clock reading too (see the time package): the ones made
+// by the range from time.Now, and the loaded ones once anchored to it. The
+// leases are compared with them, so that a jump of the wall clock neither
+// expires them early nor keeps them past their end, and they are converted
+// back to the wall clock of the moment when they leave the range.
+
+// minPlausible is the earliest plausible expiry: the earlier ones were
+// written while the wall clock was not set, eg. reset to 1970 after a boot
+// without RTC
+var minPlausible = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// anchor returns a wall clock time on the monotonic clock, as of now
+func anchor(wall, now time.Time) time.Time {
+	return now.Add(wall.Sub(now.Round(0)))
+}
+
+// wallTime returns the wall clock time of t, as of now
+func wallTime(t time.Time) time.Time {
+	now := time.Now()
+	return now.Round(0).Add(t.Sub(now))
+}
+
+// roundSecond rounds t to the second, keeping its monotonic clock reading,
+// unlike t.Round
+func roundSecond(t time.Time) time.Time {
+	return t.Add(t.Round(time.Second).Sub(t))
+}
+
+// repairRecords anchors the expiries of the loaded leases to the monotonic
+// clock. The impossible ones, before minPlausible or further than the lease
+// time from now, were written while the clock was wrong: the leases are
+// given the lease time from now, to keep their addresses from the other
+// clients until they come back. The caller must hold the lock
+func (p *PluginState) repairRecords(now time.Time) {
+	repaired := 0
+	for mac, rec := range p.Recordsv4 {
+		end := now.Add(p.leaseTime(rec.IP))
+		// A second of slack for the rounding of the expiries
+		if rec.expires.Before(minPlausible) || rec.expires.After(end.Round(0).Add(time.Second)) {
+			log.Debugf("Repairing the lease of MAC %s, its expiry %s is impossible", mac, rec.expires.Format(time.RFC3339))
+			rec.expires = roundSecond(end)
+			repaired++
+			continue
+		}
+		rec.expires = anchor(rec.expires, now)
+	}
+	if repaired > 0 {
+		log.Warningf("Repaired %d leases with impossible expiries, the clock was wrong when they were written", repaired)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// exclusion is a hole of the range, or of its overflow range or subnets:
+// addresses never allocated, eg. the ones of infrastructure devices
+type exclusion struct {
+	start, end net.IP
+}
+
+// parseExclusion parses an exclusion of the form <IP>[-<IP>]
+func parseExclusion(value string) (*exclusion, error) {
+	bounds := strings.SplitN(value, "-", 2)
+	e := exclusion{start: net.ParseIP(bounds[0]).To4()}
+	e.end = e.start
+	if len(bounds) == 2 {
+		e.end = net.ParseIP(bounds[1]).To4()
+	}
+	if e.start == nil || e.end == nil || bytes.Compare(e.start, e.end) > 0 {
+		return nil, fmt.Errorf("invalid exclusion %q, expected <IP>[-<IP>]", value)
+	}
+	return &e, nil
+}
+
+func (e *exclusion) contains(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && bytes.Compare(ip4, e.start) >= 0 && bytes.Compare(ip4, e.end) <= 0
+}
+
+func (e *exclusion) String() string {
+	if e.start.Equal(e.end) {
+		return e.start.String()
+	}
+	return e.start.String() + "-" + e.end.String()
+}
+
+// excluded returns whether an address is in an exclusion
+func (p *PluginState) excluded(ip net.IP) bool {
+	for _, e := range p.exclusions {
+		if e.contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addExclusion marks the addresses of an exclusion as allocated, it must be
+// within the range, its overflow range or one of its subnets
+func (p *PluginState) addExclusion(e *exclusion) error {
+	alloc := p.allocatorOf(e.start)
+	if alloc == nil || alloc != p.allocatorOf(e.end) {
+		return fmt.Errorf("exclusion %s is not within the range, its overflow range or one of its subnets", e)
+	}
+	for _, o := range p.exclusions {
+		if o.contains(e.start) || e.contains(o.start) {
+			return fmt.Errorf("exclusion %s overlaps exclusion %s", e, o)
+		}
+	}
+	first, last := binary.BigEndian.Uint32(e.start), binary.BigEndian.Uint32(e.end)
+	for n := first; n >= first && n <= last; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		if _, err := alloc.Allocate(net.IPNet{IP: ip}); err != nil {
+			return fmt.Errorf("could not exclude %s: %w", ip, err)
+		}
+	}
+	p.exclusions = append(p.exclusions, e)
+	return nil
+}
+
+// excludedFromRange returns the number of excluded addresses of the range
+func (p *PluginState) excludedFromRange() int {
+	n := 0
+	for _, e := range p.exclusions {
+		if p.inRange(e.start) {
+			n += int(binary.BigEndian.Uint32(e.end)-binary.BigEndian.Uint32(e.start)) + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,166 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// The ranges of a plugin chain share the clients: each client is served by
+// a single range, the one holding its lease, or for new clients a range
+// selected among the ones matching their class (all the ranges without
+// class), by weighted round-robin.
+//
+// Each range is a shard of the lease state, with its own locks, so that the
+// clients of a busy range, eg. behind a single relay, do not hold back the
+// clients of the others: finding the range of a client only takes the lock of
+// the records of each range (see PluginState.records), never held while a
+// lease is allocated or written, and the group lock is only taken
+// exclusively to select a range for a new client.
+
+// assignmentTimeout bounds how long a new client is assigned to a range before
+// it gets its lease, eg. when a plugin stopped the chain before the range
+const assignmentTimeout = time.Minute
+
+type assignment struct {
+	p  *PluginState
+	at time.Time
+}
+
+// rangeGroup holds the ranges of a plugin chain
+type rangeGroup struct {
+	// RWMutex guards the ranges, read for each request
+	sync.RWMutex
+	ranges []*PluginState
+	// selecting guards the selection of the ranges of the new clients:
+	// assigned and the round-robin state of the ranges
+	selecting sync.Mutex
+	// assigned holds the ranges selected for the new clients, by MAC
+	// address, until the ranges give them a lease
+	assigned map[string]assignment
+}
+
+// groups holds the groups of ranges, by plugin chain (see plugins.Scope):
+// the ranges of the other chains serve the clients of other links
+var groups = struct {
+	sync.Mutex
+	byScope map[int]*rangeGroup
+}{byScope: make(map[int]*rangeGroup)}
+
+// register adds a range to the group of the plugin chain being set up
+func register(p *PluginState) {
+	groups.Lock()
+	defer groups.Unlock()
+	scope := plugins.Scope()
+	g, ok := groups.byScope[scope]
+	if !ok {
+		g = &rangeGroup{assigned: make(map[string]assignment)}
+		groups.byScope[scope] = g
+	}
+	g.Lock()
+	defer g.Unlock()
+	p.group = g
+	g.ranges = append(g.ranges, p)
+}
+
+// allRanges returns the ranges of all the groups
+func allRanges() []*PluginState {
+	groups.Lock()
+	defer groups.Unlock()
+	var ranges []*PluginState
+	for _, g := range groups.byScope {
+		g.RLock()
+		ranges = append(ranges, g.ranges...)
+		g.RUnlock()
+	}
+	return ranges
+}
+
+// matches returns whether a client may get a new lease from a range
+func (p *PluginState) matches(req *dhcpv4.DHCPv4) bool {
+	if len(p.classes) == 0 {
+		return true
+	}
+	for _, c := range p.classes {
+		if c.Match4(req) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PluginState) hasRecord(mac string) bool {
+	p.records.RLock()
+	defer p.records.RUnlock()
+	_, ok := p.Recordsv4[mac]
+	return ok
+}
+
+// setRecord and deleteRecord add and remove the lease of a client. The caller
+// must hold the lock
+func (p *PluginState) setRecord(mac string, rec *Record) {
+	p.records.Lock()
+	p.Recordsv4[mac] = rec
+	p.records.Unlock()
+}
+
+func (p *PluginState) deleteRecord(mac string) {
+	p.records.Lock()
+	delete(p.Recordsv4, mac)
+	p.records.Unlock()
+}
+
+// owner returns the range serving a client, nil if no range may serve it. The
+// lease of a client no longer matching the class of its range, eg. moved to
+// another link, is left to expire
+func (g *rangeGroup) owner(req *dhcpv4.DHCPv4) *PluginState {
+	mac := req.ClientHWAddr.String()
+	g.RLock()
+	defer g.RUnlock()
+	for _, p := range g.ranges {
+		if p.hasRecord(mac) && p.matches(req) {
+			// The assignment, if any, is dropped once it times out
+			return p
+		}
+	}
+	g.selecting.Lock()
+	defer g.selecting.Unlock()
+	now := time.Now()
+	// The client may have left the class of its range since, eg. moved to
+	// another link
+	if a, ok := g.assigned[mac]; ok && now.Sub(a.at) < assignmentTimeout && a.p.matches(req) {
+		return a.p
+	}
+	for m, a := range g.assigned {
+		if now.Sub(a.at) >= assignmentTimeout {
+			delete(g.assigned, m)
+		}
+	}
+	// Smooth weighted round-robin, as done by nginx
+	var (
+		selected *PluginState
+		total    int
+	)
+	for _, p := range g.ranges {
+		if !p.matches(req) {
+			continue
+		}
+		p.current += p.weight
+		total += p.weight
+		if selected == nil || p.current > selected.current {
+			selected = p
+		}
+	}
+	if selected == nil {
+		return nil
+	}
+	selected.current -= total
+	g.assigned[mac] = assignment{p: selected, at: now}
+	return selected
+}
@@ -0,0 +1,142 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/ipam"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// allocate allocates an address for a client, from the external IPAM if
+// there is one. hint is the address the client had. The caller must hold the
+// lock
+func (p *PluginState) allocate(req *dhcpv4.DHCPv4, hint net.IP) (net.IP, error) {
+	if p.ipam == nil {
+		return p.allocateShared(req)
+	}
+	lease := ipam.Lease{
+		HWAddr:   req.ClientHWAddr,
+		IP:       hint,
+		Hostname: fqdn.HostName4(req),
+		Expires:  time.Now().Add(p.LeaseTime).Round(time.Second),
+	}
+	var (
+		ip  net.IP
+		err error
+	)
+	if hint == nil {
+		// The IPAM may know the client, eg. after the lease file was lost
+		if ip, err = p.ipam.Lookup(req.ClientHWAddr); err != nil {
+			return nil, fmt.Errorf("IPAM lookup failed: %w", err)
+		}
+		if ip != nil {
+			lease.IP = ip
+			if err := p.ipam.Renew(lease); err != nil {
+				return nil, fmt.Errorf("IPAM renew failed: %w", err)
+			}
+		} else {
+			lease.IP = req.RequestedIPAddress()
+		}
+	}
+	if ip == nil {
+		if ip, err = p.ipam.Allocate(lease); err != nil {
+			return nil, fmt.Errorf("IPAM allocation failed: %w", err)
+		}
+	}
+	if !p.Contains(ip) {
+		return nil, fmt.Errorf("the IPAM returned %s, out of the range", ip)
+	}
+	if p.excluded(ip) {
+		return nil, fmt.Errorf("the IPAM returned %s, which is excluded", ip)
+	}
+	return ip.To4(), nil
+}
+
+// renewIPAM tells the external IPAM, if any, that a lease was extended. The
+// caller must hold the lock
+func (p *PluginState) renewIPAM(mac net.HardwareAddr, record *Record) {
+	if p.ipam == nil {
+		return
+	}
+	err := p.ipam.Renew(ipam.Lease{HWAddr: mac, IP: record.IP, Hostname: record.Hostname, Expires: wallTime(record.expires)})
+	if err != nil {
+		log.Errorf("Could not renew the lease of MAC %s in the IPAM: %v", mac, err)
+	}
+}
+
+// releaseIPAM returns the address of a client to the external IPAM, and ends
+// its lease. The caller must hold the lock
+func (p *PluginState) releaseIPAM(mac net.HardwareAddr) {
+	record, ok := p.Recordsv4[mac.String()]
+	if !ok || record.expires.Before(time.Now()) {
+		return
+	}
+	err := p.ipam.Release(ipam.Lease{HWAddr: mac, IP: record.IP, Hostname: record.Hostname})
+	if err != nil {
+		log.Errorf("Could not release the lease of MAC %s in the IPAM: %v", mac, err)
+		return
+	}
+	record.expires = time.Now()
+	if err := p.saveIPAddress(mac, record); err != nil {
+		log.Errorf("Could not persist lease for MAC %s: %v", mac, err)
+	}
+}
+
+// preloadProgress is how often the progress of the preloading is logged, in
+// leases
+const preloadProgress = 10000
+
+// preloadIPAM loads the active leases of the external IPAM, at most max of
+// them, the latest to expire first, for the clients the lease file does not
+// know, eg. after it was lost or when the range is new. The IPAM cache, if
+// any, is warmed up on the way. It runs at setup, before the range handles
+// requests, so that the clients coming back right after a restart do not all
+// hit the IPAM at once
+func (p *PluginState) preloadIPAM(max int) error {
+	l, ok := p.ipam.(ipam.Lister)
+	if !ok {
+		return ipam.ErrNoList
+	}
+	start := time.Now()
+	leases, err := l.Leases()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	active := leases[:0]
+	for _, lease := range leases {
+		if lease.Expires.IsZero() {
+			lease.Expires = now.Add(p.LeaseTime).Round(time.Second)
+		}
+		if lease.Expires.After(now) && p.Contains(lease.IP) && !p.excluded(lease.IP) {
+			active = append(active, lease)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool { return active[i].Expires.After(active[j].Expires) })
+	if len(active) > max {
+		log.Warningf("The IPAM has %d active leases, only preloading %d of them", len(active), max)
+		active = active[:max]
+	}
+	loaded := 0
+	for i, lease := range active {
+		if i > 0 && i%preloadProgress == 0 {
+			log.Infof("Preloaded %d of %d IPAM leases", i, len(active))
+		}
+		mac := lease.HWAddr.String()
+		if _, ok := p.Recordsv4[mac]; ok {
+			continue
+		}
+		p.Recordsv4[mac] = &Record{IP: lease.IP.To4(), expires: anchor(lease.Expires, now), Hostname: lease.Hostname}
+		loaded++
+	}
+	log.Printf("Preloaded %d leases from the IPAM in %s, %d were known already", loaded, time.Since(start).Round(time.Millisecond), len(active)-loaded)
+	return nil
+}
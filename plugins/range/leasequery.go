@@ -0,0 +1,76 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/coredhcp/coredhcp/plugins/leasequery"
+)
+
+// PluginState implements leasequery.Store so that the leases of the range are
+// available to the leasequery plugin
+var _ leasequery.Store = &PluginState{}
+
+// Contains returns whether the given IP is part of the range, of its overflow
+// range, or of the subnets of its shared network
+func (p *PluginState) Contains(ip net.IP) bool {
+	return p.inRange(ip) || p.subnetOf(ip) != nil || (p.overflow != nil && p.overflow.contains(ip))
+}
+
+func (p *PluginState) inRange(ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || p.rangeStart == nil || p.rangeEnd == nil {
+		return false
+	}
+	return bytes.Compare(ip4, p.rangeStart.To4()) >= 0 && bytes.Compare(ip4, p.rangeEnd.To4()) <= 0
+}
+
+// LookupIP returns the lease for the given IP, if there is one
+func (p *PluginState) LookupIP(ip net.IP) *leasequery.Binding {
+	p.Lock()
+	defer p.Unlock()
+	// XXX: linear scan, the records are only indexed by MAC for now
+	for mac, rec := range p.Recordsv4 {
+		if rec.IP.Equal(ip) {
+			return toBinding(mac, rec)
+		}
+	}
+	return nil
+}
+
+// LookupHWAddr returns the lease of the given MAC address, if there is one
+func (p *PluginState) LookupHWAddr(hwaddr net.HardwareAddr) []leasequery.Binding {
+	p.Lock()
+	defer p.Unlock()
+	rec, ok := p.Recordsv4[hwaddr.String()]
+	if !ok {
+		return nil
+	}
+	return []leasequery.Binding{*toBinding(hwaddr.String(), rec)}
+}
+
+// Bindings returns all the leases of the range
+func (p *PluginState) Bindings() []leasequery.Binding {
+	p.Lock()
+	defer p.Unlock()
+	bindings := make([]leasequery.Binding, 0, len(p.Recordsv4))
+	for mac, rec := range p.Recordsv4 {
+		bindings = append(bindings, *toBinding(mac, rec))
+	}
+	return bindings
+}
+
+func toBinding(mac string, rec *Record) *leasequery.Binding {
+	// The keys are always the output of net.HardwareAddr.String()
+	hwaddr, _ := net.ParseMAC(mac)
+	return &leasequery.Binding{
+		IP:              rec.IP,
+		HWAddr:          hwaddr,
+		Expires:         wallTime(rec.expires),
+		LastTransaction: rec.lastTransaction,
+	}
+}
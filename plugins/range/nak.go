@@ -0,0 +1,51 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"context"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// An authoritative range answers the DHCPREQUESTs for its addresses with a
+// DHCPNAK when the client may not use them (RFC2131 §4.3.2): the address is
+// not leased to the client, or the client moved to a network served by
+// another range of the group (see group.go). The clients then go back to
+// DISCOVER right away, instead of retrying until their lease expires.
+// Requests for the addresses of other ranges are left to them, and to the
+// authoritative setting of the server.
+
+// requested returns the address a client asks to use in a DHCPREQUEST, if
+// the range is authoritative for it, nil otherwise: the requested IP address
+// option in the SELECTING and INIT-REBOOT states, ciaddr when renewing or
+// rebinding
+func (p *PluginState) requested(req *dhcpv4.DHCPv4) net.IP {
+	if !p.authoritative || req.MessageType() != dhcpv4.MessageTypeRequest {
+		return nil
+	}
+	ip := req.RequestedIPAddress()
+	if ip == nil || ip.IsUnspecified() {
+		ip = req.ClientIPAddr
+	}
+	if ip == nil || ip.IsUnspecified() || !p.Contains(ip) {
+		return nil
+	}
+	return ip
+}
+
+// nak turns the reply into a DHCPNAK, with the reason (see handler.Refuse)
+// and the message for the client, and stops the plugin chain
+func nak(ctx context.Context, resp *dhcpv4.DHCPv4, reason, msg string) (*dhcpv4.DHCPv4, bool) {
+	log.Printf("NAK to MAC %s: %s", resp.ClientHWAddr, msg)
+	handler.Refuse(ctx, reason)
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	resp.UpdateOption(dhcpv4.OptMessage(msg))
+	resp.YourIPAddr = net.IPv4zero
+	return resp, true
+}
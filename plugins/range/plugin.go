@@ -5,11 +5,15 @@
 package rangeplugin
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,7 +21,12 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
 	"github.com/coredhcp/coredhcp/plugins/allocators"
-	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/allocators/sparse"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/fqdn"
+	"github.com/coredhcp/coredhcp/plugins/ipam"
+	"github.com/coredhcp/coredhcp/plugins/leasecrypt"
+	"github.com/coredhcp/coredhcp/plugins/leasequery"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
@@ -25,75 +34,397 @@ var log = logger.GetLogger("plugins/range")
 
 // Plugin wraps plugin registration information
 var Plugin = plugins.Plugin{
-	Name:   "range",
-	Setup4: setupRange,
+	Name:      "range",
+	Setup4Ctx: setupRange,
+	Start:     start,
+	Stop:      stop,
 }
 
 //Record holds an IP lease record
 type Record struct {
 	IP      net.IP
 	expires time.Time
+	// Hostname is the host name the client sent (see plugins/fqdn), if any
+	Hostname string
+	// lastTransaction is the time of the last DISCOVER or REQUEST of the
+	// client, for leasequery. It is not persisted: zero after a restart,
+	// until the client renews
+	lastTransaction time.Time
+	// clientID and circuitID are the identifiers bound to the lease, when
+	// verified (see verify.go). They are not persisted either: nil until
+	// bound again
+	clientID, circuitID *string
 }
 
 // PluginState is the data held by an instance of the range plugin
 type PluginState struct {
 	// Rough lock for the whole plugin, we'll get better performance once we use leasestorage
 	sync.Mutex
+	// records guards the changes of Recordsv4, made with the lock held too,
+	// so that the other ranges look the clients up without waiting for the
+	// allocations and the writes of the lease file, see group.go
+	records sync.RWMutex
 	// Recordsv4 holds a MAC -> IP address and lease time mapping
 	Recordsv4 map[string]*Record
 	LeaseTime time.Duration
 	leasefile *os.File
+	// cipher encrypts the lease file, if set
+	cipher    *leasecrypt.Cipher
 	allocator allocators.Allocator
+	// rangeStart and rangeEnd are the bounds of the range, inclusive
+	rangeStart net.IP
+	rangeEnd   net.IP
+	// sanitize is set to fix the invalid host names sent by the clients, and
+	// make them unique across leases, rather than dropping them
+	sanitize bool
+	// namePrefix, if set, generates host names for the clients without one,
+	// eg. dhcp-10-0-0-23
+	namePrefix string
+	// ipam, if set, is the external IPAM allocating the addresses instead of
+	// allocator
+	ipam ipam.Driver
+	// subnets are the other subnets of the shared network of the range
+	subnets []*subnet
+	// thresholds are the utilization percentages to warn about, in
+	// increasing order, and level the number of them crossed
+	thresholds []int
+	level      int
+	// overflow, if set, is used once the utilization of the range reaches
+	// overflowAt percent
+	overflow   *overflow
+	overflowAt int
+	// stats holds the utilization metrics of the range, if published
+	stats *expvar.Map
+	// affinity is how long the address of an expired lease is kept for its
+	// client, before it can be reclaimed for others (see affinity.go)
+	affinity time.Duration
+	// strategy is the allocation strategy, sequential if nil, see
+	// strategy.go
+	strategy strategy
+	// grace, if set, is how long expired leases are kept before they are
+	// released, see reaper.go
+	grace time.Duration
+	// reaping is set to release the expired leases
+	reaping bool
+	// lines is the number of lines of the lease file, roughly
+	lines int
+	// exclusions are the addresses never allocated
+	exclusions []*exclusion
+	// classes, if any, restrict the new leases of the range to their
+	// clients, and weight is the share of the new clients of the range
+	// among the others of the group, see group.go
+	classes []*class.Matcher
+	weight  int
+	// current is the weighted round-robin state, guarded by the selection
+	// lock of the group
+	current int
+	// group is the group of the ranges of the plugin chain, nil for the
+	// ranges not set up by setupRange, eg. in tests
+	group *rangeGroup
+	// authoritative is set to NAK the requests for addresses of the range
+	// the clients may not use, see nak.go
+	authoritative bool
+	// verify, if set, verifies that the clients own the leases they use,
+	// see verify.go
+	verify *verification
+}
+
+// hostname returns the host name to record for a client getting an address,
+// following the naming policy. The caller must hold the lock
+func (p *PluginState) hostname(req *dhcpv4.DHCPv4, ip net.IP) string {
+	name := fqdn.HostName4(req)
+	if p.sanitize {
+		name = p.unique(fqdn.Sanitize(fqdn.Name4(req)), req.ClientHWAddr.String())
+	}
+	if name == "" && p.namePrefix != "" {
+		name = p.namePrefix + "-" + strings.ReplaceAll(ip.To4().String(), ".", "-")
+	}
+	return name
+}
+
+// unique returns a host name not used by the active leases of the other
+// clients, adding a numbered suffix to name if needed
+func (p *PluginState) unique(name, mac string) string {
+	if name == "" {
+		return ""
+	}
+	now := time.Now()
+	used := func(candidate string) bool {
+		for m, rec := range p.Recordsv4 {
+			if m != mac && rec.Hostname == candidate && rec.expires.After(now) {
+				return true
+			}
+		}
+		return false
+	}
+	candidate := name
+	for n := 2; used(candidate); n++ {
+		suffix := "-" + strconv.Itoa(n)
+		base := name
+		if len(base)+len(suffix) > 63 {
+			base = strings.TrimRight(base[:63-len(suffix)], "-")
+		}
+		candidate = base + suffix
+	}
+	return candidate
 }
 
 // Handler4 handles DHCPv4 packets for the range plugin
-func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+	case dhcpv4.MessageTypeRelease:
+		// Only the external IPAMs are told about released addresses, the
+		// allocator keeps them for the clients
+		if p.ipam != nil {
+			p.Lock()
+			p.releaseIPAM(req.ClientHWAddr)
+			p.Unlock()
+		}
+		return resp, false
+	case dhcpv4.MessageTypeInform:
+		// Nothing to allocate, the client already has an address (ciaddr):
+		// it selects the subnet of the shared network whose options it
+		// gets, if any
+		if s := p.subnetOfLink(req.ClientIPAddr); s != nil {
+			s.setOptions(resp)
+		}
+		return resp, false
+	default:
+		// Nothing to allocate for the other messages: BOOTP clients (no
+		// message type) never release theirs so they are only served from
+		// static bindings (eg. the file plugin), and leasequeries are
+		// answered elsewhere
+		return resp, false
+	}
+	requested := p.requested(req)
+	// Each client is served by a single range of the group
+	if p.group != nil {
+		if o := p.group.owner(req); o != p {
+			if requested == nil {
+				return resp, false
+			}
+			reason := handler.ReasonWrongNetwork
+			if o == nil {
+				reason = handler.ReasonClassMismatch
+			}
+			return nak(ctx, resp, reason, fmt.Sprintf("%s is not on the network of the client", requested))
+		}
+	}
 	p.Lock()
 	defer p.Unlock()
 	record, ok := p.Recordsv4[req.ClientHWAddr.String()]
+	expired := ok && !record.expires.After(time.Now())
+	if p.verify != nil {
+		if reason := p.conflict(req, record, time.Now()); reason != "" {
+			p.stats.Add("conflicts", 1)
+			log.Warningf("Conflicting request from MAC %s: %s", req.ClientHWAddr, reason)
+			if req.MessageType() == dhcpv4.MessageTypeRequest {
+				return nak(ctx, resp, handler.ReasonLeaseConflict, reason)
+			}
+			handler.Refuse(ctx, handler.ReasonLeaseConflict)
+			return nil, true
+		}
+	}
+	if requested != nil && (!ok || !record.IP.Equal(requested)) {
+		return nak(ctx, resp, handler.ReasonNotLeased, fmt.Sprintf("%s is not leased to the client", requested))
+	}
 	if !ok {
 		// Allocating new address since there isn't one allocated
 		log.Printf("MAC address %s is new, leasing new IPv4 address", req.ClientHWAddr.String())
-		ip, err := p.allocator.Allocate(net.IPNet{})
+		ip, err := p.allocate(req, nil)
 		if err != nil {
 			log.Errorf("Could not allocate IP for MAC %s: %v", req.ClientHWAddr.String(), err)
+			refuseAllocation(ctx, err)
 			return nil, true
 		}
 		rec := Record{
-			IP:      ip.IP.To4(),
-			expires: time.Now().Add(p.LeaseTime),
+			IP:       ip,
+			expires:  time.Now().Add(p.leaseTime(ip)),
+			Hostname: p.hostname(req, ip),
 		}
 		err = p.saveIPAddress(req.ClientHWAddr, &rec)
 		if err != nil {
 			log.Errorf("SaveIPAddress for MAC %s failed: %v", req.ClientHWAddr.String(), err)
 		}
-		p.Recordsv4[req.ClientHWAddr.String()] = &rec
+		p.setRecord(req.ClientHWAddr.String(), &rec)
 		record = &rec
+		p.checkUtilization(time.Now())
 	} else {
+		// The external IPAMs reclaim the expired addresses, ask for it again
+		reallocate := p.ipam != nil && record.expires.Before(time.Now())
+		if reallocate {
+			ip, err := p.allocate(req, record.IP)
+			if err != nil {
+				log.Errorf("Could not allocate IP for MAC %s: %v", req.ClientHWAddr.String(), err)
+				refuseAllocation(ctx, err)
+				return nil, true
+			}
+			record.IP = ip
+			p.checkUtilization(time.Now())
+		}
 		// Ensure we extend the existing lease at least past when the one we're giving expires
-		if record.expires.Before(time.Now().Add(p.LeaseTime)) {
-			record.expires = time.Now().Add(p.LeaseTime).Round(time.Second)
+		hostname := p.hostname(req, record.IP)
+		leaseTime := p.leaseTime(record.IP)
+		extend := record.expires.Before(time.Now().Add(leaseTime))
+		if extend {
+			record.expires = roundSecond(time.Now().Add(leaseTime))
+			if !reallocate {
+				p.renewIPAM(req.ClientHWAddr, record)
+			}
+		}
+		if extend || hostname != record.Hostname {
+			record.Hostname = hostname
 			err := p.saveIPAddress(req.ClientHWAddr, record)
 			if err != nil {
 				log.Errorf("Could not persist lease for MAC %s: %v", req.ClientHWAddr.String(), err)
 			}
 		}
 	}
+	record.lastTransaction = time.Now()
+	if p.verify != nil {
+		p.bind(req, record, expired)
+	}
 	resp.YourIPAddr = record.IP
-	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.LeaseTime.Round(time.Second)))
+	resp.Options.Update(dhcpv4.OptIPAddressLeaseTime(p.leaseTime(record.IP).Round(time.Second)))
+	p.setSubnetOptions(resp, record.IP)
+	// Tell the client when its name was changed or generated
+	if record.Hostname != "" && record.Hostname != fqdn.Name4(req) {
+		resp.Options.Update(dhcpv4.OptHostName(record.Hostname))
+	}
 	log.Printf("found IP address %s for MAC %s", record.IP, req.ClientHWAddr.String())
 	return resp, false
 }
 
-func setupRange(args ...string) (handler.Handler4, error) {
+// refuseAllocation tells why a request is dropped for want of an address:
+// the pool is exhausted, or the allocation failed
+func refuseAllocation(ctx context.Context, err error) {
+	if errors.Is(err, allocators.ErrNoAddrAvail) {
+		handler.Refuse(ctx, handler.ReasonPoolExhausted)
+		return
+	}
+	handler.ReportError(ctx, err)
+}
+
+func setupRange(args ...string) (handler.Handler4Ctx, error) {
 	var (
-		err error
-		p   PluginState
+		err        error
+		p          PluginState
+		subnets    []*subnet
+		exclusions []*exclusion
+		ipamSpec   string
+		cacheSpec  string
+		preload    int
 	)
+	p.thresholds, p.overflowAt, p.strategy, p.weight = defaultThresholds, 95, sequential{}, 1
 
 	if len(args) < 4 {
 		return nil, fmt.Errorf("invalid number of arguments, want: 4 (file name, start IP, end IP, lease time), got: %d", len(args))
 	}
+	for _, arg := range args[4:] {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "sanitize":
+			if p.sanitize, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid sanitize value %q", value)
+			}
+		case "ipam":
+			if p.ipam, err = ipam.New(value); err != nil {
+				return nil, fmt.Errorf("invalid IPAM: %w", err)
+			}
+			ipamSpec = value
+		case "ipam-cache":
+			cacheSpec = value
+		case "preload":
+			if preload, err = strconv.Atoi(value); err != nil || preload <= 0 {
+				return nil, fmt.Errorf("invalid number of leases to preload %q", value)
+			}
+		case "subnet":
+			s, err := parseSubnet(value)
+			if err != nil {
+				return nil, err
+			}
+			subnets = append(subnets, s)
+		case "thresholds":
+			if p.thresholds, err = parseThresholds(value); err != nil {
+				return nil, err
+			}
+		case "overflow":
+			if p.overflow, err = parseOverflow(value); err != nil {
+				return nil, err
+			}
+		case "overflow-at":
+			if p.overflowAt, err = strconv.Atoi(strings.TrimSuffix(value, "%")); err != nil || p.overflowAt <= 0 || p.overflowAt > 100 {
+				return nil, fmt.Errorf("invalid overflow threshold %q, expected a percentage", value)
+			}
+		case "affinity":
+			if p.affinity, err = time.ParseDuration(value); err != nil || p.affinity < 0 {
+				return nil, fmt.Errorf("invalid affinity window %q", value)
+			}
+		case "allocation":
+			newStrategy, ok := strategies[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid allocation strategy %q, expected %s", value, strategyNames())
+			}
+			p.strategy = newStrategy()
+		case "grace":
+			if p.grace, err = time.ParseDuration(value); err != nil || p.grace < 0 {
+				return nil, fmt.Errorf("invalid grace period %q", value)
+			}
+			p.reaping = true
+		case "class":
+			m, err := class.Parse(value)
+			if err != nil {
+				return nil, err
+			}
+			p.classes = append(p.classes, m)
+		case "authoritative":
+			if p.authoritative, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid authoritative value %q", value)
+			}
+		case "verify":
+			if p.verify, err = parseVerify(value); err != nil {
+				return nil, err
+			}
+		case "weight":
+			if p.weight, err = strconv.Atoi(value); err != nil || p.weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q", value)
+			}
+		case "exclude":
+			e, err := parseExclusion(value)
+			if err != nil {
+				return nil, err
+			}
+			exclusions = append(exclusions, e)
+		case "key":
+			if p.cipher, err = leasecrypt.Load(value); err != nil {
+				return nil, err
+			}
+		case "generate":
+			if fqdn.HostName(value) != value {
+				return nil, fmt.Errorf("invalid host name prefix %q", value)
+			}
+			p.namePrefix = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if cacheSpec != "" {
+		if p.ipam == nil {
+			return nil, errors.New("cannot use an IPAM cache without an IPAM")
+		}
+		size, ttl, negativeTTL, err := ipam.ParseCache(cacheSpec)
+		if err != nil {
+			return nil, err
+		}
+		p.ipam = ipam.NewCache(p.ipam, ipamSpec, size, ttl, negativeTTL)
+	}
+	if preload > 0 && p.ipam == nil {
+		return nil, errors.New("cannot preload the leases without an IPAM")
+	}
 	filename := args[0]
 	if filename == "" {
 		return nil, errors.New("file name cannot be empty")
@@ -110,26 +441,94 @@ func setupRange(args ...string) (handler.Handler4, error) {
 		return nil, errors.New("start of IP range has to be lower than the end of an IP range")
 	}
 
-	p.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
+	p.rangeStart, p.rangeEnd = ipRangeStart.To4(), ipRangeEnd.To4()
+	p.allocator, err = sparse.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
 	if err != nil {
 		return nil, fmt.Errorf("could not create an allocator: %w", err)
 	}
+	if p.overflow != nil {
+		if p.ipam != nil {
+			return nil, errors.New("cannot use an overflow range with an IPAM")
+		}
+		if p.overflow.contains(p.rangeStart) || p.inRange(p.overflow.start) || p.inRange(p.overflow.end) {
+			return nil, errors.New("the overflow range overlaps the range")
+		}
+	}
+	for _, s := range subnets {
+		if err := p.addSubnet(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range exclusions {
+		if err := p.addExclusion(e); err != nil {
+			return nil, err
+		}
+	}
 
 	p.LeaseTime, err = time.ParseDuration(args[3])
 	if err != nil {
 		return nil, fmt.Errorf("invalid lease duration: %v", args[3])
 	}
 
-	p.Recordsv4, err = loadRecordsFromFile(filename)
+	p.Recordsv4, err = loadRecordsFromFile(filename, p.cipher)
 	if err != nil {
 		return nil, fmt.Errorf("could not load records from file: %v", err)
 	}
 
+	p.repairRecords(time.Now())
+	if p.ipam == nil {
+		p.markRecords()
+	}
 	log.Printf("Loaded %d DHCPv4 leases from %s", len(p.Recordsv4), filename)
+	if preload > 0 {
+		// The clients are still looked up in the IPAM if it failed
+		if err := p.preloadIPAM(preload); err != nil {
+			log.Errorf("Could not preload the leases of the IPAM: %v", err)
+		}
+	}
+
+	name := p.rangeStart.String() + "-" + p.rangeEnd.String()
+	if stats, ok := metrics.Get(name).(*expvar.Map); ok {
+		p.stats = stats
+	} else {
+		p.stats = new(expvar.Map).Init()
+		metrics.Set(name, p.stats)
+	}
+	p.checkUtilization(time.Now())
 
 	if err := p.registerBackingFile(filename); err != nil {
 		return nil, fmt.Errorf("could not setup lease storage: %w", err)
 	}
+	leasequery.RegisterStore(&p)
+	register(&p)
+	if p.reaping {
+		p.lines = len(p.Recordsv4)
+	}
 
 	return p.Handler4, nil
 }
+
+// start starts the reapers of the ranges
+func start(ctx context.Context) error {
+	for _, p := range allRanges() {
+		if p.reaping {
+			go p.reaper(ctx)
+		}
+	}
+	return nil
+}
+
+// stop closes the lease files of the ranges
+func stop() error {
+	var err error
+	for _, p := range allRanges() {
+		p.Lock()
+		if p.leasefile != nil {
+			if cerr := p.leasefile.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		p.Unlock()
+	}
+	return err
+}
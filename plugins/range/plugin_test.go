@@ -0,0 +1,1020 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/bitmap"
+	"github.com/coredhcp/coredhcp/plugins/ipam"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+// resetGroup empties the groups of ranges, for the tests setting ranges up
+func resetGroup(t *testing.T) {
+	reset := func() {
+		groups.Lock()
+		groups.byScope = make(map[int]*rangeGroup)
+		groups.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// currentGroup returns the group of the ranges set up last
+func currentGroup(t *testing.T) *rangeGroup {
+	groups.Lock()
+	defer groups.Unlock()
+	g := groups.byScope[plugins.Scope()]
+	require.NotNil(t, g)
+	return g
+}
+
+func TestInformDoesNotAllocate(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4: make(map[string]*Record),
+		LeaseTime: time.Hour,
+		allocator: alloc,
+	}
+
+	hwaddr := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	req, err := dhcpv4.NewInform(hwaddr, net.IPv4(10, 0, 0, 15))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler4(context.Background(), req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified(), "INFORM reply must not carry an address")
+	assert.False(t, resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime))
+	assert.Empty(t, p.Recordsv4, "INFORM must not create a lease")
+}
+
+func TestInformSubnet(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		rangeStart: net.IPv4(10, 0, 0, 10),
+		rangeEnd:   net.IPv4(10, 0, 0, 20),
+	}
+	s, err := parseSubnet("10.0.1.0/24:10.0.1.10-10.0.1.20:10.0.1.1")
+	require.NoError(t, err)
+	require.NoError(t, p.addSubnet(s))
+
+	hwaddr := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	// A static address of the subnet, outside of its pool
+	req, err := dhcpv4.NewInform(hwaddr, net.IPv4(10, 0, 1, 5))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithRouter(net.IPv4(10, 0, 0, 1)))
+	require.NoError(t, err)
+	resp, stop := p.Handler4(context.Background(), req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 1, 1).To4()}, resp.Router())
+	assert.Equal(t, net.CIDRMask(24, 32), resp.SubnetMask())
+	assert.Empty(t, p.Recordsv4, "INFORM must not create a lease")
+
+	// The clients of the range keep the options of the previous plugins
+	req, err = dhcpv4.NewInform(hwaddr, net.IPv4(10, 0, 0, 15))
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req, dhcpv4.WithRouter(net.IPv4(10, 0, 0, 1)))
+	require.NoError(t, err)
+	resp, _ = p.Handler4(context.Background(), req, stub)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+	assert.Nil(t, resp.SubnetMask())
+}
+
+func TestBOOTPDoesNotAllocate(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4: make(map[string]*Record),
+		LeaseTime: time.Hour,
+		allocator: alloc,
+	}
+
+	req, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}))
+	require.NoError(t, err)
+	require.Equal(t, dhcpv4.MessageTypeNone, req.MessageType())
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := p.Handler4(context.Background(), req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified(), "range must not serve BOOTP clients")
+	assert.Empty(t, p.Recordsv4)
+}
+
+func TestHostnamePolicy(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		sanitize:   true,
+		namePrefix: "dhcp",
+	}
+
+	request := func(mac byte, hostname string) *dhcpv4.DHCPv4 {
+		modifiers := []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		}
+		if hostname != "" {
+			modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptHostName(hostname)))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, stop := p.Handler4(context.Background(), req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		return resp
+	}
+
+	// Valid names are kept, and not sent back
+	resp := request(1, "host")
+	assert.Equal(t, "host", p.Recordsv4["aa:bb:cc:dd:ee:01"].Hostname)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+
+	// Invalid and duplicate names are fixed
+	resp = request(2, "Host")
+	assert.Equal(t, "host-2", p.Recordsv4["aa:bb:cc:dd:ee:02"].Hostname)
+	assert.Equal(t, "host-2", resp.HostName())
+	resp = request(3, "my_host")
+	assert.Equal(t, "my-host", resp.HostName())
+
+	// Clients keep their name when renewing
+	resp = request(1, "host")
+	assert.Equal(t, "host", p.Recordsv4["aa:bb:cc:dd:ee:01"].Hostname)
+
+	// Names are generated from the address
+	resp = request(4, "")
+	assert.Equal(t, "dhcp-"+strings.ReplaceAll(resp.YourIPAddr.String(), ".", "-"), resp.HostName())
+	assert.Equal(t, resp.HostName(), p.Recordsv4["aa:bb:cc:dd:ee:04"].Hostname)
+}
+
+func TestSetupArguments(t *testing.T) {
+	resetGroup(t)
+	for _, args := range [][]string{
+		{"sanitize"},
+		{"sanitize=maybe"},
+		{"generate=-dhcp"},
+		{"generate=dhcp.example"},
+		{"prefix=dhcp"},
+		{"ipam=phpipam:https://ipam.example.org"},
+		{"ipam=http:ipam.example.org"},
+		{"ipam-cache=100"},
+		{"ipam=http:https://ipam.example.org", "ipam-cache=0"},
+		{"ipam=http:https://ipam.example.org", "ipam-cache=100:1m:10s:1s"},
+		{"preload=100"},
+		{"ipam=http:https://ipam.example.org", "preload=0"},
+		{"thresholds=80,101"},
+		{"overflow-at=0"},
+		{"overflow=10.0.1.10-10.0.1.20"},
+		{"overflow=10.0.1.10-10.0.1.20:0s"},
+		{"overflow=10.0.0.15-10.0.0.30:10m"},
+		{"overflow=10.0.1.10-10.0.1.20:10m", "ipam=http:https://ipam.example.org"},
+		{"overflow=10.0.1.10-10.0.1.20:10m", "subnet=10.0.1.0/24:10.0.1.20-10.0.1.30"},
+		{"affinity=-1h"},
+		{"allocation=weighted"},
+		{"grace=forever"},
+		{"exclude=10.0.0.30"},
+		{"exclude=10.0.0.15-10.0.0.12"},
+		{"exclude=10.0.0.15-10.0.0.25"},
+		{"exclude=10.0.0.12-10.0.0.15", "exclude=10.0.0.15"},
+		{"class=vendor"},
+		{"weight=0"},
+		{"authoritative=maybe"},
+		{"subnet=10.0.1.0/24"},
+		{"subnet=10.0.1.0/24:10.0.2.10-10.0.2.20"},
+		{"subnet=10.0.1.0/24:10.0.1.20-10.0.1.10"},
+		{"subnet=10.0.1.0/24:10.0.1.10-10.0.1.20:10.0.2.1"},
+		{"subnet=10.0.0.0/24:10.0.0.15-10.0.0.30"},
+		{"subnet=10.0.1.0/24:10.0.1.10-10.0.1.20", "subnet=10.0.1.0/24:10.0.1.20-10.0.1.30"},
+	} {
+		_, err := setupRange(append([]string{"leases.txt", "10.0.0.10", "10.0.0.20", "1h"}, args...)...)
+		assert.Error(t, err, args)
+	}
+}
+
+// fakeIPAM is an IPAM handing out 10.0.0.15, and recording the calls
+type fakeIPAM struct {
+	calls []string
+	ip    net.IP
+}
+
+func (f *fakeIPAM) Lookup(hwaddr net.HardwareAddr) (net.IP, error) {
+	f.calls = append(f.calls, "lookup "+hwaddr.String())
+	return nil, nil
+}
+
+func (f *fakeIPAM) Allocate(lease ipam.Lease) (net.IP, error) {
+	f.calls = append(f.calls, fmt.Sprintf("allocate %s %s", lease.HWAddr, lease.IP))
+	return f.ip, nil
+}
+
+func (f *fakeIPAM) Renew(lease ipam.Lease) error {
+	f.calls = append(f.calls, fmt.Sprintf("renew %s %s", lease.HWAddr, lease.IP))
+	return nil
+}
+
+func (f *fakeIPAM) Release(lease ipam.Lease) error {
+	f.calls = append(f.calls, fmt.Sprintf("release %s %s", lease.HWAddr, lease.IP))
+	return nil
+}
+
+func TestIPAM(t *testing.T) {
+	driver := &fakeIPAM{ip: net.IPv4(10, 0, 0, 15)}
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 20).To4(),
+		ipam:       driver,
+	}
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	handle := func(mt dhcpv4.MessageType) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(dhcpv4.WithMessageType(mt), dhcpv4.WithHwAddr(mac))
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(context.Background(), req, stub)
+		return resp
+	}
+
+	resp := handle(dhcpv4.MessageTypeDiscover)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 15).To4(), resp.YourIPAddr)
+	// The lease is renewed when extended
+	p.Recordsv4[mac.String()].expires = time.Now().Add(time.Minute)
+	handle(dhcpv4.MessageTypeRequest)
+	// Released leases are allocated again
+	handle(dhcpv4.MessageTypeRelease)
+	handle(dhcpv4.MessageTypeRequest)
+	assert.Equal(t, []string{
+		"lookup aa:bb:cc:dd:ee:ff",
+		"allocate aa:bb:cc:dd:ee:ff <nil>",
+		"renew aa:bb:cc:dd:ee:ff 10.0.0.15",
+		"release aa:bb:cc:dd:ee:ff 10.0.0.15",
+		"allocate aa:bb:cc:dd:ee:ff 10.0.0.15",
+	}, driver.calls)
+
+	// Addresses out of the range are refused
+	driver.ip = net.IPv4(10, 0, 1, 15)
+	mac = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x00}
+	assert.Nil(t, handle(dhcpv4.MessageTypeDiscover))
+}
+
+// listingIPAM is a fakeIPAM listing leases
+type listingIPAM struct {
+	fakeIPAM
+	leases []ipam.Lease
+}
+
+func (l *listingIPAM) Leases() ([]ipam.Lease, error) {
+	return l.leases, nil
+}
+
+func TestIPAMPreload(t *testing.T) {
+	now := time.Now()
+	mac := func(b byte) net.HardwareAddr { return net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, b} }
+	driver := &listingIPAM{leases: []ipam.Lease{
+		{HWAddr: mac(1), IP: net.IPv4(10, 0, 0, 11), Expires: now.Add(time.Minute)},
+		{HWAddr: mac(2), IP: net.IPv4(10, 0, 0, 12), Expires: now.Add(-time.Minute)},
+		{HWAddr: mac(3), IP: net.IPv4(10, 0, 1, 13), Expires: now.Add(time.Hour)},
+		{HWAddr: mac(4), IP: net.IPv4(10, 0, 0, 14), Expires: now.Add(time.Hour)},
+		{HWAddr: mac(5), IP: net.IPv4(10, 0, 0, 15), Hostname: "node-5"},
+		{HWAddr: mac(6), IP: net.IPv4(10, 0, 0, 16), Expires: now.Add(2 * time.Hour)},
+	}}
+	p := PluginState{
+		Recordsv4:  map[string]*Record{mac(6).String(): {IP: net.IPv4(10, 0, 0, 16)}},
+		LeaseTime:  time.Hour,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 20).To4(),
+		ipam:       driver,
+	}
+	// The latest to expire are preloaded, the expired ones and the ones out
+	// of the range skipped
+	require.NoError(t, p.preloadIPAM(3))
+	assert.Len(t, p.Recordsv4, 3)
+	assert.Contains(t, p.Recordsv4, mac(4).String())
+	assert.Equal(t, "node-5", p.Recordsv4[mac(5).String()].Hostname)
+	assert.Empty(t, driver.calls)
+
+	p.ipam = &driver.fakeIPAM
+	assert.Equal(t, ipam.ErrNoList, p.preloadIPAM(3))
+}
+
+func TestSharedNetwork(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 11))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 11).To4(),
+	}
+	for _, value := range []string{"10.0.1.0/24:10.0.1.10-10.0.1.10:10.0.1.1", "10.0.2.0/25:10.0.2.10-10.0.2.20"} {
+		s, err := parseSubnet(value)
+		require.NoError(t, err)
+		require.NoError(t, p.addSubnet(s))
+	}
+
+	handle := func(mac byte) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		)
+		require.NoError(t, err)
+		// As set by the netmask and router plugins for the range
+		stub, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+			dhcpv4.WithRouter(net.IPv4(10, 0, 0, 1)),
+		)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(context.Background(), req, stub)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// The range is used first, with the options of the previous plugins
+	for mac := byte(1); mac <= 2; mac++ {
+		resp := handle(mac)
+		assert.True(t, p.inRange(resp.YourIPAddr), resp.YourIPAddr)
+		assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+		assert.Nil(t, resp.BroadcastAddress())
+	}
+
+	// Then the subnets, in order, with their own options
+	for i := 0; i < 2; i++ {
+		resp := handle(3)
+		assert.Equal(t, net.IPv4(10, 0, 1, 10).To4(), resp.YourIPAddr)
+		assert.Equal(t, net.CIDRMask(24, 32), resp.SubnetMask())
+		assert.Equal(t, net.IPv4(10, 0, 1, 255).To4(), resp.BroadcastAddress().To4())
+		assert.Equal(t, []net.IP{net.IPv4(10, 0, 1, 1).To4()}, resp.Router())
+	}
+	resp := handle(4)
+	assert.True(t, p.subnets[1].contains(resp.YourIPAddr), resp.YourIPAddr)
+	assert.Equal(t, net.CIDRMask(25, 32), resp.SubnetMask())
+	assert.Equal(t, net.IPv4(10, 0, 2, 127).To4(), resp.BroadcastAddress().To4())
+	assert.Nil(t, resp.Router())
+	assert.True(t, p.Contains(resp.YourIPAddr))
+}
+
+func TestUtilization(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 19))
+	require.NoError(t, err)
+	o, err := parseOverflow("10.0.1.10-10.0.1.11:5m")
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 19).To4(),
+		thresholds: []int{50, 80},
+		overflow:   o,
+		overflowAt: 80,
+		stats:      new(expvar.Map).Init(),
+	}
+
+	handle := func(mac byte) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(context.Background(), req, stub)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	for mac := byte(1); mac <= 5; mac++ {
+		assert.True(t, p.inRange(handle(mac).YourIPAddr))
+	}
+	assert.Equal(t, 1, p.level)
+	for mac := byte(6); mac <= 8; mac++ {
+		assert.True(t, p.inRange(handle(mac).YourIPAddr))
+	}
+	assert.Equal(t, 2, p.level)
+	assert.Equal(t, "8", p.stats.Get("used").String())
+	assert.Equal(t, "0.8", p.stats.Get("utilization").String())
+
+	// The overflow range is used once the range is nearly exhausted, with
+	// shorter leases
+	for mac := byte(9); mac <= 10; mac++ {
+		resp := handle(mac)
+		assert.True(t, o.contains(resp.YourIPAddr), resp.YourIPAddr)
+		assert.Equal(t, 5*time.Minute, resp.IPAddressLeaseTime(0))
+	}
+	assert.Equal(t, "2", p.stats.Get("overflow_used").String())
+	// And the range again once it is exhausted
+	resp := handle(11)
+	assert.True(t, p.inRange(resp.YourIPAddr))
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+
+	// Expired leases don't count
+	for _, rec := range p.Recordsv4 {
+		rec.expires = time.Now().Add(-time.Minute)
+	}
+	p.checkUtilization(time.Now())
+	assert.Equal(t, 0, p.level)
+	assert.Equal(t, "0", p.stats.Get("used").String())
+}
+
+func TestAffinity(t *testing.T) {
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 11))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 11).To4(),
+		affinity:   time.Hour,
+	}
+
+	handle := func(mac byte) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(context.Background(), req, stub)
+		return resp
+	}
+
+	first, second := handle(1).YourIPAddr, handle(2).YourIPAddr
+	p.Recordsv4["aa:bb:cc:dd:ee:01"].expires = time.Now().Add(-2 * time.Hour)
+	p.Recordsv4["aa:bb:cc:dd:ee:02"].expires = time.Now().Add(-30 * time.Minute)
+
+	// The range is exhausted, the lease expired for longer than the affinity
+	// window is reclaimed
+	resp := handle(3)
+	require.NotNil(t, resp)
+	assert.Equal(t, first, resp.YourIPAddr)
+	assert.Nil(t, handle(4))
+	assert.Nil(t, handle(1))
+
+	// The other client keeps its address after its lease expired
+	resp = handle(2)
+	require.NotNil(t, resp)
+	assert.Equal(t, second, resp.YourIPAddr)
+}
+
+func TestLoadedLeasesAreAllocated(t *testing.T) {
+	resetGroup(t)
+	f, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	// 10.0.0.10 was reclaimed from the first client
+	_, err = f.WriteString(`aa:bb:cc:dd:ee:01 10.0.0.10 2000-01-01T00:00:00Z
+aa:bb:cc:dd:ee:02 10.0.0.10 2000-01-02T00:00:00Z
+aa:bb:cc:dd:ee:03 10.0.0.12 2000-01-01T00:00:00Z
+`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	handler, err := setupRange(f.Name(), "10.0.0.10", "10.0.0.12", "1h", "affinity=87600h")
+	require.NoError(t, err)
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x04}),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ := handler(context.Background(), req, stub)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 11).To4(), resp.YourIPAddr)
+}
+
+func TestRepairRecords(t *testing.T) {
+	now := time.Now()
+	wall := now.Add(30 * time.Minute).Round(0)
+	p := PluginState{
+		Recordsv4: map[string]*Record{
+			"aa:bb:cc:dd:ee:01": {IP: net.IPv4(10, 0, 0, 10), expires: wall},
+			"aa:bb:cc:dd:ee:02": {IP: net.IPv4(10, 0, 0, 11), expires: time.Unix(3600, 0)},
+			"aa:bb:cc:dd:ee:03": {IP: net.IPv4(10, 0, 0, 12), expires: now.Add(48 * time.Hour).Round(0)},
+			"aa:bb:cc:dd:ee:04": {IP: net.IPv4(10, 0, 0, 13), expires: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		LeaseTime: time.Hour,
+	}
+	p.repairRecords(now)
+
+	// The plausible expiries are kept, on the monotonic clock
+	rec := p.Recordsv4["aa:bb:cc:dd:ee:01"]
+	assert.Equal(t, 30*time.Minute, rec.expires.Sub(now))
+	assert.NotEqual(t, rec.expires, rec.expires.Round(0), "the expiry should have a monotonic clock reading")
+	assert.WithinDuration(t, wall, wallTime(rec.expires), time.Millisecond)
+	assert.True(t, p.Recordsv4["aa:bb:cc:dd:ee:04"].expires.Equal(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	// The impossible ones get the lease time
+	for _, mac := range []string{"aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:03"} {
+		assert.InDelta(t, float64(time.Hour), float64(p.Recordsv4[mac].expires.Sub(now)), float64(time.Second), mac)
+	}
+	rounded := roundSecond(now)
+	assert.Equal(t, 0, rounded.Nanosecond())
+	assert.NotEqual(t, rounded, rounded.Round(0), "roundSecond should keep the monotonic clock reading")
+}
+
+func TestHashAllocation(t *testing.T) {
+	newState := func() *PluginState {
+		alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 109))
+		require.NoError(t, err)
+		return &PluginState{
+			Recordsv4:  make(map[string]*Record),
+			LeaseTime:  time.Hour,
+			allocator:  alloc,
+			rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+			rangeEnd:   net.IPv4(10, 0, 0, 109).To4(),
+			strategy:   hashed{},
+		}
+	}
+	handle := func(p *PluginState, mac byte, modifiers ...dhcpv4.Modifier) net.IP {
+		req, err := dhcpv4.New(append(modifiers,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		)...)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := p.Handler4(context.Background(), req, stub)
+		require.NotNil(t, resp)
+		return resp.YourIPAddr
+	}
+
+	// Clients get the same address from a new state, eg. after the lease
+	// file was lost
+	p, q := newState(), newState()
+	for mac := byte(1); mac <= 20; mac++ {
+		assert.Equal(t, handle(p, mac), handle(q, mac))
+	}
+
+	// The client identifier comes before the MAC address
+	id := dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{1, 2, 3}))
+	p, q = newState(), newState()
+	ip := handle(p, 1, id)
+	assert.Equal(t, ip, handle(q, 2, id))
+
+	// Taken addresses are skipped to the next one
+	q = newState()
+	_, err := q.allocator.Allocate(net.IPNet{IP: ip})
+	require.NoError(t, err)
+	next := make(net.IP, net.IPv4len)
+	copy(next, ip)
+	next[3]++
+	if next[3] > 109 {
+		next[3] = 10
+	}
+	assert.Equal(t, next, handle(q, 1, id))
+}
+
+func TestRandomAllocation(t *testing.T) {
+	start, end := net.IPv4(10, 0, 0, 10).To4(), net.IPv4(10, 0, 0, 250).To4()
+	alloc, err := bitmap.NewIPv4Allocator(start, end)
+	require.NoError(t, err)
+	p := PluginState{strategy: random{}}
+	req, err := dhcpv4.New()
+	require.NoError(t, err)
+	sequential := true
+	for i := 0; i < 10; i++ {
+		ip, err := p.allocateFrom(alloc, req, start, end)
+		require.NoError(t, err)
+		assert.True(t, ipToUint32(ip.IP) >= ipToUint32(start) && ipToUint32(ip.IP) <= ipToUint32(end), ip.IP)
+		sequential = sequential && ip.IP.Equal(uint32ToIP(ipToUint32(start)+uint32(i)))
+	}
+	assert.False(t, sequential)
+}
+
+func TestLRUAllocation(t *testing.T) {
+	start, end := net.IPv4(10, 0, 0, 10).To4(), net.IPv4(10, 0, 0, 13).To4()
+	alloc, err := bitmap.NewIPv4Allocator(start, end)
+	require.NoError(t, err)
+	// A lease loaded from the lease file
+	_, err = alloc.Allocate(net.IPNet{IP: net.IPv4(10, 0, 0, 11)})
+	require.NoError(t, err)
+	p := PluginState{strategy: strategies[allocLRU]()}
+	req, err := dhcpv4.New()
+	require.NoError(t, err)
+	allocate := func() string {
+		ip, err := p.allocateFrom(alloc, req, start, end)
+		require.NoError(t, err)
+		return ip.IP.String()
+	}
+	release := func(ip string) {
+		require.NoError(t, alloc.Free(net.IPNet{IP: net.ParseIP(ip).To4(), Mask: net.CIDRMask(32, 32)}))
+		p.released(net.ParseIP(ip))
+	}
+
+	assert.Equal(t, "10.0.0.10", allocate())
+	release("10.0.0.10")
+	// The addresses never allocated come first
+	assert.Equal(t, "10.0.0.12", allocate())
+	release("10.0.0.11")
+	release("10.0.0.12")
+	assert.Equal(t, "10.0.0.13", allocate())
+	// Then the least recently released
+	assert.Equal(t, "10.0.0.10", allocate())
+	assert.Equal(t, "10.0.0.11", allocate())
+	assert.Equal(t, "10.0.0.12", allocate())
+	_, err = p.allocateFrom(alloc, req, start, end)
+	assert.Equal(t, allocators.ErrNoAddrAvail, err)
+}
+
+func TestReap(t *testing.T) {
+	f, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	defer os.Remove(f.Name())
+	alloc, err := bitmap.NewIPv4Allocator(net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 20))
+	require.NoError(t, err)
+	p := PluginState{
+		Recordsv4:  make(map[string]*Record),
+		LeaseTime:  time.Hour,
+		allocator:  alloc,
+		rangeStart: net.IPv4(10, 0, 0, 10).To4(),
+		rangeEnd:   net.IPv4(10, 0, 0, 20).To4(),
+		grace:      time.Hour,
+	}
+	require.NoError(t, p.registerBackingFile(f.Name()))
+	defer p.leasefile.Close()
+
+	events := make(chan leaseevents.Event, 10)
+	leaseevents.NewTracker(func(e leaseevents.Event) { events <- e })
+
+	now := time.Now().Round(time.Second)
+	for i, expires := range []time.Time{now.Add(time.Hour), now.Add(-time.Minute), now.Add(-2 * time.Hour)} {
+		mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, byte(i)}
+		ip, err := p.allocateShared(nil)
+		require.NoError(t, err)
+		p.Recordsv4[mac.String()] = &Record{IP: ip, expires: expires}
+		require.NoError(t, p.saveIPAddress(mac, p.Recordsv4[mac.String()]))
+		// Renewals add lines to the lease file
+		require.NoError(t, p.saveIPAddress(mac, p.Recordsv4[mac.String()]))
+	}
+
+	// Only the lease expired for longer than the grace period is released
+	p.reap(now)
+	assert.Len(t, p.Recordsv4, 2)
+	assert.NotContains(t, p.Recordsv4, "aa:bb:cc:dd:ee:02")
+	e := <-events
+	assert.Equal(t, leaseevents.Reclaim, e.Event)
+	assert.Equal(t, net.IPv4(10, 0, 0, 12).To4(), e.IP.To4())
+	assert.Equal(t, "aa:bb:cc:dd:ee:02", e.HWAddr)
+
+	// Its address is free again
+	ip, err := p.allocateShared(nil)
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 12).To4(), ip)
+
+	// And the lease file was compacted
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	records, err := loadRecords(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+	require.NoError(t, p.saveIPAddress(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x00}, p.Recordsv4["aa:bb:cc:dd:ee:00"]))
+	data, err = ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+}
+
+func TestExclusions(t *testing.T) {
+	resetGroup(t)
+	f, err := ioutil.TempFile("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	// The address of the lease was excluded since
+	_, err = f.WriteString("aa:bb:cc:dd:ee:01 10.0.0.11 2000-01-01T00:00:00Z\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	h, err := setupRange(f.Name(), "10.0.0.10", "10.0.0.13", "1h", "exclude=10.0.0.10-10.0.0.11", "exclude=10.0.0.13")
+	require.NoError(t, err)
+	ctx := handler.NewContext(context.Background())
+	handle := func(mac byte) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		)
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		resp, _ := h(ctx, req, stub)
+		return resp
+	}
+
+	resp := handle(1)
+	require.NotNil(t, resp)
+	assert.Equal(t, net.IPv4(10, 0, 0, 12).To4(), resp.YourIPAddr)
+	assert.Nil(t, handle(2))
+	reason, _ := handler.Refusal(ctx)
+	assert.Equal(t, handler.ReasonPoolExhausted, reason)
+}
+
+func TestGroup(t *testing.T) {
+	resetGroup(t)
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var chain []handler.Handler4Ctx
+	for i, args := range [][]string{
+		{"10.0.5.10", "10.0.5.200", "1h", "class=link:10.0.5.0/24"},
+		{"10.0.0.10", "10.0.0.200", "1h", "weight=2"},
+		{"10.0.1.10", "10.0.1.200", "1h"},
+	} {
+		h, err := setupRange(append([]string{fmt.Sprintf("%s/leases%d.txt", dir, i)}, args...)...)
+		require.NoError(t, err)
+		chain = append(chain, h)
+	}
+	// handle runs the chain, and returns the prefix of the address
+	handle := func(mac byte, giaddr net.IP) string {
+		req, err := dhcpv4.New(
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+			dhcpv4.WithGatewayIP(giaddr),
+		)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		for _, h := range chain {
+			before := resp.YourIPAddr
+			resp, _ = h(context.Background(), req, resp)
+			require.NotNil(t, resp)
+			if !before.IsUnspecified() {
+				require.Equal(t, before, resp.YourIPAddr, "the address was given by two ranges")
+			}
+		}
+		return resp.YourIPAddr.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	// The new clients are shared by weighted round-robin among the ranges of
+	// their class, and keep their range
+	counts := make(map[string]int)
+	for mac := byte(1); mac <= 6; mac++ {
+		counts[handle(mac, net.IPv4zero)]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.0": 4, "10.0.1.0": 2}, counts)
+	for mac := byte(1); mac <= 6; mac++ {
+		counts[handle(mac, net.IPv4zero)]++
+	}
+	assert.Equal(t, map[string]int{"10.0.0.0": 8, "10.0.1.0": 4}, counts)
+
+	// The relayed clients of the link get addresses from its range too
+	counts = make(map[string]int)
+	for mac := byte(10); mac < 18; mac++ {
+		counts[handle(mac, net.IPv4(10, 0, 5, 1))]++
+	}
+	assert.Equal(t, map[string]int{"10.0.5.0": 2, "10.0.0.0": 4, "10.0.1.0": 2}, counts)
+
+	// The clients of a range are found while the others are busy
+	req, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 1}))
+	require.NoError(t, err)
+	g := currentGroup(t)
+	p := g.owner(req)
+	require.NotNil(t, p)
+	for _, o := range g.ranges {
+		if o != p {
+			o.Lock()
+			defer o.Unlock()
+		}
+	}
+	found := make(chan *PluginState)
+	go func() { found <- g.owner(req) }()
+	select {
+	case o := <-found:
+		assert.Equal(t, p, o)
+	case <-time.After(5 * time.Second):
+		t.Fatal("looking the client up waited for the other ranges")
+	}
+}
+
+func TestAuthoritative(t *testing.T) {
+	resetGroup(t)
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var chain []handler.Handler4Ctx
+	for i, args := range [][]string{
+		{"10.0.5.10", "10.0.5.200", "1h", "class=link:10.0.5.0/24", "authoritative=true"},
+		{"10.0.0.10", "10.0.0.200", "1h"},
+	} {
+		h, err := setupRange(append([]string{fmt.Sprintf("%s/leases%d.txt", dir, i)}, args...)...)
+		require.NoError(t, err)
+		chain = append(chain, h)
+	}
+	// reason is why the last request was refused
+	var reason string
+	handle := func(mt dhcpv4.MessageType, giaddr, requested net.IP) *dhcpv4.DHCPv4 {
+		modifiers := []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(mt),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}),
+			dhcpv4.WithGatewayIP(giaddr),
+		}
+		if requested != nil {
+			modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(requested)))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		var stop bool
+		for _, h := range chain {
+			if resp, stop = h(ctx, req, resp); stop {
+				break
+			}
+		}
+		require.NotNil(t, resp)
+		reason, _ = handler.Refusal(ctx)
+		return resp
+	}
+	link := net.IPv4(10, 0, 5, 1)
+
+	// INIT-REBOOT with an address the client has no lease for
+	resp := handle(dhcpv4.MessageTypeRequest, link, net.IPv4(10, 0, 5, 50))
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	assert.Equal(t, handler.ReasonNotLeased, reason)
+
+	resp = handle(dhcpv4.MessageTypeDiscover, link, nil)
+	leased := resp.YourIPAddr
+	assert.Equal(t, net.IPv4(10, 0, 5, 10).To4(), leased.To4())
+	resp = handle(dhcpv4.MessageTypeRequest, link, leased)
+	assert.NotEqual(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.Equal(t, leased, resp.YourIPAddr)
+	assert.Empty(t, reason)
+
+	// The client moved to another link
+	resp = handle(dhcpv4.MessageTypeRequest, net.IPv4(10, 0, 0, 1), leased)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.Equal(t, handler.ReasonWrongNetwork, reason)
+
+	// The addresses of the other ranges are not checked
+	resp = handle(dhcpv4.MessageTypeRequest, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 99))
+	assert.NotEqual(t, dhcpv4.MessageTypeNak, resp.MessageType())
+}
+
+func TestVerify(t *testing.T) {
+	resetGroup(t)
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, value := range []string{"", "mac,uuid"} {
+		_, err := setupRange(dir+"/leases.txt", "10.0.0.10", "10.0.0.200", "1h", "verify="+value)
+		assert.Error(t, err, value)
+	}
+	h, err := setupRange(dir+"/leases.txt", "10.0.0.10", "10.0.0.200", "1h", "verify=mac,client-id,circuit-id")
+	require.NoError(t, err)
+	g := currentGroup(t)
+	p := g.ranges[len(g.ranges)-1]
+
+	handle := func(mt dhcpv4.MessageType, mac byte, clientID, circuit string, requested net.IP) (*dhcpv4.DHCPv4, bool) {
+		modifiers := []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(mt),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+			dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte(clientID))),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(
+				dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit)),
+			)),
+		}
+		if requested != nil {
+			modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(requested)))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		return h(context.Background(), req, resp)
+	}
+
+	resp, stop := handle(dhcpv4.MessageTypeRequest, 1, "id1", "port1", nil)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	leased := resp.YourIPAddr
+
+	// Another client asking for the address
+	resp, stop = handle(dhcpv4.MessageTypeRequest, 2, "id2", "port2", leased)
+	assert.True(t, stop)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	resp, stop = handle(dhcpv4.MessageTypeDiscover, 2, "id2", "port2", leased)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+	// Spoofing the hardware address
+	resp, _ = handle(dhcpv4.MessageTypeRequest, 1, "id2", "port1", leased)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	resp, _ = handle(dhcpv4.MessageTypeRequest, 1, "id1", "port2", leased)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	assert.Equal(t, "4", p.stats.Get("conflicts").String())
+
+	// The owner renews
+	resp, stop = handle(dhcpv4.MessageTypeRequest, 1, "id1", "port1", leased)
+	assert.False(t, stop)
+	assert.Equal(t, leased, resp.YourIPAddr)
+
+	// Once expired, the lease is bound to its next user
+	p.Recordsv4["aa:bb:cc:dd:ee:01"].expires = time.Now().Add(-time.Minute)
+	resp, stop = handle(dhcpv4.MessageTypeRequest, 1, "id1", "port2", leased)
+	assert.False(t, stop)
+	assert.Equal(t, leased, resp.YourIPAddr)
+	resp, _ = handle(dhcpv4.MessageTypeRequest, 1, "id1", "port1", leased)
+	assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+}
+
+func TestGroupPerChain(t *testing.T) {
+	resetGroup(t)
+	dir, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	if _, ok := plugins.RegisteredPlugins[Plugin.Name]; !ok {
+		plugins.RegisteredPlugins[Plugin.Name] = &Plugin
+		defer delete(plugins.RegisteredPlugins, Plugin.Name)
+	}
+
+	// The main chain and one of the chains of the configuration, eg. of a
+	// VLAN, each with a range
+	var chains [][]handler.Handler4Ctx
+	for i, args := range [][]string{
+		{"10.10.10.10", "10.10.10.200", "1h"},
+		{"10.20.0.10", "10.20.0.200", "1h"},
+	} {
+		h, err := plugins.LoadPlugins4(&config.ServerConfig{Plugins: []config.PluginConfig{{
+			Name: Plugin.Name,
+			Args: append([]string{fmt.Sprintf("%s/leases%d.txt", dir, i)}, args...),
+		}}})
+		require.NoError(t, err)
+		chains = append(chains, h)
+	}
+	handle := func(chain []handler.Handler4Ctx, mt dhcpv4.MessageType, mac byte, requested net.IP) *dhcpv4.DHCPv4 {
+		modifiers := []dhcpv4.Modifier{
+			dhcpv4.WithMessageType(mt),
+			dhcpv4.WithHwAddr(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, mac}),
+		}
+		if requested != nil {
+			modifiers = append(modifiers, dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(requested)))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		var stop bool
+		for _, h := range chain {
+			if resp, stop = h(ctx, req, resp); stop {
+				break
+			}
+		}
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// Each range serves all the clients of its chain
+	for i, network := range []string{"10.10.10.0", "10.20.0.0"} {
+		for n := byte(1); n <= 4; n++ {
+			mac := byte(i)*0x10 + n
+			resp := handle(chains[i], dhcpv4.MessageTypeDiscover, mac, nil)
+			require.False(t, resp.YourIPAddr.IsUnspecified(), "no address for client %d of chain %d", mac, i)
+			assert.Equal(t, network, resp.YourIPAddr.Mask(net.CIDRMask(24, 32)).String())
+			resp = handle(chains[i], dhcpv4.MessageTypeRequest, mac, resp.YourIPAddr)
+			assert.NotEqual(t, dhcpv4.MessageTypeNak, resp.MessageType())
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+// reapInterval is how often the expired leases are looked for
+const reapInterval = time.Minute
+
+// When a grace period is set, the leases go through three states: active
+// until they expire, expired (the address is still kept for the client) until
+// the grace period ends, then released: the lease is deleted, its address
+// given back to the allocator, and a reclaim event published (see
+// plugins/leaseevents). The lease file is compacted on the way.
+
+// reap releases the leases expired for longer than the grace period. The
+// caller must hold the lock
+func (p *PluginState) reap(now time.Time) {
+	released := 0
+	for mac, rec := range p.Recordsv4 {
+		if !rec.expires.Add(p.grace).Before(now) {
+			continue
+		}
+		if p.ipam == nil {
+			if alloc := p.allocatorOf(rec.IP); alloc != nil {
+				if err := alloc.Free(net.IPNet{IP: rec.IP, Mask: net.CIDRMask(32, 32)}); err != nil {
+					log.Warningf("Could not free %s: %v", rec.IP, err)
+				} else {
+					p.released(rec.IP)
+				}
+			}
+		}
+		p.deleteRecord(mac)
+		released++
+		log.Debugf("Released %s from MAC %s, its lease expired at %s", rec.IP, mac, wallTime(rec.expires).Format(time.RFC3339))
+		expires := wallTime(rec.expires)
+		leaseevents.Publish(leaseevents.Event{
+			Event:    leaseevents.Reclaim,
+			IP:       rec.IP,
+			HWAddr:   mac,
+			Hostname: rec.Hostname,
+			Expires:  &expires,
+		})
+	}
+	if released > 0 {
+		log.Infof("Released %d expired leases", released)
+		p.checkUtilization(now)
+	}
+	// The lease file only grows, with a line for every renewal
+	if p.leasefile != nil && (released > 0 || p.lines > 2*len(p.Recordsv4)+100) {
+		if err := p.compact(); err != nil {
+			log.Errorf("Could not compact the lease file: %v", err)
+		}
+	}
+}
+
+// reaper reaps the expired leases periodically, until ctx is done
+func (p *PluginState) reaper(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.Lock()
+			p.reap(now)
+			p.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// compact rewrites the lease file with the current leases only. The caller
+// must hold the lock
+func (p *PluginState) compact() error {
+	name := p.leasefile.Name()
+	tmp, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for mac, rec := range p.Recordsv4 {
+		if _, err := w.WriteString(p.cipher.Seal(recordLine(mac, rec)) + "\n"); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	leasefile, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not reopen the lease file: %w", err)
+	}
+	p.leasefile.Close()
+	p.leasefile, p.lines = leasefile, len(p.Recordsv4)
+	return nil
+}
@@ -13,11 +13,13 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leasecrypt"
 )
 
 // loadRecords loads the DHCPv6/v4 Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
-// IP address.
+// the specified file. The records have to be one per line, a mac address, an
+// IP address, the expiry time, and optionally the host name of the client.
 func loadRecords(r io.Reader) (map[string]*Record, error) {
 	sc := bufio.NewScanner(r)
 	records := make(map[string]*Record)
@@ -27,8 +29,8 @@ func loadRecords(r io.Reader) (map[string]*Record, error) {
 			continue
 		}
 		tokens := strings.Fields(line)
-		if len(tokens) != 3 {
-			return nil, fmt.Errorf("malformed line, want 3 fields, got %d: %s", len(tokens), line)
+		if len(tokens) != 3 && len(tokens) != 4 {
+			return nil, fmt.Errorf("malformed line, want 3 or 4 fields, got %d: %s", len(tokens), line)
 		}
 		hwaddr, err := net.ParseMAC(tokens[0])
 		if err != nil {
@@ -42,12 +44,17 @@ func loadRecords(r io.Reader) (map[string]*Record, error) {
 		if err != nil {
 			return nil, fmt.Errorf("expected time of exipry in RFC3339 format, got: %v", tokens[2])
 		}
-		records[hwaddr.String()] = &Record{IP: ipaddr, expires: expires}
+		rec := &Record{IP: ipaddr, expires: expires}
+		if len(tokens) == 4 {
+			rec.Hostname = tokens[3]
+		}
+		records[hwaddr.String()] = rec
 	}
 	return records, nil
 }
 
-func loadRecordsFromFile(filename string) (map[string]*Record, error) {
+// loadRecordsFromFile loads the records of a lease file, decrypted with c
+func loadRecordsFromFile(filename string, c *leasecrypt.Cipher) (map[string]*Record, error) {
 	reader, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0640)
 	defer func() {
 		if err := reader.Close(); err != nil {
@@ -57,15 +64,29 @@ func loadRecordsFromFile(filename string) (map[string]*Record, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot open lease file %s: %w", filename, err)
 	}
-	return loadRecords(reader)
+	lines, err := c.Decrypt(reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read lease file %s: %w", filename, err)
+	}
+	return loadRecords(lines)
+}
+
+// recordLine formats a lease as a line of the lease file
+func recordLine(mac string, record *Record) string {
+	line := mac + " " + record.IP.String() + " " + wallTime(record.expires).Format(time.RFC3339)
+	if record.Hostname != "" {
+		line += " " + record.Hostname
+	}
+	return line
 }
 
 // saveIPAddress writes out a lease to storage
 func (p *PluginState) saveIPAddress(mac net.HardwareAddr, record *Record) error {
-	_, err := p.leasefile.WriteString(mac.String() + " " + record.IP.String() + " " + record.expires.Format(time.RFC3339) + "\n")
+	_, err := p.leasefile.WriteString(p.cipher.Seal(recordLine(mac.String(), record)) + "\n")
 	if err != nil {
 		return err
 	}
+	p.lines++
 	err = p.leasefile.Sync()
 	if err != nil {
 		return err
@@ -80,7 +101,7 @@ func (p *PluginState) registerBackingFile(filename string) error {
 		// but maintaining consistency with the in-memory state isn't
 		return errors.New("cannot swap out a lease storage file while running")
 	}
-	// We never close this, but that's ok because plugins are never stopped/unregistered
+	// Closed when the plugin stops
 	newLeasefile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open lease file %s: %w", filename, err)
@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/coredhcp/coredhcp/plugins/leasecrypt"
 )
 
 var leasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
@@ -21,6 +23,7 @@ var leasefile string = `02:00:00:00:00:00 10.0.0.0 2000-01-01T00:00:00Z
 02:00:00:00:00:03 10.0.0.3 2000-01-01T00:00:00Z
 02:00:00:00:00:04 10.0.0.4 2000-01-01T00:00:00Z
 02:00:00:00:00:05 10.0.0.5 2000-01-01T00:00:00Z
+02:00:00:00:00:06 10.0.0.6 2000-01-01T00:00:00Z host6
 `
 
 var expire = time.Date(2000, 01, 01, 00, 00, 00, 00, time.UTC)
@@ -28,12 +31,13 @@ var records = []struct {
 	mac string
 	ip  *Record
 }{
-	{"02:00:00:00:00:00", &Record{net.IPv4(10, 0, 0, 0), expire}},
-	{"02:00:00:00:00:01", &Record{net.IPv4(10, 0, 0, 1), expire}},
-	{"02:00:00:00:00:02", &Record{net.IPv4(10, 0, 0, 2), expire}},
-	{"02:00:00:00:00:03", &Record{net.IPv4(10, 0, 0, 3), expire}},
-	{"02:00:00:00:00:04", &Record{net.IPv4(10, 0, 0, 4), expire}},
-	{"02:00:00:00:00:05", &Record{net.IPv4(10, 0, 0, 5), expire}},
+	{"02:00:00:00:00:00", &Record{IP: net.IPv4(10, 0, 0, 0), expires: expire}},
+	{"02:00:00:00:00:01", &Record{IP: net.IPv4(10, 0, 0, 1), expires: expire}},
+	{"02:00:00:00:00:02", &Record{IP: net.IPv4(10, 0, 0, 2), expires: expire}},
+	{"02:00:00:00:00:03", &Record{IP: net.IPv4(10, 0, 0, 3), expires: expire}},
+	{"02:00:00:00:00:04", &Record{IP: net.IPv4(10, 0, 0, 4), expires: expire}},
+	{"02:00:00:00:00:05", &Record{IP: net.IPv4(10, 0, 0, 5), expires: expire}},
+	{"02:00:00:00:00:06", &Record{IP: net.IPv4(10, 0, 0, 6), expires: expire, Hostname: "host6"}},
 }
 
 func TestLoadRecords(t *testing.T) {
@@ -85,3 +89,49 @@ func TestWriteRecords(t *testing.T) {
 	}
 	assert.Equal(t, leasefile, string(written), "Data written to the file doesn't match records")
 }
+
+func TestEncryptedRecords(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "coredhcptest")
+	if err != nil {
+		t.Skipf("Could not setup file-based test: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	c, err := leasecrypt.New(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An unencrypted lease, written before encryption was turned on
+	if _, err := tmpfile.WriteString("02:00:00:00:00:07 10.0.0.7 2000-01-01T00:00:00Z\n"); err != nil {
+		t.Fatal(err)
+	}
+	pl := PluginState{cipher: c}
+	if err := pl.registerBackingFile(tmpfile.Name()); err != nil {
+		t.Fatalf("Could not setup file")
+	}
+	defer pl.leasefile.Close()
+	for _, rec := range records {
+		hwaddr, _ := net.ParseMAC(rec.mac)
+		if err := pl.saveIPAddress(hwaddr, rec.ip); err != nil {
+			t.Errorf("Failed to save ip for %s: %v", hwaddr, err)
+		}
+	}
+
+	written, err := ioutil.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(written), "02:00:00:00:00:06", "Leases written in clear")
+	assert.NotContains(t, string(written), "host6", "Leases written in clear")
+
+	loaded, err := loadRecordsFromFile(tmpfile.Name(), c)
+	if err != nil {
+		t.Fatalf("Failed to load encrypted records: %v", err)
+	}
+	assert.Len(t, loaded, len(records)+1)
+	assert.Equal(t, "host6", loaded["02:00:00:00:00:06"].Hostname)
+	if _, err := loadRecordsFromFile(tmpfile.Name(), nil); err == nil {
+		t.Error("Expected encrypted records to fail to load without the key")
+	}
+}
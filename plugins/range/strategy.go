@@ -0,0 +1,210 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// Allocation strategies
+const (
+	// allocSequential gives the lowest free address
+	allocSequential = "sequential"
+	// allocHash derives the address from a hash of the client identifier
+	// (option 61), or of the MAC address, over the pool. Taken addresses are
+	// skipped to the next free one. Clients get the same address across
+	// restarts, even when the lease file is lost, as long as the pool does
+	// not change
+	allocHash = "hash"
+	// allocRandom gives a random address, drawn with crypto/rand, or the
+	// next free one if it is taken, so that the addresses of the clients
+	// cannot be guessed, eg. by scanning from the start of the pool
+	allocRandom = "random"
+	// allocLRU gives the addresses never allocated first, in order, then the
+	// least recently released ones (see reaper.go), to keep released
+	// addresses unused as long as possible. Only the releases since the
+	// server started are known: the addresses free at startup count as never
+	// allocated
+	allocLRU = "lru"
+)
+
+// A strategy selects the address of a pool to allocate first for a new
+// client, the allocator giving the next free one if it is taken. Its methods
+// are called with the lock of the range held
+type strategy interface {
+	// hint returns the address to allocate first, nil for the lowest free
+	// one
+	hint(req *dhcpv4.DHCPv4, start, end net.IP) net.IP
+	// allocated tells an address of a pool was given to a client
+	allocated(start, end, ip net.IP)
+	// released tells an address was given back to its pool
+	released(ip net.IP)
+}
+
+// strategies build the allocation strategies, by name
+var strategies = map[string]func() strategy{
+	allocSequential: func() strategy { return sequential{} },
+	allocHash:       func() strategy { return hashed{} },
+	allocRandom:     func() strategy { return random{} },
+	allocLRU:        func() strategy { return &lru{cursors: make(map[string]*cursor)} },
+}
+
+// strategyNames returns the names of the strategies, for the error messages
+func strategyNames() string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// allocateFrom allocates an address of a pool for a client, as selected by
+// the strategy of the range, sequential if unset. The caller must hold the
+// lock
+func (p *PluginState) allocateFrom(alloc allocators.Allocator, req *dhcpv4.DHCPv4, start, end net.IP) (net.IPNet, error) {
+	s := p.strategy
+	if s == nil {
+		s = sequential{}
+	}
+	ip, err := alloc.Allocate(net.IPNet{IP: s.hint(req, start, end)})
+	if err == nil {
+		s.allocated(start, end, ip.IP)
+	}
+	return ip, err
+}
+
+// released tells the strategy of the range an address was given back to its
+// pool. The caller must hold the lock
+func (p *PluginState) released(ip net.IP) {
+	if p.strategy != nil {
+		p.strategy.released(ip)
+	}
+}
+
+// stateless is embedded by the strategies ignoring the allocations
+type stateless struct{}
+
+func (stateless) allocated(start, end, ip net.IP) {}
+func (stateless) released(ip net.IP)              {}
+
+type sequential struct{ stateless }
+
+func (sequential) hint(req *dhcpv4.DHCPv4, start, end net.IP) net.IP {
+	return nil
+}
+
+// clientID returns the identifier of a client: its client identifier if it
+// sent one, its MAC address otherwise
+func clientID(req *dhcpv4.DHCPv4) []byte {
+	if id := req.Options.Get(dhcpv4.OptionClientIdentifier); len(id) > 0 {
+		return id
+	}
+	return req.ClientHWAddr
+}
+
+// ipToUint32 and uint32ToIP convert between IPv4 addresses and numbers
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+type hashed struct{ stateless }
+
+func (hashed) hint(req *dhcpv4.DHCPv4, start, end net.IP) net.IP {
+	h := fnv.New64a()
+	_, _ = h.Write(clientID(req))
+	first, last := ipToUint32(start), ipToUint32(end)
+	size := uint64(last-first) + 1
+	return uint32ToIP(first + uint32(h.Sum64()%size))
+}
+
+type random struct{ stateless }
+
+func (random) hint(req *dhcpv4.DHCPv4, start, end net.IP) net.IP {
+	first, last := ipToUint32(start), ipToUint32(end)
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(last-first)+1))
+	if err != nil {
+		log.Warningf("Could not draw a random address: %v", err)
+		return nil
+	}
+	return uint32ToIP(first + uint32(n.Uint64()))
+}
+
+// cursor is the next address of a pool never allocated, once done all were
+type cursor struct {
+	next uint32
+	done bool
+}
+
+type lru struct {
+	// cursors are the cursors of the pools, by start address
+	cursors map[string]*cursor
+	// free are the released addresses, the least recently released first
+	free []net.IP
+}
+
+func (l *lru) cursor(start net.IP) *cursor {
+	c, ok := l.cursors[start.String()]
+	if !ok {
+		c = &cursor{next: ipToUint32(start)}
+		l.cursors[start.String()] = c
+	}
+	return c
+}
+
+func (l *lru) hint(req *dhcpv4.DHCPv4, start, end net.IP) net.IP {
+	if c := l.cursor(start); !c.done {
+		return uint32ToIP(c.next)
+	}
+	for _, ip := range l.free {
+		if ipToUint32(ip) >= ipToUint32(start) && ipToUint32(ip) <= ipToUint32(end) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (l *lru) allocated(start, end, ip net.IP) {
+	for i, free := range l.free {
+		if free.Equal(ip) {
+			l.free = append(l.free[:i], l.free[i+1:]...)
+			break
+		}
+	}
+	c := l.cursor(start)
+	switch n := ipToUint32(ip); {
+	case c.done:
+	case n < c.next || n == ipToUint32(end):
+		// The allocator wrapped around, or reached the end of the pool:
+		// the addresses left were allocated before
+		c.done = true
+	default:
+		c.next = n + 1
+	}
+}
+
+func (l *lru) released(ip net.IP) {
+	for _, free := range l.free {
+		if free.Equal(ip) {
+			return
+		}
+	}
+	l.free = append(l.free, ip.To4())
+}
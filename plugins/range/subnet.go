@@ -0,0 +1,166 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/sparse"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// subnet is another logical subnet living on the network segment of the
+// range (a shared network). Clients get addresses from the subnets, in order,
+// once the range is exhausted
+type subnet struct {
+	network *net.IPNet
+	// start and end are the bounds of the addresses to allocate, inclusive
+	start, end net.IP
+	// router is the default gateway of the subnet, if any
+	router    net.IP
+	allocator allocators.Allocator
+}
+
+// parseSubnet parses a subnet of the form
+// <network>/<prefix length>:<start IP>-<end IP>[:<router IP>]
+func parseSubnet(value string) (*subnet, error) {
+	fields := strings.Split(value, ":")
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, fmt.Errorf("invalid subnet %q, expected <network>/<prefix length>:<start IP>-<end IP>[:<router IP>]", value)
+	}
+	_, network, err := net.ParseCIDR(fields[0])
+	if err != nil || network.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 network in subnet %q", value)
+	}
+	bounds := strings.SplitN(fields[1], "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid subnet %q, expected <start IP>-<end IP>", value)
+	}
+	s := subnet{network: network, start: net.ParseIP(bounds[0]).To4(), end: net.ParseIP(bounds[1]).To4()}
+	if s.start == nil || s.end == nil || bytes.Compare(s.start, s.end) > 0 {
+		return nil, fmt.Errorf("invalid IPv4 range in subnet %q", value)
+	}
+	if !network.Contains(s.start) || !network.Contains(s.end) {
+		return nil, fmt.Errorf("the range of subnet %q is out of its network", value)
+	}
+	if len(fields) == 3 {
+		if s.router = net.ParseIP(fields[2]).To4(); s.router == nil || !network.Contains(s.router) {
+			return nil, fmt.Errorf("invalid router in subnet %q", value)
+		}
+	}
+	if s.allocator, err = sparse.NewIPv4Allocator(s.start, s.end); err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: %w", value, err)
+	}
+	return &s, nil
+}
+
+func (s *subnet) contains(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && bytes.Compare(ip4, s.start) >= 0 && bytes.Compare(ip4, s.end) <= 0
+}
+
+// addSubnet adds a subnet to the shared network, it must not overlap the
+// range nor the other subnets
+func (p *PluginState) addSubnet(s *subnet) error {
+	if p.inRange(s.start) || p.inRange(s.end) || s.contains(p.rangeStart) {
+		return fmt.Errorf("subnet %s overlaps the range", s.network)
+	}
+	if p.overflow != nil && (p.overflow.contains(s.start) || s.contains(p.overflow.start)) {
+		return fmt.Errorf("subnet %s overlaps the overflow range", s.network)
+	}
+	for _, o := range p.subnets {
+		if o.contains(s.start) || s.contains(o.start) {
+			return fmt.Errorf("subnet %s overlaps subnet %s", s.network, o.network)
+		}
+	}
+	p.subnets = append(p.subnets, s)
+	return nil
+}
+
+// subnetOf returns the subnet of the shared network an address was allocated
+// from, nil for the addresses of the range
+func (p *PluginState) subnetOf(ip net.IP) *subnet {
+	for _, s := range p.subnets {
+		if s.contains(ip) {
+			return s
+		}
+	}
+	return nil
+}
+
+// subnetOfLink returns the subnet of the shared network whose network holds
+// an address, eg. the one of a client sending an INFORM, nil if none
+func (p *PluginState) subnetOfLink(ip net.IP) *subnet {
+	for _, s := range p.subnets {
+		if s.network.Contains(ip) {
+			return s
+		}
+	}
+	return nil
+}
+
+// allocateShared allocates an address from the range, or from the first
+// subnet of the shared network with addresses left. The overflow range, if
+// any, comes first once the range is nearly exhausted, and after it otherwise.
+// Once they are all exhausted, the address of an expired lease is reclaimed.
+// The caller must hold the lock
+func (p *PluginState) allocateShared(req *dhcpv4.DHCPv4) (net.IP, error) {
+	var (
+		ip  net.IPNet
+		err = allocators.ErrNoAddrAvail
+	)
+	overflowing := p.overflowing(time.Now())
+	if overflowing {
+		ip, err = p.allocateFrom(p.overflow.allocator, req, p.overflow.start, p.overflow.end)
+	}
+	if errors.Is(err, allocators.ErrNoAddrAvail) {
+		ip, err = p.allocateFrom(p.allocator, req, p.rangeStart, p.rangeEnd)
+	}
+	if errors.Is(err, allocators.ErrNoAddrAvail) && p.overflow != nil && !overflowing {
+		ip, err = p.allocateFrom(p.overflow.allocator, req, p.overflow.start, p.overflow.end)
+	}
+	for i := 0; errors.Is(err, allocators.ErrNoAddrAvail) && i < len(p.subnets); i++ {
+		s := p.subnets[i]
+		log.Debugf("Range exhausted, allocating from subnet %s", s.network)
+		ip, err = p.allocateFrom(s.allocator, req, s.start, s.end)
+	}
+	if errors.Is(err, allocators.ErrNoAddrAvail) {
+		return p.reclaim(time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ip.IP.To4(), nil
+}
+
+// setSubnetOptions sets the options describing the subnet of an address of
+// the shared network, overriding the ones set for the range by the previous
+// plugins (eg. netmask and router)
+func (p *PluginState) setSubnetOptions(resp *dhcpv4.DHCPv4, ip net.IP) {
+	if s := p.subnetOf(ip); s != nil {
+		s.setOptions(resp)
+	}
+}
+
+// setOptions sets the options describing a subnet
+func (s *subnet) setOptions(resp *dhcpv4.DHCPv4) {
+	resp.UpdateOption(dhcpv4.OptSubnetMask(s.network.Mask))
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range broadcast {
+		broadcast[i] = s.network.IP.To4()[i] | ^s.network.Mask[i]
+	}
+	resp.UpdateOption(dhcpv4.OptBroadcastAddress(broadcast))
+	if s.router != nil {
+		resp.UpdateOption(dhcpv4.OptRouter(s.router))
+	} else {
+		delete(resp.Options, dhcpv4.OptionRouter.Code())
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"expvar"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/allocators"
+	"github.com/coredhcp/coredhcp/plugins/allocators/sparse"
+)
+
+// metrics holds the utilization of the ranges, by range, eg.
+// "10.0.0.100-10.0.0.200": {"size": 101, "used": 80, "utilization": 0.79}.
+// It is published with expvar
+var metrics = expvar.NewMap("range")
+
+// defaultThresholds are the utilization percentages warned about by default
+var defaultThresholds = []int{80, 95}
+
+// overflow is a range of addresses given with shorter leases once the range
+// is nearly exhausted
+type overflow struct {
+	start, end net.IP
+	leaseTime  time.Duration
+	allocator  allocators.Allocator
+}
+
+// parseOverflow parses an overflow range of the form
+// <start IP>-<end IP>:<lease duration>
+func parseOverflow(value string) (*overflow, error) {
+	sep := strings.LastIndexByte(value, ':')
+	if sep < 0 {
+		return nil, fmt.Errorf("invalid overflow range %q, expected <start IP>-<end IP>:<lease duration>", value)
+	}
+	bounds := strings.SplitN(value[:sep], "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid overflow range %q, expected <start IP>-<end IP>:<lease duration>", value)
+	}
+	o := overflow{start: net.ParseIP(bounds[0]).To4(), end: net.ParseIP(bounds[1]).To4()}
+	if o.start == nil || o.end == nil || bytes.Compare(o.start, o.end) > 0 {
+		return nil, fmt.Errorf("invalid IPv4 range in overflow range %q", value)
+	}
+	var err error
+	if o.leaseTime, err = time.ParseDuration(value[sep+1:]); err != nil || o.leaseTime <= 0 {
+		return nil, fmt.Errorf("invalid lease duration in overflow range %q", value)
+	}
+	if o.allocator, err = sparse.NewIPv4Allocator(o.start, o.end); err != nil {
+		return nil, fmt.Errorf("invalid overflow range %q: %w", value, err)
+	}
+	return &o, nil
+}
+
+func (o *overflow) contains(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && bytes.Compare(ip4, o.start) >= 0 && bytes.Compare(ip4, o.end) <= 0
+}
+
+// parseThresholds parses a comma-separated list of percentages, an empty
+// list disables the warnings
+func parseThresholds(value string) ([]int, error) {
+	var thresholds []int
+	if value == "" {
+		return thresholds, nil
+	}
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+		if err != nil || n <= 0 || n > 100 {
+			return nil, fmt.Errorf("invalid threshold %q, expected a percentage", s)
+		}
+		thresholds = append(thresholds, n)
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+// size returns the number of addresses of the range, without the excluded
+// ones
+func (p *PluginState) size() int {
+	if p.rangeStart == nil || p.rangeEnd == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(p.rangeEnd.To4())-binary.BigEndian.Uint32(p.rangeStart.To4())) + 1 - p.excludedFromRange()
+}
+
+// used returns the number of active leases of the range, and of the overflow
+// range. The caller must hold the lock
+func (p *PluginState) used(now time.Time) (inRange int, inOverflow int) {
+	for _, rec := range p.Recordsv4 {
+		if !rec.expires.After(now) {
+			continue
+		}
+		if p.inRange(rec.IP) {
+			inRange++
+		} else if p.overflow != nil && p.overflow.contains(rec.IP) {
+			inOverflow++
+		}
+	}
+	return inRange, inOverflow
+}
+
+// utilization returns the share of the addresses of the range with an active
+// lease, in percent. The caller must hold the lock
+func (p *PluginState) utilization(now time.Time) float64 {
+	size := p.size()
+	if size == 0 {
+		return 0
+	}
+	used, _ := p.used(now)
+	return float64(used) * 100 / float64(size)
+}
+
+// overflowing returns whether addresses are allocated from the overflow
+// range. The caller must hold the lock
+func (p *PluginState) overflowing(now time.Time) bool {
+	return p.overflow != nil && p.utilization(now) >= float64(p.overflowAt)
+}
+
+// leaseTime returns the lease time of an address
+func (p *PluginState) leaseTime(ip net.IP) time.Duration {
+	if p.overflow != nil && p.overflow.contains(ip) {
+		return p.overflow.leaseTime
+	}
+	return p.LeaseTime
+}
+
+// checkUtilization updates the utilization metrics of the range, and warns
+// when it crosses a threshold. The caller must hold the lock
+func (p *PluginState) checkUtilization(now time.Time) {
+	size := p.size()
+	if size == 0 {
+		return
+	}
+	used, inOverflow := p.used(now)
+	utilization := float64(used) * 100 / float64(size)
+	name := p.rangeStart.String() + "-" + p.rangeEnd.String()
+	if p.stats != nil {
+		p.stats.Set("size", intVar(size))
+		p.stats.Set("used", intVar(used))
+		p.stats.Set("overflow_used", intVar(inOverflow))
+		p.stats.Set("utilization", floatVar(utilization/100))
+	}
+	level := 0
+	for _, t := range p.thresholds {
+		if utilization >= float64(t) {
+			level++
+		}
+	}
+	switch {
+	case level > p.level:
+		log.Warningf("Range %s is %.0f%% used (%d/%d), above the %d%% threshold", name, utilization, used, size, p.thresholds[level-1])
+	case level < p.level:
+		log.Infof("Range %s is %.0f%% used (%d/%d), back below the %d%% threshold", name, utilization, used, size, p.thresholds[level])
+	}
+	p.level = level
+}
+
+func intVar(n int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
+
+func floatVar(f float64) *expvar.Float {
+	v := new(expvar.Float)
+	v.Set(f)
+	return v
+}
@@ -0,0 +1,109 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/plugins/relay"
+)
+
+// Verifying the ownership of the leases keeps a client from taking the
+// address of another one, eg. by asking for it or by spoofing its hardware
+// address. When enabled, a DHCPREQUEST is answered with a DHCPNAK, and a
+// DHCPDISCOVER is dropped, when:
+// - it asks for an address of the range leased to another hardware address
+// - the client identifier (option 61), or the Agent Circuit ID of option 82,
+// differs from the one of the active lease of the client, if told to verify
+// them
+//
+// The identifiers are bound to a lease when it is allocated, or renewed once
+// expired. They are kept in memory only: after a restart, they are bound
+// again by the next request of the client. The conflicts are counted in the
+// "conflicts" metric of the range.
+
+// The identifiers to verify
+const (
+	verifyMAC       = "mac"
+	verifyClientID  = "client-id"
+	verifyCircuitID = "circuit-id"
+)
+
+// verification tells which identifiers of the clients are verified
+type verification struct {
+	clientID, circuitID bool
+}
+
+// parseVerify parses a comma-separated list of identifiers to verify, the
+// hardware address being always verified
+func parseVerify(value string) (*verification, error) {
+	var v verification
+	for _, id := range strings.Split(value, ",") {
+		switch id {
+		case verifyMAC:
+		case verifyClientID:
+			v.clientID = true
+		case verifyCircuitID:
+			v.circuitID = true
+		default:
+			return nil, fmt.Errorf("invalid identifier %q to verify, expected %s, %s or %s", id, verifyMAC, verifyClientID, verifyCircuitID)
+		}
+	}
+	return &v, nil
+}
+
+// requestedIP returns the address a client asks for, if any: the requested
+// IP address option, or ciaddr
+func requestedIP(req *dhcpv4.DHCPv4) net.IP {
+	ip := req.RequestedIPAddress()
+	if ip == nil || ip.IsUnspecified() {
+		ip = req.ClientIPAddr
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+	return ip
+}
+
+// conflict returns why a request may not use its lease, or the address it
+// asks for, "" if it may. The caller must hold the lock
+func (p *PluginState) conflict(req *dhcpv4.DHCPv4, record *Record, now time.Time) string {
+	mac := req.ClientHWAddr.String()
+	if ip := requestedIP(req); ip != nil && p.Contains(ip) {
+		for m, rec := range p.Recordsv4 {
+			if m != mac && rec.IP.Equal(ip) && rec.expires.After(now) {
+				return fmt.Sprintf("%s is leased to another client", ip)
+			}
+		}
+	}
+	if record == nil || !record.expires.After(now) {
+		return ""
+	}
+	if p.verify.clientID && record.clientID != nil && string(req.Options.Get(dhcpv4.OptionClientIdentifier)) != *record.clientID {
+		return fmt.Sprintf("the client identifier does not match the lease of %s", record.IP)
+	}
+	if p.verify.circuitID && record.circuitID != nil && string(relay.CircuitID4(req)) != *record.circuitID {
+		return fmt.Sprintf("the circuit does not match the lease of %s", record.IP)
+	}
+	return ""
+}
+
+// bind binds the identifiers of a client to its lease, when not bound yet
+// or when the lease had expired. The caller must hold the lock
+func (p *PluginState) bind(req *dhcpv4.DHCPv4, record *Record, expired bool) {
+	if p.verify.clientID && (record.clientID == nil || expired) {
+		id := string(req.Options.Get(dhcpv4.OptionClientIdentifier))
+		record.clientID = &id
+	}
+	if p.verify.circuitID && (record.circuitID == nil || expired) {
+		id := string(relay.CircuitID4(req))
+		record.circuitID = &id
+	}
+}
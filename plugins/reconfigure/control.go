@@ -0,0 +1,81 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package reconfigure
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func (p *PluginState) listenControl(path string) error {
+	// Remove a stale socket left by a previous run
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove stale control socket: %w", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("cannot listen on control socket: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Errorf("control socket stopped: %v", err)
+				return
+			}
+			go p.serveControl(conn)
+		}
+	}()
+	return nil
+}
+
+func (p *PluginState) serveControl(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(conn, p.command(line)); err != nil {
+			return
+		}
+	}
+}
+
+// command runs one control command and returns the answer
+func (p *PluginState) command(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return "error expected <renew|information-request> <duid|all>"
+	}
+	var mt dhcpv6.MessageType
+	switch fields[0] {
+	case "renew":
+		mt = dhcpv6.MessageTypeRenew
+	case "information-request":
+		mt = dhcpv6.MessageTypeInformationRequest
+	default:
+		return "error unknown command " + fields[0]
+	}
+	var duid []byte
+	if fields[1] != "all" {
+		var err error
+		if duid, err = hex.DecodeString(strings.ReplaceAll(fields[1], ":", "")); err != nil {
+			return "error malformed DUID " + fields[1]
+		}
+	}
+	sent, err := p.reconfigure(duid, mt)
+	if err != nil {
+		return "error " + err.Error()
+	}
+	return fmt.Sprintf("ok %d", sent)
+}
@@ -0,0 +1,182 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package reconfigure
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// newKey generates a Reconfigure Key
+func newKey() ([]byte, error) {
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate a reconfigure key: %w", err)
+	}
+	return key, nil
+}
+
+// verify checks the Authentication option of a Reconfigure message with the
+// key of its client, as the client does (RFC8415 §20.4.3), and returns the
+// replay detection value of the message
+func verify(msg *dhcpv6.Message, key []byte) (uint64, error) {
+	opt := msg.GetOneOption(dhcpv6.OptionAuth)
+	if opt == nil {
+		return 0, errors.New("no authentication option")
+	}
+	data := opt.ToBytes()
+	if len(data) != 12+md5.Size {
+		return 0, fmt.Errorf("authentication option of length %d, expected %d", len(data), 12+md5.Size)
+	}
+	if data[0] != authProtocolReconfigureKey || data[1] != authAlgorithmHMACMD5 ||
+		data[2] != authRDMMonotonic || data[11] != authTypeHMACMD5 {
+		return 0, errors.New("not a reconfigure key authentication")
+	}
+	// The HMAC is computed with a zero HMAC field: rebuild the message
+	// with one
+	zeroed := make([]byte, len(data))
+	copy(zeroed, data[:12])
+	check := &dhcpv6.Message{MessageType: msg.MessageType, TransactionID: msg.TransactionID}
+	for _, o := range msg.Options.Options {
+		if o.Code() == dhcpv6.OptionAuth {
+			o = &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: zeroed}
+		}
+		check.AddOption(o)
+	}
+	mac := hmac.New(md5.New, key)
+	mac.Write(check.ToBytes())
+	if !hmac.Equal(data[12:], mac.Sum(nil)) {
+		return 0, errors.New("invalid HMAC")
+	}
+	return binary.BigEndian.Uint64(data[3:11]), nil
+}
+
+// loadKeys loads the clients stored in the given reader. There is one client
+// per line: its DUID, Reconfigure Key and the DUID of the server which gave
+// it, in hex, and its address, "-" if unknown. Later lines for the same
+// client update the earlier ones
+func loadKeys(r io.Reader) (map[string]*client, error) {
+	sc := bufio.NewScanner(r)
+	clients := make(map[string]*client)
+	for sc.Scan() {
+		line := sc.Text()
+		if len(line) == 0 {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 4 {
+			return nil, fmt.Errorf("malformed line, want 4 fields, got %d: %s", len(tokens), line)
+		}
+		duid, err := parseDUID(tokens[0])
+		if err != nil {
+			return nil, err
+		}
+		key, err := hex.DecodeString(tokens[1])
+		if err != nil || len(key) != keyLength {
+			return nil, fmt.Errorf("malformed reconfigure key for %s", tokens[0])
+		}
+		serverID, err := parseDUID(tokens[2])
+		if err != nil {
+			return nil, err
+		}
+		c := &client{duid: *duid, key: key, serverID: dhcpv6.OptServerID(*serverID)}
+		if tokens[3] != "-" {
+			if c.addr = net.ParseIP(tokens[3]); c.addr == nil || c.addr.To4() != nil {
+				return nil, fmt.Errorf("expected an IPv6 address, got: %s", tokens[3])
+			}
+		}
+		clients[string(duid.ToBytes())] = c
+	}
+	return clients, sc.Err()
+}
+
+func parseDUID(s string) (*dhcpv6.Duid, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("malformed DUID: %s", s)
+	}
+	duid, err := dhcpv6.DuidFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed DUID %s: %w", s, err)
+	}
+	return duid, nil
+}
+
+func loadKeysFromFile(filename string) (map[string]*client, error) {
+	reader, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open key file %s: %w", filename, err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Warningf("Failed to close file %s: %v", filename, err)
+		}
+	}()
+	return loadKeys(reader)
+}
+
+// keyLine formats a client as a line of the key file
+func keyLine(c *client) string {
+	addr := "-"
+	if c.addr != nil {
+		addr = c.addr.String()
+	}
+	// The server ID option holds the DUID of the server
+	return hex.EncodeToString(c.duid.ToBytes()) + " " + hex.EncodeToString(c.key) + " " +
+		hex.EncodeToString(c.serverID.ToBytes()) + " " + addr
+}
+
+// saveKey writes out a client to storage, if there is one. Must be called
+// with the lock held
+func (p *PluginState) saveKey(c *client) error {
+	if p.keyfile == nil {
+		return nil
+	}
+	if _, err := p.keyfile.WriteString(keyLine(c) + "\n"); err != nil {
+		return err
+	}
+	return p.keyfile.Sync()
+}
+
+// registerBackingFile installs a file as the backing store for the keys. The
+// file holds secrets, so it is only readable by the server
+func (p *PluginState) registerBackingFile(filename string) error {
+	if p.keyfile != nil {
+		return errors.New("cannot swap out a key storage file while running")
+	}
+	keyfile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open key file %s: %w", filename, err)
+	}
+	p.keyfile = keyfile
+	return nil
+}
+
+// closeBackingFile syncs and closes the backing file of the keys, if any. The
+// keys are no longer saved afterwards. Must be called with the lock held
+func (p *PluginState) closeBackingFile() error {
+	if p.keyfile == nil {
+		return nil
+	}
+	keyfile := p.keyfile
+	p.keyfile = nil
+	if err := keyfile.Sync(); err != nil {
+		keyfile.Close()
+		return fmt.Errorf("cannot sync key file %s: %w", keyfile.Name(), err)
+	}
+	return keyfile.Close()
+}
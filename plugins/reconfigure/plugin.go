@@ -0,0 +1,300 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package reconfigure lets operators make DHCPv6 clients come back to the
+// server (RFC8415 §18.3.11), eg. to pick up new DNS servers without waiting
+// for T1.
+//
+// Clients announcing that they accept Reconfigure messages (Reconfigure
+// Accept option) are given a Reconfigure Key (RFC8415 §20.4) in the Reply,
+// which then authenticates the Reconfigure messages sent to them.
+// Reconfigure messages are sent to the first address assigned to the client,
+// so the plugin must come after the plugins assigning addresses:
+//
+// server6:
+//   plugins:
+//     - server_id: LL 00:de:ad:be:ef:00
+//     - file: "leases6.txt"
+//     - reconfigure: /run/coredhcp/reconfigure.sock file=/var/lib/coredhcp/reconfigure.keys
+//
+// Reconfigure messages are triggered through the control socket given as
+// argument, with one command per line:
+//
+//  renew <client DUID in hex | all>
+//  information-request <client DUID in hex | all>
+//
+// For example: `echo "renew all" | socat - UNIX-CONNECT:/run/coredhcp/reconfigure.sock`
+// Each command is answered with `ok <number of clients>` or `error <reason>`.
+//
+// With file=<path>, the keys are persisted in the given file, along with the
+// server and address of their clients, so the clients can still be
+// reconfigured after a restart. Otherwise the keys only live in memory, and
+// clients can only be reconfigured once they got a new key, at their next
+// renew.
+package reconfigure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/reconfigure")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "reconfigure",
+	Setup6: setup6,
+	Stop:   stop,
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// Authentication option fields for the Reconfigure Key Authentication
+// Protocol (RFC8415 §20.4 and §21.11)
+const (
+	authProtocolReconfigureKey = 3
+	authAlgorithmHMACMD5       = 1
+	authRDMMonotonic           = 0
+	authTypeKey                = 1
+	authTypeHMACMD5            = 2
+	keyLength                  = 16
+)
+
+type client struct {
+	duid     dhcpv6.Duid
+	addr     net.IP
+	key      []byte
+	serverID dhcpv6.Option
+}
+
+// PluginState holds the clients that can be reconfigured
+type PluginState struct {
+	sync.Mutex
+	clients map[string]*client
+	// lastReplay is the last replay detection value used. It must increase
+	// monotonically, including across restarts, so it is based on the time
+	lastReplay uint64
+	conn       net.PacketConn
+	keyfile    *os.File
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need the path of the control socket")
+	}
+	var filename string
+	for _, arg := range args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "file="):
+			filename = strings.TrimPrefix(arg, "file=")
+		default:
+			return nil, fmt.Errorf("unknown argument %q", arg)
+		}
+	}
+	p := &PluginState{clients: make(map[string]*client)}
+	if filename != "" {
+		var err error
+		if p.clients, err = loadKeysFromFile(filename); err != nil {
+			return nil, err
+		}
+		if err := p.registerBackingFile(filename); err != nil {
+			return nil, err
+		}
+		log.Infof("loaded %d reconfigure keys from %s", len(p.clients), filename)
+	}
+	conn, err := net.ListenPacket("udp6", "[::]:0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot open a socket to send reconfigure messages: %w", err)
+	}
+	p.conn = conn
+	if err := p.listenControl(args[0]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("loaded plugin for DHCPv6, control socket %s", args[0])
+	return p.Handler6, nil
+}
+
+// stop closes the key files of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	var err error
+	for _, p := range instances.list {
+		p.Lock()
+		if cerr := p.closeBackingFile(); cerr != nil && err == nil {
+			err = cerr
+		}
+		p.Unlock()
+	}
+	return err
+}
+
+// Handler6 hands out Reconfigure Keys to the clients accepting Reconfigure
+// messages, and records them for later use
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	// RFC8415 §20.4.2: the key is sent in the Reply to a Request, Renew,
+	// Rebind or Information-request
+	switch msg.Type() {
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeInformationRequest:
+	default:
+		return resp, false
+	}
+	if msg.GetOneOption(dhcpv6.OptionReconfAccept) == nil {
+		return resp, false
+	}
+	duid := msg.Options.ClientID()
+	reply, ok := resp.(*dhcpv6.Message)
+	if duid == nil || !ok {
+		return resp, false
+	}
+	serverID := reply.GetOneOption(dhcpv6.OptionServerID)
+	if serverID == nil {
+		log.Warning("No server ID in the reply, is the plugin after server_id?")
+		return resp, false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	key := string(duid.ToBytes())
+	c, ok := p.clients[key]
+	if !ok {
+		k, err := newKey()
+		if err != nil {
+			log.Error(err)
+			return resp, false
+		}
+		c = &client{duid: *duid, key: k}
+		p.clients[key] = c
+	}
+	changed := !ok || !bytes.Equal(c.serverID.ToBytes(), serverID.ToBytes())
+	c.serverID = serverID
+	if addr := assignedAddress(reply); addr != nil && !addr.Equal(c.addr) {
+		c.addr = addr
+		changed = true
+	}
+	if changed {
+		if err := p.saveKey(c); err != nil {
+			log.Errorf("Could not persist the reconfigure key of %s: %v", duid, err)
+		}
+	}
+
+	reply.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept})
+	reply.AddOption(p.authOption(authTypeKey, c.key))
+	return resp, false
+}
+
+// assignedAddress returns the first address assigned in a reply
+func assignedAddress(reply *dhcpv6.Message) net.IP {
+	for _, iana := range reply.Options.IANA() {
+		if addr := iana.Options.OneAddress(); addr != nil {
+			return addr.IPv6Addr
+		}
+	}
+	return nil
+}
+
+// nextReplay returns a new replay detection value. Must be called with the
+// lock held
+func (p *PluginState) nextReplay() uint64 {
+	replay := uint64(time.Now().UnixNano())
+	if replay <= p.lastReplay {
+		replay = p.lastReplay + 1
+	}
+	p.lastReplay = replay
+	return replay
+}
+
+// authOption builds an Authentication option of the Reconfigure Key
+// Authentication Protocol. Must be called with the lock held
+func (p *PluginState) authOption(infoType byte, value []byte) *dhcpv6.OptionGeneric {
+	data := make([]byte, 3+8+1+len(value))
+	data[0] = authProtocolReconfigureKey
+	data[1] = authAlgorithmHMACMD5
+	data[2] = authRDMMonotonic
+	binary.BigEndian.PutUint64(data[3:11], p.nextReplay())
+	data[11] = infoType
+	copy(data[12:], value)
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+}
+
+// reconfigureMessage builds an authenticated Reconfigure message asking the
+// client to send the given message type (Renew or Information-request). Must
+// be called with the lock held
+func (p *PluginState) reconfigureMessage(c *client, mt dhcpv6.MessageType) *dhcpv6.Message {
+	// RFC8415 §18.3.11: the transaction ID is zero
+	msg := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReconfigure}
+	msg.AddOption(c.serverID)
+	msg.AddOption(dhcpv6.OptClientID(c.duid))
+	msg.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfMessage, OptionData: []byte{byte(mt)}})
+	// The HMAC is computed over the whole message, with a zero HMAC field
+	auth := p.authOption(authTypeHMACMD5, make([]byte, md5.Size))
+	msg.AddOption(auth)
+	mac := hmac.New(md5.New, c.key)
+	mac.Write(msg.ToBytes())
+	copy(auth.OptionData[12:], mac.Sum(nil))
+	return msg
+}
+
+// reconfigure sends a Reconfigure message to the client with the given DUID,
+// or to all known clients if duid is nil. It returns the number of messages
+// sent
+func (p *PluginState) reconfigure(duid []byte, mt dhcpv6.MessageType) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	var targets []*client
+	if duid == nil {
+		for _, c := range p.clients {
+			targets = append(targets, c)
+		}
+	} else if c, ok := p.clients[string(duid)]; ok {
+		targets = append(targets, c)
+	} else {
+		return 0, errors.New("unknown client")
+	}
+
+	sent := 0
+	for _, c := range targets {
+		if c.addr == nil {
+			log.Warningf("No known address for client %s, cannot reconfigure it", &c.duid)
+			continue
+		}
+		msg := p.reconfigureMessage(c, mt)
+		peer := &net.UDPAddr{IP: c.addr, Port: dhcpv6.DefaultClientPort}
+		if _, err := p.conn.WriteTo(msg.ToBytes(), peer); err != nil {
+			log.Warningf("Could not send reconfigure to %s: %v", peer, err)
+			continue
+		}
+		log.Debugf("Sent reconfigure (%s) to %s at %s", mt, &c.duid, c.addr)
+		sent++
+	}
+	return sent, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package reconfigure
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testDUID = dhcpv6.Duid{
+	Type:          dhcpv6.DUID_LL,
+	HwType:        iana.HWTypeEthernet,
+	LinkLayerAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+}
+
+func request(t *testing.T, accept bool) (*dhcpv6.Message, *dhcpv6.Message) {
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptClientID(testDUID))
+	if accept {
+		req.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept})
+	}
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(dhcpv6.OptServerID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x00, 0xde, 0xad, 0xbe, 0xef, 0x00},
+	}))
+	resp.AddOption(&dhcpv6.OptIANA{
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10"), ValidLifetime: time.Hour},
+		}},
+	})
+	return req, resp
+}
+
+func TestKeyDistribution(t *testing.T) {
+	p := PluginState{clients: make(map[string]*client)}
+
+	req, resp := request(t, false)
+	result, _ := p.Handler6(req, resp)
+	assert.Nil(t, result.GetOneOption(dhcpv6.OptionAuth), "no key for clients not accepting reconfigure")
+	assert.Empty(t, p.clients)
+
+	req, resp = request(t, true)
+	result, _ = p.Handler6(req, resp)
+	auth := result.GetOneOption(dhcpv6.OptionAuth)
+	require.NotNil(t, auth)
+	data := auth.ToBytes()
+	require.Len(t, data, 12+keyLength)
+	assert.Equal(t, byte(authProtocolReconfigureKey), data[0])
+	assert.Equal(t, byte(authTypeKey), data[11])
+	assert.NotNil(t, result.GetOneOption(dhcpv6.OptionReconfAccept))
+
+	c := p.clients[string(testDUID.ToBytes())]
+	require.NotNil(t, c)
+	assert.Equal(t, c.key, data[12:])
+	assert.True(t, c.addr.Equal(net.ParseIP("2001:db8::10")))
+}
+
+func TestReconfigureMessage(t *testing.T) {
+	p := PluginState{clients: make(map[string]*client)}
+	req, resp := request(t, true)
+	p.Handler6(req, resp)
+	c := p.clients[string(testDUID.ToBytes())]
+
+	msg := p.reconfigureMessage(c, dhcpv6.MessageTypeRenew)
+	parsed, err := dhcpv6.FromBytes(msg.ToBytes())
+	require.NoError(t, err)
+	m := parsed.(*dhcpv6.Message)
+	assert.Equal(t, dhcpv6.MessageTypeReconfigure, m.Type())
+	assert.Equal(t, []byte{byte(dhcpv6.MessageTypeRenew)}, m.GetOneOption(dhcpv6.OptionReconfMessage).ToBytes())
+
+	// Verify the HMAC as a client would
+	replay, err := verify(m, c.key)
+	require.NoError(t, err)
+	assert.Equal(t, p.lastReplay, replay)
+
+	other, err := newKey()
+	require.NoError(t, err)
+	_, err = verify(m, other)
+	assert.Error(t, err, "message verified with another key")
+	m.Options.Del(dhcpv6.OptionReconfMessage)
+	_, err = verify(m, c.key)
+	assert.Error(t, err, "tampered message verified")
+}
+
+func TestKeyStorage(t *testing.T) {
+	f, err := ioutil.TempFile("", "reconfigure.keys")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	p := PluginState{clients: make(map[string]*client)}
+	require.NoError(t, p.registerBackingFile(f.Name()))
+	req, resp := request(t, true)
+	p.Handler6(req, resp)
+	// Unchanged clients are not written again
+	req, resp = request(t, true)
+	p.Handler6(req, resp)
+
+	content, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(content, []byte("\n")))
+
+	clients, err := loadKeysFromFile(f.Name())
+	require.NoError(t, err)
+	c := clients[string(testDUID.ToBytes())]
+	require.NotNil(t, c)
+	stored := p.clients[string(testDUID.ToBytes())]
+	assert.Equal(t, stored.key, c.key)
+	assert.Equal(t, stored.serverID.ToBytes(), c.serverID.ToBytes())
+	assert.True(t, c.addr.Equal(stored.addr))
+
+	// Once closed, the keys are no longer saved
+	require.NoError(t, p.closeBackingFile())
+	assert.Nil(t, p.keyfile)
+	require.NoError(t, p.saveKey(stored))
+	require.NoError(t, p.closeBackingFile())
+
+	// A restored client can be reconfigured
+	restored := PluginState{clients: clients}
+	_, err = verify(restored.reconfigureMessage(c, dhcpv6.MessageTypeRenew), stored.key)
+	assert.NoError(t, err)
+
+	for _, line := range []string{
+		"00030001aabbccddeeff 00",
+		"zz 000102030405060708090a0b0c0d0e0f 00030001deadbeef0000 -",
+		"00030001aabbccddeeff 0001 00030001deadbeef0000 -",
+		"00030001aabbccddeeff 000102030405060708090a0b0c0d0e0f 00030001deadbeef0000 10.0.0.1",
+	} {
+		_, err := loadKeys(strings.NewReader(line))
+		assert.Error(t, err, line)
+	}
+	clients, err = loadKeys(strings.NewReader("00030001aabbccddeeff 000102030405060708090a0b0c0d0e0f 00030001deadbeef0000 -\n"))
+	require.NoError(t, err)
+	assert.Nil(t, clients[string(testDUID.ToBytes())].addr)
+}
+
+func TestReplayIncreases(t *testing.T) {
+	var p PluginState
+	a, b := p.nextReplay(), p.nextReplay()
+	assert.Greater(t, b, a)
+}
+
+func TestCommand(t *testing.T) {
+	p := PluginState{clients: make(map[string]*client)}
+	assert.Equal(t, "ok 0", p.command("renew all"))
+	assert.Equal(t, "error unknown client", p.command("information-request 00030001aabbccddeeff"))
+	assert.Contains(t, p.command("rebind all"), "error")
+	assert.Contains(t, p.command("renew zz"), "error")
+}
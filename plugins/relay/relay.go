@@ -0,0 +1,85 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package relay gives plugins access to the information added by the relay
+// agents a request went through: the chain of DHCPv6 Relay-Forward messages
+// with their link address, Interface-ID (option 18) and Remote-ID (option
+// 37), and the DHCPv4 Relay Agent Information (option 82) with its Circuit-ID
+// and Remote-ID sub-options.
+package relay
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Hop6 is one of the relay agents a DHCPv6 message went through
+type Hop6 struct {
+	HopCount uint8
+	// LinkAddr identifies the link of the client, it is unspecified when
+	// the relay can't tell (eg. a relay whose peer is another relay)
+	LinkAddr net.IP
+	PeerAddr net.IP
+	// InterfaceID is nil if the relay didn't send the option
+	InterfaceID []byte
+	// RemoteID is nil if the relay didn't send the option
+	RemoteID *dhcpv6.OptRemoteID
+}
+
+// Chain6 returns the relays a DHCPv6 message went through, starting with the
+// relay closest to the client. It is empty for messages that were not relayed
+func Chain6(d dhcpv6.DHCPv6) []Hop6 {
+	var chain []Hop6
+	for d != nil && d.IsRelay() {
+		r, ok := d.(*dhcpv6.RelayMessage)
+		if !ok {
+			break
+		}
+		chain = append(chain, Hop6{
+			HopCount:    r.HopCount,
+			LinkAddr:    r.LinkAddr,
+			PeerAddr:    r.PeerAddr,
+			InterfaceID: r.Options.InterfaceID(),
+			RemoteID:    r.Options.RemoteID(),
+		})
+		d = r.Options.RelayMessage()
+	}
+	// Reverse, to start from the relay closest to the client
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// LinkAddr6 returns the address identifying the link of the client: the
+// first specified link address, starting from the relay closest to the
+// client. It is nil for messages that were not relayed
+func LinkAddr6(d dhcpv6.DHCPv6) net.IP {
+	for _, hop := range Chain6(d) {
+		if hop.LinkAddr != nil && !hop.LinkAddr.IsUnspecified() {
+			return hop.LinkAddr
+		}
+	}
+	return nil
+}
+
+// CircuitID4 returns the Agent Circuit ID sub-option of the Relay Agent
+// Information option (RFC3046), or nil
+func CircuitID4(req *dhcpv4.DHCPv4) []byte {
+	if rai := req.RelayAgentInfo(); rai != nil {
+		return rai.Get(dhcpv4.AgentCircuitIDSubOption)
+	}
+	return nil
+}
+
+// RemoteID4 returns the Agent Remote ID sub-option of the Relay Agent
+// Information option (RFC3046), or nil
+func RemoteID4(req *dhcpv4.DHCPv4) []byte {
+	if rai := req.RelayAgentInfo(); rai != nil {
+		return rai.Get(dhcpv4.AgentRemoteIDSubOption)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package relay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// relayed builds a Solicit that went through a first relay on link 2001:db8:1::1,
+// then through a second relay that doesn't know the client link
+func relayed(t *testing.T) dhcpv6.DHCPv6 {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+
+	first, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward,
+		net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	first.AddOption(dhcpv6.OptInterfaceID([]byte("olt1/1")))
+	first.AddOption(&dhcpv6.OptRemoteID{EnterpriseNumber: 3561, RemoteID: []byte("cpe42")})
+
+	second, err := dhcpv6.EncapsulateRelay(first, dhcpv6.MessageTypeRelayForward,
+		net.IPv6unspecified, net.ParseIP("2001:db8:1::1"))
+	require.NoError(t, err)
+	second.AddOption(dhcpv6.OptInterfaceID([]byte("agg0")))
+
+	// Go through the wire format, as the server does
+	d, err := dhcpv6.FromBytes(second.ToBytes())
+	require.NoError(t, err)
+	return d
+}
+
+func TestChain6(t *testing.T) {
+	chain := Chain6(relayed(t))
+	require.Len(t, chain, 2)
+
+	assert.Equal(t, uint8(0), chain[0].HopCount)
+	assert.True(t, chain[0].LinkAddr.Equal(net.ParseIP("2001:db8:1::1")))
+	assert.Equal(t, []byte("olt1/1"), chain[0].InterfaceID)
+	require.NotNil(t, chain[0].RemoteID)
+	assert.Equal(t, []byte("cpe42"), chain[0].RemoteID.RemoteID)
+
+	assert.Equal(t, uint8(1), chain[1].HopCount)
+	assert.Equal(t, []byte("agg0"), chain[1].InterfaceID)
+	assert.Nil(t, chain[1].RemoteID)
+
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	assert.Empty(t, Chain6(msg))
+}
+
+func TestLinkAddr6(t *testing.T) {
+	assert.True(t, LinkAddr6(relayed(t)).Equal(net.ParseIP("2001:db8:1::1")))
+
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	assert.Nil(t, LinkAddr6(msg))
+}
+
+func TestRelayAgentInfo4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	assert.Nil(t, CircuitID4(req))
+	assert.Nil(t, RemoteID4(req))
+
+	req.UpdateOption(dhcpv4.OptRelayAgentInfo(
+		dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0/1")),
+		dhcpv4.OptGeneric(dhcpv4.AgentRemoteIDSubOption, []byte("cpe42")),
+	))
+	assert.Equal(t, []byte("eth0/1"), CircuitID4(req))
+	assert.Equal(t, []byte("cpe42"), RemoteID4(req))
+}
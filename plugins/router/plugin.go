@@ -24,6 +24,7 @@ var Plugin = plugins.Plugin{
 
 var (
 	routers []net.IP
+	options handler.StaticOptions4
 )
 
 func setup4(args ...string) (handler.Handler4, error) {
@@ -38,12 +39,13 @@ func setup4(args ...string) (handler.Handler4, error) {
 		}
 		routers = append(routers, router)
 	}
+	options.Add(dhcpv4.OptRouter(routers...))
 	log.Infof("loaded %d router IP addresses.", len(routers))
 	return Handler4, nil
 }
 
 //Handler4 handles DHCPv4 packets for the router plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.Options.Update(dhcpv4.OptRouter(routers...))
+	options.Splice(req, resp)
 	return resp, false
 }
@@ -0,0 +1,32 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package plugins
+
+import "sync"
+
+// scopes numbers the plugin chains as they are set up, see Scope
+var scopes struct {
+	sync.Mutex
+	last int
+}
+
+// enterScope gives the plugin chain about to be set up a scope of its own
+func enterScope() {
+	scopes.Lock()
+	defer scopes.Unlock()
+	scopes.last++
+}
+
+// Scope returns the scope of the plugin chain being set up: each chain of the
+// configuration (see config.ServerConfig.Chains) and each of their sub-chains
+// has its own. It is meant to be called by the setup functions of the plugins
+// whose instances share state within a chain only, eg. the ranges of the
+// range plugin, the chains being set up one at a time. It is 0 until a chain
+// is set up, eg. in tests
+func Scope() int {
+	scopes.Lock()
+	defer scopes.Unlock()
+	return scopes.last
+}
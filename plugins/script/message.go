@@ -0,0 +1,308 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"go.starlark.net/starlark"
+
+	"github.com/coredhcp/coredhcp/plugins/duid"
+)
+
+// optionValue converts the value given to set_option
+func optionValue(v starlark.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return []byte(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("invalid option value %s, expected a string or bytes", v.Type())
+}
+
+// ipString returns an IP address as a script sees it, None when unset
+func ipString(ip net.IP) starlark.Value {
+	if ip == nil || ip.IsUnspecified() {
+		return starlark.None
+	}
+	return starlark.String(ip.String())
+}
+
+var errReadOnly = errors.New("the request is read-only")
+
+// message4 is a DHCPv4 message, as seen by the scripts: the request,
+// read-only, or the response. The response is copied when first modified, to
+// be left as it was if the script fails
+type message4 struct {
+	msg      *dhcpv4.DHCPv4
+	readOnly bool
+	copied   bool
+}
+
+var message4Attrs = []string{
+	"boot_file_name", "ciaddr", "del_option", "giaddr", "lease_time", "mac",
+	"option", "set_option", "siaddr", "type", "xid", "yiaddr",
+}
+
+func (m *message4) String() string        { return m.msg.Summary() }
+func (m *message4) Type() string          { return "message4" }
+func (m *message4) Freeze()               {}
+func (m *message4) Truth() starlark.Bool  { return starlark.True }
+func (m *message4) Hash() (uint32, error) { return 0, errors.New("unhashable type: message4") }
+func (m *message4) AttrNames() []string   { return message4Attrs }
+
+// modify returns the message to modify, copying it first
+func (m *message4) modify() (*dhcpv4.DHCPv4, error) {
+	if m.readOnly {
+		return nil, errReadOnly
+	}
+	if !m.copied {
+		msg, err := dhcpv4.FromBytes(m.msg.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		m.msg, m.copied = msg, true
+	}
+	return m.msg, nil
+}
+
+func (m *message4) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "type":
+		return starlark.String(m.msg.MessageType().String()), nil
+	case "mac":
+		return starlark.String(m.msg.ClientHWAddr.String()), nil
+	case "xid":
+		return starlark.MakeUint64(uint64(m.msg.TransactionID[0])<<24 |
+			uint64(m.msg.TransactionID[1])<<16 |
+			uint64(m.msg.TransactionID[2])<<8 |
+			uint64(m.msg.TransactionID[3])), nil
+	case "ciaddr":
+		return ipString(m.msg.ClientIPAddr), nil
+	case "yiaddr":
+		return ipString(m.msg.YourIPAddr), nil
+	case "siaddr":
+		return ipString(m.msg.ServerIPAddr), nil
+	case "giaddr":
+		return ipString(m.msg.GatewayIPAddr), nil
+	case "boot_file_name":
+		return starlark.String(m.msg.BootFileName), nil
+	case "lease_time":
+		if !m.msg.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+			return starlark.None, nil
+		}
+		return starlark.MakeInt64(int64(m.msg.IPAddressLeaseTime(0) / time.Second)), nil
+	case "option":
+		return starlark.NewBuiltin("option", m.option), nil
+	case "set_option":
+		return starlark.NewBuiltin("set_option", m.setOption), nil
+	case "del_option":
+		return starlark.NewBuiltin("del_option", m.delOption), nil
+	}
+	return nil, nil
+}
+
+func (m *message4) SetField(name string, v starlark.Value) error {
+	msg, err := m.modify()
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "yiaddr", "siaddr":
+		var ip net.IP
+		if s, ok := starlark.AsString(v); ok {
+			ip = net.ParseIP(s).To4()
+		}
+		if ip == nil {
+			return fmt.Errorf("invalid %s %s, expected an IPv4 address", name, v)
+		}
+		if name == "yiaddr" {
+			msg.YourIPAddr = ip
+		} else {
+			msg.ServerIPAddr = ip
+		}
+	case "boot_file_name":
+		s, ok := starlark.AsString(v)
+		if !ok {
+			return fmt.Errorf("invalid boot_file_name %s, expected a string", v)
+		}
+		msg.BootFileName = s
+	case "lease_time":
+		seconds, err := starlark.AsInt32(v)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid lease_time %s, expected seconds", v)
+		}
+		msg.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Duration(seconds) * time.Second))
+	default:
+		return fmt.Errorf("cannot set %s of a message4", name)
+	}
+	return nil
+}
+
+func (m *message4) option(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code uint8
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	v := m.msg.Options.Get(dhcpv4.GenericOptionCode(code))
+	if v == nil {
+		return starlark.None, nil
+	}
+	return starlark.String(v), nil
+}
+
+func (m *message4) setOption(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		code  uint8
+		value starlark.Value
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &code, &value); err != nil {
+		return nil, err
+	}
+	data, err := optionValue(value)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := m.modify()
+	if err != nil {
+		return nil, err
+	}
+	msg.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), data))
+	return starlark.None, nil
+}
+
+func (m *message4) delOption(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code uint8
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	msg, err := m.modify()
+	if err != nil {
+		return nil, err
+	}
+	delete(msg.Options, code)
+	return starlark.None, nil
+}
+
+// message6 is a DHCPv6 message, as seen by the scripts, like message4. The
+// options are those of the innermost message of relayed requests
+type message6 struct {
+	msg      dhcpv6.DHCPv6
+	inner    *dhcpv6.Message
+	readOnly bool
+	copied   bool
+}
+
+var message6Attrs = []string{"del_option", "mac", "option", "set_option", "type"}
+
+func newMessage6(msg dhcpv6.DHCPv6, readOnly bool) (*message6, error) {
+	inner, err := msg.GetInnerMessage()
+	if err != nil {
+		return nil, err
+	}
+	return &message6{msg: msg, inner: inner, readOnly: readOnly}, nil
+}
+
+func (m *message6) String() string        { return m.msg.Summary() }
+func (m *message6) Type() string          { return "message6" }
+func (m *message6) Freeze()               {}
+func (m *message6) Truth() starlark.Bool  { return starlark.True }
+func (m *message6) Hash() (uint32, error) { return 0, errors.New("unhashable type: message6") }
+func (m *message6) AttrNames() []string   { return message6Attrs }
+
+// modify returns the message to modify, copying it first
+func (m *message6) modify() (*dhcpv6.Message, error) {
+	if m.readOnly {
+		return nil, errReadOnly
+	}
+	if !m.copied {
+		msg, err := dhcpv6.FromBytes(m.msg.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		inner, err := msg.GetInnerMessage()
+		if err != nil {
+			return nil, err
+		}
+		m.msg, m.inner, m.copied = msg, inner, true
+	}
+	return m.inner, nil
+}
+
+func (m *message6) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "type":
+		return starlark.String(m.inner.MessageType.String()), nil
+	case "mac":
+		mac, _ := duid.HWAddr(m.msg)
+		if mac == nil {
+			return starlark.None, nil
+		}
+		return starlark.String(mac.String()), nil
+	case "option":
+		return starlark.NewBuiltin("option", m.option), nil
+	case "set_option":
+		return starlark.NewBuiltin("set_option", m.setOption), nil
+	case "del_option":
+		return starlark.NewBuiltin("del_option", m.delOption), nil
+	}
+	return nil, nil
+}
+
+func (m *message6) option(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code uint16
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	opt := m.inner.Options.GetOne(dhcpv6.OptionCode(code))
+	if opt == nil {
+		return starlark.None, nil
+	}
+	return starlark.String(opt.ToBytes()), nil
+}
+
+func (m *message6) setOption(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		code  uint16
+		value starlark.Value
+	)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &code, &value); err != nil {
+		return nil, err
+	}
+	data, err := optionValue(value)
+	if err != nil {
+		return nil, err
+	}
+	// Parsed, for the next plugins to find the options they know
+	opt, err := dhcpv6.ParseOption(dhcpv6.OptionCode(code), data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid option %d: %w", b.Name(), code, err)
+	}
+	msg, err := m.modify()
+	if err != nil {
+		return nil, err
+	}
+	msg.UpdateOption(opt)
+	return starlark.None, nil
+}
+
+func (m *message6) delOption(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var code uint16
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &code); err != nil {
+		return nil, err
+	}
+	msg, err := m.modify()
+	if err != nil {
+		return nil, err
+	}
+	msg.Options.Del(dhcpv6.OptionCode(code))
+	return starlark.None, nil
+}
@@ -0,0 +1,386 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package script implements a plugin running a Starlark
+// (https://github.com/bazelbuild/starlark) function per request, for policies
+// too small to justify a compiled plugin.
+//
+// The script defines handle4(req, resp) and/or handle6(req, resp), which
+// return CONTINUE (or None) to go on with the next plugins, STOP to stop the
+// chain, or DROP to drop the request. The request is read-only. The messages
+// have these attributes, the settable ones being set on the response:
+// - type: the message type, eg. "DISCOVER" or "SOLICIT"
+// - mac: the MAC address of the client, None if unknown (DHCPv6)
+// - option(code): the value of an option, as a string of bytes, None if unset
+// - set_option(code, value): sets an option, given a string or bytes
+// - del_option(code): removes an option
+// - xid, ciaddr, yiaddr, siaddr, giaddr, boot_file_name, lease_time: the
+// fields of DHCPv4 messages, the addresses being None when unset. yiaddr,
+// siaddr, boot_file_name and lease_time (in seconds) are settable
+//
+// Options of relayed DHCPv6 requests are those of the innermost message.
+// Scripts can also use:
+// - args: the arguments of the script in the configuration
+// - metadata_get(key, default=None) and metadata_set(key, value): the string
+// metadata of the request (see handler.Metadata)
+// - ip(address): an IP address as a string of bytes, for options
+// - print(...): logs a message
+//
+// The script is loaded when the server starts. Its top-level statements run
+// once, its global values being read-only afterwards.
+//
+// Arguments are the settings of the plugin, then the path of the script, then
+// its arguments:
+// - timeout=<duration>: how long the script can take to handle a request,
+// 100ms by default
+// - on-error=<continue|drop>: what to do when the script fails to handle a
+// request: pass the response as it was before the script to the next plugins
+// (by default), or drop the request
+//
+// They can also be given as a map, see Config.
+//
+// server4:
+//   plugins:
+//     - script: /etc/coredhcp/policy.star 192.0.2.10
+//
+// with /etc/coredhcp/policy.star:
+//
+//   def handle4(req, resp):
+//       if req.option(60) == "PXEClient":
+//           resp.siaddr = args[0]
+//           resp.boot_file_name = "pxelinux.0"
+//       return CONTINUE
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"go.starlark.net/starlark"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+var log = logger.GetLogger("plugins/script")
+
+// Plugin wraps the script plugin information.
+var Plugin = plugins.Plugin{
+	Name:         "script",
+	Setup6Ctx:    setup6,
+	Setup4Ctx:    setup4,
+	NewConfig:    newConfig,
+	Setup6Config: setupConfig6,
+	Setup4Config: setupConfig4,
+}
+
+// What to do after the script, as returned by the handlers
+const (
+	actionContinue = starlark.String("continue")
+	actionStop     = starlark.String("stop")
+	actionDrop     = starlark.String("drop")
+)
+
+// contextKey is the thread local holding the context of the request
+const contextKey = "context"
+
+// Config is the typed configuration of the plugin, for its settings given as
+// a map:
+//
+// - script:
+//     path: /etc/coredhcp/policy.star
+//     args: [192.0.2.10]
+//     timeout: 1s
+//     on-error: drop
+type Config struct {
+	Path    string        `config:"path,required"`
+	Args    []string      `config:"args"`
+	Timeout time.Duration `config:"timeout"`
+	OnError string        `config:"on-error"`
+}
+
+func newConfig() interface{} {
+	return &Config{Timeout: 100 * time.Millisecond, OnError: "continue"}
+}
+
+// Validate checks the settings of the plugin
+func (c *Config) Validate() error {
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid timeout %s", c.Timeout)
+	}
+	switch c.OnError {
+	case "continue", "drop":
+	default:
+		return fmt.Errorf("invalid on-error %q, expected continue or drop", c.OnError)
+	}
+	return nil
+}
+
+// PluginState holds the configuration of an instance of the plugin, and the
+// script it runs
+type PluginState struct {
+	version int
+	path    string
+	args    []string
+	timeout time.Duration
+	drop    bool
+	handler starlark.Callable
+}
+
+func newState(version int, c *Config) *PluginState {
+	return &PluginState{
+		version: version,
+		path:    c.Path,
+		args:    c.Args,
+		timeout: c.Timeout,
+		drop:    c.OnError == "drop",
+	}
+}
+
+func parseArgs(version int, args ...string) (*PluginState, error) {
+	c := newConfig().(*Config)
+	for i, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			// The script, and its arguments
+			c.Path, c.Args = arg, args[i+1:]
+			break
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			c.Timeout = timeout
+		case "on-error":
+			c.OnError = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if c.Path == "" {
+		return nil, errors.New("need the path of the script to run")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return newState(version, c), nil
+}
+
+func metadataGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		key string
+		def starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &def); err != nil {
+		return nil, err
+	}
+	ctx, _ := thread.Local(contextKey).(context.Context)
+	if v, ok := handler.MetadataFromContext(ctx).Get(key); ok {
+		if s, ok := v.(string); ok {
+			return starlark.String(s), nil
+		}
+	}
+	return def, nil
+}
+
+func metadataSet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, value string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	ctx, _ := thread.Local(contextKey).(context.Context)
+	handler.MetadataFromContext(ctx).Set(key, value)
+	return starlark.None, nil
+}
+
+func ip(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	addr := net.ParseIP(s)
+	if addr == nil {
+		return nil, fmt.Errorf("%s: invalid IP address %q", b.Name(), s)
+	}
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	return starlark.String(addr), nil
+}
+
+// load runs the script, returning its handler
+func (p *PluginState) load() error {
+	src, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	args := make([]starlark.Value, len(p.args))
+	for i, arg := range p.args {
+		args[i] = starlark.String(arg)
+	}
+	predeclared := starlark.StringDict{
+		"CONTINUE":     actionContinue,
+		"STOP":         actionStop,
+		"DROP":         actionDrop,
+		"args":         starlark.NewList(args),
+		"metadata_get": starlark.NewBuiltin("metadata_get", metadataGet),
+		"metadata_set": starlark.NewBuiltin("metadata_set", metadataSet),
+		"ip":           starlark.NewBuiltin("ip", ip),
+	}
+	predeclared.Freeze()
+	globals, err := starlark.ExecFile(p.newThread(context.Background()), p.path, src, predeclared)
+	if err != nil {
+		return err
+	}
+	globals.Freeze()
+	name := fmt.Sprintf("handle%d", p.version)
+	fn, ok := globals[name].(starlark.Callable)
+	if !ok {
+		return fmt.Errorf("%s does not define %s", p.path, name)
+	}
+	p.handler = fn
+	return nil
+}
+
+func (p *PluginState) newThread(ctx context.Context) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: p.path,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Infof("%s: %s", p.path, msg)
+		},
+	}
+	thread.SetLocal(contextKey, ctx)
+	return thread
+}
+
+// run calls the handler of the script, returning what to do next
+func (p *PluginState) run(ctx context.Context, req, resp starlark.Value) (starlark.String, error) {
+	thread := p.newThread(ctx)
+	timer := time.AfterFunc(p.timeout, func() {
+		thread.Cancel("timeout")
+	})
+	defer timer.Stop()
+	v, err := starlark.Call(thread, p.handler, starlark.Tuple{req, resp}, nil)
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			return "", errors.New(evalErr.Backtrace())
+		}
+		return "", err
+	}
+	switch v {
+	case starlark.None, actionContinue:
+		return actionContinue, nil
+	case actionStop, actionDrop:
+		return v.(starlark.String), nil
+	}
+	return "", fmt.Errorf("%s returned %s, expected CONTINUE, STOP or DROP", p.handler.Name(), v)
+}
+
+// Handler6 handles DHCPv6 packets for the script plugin
+func (p *PluginState) Handler6(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	in, err := newMessage6(req, true)
+	var out *message6
+	if err == nil {
+		out, err = newMessage6(resp, false)
+	}
+	var action starlark.String
+	if err == nil {
+		action, err = p.run(ctx, in, out)
+	}
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	switch action {
+	case actionDrop:
+		return nil, true
+	case actionStop:
+		return out.msg, true
+	}
+	return out.msg, false
+}
+
+// Handler4 handles DHCPv4 packets for the script plugin
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	out := &message4{msg: resp}
+	action, err := p.run(ctx, &message4{msg: req, readOnly: true}, out)
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	switch action {
+	case actionDrop:
+		return nil, true
+	case actionStop:
+		return out.msg, true
+	}
+	return out.msg, false
+}
+
+// setup loads the script of an instance of the plugin
+func (p *PluginState) setup() error {
+	if err := p.load(); err != nil {
+		return err
+	}
+	log.Printf("DHCPv%d: running %s", p.version, p.path)
+	return nil
+}
+
+func setup6(args ...string) (handler.Handler6Ctx, error) {
+	p, err := parseArgs(6, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.setup(); err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := parseArgs(4, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.setup(); err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setupConfig6(conf interface{}) (handler.Handler6Ctx, error) {
+	p := newState(6, conf.(*Config))
+	if err := p.setup(); err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setupConfig4(conf interface{}) (handler.Handler4Ctx, error) {
+	p := newState(4, conf.(*Config))
+	if err := p.setup(); err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+const testScript = `
+routers = {"de:ad:be:ef:00:00": "192.0.2.1"}
+
+def handle4(req, resp):
+    mac = req.mac
+    if mac == "de:ad:be:ef:00:01":
+        return DROP
+    if mac == "de:ad:be:ef:00:02":
+        resp.boot_file_name = "partial"
+        fail("failing")
+    if mac == "de:ad:be:ef:00:03":
+        for i in range(1 << 30):
+            pass
+    if mac == "de:ad:be:ef:00:04":
+        req.boot_file_name = "read-only"
+    resp.yiaddr = "192.0.2.100"
+    resp.lease_time = 600
+    resp.set_option(3, ip(routers[mac]))
+    resp.del_option(54)
+    resp.boot_file_name = metadata_get("test.file", args[0])
+    metadata_set("test.type", req.type)
+    return STOP
+
+def handle6(req, resp):
+    resp.set_option(59, "http://[2001:db8::1]/" + args[0])
+`
+
+func writeScript(t *testing.T) string {
+	f, err := ioutil.TempFile("", "coredhcp-script")
+	require.NoError(t, err)
+	_, err = f.WriteString(testScript)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs(4, "timeout=1s", "on-error=drop", "/etc/coredhcp/policy.star", "timeout=2s", "arg")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/coredhcp/policy.star", p.path)
+	assert.Equal(t, []string{"timeout=2s", "arg"}, p.args)
+	assert.Equal(t, time.Second, p.timeout)
+	assert.True(t, p.drop)
+
+	for _, args := range [][]string{
+		{},
+		{"timeout=1s"},
+		{"timeout=0", "policy.star"},
+		{"on-error=retry", "policy.star"},
+		{"retries=1", "policy.star"},
+	} {
+		_, err := parseArgs(4, args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := writeScript(t)
+	defer os.Remove(path)
+	c := newConfig().(*Config)
+	c.Path, c.Args = path, []string{"boot.efi"}
+	require.NoError(t, c.Validate())
+	_, err := setupConfig4(c)
+	require.NoError(t, err)
+	h6, err := setupConfig6(c)
+	require.NoError(t, err)
+	assert.NotNil(t, h6)
+
+	c.OnError = "retry"
+	assert.Error(t, c.Validate())
+	c.OnError, c.Timeout = "drop", 0
+	assert.Error(t, c.Validate())
+}
+
+func TestScript4(t *testing.T) {
+	path := writeScript(t)
+	defer os.Remove(path)
+	h4, err := setup4("timeout=50ms", path, "pxelinux.0")
+	require.NoError(t, err)
+
+	handle := func(mac net.HardwareAddr, md map[string]string) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4, bool, *handler.Metadata) {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithServerIP(net.IPv4(192, 0, 2, 2)))
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		for k, v := range md {
+			handler.MetadataFromContext(ctx).Set(k, v)
+		}
+		out, stop := h4(ctx, req, resp)
+		return resp, out, stop, handler.MetadataFromContext(ctx)
+	}
+
+	_, resp, stop, md := handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}, nil)
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, "192.0.2.100", resp.YourIPAddr.String())
+	assert.Equal(t, 10*time.Minute, resp.IPAddressLeaseTime(0))
+	assert.Equal(t, []net.IP{net.IPv4(192, 0, 2, 1).To4()}, resp.Router())
+	assert.False(t, resp.Options.Has(dhcpv4.OptionServerIdentifier))
+	assert.Equal(t, "pxelinux.0", resp.BootFileName)
+	v, _ := md.Get("test.type")
+	assert.Equal(t, "DISCOVER", v)
+
+	_, resp, _, _ = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}, map[string]string{"test.file": "ipxe.efi"})
+	require.NotNil(t, resp)
+	assert.Equal(t, "ipxe.efi", resp.BootFileName)
+
+	// Dropped
+	_, resp, stop, _ = handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 1}, nil)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+
+	// Failing, timing out, or modifying the request: the response is passed
+	// on as it was
+	for _, last := range []byte{2, 3, 4} {
+		orig, resp, stop, _ := handle(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, last}, nil)
+		assert.Equal(t, orig, resp)
+		assert.Equal(t, "", resp.BootFileName)
+		assert.False(t, stop)
+	}
+}
+
+func TestScript6(t *testing.T) {
+	path := writeScript(t)
+	defer os.Remove(path)
+	h6, err := setup6(path, "boot.efi")
+	require.NoError(t, err)
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	require.NoError(t, err)
+	advertise, err := dhcpv6.NewAdvertiseFromSolicit(solicit)
+	require.NoError(t, err)
+
+	resp, stop := h6(handler.NewContext(context.Background()), relayed, advertise)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	msg, err := resp.GetInnerMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "http://[2001:db8::1]/boot.efi", msg.Options.BootFileURL())
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-script")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"syntax.star":  "def handle4(req, resp)\n",
+		"missing.star": "def handle6(req, resp):\n    pass\n",
+		"failing.star": "fail('failing')\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+		_, err := setup4(path)
+		assert.Error(t, err, name)
+	}
+	_, err = setup4(filepath.Join(dir, "none.star"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package searchdomains
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxLabelLen and maxNameLen are the RFC1035 limits, maxNameLen being
+	// the length of the encoded name
+	maxLabelLen = 63
+	maxNameLen  = 255
+	// maxSearchListLen is the most that fits in a single DHCPv4 option.
+	// Longer lists would need clients supporting RFC3396 long options
+	maxSearchListLen = 255
+)
+
+// checkDomain validates a domain name and returns it without its trailing dot
+func checkDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return "", fmt.Errorf("empty search domain")
+	}
+	// One length byte per label plus the final zero
+	if len(domain)+2 > maxNameLen {
+		return "", fmt.Errorf("search domain %q is too long", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > maxLabelLen {
+			return "", fmt.Errorf("invalid label %q in search domain %q", label, domain)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", fmt.Errorf("invalid character %q in search domain %q", c, domain)
+			}
+		}
+	}
+	return domain, nil
+}
+
+// compressDomains encodes a search list as described in RFC3397: the domains
+// are concatenated in RFC1035 format, and a domain ending with a suffix that
+// was already encoded refers to it with a compression pointer.
+//
+// Pointers only ever target names that were written out in full, so that
+// decoding never has to follow a pointer to another pointer; some decoders,
+// including the one of our DHCP library, don't handle that
+func compressDomains(domains []string) []byte {
+	var buf []byte
+	// suffixes maps the lowercase names written out in full, and each of
+	// their suffixes, to their offset in buf
+	suffixes := make(map[string]int)
+	for _, domain := range domains {
+		labels := strings.Split(domain, ".")
+		ptr := -1
+		for i := range labels {
+			if off, ok := suffixes[strings.ToLower(strings.Join(labels[i:], "."))]; ok {
+				labels, ptr = labels[:i], off
+				break
+			}
+		}
+		offsets := make([]int, 0, len(labels))
+		for _, label := range labels {
+			offsets = append(offsets, len(buf))
+			buf = append(buf, byte(len(label)))
+			buf = append(buf, label...)
+		}
+		if ptr >= 0 {
+			buf = append(buf, 0xc0|byte(ptr>>8), byte(ptr))
+			continue
+		}
+		buf = append(buf, 0)
+		for i, off := range offsets {
+			// Offsets above 0x3fff can't be pointed to
+			if off <= 0x3fff {
+				suffixes[strings.ToLower(strings.Join(labels[i:], "."))] = off
+			}
+		}
+	}
+	return buf
+}
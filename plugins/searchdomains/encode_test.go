@@ -0,0 +1,70 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package searchdomains
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressRFC3397(t *testing.T) {
+	// The example of RFC3397 section 2
+	want := []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04")
+	assert.Equal(t, want, compressDomains([]string{"eng.apple.com", "marketing.apple.com"}))
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	domains := []string{
+		"a.b.example.org",
+		"c.b.example.org",
+		"d.c.b.example.org",
+		"example.org",
+		"Example.COM",
+		"example.com",
+	}
+	encoded := compressDomains(domains)
+	// Compression makes the list shorter than the plain encoding
+	assert.Less(t, len(encoded), len((&rfc1035label.Labels{Labels: domains}).ToBytes()))
+
+	labels, err := rfc1035label.FromBytes(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, len(domains), len(labels.Labels))
+	for i, domain := range domains {
+		assert.True(t, strings.EqualFold(domain, labels.Labels[i]), domain)
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	domain, err := checkDomain("example.org.")
+	require.NoError(t, err)
+	assert.Equal(t, "example.org", domain)
+
+	for _, domain := range []string{
+		"",
+		".",
+		"a..b",
+		"exa mple.org",
+		strings.Repeat("a", 64) + ".org",
+		strings.Repeat("abcdefg.", 32) + "org",
+	} {
+		_, err := checkDomain(domain)
+		assert.Error(t, err, domain)
+	}
+}
+
+func TestSearchListTooLong(t *testing.T) {
+	var domains []string
+	for _, c := range "abcdefghijklmnopqrstuvwxyz" {
+		domains = append(domains, strings.Repeat(string(c), 10)+".net")
+	}
+	_, err := setup4(domains...)
+	assert.Error(t, err)
+	_, err = setup4(domains[:10]...)
+	assert.NoError(t, err)
+}
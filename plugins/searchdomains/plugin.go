@@ -5,11 +5,16 @@
 package searchdomains
 
 // This is an searchdomains plugin that adds default DNS search domains.
+// In DHCPv4, the search list is compressed as described in RFC3397, and must
+// fit in a single option.
 
 import (
+	"fmt"
+
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/ra"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/rfc1035label"
@@ -38,7 +43,7 @@ var Plugin = plugins.Plugin{
 // Note that DHCPv4 and DHCPv6 options are totally independent.
 // If you need the same settings for both, you'll need to configure
 // this plugin once for the v4 and once for the v6 server.
-var v4SearchList []string
+var v4SearchList []byte
 var v6SearchList []string
 
 // copySlice creates a new copy of a string slice in memory.
@@ -50,15 +55,41 @@ func copySlice(original []string) []string {
 	return copied
 }
 
+// checkDomains validates the search domains given as arguments
+func checkDomains(args []string) ([]string, error) {
+	domains := make([]string, 0, len(args))
+	for _, arg := range args {
+		domain, err := checkDomain(arg)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
 func setup6(args ...string) (handler.Handler6, error) {
-	v6SearchList = args
+	domains, err := checkDomains(args)
+	if err != nil {
+		return nil, err
+	}
+	v6SearchList = domains
+	ra.AdvertiseDomains(domains...)
 	log.Printf("Registered domain search list (DHCPv6) %s", v6SearchList)
 	return domainSearchListHandler6, nil
 }
 
 func setup4(args ...string) (handler.Handler4, error) {
-	v4SearchList = args
-	log.Printf("Registered domain search list (DHCPv4) %s", v4SearchList)
+	domains, err := checkDomains(args)
+	if err != nil {
+		return nil, err
+	}
+	encoded := compressDomains(domains)
+	if len(encoded) > maxSearchListLen {
+		return nil, fmt.Errorf("domain search list is %d bytes long once encoded, more than the maximum of %d", len(encoded), maxSearchListLen)
+	}
+	v4SearchList = encoded
+	log.Printf("Registered domain search list (DHCPv4) %s, %d bytes", domains, len(encoded))
 	return domainSearchListHandler4, nil
 }
 
@@ -70,8 +101,6 @@ func domainSearchListHandler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 }
 
 func domainSearchListHandler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-	resp.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{
-		Labels: copySlice(v4SearchList),
-	}))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionDNSDomainSearchList, v4SearchList))
 	return resp, false
 }
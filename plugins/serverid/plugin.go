@@ -6,12 +6,14 @@ package serverid
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strings"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/insomniacslk/dhcp/iana"
@@ -30,8 +32,29 @@ var Plugin = plugins.Plugin{
 var (
 	v6ServerID *dhcpv6.Duid
 	v4ServerID net.IP
+	// v4Overrides are the server identifiers of some classes of clients,
+	// eg. the address of the server on the link of a relay, used instead of
+	// v4ServerID
+	v4Overrides []override
 )
 
+// override is a server identifier used for the clients of a class
+type override struct {
+	id    net.IP
+	class *class.Matcher
+}
+
+// serverID4 returns the server identifier for a client: the one of the first
+// class it belongs to, the default one otherwise
+func serverID4(req *dhcpv4.DHCPv4) net.IP {
+	for _, o := range v4Overrides {
+		if o.class.Match4(req) {
+			return o.id
+		}
+	}
+	return v4ServerID
+}
+
 // Handler6 handles DHCPv6 packets for the server_id plugin.
 func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
 	if v6ServerID == nil {
@@ -82,16 +105,28 @@ func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 		log.Warningf("not a BootRequest, ignoring")
 		return resp, false
 	}
+	serverID := serverID4(req)
 	if req.ServerIPAddr != nil &&
 		!req.ServerIPAddr.Equal(net.IPv4zero) &&
-		!req.ServerIPAddr.Equal(v4ServerID) {
+		!req.ServerIPAddr.Equal(serverID) {
 		// This request is not for us, drop it.
-		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, v4ServerID)
+		log.Infof("requested server ID does not match this server's ID. Got %v, want %v", req.ServerIPAddr, serverID)
 		return nil, true
 	}
-	resp.ServerIPAddr = make(net.IP, net.IPv4len)
-	copy(resp.ServerIPAddr[:], v4ServerID)
-	resp.UpdateOption(dhcpv4.OptServerIdentifier(v4ServerID))
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		// RFC2131 §4.3.2: a client in the SELECTING state sends the
+		// identifier of the server it chose, the others did not offer the
+		// address. Renewing clients send none, they unicast to the server
+		if sid := req.ServerIdentifier(); sid != nil && !sid.Equal(serverID) {
+			log.Infof("client %s chose another server: got server ID %v, want %v", req.ClientHWAddr, sid, serverID)
+			return nil, true
+		}
+	}
+	// The identifiers are IPv4 addresses of 4 bytes, never modified: they
+	// are shared by the replies rather than copied
+	resp.ServerIPAddr = serverID
+	resp.Options[dhcpv4.OptionServerIdentifier.Code()] = serverID
 	return resp, false
 }
 
@@ -107,7 +142,23 @@ func setup4(args ...string) (handler.Handler4, error) {
 	if serverID.To4() == nil {
 		return nil, errors.New("not a valid IPv4 address")
 	}
-	v4ServerID = serverID.To4()
+	var overrides []override
+	for _, arg := range args[1:] {
+		sep := strings.IndexByte(arg, '@')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <IP>@<class>", arg)
+		}
+		o := override{id: net.ParseIP(arg[:sep]).To4()}
+		if o.id == nil {
+			return nil, fmt.Errorf("invalid IPv4 address in %q", arg)
+		}
+		var err error
+		if o.class, err = class.Parse(arg[sep+1:]); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	v4ServerID, v4Overrides = serverID.To4(), overrides
 	return Handler4, nil
 }
 
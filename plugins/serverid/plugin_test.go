@@ -8,6 +8,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
 
@@ -124,3 +125,71 @@ func TestRejectInnerMessageServerID(t *testing.T) {
 		t.Error("server_id did not interrupt processing on a relayed solicit with a ServerID")
 	}
 }
+
+func TestServerID4(t *testing.T) {
+	if _, err := setup4("10.0.0.1", "10.0.5.1@link:10.0.5.0/24", "10.0.6.1@circuit-id:eth6"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		modifiers []dhcpv4.Modifier
+		want      net.IP
+	}{
+		{"direct", nil, net.IPv4(10, 0, 0, 1)},
+		{"relayed", []dhcpv4.Modifier{dhcpv4.WithGatewayIP(net.IPv4(10, 0, 5, 254))}, net.IPv4(10, 0, 5, 1)},
+		// Renewals are unicast by the client, bypassing the relay
+		{"renewing", []dhcpv4.Modifier{dhcpv4.WithClientIP(net.IPv4(10, 0, 5, 23))}, net.IPv4(10, 0, 5, 1)},
+		{"circuit", []dhcpv4.Modifier{
+			dhcpv4.WithGatewayIP(net.IPv4(10, 0, 7, 254)),
+			dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth6")))),
+		}, net.IPv4(10, 0, 6, 1)},
+		{"selected", []dhcpv4.Modifier{
+			dhcpv4.WithGatewayIP(net.IPv4(10, 0, 5, 254)),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 5, 1))),
+		}, net.IPv4(10, 0, 5, 1)},
+		// The client chose another server
+		{"other", []dhcpv4.Modifier{
+			dhcpv4.WithGatewayIP(net.IPv4(10, 0, 5, 254)),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+			dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1))),
+		}, nil},
+	} {
+		req, err := dhcpv4.New(tc.modifiers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, stop := Handler4(req, stub)
+		if tc.want == nil {
+			if resp != nil || !stop {
+				t.Errorf("%s: server_id did not drop a request for another server", tc.name)
+			}
+			continue
+		}
+		if resp == nil {
+			t.Fatalf("%s: plugin did not return an answer", tc.name)
+		}
+		if got := resp.ServerIdentifier(); !got.Equal(tc.want) {
+			t.Errorf("%s: got server ID %v, expected %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetup4Arguments(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"10.0.0"},
+		{"2001:db8::1"},
+		{"10.0.0.1", "10.0.5.1"},
+		{"10.0.0.1", "10.0.5@link:10.0.5.0/24"},
+		{"10.0.0.1", "10.0.5.1@color:blue"},
+	} {
+		if _, err := setup4(args...); err == nil {
+			t.Errorf("setup4(%q) should fail", args)
+		}
+	}
+}
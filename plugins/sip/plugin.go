@@ -0,0 +1,148 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package sip implements a plugin sending SIP servers to DHCPv6 clients
+// (RFC3319). Servers given as addresses are sent in the SIP Servers IPv6
+// Address List option, those given as domain names in the SIP Servers Domain
+// Name List option.
+//
+// server6:
+//   plugins:
+//     - sip: 2001:db8::5060 sip.example.org
+//
+// DHCPv4 clients get them in the SIP Servers option (120, RFC3361), which
+// holds either domain names (encoding 0) or IPv4 addresses (encoding 1), not
+// both:
+//
+// server4:
+//   plugins:
+//     - sip: sip1.example.org sip2.example.org
+//
+// The provisioning of the phones, eg. their configuration URL, is left to the
+// voip plugin.
+package sip
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+var log = logger.GetLogger("plugins/sip")
+
+// Plugin wraps the SIP plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "sip",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+var (
+	sipServers []net.IP
+	sipNames   []string
+	// sipOption4 is the encoded option 120 sent to DHCPv4 clients
+	sipOption4 []byte
+)
+
+// The encodings of the DHCPv4 option (RFC3361 §3)
+const (
+	encodingNames     = 0
+	encodingAddresses = 1
+)
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one SIP server")
+	}
+	for _, arg := range args {
+		if ip := net.ParseIP(arg); ip != nil {
+			if ip.To4() != nil {
+				return nil, errors.New("expected an IPv6 SIP server address, got: " + arg)
+			}
+			sipServers = append(sipServers, ip)
+			continue
+		}
+		if strings.ContainsAny(arg, ":/ ") {
+			return nil, errors.New("expected a SIP server address or name, got: " + arg)
+		}
+		sipNames = append(sipNames, strings.TrimSuffix(arg, "."))
+	}
+	log.Infof("loaded %d SIP servers.", len(sipServers)+len(sipNames))
+	return Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need at least one SIP server")
+	}
+	var (
+		ips   []byte
+		names []string
+	)
+	for _, arg := range args {
+		if ip := net.ParseIP(arg); ip != nil {
+			if ip.To4() == nil {
+				return nil, errors.New("expected an IPv4 SIP server address, got: " + arg)
+			}
+			ips = append(ips, ip.To4()...)
+			continue
+		}
+		if strings.ContainsAny(arg, ":/ ") {
+			return nil, errors.New("expected a SIP server address or name, got: " + arg)
+		}
+		names = append(names, strings.TrimSuffix(arg, "."))
+	}
+	if len(ips) > 0 && len(names) > 0 {
+		return nil, errors.New("the DHCPv4 SIP servers must be either all names or all addresses")
+	}
+	if len(names) > 0 {
+		sipOption4 = append([]byte{encodingNames}, (&rfc1035label.Labels{Labels: names}).ToBytes()...)
+	} else {
+		sipOption4 = append([]byte{encodingAddresses}, ips...)
+	}
+	if len(sipOption4) > 255 {
+		return nil, errors.New("too many SIP servers for the DHCPv4 option")
+	}
+	log.Infof("loaded %d DHCPv4 SIP servers.", len(args))
+	return Handler4, nil
+}
+
+// Handler4 handles DHCPv4 packets for the sip plugin
+func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if req.IsOptionRequested(dhcpv4.OptionSIPServers) {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionSIPServers, sipOption4))
+	}
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the sip plugin
+func Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	decap, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+		return nil, true
+	}
+
+	if len(sipServers) > 0 && decap.IsOptionRequested(dhcpv6.OptionSIPServersIPv6AddressList) {
+		var data []byte
+		for _, ip := range sipServers {
+			data = append(data, ip.To16()...)
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionSIPServersIPv6AddressList, OptionData: data})
+	}
+	if len(sipNames) > 0 && decap.IsOptionRequested(dhcpv6.OptionSIPServersDomainNameList) {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionSIPServersDomainNameList,
+			OptionData: (&rfc1035label.Labels{Labels: sipNames}).ToBytes(),
+		})
+	}
+	return resp, false
+}
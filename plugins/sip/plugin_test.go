@@ -0,0 +1,97 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddServers(t *testing.T) {
+	sipServers, sipNames = nil, nil
+	_, err := setup6("2001:db8::5060", "sip.example.org")
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption(
+		dhcpv6.OptionSIPServersIPv6AddressList,
+		dhcpv6.OptionSIPServersDomainNameList,
+	))
+
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	resp, stop := Handler6(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+
+	addrs := resp.GetOneOption(dhcpv6.OptionSIPServersIPv6AddressList)
+	require.NotNil(t, addrs)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::5060")), addrs.ToBytes())
+
+	names := resp.GetOneOption(dhcpv6.OptionSIPServersDomainNameList)
+	require.NotNil(t, names)
+	assert.Equal(t, []byte("\x03sip\x07example\x03org\x00"), names.ToBytes())
+}
+
+func TestNotRequested(t *testing.T) {
+	sipServers, sipNames = []net.IP{net.ParseIP("2001:db8::5060")}, nil
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionSIPServersDomainNameList))
+
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	resp, _ := Handler6(req, stub)
+	require.NotNil(t, resp)
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSIPServersIPv6AddressList))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSIPServersDomainNameList))
+}
+
+func TestHandler4(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionSIPServers))
+	require.NoError(t, err)
+
+	_, err = setup4("sip1.example.org", "sip2.example.org.")
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop := Handler4(req, resp)
+	assert.False(t, stop)
+	assert.Equal(t, []byte("\x00\x04sip1\x07example\x03org\x00\x04sip2\x07example\x03org\x00"), resp.Options.Get(dhcpv4.OptionSIPServers))
+
+	_, err = setup4("192.0.2.5", "192.0.2.6")
+	require.NoError(t, err)
+	resp, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = Handler4(req, resp)
+	assert.Equal(t, []byte{1, 192, 0, 2, 5, 192, 0, 2, 6}, resp.Options.Get(dhcpv4.OptionSIPServers))
+
+	// Not requested
+	other, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	resp, err = dhcpv4.NewReplyFromRequest(other)
+	require.NoError(t, err)
+	resp, _ = Handler4(other, resp)
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionSIPServers))
+
+	for _, args := range [][]string{{}, {"192.0.2.5", "sip.example.org"}, {"2001:db8::5060"}, {"sip://example.org"}} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
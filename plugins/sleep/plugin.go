@@ -7,12 +7,16 @@ package sleep
 // This plugin introduces a delay in the DHCP response.
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/coredhcp/coredhcp/handler"
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -34,6 +38,15 @@ var (
 //     - sleep 1s
 //     - file: "leases6.txt"
 //
+// The delay can depend on the class of the client (<duration>@<class>, see
+// the class package), the first matching class winning. Other arguments are:
+// - jitter=<duration>: adds a random delay, up to the duration
+// - types=<message type>,...: only delays these messages, eg. to act as a
+// backup server answering the new clients late, but not the renewals:
+//
+//     - sleep: 2s types=discover jitter=500ms
+//     - sleep: 0s 1s@vendor:PXEClient
+//
 // For the duration format, see the documentation of `time.ParseDuration`,
 // https://golang.org/pkg/time/#ParseDuration .
 
@@ -44,46 +57,144 @@ var Plugin = plugins.Plugin{
 	Setup4: setup4,
 }
 
-func setup6(args ...string) (handler.Handler6, error) {
-	if len(args) != 1 {
-		return nil, fmt.Errorf("want exactly one argument, got %d", len(args))
+// classDelay is the delay of the responses to the clients of a class
+type classDelay struct {
+	*class.Matcher
+	delay time.Duration
+}
+
+// PluginState holds the delays of an instance of the sleep plugin
+type PluginState struct {
+	delay   time.Duration
+	classes []classDelay
+	jitter  time.Duration
+	// types are the names of the message types delayed, all if empty
+	types map[string]bool
+}
+
+func parseDelay(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("failed to parse duration %q", value)
 	}
-	delay, err := time.ParseDuration(args[0])
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse duration: %w", err)
+	return d, nil
+}
+
+// parseArgs parses the arguments of the plugin, the message types being
+// checked with typeName, which returns the name of a message type
+func parseArgs(typeName func(uint8) string, args ...string) (*PluginState, error) {
+	if len(args) < 1 {
+		return nil, errors.New("need a delay")
 	}
-	log.Printf("loaded plugin for DHCPv6.")
-	return makeSleepHandler6(delay), nil
+	var (
+		p   PluginState
+		err error
+	)
+	if p.delay, err = parseDelay(args[0]); err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		if sep := strings.IndexByte(arg, '@'); sep >= 0 {
+			delay, err := parseDelay(arg[:sep])
+			if err != nil {
+				return nil, err
+			}
+			m, err := class.Parse(arg[sep+1:])
+			if err != nil {
+				return nil, err
+			}
+			p.classes = append(p.classes, classDelay{Matcher: m, delay: delay})
+			continue
+		}
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <duration>@<class> or <key>=<value>", arg)
+		}
+		switch key, value := arg[:sep], arg[sep+1:]; key {
+		case "jitter":
+			if p.jitter, err = parseDelay(value); err != nil {
+				return nil, err
+			}
+		case "types":
+			known := make(map[string]bool)
+			for t := 1; t < 256; t++ {
+				known[typeName(uint8(t))] = true
+			}
+			p.types = make(map[string]bool)
+			for _, name := range strings.Split(value, ",") {
+				name = strings.ToUpper(name)
+				if !known[name] {
+					return nil, fmt.Errorf("unknown message type %q", name)
+				}
+				p.types[name] = true
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	return &p, nil
 }
 
-func setup4(args ...string) (handler.Handler4, error) {
-	if len(args) != 1 {
-		return nil, fmt.Errorf("want exactly one argument, got %d", len(args))
+// delayOf returns the delay of a request, given its message type and whether
+// its client matches a class
+func (p *PluginState) delayOf(msgType string, match func(*class.Matcher) bool) time.Duration {
+	if len(p.types) > 0 && !p.types[msgType] {
+		return 0
 	}
-	delay, err := time.ParseDuration(args[0])
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse duration: %w", err)
+	delay := p.delay
+	for _, c := range p.classes {
+		if match(c.Matcher) {
+			delay = c.delay
+			break
+		}
 	}
-	log.Printf("loaded plugin for DHCPv4.")
-	return makeSleepHandler4(delay), nil
+	if p.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	return delay
 }
 
-func makeSleepHandler6(delay time.Duration) handler.Handler6 {
-	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+func sleep(delay time.Duration) {
+	if delay > 0 {
 		log.Printf("introducing delay of %s in response", delay)
-		// return the unmodified response, and instruct coredhcp to continue to
-		// the next plugin.
 		time.Sleep(delay)
-		return resp, false
 	}
 }
 
-func makeSleepHandler4(delay time.Duration) handler.Handler4 {
-	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
-		log.Printf("introducing delay of %s in response", delay)
-		// return the unmodified response, and instruct coredhcp to continue to
-		// the next plugin.
-		time.Sleep(delay)
+// Handler6 handles DHCPv6 packets for the sleep plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
 		return resp, false
 	}
+	sleep(p.delayOf(msg.Type().String(), func(m *class.Matcher) bool { return m.Match6(req) }))
+	// return the unmodified response, and instruct coredhcp to continue to
+	// the next plugin.
+	return resp, false
+}
+
+// Handler4 handles DHCPv4 packets for the sleep plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	sleep(p.delayOf(req.MessageType().String(), func(m *class.Matcher) bool { return m.Match4(req) }))
+	// return the unmodified response, and instruct coredhcp to continue to
+	// the next plugin.
+	return resp, false
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := parseArgs(func(t uint8) string { return dhcpv6.MessageType(t).String() }, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded plugin for DHCPv6.")
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := parseArgs(func(t uint8) string { return dhcpv4.MessageType(t).String() }, args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded plugin for DHCPv4.")
+	return p.Handler4, nil
 }
@@ -0,0 +1,70 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sleep
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/plugins/class"
+)
+
+func typeName4(t uint8) string { return dhcpv4.MessageType(t).String() }
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs(typeName4, "0s", "2s@vendor:PXEClient", "jitter=100ms", "types=discover,request")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), p.delay)
+	assert.Len(t, p.classes, 1)
+	assert.Equal(t, 100*time.Millisecond, p.jitter)
+	assert.Equal(t, map[string]bool{"DISCOVER": true, "REQUEST": true}, p.types)
+
+	for _, args := range [][]string{
+		{},
+		{"soon"},
+		{"-1s"},
+		{"1s", "2s@PXEClient"},
+		{"1s", "jitter=-1s"},
+		{"1s", "types=solicit"},
+		{"1s", "retries=1"},
+		{"1s", "2s"},
+	} {
+		_, err := parseArgs(typeName4, args...)
+		assert.Error(t, err, args)
+	}
+	_, err = parseArgs(func(t uint8) string { return dhcpv6.MessageType(t).String() }, "1s", "types=solicit")
+	assert.NoError(t, err)
+}
+
+func TestDelay(t *testing.T) {
+	p, err := parseArgs(typeName4, "1s", "2s@vendor:PXEClient", "types=discover")
+	require.NoError(t, err)
+	delay := func(req *dhcpv4.DHCPv4) time.Duration {
+		return p.delayOf(req.MessageType().String(), func(m *class.Matcher) bool { return m.Match4(req) })
+	}
+
+	discover, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, delay(discover))
+	discover.UpdateOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000"))
+	assert.Equal(t, 2*time.Second, delay(discover))
+
+	// Renewals are not delayed
+	discover.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+	assert.Equal(t, time.Duration(0), delay(discover))
+
+	p, err = parseArgs(typeName4, "1s", "jitter=500ms")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		d := delay(discover)
+		assert.True(t, d >= time.Second && d < 1500*time.Millisecond, d)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package snmp
+
+// The subset of BER (X.690) and of the SNMP messages (RFC 1157, RFC 3416)
+// the agent needs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+
+	// The exceptions of SNMPv2 variable bindings
+	tagNoSuchObject = 0x80
+	tagEndOfMibView = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+)
+
+// The versions of the messages
+const (
+	version1  = 0
+	version2c = 1
+)
+
+// The error statuses of the responses
+const (
+	errNoSuchName  = 2
+	errNotWritable = 17
+)
+
+var errMalformed = errors.New("malformed message")
+
+// oid is an object identifier
+type oid []uint32
+
+func parseOID(s string) (oid, error) {
+	var o oid
+	for _, arc := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		n, err := strconv.ParseUint(arc, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		o = append(o, uint32(n))
+	}
+	if len(o) < 2 || o[0] > 2 || (o[0] < 2 && o[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	arcs := make([]string, len(o))
+	for i, arc := range o {
+		arcs[i] = strconv.FormatUint(uint64(arc), 10)
+	}
+	return strings.Join(arcs, ".")
+}
+
+// compare compares two OIDs in lexicographic order
+func (o oid) compare(p oid) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		if o[i] != p[i] {
+			if o[i] < p[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(o) - len(p)
+}
+
+// append returns the OID followed by arcs
+func (o oid) append(arcs ...uint32) oid {
+	return append(append(oid{}, o...), arcs...)
+}
+
+func appendTLV(b []byte, tag byte, content []byte) []byte {
+	b = append(b, tag)
+	n := len(content)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+func encodeInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for (n > 0x7f || n < -0x80) && len(b) < 8 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return b
+}
+
+func encodeUint(n uint64) []byte {
+	b := []byte{byte(n)}
+	for n > 0xff {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func encodeOID(o oid) []byte {
+	var b []byte
+	arcs := append([]uint32{o[0]*40 + o[1]}, o[2:]...)
+	for _, arc := range arcs {
+		chunk := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7f) | 0x80}, chunk...)
+		}
+		b = append(b, chunk...)
+	}
+	return b
+}
+
+// tlv is a decoded tag, length and value
+type tlv struct {
+	tag     byte
+	content []byte
+}
+
+func readTLV(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errMalformed
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return tlv{}, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if len(b) < n {
+		return tlv{}, nil, errMalformed
+	}
+	return tlv{tag: tag, content: b[:n]}, b[n:], nil
+}
+
+// readTagged reads a TLV with the given tag
+func readTagged(b []byte, tag byte) ([]byte, []byte, error) {
+	t, rest, err := readTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.tag != tag {
+		return nil, nil, errMalformed
+	}
+	return t.content, rest, nil
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func readInt(b []byte) (int64, []byte, error) {
+	content, rest, err := readTagged(b, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := decodeInt(content)
+	return n, rest, err
+}
+
+func decodeOID(b []byte) (oid, error) {
+	if len(b) == 0 {
+		return nil, errMalformed
+	}
+	var (
+		o   oid
+		arc uint64
+	)
+	for i, c := range b {
+		arc = arc<<7 | uint64(c&0x7f)
+		if arc > 0xffffffff {
+			return nil, errMalformed
+		}
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errMalformed
+			}
+			continue
+		}
+		if o == nil {
+			if arc < 80 {
+				o = oid{uint32(arc / 40), uint32(arc % 40)}
+			} else {
+				o = oid{2, uint32(arc - 80)}
+			}
+		} else {
+			o = append(o, uint32(arc))
+		}
+		arc = 0
+	}
+	return o, nil
+}
+
+// message is an SNMP request. For GetBulk requests, the error status and
+// index are the non-repeaters and the max-repetitions
+type message struct {
+	version   int64
+	community string
+	pdu       byte
+	requestID int64
+	errStatus int64
+	errIndex  int64
+	oids      []oid
+}
+
+func parseMessage(b []byte) (*message, error) {
+	var (
+		m   message
+		err error
+	)
+	if b, _, err = readTagged(b, tagSequence); err != nil {
+		return nil, err
+	}
+	if m.version, b, err = readInt(b); err != nil {
+		return nil, err
+	}
+	community, b, err := readTagged(b, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	m.community = string(community)
+	pdu, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	m.pdu, b = pdu.tag, pdu.content
+	if m.requestID, b, err = readInt(b); err != nil {
+		return nil, err
+	}
+	if m.errStatus, b, err = readInt(b); err != nil {
+		return nil, err
+	}
+	if m.errIndex, b, err = readInt(b); err != nil {
+		return nil, err
+	}
+	if b, _, err = readTagged(b, tagSequence); err != nil {
+		return nil, err
+	}
+	for len(b) > 0 {
+		var vb []byte
+		if vb, b, err = readTagged(b, tagSequence); err != nil {
+			return nil, err
+		}
+		name, _, err := readTagged(vb, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		o, err := decodeOID(name)
+		if err != nil {
+			return nil, err
+		}
+		m.oids = append(m.oids, o)
+	}
+	return &m, nil
+}
+
+// varbind is a variable binding, an OID and its encoded value
+type varbind struct {
+	oid     oid
+	tag     byte
+	content []byte
+}
+
+// response encodes the response to a request
+func (m *message) response(errStatus, errIndex int, vbs []varbind) []byte {
+	var list []byte
+	for _, vb := range vbs {
+		var b []byte
+		b = appendTLV(b, tagOID, encodeOID(vb.oid))
+		b = appendTLV(b, vb.tag, vb.content)
+		list = appendTLV(list, tagSequence, b)
+	}
+	var pdu []byte
+	pdu = appendTLV(pdu, tagInteger, encodeInt(m.requestID))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(int64(errStatus)))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(int64(errIndex)))
+	pdu = appendTLV(pdu, tagSequence, list)
+	var msg []byte
+	msg = appendTLV(msg, tagInteger, encodeInt(m.version))
+	msg = appendTLV(msg, tagOctetString, []byte(m.community))
+	msg = appendTLV(msg, pduResponse, pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
@@ -0,0 +1,188 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package snmp
+
+import (
+	"encoding/json"
+	"expvar"
+	"os"
+	"sort"
+	"time"
+)
+
+// The system group of MIB-II (RFC 1213)
+var (
+	sysDescr    = oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	sysObjectID = oid{1, 3, 6, 1, 2, 1, 1, 2, 0}
+	sysUpTime   = oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	sysName     = oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+)
+
+// counters4 and counters6 are the names of the counters of the messages, in
+// the order of their OIDs
+var (
+	counters4 = []string{"discover", "offer", "request", "decline", "ack", "nak", "release", "inform", "bootp_request", "bootp_reply"}
+	counters6 = []string{"solicit", "advertise", "request6", "confirm", "renew", "rebind", "reply", "release6", "decline6", "information_request"}
+)
+
+// maxBulk is the most variable bindings in a response to a GetBulk request
+const maxBulk = 100
+
+// pool is the utilization of a range, as published by plugins/range
+type pool struct {
+	Size        int     `json:"size"`
+	Used        int     `json:"used"`
+	Utilization float64 `json:"utilization"`
+}
+
+// mib is a snapshot of the objects, sorted by OID
+type mib []varbind
+
+// snapshot returns the current objects of the agent
+func (p *PluginState) snapshot() mib {
+	root := p.root
+	m := mib{
+		{oid: sysDescr, tag: tagOctetString, content: []byte("CoreDHCP")},
+		{oid: sysObjectID, tag: tagOID, content: encodeOID(root)},
+		{oid: sysUpTime, tag: tagTimeTicks, content: encodeUint(uint64(time.Since(p.started) / (10 * time.Millisecond)))},
+	}
+	if name, err := os.Hostname(); err == nil {
+		m = append(m, varbind{oid: sysName, tag: tagOctetString, content: []byte(name)})
+	}
+	for i, name := range counters4 {
+		m = append(m, varbind{oid: root.append(1, uint32(i+1), 0), tag: tagCounter32, content: encodeUint(uint64(uint32(p.counters.Counter(name).Value())))})
+	}
+	for i, name := range counters6 {
+		m = append(m, varbind{oid: root.append(2, uint32(i+1), 0), tag: tagCounter32, content: encodeUint(uint64(uint32(p.counters.Counter(name).Value())))})
+	}
+
+	pools := make(map[string]pool)
+	if v := expvar.Get("range"); v != nil {
+		_ = json.Unmarshal([]byte(v.String()), &pools)
+	}
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	m = append(m, varbind{oid: root.append(3, 1, 0), tag: tagGauge32, content: encodeUint(uint64(len(names)))})
+	leases := 0
+	for i, name := range names {
+		index := uint32(i + 1)
+		pl := pools[name]
+		leases += pl.Used
+		m = append(m,
+			varbind{oid: root.append(3, 2, 1, 1, index), tag: tagInteger, content: encodeInt(int64(index))},
+			varbind{oid: root.append(3, 2, 1, 2, index), tag: tagOctetString, content: []byte(name)},
+			varbind{oid: root.append(3, 2, 1, 3, index), tag: tagGauge32, content: encodeUint(uint64(pl.Size))},
+			varbind{oid: root.append(3, 2, 1, 4, index), tag: tagGauge32, content: encodeUint(uint64(pl.Used))},
+			varbind{oid: root.append(3, 2, 1, 5, index), tag: tagGauge32, content: encodeUint(uint64(100*pl.Utilization + 0.5))},
+		)
+	}
+	m = append(m, varbind{oid: root.append(4, 0), tag: tagGauge32, content: encodeUint(uint64(leases))})
+	sort.Slice(m, func(i, j int) bool { return m[i].oid.compare(m[j].oid) < 0 })
+	return m
+}
+
+// get returns the object with an OID, if any
+func (m mib) get(o oid) (varbind, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].oid.compare(o) >= 0 })
+	if i < len(m) && m[i].oid.compare(o) == 0 {
+		return m[i], true
+	}
+	return varbind{}, false
+}
+
+// next returns the object following an OID, if any
+func (m mib) next(o oid) (varbind, bool) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].oid.compare(o) > 0 })
+	if i < len(m) {
+		return m[i], true
+	}
+	return varbind{}, false
+}
+
+// handle returns the response to a request, nil if it gets none
+func (m mib) handle(req *message) []byte {
+	vbs := make([]varbind, 0, len(req.oids))
+	// fail answers SNMPv1 requests with an error, SNMPv2 ones with an
+	// exception in the variable binding
+	fail := func(i int, o oid, exception byte) []byte {
+		if req.version == version1 {
+			null := make([]varbind, len(req.oids))
+			for j, o := range req.oids {
+				null[j] = varbind{oid: o, tag: tagNull}
+			}
+			return req.response(errNoSuchName, i+1, null)
+		}
+		vbs = append(vbs, varbind{oid: o, tag: exception})
+		return nil
+	}
+	switch req.pdu {
+	case pduGet:
+		for i, o := range req.oids {
+			if vb, ok := m.get(o); ok {
+				vbs = append(vbs, vb)
+			} else if resp := fail(i, o, tagNoSuchObject); resp != nil {
+				return resp
+			}
+		}
+	case pduGetNext:
+		for i, o := range req.oids {
+			if vb, ok := m.next(o); ok {
+				vbs = append(vbs, vb)
+			} else if resp := fail(i, o, tagEndOfMibView); resp != nil {
+				return resp
+			}
+		}
+	case pduGetBulk:
+		if req.version == version1 {
+			return nil
+		}
+		nonRepeaters, repetitions := int(req.errStatus), int(req.errIndex)
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(req.oids) {
+			nonRepeaters = len(req.oids)
+		}
+		for _, o := range req.oids[:nonRepeaters] {
+			vb, ok := m.next(o)
+			if !ok {
+				vb = varbind{oid: o, tag: tagEndOfMibView}
+			}
+			vbs = append(vbs, vb)
+		}
+		last := append([]oid{}, req.oids[nonRepeaters:]...)
+		for r := 0; r < repetitions && len(last) > 0 && len(vbs)+len(last) <= maxBulk; r++ {
+			done := true
+			for i, o := range last {
+				vb, ok := m.next(o)
+				if !ok {
+					vb = varbind{oid: o, tag: tagEndOfMibView}
+				} else {
+					done = false
+				}
+				vbs = append(vbs, vb)
+				last[i] = vb.oid
+			}
+			if done {
+				break
+			}
+		}
+	case pduSet:
+		null := make([]varbind, len(req.oids))
+		for j, o := range req.oids {
+			null[j] = varbind{oid: o, tag: tagNull}
+		}
+		if req.version == version1 {
+			return req.response(errNoSuchName, 1, null)
+		}
+		return req.response(errNotWritable, 1, null)
+	default:
+		return nil
+	}
+	return req.response(0, 0, vbs)
+}
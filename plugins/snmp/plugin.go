@@ -0,0 +1,317 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package snmp implements a plugin serving the utilization of the pools (see
+// plugins/range), the counters of the messages and the number of leases over
+// SNMP (v1 and v2c, read-only), for the network management systems which
+// don't read the metrics of package metrics.
+//
+// server4:
+//   plugins:
+//     - snmp: listen=0.0.0.0:161 community=s3cr3t oid=1.3.6.1.4.1.32473.67
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//
+// The arguments are:
+// - listen=<address>: the UDP address to serve on (mandatory)
+// - oid=<OID>: the root of the objects (mandatory), see below
+// - community=<string>: the community of the requests, public by default
+//
+// The DHCP server MIB of the IETF (draft-ietf-dhc-server-mib) never became a
+// standard and has no assigned OID: the objects are rather rooted at an OID
+// given by the operator, eg. under its private enterprise number. Under the
+// root, they are:
+// - 1.<n>.0: the DHCPv4 messages (Counter32): 1 discovers, 2 offers, 3
+// requests, 4 declines, 5 acks, 6 naks, 7 releases, 8 informs, 9 BOOTP
+// requests, 10 BOOTP replies
+// - 2.<n>.0: the DHCPv6 messages (Counter32): 1 solicits, 2 advertises, 3
+// requests, 4 confirms, 5 renews, 6 rebinds, 7 replies, 8 releases, 9
+// declines, 10 information requests
+// - 3.1.0: the number of pools (Gauge32)
+// - 3.2.1.<column>.<pool>: the table of the pools, sorted by range, with the
+// columns 1 index (INTEGER), 2 range (OCTET STRING, eg.
+// "192.0.2.100-192.0.2.200"), 3 size, 4 used addresses, 5 utilization in
+// percent (Gauge32)
+// - 4.0: the number of leases of the pools (Gauge32)
+//
+// The system group of MIB-II (sysDescr, sysObjectID, sysUpTime and sysName)
+// is also served, sysObjectID being the root.
+//
+// The plugin counts the messages going through the chain, and the replies
+// sent: it should come first. The DHCPv6 and DHCPv4 instances listening on
+// the same address share their agent.
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/metrics"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+var log = logger.GetLogger("plugins/snmp")
+
+// Plugin wraps the snmp plugin information.
+var Plugin = plugins.Plugin{
+	Name:      "snmp",
+	Setup6Ctx: setup6,
+	Setup4Ctx: setup4,
+	Stop:      stop,
+}
+
+// instances holds the instances of the plugin, by listen address
+var instances = struct {
+	sync.Mutex
+	byAddr map[string]*PluginState
+}{byAddr: make(map[string]*PluginState)}
+
+// PluginState holds an instance of the plugin
+type PluginState struct {
+	root      oid
+	community string
+	conn      net.PacketConn
+	// done is closed when the agent is stopped
+	done    chan struct{}
+	started time.Time
+	// counters are the counters of the messages, also published as the
+	// metrics of the plugin
+	counters *metrics.Registry
+}
+
+// options holds the arguments of the plugin
+type options struct {
+	listen    string
+	root      oid
+	community string
+}
+
+func parseArgs(args ...string) (*options, error) {
+	o := options{community: "public"}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "listen":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return nil, fmt.Errorf("invalid listen address %q: %v", value, err)
+			}
+			o.listen = value
+		case "oid":
+			root, err := parseOID(value)
+			if err != nil {
+				return nil, err
+			}
+			o.root = root
+		case "community":
+			if value == "" {
+				return nil, errors.New("empty community")
+			}
+			o.community = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if o.listen == "" {
+		return nil, errors.New("need a listen address")
+	}
+	if o.root == nil {
+		return nil, errors.New("need the OID of the root of the objects")
+	}
+	return &o, nil
+}
+
+func newPluginState(o *options) *PluginState {
+	return &PluginState{
+		root:      o.root,
+		community: o.community,
+		done:      make(chan struct{}),
+		started:   time.Now(),
+		counters:  metrics.Get("snmp"),
+	}
+}
+
+func setup(args ...string) (*PluginState, error) {
+	o, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	instances.Lock()
+	defer instances.Unlock()
+	// Shared by the DHCPv6 and DHCPv4 instances, and kept on reload
+	if p, ok := instances.byAddr[o.listen]; ok {
+		return p, nil
+	}
+	p := newPluginState(o)
+	if p.conn, err = net.ListenPacket("udp", o.listen); err != nil {
+		return nil, err
+	}
+	go p.serve()
+	instances.byAddr[o.listen] = p
+	log.Printf("serving SNMP on %s, under %s", o.listen, o.root)
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6Ctx, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// stop stops the agents
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for addr, p := range instances.byAddr {
+		close(p.done)
+		p.conn.Close()
+		delete(instances.byAddr, addr)
+	}
+	return nil
+}
+
+// serve answers the requests until the socket is closed
+func (p *PluginState) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-p.done:
+			default:
+				log.Errorf("SNMP agent stopped: %v", err)
+			}
+			return
+		}
+		if resp := p.handle(buf[:n]); resp != nil {
+			if _, err := p.conn.WriteTo(resp, peer); err != nil {
+				log.Warningf("could not answer %s: %v", peer, err)
+			}
+		}
+	}
+}
+
+// handle returns the response to a request, nil if it gets none
+func (p *PluginState) handle(b []byte) []byte {
+	req, err := parseMessage(b)
+	if err != nil {
+		log.Debugf("invalid SNMP request: %v", err)
+		return nil
+	}
+	if req.version != version1 && req.version != version2c {
+		log.Debugf("unsupported SNMP version %d", req.version)
+		return nil
+	}
+	if req.community != p.community {
+		log.Debugf("SNMP request with a wrong community")
+		return nil
+	}
+	return p.snapshot().handle(req)
+}
+
+// count increments the counter of a message, if any
+func (p *PluginState) count(name string) {
+	if name != "" {
+		p.counters.Counter(name).Add(1)
+	}
+}
+
+// Handler4 counts the DHCPv4 messages
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.count(name4(req))
+	handler.OnReply4(ctx, func(resp *dhcpv4.DHCPv4) {
+		p.count(name4(resp))
+	})
+	return resp, false
+}
+
+// Handler6 counts the DHCPv6 messages
+func (p *PluginState) Handler6(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	if m, err := req.GetInnerMessage(); err == nil {
+		p.count(name6(m.MessageType))
+	}
+	handler.OnReply6(ctx, func(resp dhcpv6.DHCPv6) {
+		if m, err := resp.GetInnerMessage(); err == nil {
+			p.count(name6(m.MessageType))
+		}
+	})
+	return resp, false
+}
+
+// name4 returns the name of the counter of a DHCPv4 message
+func name4(m *dhcpv4.DHCPv4) string {
+	switch m.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		return "discover"
+	case dhcpv4.MessageTypeOffer:
+		return "offer"
+	case dhcpv4.MessageTypeRequest:
+		return "request"
+	case dhcpv4.MessageTypeDecline:
+		return "decline"
+	case dhcpv4.MessageTypeAck:
+		return "ack"
+	case dhcpv4.MessageTypeNak:
+		return "nak"
+	case dhcpv4.MessageTypeRelease:
+		return "release"
+	case dhcpv4.MessageTypeInform:
+		return "inform"
+	case dhcpv4.MessageTypeNone:
+		if m.OpCode == dhcpv4.OpcodeBootRequest {
+			return "bootp_request"
+		}
+		return "bootp_reply"
+	}
+	return ""
+}
+
+// name6 returns the name of the counter of a DHCPv6 message
+func name6(t dhcpv6.MessageType) string {
+	switch t {
+	case dhcpv6.MessageTypeSolicit:
+		return "solicit"
+	case dhcpv6.MessageTypeAdvertise:
+		return "advertise"
+	case dhcpv6.MessageTypeRequest:
+		return "request6"
+	case dhcpv6.MessageTypeConfirm:
+		return "confirm"
+	case dhcpv6.MessageTypeRenew:
+		return "renew"
+	case dhcpv6.MessageTypeRebind:
+		return "rebind"
+	case dhcpv6.MessageTypeReply:
+		return "reply"
+	case dhcpv6.MessageTypeRelease:
+		return "release6"
+	case dhcpv6.MessageTypeDecline:
+		return "decline6"
+	case dhcpv6.MessageTypeInformationRequest:
+		return "information_request"
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package snmp
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var root = oid{1, 3, 6, 1, 4, 1, 32473, 67}
+
+// request encodes a request
+func request(version int64, community string, pdu byte, a, b int64, oids ...oid) []byte {
+	var list []byte
+	for _, o := range oids {
+		var vb []byte
+		vb = appendTLV(vb, tagOID, encodeOID(o))
+		vb = appendTLV(vb, tagNull, nil)
+		list = appendTLV(list, tagSequence, vb)
+	}
+	var p []byte
+	p = appendTLV(p, tagInteger, encodeInt(42))
+	p = appendTLV(p, tagInteger, encodeInt(a))
+	p = appendTLV(p, tagInteger, encodeInt(b))
+	p = appendTLV(p, tagSequence, list)
+	var msg []byte
+	msg = appendTLV(msg, tagInteger, encodeInt(version))
+	msg = appendTLV(msg, tagOctetString, []byte(community))
+	msg = appendTLV(msg, pdu, p)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// decode decodes a response
+func decode(t *testing.T, b []byte) (int64, int64, []varbind) {
+	require.NotNil(t, b)
+	b, _, err := readTagged(b, tagSequence)
+	require.NoError(t, err)
+	_, b, err = readInt(b)
+	require.NoError(t, err)
+	_, b, err = readTagged(b, tagOctetString)
+	require.NoError(t, err)
+	b, _, err = readTagged(b, pduResponse)
+	require.NoError(t, err)
+	id, b, err := readInt(b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+	errStatus, b, err := readInt(b)
+	require.NoError(t, err)
+	errIndex, b, err := readInt(b)
+	require.NoError(t, err)
+	b, _, err = readTagged(b, tagSequence)
+	require.NoError(t, err)
+	var vbs []varbind
+	for len(b) > 0 {
+		var vb []byte
+		vb, b, err = readTagged(b, tagSequence)
+		require.NoError(t, err)
+		name, vb, err := readTagged(vb, tagOID)
+		require.NoError(t, err)
+		o, err := decodeOID(name)
+		require.NoError(t, err)
+		value, _, err := readTLV(vb)
+		require.NoError(t, err)
+		vbs = append(vbs, varbind{oid: o, tag: value.tag, content: value.content})
+	}
+	return errStatus, errIndex, vbs
+}
+
+func TestParseArgs(t *testing.T) {
+	o, err := parseArgs("listen=127.0.0.1:161", "oid=.1.3.6.1.4.1.32473.67")
+	require.NoError(t, err)
+	assert.Equal(t, &options{listen: "127.0.0.1:161", root: root, community: "public"}, o)
+
+	for _, args := range [][]string{
+		{},
+		{"listen=127.0.0.1:161"},
+		{"oid=1.3.6.1"},
+		{"listen=127.0.0.1:161", "oid=1.3.x"},
+		{"listen=127.0.0.1:161", "oid=1.3.6.1", "community="},
+		{"listen=127.0.0.1:161", "oid=1.3.6.1", "version=3"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestBER(t *testing.T) {
+	for _, n := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		got, err := decodeInt(encodeInt(n))
+		require.NoError(t, err)
+		assert.Equal(t, n, got)
+	}
+	assert.Equal(t, []byte{0, 0x80}, encodeUint(128))
+	assert.Equal(t, []byte{0, 0xff, 0xff, 0xff, 0xff}, encodeUint(0xffffffff))
+
+	o := oid{1, 3, 6, 1, 4, 1, 32473, 67, 200000}
+	assert.Equal(t, []byte{0x2b, 6, 1, 4, 1, 0x81, 0xfd, 0x59, 0x43, 0x8c, 0x9a, 0x40}, encodeOID(o))
+	got, err := decodeOID(encodeOID(o))
+	require.NoError(t, err)
+	assert.Equal(t, o, got)
+
+	// Long lengths
+	long := appendTLV(nil, tagOctetString, make([]byte, 300))
+	v, rest, err := readTLV(long)
+	require.NoError(t, err)
+	assert.Len(t, v.content, 300)
+	assert.Empty(t, rest)
+	_, _, err = readTLV(long[:100])
+	assert.Error(t, err)
+}
+
+func TestHandle(t *testing.T) {
+	p := newPluginState(&options{root: root, community: "public"})
+	expvar.NewMap("range").Set("192.0.2.100-192.0.2.200", expvar.Func(func() interface{} {
+		return pool{Size: 101, Used: 80, Utilization: 0.792}
+	}))
+	p.counters.Counter("ack").Set(0)
+	p.counters.Counter("ack").Add(3)
+
+	// Get
+	status, _, vbs := decode(t, p.handle(request(version2c, "public", pduGet, 0, 0, sysDescr, root.append(1, 5, 0), root.append(9))))
+	assert.Zero(t, status)
+	require.Len(t, vbs, 3)
+	assert.Equal(t, "CoreDHCP", string(vbs[0].content))
+	assert.Equal(t, varbind{oid: root.append(1, 5, 0), tag: tagCounter32, content: []byte{3}}, vbs[1])
+	assert.Equal(t, byte(tagNoSuchObject), vbs[2].tag)
+
+	// GetNext walks the pool table
+	_, _, vbs = decode(t, p.handle(request(version2c, "public", pduGetNext, 0, 0, root.append(3, 2, 1, 2))))
+	require.Len(t, vbs, 1)
+	assert.Equal(t, varbind{oid: root.append(3, 2, 1, 2, 1), tag: tagOctetString, content: []byte("192.0.2.100-192.0.2.200")}, vbs[0])
+	_, _, vbs = decode(t, p.handle(request(version2c, "public", pduGetNext, 0, 0, root.append(3, 2, 1, 5, 1))))
+	assert.Equal(t, varbind{oid: root.append(4, 0), tag: tagGauge32, content: []byte{80}}, vbs[0])
+	_, _, vbs = decode(t, p.handle(request(version2c, "public", pduGetNext, 0, 0, root.append(4, 0))))
+	assert.Equal(t, byte(tagEndOfMibView), vbs[0].tag)
+
+	// GetBulk, one non-repeater and the utilization of the pools
+	_, _, vbs = decode(t, p.handle(request(version2c, "public", pduGetBulk, 1, 3, sysObjectID, root.append(3, 2, 1, 4))))
+	require.Len(t, vbs, 4)
+	assert.Equal(t, sysUpTime, vbs[0].oid)
+	assert.Equal(t, varbind{oid: root.append(3, 2, 1, 4, 1), tag: tagGauge32, content: []byte{80}}, vbs[1])
+	assert.Equal(t, varbind{oid: root.append(3, 2, 1, 5, 1), tag: tagGauge32, content: []byte{79}}, vbs[2])
+	assert.Equal(t, root.append(4, 0), vbs[3].oid)
+
+	// SNMPv1 errors
+	status, index, _ := decode(t, p.handle(request(version1, "public", pduGet, 0, 0, sysDescr, root.append(9))))
+	assert.Equal(t, int64(errNoSuchName), status)
+	assert.Equal(t, int64(2), index)
+	assert.Nil(t, p.handle(request(version1, "public", pduGetBulk, 0, 1, sysDescr)))
+
+	// Read-only
+	status, _, _ = decode(t, p.handle(request(version2c, "public", pduSet, 0, 0, sysName)))
+	assert.Equal(t, int64(errNotWritable), status)
+
+	// Wrong community, or version
+	assert.Nil(t, p.handle(request(version2c, "private", pduGet, 0, 0, sysDescr)))
+	assert.Nil(t, p.handle(request(3, "public", pduGet, 0, 0, sysDescr)))
+	assert.Nil(t, p.handle([]byte{0x30, 0x05, 0x02}))
+}
+
+func TestHandler4(t *testing.T) {
+	p := newPluginState(&options{root: root, community: "public"})
+	discovers, offers := p.counters.Counter("discover").Value(), p.counters.Counter("offer").Value()
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+	ctx := handler.NewContext(context.Background())
+	result, stop := p.Handler4(ctx, req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stop)
+	assert.Equal(t, discovers+1, p.counters.Counter("discover").Value())
+	assert.Equal(t, offers, p.counters.Counter("offer").Value())
+	handler.FinishReply4(ctx, resp)
+	assert.Equal(t, offers+1, p.counters.Counter("offer").Value())
+}
+
+func TestServe(t *testing.T) {
+	p, err := setup("listen=127.0.0.1:0", "oid=1.3.6.1.4.1.32473.67")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, stop()) }()
+
+	conn, err := net.Dial("udp", p.conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(request(version2c, "public", pduGet, 0, 0, sysObjectID))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	_, _, vbs := decode(t, buf[:n])
+	require.Len(t, vbs, 1)
+	assert.Equal(t, varbind{oid: sysObjectID, tag: tagOID, content: encodeOID(root)}, vbs[0])
+}
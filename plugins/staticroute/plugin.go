@@ -2,6 +2,20 @@
 // This source code is licensed under the MIT license found in the
 // LICENSE file in the root directory of this source tree.
 
+// Package staticroute implements a plugin advertising classless static
+// routes (RFC3442, option 121). Routes are given either as
+// `<destination>,<gateway>` or as `<destination> via <gateway>`:
+//
+// server4:
+//   plugins:
+//     - router: 192.168.1.1
+//     - staticroute: 10.10.0.0/16 via 192.168.1.254 10.20.0.0/16,192.168.1.253
+//
+// Clients receiving option 121 ignore the router option (3), so when the
+// response carries routers and no default route is configured, a default
+// route through the first router is added. The router plugin must then come
+// before this one. The routes are also sent in option 249, the Microsoft
+// variant of option 121, to the clients requesting it.
 package staticroute
 
 import (
@@ -25,6 +39,25 @@ var Plugin = plugins.Plugin{
 
 var routes dhcpv4.Routes
 
+// optionMSClasslessStaticRoute is the pre-standard option used by Microsoft
+// clients, with the same encoding as option 121
+var optionMSClasslessStaticRoute = dhcpv4.GenericOptionCode(249)
+
+func parseRoute(dest, gateway string) (*dhcpv4.Route, error) {
+	var err error
+	route := &dhcpv4.Route{}
+	_, route.Dest, err = net.ParseCIDR(dest)
+	if err != nil {
+		return nil, errors.New("expected a destination subnet, got: " + dest)
+	}
+
+	route.Router = net.ParseIP(gateway).To4()
+	if route.Router == nil {
+		return nil, errors.New("expected a gateway address, got: " + gateway)
+	}
+	return route, nil
+}
+
 func setup4(args ...string) (handler.Handler4, error) {
 	log.Printf("loaded plugin for DHCPv4.")
 	routes = make(dhcpv4.Routes, 0)
@@ -33,24 +66,22 @@ func setup4(args ...string) (handler.Handler4, error) {
 		return nil, errors.New("need at least one static route")
 	}
 
-	var err error
-	for _, arg := range args {
-		fields := strings.Split(arg, ",")
-		if len(fields) != 2 {
-			return Handler4, errors.New("expected a destination/gateway pair, got: " + arg)
+	for i := 0; i < len(args); i++ {
+		var fields []string
+		if i+2 < len(args) && args[i+1] == "via" {
+			fields = []string{args[i], args[i+2]}
+			i += 2
+		} else {
+			fields = strings.Split(args[i], ",")
+			if len(fields) != 2 {
+				return Handler4, errors.New("expected a destination/gateway pair, got: " + args[i])
+			}
 		}
 
-		route := &dhcpv4.Route{}
-		_, route.Dest, err = net.ParseCIDR(fields[0])
+		route, err := parseRoute(fields[0], fields[1])
 		if err != nil {
-			return Handler4, errors.New("expected a destination subnet, got: " + fields[0])
+			return Handler4, err
 		}
-
-		route.Router = net.ParseIP(fields[1])
-		if route.Router == nil {
-			return Handler4, errors.New("expected a gateway address, got: " + fields[1])
-		}
-
 		routes = append(routes, route)
 		log.Debugf("adding static route %s", route)
 	}
@@ -60,13 +91,37 @@ func setup4(args ...string) (handler.Handler4, error) {
 	return Handler4, nil
 }
 
+// withDefaultRoute returns the routes, with a default route through the
+// first of the routers added if there is none
+func withDefaultRoute(routes dhcpv4.Routes, routers []net.IP) dhcpv4.Routes {
+	if len(routers) == 0 {
+		return routes
+	}
+	for _, route := range routes {
+		if ones, _ := route.Dest.Mask.Size(); ones == 0 {
+			return routes
+		}
+	}
+	return append(routes[:len(routes):len(routes)], &dhcpv4.Route{
+		Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		Router: routers[0],
+	})
+}
+
 // Handler4 handles DHCPv4 packets for the static routes plugin
 func Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if len(routes) > 0 {
+		r := withDefaultRoute(routes, resp.Router())
 		resp.Options.Update(dhcpv4.Option{
 			Code:  dhcpv4.OptionCode(dhcpv4.OptionClasslessStaticRoute),
-			Value: routes,
+			Value: r,
 		})
+		for _, code := range req.ParameterRequestList() {
+			if code.Code() == optionMSClasslessStaticRoute.Code() {
+				resp.Options.Update(dhcpv4.Option{Code: optionMSClasslessStaticRoute, Value: r})
+				break
+			}
+		}
 	}
 
 	return resp, false
@@ -5,9 +5,12 @@
 package staticroute
 
 import (
+	"net"
 	"testing"
 
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSetup4(t *testing.T) {
@@ -58,3 +61,50 @@ func TestSetup4(t *testing.T) {
 		}
 	}
 }
+
+func TestSetup4Via(t *testing.T) {
+	_, err := setup4("10.10.0.0/16", "via", "192.168.1.254", "10.20.0.0/16,192.168.1.253")
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(routes)) {
+		assert.Equal(t, "10.10.0.0/16", routes[0].Dest.String())
+		assert.Equal(t, "192.168.1.254", routes[0].Router.String())
+		assert.Equal(t, "10.20.0.0/16", routes[1].Dest.String())
+	}
+
+	_, err = setup4("10.10.0.0/16", "via")
+	assert.Error(t, err)
+	_, err = setup4("10.10.0.0/16", "via", "2001:db8::1")
+	assert.Error(t, err)
+}
+
+func TestHandler4(t *testing.T) {
+	_, err := setup4("10.10.0.0/16", "via", "192.168.1.254")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionClasslessStaticRoute, optionMSClasslessStaticRoute))
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithRouter(net.IPv4(192, 168, 1, 1)))
+	require.NoError(t, err)
+
+	resp, stop := Handler4(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+
+	// The default route goes through the router, as clients ignore option 3
+	want := []byte{16, 10, 10, 192, 168, 1, 254, 0, 192, 168, 1, 1}
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionClasslessStaticRoute))
+	assert.Equal(t, want, resp.Options.Get(optionMSClasslessStaticRoute))
+	// The configured routes are left alone
+	assert.Equal(t, 1, len(routes))
+
+	// Without a router, nor a request for option 249
+	req, err = dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionClasslessStaticRoute))
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, _ = Handler4(req, stub)
+	assert.Equal(t, want[:7], resp.Options.Get(dhcpv4.OptionClasslessStaticRoute))
+	assert.Nil(t, resp.Options.Get(optionMSClasslessStaticRoute))
+}
@@ -0,0 +1,462 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package status implements a plugin serving the status of the server over
+// HTTP, for coredhcp-top (see cmds/coredhcp-top), monitoring systems and its
+// web UI: the leases, the recent lease events and PXE boots, the utilization
+// of the pools (see plugins/range), the metrics of the plugins and why the
+// requests were NAKed or dropped (see package metrics). The web UI also lists the reservations of the file plugin, and
+// creates new ones.
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - status: listen=127.0.0.1:8067
+//
+// The arguments are:
+// - listen=<address>: the address to serve on (mandatory). There is no
+// authentication, it should be a local address
+// - events=<n>: how many recent events, and PXE boots, are kept, 100 by
+// default
+// - token=<secret>: the bearer token of the read-only role. Without it, the
+// status is readable by all
+// - admin-token=<secret>: the bearer token of the admin role, which can also
+// create reservations. Without it, no one can
+//
+// The endpoints are:
+// - GET /: the web UI, asking for the token of a role if needed
+// - GET /status: the Status, as JSON
+// - GET /hosts: the leases linked by host, as JSON (see leaseevents.Host)
+// - GET /reservations: the reservations of the file plugin, as JSON
+// - POST /reservations: add a Reservation, given as JSON, to the file plugin
+// (admin role)
+// - GET /debug/vars: the variables published with expvar, including the
+// metrics
+//
+// The tokens are sent in the Authorization header, as "Bearer <token>", and
+// the role of the client is returned in the X-Coredhcp-Role header.
+//
+// The plugin must come after the plugins assigning addresses, as it follows
+// the leases through the exchanges (see plugins/leaseevents): the leases are
+// those it saw since the server started. The DHCPv6 and DHCPv4 instances
+// listening on the same address share their status.
+package status
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/metrics"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+)
+
+var log = logger.GetLogger("plugins/status")
+
+// Plugin wraps the status plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "status",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+// Status is the status of the server, as served on /status
+type Status struct {
+	Time time.Time `json:"time"`
+	// Leases are the last events of the leases, by address
+	Leases []leaseevents.Event `json:"leases"`
+	// Hosts are the leases linked by host, the DHCPv4 and DHCPv6 leases of
+	// a machine together
+	Hosts []leaseevents.Host `json:"hosts"`
+	// Events are the recent lease events, the last one first
+	Events []leaseevents.Event `json:"events"`
+	// PXE are the recent PXE boots, the last one first
+	PXE []leaseevents.Event `json:"pxe"`
+	// Pools is the utilization of the ranges, by range
+	Pools map[string]Pool `json:"pools"`
+	// Plugins are the metrics of the plugins, by plugin and by name
+	Plugins map[string]map[string]float64 `json:"plugins"`
+	// Refusals are how many requests were NAKed or dropped, by reason (see
+	// handler.Refuse)
+	Refusals map[string]int64 `json:"refusals"`
+}
+
+// Pool is the utilization of a range
+type Pool struct {
+	Size        int     `json:"size"`
+	Used        int     `json:"used"`
+	Utilization float64 `json:"utilization"`
+}
+
+// Reservation is a reservation of the file plugin
+type Reservation struct {
+	HWAddr string `json:"hwaddr"`
+	IP     net.IP `json:"ip"`
+}
+
+// The roles of the clients
+const (
+	RoleReadOnly = "read-only"
+	RoleAdmin    = "admin"
+)
+
+// RoleHeader is the header of the responses holding the role of the client
+const RoleHeader = "X-Coredhcp-Role"
+
+// instances holds the instances of the plugin, by listen address
+var instances = struct {
+	sync.Mutex
+	byAddr map[string]*PluginState
+}{byAddr: make(map[string]*PluginState)}
+
+// PluginState holds an instance of the plugin
+type PluginState struct {
+	tracker *leaseevents.Tracker
+	server  *http.Server
+	// token and adminToken are the tokens of the roles, if any
+	token, adminToken string
+
+	mu     sync.Mutex
+	events ring
+	pxe    ring
+}
+
+// ring holds the last events
+type ring struct {
+	// events holds the events, from next (the oldest one) when full
+	events []leaseevents.Event
+	next   int
+	size   int
+}
+
+func (r *ring) add(e leaseevents.Event) {
+	if len(r.events) < r.size {
+		r.events = append(r.events, e)
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % r.size
+}
+
+// list returns the events, the last one first
+func (r *ring) list() []leaseevents.Event {
+	events := make([]leaseevents.Event, 0, len(r.events))
+	for i := len(r.events) - 1; i >= 0; i-- {
+		events = append(events, r.events[(r.next+i)%len(r.events)])
+	}
+	return events
+}
+
+// options holds the arguments of the plugin
+type options struct {
+	listen            string
+	size              int
+	token, adminToken string
+}
+
+func parseArgs(args ...string) (*options, error) {
+	o := options{size: 100}
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "listen":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return nil, fmt.Errorf("invalid listen address %q: %v", value, err)
+			}
+			o.listen = value
+		case "events":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid number of events %q", value)
+			}
+			o.size = n
+		case "token", "admin-token":
+			if value == "" {
+				return nil, fmt.Errorf("empty %s", key)
+			}
+			if key == "token" {
+				o.token = value
+			} else {
+				o.adminToken = value
+			}
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if o.listen == "" {
+		return nil, errors.New("need a listen address")
+	}
+	return &o, nil
+}
+
+func newPluginState(o *options) *PluginState {
+	p := &PluginState{
+		token:      o.token,
+		adminToken: o.adminToken,
+		events:     ring{size: o.size},
+		pxe:        ring{size: o.size},
+	}
+	p.tracker = leaseevents.NewTracker(p.record)
+	return p
+}
+
+// handler returns the handler of the endpoints
+func (p *PluginState) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", p.serveUI)
+	mux.Handle("/status", p.authorize(RoleReadOnly, http.HandlerFunc(p.serveStatus)))
+	mux.Handle("/hosts", p.authorize(RoleReadOnly, http.HandlerFunc(p.serveHosts)))
+	mux.Handle("/reservations", p.authorize(RoleReadOnly, http.HandlerFunc(p.serveReservations)))
+	mux.Handle("/debug/vars", p.authorize(RoleReadOnly, expvar.Handler()))
+	return mux
+}
+
+func setup(args ...string) (*PluginState, error) {
+	o, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	listen := o.listen
+	instances.Lock()
+	defer instances.Unlock()
+	// Shared by the DHCPv6 and DHCPv4 instances, and kept on reload
+	if p, ok := instances.byAddr[listen]; ok {
+		return p, nil
+	}
+	p := newPluginState(o)
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	p.server = &http.Server{Handler: p.handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := p.server.Serve(ln); err != http.ErrServerClosed {
+			log.Errorf("status server on %s failed: %v", listen, err)
+		}
+	}()
+	instances.byAddr[listen] = p
+	log.Printf("serving the status on %s", listen)
+	return p, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.byAddr {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop stops serving the status
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for addr, p := range instances.byAddr {
+		if err := p.server.Shutdown(ctx); err != nil {
+			p.server.Close()
+		}
+		delete(instances.byAddr, addr)
+	}
+	return nil
+}
+
+// record keeps a lease event, the requests aside
+func (p *PluginState) record(e leaseevents.Event) {
+	if e.Event == leaseevents.Request {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events.add(e)
+	if e.Event == leaseevents.PXE {
+		p.pxe.add(e)
+	}
+}
+
+// role returns the role of the client of a request, empty if none
+func (p *PluginState) role(r *http.Request) string {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch {
+	case p.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) == 1:
+		return RoleAdmin
+	case p.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) == 1:
+		return RoleReadOnly
+	}
+	return ""
+}
+
+// authorize serves the requests of the clients having a role, admin being
+// allowed everything
+func (p *PluginState) authorize(role string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := p.role(r)
+		if got == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if got != role && got != RoleAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set(RoleHeader, got)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Status returns the status of the server
+func (p *PluginState) Status() Status {
+	s := Status{
+		Time:     time.Now().UTC(),
+		Leases:   p.tracker.Leases(),
+		Hosts:    p.tracker.Hosts(),
+		Pools:    make(map[string]Pool),
+		Plugins:  make(map[string]map[string]float64),
+		Refusals: metrics.Refusals(),
+	}
+	p.mu.Lock()
+	s.Events, s.PXE = p.events.list(), p.pxe.list()
+	p.mu.Unlock()
+	if v := expvar.Get("range"); v != nil {
+		_ = json.Unmarshal([]byte(v.String()), &s.Pools)
+	}
+	if v := expvar.Get("plugins"); v != nil {
+		var all map[string]map[string]interface{}
+		_ = json.Unmarshal([]byte(v.String()), &all)
+		for plugin, vars := range all {
+			s.Plugins[plugin] = make(map[string]float64)
+			for name, value := range vars {
+				// The metrics computed when read may not be numbers
+				if f, ok := value.(float64); ok {
+					s.Plugins[plugin][name] = f
+				}
+			}
+		}
+	}
+	return s
+}
+
+func (p *PluginState) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.Status()); err != nil {
+		log.Warningf("could not send the status: %v", err)
+	}
+}
+
+func (p *PluginState) serveHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.tracker.Hosts()); err != nil {
+		log.Warningf("could not send the hosts: %v", err)
+	}
+}
+
+// Reservations returns the reservations of the file plugin, by address
+func Reservations() []Reservation {
+	records, _ := file.Records()
+	reservations := make([]Reservation, 0, len(records))
+	for mac, ip := range records {
+		reservations = append(reservations, Reservation{HWAddr: mac, IP: ip})
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return bytes.Compare(reservations[i].IP.To16(), reservations[j].IP.To16()) < 0
+	})
+	return reservations
+}
+
+func (p *PluginState) serveReservations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Reservations()); err != nil {
+			log.Warningf("could not send the reservations: %v", err)
+		}
+	case http.MethodPost:
+		if p.role(r) != RoleAdmin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var res Reservation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&res); err != nil {
+			http.Error(w, fmt.Sprintf("invalid reservation: %v", err), http.StatusBadRequest)
+			return
+		}
+		mac, err := net.ParseMAC(res.HWAddr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid hardware address %q", res.HWAddr), http.StatusBadRequest)
+			return
+		}
+		if err := file.AddRecord(mac, res.IP); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Infof("reservation of %s for %s added from %s", res.IP, mac, r.RemoteAddr)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Handler4 follows the leases of DHCPv4 exchanges
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.tracker.Handle4(req, resp)
+	return resp, false
+}
+
+// Handler6 follows the leases of DHCPv6 exchanges
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.tracker.Handle6(req, resp)
+	return resp, false
+}
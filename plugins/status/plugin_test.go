@@ -0,0 +1,172 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package status
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/metrics"
+	"github.com/coredhcp/coredhcp/plugins/file"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	o, err := parseArgs("listen=127.0.0.1:8067")
+	require.NoError(t, err)
+	assert.Equal(t, &options{listen: "127.0.0.1:8067", size: 100}, o)
+
+	o, err = parseArgs("listen=[::1]:8067", "events=10", "token=r", "admin-token=w")
+	require.NoError(t, err)
+	assert.Equal(t, &options{listen: "[::1]:8067", size: 10, token: "r", adminToken: "w"}, o)
+
+	for _, args := range [][]string{
+		{},
+		{"listen"},
+		{"listen=localhost"},
+		{"listen=:8067", "events=0"},
+		{"listen=:8067", "port=1"},
+		{"listen=:8067", "token="},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	p := newPluginState(&options{size: 2})
+	p.record(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 100)})
+	p.record(leaseevents.Event{Event: leaseevents.Request, IP: net.IPv4(192, 0, 2, 100)})
+	p.record(leaseevents.Event{Event: leaseevents.PXE, IP: net.IPv4(192, 0, 2, 101)})
+	p.record(leaseevents.Event{Event: leaseevents.Release, IP: net.IPv4(192, 0, 2, 100)})
+	p.record(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 102)})
+
+	events := p.events.list()
+	require.Len(t, events, 2)
+	assert.Equal(t, "192.0.2.102", events[0].IP.String())
+	assert.Equal(t, leaseevents.Release, events[1].Event)
+	pxe := p.pxe.list()
+	require.Len(t, pxe, 1)
+	assert.Equal(t, "192.0.2.101", pxe[0].IP.String())
+}
+
+func TestServeStatus(t *testing.T) {
+	p := newPluginState(&options{size: 10})
+	expvar.NewMap("range").Set("192.0.2.100-192.0.2.200", expvar.Func(func() interface{} {
+		return Pool{Size: 101, Used: 1, Utilization: 0.01}
+	}))
+	metrics.CountRefusal("test-status")
+
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	result, stop := p.Handler4(req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stop)
+
+	w := httptest.NewRecorder()
+	p.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, RoleReadOnly, w.Header().Get(RoleHeader))
+	var s Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &s))
+	require.Len(t, s.Leases, 1)
+	assert.Equal(t, "00:01:02:03:04:05", s.Leases[0].HWAddr)
+	require.Len(t, s.Events, 1)
+	assert.Equal(t, leaseevents.Allocate, s.Events[0].Event)
+	assert.Equal(t, Pool{Size: 101, Used: 1, Utilization: 0.01}, s.Pools["192.0.2.100-192.0.2.200"])
+	assert.Equal(t, int64(1), s.Refusals["test-status"])
+	require.Len(t, s.Hosts, 1)
+	require.Len(t, s.Hosts[0].IPv4, 1)
+	assert.Equal(t, "192.0.2.100", s.Hosts[0].IPv4[0].String())
+
+	w = httptest.NewRecorder()
+	p.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hosts", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var hosts []leaseevents.Host
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hosts))
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "00:01:02:03:04:05", hosts[0].HWAddr)
+
+	w = httptest.NewRecorder()
+	p.serveStatus(w, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func request(p *PluginState, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	p.handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestRoles(t *testing.T) {
+	p := newPluginState(&options{size: 10, token: "r", adminToken: "w"})
+
+	// The UI holds no data
+	w := request(p, http.MethodGet, "/", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>CoreDHCP</title>")
+
+	assert.Equal(t, http.StatusUnauthorized, request(p, http.MethodGet, "/status", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(p, http.MethodGet, "/debug/vars", "x", "").Code)
+	w = request(p, http.MethodGet, "/status", "r", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, RoleReadOnly, w.Header().Get(RoleHeader))
+	w = request(p, http.MethodGet, "/status", "w", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, RoleAdmin, w.Header().Get(RoleHeader))
+}
+
+func TestReservations(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "reservations")
+	require.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString("00:11:22:33:44:55 192.0.2.10")
+	require.NoError(t, err)
+	require.NoError(t, tmp.Close())
+	_, err = file.Plugin.Setup4(tmp.Name())
+	require.NoError(t, err)
+
+	p := newPluginState(&options{size: 10, adminToken: "w"})
+	body := `{"hwaddr": "00:11:22:33:44:56", "ip": "192.0.2.11"}`
+	assert.Equal(t, http.StatusForbidden, request(p, http.MethodPost, "/reservations", "", body).Code)
+	assert.Equal(t, http.StatusCreated, request(p, http.MethodPost, "/reservations", "w", body).Code)
+	// Already reserved
+	assert.Equal(t, http.StatusBadRequest, request(p, http.MethodPost, "/reservations", "w", body).Code)
+	assert.Equal(t, http.StatusBadRequest, request(p, http.MethodPost, "/reservations", "w", `{"hwaddr": "00:11:22:33:44:57", "ip": "2001:db8::1"}`).Code)
+
+	w := request(p, http.MethodGet, "/reservations", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var reservations []Reservation
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reservations))
+	assert.Equal(t, []Reservation{
+		{HWAddr: "00:11:22:33:44:55", IP: net.ParseIP("192.0.2.10")},
+		{HWAddr: "00:11:22:33:44:56", IP: net.ParseIP("192.0.2.11")},
+	}, reservations)
+	data, err := ioutil.ReadFile(tmp.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:55 192.0.2.10\n00:11:22:33:44:56 192.0.2.11\n", string(data))
+}
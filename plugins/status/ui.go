@@ -0,0 +1,182 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package status
+
+import (
+	"net/http"
+)
+
+// serveUI serves the web UI, a single page querying the other endpoints. It
+// holds no data, and is served to all
+func (p *PluginState) serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write([]byte(uiPage))
+}
+
+// uiPage is the web UI. The token, if needed, is kept in the local storage of
+// the browser
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>CoreDHCP</title>
+<style>
+body { font-family: sans-serif; margin: 0 2em; color: #222; }
+header { display: flex; align-items: baseline; gap: 2em; border-bottom: 1px solid #ccc; }
+nav a { margin-right: 1em; cursor: pointer; color: #06c; }
+nav a.active { font-weight: bold; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { text-align: left; padding: .2em 1em .2em 0; border-bottom: 1px solid #eee; font-family: monospace; }
+th { font-family: sans-serif; }
+input { margin-right: .5em; }
+.error { color: #b00; }
+.hidden { display: none; }
+#role { color: #666; font-size: small; }
+</style>
+</head>
+<body>
+<header>
+<h2>CoreDHCP</h2>
+<nav>
+<a data-tab="leases">Leases</a>
+<a data-tab="reservations">Reservations</a>
+<a data-tab="pxe">PXE boots</a>
+<a data-tab="pools">Pools</a>
+</nav>
+<span id="role"></span>
+</header>
+<p id="error" class="error"></p>
+<form id="login" class="hidden">
+<input id="token" type="password" placeholder="Token">
+<button>Sign in</button>
+</form>
+
+<section id="leases">
+<input id="search" placeholder="Search address, client, host name">
+<table><thead><tr><th>Address</th><th>Client</th><th>Host name</th><th>Vendor class</th><th>Last event</th><th>Expires</th></tr></thead><tbody></tbody></table>
+</section>
+
+<section id="reservations">
+<form id="reserve" class="hidden">
+<input id="hwaddr" placeholder="MAC address" required>
+<input id="ip" placeholder="IP address" required>
+<button>Reserve</button>
+</form>
+<table><thead><tr><th>MAC address</th><th>Address</th></tr></thead><tbody></tbody></table>
+</section>
+
+<section id="pxe">
+<table><thead><tr><th>Time</th><th>Address</th><th>Client</th><th>Vendor class</th><th>Boot file</th></tr></thead><tbody></tbody></table>
+</section>
+
+<section id="pools">
+<table><thead><tr><th>Pool</th><th>Size</th><th>Used</th><th>Utilization</th></tr></thead><tbody></tbody></table>
+</section>
+
+<script>
+"use strict";
+var tab = location.hash.slice(1) || "leases", status = null, reservations = [];
+
+function $(id) { return document.getElementById(id); }
+
+function api(method, path, body) {
+	var headers = {};
+	var token = localStorage.getItem("coredhcp-token");
+	if (token) headers["Authorization"] = "Bearer " + token;
+	if (body) headers["Content-Type"] = "application/json";
+	return fetch(path, {method: method, headers: headers, body: body ? JSON.stringify(body) : undefined}).then(function (r) {
+		if (r.status === 401) {
+			$("login").classList.remove("hidden");
+			throw new Error("a token is needed");
+		}
+		var role = r.headers.get("X-Coredhcp-Role");
+		if (role) {
+			$("role").textContent = role;
+			$("reserve").classList.toggle("hidden", role !== "admin");
+		}
+		if (!r.ok) return r.text().then(function (t) { throw new Error(t.trim()); });
+		return r.status === 201 ? null : r.json();
+	});
+}
+
+function rows(section, list, cells) {
+	var tbody = $(section).querySelector("tbody");
+	tbody.textContent = "";
+	list.forEach(function (item) {
+		var tr = document.createElement("tr");
+		cells(item).forEach(function (c) {
+			var td = document.createElement("td");
+			td.textContent = c === undefined || c === null ? "" : c;
+			tr.appendChild(td);
+		});
+		tbody.appendChild(tr);
+	});
+}
+
+function client(e) { return e.hwaddr || e.duid; }
+
+function time(t) { return t ? new Date(t).toLocaleString() : ""; }
+
+function render() {
+	document.querySelectorAll("nav a").forEach(function (a) { a.classList.toggle("active", a.dataset.tab === tab); });
+	document.querySelectorAll("section").forEach(function (s) { s.classList.toggle("hidden", s.id !== tab); });
+	if (status) {
+		var q = $("search").value.toLowerCase();
+		rows("leases", status.leases.filter(function (l) {
+			return !q || [l.ip, client(l), l.hostname, l.vendor_class].join(" ").toLowerCase().indexOf(q) >= 0;
+		}), function (l) { return [l.ip, client(l), l.hostname, l.vendor_class, l.event, time(l.expires)]; });
+		rows("pxe", status.pxe, function (e) { return [time(e.time), e.ip, client(e), e.vendor_class, e.boot_file]; });
+		rows("pools", Object.keys(status.pools).sort(), function (k) {
+			var p = status.pools[k];
+			return [k, p.size, p.used, (100 * p.utilization).toFixed(1) + "%"];
+		});
+	}
+	rows("reservations", reservations, function (r) { return [r.hwaddr, r.ip]; });
+}
+
+function refresh() {
+	Promise.all([api("GET", "/status"), api("GET", "/reservations")]).then(function (res) {
+		status = res[0];
+		reservations = res[1];
+		$("error").textContent = "";
+		$("login").classList.add("hidden");
+		render();
+	}).catch(function (err) { $("error").textContent = err.message; });
+}
+
+document.querySelectorAll("nav a").forEach(function (a) {
+	a.onclick = function () { tab = a.dataset.tab; location.hash = tab; render(); };
+});
+$("search").oninput = render;
+$("login").onsubmit = function (ev) {
+	ev.preventDefault();
+	localStorage.setItem("coredhcp-token", $("token").value);
+	refresh();
+};
+$("reserve").onsubmit = function (ev) {
+	ev.preventDefault();
+	api("POST", "/reservations", {hwaddr: $("hwaddr").value, ip: $("ip").value}).then(function () {
+		$("hwaddr").value = $("ip").value = "";
+		refresh();
+	}).catch(function (err) { $("error").textContent = err.message; });
+};
+render();
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,286 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package syncplugin implements a plugin serving host reservations kept in a
+// Git repository, or behind an HTTP URL, and pulled periodically, for a
+// GitOps-style management of the DHCP data.
+//
+// The reservations are one per line: a MAC address, an IP address (IPv4 for
+// server4, IPv6 for server6), and option overrides with the syntax of the
+// options plugin. Empty lines and lines starting with # are ignored:
+//
+//  # printers
+//  00:11:22:33:44:55 10.0.0.10 12=string:printer-1 3=ip:10.0.0.254
+//  00:11:22:33:44:56 10.0.0.11
+//
+// Arguments are the source of the reservations, either an http:// or
+// https:// URL, or a Git repository URL prefixed with git+ (eg.
+// git+https://git.example.org/dhcp.git or git+ssh://git@git.example.org/dhcp.git),
+// then:
+// - path=<file>: the file of the reservations in the Git repository,
+// reservations.txt by default
+// - ref=<branch or tag>: the reference to follow in the Git repository, the
+// default branch by default
+// - interval=<duration>: how often the source is pulled, 5m by default
+//
+// server4:
+//   plugins:
+//     - sync: git+https://git.example.org/dhcp.git path=site1/reservations.txt ref=production
+//
+// The reservations are validated, and replaced all at once: invalid data is
+// logged and ignored, the previous reservations are served until it is fixed.
+// The reservations must be valid when the server starts.
+//
+// Git sources need the git command. They are fetched with the credentials of
+// the server (eg. its SSH keys, or its git credential helpers).
+//
+// As for the file plugin, the DHCPv4 clients with a reservation are not
+// handled by the following plugins. The option overrides replace the options
+// set by the previous plugins.
+package syncplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/duid"
+	"github.com/coredhcp/coredhcp/plugins/ra"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/sync")
+
+// Plugin wraps the sync plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "sync",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds the reservations of an instance of the plugin
+type PluginState struct {
+	v6       bool
+	source   source
+	interval time.Duration
+	// records holds the current map[string]*reservation, keyed by MAC
+	// address, replaced all at once on updates
+	records atomic.Value
+}
+
+func parseArgs(v6 bool, args ...string) (*PluginState, time.Duration, error) {
+	if len(args) < 1 {
+		return nil, 0, errors.New("need the source of the reservations")
+	}
+	var (
+		src      = args[0]
+		path     = "reservations.txt"
+		ref      string
+		interval = 5 * time.Minute
+	)
+	for _, arg := range args[1:] {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, 0, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "path":
+			path = value
+		case "ref":
+			ref = value
+		case "interval":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < time.Second {
+				return nil, 0, fmt.Errorf("invalid interval %q", value)
+			}
+			interval = d
+		default:
+			return nil, 0, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+
+	p := PluginState{v6: v6}
+	switch {
+	case strings.HasPrefix(src, "git+"):
+		s, err := newGitSource(strings.TrimPrefix(src, "git+"), ref, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		p.source = s
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+		p.source = newHTTPSource(src)
+	default:
+		return nil, 0, fmt.Errorf("unsupported source %q, expected an http(s) URL or a git+ URL", src)
+	}
+	return &p, interval, nil
+}
+
+// update fetches the reservations, and replaces the current ones if they
+// changed and are valid
+func (p *PluginState) update() error {
+	data, err := p.source.Fetch()
+	if err != nil {
+		return fmt.Errorf("could not fetch the reservations: %w", err)
+	}
+	if data == nil {
+		return nil
+	}
+	records, err := parseRecords(data, p.v6)
+	if err != nil {
+		return fmt.Errorf("invalid reservations: %w", err)
+	}
+	p.records.Store(records)
+	log.Infof("loaded %d reservations", len(records))
+	return nil
+}
+
+func (p *PluginState) lookup(mac net.HardwareAddr) *reservation {
+	records, _ := p.records.Load().(map[string]*reservation)
+	return records[mac.String()]
+}
+
+func setup(v6 bool, args ...string) (*PluginState, error) {
+	p, interval, err := parseArgs(v6, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.update(); err != nil {
+		p.source.Close()
+		return nil, err
+	}
+	p.interval = interval
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	return p, nil
+}
+
+// start starts updating the reservations of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.poll(ctx)
+	}
+	return nil
+}
+
+// stop releases the sources of the instances, eg. removes the clones of
+// their Git repositories
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	var err error
+	for _, p := range instances.list {
+		if cerr := p.source.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// poll updates the reservations periodically, until ctx is done
+func (p *PluginState) poll(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.update(); err != nil {
+				log.Errorf("keeping the previous reservations: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(false, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(true, args...)
+	if err != nil {
+		return nil, err
+	}
+	ra.AdvertiseAddresses()
+	return p.Handler6, nil
+}
+
+// Handler4 handles DHCPv4 packets for the sync plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeNone:
+	default:
+		return resp, false
+	}
+	r := p.lookup(req.ClientHWAddr)
+	if r == nil {
+		return resp, false
+	}
+	resp.YourIPAddr = r.ip
+	for _, o := range r.options {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(o.code), o.value))
+	}
+	log.Debugf("found IP address %s for MAC %s", r.ip, req.ClientHWAddr)
+	return resp, true
+}
+
+// Handler6 handles DHCPv6 packets for the sync plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	m, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	if m.Options.OneIANA() == nil {
+		return resp, false
+	}
+	mac, _ := duid.HWAddr(req)
+	if mac == nil {
+		return resp, false
+	}
+	r := p.lookup(mac)
+	if r == nil {
+		return resp, false
+	}
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: m.Options.OneIANA().IaId,
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{
+				IPv6Addr:          r.ip,
+				PreferredLifetime: 3600 * time.Second,
+				ValidLifetime:     3600 * time.Second,
+			},
+		}},
+	})
+	for _, o := range r.options {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(o.code), OptionData: o.value})
+	}
+	log.Debugf("found IP address %s for MAC %s", r.ip, mac)
+	return resp, false
+}
@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package syncplugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticSource returns its data once
+type staticSource struct {
+	data []byte
+}
+
+func (s *staticSource) Fetch() ([]byte, error) {
+	data := s.data
+	s.data = nil
+	return data, nil
+}
+
+func (s *staticSource) Close() error {
+	return nil
+}
+
+func TestParseArgs(t *testing.T) {
+	p, interval, err := parseArgs(false, "https://example.org/reservations.txt", "interval=1m")
+	require.NoError(t, err)
+	assert.IsType(t, &httpSource{}, p.source)
+	assert.Equal(t, "1m0s", interval.String())
+
+	for _, args := range [][]string{
+		{},
+		{"ftp://example.org/reservations.txt"},
+		{"/etc/reservations.txt"},
+		{"https://example.org/reservations.txt", "interval=0s"},
+		{"https://example.org/reservations.txt", "branch=main"},
+		{"https://example.org/reservations.txt", "main"},
+	} {
+		_, _, err := parseArgs(false, args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	src := &staticSource{data: []byte("00:11:22:33:44:55 10.0.0.10 12=string:printer-1\n")}
+	p := PluginState{source: src}
+	require.NoError(t, p.update())
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithOption(dhcpv4.OptHostName("other")))
+	require.NoError(t, err)
+	resp, stop := p.Handler4(req, stub)
+	assert.True(t, stop)
+	assert.Equal(t, net.IPv4(10, 0, 0, 10).To4(), resp.YourIPAddr)
+	assert.Equal(t, "printer-1", resp.HostName())
+
+	// Invalid updates are ignored
+	src.data = []byte("00:11:22:33:44:55 10.0.0.300\n")
+	assert.Error(t, p.update())
+	assert.NotNil(t, p.lookup(req.ClientHWAddr))
+
+	// Unknown clients are left to the next plugins
+	src.data = []byte("00:11:22:33:44:56 10.0.0.11\n")
+	require.NoError(t, p.update())
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop = p.Handler4(req, stub)
+	assert.False(t, stop)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
@@ -0,0 +1,78 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package syncplugin
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/plugins/options"
+)
+
+// option is an option override of a reservation
+type option struct {
+	code  uint64
+	value []byte
+}
+
+// reservation is the address, and the option overrides, of a client
+type reservation struct {
+	ip      net.IP
+	options []option
+}
+
+// parseRecords parses and validates reservations, one per line: a MAC address,
+// an IP address, and option overrides with the syntax of plugins/options. Empty
+// lines and lines starting with # are ignored
+func parseRecords(data []byte, v6 bool) (map[string]*reservation, error) {
+	records := make(map[string]*reservation)
+	owners := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("line %d: want at least 2 fields, got %d", n, len(tokens))
+		}
+		hwaddr, err := net.ParseMAC(tokens[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: malformed hardware address %s", n, tokens[0])
+		}
+		ip := net.ParseIP(tokens[1])
+		if ip == nil || (ip.To4() == nil) != v6 {
+			return nil, fmt.Errorf("line %d: expected an IPv%d address, got %s", n, map[bool]int{false: 4, true: 6}[v6], tokens[1])
+		}
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		mac := hwaddr.String()
+		if _, ok := records[mac]; ok {
+			return nil, fmt.Errorf("line %d: duplicate reservation for %s", n, mac)
+		}
+		if owner, ok := owners[ip.String()]; ok {
+			return nil, fmt.Errorf("line %d: %s is already reserved for %s", n, ip, owner)
+		}
+		owners[ip.String()] = mac
+		r := reservation{ip: ip}
+		for _, arg := range tokens[2:] {
+			code, value, err := options.Parse(arg, v6)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			r.options = append(r.options, option{code: code, value: value})
+		}
+		records[mac] = &r
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package syncplugin
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRecords(t *testing.T) {
+	records, err := parseRecords([]byte(`
+# printers
+00:11:22:33:44:55 10.0.0.10 12=string:printer-1 3=ip:10.0.0.254
+  00:11:22:33:44:56   10.0.0.11
+`), false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*reservation{
+		"00:11:22:33:44:55": {ip: net.IPv4(10, 0, 0, 10).To4(), options: []option{
+			{code: 12, value: []byte("printer-1")},
+			{code: 3, value: []byte{10, 0, 0, 254}},
+		}},
+		"00:11:22:33:44:56": {ip: net.IPv4(10, 0, 0, 11).To4()},
+	}, records)
+
+	records, err = parseRecords([]byte("00:11:22:33:44:55 2001:db8::10 23=ip-list:2001:db8::53\n"), true)
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8::10"), records["00:11:22:33:44:55"].ip)
+
+	for _, data := range []string{
+		"00:11:22:33:44:55",
+		"00:11:22:33:44:5 10.0.0.10",
+		"00:11:22:33:44:55 2001:db8::10",
+		"00:11:22:33:44:55 10.0.0.10 12",
+		"00:11:22:33:44:55 10.0.0.10 300=string:too-big",
+		"00:11:22:33:44:55 10.0.0.10\n00:11:22:33:44:55 10.0.0.11",
+		"00:11:22:33:44:55 10.0.0.10\n00:11:22:33:44:56 10.0.0.10",
+	} {
+		_, err := parseRecords([]byte(data), false)
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package syncplugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fetchTimeout bounds how long fetching the reservations can take
+const fetchTimeout = time.Minute
+
+// source is where the reservations are fetched from
+type source interface {
+	// Fetch returns the reservations, or nil if they didn't change since the
+	// last fetch
+	Fetch() ([]byte, error)
+	// Close releases the resources of the source, eg. the clone of a Git
+	// repository
+	Close() error
+}
+
+// httpSource fetches the reservations from an HTTP URL, using the ETag of the
+// last response to only download them when they changed
+type httpSource struct {
+	url    string
+	client *http.Client
+	etag   string
+}
+
+func newHTTPSource(url string) *httpSource {
+	return &httpSource{url: url, client: &http.Client{Timeout: fetchTimeout}}
+}
+
+func (s *httpSource) Fetch() ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.etag = resp.Header.Get("ETag")
+	return data, nil
+}
+
+// Close does nothing, the source holding no resources
+func (s *httpSource) Close() error {
+	return nil
+}
+
+// gitSource fetches the reservations from a file in a Git repository, with
+// the git command. The repository is cloned in a directory of its own
+type gitSource struct {
+	url string
+	// ref is the branch or tag to follow, the default branch if empty
+	ref  string
+	path string
+	dir  string
+	// commit is the commit of the last fetch
+	commit string
+}
+
+func newGitSource(url, ref, path string) (*gitSource, error) {
+	dir, err := ioutil.TempDir("", "coredhcp-sync")
+	if err != nil {
+		return nil, err
+	}
+	return &gitSource{url: url, ref: ref, path: path, dir: dir}, nil
+}
+
+func (s *gitSource) git(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", s.dir}, args...)...)
+	// Never prompt for credentials
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (s *gitSource) Fetch() ([]byte, error) {
+	if s.commit == "" {
+		if _, err := s.git("init", "--quiet"); err != nil {
+			return nil, err
+		}
+	}
+	ref := s.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := s.git("fetch", "--quiet", "--depth=1", s.url, ref); err != nil {
+		return nil, err
+	}
+	commit, err := s.git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if commit == s.commit {
+		return nil, nil
+	}
+	// Read the file from the commit, there is no need for a work tree
+	data, err := s.git("show", commit+":"+filepath.ToSlash(s.path))
+	if err != nil {
+		return nil, err
+	}
+	s.commit = commit
+	return []byte(data + "\n"), nil
+}
+
+// Close removes the clone of the repository
+func (s *gitSource) Close() error {
+	return os.RemoveAll(s.dir)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package syncplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPSource(t *testing.T) {
+	content, version := "00:11:22:33:44:55 10.0.0.10\n", 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	s := newHTTPSource(srv.URL)
+	data, err := s.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	data, err = s.Fetch()
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	content, version = "00:11:22:33:44:55 10.0.0.11\n", 2
+	data, err = s.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	_, err = newHTTPSource(srv.URL + "/\x00").Fetch()
+	assert.Error(t, err)
+}
+
+// git runs a git command in dir
+func git(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.org"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	repo, err := ioutil.TempDir("", "coredhcptest")
+	require.NoError(t, err)
+	defer os.RemoveAll(repo)
+	git(t, repo, "init", "--quiet")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, "site1"), 0755))
+	file := filepath.Join(repo, "site1", "reservations.txt")
+	require.NoError(t, ioutil.WriteFile(file, []byte("00:11:22:33:44:55 10.0.0.10\n"), 0644))
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "--quiet", "-m", "Add reservations")
+
+	s, err := newGitSource(repo, "", "site1/reservations.txt")
+	require.NoError(t, err)
+	defer s.Close()
+	data, err := s.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:55 10.0.0.10\n", string(data))
+	data, err = s.Fetch()
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("00:11:22:33:44:55 10.0.0.11\n"), 0644))
+	git(t, repo, "commit", "--quiet", "-a", "-m", "Update reservations")
+	data, err = s.Fetch()
+	require.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:55 10.0.0.11\n", string(data))
+
+	s.ref = "nonexistent"
+	_, err = s.Fetch()
+	assert.Error(t, err)
+}
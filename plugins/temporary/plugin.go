@@ -0,0 +1,239 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package temporary assigns DHCPv6 temporary addresses (IA_TA, RFC8415 §21.5)
+// out of a pool. Temporary addresses are meant for privacy (RFC4941): they
+// are picked at random in the pool, so they can't be guessed from the client
+// identity or from previous assignments, and have short lifetimes after which
+// the client is expected to ask for a new one.
+//
+// The plugin takes the pool prefix and, optionally, the valid lifetime of the
+// addresses (1h by default). The preferred lifetime is half of it, so that
+// clients switch to a new address before the old one becomes invalid:
+//
+// server6:
+//   plugins:
+//     - server_id: LL 00:de:ad:be:ef:00
+//     - file: "leases6.txt"
+//     - temporary: 2001:db8:0:1::/64 30m
+//
+// Bindings are kept in memory only, and the lifetime of an address is never
+// extended: on Renew or Rebind the client gets the remaining lifetime of the
+// address, and a new address once it expired.
+package temporary
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/ra"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	dhcpIana "github.com/insomniacslk/dhcp/iana"
+)
+
+var log = logger.GetLogger("plugins/temporary")
+
+// Plugin wraps plugin registration information
+var Plugin = plugins.Plugin{
+	Name:   "temporary",
+	Setup6: setup6,
+}
+
+const defaultLifetime = time.Hour
+
+// allocationAttempts is how many random addresses are tried before
+// considering the pool full
+const allocationAttempts = 64
+
+type binding struct {
+	IP      net.IP
+	Expires time.Time
+}
+
+// PluginState holds the temporary address bindings
+type PluginState struct {
+	sync.Mutex
+	pool     net.IPNet
+	lifetime time.Duration
+	// bindings are keyed by client DUID and IAID, inUse by address
+	bindings  map[string]*binding
+	inUse     map[string]string
+	lastSweep time.Time
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errors.New("need a pool prefix, and optionally a lifetime")
+	}
+	_, pool, err := net.ParseCIDR(args[0])
+	if err != nil || pool.IP.To4() != nil {
+		return nil, fmt.Errorf("invalid IPv6 pool %s", args[0])
+	}
+	if size, _ := pool.Mask.Size(); size > 120 {
+		return nil, fmt.Errorf("pool %s is too small for random allocation", pool)
+	}
+	p := PluginState{
+		pool:      *pool,
+		lifetime:  defaultLifetime,
+		bindings:  make(map[string]*binding),
+		inUse:     make(map[string]string),
+		lastSweep: time.Now(),
+	}
+	if len(args) == 2 {
+		if p.lifetime, err = time.ParseDuration(args[1]); err != nil || p.lifetime <= 0 {
+			return nil, fmt.Errorf("invalid lifetime %s", args[1])
+		}
+	}
+	ra.AdvertiseAddresses()
+	log.Printf("loaded plugin for DHCPv6, temporary addresses from %s for %s", &p.pool, p.lifetime)
+	return p.Handler6, nil
+}
+
+// Handler6 handles the IA_TA options of DHCPv6 messages
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("BUG: could not decapsulate: %v", err)
+		return nil, true
+	}
+	iatas := getIATAs(msg.Options.Options)
+	if len(iatas) == 0 {
+		return resp, false
+	}
+	client := msg.Options.ClientID()
+	if client == nil {
+		log.Error("Invalid packet received, no clientID")
+		return nil, true
+	}
+
+	now := time.Now()
+	p.Lock()
+	defer p.Unlock()
+	p.sweep(now)
+
+	for _, iata := range iatas {
+		key := string(client.ToBytes()) + string(iata.IaId[:])
+		switch msg.Type() {
+		case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest,
+			dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+		case dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline:
+			p.release(key)
+			continue
+		default:
+			continue
+		}
+
+		iataResp := &dhcpv6.OptIATA{IaId: iata.IaId}
+		b, err := p.lookupOrAllocate(key, now)
+		if err != nil {
+			log.Warningf("No temporary address for %s (IAID %x): %v", client, iata.IaId, err)
+			iataResp.Options.Add(&dhcpv6.OptStatusCode{
+				StatusCode:    dhcpIana.StatusNoAddrsAvail,
+				StatusMessage: err.Error(),
+			})
+		} else {
+			valid := b.Expires.Sub(now)
+			preferred := valid - p.lifetime/2
+			if preferred < 0 {
+				preferred = 0
+			}
+			iataResp.Options.Add(&dhcpv6.OptIAAddress{
+				IPv6Addr:          b.IP,
+				PreferredLifetime: preferred.Round(time.Second),
+				ValidLifetime:     valid.Round(time.Second),
+			})
+		}
+		resp.AddOption(iataResp)
+	}
+	return resp, false
+}
+
+func (p *PluginState) release(key string) {
+	if b, ok := p.bindings[key]; ok {
+		delete(p.inUse, b.IP.String())
+		delete(p.bindings, key)
+	}
+}
+
+// lookupOrAllocate returns the active binding of a client IA_TA, or creates
+// one with a random address
+func (p *PluginState) lookupOrAllocate(key string, now time.Time) (*binding, error) {
+	if b, ok := p.bindings[key]; ok {
+		if b.Expires.After(now) {
+			return b, nil
+		}
+		p.release(key)
+	}
+	for i := 0; i < allocationAttempts; i++ {
+		ip, err := p.randomAddress()
+		if err != nil {
+			return nil, err
+		}
+		if holder, ok := p.inUse[ip.String()]; ok {
+			if p.bindings[holder].Expires.After(now) {
+				continue
+			}
+			p.release(holder)
+		}
+		b := &binding{IP: ip, Expires: now.Add(p.lifetime)}
+		p.bindings[key] = b
+		p.inUse[ip.String()] = key
+		return b, nil
+	}
+	return nil, errors.New("pool exhausted")
+}
+
+// randomAddress returns a random address of the pool, never the
+// Subnet-Router anycast address (all zeroes host part, RFC4291 §2.6.1)
+func (p *PluginState) randomAddress() (net.IP, error) {
+	for {
+		ip := make(net.IP, net.IPv6len)
+		if _, err := rand.Read(ip); err != nil {
+			return nil, err
+		}
+		hostZero := true
+		for i := range ip {
+			host := ip[i] &^ p.pool.Mask[i]
+			if host != 0 {
+				hostZero = false
+			}
+			ip[i] = p.pool.IP[i]&p.pool.Mask[i] | host
+		}
+		if !hostZero {
+			return ip, nil
+		}
+	}
+}
+
+// sweep forgets the expired bindings, at most once per lifetime
+func (p *PluginState) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < p.lifetime {
+		return
+	}
+	p.lastSweep = now
+	for key, b := range p.bindings {
+		if !b.Expires.After(now) {
+			p.release(key)
+		}
+	}
+}
+
+// getIATAs returns the IA_TA options. MessageOptions.IATA can't be used, it
+// looks up IA_NA options instead (and panics when it finds one)
+func getIATAs(opts dhcpv6.Options) []*dhcpv6.OptIATA {
+	var iatas []*dhcpv6.OptIATA
+	for _, o := range opts.Get(dhcpv6.OptionIATA) {
+		if iata, ok := o.(*dhcpv6.OptIATA); ok {
+			iatas = append(iatas, iata)
+		}
+	}
+	return iatas
+}
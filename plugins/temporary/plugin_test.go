@@ -0,0 +1,118 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package temporary
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, mt dhcpv6.MessageType, mac net.HardwareAddr) (*dhcpv6.Message, *dhcpv6.Message) {
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = mt
+	req.AddOption(dhcpv6.OptClientID(dhcpv6.Duid{
+		Type:          dhcpv6.DUID_LL,
+		HwType:        iana.HWTypeEthernet,
+		LinkLayerAddr: mac,
+	}))
+	req.AddOption(&dhcpv6.OptIATA{IaId: [4]byte{0, 0, 0, 1}})
+	// An IA_NA alongside, handled by other plugins
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 2}})
+	resp, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	resp.MessageType = dhcpv6.MessageTypeReply
+	return req, resp
+}
+
+func assigned(t *testing.T, resp dhcpv6.DHCPv6) *dhcpv6.OptIAAddress {
+	iatas := getIATAs(resp.(*dhcpv6.Message).Options.Options)
+	require.Len(t, iatas, 1)
+	addrs := iatas[0].Options.Addresses()
+	require.Len(t, addrs, 1)
+	return addrs[0]
+}
+
+func TestAssign(t *testing.T) {
+	h, err := setup6("2001:db8:0:1::/64", "30m")
+	require.NoError(t, err)
+	_, pool, _ := net.ParseCIDR("2001:db8:0:1::/64")
+
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}
+	req, resp := newRequest(t, dhcpv6.MessageTypeSolicit, mac)
+	result, stop := h(req, resp)
+	assert.False(t, stop)
+	addr := assigned(t, result)
+	assert.True(t, pool.Contains(addr.IPv6Addr))
+	assert.Equal(t, 30*time.Minute, addr.ValidLifetime)
+	assert.Equal(t, 15*time.Minute, addr.PreferredLifetime)
+
+	// The Request following the Solicit gets the same address
+	req, resp = newRequest(t, dhcpv6.MessageTypeRequest, mac)
+	result, _ = h(req, resp)
+	assert.True(t, addr.IPv6Addr.Equal(assigned(t, result).IPv6Addr))
+
+	// Another client gets another address
+	req, resp = newRequest(t, dhcpv6.MessageTypeSolicit, net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02})
+	result, _ = h(req, resp)
+	assert.False(t, addr.IPv6Addr.Equal(assigned(t, result).IPv6Addr))
+}
+
+func TestRelease(t *testing.T) {
+	h, err := setup6("2001:db8:0:1::/64")
+	require.NoError(t, err)
+
+	mac := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}
+	req, resp := newRequest(t, dhcpv6.MessageTypeRequest, mac)
+	result, _ := h(req, resp)
+	first := assigned(t, result).IPv6Addr
+
+	req, resp = newRequest(t, dhcpv6.MessageTypeRelease, mac)
+	result, _ = h(req, resp)
+	assert.Empty(t, getIATAs(result.(*dhcpv6.Message).Options.Options), "no IA_TA in the reply to a Release")
+
+	// After a release, a new random address is picked
+	req, resp = newRequest(t, dhcpv6.MessageTypeRequest, mac)
+	result, _ = h(req, resp)
+	assert.False(t, first.Equal(assigned(t, result).IPv6Addr))
+}
+
+func TestExpired(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("2001:db8:0:1::/64")
+	p := PluginState{
+		pool:      *pool,
+		lifetime:  time.Hour,
+		bindings:  make(map[string]*binding),
+		inUse:     make(map[string]string),
+		lastSweep: time.Now(),
+	}
+	now := time.Now()
+	b, err := p.lookupOrAllocate("client", now)
+	require.NoError(t, err)
+	ip := b.IP
+
+	b, err = p.lookupOrAllocate("client", now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.False(t, ip.Equal(b.IP), "expired temporary addresses are not reused")
+	assert.Len(t, p.inUse, 1)
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"10.0.0.0/8"},
+		{"2001:db8::/124"},
+		{"2001:db8::/64", "forever"},
+	} {
+		_, err := setup6(args...)
+		assert.Error(t, err, args)
+	}
+}
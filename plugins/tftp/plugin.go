@@ -0,0 +1,138 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package tftp implements a plugin giving IP phones their TFTP servers, by
+// class (see the class package): a list of addresses in option 150 (Cisco)
+// and a server name or address in option 66 (eg. Polycom).
+//
+// The arguments are groups made of a class followed by its settings:
+// - servers=<IP>[,<IP>...]: the addresses sent in option 150
+// - name=<name or IP>: the server sent in option 66
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 10.0.0.100 10.0.0.200 1h
+//     - tftp: vendor:Cisco servers=10.0.0.10,10.0.0.11 vendor:Polycom name=tftp.example.org
+//     - nbp: tftp://10.0.0.254/pxelinux.0
+//
+// The first matching class applies. Unlike the nbp plugin, which gives its
+// TFTP server and boot file to all the clients asking for them, only the
+// clients of the classes are served: phones and PXE clients can share a
+// network. The plugin ends the chain for the phones, as nbp does for all the
+// clients: it must come last, right before nbp, so that the phones don't get
+// the boot file of the PXE clients.
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/tftp")
+
+// Plugin wraps the tftp plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "tftp",
+	Setup4: setup4,
+}
+
+// OptionTFTPServers is the TFTP server address option of Cisco (RFC5859)
+const OptionTFTPServers = dhcpv4.OptionTFTPServerAddress
+
+type group struct {
+	*class.Matcher
+	// servers holds the encoded addresses of option 150, name option 66
+	servers []byte
+	name    string
+}
+
+// Handler holds the per-class TFTP servers of a plugin instance
+type Handler struct {
+	groups []group
+}
+
+func parseArgs(args ...string) ([]group, error) {
+	var groups []group
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			m, err := class.Parse(arg)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, group{Matcher: m})
+			continue
+		}
+		if len(groups) == 0 {
+			return nil, fmt.Errorf("argument %q must follow a class", arg)
+		}
+		g := &groups[len(groups)-1]
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "servers":
+			g.servers = nil
+			for _, s := range strings.Split(value, ",") {
+				ip := net.ParseIP(s).To4()
+				if ip == nil {
+					return nil, fmt.Errorf("invalid TFTP server address %q", s)
+				}
+				g.servers = append(g.servers, ip...)
+			}
+			if len(g.servers) > 255 {
+				return nil, errors.New("too many TFTP servers for option 150")
+			}
+		case "name":
+			if value == "" || len(value) > 255 || strings.ContainsAny(value, "/ ") {
+				return nil, fmt.Errorf("invalid TFTP server name %q", value)
+			}
+			g.name = value
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("need at least a class and its TFTP servers")
+	}
+	for _, g := range groups {
+		if g.servers == nil && g.name == "" {
+			return nil, fmt.Errorf("no TFTP servers for class %s", g)
+		}
+	}
+	return groups, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	groups, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded TFTP servers for %d classes", len(groups))
+	h := Handler{groups: groups}
+	return h.Handle4, nil
+}
+
+// Handle4 handles DHCPv4 packets for the tftp plugin
+func (h *Handler) Handle4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	for _, g := range h.groups {
+		if !g.Match4(req) {
+			continue
+		}
+		if g.servers != nil {
+			resp.UpdateOption(dhcpv4.OptGeneric(OptionTFTPServers, g.servers))
+		}
+		if g.name != "" {
+			resp.UpdateOption(dhcpv4.OptTFTPServerName(g.name))
+		}
+		return resp, true
+	}
+	return resp, false
+}
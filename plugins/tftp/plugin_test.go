@@ -0,0 +1,65 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package tftp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	groups, err := parseArgs("vendor:Cisco", "servers=10.0.0.10,10.0.0.11", "vendor:Polycom", "name=tftp.example.org", "servers=10.0.0.12")
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, []byte{10, 0, 0, 10, 10, 0, 0, 11}, groups[0].servers)
+	assert.Equal(t, "", groups[0].name)
+	assert.Equal(t, "tftp.example.org", groups[1].name)
+
+	for _, args := range [][]string{
+		{},
+		{"servers=10.0.0.10"},
+		{"vendor:Cisco"},
+		{"vendor:Cisco", "servers=10.0.0.10,2001:db8::1"},
+		{"vendor:Cisco", "name=tftp://10.0.0.10/"},
+		{"vendor:Cisco", "port=69"},
+		{"color:blue", "servers=10.0.0.10"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	h4, err := setup4("vendor:Cisco", "servers=10.0.0.10,10.0.0.11", "vendor:Polycom", "name=tftp.example.org")
+	require.NoError(t, err)
+
+	handle := func(vendor string) (*dhcpv4.DHCPv4, bool) {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendor)))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		return h4(req, resp)
+	}
+
+	resp, stop := handle("Cisco Systems, Inc. IP Phone CP-8845")
+	assert.True(t, stop)
+	assert.Equal(t, []byte{10, 0, 0, 10, 10, 0, 0, 11}, resp.Options.Get(OptionTFTPServers))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionTFTPServerName))
+
+	resp, stop = handle("Polycom-VVX410")
+	assert.True(t, stop)
+	assert.Equal(t, "tftp.example.org", resp.TFTPServerName())
+	assert.Nil(t, resp.Options.Get(OptionTFTPServers))
+
+	// Left to nbp
+	resp, stop = handle("PXEClient:Arch:00007:UNDI:003016")
+	assert.False(t, stop)
+	assert.Nil(t, resp.Options.Get(OptionTFTPServers))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionTFTPServerName))
+}
@@ -0,0 +1,102 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package vendorinfo implements a plugin sending the Vendor Specific
+// Information option (43) to DHCPv4 clients, with a payload that depends on
+// their class, typically their vendor class identifier.
+//
+// The arguments are groups made of a class (see the class package for the
+// syntax) followed by the sub-options sent to the clients of the class, in
+// the same `<code>=<type>:<value>` form as for the options plugin. The first
+// matching class applies. For instance, to give the controller address to
+// Unifi access points, and a Cisco WLC to Cisco ones:
+//
+// server4:
+//   plugins:
+//     - vendorinfo: vendor:ubnt 1=ip:192.0.2.10 vendor:Cisco 241=ip-list:192.0.2.20,192.0.2.21
+//
+// The sub-options are encoded as code/length/value sequences (RFC2132 §8.4).
+package vendorinfo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/coredhcp/coredhcp/plugins/options"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/vendorinfo")
+
+// Plugin wraps the vendorinfo plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "vendorinfo",
+	Setup4: setup4,
+}
+
+// subOptionArg tells sub-options apart from classes in the arguments
+var subOptionArg = regexp.MustCompile(`^[0-9]+=`)
+
+type vendor struct {
+	*class.Matcher
+	payload []byte
+}
+
+// Handler holds the per-class payloads of a plugin instance
+type Handler struct {
+	vendors []vendor
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	if len(args) < 2 {
+		return nil, errors.New("need at least a class and a sub-option")
+	}
+	var h Handler
+	for _, arg := range args {
+		if !subOptionArg.MatchString(arg) {
+			m, err := class.Parse(arg)
+			if err != nil {
+				return nil, err
+			}
+			h.vendors = append(h.vendors, vendor{Matcher: m})
+			continue
+		}
+		if len(h.vendors) == 0 {
+			return nil, fmt.Errorf("sub-option %q must follow a class", arg)
+		}
+		code, value, err := options.Parse(arg, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > 255 {
+			return nil, fmt.Errorf("sub-option %q is longer than 255 bytes", arg)
+		}
+		v := &h.vendors[len(h.vendors)-1]
+		v.payload = append(v.payload, byte(code), byte(len(value)))
+		v.payload = append(v.payload, value...)
+	}
+	for _, v := range h.vendors {
+		if len(v.payload) == 0 {
+			return nil, fmt.Errorf("no sub-options for class %s", v)
+		}
+	}
+	log.Printf("loaded vendor specific information for %d classes", len(h.vendors))
+	return h.Handle4, nil
+}
+
+// Handle4 handles DHCPv4 packets for the vendorinfo plugin
+func (h *Handler) Handle4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	for _, v := range h.vendors {
+		if v.Match4(req) {
+			resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, v.payload))
+			break
+		}
+	}
+	return resp, false
+}
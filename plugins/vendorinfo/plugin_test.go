@@ -0,0 +1,54 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package vendorinfo
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"vendor:ubnt"},
+		{"1=ip:192.0.2.10", "vendor:ubnt"},
+		{"vendor:ubnt", "1=ip:192.0.2.10", "vendor:Cisco"},
+		{"vendor:ubnt", "1=ip:2001:db8::1"},
+		{"vendor:ubnt", "255=uint8:1"},
+		{"ubnt", "1=ip:192.0.2.10"},
+	} {
+		_, err := setup4(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	handler, err := setup4(
+		"vendor:ubnt", "1=ip:192.0.2.10",
+		"vendor:Cisco", "241=ip-list:192.0.2.20,192.0.2.21", "2=string:wlc",
+	)
+	require.NoError(t, err)
+
+	for vendor, want := range map[string][]byte{
+		"ubnt":           {1, 4, 192, 0, 2, 10},
+		"Cisco AP c2700": {241, 8, 192, 0, 2, 20, 192, 0, 2, 21, 2, 3, 'w', 'l', 'c'},
+		"MSFT 5.0":       nil,
+	} {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier(vendor)))
+		require.NoError(t, err)
+		stub, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+
+		resp, stop := handler(req, stub)
+		require.NotNil(t, resp)
+		assert.False(t, stop)
+		assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation), vendor)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package vivso implements a plugin sending vendor-identifying vendor
+// specific options, keyed by IANA enterprise number: option 125 in DHCPv4
+// (RFC3925) and option 17 in DHCPv6 (RFC8415 §21.17), eg. for CPE
+// auto-provisioning.
+//
+// The arguments are groups made of an enterprise number followed by the
+// sub-options of that enterprise, in the same `<code>=<type>:<value>` form as
+// for the options plugin:
+//
+// server4:
+//   plugins:
+//     - vivso: 3561 1=string:http://acs.example.org 4491 2=ip:192.0.2.1
+//
+// An enterprise's sub-options are only sent to the clients that identify
+// with it, by sending a vendor class (option 124 in DHCPv4, 16 in DHCPv6) or
+// vendor specific options (option 125 in DHCPv4, 17 in DHCPv6) for the same
+// enterprise number. For devices that don't, eg. some set-top boxes on
+// IPv6-only access networks, the enterprise number can be followed by
+// `:always` to send its sub-options to all the clients:
+//
+// server6:
+//   plugins:
+//     - vivso: 4491:always 32=string:docsis 2=fqdn:acs.example.org
+//
+// The sub-options of an enterprise replace those an earlier plugin set for
+// the same enterprise, keeping the ones of the other enterprises.
+package vivso
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/options"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/vivso")
+
+// Plugin wraps the vivso plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "vivso",
+	Setup6: setup6,
+	Setup4: setup4,
+}
+
+// enterpriseArg tells enterprise numbers apart from sub-options in the
+// arguments
+var enterpriseArg = regexp.MustCompile(`^([0-9]+)(:always)?$`)
+
+type enterprise struct {
+	number uint32
+	// payload holds the encoded sub-options
+	payload []byte
+	// always sends the sub-options to the clients not identifying with the
+	// enterprise
+	always bool
+}
+
+// parseArgs parses the enterprise groups. In DHCPv4 sub-options have a 1 byte
+// code and length, in DHCPv6 2 bytes
+func parseArgs(args []string, v6 bool) ([]enterprise, error) {
+	if len(args) < 2 {
+		return nil, errors.New("need at least an enterprise number and a sub-option")
+	}
+	var ents []enterprise
+	for _, arg := range args {
+		if m := enterpriseArg.FindStringSubmatch(arg); m != nil {
+			n, err := strconv.ParseUint(m[1], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid enterprise number %q: %v", arg, err)
+			}
+			ents = append(ents, enterprise{number: uint32(n), always: m[2] != ""})
+			continue
+		}
+		if len(ents) == 0 {
+			return nil, fmt.Errorf("sub-option %q must follow an enterprise number", arg)
+		}
+		code, value, err := options.Parse(arg, v6)
+		if err != nil {
+			return nil, err
+		}
+		e := &ents[len(ents)-1]
+		if v6 {
+			e.payload = append(e.payload, byte(code>>8), byte(code), byte(len(value)>>8), byte(len(value)))
+		} else {
+			e.payload = append(e.payload, byte(code), byte(len(value)))
+		}
+		e.payload = append(e.payload, value...)
+	}
+	for _, e := range ents {
+		if len(e.payload) == 0 {
+			return nil, fmt.Errorf("no sub-options for enterprise %d", e.number)
+		}
+		// DHCPv4 data-len is a single byte
+		if !v6 && len(e.payload) > 255 {
+			return nil, fmt.Errorf("sub-options of enterprise %d are longer than 255 bytes", e.number)
+		}
+	}
+	return ents, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	ents, err := parseArgs(args, false)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded vendor specific options for %d enterprises (DHCPv4)", len(ents))
+	return func(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		known := enterprises4(req)
+		sent := make(map[uint32]bool)
+		var data []byte
+		for _, e := range ents {
+			if known[e.number] || e.always {
+				data = append(data, 0, 0, 0, 0, byte(len(e.payload)))
+				binary.BigEndian.PutUint32(data[len(data)-5:], e.number)
+				data = append(data, e.payload...)
+				sent[e.number] = true
+			}
+		}
+		if data == nil {
+			return resp, false
+		}
+		// Keep the other enterprises of an earlier plugin
+		var earlier []byte
+		for _, d := range vivso4(resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific)) {
+			if !sent[binary.BigEndian.Uint32(d)] {
+				earlier = append(earlier, d...)
+			}
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, append(earlier, data...)))
+		return resp, false
+	}, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	ents, err := parseArgs(args, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded vendor specific options for %d enterprises (DHCPv6)", len(ents))
+	return func(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		msg, err := req.GetInnerMessage()
+		if err != nil {
+			log.Errorf("Could not decapsulate relayed message, aborting: %v", err)
+			return nil, true
+		}
+		reply, err := resp.GetInnerMessage()
+		if err != nil {
+			log.Errorf("Could not decapsulate response, aborting: %v", err)
+			return nil, true
+		}
+		known := enterprises6(msg)
+		for _, e := range ents {
+			if known[e.number] || e.always {
+				removeVendorOpts6(reply, e.number)
+				data := make([]byte, 4, 4+len(e.payload))
+				binary.BigEndian.PutUint32(data, e.number)
+				reply.AddOption(&dhcpv6.OptionGeneric{
+					OptionCode: dhcpv6.OptionVendorOpts,
+					OptionData: append(data, e.payload...),
+				})
+			}
+		}
+		return resp, false
+	}, nil
+}
+
+// enterprises4 returns the enterprise numbers a DHCPv4 client identifies
+// with, in options 124 and 125
+func enterprises4(req *dhcpv4.DHCPv4) map[uint32]bool {
+	known := make(map[uint32]bool)
+	for _, id := range req.VIVC() {
+		known[id.EntID] = true
+	}
+	for _, d := range vivso4(req.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific)) {
+		known[binary.BigEndian.Uint32(d)] = true
+	}
+	return known
+}
+
+// vivso4 splits the data of option 125, a list of enterprise-number(4)
+// data-len(1) data, by enterprise. A truncated last one is left out
+func vivso4(vivso []byte) [][]byte {
+	var ents [][]byte
+	for len(vivso) >= 5 {
+		n := 5 + int(vivso[4])
+		if n > len(vivso) {
+			break
+		}
+		ents = append(ents, vivso[:n])
+		vivso = vivso[n:]
+	}
+	return ents
+}
+
+// enterprises6 returns the enterprise numbers a DHCPv6 client identifies
+// with, in options 16 and 17
+func enterprises6(msg *dhcpv6.Message) map[uint32]bool {
+	known := make(map[uint32]bool)
+	for _, opt := range msg.Options.Get(dhcpv6.OptionVendorClass) {
+		if vc, ok := opt.(*dhcpv6.OptVendorClass); ok {
+			known[vc.EnterpriseNumber] = true
+		}
+	}
+	for _, vo := range msg.Options.VendorOpts() {
+		known[vo.EnterpriseNumber] = true
+	}
+	return known
+}
+
+// removeVendorOpts6 removes the option 17 of an enterprise from a response
+func removeVendorOpts6(msg *dhcpv6.Message, number uint32) {
+	opts := msg.Options.Options[:0]
+	for _, opt := range msg.Options.Options {
+		if opt.Code() == dhcpv6.OptionVendorOpts {
+			if b := opt.ToBytes(); len(b) >= 4 && binary.BigEndian.Uint32(b) == number {
+				continue
+			}
+		}
+		opts = append(opts, opt)
+	}
+	msg.Options.Options = opts
+}
@@ -0,0 +1,146 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package vivso
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	ents, err := parseArgs([]string{"3561", "1=string:acs", "4491", "2=uint8:7"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []enterprise{
+		{number: 3561, payload: []byte{1, 3, 'a', 'c', 's'}},
+		{number: 4491, payload: []byte{2, 1, 7}},
+	}, ents)
+
+	ents, err = parseArgs([]string{"3561", "1=string:acs"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 3, 'a', 'c', 's'}, ents[0].payload)
+
+	ents, err = parseArgs([]string{"4491:always", "32=string:docsis"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4491), ents[0].number)
+	assert.True(t, ents[0].always)
+
+	for _, args := range [][]string{
+		{},
+		{"3561"},
+		{"1=string:acs", "3561"},
+		{"3561", "1=string:acs", "4491"},
+		{"4294967296", "1=string:acs"},
+		{"3561", "1=ip:2001:db8::1"},
+		{"3561:sometimes", "1=string:acs"},
+	} {
+		_, err := parseArgs(args, false)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	handler, err := setup4("3561", "1=string:acs", "4491", "2=uint8:7", "9", "1=bool:true")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		dhcpv4.WithOption(dhcpv4.OptVIVC(dhcpv4.VIVCIdentifier{EntID: 3561, Data: []byte("\x03cpe")})),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific,
+			[]byte{0, 0, 0, 9, 3, 1, 1, 0})),
+	)
+	require.NoError(t, err)
+	stub, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp, stop := handler(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+	assert.Equal(t, []byte{
+		0, 0, 0x0d, 0xe9, 5, 1, 3, 'a', 'c', 's',
+		0, 0, 0, 9, 3, 1, 1, 1,
+	}, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+
+	req, err = dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	require.NoError(t, err)
+	stub, err = dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, _ = handler(req, stub)
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+}
+
+func TestHandler6(t *testing.T) {
+	handler, err := setup6("4491", "32=string:docsis", "3561", "1=string:acs")
+	require.NoError(t, err)
+
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 4491, Data: [][]byte{[]byte("docsis3.0")}})
+
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+
+	resp, stop := handler(req, stub)
+	require.NotNil(t, resp)
+	assert.False(t, stop)
+
+	// Parse the response as a client would
+	msg, err := dhcpv6.FromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	vendorOpts := msg.(*dhcpv6.Message).Options.VendorOpts()
+	require.Len(t, vendorOpts, 1)
+	assert.Equal(t, uint32(4491), vendorOpts[0].EnterpriseNumber)
+	opt := vendorOpts[0].VendorOpts.GetOne(32)
+	require.NotNil(t, opt)
+	assert.Equal(t, []byte("docsis"), opt.ToBytes())
+}
+
+func TestAlways4(t *testing.T) {
+	handler, err := setup4("3561:always", "1=string:acs")
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	require.NoError(t, err)
+	// Set by an earlier plugin, for enterprises 9 and 3561
+	stub, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific,
+			[]byte{0, 0, 0, 9, 3, 1, 1, 0, 0, 0, 0x0d, 0xe9, 3, 2, 1, 0})),
+	)
+	require.NoError(t, err)
+	resp, _ := handler(req, stub)
+	assert.Equal(t, []byte{
+		0, 0, 0, 9, 3, 1, 1, 0,
+		0, 0, 0x0d, 0xe9, 5, 1, 3, 'a', 'c', 's',
+	}, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+}
+
+func TestAlways6(t *testing.T) {
+	handler, err := setup6("4491:always", "32=string:docsis")
+	require.NoError(t, err)
+
+	// A set-top box, not identifying with the enterprise
+	req, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	stub, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	stub.MessageType = dhcpv6.MessageTypeReply
+	stub.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionVendorOpts, OptionData: []byte{0, 0, 0x11, 0x8b, 0, 1, 0, 0}})
+	stub.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionVendorOpts, OptionData: []byte{0, 0, 0, 9, 0, 1, 0, 0}})
+
+	resp, _ := handler(req, stub)
+	msg, err := dhcpv6.FromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	vendorOpts := msg.(*dhcpv6.Message).Options.VendorOpts()
+	require.Len(t, vendorOpts, 2)
+	assert.Equal(t, uint32(9), vendorOpts[0].EnterpriseNumber)
+	assert.Equal(t, uint32(4491), vendorOpts[1].EnterpriseNumber)
+	assert.NotNil(t, vendorOpts[1].VendorOpts.GetOne(32))
+}
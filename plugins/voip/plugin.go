@@ -0,0 +1,234 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package voip implements a plugin giving IP phones the URL of their
+// provisioning server, in the option their vendor expects, the phones being
+// told apart by class (see the class package).
+//
+// The arguments are groups made of a vendor and the provisioning URL of its
+// phones, followed by optional settings:
+// - class=<class>: the class of the phones, the vendor class of the vendor
+// by default
+// - option=<code>: the DHCPv4 option holding the URL, the one of the vendor
+// by default
+// - enterprise=<number>:<sub-option>: send the URL in a sub-option of the
+// vendor-identifying vendor specific option (125, RFC3925) of an IANA
+// enterprise number instead
+//
+// The vendors, their default class and option are:
+// - cisco: vendor:Cisco, option 160
+// - grandstream: vendor:Grandstream, option 66
+// - polycom: vendor:Polycom, option 160
+// - snom: vendor:snom, option 66
+// - yealink: vendor:yealink, option 66
+// - other: no default, the class and the option or enterprise must be given
+//
+// server4:
+//   plugins:
+//     - sip: sip.example.org
+//     - voip: polycom https://prov.example.org/polycom yealink https://prov.example.org/yealink other https://prov.example.org/acme class=enterprise:32473 enterprise=32473:1
+//
+// The first group matching a phone applies. Sub-options of option 125 are
+// added to those set by the previous plugins, eg. vivso.
+package voip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/class"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+var log = logger.GetLogger("plugins/voip")
+
+// Plugin wraps the voip plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "voip",
+	Setup4: setup4,
+}
+
+// preset holds the defaults of a vendor
+type preset struct {
+	class  string
+	option uint8
+}
+
+// vendors are the documented provisioning options of the vendors
+var vendors = map[string]preset{
+	"cisco":       {class: "vendor:Cisco", option: 160},
+	"grandstream": {class: "vendor:Grandstream", option: 66},
+	"polycom":     {class: "vendor:Polycom", option: 160},
+	"snom":        {class: "vendor:snom", option: 66},
+	"yealink":     {class: "vendor:yealink", option: 66},
+	"other":       {},
+}
+
+// profile is how the phones of a class are provisioned
+type profile struct {
+	vendor string
+	url    string
+	class  *class.Matcher
+	option uint8
+	// enterprise and subOption are set to send the URL in option 125
+	enterprise uint32
+	subOption  uint8
+}
+
+// Handler holds the profiles of a plugin instance
+type Handler struct {
+	profiles []profile
+}
+
+func parseArgs(args ...string) ([]profile, error) {
+	var (
+		profiles []profile
+		classes  []string
+	)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if p, ok := vendors[arg]; ok {
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("need the provisioning URL of %s", arg)
+			}
+			i++
+			// Catch a missing URL, followed by a setting
+			if len(args[i]) > 255 || strings.IndexByte(args[i], '=') >= 0 && !strings.Contains(args[i], "://") {
+				return nil, fmt.Errorf("invalid provisioning URL %q of %s", args[i], arg)
+			}
+			profiles = append(profiles, profile{vendor: arg, url: args[i], option: p.option})
+			classes = append(classes, p.class)
+			continue
+		}
+		if len(profiles) == 0 {
+			return nil, fmt.Errorf("argument %q must follow a vendor, one of cisco, grandstream, polycom, snom, yealink or other", arg)
+		}
+		p := &profiles[len(profiles)-1]
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "class":
+			classes[len(classes)-1] = value
+		case "option":
+			code, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || code == 0 || code == 255 {
+				return nil, fmt.Errorf("invalid option %q", value)
+			}
+			p.option, p.enterprise = uint8(code), 0
+		case "enterprise":
+			parts := strings.SplitN(value, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid enterprise %q, expected <number>:<sub-option>", value)
+			}
+			number, err := strconv.ParseUint(parts[0], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid enterprise number %q", parts[0])
+			}
+			sub, err := strconv.ParseUint(parts[1], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sub-option %q", parts[1])
+			}
+			p.enterprise, p.subOption, p.option = uint32(number), uint8(sub), 0
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil, errors.New("need at least a vendor and its provisioning URL")
+	}
+	for i := range profiles {
+		p := &profiles[i]
+		if classes[i] == "" {
+			return nil, fmt.Errorf("need the class of the %s phones", p.vendor)
+		}
+		m, err := class.Parse(classes[i])
+		if err != nil {
+			return nil, err
+		}
+		p.class = m
+		if p.option == 0 && p.enterprise == 0 {
+			return nil, fmt.Errorf("need the option or the enterprise of the %s phones", p.vendor)
+		}
+		if p.enterprise != 0 && len(p.url) > 253 {
+			return nil, fmt.Errorf("provisioning URL %q is too long for option 125", p.url)
+		}
+	}
+	return profiles, nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	profiles, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("loaded the provisioning of %d classes of phones", len(profiles))
+	h := Handler{profiles: profiles}
+	return h.Handle4, nil
+}
+
+// Handle4 handles DHCPv4 packets for the voip plugin
+func (h *Handler) Handle4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	for _, p := range h.profiles {
+		if !p.class.Match4(req) {
+			continue
+		}
+		if p.enterprise == 0 {
+			resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(p.option), []byte(p.url)))
+			break
+		}
+		data, err := addSubOption(resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific), p.enterprise, p.subOption, []byte(p.url))
+		if err != nil {
+			log.Warningf("could not send the provisioning URL of %s: %v", p.vendor, err)
+			break
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorSpecific, data))
+		break
+	}
+	return resp, false
+}
+
+// addSubOption adds a sub-option to the data of an enterprise in option 125,
+// a list of enterprise-number(4) data-len(1) data
+func addSubOption(vivso []byte, enterprise uint32, code uint8, value []byte) ([]byte, error) {
+	var out []byte
+	added := false
+	for len(vivso) > 0 {
+		if len(vivso) < 5 || len(vivso) < 5+int(vivso[4]) {
+			return nil, errors.New("malformed option 125")
+		}
+		n := 5 + int(vivso[4])
+		block := append([]byte{}, vivso[:n]...)
+		if binary.BigEndian.Uint32(block) == enterprise && !added {
+			if int(block[4])+2+len(value) > 255 {
+				return nil, fmt.Errorf("no room in option 125 for enterprise %d", enterprise)
+			}
+			block = append(block, code, byte(len(value)))
+			block = append(block, value...)
+			block[4] = byte(len(block) - 5)
+			added = true
+		}
+		out = append(out, block...)
+		vivso = vivso[n:]
+	}
+	if !added {
+		block := make([]byte, 5, 7+len(value))
+		binary.BigEndian.PutUint32(block, enterprise)
+		block[4] = byte(2 + len(value))
+		block = append(block, code, byte(len(value)))
+		out = append(out, append(block, value...)...)
+	}
+	if len(out) > 255 {
+		return nil, errors.New("option 125 is longer than 255 bytes")
+	}
+	return out, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package voip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func handle(t *testing.T, h handler.Handler4, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0, 1, 2, 3, 4, 5}, modifiers...)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	resp, stop := h(req, resp)
+	assert.False(t, stop)
+	return resp
+}
+
+func TestParseArgs(t *testing.T) {
+	profiles, err := parseArgs("polycom", "https://prov.example.org/polycom", "yealink", "tftp://192.0.2.1", "class=vendor:SIP-T", "option=43")
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	assert.Equal(t, uint8(160), profiles[0].option)
+	assert.Equal(t, "vendor:Polycom", profiles[0].class.String())
+	assert.Equal(t, uint8(43), profiles[1].option)
+	assert.Equal(t, "vendor:SIP-T", profiles[1].class.String())
+
+	profiles, err = parseArgs("cisco", "https://prov.example.org/cisco", "enterprise=9:1")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(9), profiles[0].enterprise)
+	assert.Equal(t, uint8(1), profiles[0].subOption)
+	assert.Zero(t, profiles[0].option)
+
+	for _, args := range [][]string{
+		{},
+		{"polycom"},
+		{"polycom", "class=vendor:x"},
+		{"class=vendor:x"},
+		{"other", "https://prov.example.org"},
+		{"other", "https://prov.example.org", "class=vendor:acme"},
+		{"yealink", "https://prov.example.org", "option=256"},
+		{"yealink", "https://prov.example.org", "enterprise=9"},
+		{"yealink", "https://prov.example.org", "class=color:blue"},
+		{"yealink", "https://prov.example.org", "ttl=1"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	h4, err := setup4(
+		"polycom", "https://prov.example.org/polycom",
+		"other", "https://prov.example.org/acme", "class=enterprise:32473", "enterprise=32473:2",
+	)
+	require.NoError(t, err)
+
+	resp := handle(t, h4, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("Polycom-VVX410")))
+	assert.Equal(t, []byte("https://prov.example.org/polycom"), resp.Options.Get(dhcpv4.GenericOptionCode(160)))
+
+	resp = handle(t, h4, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("yealink")))
+	assert.Nil(t, resp.Options.Get(dhcpv4.GenericOptionCode(160)))
+	assert.Nil(t, resp.Options.Get(dhcpv4.GenericOptionCode(66)))
+
+	acme := dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorClass, []byte{0, 0, 0x7e, 0xd9, 5, 4, 'a', 'c', 'm', 'e'})
+	resp = handle(t, h4, dhcpv4.WithOption(acme))
+	url := "https://prov.example.org/acme"
+	want := append([]byte{0, 0, 0x7e, 0xd9, byte(2 + len(url)), 2, byte(len(url))}, url...)
+	assert.Equal(t, want, resp.Options.Get(dhcpv4.OptionVendorIdentifyingVendorSpecific))
+}
+
+func TestAddSubOption(t *testing.T) {
+	// Added to the data of the enterprise, or in a new block
+	vivso := []byte{0, 0, 0, 9, 3, 1, 1, 'x'}
+	got, err := addSubOption(vivso, 9, 2, []byte("ab"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 9, 7, 1, 1, 'x', 2, 2, 'a', 'b'}, got)
+	got, err = addSubOption(vivso, 10, 2, []byte("ab"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 9, 3, 1, 1, 'x', 0, 0, 0, 10, 4, 2, 2, 'a', 'b'}, got)
+
+	_, err = addSubOption([]byte{0, 0, 0, 9, 3, 1}, 9, 2, []byte("ab"))
+	assert.Error(t, err)
+	_, err = addSubOption(nil, 9, 2, make([]byte, 250))
+	assert.Error(t, err)
+}
@@ -0,0 +1,522 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build go1.18
+
+// Package wasm implements a plugin running handlers compiled to WebAssembly,
+// eg. from Rust, C or TinyGo, in a sandbox: the modules only see the requests
+// they are given, without access to the files or the network.
+//
+// Modules are WASI (preview 1) modules, run with their arguments but no
+// environment nor filesystem, their standard error going to the standard
+// error of the server. They export:
+// - memory: their memory
+// - coredhcp_alloc(size i32) i32: allocates size bytes, returning their
+// address
+// - coredhcp_handle4(ptr, len i32) i64 and/or coredhcp_handle6(ptr, len i32)
+// i64: handle a request, given the request and the response encoded as on the
+// wire, each prefixed with its length (32 bits, little endian). They return
+// the address of their output in the upper 32 bits and its length in the
+// lower ones: a byte of flags (1 to stop the chain, 2 to drop the request),
+// then the response to pass on if it changed. A zero length leaves the
+// response as it is and goes on with the chain
+// - coredhcp_setup(version i32) i32 (optional): called once per instance with
+// the IP version, after _initialize if the module exports it. It fails the
+// setup when returning non zero
+//
+// They can import from the "coredhcp" module:
+// - metadata_get(key_ptr, key_len, buf_ptr, buf_len i32) i32: copies the value
+// of a string metadata of the request (see handler.Metadata) to the buffer if
+// it fits, returning its length, or -1 when not set
+// - metadata_set(key_ptr, key_len, value_ptr, value_len i32): sets a string
+// metadata of the request
+// - log(level, ptr, len i32): logs a message, at level 0 (debug), 1 (info), 2
+// (warning) or 3 (error)
+//
+// Handlers are never run concurrently in an instance of a module, which can
+// reuse its buffers from a request to the next.
+//
+// Arguments are the settings of the plugin, then the path of the module, then
+// its arguments:
+// - instances=<n>: how many instances of the module handle requests
+// concurrently, 1 by default
+// - timeout=<duration>: how long the module can take to handle a request, 100ms
+// by default. The instance is then discarded, as when it traps
+// - memory=<MiB>: the memory limit of each instance, 16 by default
+// - on-error=<continue|drop>: what to do when the module fails to handle a
+// request: pass the response as it is to the next plugins (by default), or
+// drop the request
+// - reload=<duration>: how often to check whether the module changed, to load
+// it again, 10s by default, 0 to never reload it. The module in use is kept if
+// the new one cannot be loaded
+//
+// server4:
+//   plugins:
+//     - wasm: instances=4 /usr/lib/coredhcp/classify.wasm --vendor=acme
+//
+// The runtime (wazero) needs Go 1.18, so the plugin is not one of the core
+// plugins: build coredhcp with it with coredhcp-generator, eg.
+// `coredhcp-generator -from core-plugins.txt github.com/coredhcp/coredhcp/plugins/wasm`
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+var log = logger.GetLogger("plugins/wasm")
+
+// Plugin wraps the wasm plugin information.
+var Plugin = plugins.Plugin{
+	Name:      "wasm",
+	Setup6Ctx: setup6,
+	Setup4Ctx: setup4,
+	Start:     start,
+}
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// Output flags of the handlers
+const (
+	flagStop = 1 << iota
+	flagDrop
+)
+
+// pageSize is the size of a page of WebAssembly memory
+const pageSize = 64 << 10
+
+// PluginState holds the configuration of an instance of the plugin, and the
+// module it runs
+type PluginState struct {
+	version   int
+	path      string
+	args      []string
+	instances int
+	timeout   time.Duration
+	memory    uint32
+	drop      bool
+	reload    time.Duration
+
+	// mu is held for reading while handling requests, and for writing to
+	// replace the module
+	mu     sync.RWMutex
+	module *module
+}
+
+// module is a loaded module, with its instances
+type module struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	modTime  time.Time
+	// pool holds the idle instances, nil for those discarded
+	pool chan api.Module
+}
+
+func parseArgs(version int, args ...string) (*PluginState, error) {
+	p := PluginState{
+		version:   version,
+		instances: 1,
+		timeout:   100 * time.Millisecond,
+		memory:    16,
+		reload:    10 * time.Second,
+	}
+	for i, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			// The module, and its arguments
+			p.path, p.args = arg, args[i+1:]
+			break
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "instances":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid instances %q", value)
+			}
+			p.instances = n
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			p.timeout = timeout
+		case "memory":
+			mib, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || mib == 0 {
+				return nil, fmt.Errorf("invalid memory %q", value)
+			}
+			p.memory = uint32(mib)
+		case "on-error":
+			switch value {
+			case "continue":
+				p.drop = false
+			case "drop":
+				p.drop = true
+			default:
+				return nil, fmt.Errorf("invalid on-error %q, expected continue or drop", value)
+			}
+		case "reload":
+			reload, err := time.ParseDuration(value)
+			if err != nil || reload < 0 {
+				return nil, fmt.Errorf("invalid reload %q", value)
+			}
+			p.reload = reload
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if p.path == "" {
+		return nil, errors.New("need the path of the module to run")
+	}
+	return &p, nil
+}
+
+func (p *PluginState) handlerName() string {
+	return "coredhcp_handle" + strconv.Itoa(p.version)
+}
+
+// load compiles the module and instantiates it
+func (p *PluginState) load() (*module, error) {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	code, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	m := module{
+		runtime: wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(p.memory<<20/pageSize)),
+		modTime: fi.ModTime(),
+		pool:    make(chan api.Module, p.instances),
+	}
+	if err := m.setup(ctx, p, code); err != nil {
+		m.runtime.Close(ctx)
+		return nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+	return &m, nil
+}
+
+func (m *module) setup(ctx context.Context, p *PluginState, code []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, m.runtime); err != nil {
+		return err
+	}
+	if _, err := hostModule(m.runtime).Instantiate(ctx); err != nil {
+		return err
+	}
+	var err error
+	if m.compiled, err = m.runtime.CompileModule(ctx, code); err != nil {
+		return err
+	}
+	exports := m.compiled.ExportedFunctions()
+	for _, name := range []string{"coredhcp_alloc", p.handlerName()} {
+		if _, ok := exports[name]; !ok {
+			return fmt.Errorf("the module does not export %s", name)
+		}
+	}
+	// Instantiate them all now, to fail early
+	for i := 0; i < p.instances; i++ {
+		inst, err := m.instantiate(ctx, p)
+		if err != nil {
+			return err
+		}
+		m.pool <- inst
+	}
+	return nil
+}
+
+func (m *module) instantiate(ctx context.Context, p *PluginState) (api.Module, error) {
+	inst, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(append([]string{p.path}, p.args...)...).
+		WithStderr(os.Stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader).
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+	if setup := inst.ExportedFunction("coredhcp_setup"); setup != nil {
+		ret, err := setup.Call(ctx, uint64(p.version))
+		if err == nil && len(ret) == 1 && uint32(ret[0]) != 0 {
+			err = fmt.Errorf("coredhcp_setup failed with %d", int32(ret[0]))
+		}
+		if err != nil {
+			inst.Close(ctx)
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// close closes the module, once its instances are idle
+func (m *module) close() {
+	m.runtime.Close(context.Background())
+}
+
+// hostModule defines the functions the modules can import
+func hostModule(r wazero.Runtime) wazero.HostModuleBuilder {
+	read := func(m api.Module, ptr, length uint32) string {
+		b, _ := m.Memory().Read(ptr, length)
+		return string(b)
+	}
+	return r.NewHostModuleBuilder("coredhcp").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, keyPtr, keyLen, bufPtr, bufLen uint32) int32 {
+			v, ok := handler.MetadataFromContext(ctx).Get(read(m, keyPtr, keyLen))
+			s, isString := v.(string)
+			if !ok || !isString {
+				return -1
+			}
+			if len(s) <= int(bufLen) {
+				m.Memory().WriteString(bufPtr, s)
+			}
+			return int32(len(s))
+		}).
+		Export("metadata_get").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, keyPtr, keyLen, valuePtr, valueLen uint32) {
+			handler.MetadataFromContext(ctx).Set(read(m, keyPtr, keyLen), read(m, valuePtr, valueLen))
+		}).
+		Export("metadata_set").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, level, ptr, length uint32) {
+			msg := read(m, ptr, length)
+			switch level {
+			case 0:
+				log.Debug(msg)
+			case 1:
+				log.Info(msg)
+			case 2:
+				log.Warning(msg)
+			default:
+				log.Error(msg)
+			}
+		}).
+		Export("log")
+}
+
+// call passes a request to an instance of the module, returning its output
+func (p *PluginState) call(ctx context.Context, inst api.Module, req, resp []byte) ([]byte, error) {
+	in := make([]byte, 8+len(req)+len(resp))
+	binary.LittleEndian.PutUint32(in, uint32(len(req)))
+	copy(in[4:], req)
+	binary.LittleEndian.PutUint32(in[4+len(req):], uint32(len(resp)))
+	copy(in[8+len(req):], resp)
+	ret, err := inst.ExportedFunction("coredhcp_alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(ret[0])
+	if !inst.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("coredhcp_alloc returned an invalid address %#x", ptr)
+	}
+	if ret, err = inst.ExportedFunction(p.handlerName()).Call(ctx, uint64(ptr), uint64(len(in))); err != nil {
+		return nil, err
+	}
+	ptr, length := uint32(ret[0]>>32), uint32(ret[0])
+	out, ok := inst.Memory().Read(ptr, length)
+	if !ok {
+		return nil, fmt.Errorf("%s returned an invalid output at %#x", p.handlerName(), ptr)
+	}
+	// The memory of the instance is reused by the next request
+	return append([]byte(nil), out...), nil
+}
+
+// handle passes a request to the module, returning its output
+func (p *PluginState) handle(ctx context.Context, req, resp []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	m := p.module
+	var inst api.Module
+	select {
+	case inst = <-m.pool:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no instance of %s available: %w", p.path, ctx.Err())
+	}
+	if inst == nil {
+		// Replace a discarded instance
+		var err error
+		if inst, err = m.instantiate(context.Background(), p); err != nil {
+			m.pool <- nil
+			return nil, fmt.Errorf("%s: %w", p.path, err)
+		}
+	}
+	out, err := p.call(ctx, inst, req, resp)
+	if err != nil {
+		// The instance may be in any state, do not reuse it
+		inst.Close(context.Background())
+		m.pool <- nil
+		return nil, fmt.Errorf("%s: %w", p.path, err)
+	}
+	m.pool <- inst
+	return out, nil
+}
+
+// output returns what to do with the output of a handler: the response
+// encoded, if it changed, and whether to stop the chain or drop the request
+func output(out []byte) (resp []byte, stop, drop bool) {
+	if len(out) == 0 {
+		return nil, false, false
+	}
+	return out[1:], out[0]&flagStop != 0, out[0]&flagDrop != 0
+}
+
+// Handler6 handles DHCPv6 packets for the wasm plugin
+func (p *PluginState) Handler6(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	out, err := p.handle(ctx, req.ToBytes(), resp.ToBytes())
+	data, stop, drop := output(out)
+	if err == nil && drop {
+		return nil, true
+	}
+	d := resp
+	if err == nil && len(data) > 0 {
+		d, err = dhcpv6.FromBytes(data)
+	}
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	return d, stop
+}
+
+// Handler4 handles DHCPv4 packets for the wasm plugin
+func (p *PluginState) Handler4(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	out, err := p.handle(ctx, req.ToBytes(), resp.ToBytes())
+	data, stop, drop := output(out)
+	if err == nil && drop {
+		return nil, true
+	}
+	d := resp
+	if err == nil && len(data) > 0 {
+		d, err = dhcpv4.FromBytes(data)
+	}
+	if err != nil {
+		log.Errorf("Cannot handle the request: %v", err)
+		handler.ReportError(ctx, err)
+		if p.drop {
+			return nil, true
+		}
+		return resp, false
+	}
+	return d, stop
+}
+
+// start starts watching the modules of the instances for changes
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		if p.reload > 0 {
+			go p.watch(ctx)
+		}
+	}
+	return nil
+}
+
+// watch reloads the module when it changes, until ctx is done
+func (p *PluginState) watch(ctx context.Context) {
+	ticker := time.NewTicker(p.reload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkReload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkReload loads the module again if it changed
+func (p *PluginState) checkReload() {
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		log.Warningf("Cannot check for changes of %s: %v", p.path, err)
+		return
+	}
+	p.mu.RLock()
+	old := p.module
+	p.mu.RUnlock()
+	if fi.ModTime().Equal(old.modTime) {
+		return
+	}
+	m, err := p.load()
+	if err != nil {
+		log.Errorf("Cannot reload %s, keeping the module in use: %v", p.path, err)
+		// Wait for the next change to try again
+		old.modTime = fi.ModTime()
+		return
+	}
+	p.mu.Lock()
+	p.module = m
+	p.mu.Unlock()
+	old.close()
+	log.Printf("DHCPv%d: reloaded %s", p.version, p.path)
+}
+
+func setup(version int, args ...string) (*PluginState, error) {
+	p, err := parseArgs(version, args...)
+	if err != nil {
+		return nil, err
+	}
+	if p.module, err = p.load(); err != nil {
+		return nil, err
+	}
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("DHCPv%d: running %s", version, p.path)
+	return p, nil
+}
+
+func setup6(args ...string) (handler.Handler6Ctx, error) {
+	p, err := setup(6, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+func setup4(args ...string) (handler.Handler4Ctx, error) {
+	p, err := setup(4, args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
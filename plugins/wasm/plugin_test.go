@@ -0,0 +1,202 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build go1.18
+
+package wasm
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// section encodes a section of a WebAssembly module
+func section(id byte, contents ...byte) []byte {
+	s := []byte{id}
+	for n := len(contents); ; n >>= 7 {
+		if n < 0x80 {
+			s = append(s, byte(n))
+			break
+		}
+		s = append(s, byte(n)|0x80)
+	}
+	return append(s, contents...)
+}
+
+// testModule returns a module whose coredhcp_handle4 sets the boot file name
+// to the given character, sets the "test.file" metadata to "x" and stops the
+// chain, and whose coredhcp_handle6 never returns
+func testModule(c byte) []byte {
+	handle4 := []byte{
+		0x01, 0x02, 0x7f, // locals: resp, resp_len
+		// resp = ptr + 4 + req_len + 4
+		0x20, 0x00, 0x20, 0x00, 0x28, 0x00, 0x00, 0x6a, 0x41, 0x08, 0x6a, 0x21, 0x02,
+		// resp_len = resp[-4:]
+		0x20, 0x02, 0x41, 0x04, 0x6b, 0x28, 0x00, 0x00, 0x21, 0x03,
+		// resp[-1] = flagStop, the output being written in place
+		0x20, 0x02, 0x41, 0x01, 0x6b, 0x41, 0x01, 0x3a, 0x00, 0x00,
+		// resp[108] (the first byte of the boot file name) = c, a letter
+		0x20, 0x02, 0x41, c | 0x80, 0x00, 0x3a, 0x00, 0x6c,
+		// metadata_set("test.file", "x")
+		0x41, 0x00, 0x41, 0x09, 0x41, 0x10, 0x41, 0x01, 0x10, 0x00,
+		// return (resp - 1) << 32 | (resp_len + 1)
+		0x20, 0x02, 0x41, 0x01, 0x6b, 0xad, 0x42, 0x20, 0x86,
+		0x20, 0x03, 0x41, 0x01, 0x6a, 0xad, 0x84,
+		0x0b,
+	}
+	var code []byte
+	code = append(code, 0x03)
+	// coredhcp_alloc: a bump allocator
+	code = append(code, 0x0b, 0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b)
+	code = append(code, byte(len(handle4)))
+	code = append(code, handle4...)
+	// coredhcp_handle6: loop forever
+	code = append(code, 0x08, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b)
+
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	m = append(m, section(1, 0x03,
+		0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x00, // (i32, i32, i32, i32)
+		0x60, 0x01, 0x7f, 0x01, 0x7f, // (i32) i32
+		0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, // (i32, i32) i64
+	)...)
+	imp := []byte{0x01, 0x08}
+	imp = append(imp, "coredhcp"...)
+	imp = append(imp, 0x0c)
+	imp = append(imp, "metadata_set"...)
+	imp = append(imp, 0x00, 0x00)
+	m = append(m, section(2, imp...)...)
+	m = append(m, section(3, 0x03, 0x01, 0x02, 0x02)...)
+	m = append(m, section(5, 0x01, 0x00, 0x01)...)
+	// The heap starts at 1024
+	m = append(m, section(6, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b)...)
+	exp := []byte{0x04}
+	for i, name := range []string{"memory", "coredhcp_alloc", "coredhcp_handle4", "coredhcp_handle6"} {
+		exp = append(exp, byte(len(name)))
+		exp = append(exp, name...)
+		if i == 0 {
+			exp = append(exp, 0x02, 0x00)
+		} else {
+			exp = append(exp, 0x00, byte(i))
+		}
+	}
+	m = append(m, section(7, exp...)...)
+	m = append(m, section(10, code...)...)
+	data := []byte{0x01, 0x00, 0x41, 0x00, 0x0b, 0x11}
+	data = append(data, "test.file\x00\x00\x00\x00\x00\x00\x00x"...)
+	return append(m, section(11, data...)...)
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs(4, "instances=4", "memory=1", "reload=0", "/usr/lib/coredhcp/plugin.wasm", "timeout=2s", "arg")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/lib/coredhcp/plugin.wasm", p.path)
+	assert.Equal(t, []string{"timeout=2s", "arg"}, p.args)
+	assert.Equal(t, 4, p.instances)
+	assert.Equal(t, uint32(1), p.memory)
+	assert.Equal(t, time.Duration(0), p.reload)
+
+	for _, args := range [][]string{
+		{},
+		{"timeout=1s"},
+		{"instances=0", "plugin.wasm"},
+		{"memory=-1", "plugin.wasm"},
+		{"on-error=retry", "plugin.wasm"},
+		{"retries=1", "plugin.wasm"},
+	} {
+		_, err := parseArgs(4, args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func writeModule(t *testing.T, path string, code []byte, modTime time.Time) {
+	require.NoError(t, ioutil.WriteFile(path, code, 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestWasm4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-wasm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.wasm")
+	writeModule(t, path, testModule('a'), time.Now().Add(-time.Hour))
+
+	p, err := setup(4, "instances=2", "reload=0", path)
+	require.NoError(t, err)
+	handle := func() (*dhcpv4.DHCPv4, bool, *handler.Metadata) {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		ctx := handler.NewContext(context.Background())
+		resp, stop := p.Handler4(ctx, req, resp)
+		return resp, stop, handler.MetadataFromContext(ctx)
+	}
+
+	resp, stop, md := handle()
+	require.NotNil(t, resp)
+	assert.True(t, stop)
+	assert.Equal(t, "a", resp.BootFileName)
+	v, _ := md.Get("test.file")
+	assert.Equal(t, "x", v)
+
+	// Unchanged
+	p.checkReload()
+	resp, _, _ = handle()
+	assert.Equal(t, "a", resp.BootFileName)
+
+	// Reloaded
+	writeModule(t, path, testModule('b'), time.Now())
+	p.checkReload()
+	resp, _, _ = handle()
+	assert.Equal(t, "b", resp.BootFileName)
+
+	// Not loaded, the module in use being kept
+	writeModule(t, path, []byte("invalid"), time.Now().Add(time.Hour))
+	p.checkReload()
+	resp, _, _ = handle()
+	assert.Equal(t, "b", resp.BootFileName)
+
+	_, err = setup(4, "reload=0", filepath.Join(dir, "missing.wasm"))
+	assert.Error(t, err)
+}
+
+func TestWasm6Timeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-wasm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.wasm")
+	writeModule(t, path, testModule('a'), time.Now())
+
+	h6, err := setup6("timeout=50ms", "reload=0", path)
+	require.NoError(t, err)
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0})
+	require.NoError(t, err)
+	advertise, err := dhcpv6.NewAdvertiseFromSolicit(solicit)
+	require.NoError(t, err)
+
+	// The instance is discarded, and replaced for the next request
+	for i := 0; i < 2; i++ {
+		resp, stop := h6(handler.NewContext(context.Background()), solicit, advertise)
+		assert.Equal(t, advertise, resp)
+		assert.False(t, stop)
+	}
+
+	h6, err = setup6("on-error=drop", "timeout=50ms", "reload=0", path)
+	require.NoError(t, err)
+	resp, stop := h6(handler.NewContext(context.Background()), solicit, advertise)
+	assert.Nil(t, resp)
+	assert.True(t, stop)
+}
@@ -0,0 +1,333 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package webhook implements a plugin posting lease events, as JSON, to HTTP
+// endpoints, so that external systems (eg. a CMDB or a NAC) can follow the
+// leases. The events are allocate, renew, release, expire, reclaim and pxe,
+// see plugins/leaseevents.
+//
+// Arguments are:
+// - url=<URL>: an endpoint to post the events to, can be repeated (mandatory)
+// - secret=<string>: a key signing the events with HMAC-SHA256. The signature
+// is sent in the X-Coredhcp-Signature header, as sha256=<hex digest of the
+// body>
+// - events=<event>[,<event>...]: the events to post, all by default
+// - retries=<n>: how many times to retry a failed post, 3 by default
+// - queue=<n>: how many events can wait to be posted before new ones are
+// dropped, 1024 by default
+// - timeout=<duration>: the timeout of a post, 5s by default
+//
+// The plugin must come after the plugins assigning addresses and boot files:
+//
+// server4:
+//   plugins:
+//     - range: leases.txt 192.0.2.100 192.0.2.200 1h
+//     - webhook: url=https://cmdb.example.org/dhcp secret=s3cr3t events=allocate,release,expire
+//
+// Events are posted in the background, in order, to each endpoint in turn.
+// The pending ones are posted when the server stops, for up to 5s. The plugin
+// is unhealthy while the last post to an endpoint failed.
+package webhook
+
+import (
+	"context"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/logger"
+	"github.com/coredhcp/coredhcp/plugins"
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+var log = logger.GetLogger("plugins/webhook")
+
+// Plugin wraps the webhook plugin information.
+var Plugin = plugins.Plugin{
+	Name:   "webhook",
+	Setup6: setup6,
+	Setup4: setup4,
+	Start:  start,
+	Stop:   stop,
+	Health: health,
+}
+
+// SignatureHeader is the HTTP header carrying the signature of the events
+const SignatureHeader = "X-Coredhcp-Signature"
+
+// retryDelay is the delay before the first retry of a post, doubled for each
+// of the following ones
+var retryDelay = time.Second
+
+// stopTimeout bounds how long the pending events are waited for when the
+// server stops
+const stopTimeout = 5 * time.Second
+
+// instances holds the instances of the plugin, for its lifecycle hooks
+var instances struct {
+	sync.Mutex
+	list []*PluginState
+}
+
+// PluginState holds the configuration of an instance of the plugin
+type PluginState struct {
+	urls    []string
+	secret  []byte
+	events  map[string]bool
+	retries int
+	client  *http.Client
+	queue   chan leaseevents.Event
+	tracker *leaseevents.Tracker
+	// done is closed once the events are all posted, after the plugin
+	// stopped
+	done chan struct{}
+
+	// mu guards stopped, set once the queue is closed, and failures, the
+	// errors of the last posts to the endpoints, by URL, when they failed
+	mu       sync.Mutex
+	stopped  bool
+	failures map[string]error
+}
+
+func parseArgs(args ...string) (*PluginState, error) {
+	p := PluginState{
+		events:  make(map[string]bool),
+		retries: 3,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, event := range leaseevents.All {
+		p.events[event] = true
+	}
+	queueSize := 1024
+	for _, arg := range args {
+		sep := strings.IndexByte(arg, '=')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid argument %q, expected <key>=<value>", arg)
+		}
+		key, value := arg[:sep], arg[sep+1:]
+		switch key {
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid URL %q, expected an http or https URL", value)
+			}
+			p.urls = append(p.urls, value)
+		case "secret":
+			if value == "" {
+				return nil, errors.New("empty secret")
+			}
+			p.secret = []byte(value)
+		case "events":
+			events := make(map[string]bool)
+			for _, event := range strings.Split(value, ",") {
+				if _, ok := p.events[event]; !ok {
+					return nil, fmt.Errorf("unknown event %q", event)
+				}
+				events[event] = true
+			}
+			p.events = events
+		case "retries":
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return nil, fmt.Errorf("invalid number of retries %q", value)
+			}
+			p.retries = retries
+		case "queue":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("invalid queue size %q", value)
+			}
+			queueSize = size
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q", value)
+			}
+			p.client.Timeout = timeout
+		default:
+			return nil, fmt.Errorf("unknown argument %q", key)
+		}
+	}
+	if len(p.urls) == 0 {
+		return nil, errors.New("need at least one URL")
+	}
+	p.queue = make(chan leaseevents.Event, queueSize)
+	p.failures = make(map[string]error)
+	return &p, nil
+}
+
+func setup(args ...string) (*PluginState, error) {
+	p, err := parseArgs(args...)
+	if err != nil {
+		return nil, err
+	}
+	p.tracker = leaseevents.NewTracker(p.send)
+	p.done = make(chan struct{})
+	go func() {
+		for e := range p.queue {
+			p.deliver(e)
+		}
+		close(p.done)
+	}()
+	instances.Lock()
+	instances.list = append(instances.list, p)
+	instances.Unlock()
+	log.Printf("posting lease events to %s", strings.Join(p.urls, ", "))
+	return p, nil
+}
+
+// start looks for the expired leases of the instances
+func start(ctx context.Context) error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		go p.tracker.Run(ctx)
+	}
+	return nil
+}
+
+// stop posts the pending events of the instances
+func stop() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		if !p.stopped {
+			p.stopped = true
+			close(p.queue)
+		}
+		p.mu.Unlock()
+	}
+	deadline := time.After(stopTimeout)
+	for _, p := range instances.list {
+		select {
+		case <-p.done:
+		case <-deadline:
+			return fmt.Errorf("gave up posting the pending events after %v", stopTimeout)
+		}
+	}
+	return nil
+}
+
+// health returns the error of the last post to an endpoint, if it failed
+func health() error {
+	instances.Lock()
+	defer instances.Unlock()
+	for _, p := range instances.list {
+		p.mu.Lock()
+		for _, u := range p.urls {
+			if err := p.failures[u]; err != nil {
+				p.mu.Unlock()
+				return fmt.Errorf("cannot post to %s: %w", u, err)
+			}
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
+
+func setup4(args ...string) (handler.Handler4, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler4, nil
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	p, err := setup(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.Handler6, nil
+}
+
+// send queues an event, without blocking the DHCP exchange
+func (p *PluginState) send(e leaseevents.Event) {
+	if !p.events[e.Event] {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Warningf("too many pending events, dropping the %s event of %s", e.Event, e.IP)
+	}
+}
+
+// deliver posts an event to all the endpoints
+func (p *PluginState) deliver(e leaseevents.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("could not encode the %s event of %s: %v", e.Event, e.IP, err)
+		return
+	}
+	for _, u := range p.urls {
+		var err error
+		for attempt := 0; ; attempt++ {
+			if err = p.post(u, body); err == nil {
+				break
+			}
+			if attempt >= p.retries {
+				log.Warningf("could not post the %s event of %s to %s: %v", e.Event, e.IP, u, err)
+				break
+			}
+			time.Sleep(retryDelay << attempt)
+		}
+		p.mu.Lock()
+		p.failures[u] = err
+		p.mu.Unlock()
+	}
+}
+
+func (p *PluginState) post(u string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.secret != nil {
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Handler4 handles DHCPv4 packets for the webhook plugin
+func (p *PluginState) Handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	p.tracker.Handle4(req, resp)
+	return resp, false
+}
+
+// Handler6 handles DHCPv6 packets for the webhook plugin
+func (p *PluginState) Handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	p.tracker.Handle6(req, resp)
+	return resp, false
+}
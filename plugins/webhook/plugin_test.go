@@ -0,0 +1,185 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins/leaseevents"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// endpoint records the events it gets, failing the first posts as told
+type endpoint struct {
+	sync.Mutex
+	events   []leaseevents.Event
+	failures int
+	posts    int
+	badSigs  int
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+	e.posts++
+	if e.failures > 0 {
+		e.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(body)
+	if r.Header.Get(SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		e.badSigs++
+	}
+	var event leaseevents.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	e.events = append(e.events, event)
+}
+
+func (e *endpoint) names() []string {
+	var names []string
+	for _, event := range e.events {
+		names = append(names, event.Event)
+	}
+	return names
+}
+
+// newTestState returns a plugin state posting to a test endpoint, whose
+// events are delivered by flush
+func newTestState(t *testing.T, e *endpoint, args ...string) *PluginState {
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	p, err := parseArgs(append([]string{"url=" + srv.URL, "secret=s3cr3t"}, args...)...)
+	require.NoError(t, err)
+	p.tracker = leaseevents.NewTracker(p.send)
+	return p
+}
+
+func flush(p *PluginState) {
+	for {
+		select {
+		case e := <-p.queue:
+			p.deliver(e)
+		default:
+			return
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	p, err := parseArgs("url=https://cmdb.example.org/dhcp", "url=http://nac.example.org",
+		"events=allocate,expire", "retries=0", "queue=10", "timeout=1s")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://cmdb.example.org/dhcp", "http://nac.example.org"}, p.urls)
+	assert.Equal(t, map[string]bool{leaseevents.Allocate: true, leaseevents.Expire: true}, p.events)
+	assert.Equal(t, 0, p.retries)
+	assert.Equal(t, 10, cap(p.queue))
+	assert.Equal(t, time.Second, p.client.Timeout)
+
+	for _, args := range [][]string{
+		{},
+		{"secret=s3cr3t"},
+		{"url=ftp://cmdb.example.org"},
+		{"url=cmdb.example.org"},
+		{"url=https://cmdb.example.org", "events=allocate,boot"},
+		{"url=https://cmdb.example.org", "retries=-1"},
+		{"url=https://cmdb.example.org", "queue=0"},
+		{"url=https://cmdb.example.org", "https://nac.example.org"},
+	} {
+		_, err := parseArgs(args...)
+		assert.Error(t, err, args)
+	}
+}
+
+func TestHandler4(t *testing.T) {
+	e := &endpoint{}
+	p := newTestState(t, e)
+
+	req, err := dhcpv4.New(
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithHwAddr(net.HardwareAddr{0, 1, 2, 3, 4, 5}),
+		dhcpv4.WithOption(dhcpv4.OptHostName("host")),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 100)),
+	)
+	require.NoError(t, err)
+	result, stop := p.Handler4(req, resp)
+	assert.Same(t, resp, result)
+	assert.False(t, stop)
+	flush(p)
+
+	require.Equal(t, []string{leaseevents.Allocate}, e.names())
+	assert.Zero(t, e.badSigs)
+	assert.Equal(t, "00:01:02:03:04:05", e.events[0].HWAddr)
+	assert.Equal(t, "host", e.events[0].Hostname)
+}
+
+func TestRetries(t *testing.T) {
+	retryDelay = time.Millisecond
+	e := &endpoint{failures: 2}
+	p := newTestState(t, e, "retries=2", "events=allocate")
+
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 100)})
+	// Filtered out
+	p.send(leaseevents.Event{Event: leaseevents.Release, IP: net.IPv4(192, 0, 2, 100)})
+	flush(p)
+	assert.Equal(t, 3, e.posts)
+	assert.Equal(t, []string{leaseevents.Allocate}, e.names())
+
+	// Dropped after the retries
+	e.failures = 5
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 101)})
+	flush(p)
+	assert.Equal(t, 6, e.posts)
+	assert.Len(t, e.events, 1)
+}
+
+func TestStopHealth(t *testing.T) {
+	e := &endpoint{failures: 1}
+	p := newTestState(t, e, "retries=0")
+	instances.list = []*PluginState{p}
+
+	// Unhealthy until a post succeeds
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 100)})
+	flush(p)
+	assert.Error(t, health())
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 101)})
+	flush(p)
+	assert.NoError(t, health())
+
+	// The pending events are posted when stopping, the next ones dropped
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 102)})
+	p.done = make(chan struct{})
+	go func() {
+		for e := range p.queue {
+			p.deliver(e)
+		}
+		close(p.done)
+	}()
+	require.NoError(t, stop())
+	p.send(leaseevents.Event{Event: leaseevents.Allocate, IP: net.IPv4(192, 0, 2, 103)})
+	assert.Equal(t, 3, e.posts)
+	assert.Len(t, e.events, 2)
+}
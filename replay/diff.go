@@ -0,0 +1,171 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// none stands for what a reply doesn't have
+const none = "none"
+
+// diff4 returns how a replayed DHCPv4 reply differs from the captured one,
+// either being nil if there is no reply
+func diff4(captured, replayed *dhcpv4.DHCPv4) []string {
+	switch {
+	case captured == nil && replayed == nil:
+		return nil
+	case captured == nil:
+		return []string{fmt.Sprintf("reply: captured none, replayed %s", replayed.MessageType())}
+	case replayed == nil:
+		return []string{fmt.Sprintf("reply: captured %s, replayed none", captured.MessageType())}
+	}
+	var diffs []string
+	field := func(name, c, r string) {
+		if c != r {
+			diffs = append(diffs, fmt.Sprintf("%s: captured %s, replayed %s", name, c, r))
+		}
+	}
+	field("message type", captured.MessageType().String(), replayed.MessageType().String())
+	field("yiaddr", ip4(captured.YourIPAddr), ip4(replayed.YourIPAddr))
+	field("siaddr", ip4(captured.ServerIPAddr), ip4(replayed.ServerIPAddr))
+	field("flags", fmt.Sprintf("%#04x", captured.Flags), fmt.Sprintf("%#04x", replayed.Flags))
+	field("sname", quote(captured.ServerHostName), quote(replayed.ServerHostName))
+	field("file", quote(captured.BootFileName), quote(replayed.BootFileName))
+
+	codes := make(map[uint8]bool)
+	for code := range captured.Options {
+		codes[code] = true
+	}
+	for code := range replayed.Options {
+		codes[code] = true
+	}
+	delete(codes, dhcpv4.OptionDHCPMessageType.Code())
+	sorted := make([]int, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, int(code))
+	}
+	sort.Ints(sorted)
+	for _, code := range sorted {
+		c, cok := captured.Options[uint8(code)]
+		r, rok := replayed.Options[uint8(code)]
+		if cok && rok && bytes.Equal(c, r) {
+			continue
+		}
+		name, cs := option4(uint8(code), c, cok)
+		_, rs := option4(uint8(code), r, rok)
+		diffs = append(diffs, fmt.Sprintf("option %s: captured %s, replayed %s", name, cs, rs))
+	}
+	return diffs
+}
+
+// option4 returns the name and the value of a DHCPv4 option, as the dhcpv4
+// package humanizes them, if the reply has it
+func option4(code uint8, value []byte, ok bool) (string, string) {
+	s := dhcpv4.Options{code: value}.String()
+	i := strings.IndexByte(s, ':')
+	name := strings.TrimSpace(s[:i])
+	if !ok {
+		return name, none
+	}
+	return name, strings.Join(strings.Fields(s[i+1:]), " ")
+}
+
+func ip4(ip net.IP) string {
+	if ip == nil {
+		return net.IPv4zero.String()
+	}
+	return ip.String()
+}
+
+func quote(s string) string {
+	if s == "" {
+		return none
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// diff6 returns how a replayed DHCPv6 reply differs from the captured one,
+// either being nil if there is no reply. The relayed replies are compared
+// by their inner messages
+func diff6(captured, replayed dhcpv6.DHCPv6) []string {
+	var c, r *dhcpv6.Message
+	if captured != nil {
+		c, _ = captured.GetInnerMessage()
+	}
+	if replayed != nil {
+		r, _ = replayed.GetInnerMessage()
+	}
+	switch {
+	case c == nil && r == nil:
+		return nil
+	case c == nil:
+		return []string{fmt.Sprintf("reply: captured none, replayed %s", r.Type())}
+	case r == nil:
+		return []string{fmt.Sprintf("reply: captured %s, replayed none", c.Type())}
+	}
+	var diffs []string
+	if c.Type() != r.Type() {
+		diffs = append(diffs, fmt.Sprintf("message type: captured %s, replayed %s", c.Type(), r.Type()))
+	}
+	co, ro := options6(c), options6(r)
+	var codes []int
+	for code := range co {
+		codes = append(codes, int(code))
+	}
+	for code := range ro {
+		if _, ok := co[code]; !ok {
+			codes = append(codes, int(code))
+		}
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		cv, rv := co[dhcpv6.OptionCode(code)], ro[dhcpv6.OptionCode(code)]
+		if cv.value != nil && rv.value != nil && bytes.Equal(cv.value, rv.value) {
+			continue
+		}
+		diffs = append(diffs, fmt.Sprintf("option %s: captured %s, replayed %s", dhcpv6.OptionCode(code), cv, rv))
+	}
+	return diffs
+}
+
+// option6 holds the options of a code of a DHCPv6 message
+type option6 struct {
+	value   []byte
+	strings []string
+}
+
+func (o option6) String() string {
+	if o.value == nil {
+		return none
+	}
+	return strings.Join(o.strings, ", ")
+}
+
+func options6(m *dhcpv6.Message) map[dhcpv6.OptionCode]option6 {
+	opts := make(map[dhcpv6.OptionCode]option6)
+	for _, opt := range m.Options.Options {
+		o := opts[opt.Code()]
+		o.value = append(o.value, opt.ToBytes()...)
+		if o.value == nil {
+			o.value = []byte{}
+		}
+		// Drop the name of the option
+		v := opt.String()
+		if i := strings.Index(v, ": "); i >= 0 {
+			v = v[i+2:]
+		}
+		o.strings = append(o.strings, v)
+		opts[opt.Code()] = o
+	}
+	return opts
+}
@@ -0,0 +1,216 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// Package replay runs the DHCP requests of packet captures through the
+// plugins, offline, and reports how the replies differ from the captured
+// ones, eg. to check that CoreDHCP answers as the server it replaces
+// (dnsmasq, ISC dhcpd, ...) before migrating.
+//
+// The requests are replayed in order, so that stateful plugins see the same
+// conversations as the captured server, and the replies are matched to the
+// captured ones by transaction ID (and hardware address for DHCPv4). The
+// captures should be taken on the server, as the requests relayed by several
+// relay agents, or captured both before and after a relay agent, would be
+// replayed several times.
+//
+// The plugins act as they do when serving: the configuration used to replay
+// should save the leases to copies of the lease files, and not use the
+// plugins acting on other systems (DDNS updates, webhooks, ...).
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Server answers the replayed requests, see server.Replayer
+type Server interface {
+	Handle4(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4
+	Handle6(req dhcpv6.DHCPv6) dhcpv6.DHCPv6
+}
+
+// Stats counts the replayed requests
+type Stats struct {
+	Requests int
+	// Differ is the number of requests whose reply differs from the
+	// captured one, including the ones with only one of them
+	Differ int
+}
+
+// message is a DHCP message of a capture
+type message struct {
+	// n is the number of the packet in the capture, from 1
+	n  int
+	v4 *dhcpv4.DHCPv4
+	v6 dhcpv6.DHCPv6
+	// request tells the requests from the replies
+	request bool
+	// matched is set for the replies matched to a request
+	matched bool
+}
+
+// File replays the requests of a pcap or pcapng file through a server, and
+// writes how the replies differ from the captured ones to w
+func File(s Server, path string, w io.Writer) (Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer f.Close()
+	msgs, err := read(f)
+	if err != nil {
+		return Stats{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return replay(s, msgs, w), nil
+}
+
+// packetReader reads the packets of a capture, see pcapgo
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// pcapngMagic starts the section header block of the pcapng files
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// read returns the DHCP messages of a capture
+func read(r io.Reader) ([]*message, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+	var pr packetReader
+	if bytes.Equal(magic, pcapngMagic) {
+		pr, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		pr, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*message
+	for n := 1; ; n++ {
+		data, _, err := pr.ReadPacketData()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %w", n, err)
+		}
+		p := gopacket.NewPacket(data, pr.LinkType(), gopacket.NoCopy)
+		udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if !ok {
+			continue
+		}
+		if m := parse(n, udp); m != nil {
+			msgs = append(msgs, m)
+		}
+	}
+}
+
+// parse returns the DHCP message of a UDP datagram, nil if it holds none
+func parse(n int, udp *layers.UDP) *message {
+	switch {
+	case udp.DstPort == dhcpv4.ServerPort || udp.SrcPort == dhcpv4.ServerPort:
+		d, err := dhcpv4.FromBytes(udp.Payload)
+		if err != nil {
+			return nil
+		}
+		return &message{n: n, v4: d, request: d.OpCode == dhcpv4.OpcodeBootRequest}
+	case udp.DstPort == dhcpv6.DefaultServerPort || udp.SrcPort == dhcpv6.DefaultServerPort:
+		d, err := dhcpv6.FromBytes(udp.Payload)
+		if err != nil {
+			return nil
+		}
+		switch d.Type() {
+		case dhcpv6.MessageTypeAdvertise, dhcpv6.MessageTypeReply, dhcpv6.MessageTypeRelayReply,
+			dhcpv6.MessageTypeReconfigure, dhcpv6.MessageTypeDHCPv4Response:
+			return &message{n: n, v6: d}
+		}
+		return &message{n: n, v6: d, request: true}
+	}
+	return nil
+}
+
+// replay replays the requests of a capture, matching their replies to the
+// captured ones
+func replay(s Server, msgs []*message, w io.Writer) Stats {
+	var stats Stats
+	for i, req := range msgs {
+		if !req.request {
+			continue
+		}
+		stats.Requests++
+		var (
+			captured *message
+			diffs    []string
+			header   string
+		)
+		if req.v4 != nil {
+			captured = match(msgs[i+1:], func(m *message) bool {
+				return m.v4 != nil && m.v4.TransactionID == req.v4.TransactionID &&
+					bytes.Equal(m.v4.ClientHWAddr, req.v4.ClientHWAddr)
+			})
+			var c *dhcpv4.DHCPv4
+			if captured != nil {
+				c = captured.v4
+			}
+			diffs = diff4(c, s.Handle4(req.v4))
+			header = fmt.Sprintf("DHCPv4 %s from %s (xid %s)", req.v4.MessageType(), req.v4.ClientHWAddr, req.v4.TransactionID)
+		} else {
+			inner, err := req.v6.GetInnerMessage()
+			if err != nil {
+				continue
+			}
+			captured = match(msgs[i+1:], func(m *message) bool {
+				if m.v6 == nil {
+					return false
+				}
+				mi, err := m.v6.GetInnerMessage()
+				return err == nil && mi.TransactionID == inner.TransactionID
+			})
+			var c dhcpv6.DHCPv6
+			if captured != nil {
+				c = captured.v6
+			}
+			diffs = diff6(c, s.Handle6(req.v6))
+			header = fmt.Sprintf("DHCPv6 %s (xid %s)", inner.Type(), inner.TransactionID)
+		}
+		if len(diffs) == 0 {
+			continue
+		}
+		stats.Differ++
+		if captured != nil {
+			fmt.Fprintf(w, "packet %d, reply in packet %d: %s\n", req.n, captured.n, header)
+		} else {
+			fmt.Fprintf(w, "packet %d: %s\n", req.n, header)
+		}
+		for _, d := range diffs {
+			fmt.Fprintf(w, "    %s\n", d)
+		}
+	}
+	return stats
+}
+
+// match returns the first reply matching a predicate, and marks it matched
+func match(msgs []*message, f func(*message) bool) *message {
+	for _, m := range msgs {
+		if !m.request && !m.matched && f(m) {
+			m.matched = true
+			return m
+		}
+	}
+	return nil
+}
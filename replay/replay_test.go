@@ -0,0 +1,162 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package replay
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capture returns a pcap file of UDP datagrams between a client and a
+// server, each payload being sent by the client if its source port is the
+// first one of the pair
+func capture(t *testing.T, v6 bool, datagrams ...[]byte) []byte {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	client, server := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")
+	eth := layers.Ethernet{SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2}, DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1}, EthernetType: layers.EthernetTypeIPv4}
+	ports := [2]layers.UDPPort{dhcpv4.ClientPort, dhcpv4.ServerPort}
+	if v6 {
+		client, server = net.ParseIP("fe80::2"), net.ParseIP("2001:db8::1")
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ports = [2]layers.UDPPort{dhcpv6.DefaultClientPort, dhcpv6.DefaultServerPort}
+	}
+	for i, payload := range datagrams {
+		udp := layers.UDP{SrcPort: ports[0], DstPort: ports[1]}
+		src, dst := client, server
+		if i%2 == 1 {
+			udp.SrcPort, udp.DstPort = udp.DstPort, udp.SrcPort
+			src, dst = dst, src
+		}
+		var ip gopacket.SerializableLayer
+		if v6 {
+			ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+			require.NoError(t, udp.SetNetworkLayerForChecksum(ip6))
+			ip = ip6
+		} else {
+			ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src.To4(), DstIP: dst.To4()}
+			require.NoError(t, udp.SetNetworkLayerForChecksum(ip4))
+			ip = ip4
+		}
+		b := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		require.NoError(t, gopacket.SerializeLayers(b, opts, &eth, ip, &udp, gopacket.Payload(payload)))
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: len(b.Bytes()), Length: len(b.Bytes())}
+		require.NoError(t, w.WritePacket(ci, b.Bytes()))
+	}
+	return buf.Bytes()
+}
+
+// server answers DHCPv4 requests with an ACK for 10.0.0.10, DHCPv6
+// Solicits with an Advertise
+type server struct{}
+
+func (server) Handle4(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	resp, _ := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.ParseIP("10.0.0.10")),
+		dhcpv4.WithOption(dhcpv4.OptRouter(net.ParseIP("10.0.0.1"))),
+	)
+	return resp
+}
+
+func (server) Handle6(req dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
+	msg, _ := req.GetInnerMessage()
+	resp, _ := dhcpv6.NewAdvertiseFromSolicit(msg)
+	if req.IsRelay() {
+		relayed, _ := dhcpv6.NewRelayReplFromRelayForw(req.(*dhcpv6.RelayMessage), resp)
+		return relayed
+	}
+	return resp
+}
+
+func TestReplay4(t *testing.T) {
+	mac := net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}
+	req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+	require.NoError(t, err)
+	same := server{}.Handle4(req)
+	other, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.ParseIP("10.0.0.11")),
+		dhcpv4.WithOption(dhcpv4.OptDNS(net.ParseIP("10.0.0.53"))),
+	)
+	require.NoError(t, err)
+	unanswered, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+
+	msgs, err := read(bytes.NewReader(capture(t, false,
+		req.ToBytes(), same.ToBytes(),
+		req.ToBytes(), other.ToBytes(),
+		unanswered.ToBytes(),
+	)))
+	require.NoError(t, err)
+	require.Len(t, msgs, 5)
+
+	var out bytes.Buffer
+	stats := replay(server{}, msgs, &out)
+	assert.Equal(t, Stats{Requests: 3, Differ: 2}, stats)
+	assert.Equal(t, "packet 3, reply in packet 4: DHCPv4 REQUEST from de:ad:be:ef:00:00 (xid "+req.TransactionID.String()+")\n"+
+		"    yiaddr: captured 10.0.0.11, replayed 10.0.0.10\n"+
+		"    option Router: captured none, replayed 10.0.0.1\n"+
+		"    option Domain Name Server: captured 10.0.0.53, replayed none\n"+
+		"packet 5: DHCPv4 DISCOVER from de:ad:be:ef:00:00 (xid "+unanswered.TransactionID.String()+")\n"+
+		"    reply: captured none, replayed ACK\n",
+		out.String())
+}
+
+func TestReplay6(t *testing.T) {
+	mac := net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0, 0}
+	solicit, err := dhcpv6.NewSolicit(mac)
+	require.NoError(t, err)
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::2"), net.ParseIP("fe80::2"))
+	require.NoError(t, err)
+	advertise, err := dhcpv6.NewAdvertiseFromSolicit(solicit)
+	require.NoError(t, err)
+	relayedAdvertise, err := dhcpv6.NewRelayReplFromRelayForw(relayed, advertise)
+	require.NoError(t, err)
+	other, err := dhcpv6.NewAdvertiseFromSolicit(solicit, dhcpv6.WithDNS(net.ParseIP("2001:db8::53")))
+	require.NoError(t, err)
+
+	msgs, err := read(bytes.NewReader(capture(t, true,
+		relayed.ToBytes(), relayedAdvertise.ToBytes(),
+		solicit.ToBytes(), other.ToBytes(),
+	)))
+	require.NoError(t, err)
+	require.Len(t, msgs, 4)
+
+	var out bytes.Buffer
+	stats := replay(server{}, msgs, &out)
+	assert.Equal(t, Stats{Requests: 2, Differ: 1}, stats)
+	assert.Equal(t, "packet 3, reply in packet 4: DHCPv6 SOLICIT (xid "+solicit.TransactionID.String()+")\n"+
+		"    option DNS Recursive Name Server: captured [2001:db8::53], replayed none\n",
+		out.String())
+}
+
+func TestReadPcapng(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriter(&buf, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+	r, err := pcapgo.NewReader(bytes.NewReader(capture(t, false, []byte("not DHCP"))))
+	require.NoError(t, err)
+	data, ci, err := r.ReadPacketData()
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(ci, data))
+	require.NoError(t, w.Flush())
+
+	msgs, err := read(&buf)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+}
@@ -0,0 +1,96 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package server
+
+// The clients without an address cannot answer the ARP requests for the
+// address they are offered, so the kernel cannot unicast their replies
+// through the UDP socket. As ISC dhcpd and dnsmasq do, the address is put in
+// the ARP cache of the interface with the hardware address of the client
+// beforehand, and the reply sent through the UDP socket as any other. This
+// needs CAP_NET_ADMIN: without it, the replies are sent as Ethernet frames
+// on packet sockets instead, see sendEthernet.
+
+import (
+	"net"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// atfCom marks a completed entry of the ARP cache, which the kernel ages out
+// as any other, unlike the permanent ones (ATF_PERM)
+const atfCom = 0x02
+
+// arpreq is the argument of the SIOCSARP ioctl, struct arpreq of
+// <net/if_arp.h>
+type arpreq struct {
+	pa      [16]byte
+	ha      [16]byte
+	flags   int32
+	netmask [16]byte
+	dev     [16]byte
+}
+
+// newARPRequest returns the request adding an entry to the ARP cache of an
+// interface
+func newARPRequest(ifname string, ip net.IP, mac net.HardwareAddr) arpreq {
+	var req arpreq
+	// struct sockaddr_in, the family in host order, the address in network
+	// order
+	*(*uint16)(unsafe.Pointer(&req.pa[0])) = unix.AF_INET
+	copy(req.pa[4:8], ip.To4())
+	*(*uint16)(unsafe.Pointer(&req.ha[0])) = unix.ARPHRD_ETHER
+	copy(req.ha[2:], mac)
+	req.flags = atfCom
+	copy(req.dev[:len(req.dev)-1], ifname)
+	return req
+}
+
+// injectARP maps ip to the hardware address of the client in the ARP cache of
+// an interface, through the socket of a listener
+func injectARP(conn *net.UDPConn, ifname string, ip net.IP, mac net.HardwareAddr) error {
+	if ip.To4() == nil || len(mac) != 6 {
+		return unix.EINVAL
+	}
+	req := newARPRequest(ifname, ip, mac)
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno unix.Errno
+	if err := raw.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, unix.SIOCSARP, uintptr(unsafe.Pointer(&req)))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// addARP adds the ARP entry of a client to the cache of an interface, and
+// tells whether its reply can be sent through the UDP socket. It stops trying
+// once the permission is denied
+func (l *listener4) addARP(ifname string, ip net.IP, mac net.HardwareAddr) bool {
+	if l.udp == nil || atomic.LoadInt32(&l.noARP) != 0 {
+		return false
+	}
+	err := injectARP(l.udp, ifname, ip, mac)
+	if err == nil {
+		return true
+	}
+	if err == unix.EPERM {
+		if atomic.CompareAndSwapInt32(&l.noARP, 0, 1) {
+			log.Infof("Cannot add ARP entries on %s without CAP_NET_ADMIN, replying with Ethernet frames", ifname)
+		}
+	} else {
+		log.Debugf("Cannot add the ARP entry of %s on %s: %v", ip, ifname, err)
+	}
+	return false
+}
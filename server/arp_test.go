@@ -0,0 +1,42 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"unsafe"
+)
+
+func TestARPRequest(t *testing.T) {
+	if size := unsafe.Sizeof(arpreq{}); size != 68 {
+		t.Fatalf("expected the size of struct arpreq, 68 bytes, got %d", size)
+	}
+	req := newARPRequest("eth0", net.IPv4(10, 0, 0, 42), clientMAC)
+	if !bytes.Equal(req.pa[4:8], []byte{10, 0, 0, 42}) {
+		t.Errorf("unexpected protocol address %v", req.pa)
+	}
+	if !bytes.Equal(req.ha[2:8], clientMAC) {
+		t.Errorf("unexpected hardware address %v", req.ha)
+	}
+	if req.flags != atfCom || string(bytes.TrimRight(req.dev[:], "\x00")) != "eth0" {
+		t.Errorf("unexpected flags %#x or device %q", req.flags, req.dev)
+	}
+
+	// Interface names are truncated, NUL-terminated
+	req = newARPRequest("a-very-long-interface-name", net.IPv4(10, 0, 0, 42), clientMAC)
+	if req.dev[len(req.dev)-1] != 0 {
+		t.Error("the device name should be NUL-terminated")
+	}
+
+	// Without a socket, the replies are sent as Ethernet frames
+	var l listener4
+	if l.addARP("eth0", net.IPv4(10, 0, 0, 42), clientMAC) {
+		t.Error("expected no ARP entry without a socket")
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// responseCache holds the responses to the requests handled in the last
+// window, by request (see dedupKey4 and dedupKey6), for their retransmissions
+// to get the same response without running the plugins again, which would
+// eg. notify webhooks or update DNS twice
+type responseCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*cachedResponse
+	lastSweep time.Time
+}
+
+// cachedResponse is the encoded response to a request, nil while the request
+// is being handled
+type cachedResponse struct {
+	resp    []byte
+	expires time.Time
+}
+
+func newResponseCache(window time.Duration) *responseCache {
+	return &responseCache{window: window, entries: make(map[string]*cachedResponse)}
+}
+
+// lookup returns the response to a request, and whether it is a
+// retransmission: the response is then nil if the request is still being
+// handled. Otherwise, the request is marked as being handled, until store
+func (c *responseCache) lookup(key string, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) >= c.window {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	if e, ok := c.entries[key]; ok && now.Before(e.expires) {
+		return e.resp, true
+	}
+	c.entries[key] = &cachedResponse{expires: now.Add(c.window)}
+	return nil, false
+}
+
+// store keeps the response to a request. Requests without response are not
+// kept, their retransmissions being handled again, eg. once a backend is back
+func (c *responseCache) store(key string, resp []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp == nil {
+		delete(c.entries, key)
+		return
+	}
+	c.entries[key] = &cachedResponse{resp: resp, expires: now.Add(c.window)}
+}
+
+// dedupKey4 returns the key of a DISCOVER or REQUEST and its
+// retransmissions: their transaction ID, client identifier (or hardware
+// address), and relay agent. Other requests are not deduplicated
+func dedupKey4(req *dhcpv4.DHCPv4) (string, bool) {
+	mt := req.MessageType()
+	if mt != dhcpv4.MessageTypeDiscover && mt != dhcpv4.MessageTypeRequest {
+		return "", false
+	}
+	client := req.Options.Get(dhcpv4.OptionClientIdentifier)
+	if client == nil {
+		client = req.ClientHWAddr
+	}
+	key := append([]byte{byte(mt)}, req.TransactionID[:]...)
+	key = append(key, req.GatewayIPAddr.To4()...)
+	key = append(key, client...)
+	return string(key), true
+}
+
+// dedup4 returns a DHCPv4 chain answering the retransmissions of its requests
+// handled in the last window with the same response, the chain itself if the
+// window is 0
+func dedup4(window time.Duration, handlers []handler.Handler4Ctx) []handler.Handler4Ctx {
+	if window <= 0 {
+		return handlers
+	}
+	c := newResponseCache(window)
+	return []handler.Handler4Ctx{func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		key, ok := dedupKey4(req)
+		if !ok {
+			return run4(ctx, handlers, req, resp)
+		}
+		if cached, ok := c.lookup(key, time.Now()); ok {
+			if cached == nil {
+				log.Debugf("MainHandler4: dropping retransmission from %s, still handling the request", req.ClientHWAddr)
+				return nil, true
+			}
+			out, err := dhcpv4.FromBytes(cached)
+			if err != nil {
+				log.Errorf("MainHandler4: cannot decode the cached response: %v", err)
+				return nil, true
+			}
+			log.Debugf("MainHandler4: answering retransmission from %s with the cached response", req.ClientHWAddr)
+			return out, true
+		}
+		resp, stop := run4(ctx, handlers, req, resp)
+		var encoded []byte
+		if resp != nil {
+			encoded = resp.ToBytes()
+		}
+		c.store(key, encoded, time.Now())
+		return resp, stop
+	}}
+}
+
+// run4 runs a DHCPv4 chain, like process4
+func run4(ctx context.Context, handlers []handler.Handler4Ctx, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	var stop bool
+	for _, h := range handlers {
+		if resp, stop = h(ctx, req, resp); stop {
+			break
+		}
+	}
+	return resp, stop
+}
+
+// dedupKey6 returns the key of a SOLICIT or REQUEST and its
+// retransmissions: their transaction ID, client identifier, and relay agent
+// if relayed. Other requests are not deduplicated
+func dedupKey6(d dhcpv6.DHCPv6) (string, bool) {
+	msg, err := d.GetInnerMessage()
+	if err != nil {
+		return "", false
+	}
+	if msg.MessageType != dhcpv6.MessageTypeSolicit && msg.MessageType != dhcpv6.MessageTypeRequest {
+		return "", false
+	}
+	client := msg.GetOneOption(dhcpv6.OptionClientID)
+	if client == nil {
+		return "", false
+	}
+	key := append([]byte{byte(msg.MessageType)}, msg.TransactionID[:]...)
+	if relay, ok := d.(*dhcpv6.RelayMessage); ok {
+		key = append(key, relay.LinkAddr...)
+		key = append(key, relay.PeerAddr...)
+	}
+	key = append(key, client.ToBytes()...)
+	return string(key), true
+}
+
+// dedup6 behaves like dedup4, for DHCPv6 chains
+func dedup6(window time.Duration, handlers []handler.Handler6Ctx) []handler.Handler6Ctx {
+	if window <= 0 {
+		return handlers
+	}
+	c := newResponseCache(window)
+	return []handler.Handler6Ctx{func(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		key, ok := dedupKey6(req)
+		if !ok {
+			return run6(ctx, handlers, req, resp)
+		}
+		if cached, ok := c.lookup(key, time.Now()); ok {
+			if cached == nil {
+				log.Debug("MainHandler6: dropping retransmission, still handling the request")
+				return nil, true
+			}
+			out, err := dhcpv6.FromBytes(cached)
+			if err != nil {
+				log.Errorf("MainHandler6: cannot decode the cached response: %v", err)
+				return nil, true
+			}
+			log.Debug("MainHandler6: answering retransmission with the cached response")
+			return out, true
+		}
+		resp, stop := run6(ctx, handlers, req, resp)
+		var encoded []byte
+		if resp != nil {
+			encoded = resp.ToBytes()
+		}
+		c.store(key, encoded, time.Now())
+		return resp, stop
+	}}
+}
+
+// run6 runs a DHCPv6 chain, like process6
+func run6(ctx context.Context, handlers []handler.Handler6Ctx, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	var stop bool
+	for _, h := range handlers {
+		if resp, stop = h(ctx, req, resp); stop {
+			break
+		}
+	}
+	return resp, stop
+}
@@ -0,0 +1,121 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(time.Second)
+	now := time.Now()
+	if _, ok := c.lookup("a", now); ok {
+		t.Fatal("new request seen as a retransmission")
+	}
+	// Still being handled
+	if resp, ok := c.lookup("a", now); !ok || resp != nil {
+		t.Errorf("expected a retransmission being handled, got %v, %v", resp, ok)
+	}
+	c.store("a", []byte("response"), now)
+	if resp, ok := c.lookup("a", now.Add(time.Second/2)); !ok || string(resp) != "response" {
+		t.Errorf("expected the cached response, got %q, %v", resp, ok)
+	}
+	// Expired, and swept
+	if _, ok := c.lookup("a", now.Add(2*time.Second)); ok {
+		t.Error("expired response returned")
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("expected the expired entries to be swept, got %d", len(c.entries))
+	}
+
+	// Requests without response are handled again
+	c.store("a", nil, now)
+	if _, ok := c.lookup("a", now); ok {
+		t.Error("request without response seen as a retransmission")
+	}
+}
+
+func TestDedup4(t *testing.T) {
+	calls := 0
+	handlers := dedup4(time.Minute, []handler.Handler4Ctx{
+		func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			calls++
+			resp.YourIPAddr = net.IPv4(10, 0, 0, byte(100+calls))
+			return resp, true
+		},
+	})
+	if len(handlers) != 1 {
+		t.Fatalf("expected a single handler, got %d", len(handlers))
+	}
+	handle := func(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+		return process4(handler.NewContext(context.Background()), req, handlers, false, false)
+	}
+
+	discover, err := dhcpv4.NewDiscovery(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := handle(discover)
+	retransmitted := handle(discover)
+	if calls != 1 {
+		t.Errorf("expected the plugins to run once, got %d", calls)
+	}
+	if first == nil || retransmitted == nil || !retransmitted.YourIPAddr.Equal(first.YourIPAddr) {
+		t.Errorf("expected the same response, got %v and %v", first, retransmitted)
+	}
+
+	// Other transactions, and other message types, are handled
+	other, err := dhcpv4.NewDiscovery(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle(other)
+	inform, err := dhcpv4.New(dhcpv4.WithHwAddr(clientMAC), dhcpv4.WithMessageType(dhcpv4.MessageTypeInform))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle(inform)
+	handle(inform)
+	if calls != 4 {
+		t.Errorf("expected the plugins to run 4 times, got %d", calls)
+	}
+
+	if h := dedup4(0, handlers); len(h) != 1 || &h[0] != &handlers[0] {
+		t.Error("expected the chain itself without window")
+	}
+}
+
+func TestDedupKey6(t *testing.T) {
+	solicit, err := dhcpv6.NewSolicit(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := dedupKey6(solicit)
+	if !ok {
+		t.Fatal("expected a key for a SOLICIT")
+	}
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relayedKey, ok := dedupKey6(relayed); !ok || relayedKey == key {
+		t.Errorf("expected another key for the relayed SOLICIT, got %q", relayedKey)
+	}
+	if again, _ := dedupKey6(solicit); again != key {
+		t.Errorf("expected the same key for a retransmission, got %q", again)
+	}
+
+	solicit.MessageType = dhcpv6.MessageTypeRenew
+	if _, ok := dedupKey6(solicit); ok {
+		t.Error("RENEW should not be deduplicated")
+	}
+}
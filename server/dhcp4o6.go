@@ -0,0 +1,52 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// DHCPv4 over DHCPv6 (RFC7341): on IPv6-only access networks, clients carry
+// their DHCPv4 messages in a DHCPv4 Message option of a DHCPv4-query sent to
+// the DHCPv6 server. The DHCPv4 message is run through the DHCPv4 plugin
+// chain as if it had been received on a DHCPv4 listener, and the reply is
+// sent back in a DHCPv4-response, through the same relays as the query.
+
+import (
+	"context"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// handleDHCPv4Query returns the DHCPv4-response to a DHCPv4-query, or nil if
+// there should be none
+func (l *listener6) handleDHCPv4Query(ctx context.Context, msg *dhcpv6.Message) *dhcpv6.Message {
+	if len(l.handlers4) == 0 {
+		log.Printf("MainHandler6: DHCPv4-query received but no DHCPv4 server is configured")
+		return nil
+	}
+	opt := msg.GetOneOption(dhcpv6.OptionDHCPv4Msg)
+	if opt == nil {
+		// RFC7341 §7: a query without a DHCPv4 Message option is discarded
+		log.Printf("MainHandler6: DHCPv4-query without DHCPv4 Message option, dropping")
+		return nil
+	}
+	req := opt.(*dhcpv6.OptDHCPv4Msg).Msg
+	if req == nil {
+		return nil
+	}
+
+	resp := process4(ctx, req, l.handlers4, l.rapidCommit4, l.authoritative4)
+	if resp == nil {
+		return nil
+	}
+	handler.FinishReply4(ctx, resp)
+	// RFC7341 §6.2: the transaction-id field carries flags, which must be
+	// zero in a DHCPv4-response
+	return &dhcpv6.Message{
+		MessageType: dhcpv6.MessageTypeDHCPv4Response,
+		Options: dhcpv6.MessageOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptDHCPv4Msg{Msg: resp},
+		}},
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// bootpMinLen is the minimum length of a BOOTP message (RFC951), which some
+// relay agents and clients insist on
+const bootpMinLen = 300
+
+// magicCookie starts the options of a DHCPv4 message (RFC2131 §3)
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+// appendReply4 appends the encoding of a DHCPv4 message to b, and returns
+// the extended buffer. It is the same as ToBytes, without allocating when b
+// is large enough: the replies are encoded into the pooled buffers
+func appendReply4(b []byte, d *dhcpv4.DHCPv4) []byte {
+	b = append(b, byte(d.OpCode), byte(d.HWType), byte(len(d.ClientHWAddr)), d.HopCount)
+	b = append(b, d.TransactionID[:]...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(b[len(b)-4:], d.NumSeconds)
+	binary.BigEndian.PutUint16(b[len(b)-2:], d.Flags)
+	for _, ip := range [...]net.IP{d.ClientIPAddr, d.YourIPAddr, d.ServerIPAddr, d.GatewayIPAddr} {
+		b = appendIP4(b, ip)
+	}
+	// The hardware address, server host name and boot file name fields are
+	// zero-padded, the names are NUL-terminated
+	b = appendPadded(b, string(d.ClientHWAddr), 16, 16)
+	b = appendPadded(b, d.ServerHostName, 64, 63)
+	b = appendPadded(b, d.BootFileName, 128, 127)
+	b = append(b, magicCookie[:]...)
+
+	// The options are sorted by code, as by ToBytes
+	var (
+		codes [256]byte
+		n     int
+	)
+	for code := range d.Options {
+		if code == dhcpv4.OptionPad.Code() || code == dhcpv4.OptionEnd.Code() {
+			continue
+		}
+		i := n
+		for ; i > 0 && codes[i-1] > code; i-- {
+			codes[i] = codes[i-1]
+		}
+		codes[i] = code
+		n++
+	}
+	for _, code := range codes[:n] {
+		data := d.Options[code]
+		// RFC3396: longer options are split in several options of the
+		// same code
+		for {
+			l := len(data)
+			if l > 255 {
+				l = 255
+			}
+			b = append(b, code, byte(l))
+			b = append(b, data[:l]...)
+			data = data[l:]
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	for len(b)+1 < bootpMinLen {
+		b = append(b, dhcpv4.OptionPad.Code())
+	}
+	return append(b, dhcpv4.OptionEnd.Code())
+}
+
+// appendIP4 appends an IPv4 address, zero if ip is not one
+func appendIP4(b []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return append(b, ip4...)
+	}
+	return append(b, 0, 0, 0, 0)
+}
+
+// appendPadded appends a field of size bytes, holding at most max bytes of
+// value and zeroes
+func appendPadded(b []byte, value string, size, max int) []byte {
+	if len(value) > max {
+		value = value[:max]
+	}
+	b = append(b, value...)
+	for i := len(value); i < size; i++ {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,155 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins/dns"
+	"github.com/coredhcp/coredhcp/plugins/leasetime"
+	"github.com/coredhcp/coredhcp/plugins/netmask"
+	"github.com/coredhcp/coredhcp/plugins/router"
+	"github.com/coredhcp/coredhcp/plugins/serverid"
+)
+
+var (
+	clientMAC = net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	clientIP  = net.IPv4(10, 0, 0, 42)
+)
+
+// renew4 returns the RENEW of a client with a lease: a REQUEST with the
+// leased address in ciaddr
+func renew4(t testing.TB) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(clientMAC),
+		dhcpv4.WithClientIP(clientIP),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+var (
+	chain4Once     sync.Once
+	chain4Handlers []handler.Handler4Ctx
+	chain4Err      error
+)
+
+// chain4 returns a typical plugin chain handing out static options, and a
+// lease to the client of renew4. It is set up once, some of these plugins
+// accumulating their arguments in global state
+func chain4(t testing.TB) []handler.Handler4Ctx {
+	chain4Once.Do(func() { chain4Handlers, chain4Err = setupChain4() })
+	if chain4Err != nil {
+		t.Fatal(chain4Err)
+	}
+	return chain4Handlers
+}
+
+func setupChain4() ([]handler.Handler4Ctx, error) {
+	var handlers []handler.Handler4Ctx
+	for _, setup := range []struct {
+		setup func(...string) (handler.Handler4, error)
+		args  []string
+	}{
+		{serverid.Plugin.Setup4, []string{"10.0.0.1"}},
+		{router.Plugin.Setup4, []string{"10.0.0.1"}},
+		{netmask.Plugin.Setup4, []string{"255.255.255.0"}},
+		{dns.Plugin.Setup4, []string{"10.0.0.2", "10.0.0.3"}},
+		{leasetime.Plugin.Setup4, []string{"1h"}},
+	} {
+		h, err := setup.setup(setup.args...)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, handler.Adapt4(h))
+	}
+	return append(handlers, func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		resp.YourIPAddr = clientIP
+		return resp, false
+	}), nil
+}
+
+func TestAppendReply4(t *testing.T) {
+	resp := process4(context.Background(), renew4(t), chain4(t), false, false)
+	if resp == nil {
+		t.Fatal("no reply to the renew")
+	}
+	long, err := dhcpv4.NewReplyFromRequest(renew4(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	long.ServerHostName = strings.Repeat("s", 70)
+	long.BootFileName = "pxelinux.0"
+	long.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, bytes.Repeat([]byte{1}, 300)))
+	long.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionRapidCommit, nil))
+
+	for _, d := range []*dhcpv4.DHCPv4{resp, long} {
+		if got, want := appendReply4(nil, d), d.ToBytes(); !bytes.Equal(got, want) {
+			t.Errorf("appendReply4 differs from ToBytes:\n%x\n%x", got, want)
+		}
+	}
+}
+
+func BenchmarkProcess4Renew(b *testing.B) {
+	req, handlers := renew4(b), chain4(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if process4(context.Background(), req, handlers, false, false) == nil {
+			b.Fatal("no reply to the renew")
+		}
+	}
+}
+
+// BenchmarkRenew4 covers the whole handling of a renew, from the received
+// bytes to the encoded reply
+func BenchmarkRenew4(b *testing.B) {
+	raw, handlers := renew4(b).ToBytes(), chain4(b)
+	out := make([]byte, 0, MaxDatagram)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, err := dhcpv4.FromBytes(raw)
+		if err != nil {
+			b.Fatal(err)
+		}
+		resp := process4(handler.NewContext(context.Background()), req, handlers, false, false)
+		if resp == nil {
+			b.Fatal("no reply to the renew")
+		}
+		out = appendReply4(out[:0], resp)
+	}
+}
+
+func BenchmarkAppendReply4(b *testing.B) {
+	resp := process4(context.Background(), renew4(b), chain4(b), false, false)
+	out := make([]byte, 0, MaxDatagram)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out = appendReply4(out[:0], resp)
+	}
+}
+
+func BenchmarkClientKey4(b *testing.B) {
+	raw := renew4(b).ToBytes()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clientKey4(raw)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build gofuzz
+
+package server
+
+// The entry points for go-fuzz (https://github.com/dvyukov/go-fuzz), eg.:
+//
+//     go-fuzz-build -func Fuzz4 github.com/coredhcp/coredhcp/server
+//     go-fuzz -bin server-fuzz.zip -workdir fuzz4
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// Fuzz4 runs a DHCPv4 packet through the server, the plugins getting it
+// only once its malformed options are removed
+func Fuzz4(data []byte) int {
+	req, err := dhcpv4.FromBytes(data)
+	if err != nil {
+		return 0
+	}
+	handlers := validate4(config.MalformedBestEffort, []handler.Handler4Ctx{
+		func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			if problems := check4(req); len(problems) > 0 {
+				panic(fmt.Sprintf("malformed request handled: %v", problems))
+			}
+			return resp, false
+		},
+	})
+	process4(handler.NewContext(context.Background()), req, handlers, true, true)
+	return 1
+}
+
+// Fuzz6 behaves like Fuzz4, for DHCPv6 packets
+func Fuzz6(data []byte) int {
+	req, err := dhcpv6.FromBytes(data)
+	if err != nil {
+		return 0
+	}
+	l := listener6{handlers: validate6(config.MalformedBestEffort, []handler.Handler6Ctx{
+		func(_ context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+			if problems := check6(req); len(problems) > 0 {
+				panic(fmt.Sprintf("malformed request handled: %v", problems))
+			}
+			return resp, false
+		},
+	})}
+	l.process6(handler.NewContext(context.Background()), req)
+	return 1
+}
@@ -5,13 +5,17 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/metrics"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 )
@@ -27,18 +31,36 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 		return
 	}
 
+	if resp := l.process6(handler.NewContext(context.Background()), d); resp != nil {
+		l.reply6(resp, oob, peer)
+	}
+}
+
+// process6 runs a DHCPv6 message through the plugin chain, and returns the
+// reply, re-encapsulated if the message was relayed, or nil if there should
+// be none
+func (l *listener6) process6(ctx context.Context, d dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
 	// decapsulate the relay message
 	msg, err := d.GetInnerMessage()
 	if err != nil {
 		log.Warningf("DHCPv6: cannot get inner message: %v", err)
-		return
+		return nil
+	}
+
+	if msg.Type() == dhcpv6.MessageTypeDHCPv4Query {
+		resp := l.handleDHCPv4Query(ctx, msg)
+		if resp == nil {
+			log.Print("MainHandler6: dropping DHCPv4-query because response is nil")
+			return nil
+		}
+		return encapsulate6(d, resp)
 	}
 
 	// Create a suitable basic response packet
 	var resp dhcpv6.DHCPv6
 	switch msg.Type() {
 	case dhcpv6.MessageTypeSolicit:
-		if msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
+		if l.rapidCommit && msg.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
 			resp, err = dhcpv6.NewReplyFromMessage(msg)
 		} else {
 			resp, err = dhcpv6.NewAdvertiseFromSolicit(msg)
@@ -51,111 +73,105 @@ func (l *listener6) HandleMsg6(buf []byte, oob *ipv6.ControlMessage, peer *net.U
 	}
 	if err != nil {
 		log.Printf("MainHandler6: NewReplyFromDHCPv6Message failed: %v", err)
-		return
+		return nil
 	}
 
 	var stop bool
 	for _, handler := range l.handlers {
-		resp, stop = handler(d, resp)
+		resp, stop = handler(ctx, d, resp)
 		if stop {
 			break
 		}
 	}
 	if resp == nil {
+		client := "a client without DUID"
+		if duid := msg.Options.ClientID(); duid != nil {
+			client = duid.String()
+		}
+		reportRefusal(ctx, client)
 		log.Print("MainHandler6: dropping request because response is nil")
-		return
+		return nil
 	}
+	handler.FinishReply6(ctx, resp)
+	return encapsulate6(d, resp)
+}
 
-	// if the request was relayed, re-encapsulate the response
-	if d.IsRelay() {
-		if rmsg, ok := resp.(*dhcpv6.Message); !ok {
-			log.Warningf("DHCPv6: response is a relayed message, not reencapsulating")
-		} else {
-			tmp, err := dhcpv6.NewRelayReplFromRelayForw(d.(*dhcpv6.RelayMessage), rmsg)
-			if err != nil {
-				log.Warningf("DHCPv6: cannot create relay-repl from relay-forw: %v", err)
-				return
-			}
-			resp = tmp
-		}
+// encapsulate6 re-encapsulates the response to a relayed DHCPv6 request, nil
+// if it fails
+func encapsulate6(d, resp dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
+	if !d.IsRelay() {
+		return resp
+	}
+	rmsg, ok := resp.(*dhcpv6.Message)
+	if !ok {
+		log.Warningf("DHCPv6: response is a relayed message, not reencapsulating")
+		return resp
 	}
+	tmp, err := dhcpv6.NewRelayReplFromRelayForw(d.(*dhcpv6.RelayMessage), rmsg)
+	if err != nil {
+		log.Warningf("DHCPv6: cannot create relay-repl from relay-forw: %v", err)
+		return nil
+	}
+	return tmp
+}
 
-	var woob *ipv6.ControlMessage
+// replyOOB6 returns the control message of the reply to a DHCPv6 request,
+// given the one of the request. A request sent to a unicast address (eg. by a
+// relay, or a client told to with the Server Unicast option) is answered from
+// it, as its sender expects. Multicast ones are answered from an address the
+// kernel selects, the link-local address of the interface for the link-local
+// peers
+func (l *listener6) replyOOB6(oob *ipv6.ControlMessage, peer *net.UDPAddr) *ipv6.ControlMessage {
+	var woob ipv6.ControlMessage
+	if oob != nil && oob.Dst != nil && !oob.Dst.IsMulticast() && !oob.Dst.IsUnspecified() {
+		woob.Src = oob.Dst
+	}
 	if peer.IP.IsLinkLocalUnicast() {
 		// LL need to be directed to the correct interface. Globally reachable
 		// addresses should use the default route, in case of asymetric routing.
 		switch {
 		case l.Interface.Index != 0:
-			woob = &ipv6.ControlMessage{IfIndex: l.Interface.Index}
+			woob.IfIndex = l.Interface.Index
 		case oob != nil && oob.IfIndex != 0:
-			woob = &ipv6.ControlMessage{IfIndex: oob.IfIndex}
+			woob.IfIndex = oob.IfIndex
 		default:
 			log.Errorf("HandleMsg6: Did not receive interface information")
 		}
 	}
-	if _, err := l.WriteTo(resp.ToBytes(), woob, peer); err != nil {
+	if woob.Src == nil && woob.IfIndex == 0 {
+		return nil
+	}
+	return &woob
+}
+
+// reply6 sends a response to a DHCPv6 request
+func (l *listener6) reply6(resp dhcpv6.DHCPv6, oob *ipv6.ControlMessage, peer *net.UDPAddr) {
+	if _, err := l.WriteTo(resp.ToBytes(), l.replyOOB6(oob, peer), peer); err != nil {
 		log.Printf("MainHandler6: conn.Write to %v failed: %v", peer, err)
 	}
 }
 
 func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.Addr) {
-	var (
-		resp, tmp *dhcpv4.DHCPv4
-		err       error
-		stop      bool
-	)
-
 	req, err := dhcpv4.FromBytes(buf)
-	bufpool.Put(&buf)
 	if err != nil {
+		bufpool.Put(&buf)
 		log.Printf("Error parsing DHCPv4 request: %v", err)
 		return
 	}
 
-	if req.OpCode != dhcpv4.OpcodeBootRequest {
-		log.Printf("MainHandler4: unsupported opcode %d. Only BootRequest (%d) is supported", req.OpCode, dhcpv4.OpcodeBootRequest)
-		return
-	}
-	tmp, err = dhcpv4.NewReplyFromRequest(req)
-	if err != nil {
-		log.Printf("MainHandler4: failed to build reply: %v", err)
-		return
-	}
-	switch mt := req.MessageType(); mt {
-	case dhcpv4.MessageTypeDiscover:
-		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
-	case dhcpv4.MessageTypeRequest:
-		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
-	default:
-		log.Printf("plugins/server: Unhandled message type: %v", mt)
-		return
-	}
-
-	resp = tmp
-	for _, handler := range l.handlers {
-		resp, stop = handler(req, resp)
-		if stop {
-			break
-		}
-	}
-
+	ctx := handler.NewContext(context.Background())
+	handler.SetRawRequest(ctx, buf)
+	resp := process4(ctx, req, l.handlers, l.rapidCommit, l.authoritative)
+	bufpool.Put(&buf)
 	if resp != nil {
-		useEthernet := false
-		var peer *net.UDPAddr
-		if !req.GatewayIPAddr.IsUnspecified() {
-			// TODO: make RFC8357 compliant
-			peer = &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
-		} else if resp.MessageType() == dhcpv4.MessageTypeNak {
-			peer = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
-		} else if !req.ClientIPAddr.IsUnspecified() {
-			peer = &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
-		} else if req.IsBroadcast() {
-			peer = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
-		} else {
-			//sends a layer2 frame so that we can define the destination MAC address
-			peer = &net.UDPAddr{IP: resp.YourIPAddr, Port: dhcpv4.ClientPort}
-			useEthernet = true
+		handler.FinishReply4(ctx, resp)
+		mode, ip := replyTo(req, resp, l.reply, canSendEthernet)
+		peer := &net.UDPAddr{IP: ip, Port: dhcpv4.ClientPort}
+		if mode == replyRelay {
+			peer.Port = dhcpv4.ServerPort
 		}
+		// Layer 2 frames define the destination MAC address
+		useEthernet := mode == replyL2
 
 		var woob *ipv4.ControlMessage
 		if peer.IP.Equal(net.IPv4bcast) || peer.IP.IsLinkLocalUnicast() || useEthernet {
@@ -178,20 +194,257 @@ func (l *listener4) HandleMsg4(buf []byte, oob *ipv4.ControlMessage, _peer net.A
 				log.Errorf("MainHandler4: Can not get Interface for index %d %v", woob.IfIndex, err)
 				return
 			}
-			err = sendEthernet(*intf, resp)
-			if err != nil {
-				log.Errorf("MainHandler4: Cannot send Ethernet packet: %v", err)
-			}
-		} else {
-			if _, err := l.WriteTo(resp.ToBytes(), woob, peer); err != nil {
-				log.Errorf("MainHandler4: conn.Write to %v failed: %v", peer, err)
+			// Through the UDP socket once the ARP cache knows the client
+			if !l.addARP(intf.Name, peer.IP, resp.ClientHWAddr) {
+				if err := sendEthernet(*intf, peer.IP, resp); err != nil {
+					log.Errorf("MainHandler4: Cannot send Ethernet packet: %v", err)
+				}
+				return
 			}
 		}
+		out := bufpool.Get().(*[]byte)
+		if _, err := l.WriteTo(appendReply4((*out)[:0], resp), woob, peer); err != nil {
+			log.Errorf("MainHandler4: conn.Write to %v failed: %v", peer, err)
+		}
+		bufpool.Put(out)
 	} else {
 		log.Print("MainHandler4: dropping request because response is nil")
 	}
 }
 
+// process4 runs a DHCPv4 request through the given plugin chain, with the
+// context of the request, and returns the reply, or nil if there should be
+// none. It is shared by the DHCPv4
+// listener and the DHCPv4-over-DHCPv6 (RFC7341) handling of the v6 listener.
+// With rapidCommit, a DISCOVER requesting it is answered with an ACK directly.
+// With authoritative, a REQUEST for an address the client may not use is
+// answered with a NAK rather than ignored
+func process4(ctx context.Context, req *dhcpv4.DHCPv4, handlers []handler.Handler4Ctx, rapidCommit, authoritative bool) *dhcpv4.DHCPv4 {
+	var (
+		resp, tmp *dhcpv4.DHCPv4
+		stop      bool
+	)
+
+	if req.OpCode != dhcpv4.OpcodeBootRequest {
+		log.Printf("MainHandler4: unsupported opcode %d. Only BootRequest (%d) is supported", req.OpCode, dhcpv4.OpcodeBootRequest)
+		return nil
+	}
+	tmp = newReply4(req)
+	mt := messageType4(req)
+	switch mt {
+	case dhcpv4.MessageTypeDiscover:
+		if rapidCommit && req.Options.Has(dhcpv4.OptionRapidCommit) {
+			// RFC4039 §4: commit the lease and ACK right away, the ACK
+			// carrying the Rapid Commit option
+			tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+			tmp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionRapidCommit, nil))
+		} else {
+			tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+		}
+	case dhcpv4.MessageTypeRequest:
+		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeInform:
+		// RFC2131 §4.3.5: the client already has an address (in ciaddr) and
+		// only wants configuration parameters, so reply with an ACK which
+		// option-providing plugins populate as usual
+		tmp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case messageTypeLeaseQuery:
+		// Answered by a plugin (see plugins/leasequery), which sets the
+		// reply message type
+	case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+		// RFC2131 §4.3.3 and §4.3.4: there is no reply, but the plugins get
+		// to see the message to clean up after the client (eg. plugins/ddns)
+	case dhcpv4.MessageTypeNone:
+		// No DHCP message type: this is a plain BOOTP (RFC951) request. The
+		// reply carries no message type either, see sanitizeBOOTPReply
+		log.Debugf("MainHandler4: BOOTP request from %s", req.ClientHWAddr)
+	default:
+		log.Printf("plugins/server: Unhandled message type: %v", mt)
+		return nil
+	}
+
+	resp = tmp
+	for _, handler := range handlers {
+		resp, stop = handler(ctx, req, resp)
+		if stop {
+			break
+		}
+	}
+
+	if resp != nil {
+		switch mt {
+		case dhcpv4.MessageTypeRequest:
+			if messageType4(resp) == dhcpv4.MessageTypeNak {
+				// A plugin refused the request
+				sanitizeNakReply(req, resp)
+			} else if reason := checkRequest(req, resp); reason != "" {
+				handler.Refuse(ctx, handler.ReasonNotLeased)
+				if authoritative {
+					log.Printf("MainHandler4: NAK to %s: %s", req.ClientHWAddr, reason)
+					resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+					resp.UpdateOption(dhcpv4.OptMessage(reason))
+					sanitizeNakReply(req, resp)
+				} else {
+					// RFC2131 §4.3.2: a server without a binding for the
+					// client must remain silent
+					log.Printf("MainHandler4: ignoring request from %s: %s", req.ClientHWAddr, reason)
+					resp = nil
+				}
+			}
+		case dhcpv4.MessageTypeDiscover:
+			if messageType4(resp) == dhcpv4.MessageTypeAck && (resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified()) {
+				// Rapid commit, but no plugin committed an address
+				log.Printf("MainHandler4: no address to commit for rapid commit client %s, dropping", req.ClientHWAddr)
+				handler.Refuse(ctx, handler.ReasonNoAddress)
+				resp = nil
+			}
+		case dhcpv4.MessageTypeInform:
+			sanitizeInformReply(resp)
+		case dhcpv4.MessageTypeNone:
+			if resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+				// BOOTP has no way to say "no", a reply without an address
+				// is useless to the client
+				log.Printf("MainHandler4: no static binding for BOOTP client %s, dropping", req.ClientHWAddr)
+				handler.Refuse(ctx, handler.ReasonNoAddress)
+				resp = nil
+			} else {
+				sanitizeBOOTPReply(resp)
+			}
+		case dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeDecline:
+			resp = nil
+		case messageTypeLeaseQuery:
+			if messageType4(resp) == dhcpv4.MessageTypeNone {
+				log.Printf("MainHandler4: no plugin answered the leasequery from %s, dropping", req.GatewayIPAddr)
+				resp = nil
+			}
+		}
+	}
+
+	if resp == nil || messageType4(resp) == dhcpv4.MessageTypeNak {
+		reportRefusal(ctx, req.ClientHWAddr.String())
+	}
+	return resp
+}
+
+// reportRefusal logs and counts why the request of a client was NAKed or
+// dropped, if a plugin or the core told (see handler.Refuse)
+func reportRefusal(ctx context.Context, client string) {
+	reason, plugin := handler.Refusal(ctx)
+	if reason == "" {
+		return
+	}
+	if plugin == "" {
+		plugin = "server"
+	}
+	metrics.CountRefusal(reason)
+	log.Infof("refused the request of %s: %s, by %s", client, reason, plugin)
+}
+
+// newReply4 builds the reply to a request as dhcpv4.NewReplyFromRequest
+// does, without first generating a random transaction ID to replace it with
+// the one of the request
+func newReply4(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	resp := dhcpv4.DHCPv4{
+		OpCode:        dhcpv4.OpcodeBootReply,
+		HWType:        req.HWType,
+		ClientHWAddr:  req.ClientHWAddr,
+		TransactionID: req.TransactionID,
+		Flags:         req.Flags,
+		ClientIPAddr:  net.IPv4zero,
+		YourIPAddr:    net.IPv4zero,
+		ServerIPAddr:  net.IPv4zero,
+		GatewayIPAddr: req.GatewayIPAddr,
+		Options:       make(dhcpv4.Options),
+	}
+	// RFC3046 §2.2 and RFC6842 §3
+	for _, code := range [...]dhcpv4.OptionCode{dhcpv4.OptionRelayAgentInformation, dhcpv4.OptionClientIdentifier} {
+		if v := req.Options.Get(code); v != nil {
+			resp.Options[code.Code()] = v
+		}
+	}
+	return &resp
+}
+
+// messageType4 returns the message type of a DHCPv4 message as MessageType
+// does, without allocating
+func messageType4(d *dhcpv4.DHCPv4) dhcpv4.MessageType {
+	if v := d.Options[dhcpv4.OptionDHCPMessageType.Code()]; len(v) == 1 {
+		return dhcpv4.MessageType(v[0])
+	}
+	return dhcpv4.MessageTypeNone
+}
+
+// messageTypeLeaseQuery is the DHCPLEASEQUERY message type (RFC4388 §6.1)
+const messageTypeLeaseQuery dhcpv4.MessageType = 10
+
+// sanitizeInformReply enforces RFC2131 §4.3.5 on a reply to a DHCPINFORM,
+// regardless of what the plugins did: no address is allocated, so yiaddr must
+// be zero and no lease time parameters may be sent
+func sanitizeInformReply(resp *dhcpv4.DHCPv4) {
+	resp.YourIPAddr = net.IPv4zero
+	delete(resp.Options, dhcpv4.OptionIPAddressLeaseTime.Code())
+	delete(resp.Options, dhcpv4.OptionRenewTimeValue.Code())
+	delete(resp.Options, dhcpv4.OptionRebindingTimeValue.Code())
+}
+
+// checkRequest returns why the client of a DHCPREQUEST may not use the
+// address it asks for (RFC2131 §4.3.2), or an empty string if it may: it must
+// be the address the plugins leased to the client. The address is the
+// requested IP address option in the SELECTING and INIT-REBOOT states, ciaddr
+// when renewing or rebinding
+func checkRequest(req, resp *dhcpv4.DHCPv4) string {
+	if messageType4(resp) != dhcpv4.MessageTypeAck {
+		return ""
+	}
+	requested := req.RequestedIPAddress()
+	if requested == nil || requested.IsUnspecified() {
+		requested = req.ClientIPAddr
+	}
+	if resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return fmt.Sprintf("no lease for %s", requested)
+	}
+	if requested != nil && !requested.IsUnspecified() && !requested.Equal(resp.YourIPAddr) {
+		return fmt.Sprintf("requested %s, leased %s", requested, resp.YourIPAddr)
+	}
+	return ""
+}
+
+// sanitizeNakReply enforces RFC2131 §4.3.2 and table 3 on a DHCPNAK: no
+// address, and no options but the server identifier, the message and the
+// client identifier. Relayed NAKs are broadcast by the relay
+func sanitizeNakReply(req, resp *dhcpv4.DHCPv4) {
+	resp.ClientIPAddr = net.IPv4zero
+	resp.YourIPAddr = net.IPv4zero
+	resp.ServerIPAddr = net.IPv4zero
+	resp.ServerHostName = ""
+	resp.BootFileName = ""
+	for code := range resp.Options {
+		switch code {
+		case dhcpv4.OptionDHCPMessageType.Code(), dhcpv4.OptionServerIdentifier.Code(), dhcpv4.OptionMessage.Code(), dhcpv4.OptionClientIdentifier.Code():
+		default:
+			delete(resp.Options, code)
+		}
+	}
+	if !req.GatewayIPAddr.IsUnspecified() {
+		resp.SetBroadcast()
+	}
+}
+
+// sanitizeBOOTPReply strips the DHCP-only options from a reply to a BOOTP
+// client (RFC1534 §2): BOOTP bindings have an infinite lease, and a message
+// type would make the reply look like DHCP to the client
+func sanitizeBOOTPReply(resp *dhcpv4.DHCPv4) {
+	for _, code := range []dhcpv4.OptionCode{
+		dhcpv4.OptionDHCPMessageType,
+		dhcpv4.OptionServerIdentifier,
+		dhcpv4.OptionIPAddressLeaseTime,
+		dhcpv4.OptionRenewTimeValue,
+		dhcpv4.OptionRebindingTimeValue,
+	} {
+		delete(resp.Options, code.Code())
+	}
+}
+
 // XXX: performance-wise, Pool may or may not be good (see https://github.com/golang/go/issues/23199)
 // Interface is good for what we want. Maybe "just" trust the GC and we'll be fine ?
 var bufpool = sync.Pool{New: func() interface{} { r := make([]byte, MaxDatagram); return &r }}
@@ -213,7 +466,15 @@ func (l *listener6) Serve() error {
 			log.Printf("Error reading from connection: %v", err)
 			return err
 		}
-		go l.HandleMsg6(b[:n], oob, peer.(*net.UDPAddr))
+		buf, udpPeer := b[:n], peer.(*net.UDPAddr)
+		client := clientKey6(buf)
+		if client == nil {
+			client = udpPeer.IP
+		}
+		if !l.pipeline.submit(client, isSolicit6(buf), func() { l.HandleMsg6(buf, oob, udpPeer) }) {
+			log.Warningf("Too many requests, dropping a request from %s", udpPeer)
+			bufpool.Put(&b)
+		}
 	}
 }
 
@@ -229,6 +490,19 @@ func (l *listener4) Serve() error {
 			log.Printf("Error reading from connection: %v", err)
 			return err
 		}
-		go l.HandleMsg4(b[:n], oob, peer.(*net.UDPAddr))
+		buf := b[:n]
+		if l.guard != nil {
+			if src, ok := peer.(*net.UDPAddr); ok {
+				if reason := l.guard.check(buf, src.IP, time.Now()); reason != "" {
+					log.Debugf("Dropping a request from %s: %s", peer, reason)
+					bufpool.Put(&b)
+					continue
+				}
+			}
+		}
+		if !l.pipeline.submit(clientKey4(buf), isDiscover4(buf), func() { l.HandleMsg4(buf, oob, peer) }) {
+			log.Warningf("Too many requests, dropping a request from %s", peer)
+			bufpool.Put(&b)
+		}
 	}
 }
@@ -0,0 +1,207 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// The requests are handled by a bounded pool of workers. The requests of a
+// client always go to the same worker, which handles them one at a time and
+// in order: retransmissions and concurrent requests of a client cannot race
+// in the plugins, eg. to allocate two addresses, while the requests of
+// different clients are handled concurrently.
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// queueDepth is the number of requests waiting for a worker, beyond which
+// the requests for this worker are dropped
+const queueDepth = 64
+
+// pipeline dispatches the requests to the workers by client
+type pipeline struct {
+	queues []chan func()
+	// shedDepth is the number of requests waiting for a worker beyond which
+	// the requests of new clients for this worker are dropped, 0 if they are
+	// not
+	shedDepth int
+}
+
+// newPipeline starts a pool of workers, 4 per CPU if workers is 0. The
+// requests of new clients are dropped once the queue of their worker is
+// shedThreshold percent full, if not 0, to keep serving the other clients
+func newPipeline(workers, shedThreshold int) *pipeline {
+	if workers <= 0 {
+		workers = 4 * runtime.NumCPU()
+	}
+	p := pipeline{
+		queues:    make([]chan func(), workers),
+		shedDepth: queueDepth * shedThreshold / 100,
+	}
+	for i := range p.queues {
+		q := make(chan func(), queueDepth)
+		p.queues[i] = q
+		go func() {
+			for handle := range q {
+				safely(handle)
+				inflight.RUnlock()
+			}
+		}()
+	}
+	return &p
+}
+
+// safely handles a request, a panic in a plugin dropping the request
+// instead of crashing the server
+func safely(handle func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("panic while handling a request, dropping it: %v\n%s", r, debug.Stack())
+		}
+	}()
+	handle()
+}
+
+// submit queues the handling of a request of a client, it returns false if
+// the request was dropped because the worker of the client is overloaded,
+// which happens earlier to the requests of new clients (DISCOVER, SOLICIT)
+// with load shedding. A queued request counts as in flight, see upgrade.go
+func (p *pipeline) submit(client []byte, newClient bool, handle func()) bool {
+	h := fnv.New32a()
+	_, _ = h.Write(client)
+	q := p.queues[h.Sum32()%uint32(len(p.queues))]
+	if newClient && p.shedDepth > 0 && len(q) >= p.shedDepth {
+		return false
+	}
+	inflight.RLock()
+	select {
+	case q <- handle:
+		return true
+	default:
+		inflight.RUnlock()
+		return false
+	}
+}
+
+// optionsOffset4 is the offset of the options of a raw DHCPv4 message, after
+// the fixed fields and the magic cookie
+const optionsOffset4 = 240
+
+// clientKey4 returns what identifies the client of a raw DHCPv4 message: its
+// client identifier option if any, its hardware address otherwise. The
+// message is not parsed, this runs on the reading goroutine
+func clientKey4(buf []byte) []byte {
+	if len(buf) < optionsOffset4 {
+		return nil
+	}
+	if id := option4(buf, dhcpv4.OptionClientIdentifier); id != nil {
+		return id
+	}
+	hlen := int(buf[2])
+	if hlen > 16 {
+		hlen = 16
+	}
+	return buf[28 : 28+hlen]
+}
+
+// udpPayload returns the UDP payload of an Ethernet frame passed by the
+// filter of the VLAN listeners (see vlan.go), nil if it is truncated
+func udpPayload(frame []byte) []byte {
+	const ethernetLen, udpLen = 14, 8
+	if len(frame) <= ethernetLen {
+		return nil
+	}
+	off := ethernetLen + int(frame[ethernetLen]&0x0f)*4 + udpLen
+	if off > len(frame) {
+		return nil
+	}
+	return frame[off:]
+}
+
+// isDiscover4 returns whether a raw DHCPv4 message is a DISCOVER
+func isDiscover4(buf []byte) bool {
+	mt := option4(buf, dhcpv4.OptionDHCPMessageType)
+	return len(mt) == 1 && dhcpv4.MessageType(mt[0]) == dhcpv4.MessageTypeDiscover
+}
+
+// option4 returns the data of an option of a raw DHCPv4 message, nil if it
+// has none
+func option4(buf []byte, code dhcpv4.OptionCode) []byte {
+	if len(buf) < optionsOffset4 {
+		return nil
+	}
+	for i := optionsOffset4; i < len(buf); {
+		c := buf[i]
+		if c == dhcpv4.OptionPad.Code() {
+			i++
+			continue
+		}
+		if c == dhcpv4.OptionEnd.Code() || i+1 >= len(buf) {
+			break
+		}
+		end := i + 2 + int(buf[i+1])
+		if end > len(buf) {
+			break
+		}
+		if c == code.Code() {
+			return buf[i+2 : end]
+		}
+		i = end
+	}
+	return nil
+}
+
+// clientKey6 returns the client DUID of a raw DHCPv6 message, looking into
+// the relayed messages, nil if it has none
+func clientKey6(buf []byte) []byte {
+	msg := inner6(buf)
+	// Message type and transaction ID
+	const headerLen = 4
+	if len(msg) < headerLen {
+		return nil
+	}
+	return option6(msg[headerLen:], dhcpv6.OptionClientID)
+}
+
+// isSolicit6 returns whether a raw DHCPv6 message is a SOLICIT, relayed or
+// not
+func isSolicit6(buf []byte) bool {
+	msg := inner6(buf)
+	return len(msg) > 0 && dhcpv6.MessageType(msg[0]) == dhcpv6.MessageTypeSolicit
+}
+
+// inner6 returns the innermost message of a raw DHCPv6 message, nil if it is
+// invalid
+func inner6(buf []byte) []byte {
+	for len(buf) > 0 && dhcpv6.MessageType(buf[0]) == dhcpv6.MessageTypeRelayForward {
+		// Message type, hop count, link and peer addresses
+		const headerLen = 34
+		if len(buf) < headerLen {
+			return nil
+		}
+		buf = option6(buf[headerLen:], dhcpv6.OptionRelayMsg)
+	}
+	return buf
+}
+
+// option6 returns the data of the first option of a code in raw DHCPv6
+// options, nil if there is none
+func option6(options []byte, code dhcpv6.OptionCode) []byte {
+	for len(options) >= 4 {
+		end := 4 + int(binary.BigEndian.Uint16(options[2:]))
+		if end > len(options) {
+			return nil
+		}
+		if dhcpv6.OptionCode(binary.BigEndian.Uint16(options)) == code {
+			return options[4:end]
+		}
+		options = options[end:]
+	}
+	return nil
+}
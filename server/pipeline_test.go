@@ -0,0 +1,103 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+func TestRawMessages(t *testing.T) {
+	discover, err := dhcpv4.NewDiscovery(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDiscover4(discover.ToBytes()) {
+		t.Error("DISCOVER not recognized")
+	}
+	if key := clientKey4(discover.ToBytes()); !bytes.Equal(key, clientMAC) {
+		t.Errorf("expected the hardware address as client key, got %x", key)
+	}
+	if isDiscover4(renew4(t).ToBytes()) || isDiscover4(nil) {
+		t.Error("not a DISCOVER")
+	}
+
+	solicit, err := dhcpv6.NewSolicit(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSolicit6(solicit.ToBytes()) || !isSolicit6(relayed.ToBytes()) {
+		t.Error("SOLICIT not recognized")
+	}
+	if !bytes.Equal(clientKey6(relayed.ToBytes()), solicit.Options.ClientID().ToBytes()) {
+		t.Error("expected the client DUID as client key")
+	}
+	solicit.MessageType = dhcpv6.MessageTypeRenew
+	if isSolicit6(solicit.ToBytes()) || isSolicit6(nil) {
+		t.Error("not a SOLICIT")
+	}
+
+	// An Ethernet frame, with an IP header with options
+	payload := discover.ToBytes()
+	frame := append(make([]byte, 14), 0x46)
+	frame = append(frame, make([]byte, 23+8)...)
+	frame = append(frame, payload...)
+	if !bytes.Equal(udpPayload(frame), payload) {
+		t.Error("unexpected UDP payload")
+	}
+	if udpPayload(frame[:20]) != nil {
+		t.Error("expected no payload from a truncated frame")
+	}
+}
+
+func TestShedding(t *testing.T) {
+	p := newPipeline(1, 50)
+	if p.shedDepth != queueDepth/2 {
+		t.Fatalf("expected to shed from %d requests, got %d", queueDepth/2, p.shedDepth)
+	}
+	// Block the worker
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	p.submit(nil, false, func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	for i := 0; i < queueDepth/2; i++ {
+		if !p.submit(nil, true, func() {}) {
+			t.Fatalf("request %d of a new client dropped", i)
+		}
+	}
+	if p.submit(nil, true, func() {}) {
+		t.Error("expected the requests of new clients to be dropped")
+	}
+	for i := queueDepth / 2; i < queueDepth; i++ {
+		if !p.submit(nil, false, func() {}) {
+			t.Fatalf("request %d of a known client dropped", i)
+		}
+	}
+	if p.submit(nil, false, func() {}) {
+		t.Error("expected the requests to be dropped once the queue is full")
+	}
+}
+
+func TestPanickingPlugin(t *testing.T) {
+	p := newPipeline(1, 0)
+	done := make(chan struct{})
+	p.submit(nil, false, func() { panic("malformed option") })
+	p.submit(nil, false, func() { close(done) })
+	// The worker survives the panic
+	<-done
+}
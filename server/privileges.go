@@ -0,0 +1,260 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package server
+
+// When started as root with a user in the configuration, the server binds its
+// sockets, then executes itself again as that user, handing the sockets over
+// as for an upgrade (see upgrade.go). It only keeps the capabilities of the
+// configuration, as ambient capabilities: unlike the capabilities of a
+// thread, they hold for all the threads of the new process. The interfaces
+// matching an interface pattern later are bound by the new process, which
+// needs net_bind_service for it, and replying to a client without an
+// address needs net_raw.
+//
+// Under systemd, the server should rather be started as the user, with its
+// sockets passed by socket activation and the capabilities it needs as
+// AmbientCapabilities, so that it never runs as root.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+var capabilities = map[string]uintptr{
+	config.CapNetBindService: unix.CAP_NET_BIND_SERVICE,
+	config.CapNetRaw:         unix.CAP_NET_RAW,
+	config.CapNetAdmin:       unix.CAP_NET_ADMIN,
+}
+
+// lookupUser returns the IDs of a user and of a group, the primary group of
+// the user if empty, and of the groups of the user
+func lookupUser(name, group string) (uid, gid int, groups []int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, nil, fmt.Errorf("unknown user %s", name)
+		}
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, nil, fmt.Errorf("unknown group %s", group)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+		return uid, gid, []int{gid}, nil
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("cannot list the groups of user %s: %v", name, err)
+	}
+	for _, id := range ids {
+		if n, err := strconv.Atoi(id); err == nil {
+			groups = append(groups, n)
+		}
+	}
+	return uid, gid, groups, nil
+}
+
+// dropPrivileges executes the server again as the user of the configuration,
+// if any and started as root, handing it the sockets bound meanwhile. It
+// does not return on success
+func dropPrivileges(conf *config.Config) error {
+	if conf.User == "" {
+		return nil
+	}
+	uid, gid, groups, err := lookupUser(conf.User, conf.Group)
+	if err != nil {
+		return err
+	}
+	if euid := os.Geteuid(); euid != 0 {
+		if euid == uid {
+			// Started as the user, or executed again already
+			return nil
+		}
+		return fmt.Errorf("cannot run as user %s, not started as root", conf.User)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	names, files, err := bindSockets(conf)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	closeInherited()
+	env := append(os.Environ(), "COREDHCP_SOCKETS="+strings.Join(names, ","))
+
+	log.Infof("Running as user %s (%d:%d), with capabilities %v", conf.User, uid, gid, conf.Capabilities)
+	// The thread executing the server again keeps its capabilities, the
+	// others lose them
+	runtime.LockOSThread()
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("cannot keep the capabilities: %v", err)
+	}
+	// The raw system calls only change the credentials of this thread, which
+	// is enough as it executes the server again, and don't need Go 1.16 like
+	// those of package syscall, which change all the threads
+	if err := unix.Setgroups(groups); err != nil {
+		return fmt.Errorf("cannot set the groups: %v", err)
+	}
+	if err := unix.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("cannot set the group: %v", err)
+	}
+	if err := unix.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("cannot set the user: %v", err)
+	}
+	if err := keepCapabilities(conf.Capabilities); err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, env)
+}
+
+// bindSockets binds the listen addresses and the VLAN interfaces of the
+// configuration, and returns their names and sockets, inheritable. The
+// interface patterns are left to the new process
+func bindSockets(conf *config.Config) (names []string, files []*os.File, err error) {
+	add := func(l listener, err error) error {
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		name, f, err := l.socket()
+		if err != nil {
+			return fmt.Errorf("cannot hand over socket %s: %v", name, err)
+		}
+		files = append(files, f)
+		var fd int
+		// Fd would put the socket in blocking mode
+		cerr := withConn(f, func(raw uintptr) {
+			fd = int(raw)
+			_, err = unix.FcntlInt(raw, unix.F_SETFD, 0)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return fmt.Errorf("cannot hand over socket %s: %v", name, err)
+		}
+		names = append(names, name+"="+strconv.Itoa(fd))
+		return nil
+	}
+	if conf.Server6 != nil {
+		for _, sc := range append([]*config.ServerConfig{conf.Server6}, conf.Server6.Chains...) {
+			for i := range sc.Addresses {
+				if isPattern(sc.Addresses[i].Zone) {
+					continue
+				}
+				if err := add(listen6(&sc.Addresses[i])); err != nil {
+					return names, files, err
+				}
+			}
+		}
+	}
+	if conf.Server4 != nil {
+		for _, sc := range append([]*config.ServerConfig{conf.Server4}, conf.Server4.Chains...) {
+			for i := range sc.Addresses {
+				if isPattern(sc.Addresses[i].Zone) {
+					continue
+				}
+				if err := add(listen4(&sc.Addresses[i])); err != nil {
+					return names, files, err
+				}
+			}
+			for _, vlans := range sc.VLANs {
+				if err := add(listenVLAN(vlans)); err != nil {
+					return names, files, err
+				}
+			}
+		}
+	}
+	return names, files, nil
+}
+
+// withConn runs fn with the descriptor of a file
+func withConn(f *os.File, fn func(fd uintptr)) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return conn.Control(fn)
+}
+
+// keepCapabilities keeps the given capabilities of the current thread, as
+// ambient capabilities, and drops the others
+func keepCapabilities(names []string) error {
+	var data [2]unix.CapUserData
+	for _, name := range names {
+		c := capabilities[name]
+		data[c/32].Effective |= 1 << (c % 32)
+		data[c/32].Permitted |= 1 << (c % 32)
+		data[c/32].Inheritable |= 1 << (c % 32)
+	}
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("cannot set the capabilities: %v", err)
+	}
+	for _, name := range names {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, capabilities[name], 0, 0); err != nil {
+			return fmt.Errorf("cannot keep capability %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// activatedName returns the name of a socket passed by systemd, after the
+// address and the device it is bound to
+func activatedName(fd int) (string, error) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return "", err
+	}
+	if typ, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE); err != nil || typ != unix.SOCK_DGRAM {
+		return "", errors.New("not a datagram socket")
+	}
+	index, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BINDTOIFINDEX)
+	if err != nil {
+		return "", err
+	}
+	if sa, ok := sa.(*unix.SockaddrInet6); ok && index == 0 {
+		index = int(sa.ZoneId)
+	}
+	var device string
+	if index != 0 {
+		ifi, err := net.InterfaceByIndex(index)
+		if err != nil {
+			return "", err
+		}
+		device = ifi.Name
+	}
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return socketName4(&net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port, Zone: device}), nil
+	case *unix.SockaddrInet6:
+		return socketName6(&net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port, Zone: device}), nil
+	}
+	return "", errors.New("not an IP socket")
+}
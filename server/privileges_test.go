@@ -0,0 +1,55 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestInheritedByFd(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	f, err := conn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	name, err := activatedName(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if name != socketName4(local) {
+		t.Errorf("expected socket name %s, got %s", socketName4(local), name)
+	}
+
+	// A duplicate, which the inherited socket closes
+	dup, err := conn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("COREDHCP_SOCKETS", name+"="+strconv.Itoa(int(dup.Fd())))
+	if err := loadInherited(); err != nil {
+		t.Fatal(err)
+	}
+	udp, err := inheritedUDP(name)
+	if err != nil || udp == nil {
+		t.Fatalf("expected inherited socket %s, got %v", name, err)
+	}
+	defer udp.Close()
+	if udp.LocalAddr().String() != local.String() {
+		t.Errorf("unexpected inherited socket %s", udp.LocalAddr())
+	}
+}
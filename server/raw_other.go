@@ -0,0 +1,73 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build !linux
+
+package server
+
+// Outside of Linux, eg. on macOS for lab use, the server runs in UDP-only
+// mode: without packet sockets, the DHCPv4 replies to the clients without an
+// address are broadcast rather than unicast to their hardware address (which
+// RFC2131 §4.1 allows), and the VLAN listeners, the user of the configuration
+// and systemd socket activation are not supported.
+
+import (
+	"errors"
+	"net"
+	"os"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// canSendEthernet tells whether the replies can be sent as Ethernet frames,
+// see sendEthernet
+const canSendEthernet = false
+
+var errUnsupported = errors.New("not supported on this system")
+
+func sendEthernet(iface net.Interface, dstIP net.IP, resp *dhcpv4.DHCPv4) error {
+	return errUnsupported
+}
+
+func (l *listener4) addARP(ifname string, ip net.IP, mac net.HardwareAddr) bool {
+	return false
+}
+
+type listenerVLAN struct {
+	pipeline      *pipeline
+	handlers      []handler.Handler4Ctx
+	rapidCommit   bool
+	authoritative bool
+	reply         config.ReplyPolicy
+}
+
+func listenVLAN(vlans config.VLANs) (*listenerVLAN, error) {
+	return nil, errors.New("DHCPv4: VLAN listeners are only supported on Linux")
+}
+
+func (l *listenerVLAN) Close() error {
+	return nil
+}
+
+func (l *listenerVLAN) Serve() error {
+	return errUnsupported
+}
+
+func (l *listenerVLAN) socket() (string, *os.File, error) {
+	return "", nil, errUnsupported
+}
+
+func dropPrivileges(conf *config.Config) error {
+	if conf.User != "" {
+		return errors.New("running as another user is only supported on Linux")
+	}
+	return nil
+}
+
+func activatedName(fd int) (string, error) {
+	return "", errors.New("socket activation is only supported on Linux")
+}
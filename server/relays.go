@@ -0,0 +1,117 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// The protections against rogue relay agents: the relayed DHCPv4 requests
+// are checked against the trusted relays and rate-limited by giaddr as they
+// are received, before being queued for the workers.
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+// relayIdle is how long the rate of a relay is tracked once it stops sending
+// requests
+const relayIdle = time.Minute
+
+// relayGuard drops the relayed requests of untrusted or flooding relays
+type relayGuard struct {
+	trusted []*net.IPNet
+	// rate is the number of requests per second accepted by giaddr, 0 if
+	// unlimited
+	rate int
+
+	mu        sync.Mutex
+	buckets   map[[4]byte]*bucket
+	lastSweep time.Time
+}
+
+// bucket holds the requests a relay can still send, as a token bucket
+// refilled at the rate of the guard
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRelayGuard returns the guard of a server, nil if it has none
+func newRelayGuard(sc *config.ServerConfig) *relayGuard {
+	if len(sc.Relays) == 0 && sc.RelayRate == 0 {
+		return nil
+	}
+	return &relayGuard{
+		trusted: sc.Relays,
+		rate:    sc.RelayRate,
+		buckets: make(map[[4]byte]*bucket),
+	}
+}
+
+// check returns why a DHCPv4 request received from src is dropped, "" if it
+// is not. The requests not relayed are not checked
+func (g *relayGuard) check(buf []byte, src net.IP, now time.Time) string {
+	if len(buf) < 28 {
+		return ""
+	}
+	var giaddr [4]byte
+	copy(giaddr[:], buf[24:28])
+	if giaddr == [4]byte{} {
+		return ""
+	}
+	if len(g.trusted) > 0 {
+		if !g.trust(src) {
+			return "relayed by an untrusted source " + src.String()
+		}
+		if !g.trust(giaddr[:]) {
+			return "untrusted giaddr " + net.IP(giaddr[:]).String()
+		}
+	}
+	if g.rate > 0 && !g.take(giaddr, now) {
+		return "too many requests from giaddr " + net.IP(giaddr[:]).String()
+	}
+	return ""
+}
+
+func (g *relayGuard) trust(ip net.IP) bool {
+	for _, n := range g.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// take takes a request from the bucket of a relay, and returns whether there
+// was one left
+func (g *relayGuard) take(giaddr [4]byte, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.lastSweep) >= relayIdle {
+		for k, b := range g.buckets {
+			if now.Sub(b.last) >= relayIdle {
+				delete(g.buckets, k)
+			}
+		}
+		g.lastSweep = now
+	}
+	b, ok := g.buckets[giaddr]
+	if !ok {
+		b = &bucket{tokens: float64(g.rate), last: now}
+		g.buckets[giaddr] = b
+	}
+	// Refill, up to a second worth of requests
+	b.tokens += now.Sub(b.last).Seconds() * float64(g.rate)
+	if b.tokens > float64(g.rate) {
+		b.tokens = float64(g.rate)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,68 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+func TestRelayGuard(t *testing.T) {
+	if newRelayGuard(&config.ServerConfig{}) != nil {
+		t.Error("expected no guard without relays nor rate")
+	}
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/24")
+	g := newRelayGuard(&config.ServerConfig{Relays: []*net.IPNet{trusted}, RelayRate: 2})
+
+	req, err := dhcpv4.NewDiscovery(clientMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	relay := net.IPv4(10, 0, 0, 1)
+	// Not relayed
+	if reason := g.check(req.ToBytes(), net.IPv4(192, 168, 0, 10), now); reason != "" {
+		t.Errorf("unexpected drop of a request not relayed: %s", reason)
+	}
+
+	req.GatewayIPAddr = net.IPv4(10, 0, 0, 2)
+	if reason := g.check(req.ToBytes(), net.IPv4(192, 168, 0, 1), now); reason == "" {
+		t.Error("expected the request of an untrusted source to be dropped")
+	}
+	req.GatewayIPAddr = net.IPv4(192, 168, 0, 1)
+	if reason := g.check(req.ToBytes(), relay, now); reason == "" {
+		t.Error("expected the request with an untrusted giaddr to be dropped")
+	}
+
+	req.GatewayIPAddr = net.IPv4(10, 0, 0, 2)
+	for i := 0; i < 2; i++ {
+		if reason := g.check(req.ToBytes(), relay, now); reason != "" {
+			t.Fatalf("unexpected drop of request %d: %s", i, reason)
+		}
+	}
+	if reason := g.check(req.ToBytes(), relay, now); reason == "" {
+		t.Error("expected the requests over the rate to be dropped")
+	}
+	// Other relays have their own rate
+	req.GatewayIPAddr = net.IPv4(10, 0, 0, 3)
+	if reason := g.check(req.ToBytes(), relay, now); reason != "" {
+		t.Errorf("unexpected drop of the request of another relay: %s", reason)
+	}
+	req.GatewayIPAddr = net.IPv4(10, 0, 0, 2)
+	if reason := g.check(req.ToBytes(), relay, now.Add(time.Second/2)); reason != "" {
+		t.Errorf("unexpected drop once the rate allows a request: %s", reason)
+	}
+
+	// Idle relays are forgotten
+	g.check(req.ToBytes(), relay, now.Add(2*relayIdle))
+	if len(g.buckets) != 1 {
+		t.Errorf("expected the idle relays to be swept, got %d", len(g.buckets))
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+// Replayer answers DHCP messages as the server does, without sockets, eg. to
+// replay captured conversations (see the replay package). Only the main
+// plugin chains are loaded, the other chains being selected by the address
+// the messages are received on
+type Replayer struct {
+	l4 *listener4
+	l6 *listener6
+}
+
+// NewReplayer loads the plugins of a configuration. They must have been
+// registered with plugins.RegisterPlugin
+func NewReplayer(config *config.Config) (*Replayer, error) {
+	handlers4, handlers6, err := plugins.LoadPlugins(config)
+	if err != nil {
+		return nil, err
+	}
+	var r Replayer
+	if sc := config.Server4; sc != nil {
+		r.l4 = &listener4{handlers: handlers4, rapidCommit: sc.RapidCommit, authoritative: sc.Authoritative}
+	}
+	if sc := config.Server6; sc != nil {
+		r.l6 = &listener6{handlers: handlers6, rapidCommit: sc.RapidCommit}
+		if sc4 := config.Server4; sc4 != nil {
+			r.l6.handlers4 = handlers4
+			r.l6.rapidCommit4 = sc4.RapidCommit
+			r.l6.authoritative4 = sc4.Authoritative
+		}
+	}
+	return &r, nil
+}
+
+// Handle4 returns the reply to a DHCPv4 message, nil if there is none
+func (r *Replayer) Handle4(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	if r.l4 == nil {
+		return nil
+	}
+	return process4(handler.NewContext(context.Background()), req, r.l4.handlers, r.l4.rapidCommit, r.l4.authoritative)
+}
+
+// Handle6 returns the reply to a DHCPv6 message, nil if there is none
+func (r *Replayer) Handle6(req dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
+	if r.l6 == nil {
+		return nil
+	}
+	return r.l6.process6(handler.NewContext(context.Background()), req)
+}
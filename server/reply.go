@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+// replyMode is how a DHCPv4 reply is sent
+type replyMode int
+
+const (
+	// replyRelay sends the reply to the relay agent, on the server port
+	replyRelay replyMode = iota
+	// replyBroadcast broadcasts the reply on the link
+	replyBroadcast
+	// replyUnicast sends the reply to an address through the routing table
+	replyUnicast
+	// replyL2 sends the reply to the hardware address of the client, as an
+	// Ethernet frame
+	replyL2
+)
+
+// replyTo returns how to send the reply to a DHCPv4 request, and the
+// destination address, following the reply policy. canL2 tells whether the
+// replies can be sent as Ethernet frames, the unicasts to the clients
+// without an address being broadcast otherwise, as RFC2131 §4.1 allows. The
+// broadcast flag of the relayed replies is set under the broadcast policy
+func replyTo(req, resp *dhcpv4.DHCPv4, policy config.ReplyPolicy, canL2 bool) (replyMode, net.IP) {
+	if !req.GatewayIPAddr.IsUnspecified() {
+		// TODO: make RFC8357 compliant
+		if policy == config.ReplyBroadcast {
+			resp.SetBroadcast()
+		}
+		return replyRelay, req.GatewayIPAddr
+	}
+	if messageType4(resp) == dhcpv4.MessageTypeNak || policy == config.ReplyBroadcast {
+		return replyBroadcast, net.IPv4bcast
+	}
+	if !req.ClientIPAddr.IsUnspecified() {
+		if policy == config.ReplyL2 && canL2 {
+			return replyL2, req.ClientIPAddr
+		}
+		return replyUnicast, req.ClientIPAddr
+	}
+	if req.IsBroadcast() && policy != config.ReplyUnicast {
+		return replyBroadcast, net.IPv4bcast
+	}
+	if !canL2 || resp.YourIPAddr.IsUnspecified() {
+		// Nowhere to unicast the reply to
+		return replyBroadcast, net.IPv4bcast
+	}
+	return replyL2, resp.YourIPAddr
+}
@@ -0,0 +1,107 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"golang.org/x/net/ipv6"
+
+	"github.com/coredhcp/coredhcp/config"
+)
+
+func TestReplyTo(t *testing.T) {
+	relay, ciaddr, yiaddr := net.IPv4(10, 0, 1, 1), net.IPv4(10, 0, 0, 41), net.IPv4(10, 0, 0, 42)
+	for _, tc := range []struct {
+		name      string
+		policy    config.ReplyPolicy
+		noL2      bool
+		giaddr    net.IP
+		ciaddr    net.IP
+		broadcast bool
+		nak       bool
+		noYiaddr  bool
+		mode      replyMode
+		ip        net.IP
+	}{
+		{name: "relayed", giaddr: relay, broadcast: true, mode: replyRelay, ip: relay},
+		{name: "nak", ciaddr: ciaddr, nak: true, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "ciaddr", ciaddr: ciaddr, broadcast: true, mode: replyUnicast, ip: ciaddr},
+		{name: "broadcast flag", broadcast: true, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "chaddr", mode: replyL2, ip: yiaddr},
+		{name: "no packet sockets", noL2: true, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "no yiaddr", noYiaddr: true, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "forced broadcast", policy: config.ReplyBroadcast, ciaddr: ciaddr, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "forced broadcast, relayed", policy: config.ReplyBroadcast, giaddr: relay, mode: replyRelay, ip: relay},
+		{name: "forced unicast", policy: config.ReplyUnicast, broadcast: true, mode: replyL2, ip: yiaddr},
+		{name: "forced unicast, nak", policy: config.ReplyUnicast, nak: true, mode: replyBroadcast, ip: net.IPv4bcast},
+		{name: "l2 ciaddr", policy: config.ReplyL2, ciaddr: ciaddr, mode: replyL2, ip: ciaddr},
+		{name: "l2 ciaddr without packet sockets", policy: config.ReplyL2, noL2: true, ciaddr: ciaddr, mode: replyUnicast, ip: ciaddr},
+	} {
+		mt := dhcpv4.MessageTypeAck
+		if tc.nak {
+			mt = dhcpv4.MessageTypeNak
+		}
+		modifiers := []dhcpv4.Modifier{dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithHwAddr(clientMAC)}
+		if tc.giaddr != nil {
+			modifiers = append(modifiers, dhcpv4.WithGatewayIP(tc.giaddr))
+		}
+		if tc.ciaddr != nil {
+			modifiers = append(modifiers, dhcpv4.WithClientIP(tc.ciaddr))
+		}
+		if tc.broadcast {
+			modifiers = append(modifiers, dhcpv4.WithBroadcast(true))
+		}
+		req, err := dhcpv4.New(modifiers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(mt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tc.noYiaddr {
+			resp.YourIPAddr = yiaddr
+		}
+		resp.SetUnicast()
+
+		mode, ip := replyTo(req, resp, tc.policy, !tc.noL2)
+		if mode != tc.mode || !ip.Equal(tc.ip) {
+			t.Errorf("%s: expected %d to %s, got %d to %s", tc.name, tc.mode, tc.ip, mode, ip)
+		}
+		if want := tc.policy == config.ReplyBroadcast && tc.giaddr != nil; resp.IsBroadcast() != want {
+			t.Errorf("%s: expected the broadcast flag of the reply to be %t", tc.name, want)
+		}
+	}
+}
+
+func TestReplyOOB6(t *testing.T) {
+	linkLocal := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}
+	relay := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: dhcpv6.DefaultServerPort}
+	unicast := net.ParseIP("2001:db8::547")
+
+	var l listener6
+	// Multicast requests of clients on the link, from the interface
+	woob := l.replyOOB6(&ipv6.ControlMessage{Dst: dhcpv6.AllDHCPRelayAgentsAndServers, IfIndex: 3}, linkLocal)
+	if woob == nil || woob.Src != nil || woob.IfIndex != 3 {
+		t.Errorf("unexpected control message %v", woob)
+	}
+	// Unicast requests, from their destination
+	woob = l.replyOOB6(&ipv6.ControlMessage{Dst: unicast, IfIndex: 3}, relay)
+	if woob == nil || !woob.Src.Equal(unicast) || woob.IfIndex != 0 {
+		t.Errorf("unexpected control message %v", woob)
+	}
+	if woob = l.replyOOB6(&ipv6.ControlMessage{Dst: dhcpv6.AllDHCPServers}, relay); woob != nil {
+		t.Errorf("expected no control message, got %v", woob)
+	}
+	// The interface of the listener comes first
+	l.Interface.Index = 2
+	if woob = l.replyOOB6(&ipv6.ControlMessage{IfIndex: 3}, linkLocal); woob == nil || woob.IfIndex != 2 {
+		t.Errorf("unexpected control message %v", woob)
+	}
+}
@@ -16,22 +16,68 @@ import (
 	"github.com/insomniacslk/dhcp/dhcpv4"
 )
 
+// canSendEthernet tells whether the replies can be sent as Ethernet frames,
+// see raw_other.go
+const canSendEthernet = true
+
 //this function sends an unicast to the hardware address defined in resp.ClientHWAddr,
-//the layer3 destination address is still the broadcast address;
+//the layer3 destination address is dstIP;
 //iface: the interface where the DHCP message should be sent;
 //resp: DHCPv4 struct, which should be sent;
-func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
+func sendEthernet(iface net.Interface, dstIP net.IP, resp *dhcpv4.DHCPv4) error {
+	data, err := ethernetFrame(iface.HardwareAddr, resp.ClientHWAddr, 0, resp.ServerIPAddr, dstIP, resp)
+	if err != nil {
+		return err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return fmt.Errorf("Send Ethernet: Cannot open socket: %v", err)
+	}
+	defer func() {
+		err = syscall.Close(fd)
+		if err != nil {
+			log.Errorf("Send Ethernet: Cannot close socket: %v", err)
+		}
+	}()
+
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	if err != nil {
+		log.Errorf("Send Ethernet: Cannot set option for socket: %v", err)
+	}
+
+	var hwAddr [8]byte
+	copy(hwAddr[0:6], resp.ClientHWAddr[0:6])
+	ethAddr := syscall.SockaddrLinklayer{
+		Protocol: 0,
+		Ifindex:  iface.Index,
+		Halen:    6,
+		Addr:     hwAddr, //not used
+	}
+	err = syscall.Sendto(fd, data, 0, &ethAddr)
+	if err != nil {
+		return fmt.Errorf("Cannot send frame via socket: %v", err)
+	}
+	return nil
+}
 
+// ethernetFrame builds the Ethernet frame of a DHCPv4 reply, tagged with the
+// VLAN ID if not zero
+func ethernetFrame(srcMAC, dstMAC net.HardwareAddr, vlan uint16, srcIP, dstIP net.IP, resp *dhcpv4.DHCPv4) ([]byte, error) {
 	eth := layers.Ethernet{
 		EthernetType: layers.EthernetTypeIPv4,
-		SrcMAC:       iface.HardwareAddr,
-		DstMAC:       resp.ClientHWAddr,
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+	}
+	dot1q := layers.Dot1Q{
+		VLANIdentifier: vlan,
+		Type:           layers.EthernetTypeIPv4,
 	}
 	ip := layers.IPv4{
 		Version:  4,
 		TTL:      64,
-		SrcIP:    resp.ServerIPAddr,
-		DstIP:    resp.YourIPAddr,
+		SrcIP:    srcIP,
+		DstIP:    dstIP,
 		Protocol: layers.IPProtocolUDP,
 		Flags:    layers.IPv4DontFragment,
 	}
@@ -42,7 +88,7 @@ func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
 
 	err := udp.SetNetworkLayerForChecksum(&ip)
 	if err != nil {
-		return fmt.Errorf("Send Ethernet: Couldn't set network layer: %v", err)
+		return nil, fmt.Errorf("Send Ethernet: Couldn't set network layer: %v", err)
 	}
 
 	buf := gopacket.NewSerializeBuffer()
@@ -56,41 +102,16 @@ func sendEthernet(iface net.Interface, resp *dhcpv4.DHCPv4) error {
 	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
 	dhcp, ok := dhcpLayer.(gopacket.SerializableLayer)
 	if !ok {
-		return fmt.Errorf("Layer %s is not serializable", dhcpLayer.LayerType().String())
-	}
-	err = gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, dhcp)
-	if err != nil {
-		return fmt.Errorf("Cannot serialize layer: %v", err)
-	}
-	data := buf.Bytes()
-
-	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
-	if err != nil {
-		return fmt.Errorf("Send Ethernet: Cannot open socket: %v", err)
-	}
-	defer func() {
-		err = syscall.Close(fd)
-		if err != nil {
-			log.Errorf("Send Ethernet: Cannot close socket: %v", err)
-		}
-	}()
-
-	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
-	if err != nil {
-		log.Errorf("Send Ethernet: Cannot set option for socket: %v", err)
+		return nil, fmt.Errorf("Layer %s is not serializable", dhcpLayer.LayerType().String())
 	}
-
-	var hwAddr [8]byte
-	copy(hwAddr[0:6], resp.ClientHWAddr[0:6])
-	ethAddr := syscall.SockaddrLinklayer{
-		Protocol: 0,
-		Ifindex:  iface.Index,
-		Halen:    6,
-		Addr:     hwAddr, //not used
+	if vlan != 0 {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		err = gopacket.SerializeLayers(buf, opts, &eth, &dot1q, &ip, &udp, dhcp)
+	} else {
+		err = gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, dhcp)
 	}
-	err = syscall.Sendto(fd, data, 0, &ethAddr)
 	if err != nil {
-		return fmt.Errorf("Cannot send frame via socket: %v", err)
+		return nil, fmt.Errorf("Cannot serialize layer: %v", err)
 	}
-	return nil
+	return buf.Bytes(), nil
 }
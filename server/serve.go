@@ -5,9 +5,15 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -25,32 +31,68 @@ var log = logger.GetLogger("server")
 type listener6 struct {
 	*ipv6.PacketConn
 	net.Interface
-	handlers []handler.Handler6
+	// udp is the socket of PacketConn, handed over on upgrades, see
+	// upgrade.go
+	udp         *net.UDPConn
+	pipeline    *pipeline
+	handlers    []handler.Handler6Ctx
+	rapidCommit bool
+	// handlers4, rapidCommit4 and authoritative4 are the DHCPv4 settings,
+	// used for DHCPv4-over-DHCPv6
+	handlers4      []handler.Handler4Ctx
+	rapidCommit4   bool
+	authoritative4 bool
 }
 
 type listener4 struct {
 	*ipv4.PacketConn
 	net.Interface
-	handlers []handler.Handler4
+	// udp is the socket of PacketConn, handed over on upgrades, see
+	// upgrade.go
+	udp           *net.UDPConn
+	pipeline      *pipeline
+	handlers      []handler.Handler4Ctx
+	rapidCommit   bool
+	authoritative bool
+	reply         config.ReplyPolicy
+	// noARP is set once adding ARP entries was denied, see arp.go
+	noARP int32
+	// guard drops the requests of untrusted relays, if any
+	guard *relayGuard
 }
 
 type listener interface {
 	io.Closer
+	Serve() error
+	// socket returns the name and a duplicate of the listening socket
+	socket() (string, *os.File, error)
 }
 
 // Servers contains state for a running server (with possibly multiple interfaces/listeners)
 type Servers struct {
 	listeners []listener
 	errors    chan error
+	// done is closed to stop the watchers of the interface patterns, see
+	// watch.go
+	done      chan struct{}
+	closeOnce sync.Once
+	// cancel stops the background work of the plugins, see
+	// plugins.Start
+	cancel   context.CancelFunc
+	stopOnce sync.Once
 }
 
 func listen4(a *net.UDPAddr) (*listener4, error) {
 	var err error
 	l4 := listener4{}
-	udpConn, err := server4.NewIPv4UDPConn(a.Zone, a)
+	udpConn, err := inheritedUDP(socketName4(a))
+	if udpConn == nil && err == nil {
+		udpConn, err = server4.NewIPv4UDPConn(a.Zone, a)
+	}
 	if err != nil {
 		return nil, err
 	}
+	l4.udp = udpConn
 	l4.PacketConn = ipv4.NewPacketConn(udpConn)
 	var ifi *net.Interface
 	if a.Zone != "" {
@@ -80,16 +122,23 @@ func listen4(a *net.UDPAddr) (*listener4, error) {
 
 func listen6(a *net.UDPAddr) (*listener6, error) {
 	l6 := listener6{}
-	udpconn, err := server6.NewIPv6UDPConn(a.Zone, a)
+	udpconn, err := inheritedUDP(socketName6(a))
+	// An inherited socket already joined its multicast group, unless
+	// passed by systemd
+	inherited := udpconn != nil && !activated[socketName6(a)]
+	if udpconn == nil && err == nil {
+		udpconn, err = server6.NewIPv6UDPConn(a.Zone, a)
+	}
 	if err != nil {
 		return nil, err
 	}
+	l6.udp = udpconn
 	l6.PacketConn = ipv6.NewPacketConn(udpconn)
 	var ifi *net.Interface
 	if a.Zone != "" {
 		ifi, err = net.InterfaceByName(a.Zone)
 		if err != nil {
-			return nil, fmt.Errorf("DHCPv4: Listen could not find interface %s: %v", a.Zone, err)
+			return nil, fmt.Errorf("DHCPv6: Listen could not find interface %s: %v", a.Zone, err)
 		}
 		l6.Interface = *ifi
 	} else {
@@ -100,8 +149,13 @@ func listen6(a *net.UDPAddr) (*listener6, error) {
 			return nil, err
 		}
 	}
+	// The destination of the requests is the source of the replies, see
+	// reply6
+	if err = l6.SetControlMessage(ipv6.FlagDst, true); err != nil {
+		return nil, err
+	}
 
-	if a.IP.IsMulticast() {
+	if a.IP.IsMulticast() && !inherited {
 		err = l6.JoinGroup(ifi, a)
 		if err != nil {
 			return nil, err
@@ -113,47 +167,75 @@ func listen6(a *net.UDPAddr) (*listener6, error) {
 // Start will start the server asynchronously. See `Wait` to wait until
 // the execution ends.
 func Start(config *config.Config) (*Servers, error) {
+	if err := loadInherited(); err != nil {
+		return nil, err
+	}
+	// Before the plugins, whose files must belong to the user
+	if err := dropPrivileges(config); err != nil {
+		return nil, err
+	}
 	handlers4, handlers6, err := plugins.LoadPlugins(config)
 	if err != nil {
 		return nil, err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	srv := Servers{
 		errors: make(chan error),
+		done:   make(chan struct{}),
+		cancel: cancel,
 	}
 
 	// listen
 	if config.Server6 != nil {
 		log.Println("Starting DHCPv6 server")
-		for _, addr := range config.Server6.Addresses {
-			var l6 *listener6
-			l6, err = listen6(&addr)
-			if err != nil {
+		// The chains share the workers, a client being served by a single
+		// chain anyway
+		p6 := newPipeline(config.Server6.Workers, config.Server6.ShedThreshold)
+		// DHCPv4-over-DHCPv6 is handled by the main DHCPv4 chain, with its
+		// policy
+		var h4o6 []handler.Handler4Ctx
+		if config.Server4 != nil {
+			h4o6 = validate4(config.Server4.Malformed, handlers4)
+		}
+		h6 := validate6(config.Server6.Malformed, dedup6(config.Server6.DedupWindow, handlers6))
+		if err = srv.start6(config.Server6, h6, config.Server4, h4o6, p6); err != nil {
+			goto cleanup
+		}
+		for _, chain := range config.Server6.Chains {
+			var h6 []handler.Handler6Ctx
+			if h6, err = plugins.LoadPlugins6(chain); err != nil {
+				goto cleanup
+			}
+			if err = srv.start6(chain, validate6(chain.Malformed, dedup6(chain.DedupWindow, h6)), config.Server4, h4o6, p6); err != nil {
 				goto cleanup
 			}
-			l6.handlers = handlers6
-			srv.listeners = append(srv.listeners, l6)
-			go func() {
-				srv.errors <- l6.Serve()
-			}()
 		}
 	}
 
 	if config.Server4 != nil {
 		log.Println("Starting DHCPv4 server")
-		for _, addr := range config.Server4.Addresses {
-			var l4 *listener4
-			l4, err = listen4(&addr)
-			if err != nil {
+		p4 := newPipeline(config.Server4.Workers, config.Server4.ShedThreshold)
+		h4 := validate4(config.Server4.Malformed, dedup4(config.Server4.DedupWindow, handlers4))
+		if err = srv.start4(config.Server4, h4, p4); err != nil {
+			goto cleanup
+		}
+		for _, chain := range config.Server4.Chains {
+			var h4 []handler.Handler4Ctx
+			if h4, err = plugins.LoadPlugins4(chain); err != nil {
+				goto cleanup
+			}
+			if err = srv.start4(chain, validate4(chain.Malformed, dedup4(chain.DedupWindow, h4)), p4); err != nil {
 				goto cleanup
 			}
-			l4.handlers = handlers4
-			srv.listeners = append(srv.listeners, l4)
-			go func() {
-				srv.errors <- l4.Serve()
-			}()
 		}
 	}
 
+	if err = plugins.Start(ctx); err != nil {
+		goto cleanup
+	}
+	closeInherited()
+	notifyReady()
+	sdNotify("READY=1")
 	return &srv, nil
 
 cleanup:
@@ -161,16 +243,137 @@ cleanup:
 	return nil, err
 }
 
-// Wait waits until the end of the execution of the server.
+// start6 starts the listeners of a DHCPv6 plugin chain. DHCPv4-over-DHCPv6 is
+// handled by the main DHCPv4 chain, if any
+func (s *Servers) start6(sc *config.ServerConfig, handlers6 []handler.Handler6Ctx, sc4 *config.ServerConfig, handlers4 []handler.Handler4Ctx, p *pipeline) error {
+	open := func(addr *net.UDPAddr) (listener, error) {
+		l6, err := listen6(addr)
+		if err != nil {
+			return nil, err
+		}
+		l6.pipeline = p
+		l6.handlers = handlers6
+		l6.rapidCommit = sc.RapidCommit
+		if sc4 != nil {
+			l6.handlers4 = handlers4
+			l6.rapidCommit4 = sc4.RapidCommit
+			l6.authoritative4 = sc4.Authoritative
+		}
+		return l6, nil
+	}
+	return s.startAll(sc.Addresses, open)
+}
+
+// start4 starts the listeners of a DHCPv4 plugin chain
+func (s *Servers) start4(sc *config.ServerConfig, handlers4 []handler.Handler4Ctx, p *pipeline) error {
+	// The listeners of a chain share the rates of the relays
+	guard := newRelayGuard(sc)
+	open := func(addr *net.UDPAddr) (listener, error) {
+		l4, err := listen4(addr)
+		if err != nil {
+			return nil, err
+		}
+		l4.pipeline = p
+		l4.handlers = handlers4
+		l4.rapidCommit = sc.RapidCommit
+		l4.authoritative = sc.Authoritative
+		l4.reply = sc.Reply
+		l4.guard = guard
+		return l4, nil
+	}
+	for _, vlans := range sc.VLANs {
+		l, err := listenVLAN(vlans)
+		if err != nil {
+			return err
+		}
+		l.pipeline = p
+		l.handlers = handlers4
+		l.rapidCommit = sc.RapidCommit
+		l.authoritative = sc.Authoritative
+		l.reply = sc.Reply
+		s.listeners = append(s.listeners, l)
+		go func() {
+			s.errors <- l.Serve()
+		}()
+	}
+	return s.startAll(sc.Addresses, open)
+}
+
+// startAll starts listening on addresses, the ones with an interface pattern
+// are watched for the matching interfaces
+func (s *Servers) startAll(addrs []net.UDPAddr, open func(*net.UDPAddr) (listener, error)) error {
+	for _, addr := range addrs {
+		if isPattern(addr.Zone) {
+			s.watch(addr, open)
+			continue
+		}
+		l, err := open(&addr)
+		if err != nil {
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+		go func() {
+			s.errors <- l.Serve()
+		}()
+	}
+	return nil
+}
+
+// Wait waits until the end of the execution of the server. SIGTERM and SIGINT
+// stop it gracefully, SIGUSR2 hands it over to a new process (see
+// upgrade.go), SIGHUP reloads the plugins (see plugins.Reload)
 func (s *Servers) Wait() error {
 	log.Debug("Waiting")
-	err := <-s.errors
-	s.Close()
-	return err
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR2, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	// The watchdog of systemd, if enabled, see systemd.go
+	var watchdog <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	for {
+		select {
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+		case err := <-s.errors:
+			sdNotify("STOPPING=1")
+			s.Shutdown(drainTimeout)
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				sdNotify("RELOADING=1")
+				if err := plugins.Reload(); err != nil {
+					log.Error(err)
+				}
+				sdNotify("READY=1")
+				continue
+			}
+			if sig == syscall.SIGUSR2 {
+				if err := s.Upgrade(); err != nil {
+					log.Errorf("Upgrade failed, keeping on serving: %v", err)
+					continue
+				}
+				log.Info("The new process took over, draining")
+			} else {
+				log.Infof("Received %v, draining", sig)
+			}
+			sdNotify("STOPPING=1")
+			s.Shutdown(drainTimeout)
+			return nil
+		}
+	}
 }
 
-// Close closes all listening connections
+// Close closes all listening connections, and stops the background work of
+// the plugins
 func (s *Servers) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.cancel()
+	})
 	for _, srv := range s.listeners {
 		if srv != nil {
 			srv.Close()
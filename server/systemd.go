@@ -0,0 +1,50 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// Under systemd, with Type=notify, the server tells systemd when it is ready,
+// ie. listening with its plugins started, so that the units ordered after it
+// start once it serves, and when it stops or reloads. With WatchdogSec= set,
+// the main loop pings the watchdog at half its interval: systemd restarts the
+// server if it hangs. After an upgrade (see upgrade.go), the new process is
+// the main process, which needs NotifyAccess=all.
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state to the notification socket of systemd, if any
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	// An abstract socket, starting with @, is handled by net
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Warningf("Could not notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Warningf("Could not notify systemd: %v", err)
+	}
+}
+
+// watchdogInterval returns the interval at which the systemd watchdog must be
+// pinged, 0 if it is not enabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,58 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredhcp-systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sdNotify("READY=1")
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got %q", buf[:n])
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	if d := watchdogInterval(); d != 0 {
+		t.Errorf("expected no watchdog, got %v", d)
+	}
+	os.Setenv("WATCHDOG_USEC", "10000000")
+	if d := watchdogInterval(); d != 5*time.Second {
+		t.Errorf("expected a 5s interval, got %v", d)
+	}
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if d := watchdogInterval(); d != 0 {
+		t.Errorf("expected no watchdog for another process, got %v", d)
+	}
+}
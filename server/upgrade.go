@@ -0,0 +1,266 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// On SIGUSR2, the server starts a new process of the same program, eg. an
+// upgraded binary, and hands it its listening sockets: the new process keeps
+// on receiving on them, including the requests queued meanwhile, so none are
+// lost. Once it is ready, the old process stops listening, finishes the
+// requests it is handling, and exits. If the new process fails to start, the
+// old one keeps on serving.
+//
+// The sockets are passed as the file descriptors following stderr, named in
+// order in the COREDHCP_SOCKETS environment variable, or as <name>=<fd> when
+// at other descriptors (see privileges.go). The new process tells it is
+// ready by writing to the file descriptor in COREDHCP_READY_FD.
+//
+// The sockets passed by systemd socket activation (LISTEN_FDS) are inherited
+// the same way, named after the address and device they are bound to, so
+// that the server never needs to bind them itself.
+// The listeners of interface patterns (see watch.go) are not handed over,
+// the new process binds to the interfaces again.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredhcp/coredhcp/plugins"
+)
+
+const (
+	// readyTimeout bounds how long the new process takes to start
+	readyTimeout = 30 * time.Second
+	// drainTimeout bounds how long the requests being handled are waited
+	// for when stopping
+	drainTimeout = 5 * time.Second
+)
+
+// inflight is held for reading by the requests being handled or queued for
+// a worker, see pipeline.go
+var inflight sync.RWMutex
+
+// inherited holds the sockets handed over by the previous process, by name,
+// until they are used
+var inherited map[string]*os.File
+
+// activated holds the names of the inherited sockets passed by systemd, which
+// did not join their multicast group
+var activated map[string]bool
+
+func socketName4(a *net.UDPAddr) string {
+	return "udp4:" + a.String()
+}
+
+func socketName6(a *net.UDPAddr) string {
+	return "udp6:" + a.String()
+}
+
+func socketNameVLAN(iface string) string {
+	return "packet:" + iface
+}
+
+// loadInherited reads the sockets handed over by the previous process, if
+// any
+func loadInherited() error {
+	names := os.Getenv("COREDHCP_SOCKETS")
+	os.Unsetenv("COREDHCP_SOCKETS")
+	inherited = make(map[string]*os.File)
+	activated = make(map[string]bool)
+	if err := loadActivated(); err != nil {
+		return err
+	}
+	if names != "" {
+		for i, name := range strings.Split(names, ",") {
+			fd := 3 + i
+			if sep := strings.LastIndexByte(name, '='); sep >= 0 {
+				n, err := strconv.Atoi(name[sep+1:])
+				if err != nil {
+					return fmt.Errorf("invalid inherited socket %q", name)
+				}
+				name, fd = name[:sep], n
+			}
+			inherited[name] = os.NewFile(uintptr(fd), name)
+		}
+	}
+	if len(inherited) > 0 {
+		log.Infof("Inherited %d sockets", len(inherited))
+	}
+	return nil
+}
+
+// loadActivated reads the sockets passed by systemd socket activation, if
+// any
+func loadActivated() error {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+	if pid != os.Getpid() {
+		return nil
+	}
+	for fd := 3; fd < 3+count; fd++ {
+		name, err := activatedName(fd)
+		if err != nil {
+			return fmt.Errorf("invalid activated socket %d: %v", fd, err)
+		}
+		inherited[name] = os.NewFile(uintptr(fd), name)
+		activated[name] = true
+	}
+	return nil
+}
+
+// inheritedUDP returns the inherited UDP socket of the given name, nil if
+// there is none
+func inheritedUDP(name string) (*net.UDPConn, error) {
+	f := inherited[name]
+	if f == nil {
+		return nil, nil
+	}
+	delete(inherited, name)
+	defer f.Close()
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inherited socket %s: %v", name, err)
+	}
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("invalid inherited socket %s: not UDP", name)
+	}
+	return udp, nil
+}
+
+// closeInherited closes the inherited sockets not listened to anymore, eg.
+// after a configuration change
+func closeInherited() {
+	for name, f := range inherited {
+		log.Infof("Closing unused inherited socket %s", name)
+		f.Close()
+	}
+	inherited = nil
+}
+
+// notifyReady tells the previous process that this one is ready, if started
+// by an upgrade
+func notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv("COREDHCP_READY_FD"))
+	os.Unsetenv("COREDHCP_READY_FD")
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Warningf("Could not notify the previous process: %v", err)
+	}
+	f.Close()
+}
+
+func (l *listener4) socket() (string, *os.File, error) {
+	f, err := l.udp.File()
+	return socketName4(l.udp.LocalAddr().(*net.UDPAddr)), f, err
+}
+
+func (l *listener6) socket() (string, *os.File, error) {
+	f, err := l.udp.File()
+	return socketName6(l.udp.LocalAddr().(*net.UDPAddr)), f, err
+}
+
+// Upgrade starts a new process of the program, hands it the listening
+// sockets, and waits until it is ready. The server must then be shut down
+func (s *Servers) Upgrade() error {
+	var (
+		names []string
+		files []*os.File
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range s.listeners {
+		name, f, err := l.socket()
+		if err != nil {
+			return fmt.Errorf("cannot hand over socket %s: %v", name, err)
+		}
+		names = append(names, name)
+		files = append(files, f)
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		"COREDHCP_SOCKETS="+strings.Join(names, ","),
+		"COREDHCP_READY_FD="+strconv.Itoa(3+len(files)),
+		// The new process pings the watchdog of systemd, see systemd.go
+		"WATCHDOG_PID=",
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("Started new process %d", cmd.Process.Pid)
+
+	result := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, err := ready.Read(b); err != nil {
+			// The new process exited without being ready
+			result <- errors.New("the new process failed to start")
+			return
+		}
+		result <- nil
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(readyTimeout):
+		err = errors.New("timed out waiting for the new process")
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		go func() { _ = cmd.Wait() }()
+		return err
+	}
+	// The new process is adopted by init once this one exits
+	go func() { _ = cmd.Wait() }()
+	sdNotify("MAINPID=" + strconv.Itoa(cmd.Process.Pid))
+	return nil
+}
+
+// Shutdown stops listening, waits for the requests being handled, up to
+// timeout, and stops the plugins
+func (s *Servers) Shutdown(timeout time.Duration) {
+	defer s.stopOnce.Do(plugins.Stop)
+	s.Close()
+	done := make(chan struct{})
+	go func() {
+		inflight.Lock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warningf("Gave up waiting for the requests being handled after %v", timeout)
+	}
+}
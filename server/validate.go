@@ -0,0 +1,305 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// The sanity checks of the requests, before the plugins, which parse some
+// options themselves (eg. the PXE vendor options) and should not have to
+// guard against every malformed packet. What the server does with the
+// malformed requests is configured by their policy (see config.MalformedPolicy)
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+const (
+	// maxHops4 is the hop count beyond which a DHCPv4 request is looping
+	// between relays (RFC1542 §4.1.1)
+	maxHops4 = 16
+	// maxHops6 is the maximum hop count of a DHCPv6 relay message
+	// (RFC8415 §7.6)
+	maxHops6 = 32
+	// maxNameLength is the maximum length of a host or domain name
+	maxNameLength = 255
+)
+
+// problem is a failed sanity check of a request
+type problem struct {
+	// option is the code of the malformed option, 0 if the request itself
+	// is malformed and cannot be fixed by removing an option
+	option uint16
+	reason string
+}
+
+func (p problem) String() string {
+	if p.option == 0 {
+		return p.reason
+	}
+	return fmt.Sprintf("option %d: %s", p.option, p.reason)
+}
+
+// fixedLength4 are the lengths of the DHCPv4 options of fixed length
+var fixedLength4 = map[dhcpv4.OptionCode]int{
+	dhcpv4.OptionSubnetMask:             4,
+	dhcpv4.OptionRequestedIPAddress:     4,
+	dhcpv4.OptionIPAddressLeaseTime:     4,
+	dhcpv4.OptionDHCPMessageType:        1,
+	dhcpv4.OptionServerIdentifier:       4,
+	dhcpv4.OptionMaximumDHCPMessageSize: 2,
+	dhcpv4.OptionRenewTimeValue:         4,
+	dhcpv4.OptionRebindingTimeValue:     4,
+}
+
+// check4 returns the problems of a DHCPv4 request
+func check4(req *dhcpv4.DHCPv4) []problem {
+	var problems []problem
+	bad := func(code dhcpv4.OptionCode, format string, args ...interface{}) {
+		problems = append(problems, problem{option: uint16(code.Code()), reason: fmt.Sprintf(format, args...)})
+	}
+	if req.HopCount > maxHops4 {
+		problems = append(problems, problem{reason: fmt.Sprintf("hop count %d over %d", req.HopCount, maxHops4)})
+	}
+	for code, length := range fixedLength4 {
+		if v, ok := req.Options[code.Code()]; ok && len(v) != length {
+			bad(code, "length %d, expected %d", len(v), length)
+		}
+	}
+	if v := req.Options.Get(dhcpv4.OptionMaximumDHCPMessageSize); len(v) == 2 && int(v[0])<<8|int(v[1]) < 576 {
+		bad(dhcpv4.OptionMaximumDHCPMessageSize, "size %d under 576", int(v[0])<<8|int(v[1]))
+	}
+	if v, ok := req.Options[dhcpv4.OptionClientIdentifier.Code()]; ok && len(v) < 2 {
+		bad(dhcpv4.OptionClientIdentifier, "length %d, expected at least 2", len(v))
+	}
+	if v, ok := req.Options[dhcpv4.OptionHostName.Code()]; ok {
+		if reason := checkName(v); reason != "" {
+			bad(dhcpv4.OptionHostName, "%s", reason)
+		}
+	}
+	if v, ok := req.Options[dhcpv4.OptionFQDN.Code()]; ok {
+		// Flags, and the deprecated RCODE1 and RCODE2 (RFC4702 §2)
+		if len(v) < 3 {
+			bad(dhcpv4.OptionFQDN, "length %d, expected at least 3", len(v))
+		} else if len(v) > 3 {
+			check := checkName
+			if v[0]&0x04 != 0 {
+				// The E flag: the name is in DNS wire format
+				check = checkLabels
+			}
+			if reason := check(v[3:]); reason != "" {
+				bad(dhcpv4.OptionFQDN, "%s", reason)
+			}
+		}
+	}
+	if v, ok := req.Options[dhcpv4.OptionRelayAgentInformation.Code()]; ok {
+		if reason := checkTLV(v, false); reason != "" {
+			bad(dhcpv4.OptionRelayAgentInformation, "%s", reason)
+		}
+	}
+	// The vendor options are only parsed for the PXE clients (RFC4578)
+	if v, ok := req.Options[dhcpv4.OptionVendorSpecificInformation.Code()]; ok &&
+		bytes.HasPrefix(req.Options.Get(dhcpv4.OptionClassIdentifier), []byte("PXEClient")) {
+		if reason := checkTLV(v, true); reason != "" {
+			bad(dhcpv4.OptionVendorSpecificInformation, "%s", reason)
+		}
+	}
+	for _, code := range []dhcpv4.OptionCode{dhcpv4.OptionVendorIdentifyingVendorClass, dhcpv4.OptionVendorIdentifyingVendorSpecific} {
+		if v, ok := req.Options[code.Code()]; ok {
+			if reason := checkVendorIdentifying(v); reason != "" {
+				bad(code, "%s", reason)
+			}
+		}
+	}
+	return problems
+}
+
+// checkName returns why a host or domain name is absurd, "" if it is not
+func checkName(name []byte) string {
+	if len(name) == 0 || len(name) > maxNameLength {
+		return fmt.Sprintf("name length %d, expected 1 to %d", len(name), maxNameLength)
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Sprintf("control character %#x in name", c)
+		}
+	}
+	return ""
+}
+
+// checkLabels returns why a domain name in DNS wire format (RFC1035 §3.1) is
+// absurd, "" if it is not
+func checkLabels(name []byte) string {
+	if len(name) > maxNameLength {
+		return fmt.Sprintf("name length %d, over %d", len(name), maxNameLength)
+	}
+	for i := 0; i < len(name); {
+		length := int(name[i])
+		if length == 0 {
+			return ""
+		}
+		if length > 63 || i+1+length > len(name) {
+			return fmt.Sprintf("label of length %d overflows the name", length)
+		}
+		if reason := checkName(name[i+1 : i+1+length]); reason != "" {
+			return reason
+		}
+		i += 1 + length
+	}
+	return ""
+}
+
+// checkTLV returns why the sub-options of an option overflow it, "" if they
+// do not. The pad and end sub-options (0 and 255, without length) are only
+// allowed in the vendor options
+func checkTLV(data []byte, padEnd bool) string {
+	for i := 0; i < len(data); {
+		if padEnd && data[i] == 0 {
+			i++
+			continue
+		}
+		if padEnd && data[i] == 255 {
+			return ""
+		}
+		if i+2 > len(data) {
+			return fmt.Sprintf("truncated sub-option %d", data[i])
+		}
+		length := int(data[i+1])
+		if i+2+length > len(data) {
+			return fmt.Sprintf("sub-option %d of length %d overflows the option", data[i], length)
+		}
+		i += 2 + length
+	}
+	return ""
+}
+
+// checkVendorIdentifying returns why the vendor-identifying options (RFC3925)
+// overflow their option, "" if they do not: they are made of an enterprise
+// number and data length, followed by the data
+func checkVendorIdentifying(data []byte) string {
+	for i := 0; i < len(data); {
+		if i+5 > len(data) {
+			return "truncated enterprise"
+		}
+		length := int(data[i+4])
+		if i+5+length > len(data) {
+			return fmt.Sprintf("data of length %d overflows the option", length)
+		}
+		i += 5 + length
+	}
+	return ""
+}
+
+// check6 returns the problems of a DHCPv6 request
+func check6(req dhcpv6.DHCPv6) []problem {
+	var problems []problem
+	for d := req; d.IsRelay(); {
+		relay := d.(*dhcpv6.RelayMessage)
+		if relay.HopCount > maxHops6 {
+			return []problem{{reason: fmt.Sprintf("hop count %d over %d", relay.HopCount, maxHops6)}}
+		}
+		inner := relay.Options.RelayMessage()
+		if inner == nil {
+			return []problem{{reason: "relay message without message"}}
+		}
+		d = inner
+	}
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		return []problem{{reason: err.Error()}}
+	}
+	switch msg.MessageType {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm,
+		dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease,
+		dhcpv6.MessageTypeDecline:
+		// RFC8415 §16
+		if msg.Options.ClientID() == nil {
+			problems = append(problems, problem{reason: "no client identifier"})
+		}
+	}
+	if fqdn := msg.Options.FQDN(); fqdn != nil && fqdn.DomainName != nil {
+		for _, name := range fqdn.DomainName.Labels {
+			if reason := checkName([]byte(name)); reason != "" {
+				problems = append(problems, problem{option: uint16(dhcpv6.OptionFQDN), reason: reason})
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// fatal returns whether some problems cannot be fixed by removing an option
+func fatal(problems []problem) bool {
+	for _, p := range problems {
+		if p.option == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validate4 returns a DHCPv4 chain applying the malformed request policy to
+// its requests, the chain itself if it is empty
+func validate4(policy config.MalformedPolicy, handlers []handler.Handler4Ctx) []handler.Handler4Ctx {
+	if len(handlers) == 0 {
+		return handlers
+	}
+	return []handler.Handler4Ctx{func(ctx context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		problems := check4(req)
+		if len(problems) == 0 {
+			return run4(ctx, handlers, req, resp)
+		}
+		log.Warningf("MainHandler4: malformed request from %s: %v", req.ClientHWAddr, problems)
+		switch policy {
+		case config.MalformedDrop:
+			return nil, true
+		case config.MalformedBestEffort:
+			if fatal(problems) {
+				return nil, true
+			}
+			for _, p := range problems {
+				delete(req.Options, uint8(p.option))
+				delete(resp.Options, uint8(p.option))
+			}
+		}
+		return run4(ctx, handlers, req, resp)
+	}}
+}
+
+// validate6 behaves like validate4, for DHCPv6 chains. The malformed options
+// are removed from the innermost message
+func validate6(policy config.MalformedPolicy, handlers []handler.Handler6Ctx) []handler.Handler6Ctx {
+	if len(handlers) == 0 {
+		return handlers
+	}
+	return []handler.Handler6Ctx{func(ctx context.Context, req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+		problems := check6(req)
+		if len(problems) == 0 {
+			return run6(ctx, handlers, req, resp)
+		}
+		log.Warningf("MainHandler6: malformed request: %v", problems)
+		switch policy {
+		case config.MalformedDrop:
+			return nil, true
+		case config.MalformedBestEffort:
+			if fatal(problems) {
+				return nil, true
+			}
+			msg, err := req.GetInnerMessage()
+			if err != nil {
+				return nil, true
+			}
+			for _, p := range problems {
+				msg.Options.Del(dhcpv6.OptionCode(p.option))
+			}
+		}
+		return run6(ctx, handlers, req, resp)
+	}}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+// pxeDiscover4 returns a well-formed DISCOVER of a PXE client, relayed
+func pxeDiscover4(t *testing.T) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(clientMAC,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000:UNDI:002001")),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, []byte{6, 1, 8, 0, 255})),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionRelayAgentInformation, []byte{1, 3, 'e', 't', 'h'})),
+		dhcpv4.WithOption(dhcpv4.OptHostName("pxe-client")),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, []byte{0x05, 0, 0, 3, 'p', 'x', 'e', 0})),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorIdentifyingVendorClass, []byte{0, 0, 0x0d, 0xe9, 2, 'a', 'b'})),
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(1500)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestCheck4(t *testing.T) {
+	if problems := check4(pxeDiscover4(t)); len(problems) != 0 {
+		t.Fatalf("unexpected problems with a well-formed request: %v", problems)
+	}
+	for _, tc := range []struct {
+		name   string
+		modify func(*dhcpv4.DHCPv4)
+		option uint8
+	}{
+		{"hop count", func(d *dhcpv4.DHCPv4) { d.HopCount = 17 }, 0},
+		{"lease time", func(d *dhcpv4.DHCPv4) { d.Options[51] = []byte{1, 2} }, 51},
+		{"message size", func(d *dhcpv4.DHCPv4) { d.Options[57] = []byte{0, 200} }, 57},
+		{"client identifier", func(d *dhcpv4.DHCPv4) { d.Options[61] = []byte{1} }, 61},
+		{"empty host name", func(d *dhcpv4.DHCPv4) { d.Options[12] = []byte{} }, 12},
+		{"host name control character", func(d *dhcpv4.DHCPv4) { d.Options[12] = []byte("pxe\n") }, 12},
+		{"short FQDN", func(d *dhcpv4.DHCPv4) { d.Options[81] = []byte{0, 0} }, 81},
+		{"FQDN label", func(d *dhcpv4.DHCPv4) { d.Options[81] = []byte{0x04, 0, 0, 9, 'p', 'x', 'e'} }, 81},
+		{"relay sub-option", func(d *dhcpv4.DHCPv4) { d.Options[82] = []byte{1, 8, 'e', 't', 'h'} }, 82},
+		{"truncated relay sub-option", func(d *dhcpv4.DHCPv4) { d.Options[82] = []byte{1, 1, 'e', 2} }, 82},
+		{"vendor sub-option", func(d *dhcpv4.DHCPv4) { d.Options[43] = []byte{6, 200, 8} }, 43},
+		{"vendor class", func(d *dhcpv4.DHCPv4) { d.Options[124] = []byte{0, 0, 0x0d, 0xe9, 9, 'a'} }, 124},
+		{"vendor specific", func(d *dhcpv4.DHCPv4) { d.Options[125] = []byte{0, 0, 0x0d} }, 125},
+	} {
+		req := pxeDiscover4(t)
+		tc.modify(req)
+		problems := check4(req)
+		if len(problems) != 1 || problems[0].option != uint16(tc.option) {
+			t.Errorf("%s: expected a problem with option %d, got %v", tc.name, tc.option, problems)
+		}
+	}
+
+	// The vendor options of other clients are not parsed
+	req := pxeDiscover4(t)
+	req.Options[43] = []byte{6, 200, 8}
+	req.UpdateOption(dhcpv4.OptClassIdentifier("MSFT 5.0"))
+	if problems := check4(req); len(problems) != 0 {
+		t.Errorf("unexpected problems with opaque vendor options: %v", problems)
+	}
+}
+
+func TestCheck6(t *testing.T) {
+	solicit, err := dhcpv6.NewSolicit(clientMAC, dhcpv6.WithFQDN(0, "client.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := check6(solicit); len(problems) != 0 {
+		t.Fatalf("unexpected problems with a well-formed request: %v", problems)
+	}
+	relayed, err := dhcpv6.EncapsulateRelay(solicit, dhcpv6.MessageTypeRelayForward, net.IPv6loopback, net.IPv6loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayed.HopCount = 33
+	if problems := check6(relayed); !fatal(problems) {
+		t.Errorf("expected a problem with the hop count, got %v", problems)
+	}
+
+	solicit.Options.Del(dhcpv6.OptionClientID)
+	if problems := check6(solicit); !fatal(problems) {
+		t.Errorf("expected a problem with the missing client identifier, got %v", problems)
+	}
+	solicit.MessageType = dhcpv6.MessageTypeInformationRequest
+	if problems := check6(solicit); len(problems) != 0 {
+		t.Errorf("unexpected problems with an INFORMATION-REQUEST: %v", problems)
+	}
+}
+
+func TestValidate4(t *testing.T) {
+	var handled *dhcpv4.DHCPv4
+	handlers := []handler.Handler4Ctx{func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+		handled = req
+		return resp, true
+	}}
+	handle := func(policy config.MalformedPolicy, req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+		handled = nil
+		return process4(handler.NewContext(context.Background()), req, validate4(policy, handlers), false, false)
+	}
+	malformed := func() *dhcpv4.DHCPv4 {
+		req := pxeDiscover4(t)
+		req.Options[82] = []byte{1, 8, 'e', 't', 'h'}
+		return req
+	}
+
+	if resp := handle(config.MalformedLog, malformed()); resp == nil || handled == nil {
+		t.Error("expected the request to be handled")
+	}
+	if resp := handle(config.MalformedDrop, malformed()); resp != nil || handled != nil {
+		t.Error("expected the request to be dropped")
+	}
+	resp := handle(config.MalformedBestEffort, malformed())
+	if resp == nil || handled == nil {
+		t.Fatal("expected the request to be handled")
+	}
+	if handled.Options.Has(dhcpv4.OptionRelayAgentInformation) || resp.Options.Has(dhcpv4.OptionRelayAgentInformation) {
+		t.Error("expected the malformed option to be removed")
+	}
+	if !handled.Options.Has(dhcpv4.OptionVendorSpecificInformation) {
+		t.Error("expected the other options to be kept")
+	}
+	req := pxeDiscover4(t)
+	req.HopCount = 17
+	if resp := handle(config.MalformedBestEffort, req); resp != nil || handled != nil {
+		t.Error("expected the request to be dropped")
+	}
+}
+
+// trusting4 reads the options checked by check4 the way a plugin trusting
+// them would, panicking if they are malformed
+func trusting4(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+	if v := req.Options.Get(dhcpv4.OptionIPAddressLeaseTime); v != nil {
+		binary.BigEndian.Uint32(v)
+	}
+	if v := req.Options.Get(dhcpv4.OptionMaximumDHCPMessageSize); v != nil {
+		binary.BigEndian.Uint16(v)
+	}
+	// The relay agent sub-options have no pad nor end (RFC3046 §2.0)
+	v := req.Options.Get(dhcpv4.OptionRelayAgentInformation)
+	for i := 0; i < len(v); i += 2 + int(v[i+1]) {
+		_ = v[i+2 : i+2+int(v[i+1])]
+	}
+	if strings.HasPrefix(req.ClassIdentifier(), "PXEClient") {
+		v := req.Options.Get(dhcpv4.OptionVendorSpecificInformation)
+		for i := 0; i < len(v) && v[i] != 255; {
+			if v[i] == 0 {
+				i++
+				continue
+			}
+			_ = v[i+2 : i+2+int(v[i+1])]
+			i += 2 + int(v[i+1])
+		}
+	}
+	return resp, false
+}
+
+// TestMutations runs randomly corrupted requests through the server, which
+// should neither panic nor pass malformed requests to the plugins
+func TestMutations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	handlers := validate4(config.MalformedBestEffort, []handler.Handler4Ctx{
+		func(_ context.Context, req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
+			if problems := check4(req); len(problems) > 0 {
+				t.Errorf("malformed request handled: %v", problems)
+			}
+			return resp, false
+		},
+		trusting4,
+	})
+	orig := pxeDiscover4(t).ToBytes()
+	parsed := 0
+	for i := 0; i < 20000; i++ {
+		buf := append([]byte(nil), orig...)
+		for n := rng.Intn(4) + 1; n > 0; n-- {
+			// Corrupt the options rather than the fixed header
+			buf[240+rng.Intn(len(buf)-240)] = byte(rng.Intn(256))
+		}
+		req, err := dhcpv4.FromBytes(buf)
+		if err != nil {
+			continue
+		}
+		parsed++
+		process4(handler.NewContext(context.Background()), req, handlers, true, true)
+	}
+	if parsed == 0 {
+		t.Error("no corrupted request parsed")
+	}
+}
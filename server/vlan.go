@@ -0,0 +1,265 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+// +build linux
+
+package server
+
+// Provider-edge deployments serve hundreds of VLANs, often without a VLAN
+// subinterface, nor an address, for each of them. A VLAN listener serves the
+// VLANs of an interface with a single packet socket: the requests are
+// received tagged on the parent interface, and the replies sent back tagged
+// on it. The plugins see the VLAN of a request as the circuit ID of a relay
+// agent information option, "<interface>.<VLAN ID>" (eg. eth0.100, see
+// plugins/class), as if it was relayed from a VLAN subinterface of the usual
+// name. Relayed requests are left to the UDP listeners.
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/coredhcp/coredhcp/config"
+	"github.com/coredhcp/coredhcp/handler"
+)
+
+type listenerVLAN struct {
+	// file is the packet socket, and conn gives access to it through the
+	// runtime poller, so that closing it stops Serve
+	file          *os.File
+	conn          syscall.RawConn
+	iface         net.Interface
+	vlans         config.VLANs
+	pipeline      *pipeline
+	handlers      []handler.Handler4Ctx
+	rapidCommit   bool
+	authoritative bool
+	reply         config.ReplyPolicy
+}
+
+// bootpsFilter only passes the tagged IPv4 UDP packets to the DHCP server
+// port, the VLAN tags being stripped by the kernel
+var bootpsFilter = []bpf.Instruction{
+	bpf.LoadExtension{Num: bpf.ExtVLANTagPresent},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 10},
+	// EtherType
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 0x0800, SkipTrue: 8},
+	// IP protocol
+	bpf.LoadAbsolute{Off: 23, Size: 1},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 17, SkipTrue: 6},
+	// Fragment offset
+	bpf.LoadAbsolute{Off: 20, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 4},
+	// UDP destination port, after the IP header
+	bpf.LoadMemShift{Off: 14},
+	bpf.LoadIndirect{Off: 16, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpEqual, Val: dhcpv4.ServerPort, SkipFalse: 1},
+	bpf.RetConstant{Val: 0xffff},
+	bpf.RetConstant{Val: 0},
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func listenVLAN(vlans config.VLANs) (*listenerVLAN, error) {
+	iface, err := net.InterfaceByName(vlans.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("DHCPv4: Listen could not find interface %s: %v", vlans.Interface, err)
+	}
+	if f := inherited[socketNameVLAN(iface.Name)]; f != nil {
+		delete(inherited, socketNameVLAN(iface.Name))
+		l := listenerVLAN{file: f, iface: *iface, vlans: vlans}
+		if l.conn, err = f.SyscallConn(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &l, nil
+	}
+	raw, err := bpf.Assemble(bootpsFilter)
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("DHCPv4: cannot open packet socket: %v", err)
+	}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("DHCPv4: cannot filter packet socket: %v", err)
+	}
+	// The VLAN ID comes in the auxiliary data
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_AUXDATA, 1); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("DHCPv4: cannot enable packet auxiliary data: %v", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("DHCPv4: cannot bind packet socket to %s: %v", iface.Name, err)
+	}
+
+	l := listenerVLAN{file: os.NewFile(uintptr(fd), "packet:"+iface.Name), iface: *iface, vlans: vlans}
+	if l.conn, err = l.file.SyscallConn(); err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Close closes the packet socket
+func (l *listenerVLAN) Close() error {
+	return l.file.Close()
+}
+
+func (l *listenerVLAN) socket() (string, *os.File, error) {
+	var (
+		dup  int
+		derr error
+	)
+	// Fd would put the socket in blocking mode
+	if err := l.conn.Control(func(fd uintptr) {
+		dup, derr = unix.Dup(int(fd))
+	}); err != nil {
+		return "", nil, err
+	}
+	if derr != nil {
+		return "", nil, derr
+	}
+	return socketNameVLAN(l.iface.Name), os.NewFile(uintptr(dup), l.file.Name()), nil
+}
+
+// Serve receives the requests from the VLANs
+func (l *listenerVLAN) Serve() error {
+	log.Printf("Listen on the VLANs of %s", l.iface.Name)
+	oob := make([]byte, unix.CmsgSpace(int(unsafe.Sizeof(unix.TpacketAuxdata{}))))
+	for {
+		b := *bufpool.Get().(*[]byte)
+		b = b[:MaxDatagram] //Reslice to max capacity in case the buffer in pool was resliced smaller
+
+		var (
+			n, oobn int
+			rerr    error
+		)
+		err := l.conn.Read(func(fd uintptr) bool {
+			n, oobn, _, _, rerr = unix.Recvmsg(int(fd), b, oob, 0)
+			return rerr != unix.EAGAIN
+		})
+		if err == nil {
+			err = rerr
+		}
+		if err != nil {
+			log.Printf("Error reading from packet socket: %v", err)
+			return err
+		}
+		vlan, ok := vlanID(oob[:oobn])
+		if !ok || !l.vlans.Contains(vlan) {
+			bufpool.Put(&b)
+			continue
+		}
+		// The filter only passes full Ethernet frames, the source MAC
+		// address is the client's as relayed requests are skipped
+		frame := b[:n]
+		if !l.pipeline.submit(frame[6:12], isDiscover4(udpPayload(frame)), func() { l.HandleFrame(frame, vlan) }) {
+			log.Warningf("Too many requests, dropping a request from VLAN %d of %s", vlan, l.iface.Name)
+			bufpool.Put(&b)
+		}
+	}
+}
+
+// vlanID returns the VLAN ID of the auxiliary data of a packet
+func vlanID(oob []byte) (uint16, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_PACKET || m.Header.Type != unix.PACKET_AUXDATA || uintptr(len(m.Data)) < unsafe.Sizeof(unix.TpacketAuxdata{}) {
+			continue
+		}
+		aux := (*unix.TpacketAuxdata)(unsafe.Pointer(&m.Data[0]))
+		if aux.Status&unix.TP_STATUS_VLAN_VALID == 0 {
+			return 0, false
+		}
+		return aux.Vlan_tci & 0x0fff, true
+	}
+	return 0, false
+}
+
+// HandleFrame runs a request received from a VLAN through the plugin chain,
+// and sends the reply back to the VLAN
+func (l *listenerVLAN) HandleFrame(frame []byte, vlan uint16) {
+	packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.NoCopy)
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok {
+		bufpool.Put(&frame)
+		return
+	}
+	req, err := dhcpv4.FromBytes(udp.Payload)
+	if err != nil {
+		bufpool.Put(&frame)
+		log.Printf("Error parsing DHCPv4 request: %v", err)
+		return
+	}
+	if !req.GatewayIPAddr.IsUnspecified() {
+		bufpool.Put(&frame)
+		return
+	}
+	circuit := fmt.Sprintf("%s.%d", l.iface.Name, vlan)
+	req.UpdateOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuit))))
+
+	ctx := handler.NewContext(context.Background())
+	// The request as sent by the client, without the circuit ID
+	handler.SetRawRequest(ctx, udp.Payload)
+	resp := process4(ctx, req, l.handlers, l.rapidCommit, l.authoritative)
+	bufpool.Put(&frame)
+	if resp == nil {
+		log.Printf("MainHandler4: dropping request from %s because response is nil", circuit)
+		return
+	}
+	delete(resp.Options, dhcpv4.OptionRelayAgentInformation.Code())
+	handler.FinishReply4(ctx, resp)
+
+	dstMAC := req.ClientHWAddr
+	mode, dstIP := replyTo(req, resp, l.reply, true)
+	if mode == replyBroadcast {
+		dstMAC = layers.EthernetBroadcast
+	}
+	srcIP := resp.ServerIdentifier()
+	if srcIP == nil {
+		srcIP = net.IPv4zero
+	}
+	data, err := ethernetFrame(l.iface.HardwareAddr, dstMAC, vlan, srcIP, dstIP, resp)
+	if err != nil {
+		log.Errorf("MainHandler4: %v", err)
+		return
+	}
+	var addr [8]byte
+	copy(addr[:], dstMAC)
+	var werr error
+	err = l.conn.Write(func(fd uintptr) bool {
+		werr = unix.Sendto(int(fd), data, 0, &unix.SockaddrLinklayer{Ifindex: l.iface.Index, Halen: 6, Addr: addr})
+		return werr != unix.EAGAIN
+	})
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		log.Errorf("MainHandler4: cannot send reply to %s: %v", circuit, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package server
+
+// A listen address can name its interface with a glob pattern, eg.
+// `0.0.0.0%vlan*`: the server then listens on each interface matching it,
+// binding to the new ones as they come up and closing the listeners of the
+// ones going down or away.
+
+import (
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// rescanInterval is how often the interfaces are listed
+const rescanInterval = 10 * time.Second
+
+// isPattern returns whether an interface name is a glob pattern
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// boundListener is a listener opened on an interface matching a pattern
+type boundListener struct {
+	index int
+	l     listener
+}
+
+// watcher binds an address to the interfaces matching the pattern of its
+// zone
+type watcher struct {
+	addr  net.UDPAddr
+	open  func(*net.UDPAddr) (listener, error)
+	bound map[string]boundListener
+}
+
+// watch starts binding an address to the interfaces matching the pattern of
+// its zone, until the server is closed
+func (s *Servers) watch(addr net.UDPAddr, open func(*net.UDPAddr) (listener, error)) {
+	w := watcher{addr: addr, open: open, bound: make(map[string]boundListener)}
+	w.rescan()
+	go func() {
+		ticker := time.NewTicker(rescanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				for name := range w.bound {
+					w.unbind(name)
+				}
+				return
+			case <-ticker.C:
+				w.rescan()
+			}
+		}
+	}()
+}
+
+// rescan binds to the interfaces matching the pattern which are up, and
+// unbinds from the others
+func (w *watcher) rescan() {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Errorf("Could not list network interfaces: %v", err)
+		return
+	}
+	up := make(map[string]int)
+	for _, iface := range ifaces {
+		if ok, _ := path.Match(w.addr.Zone, iface.Name); ok && iface.Flags&net.FlagUp != 0 {
+			up[iface.Name] = iface.Index
+		}
+	}
+	for name, b := range w.bound {
+		// An interface recreated with the same name has a new index
+		if index, ok := up[name]; !ok || index != b.index {
+			log.Infof("Interface %s is gone, closing its listener", name)
+			w.unbind(name)
+		}
+	}
+	for name, index := range up {
+		if _, ok := w.bound[name]; ok {
+			continue
+		}
+		addr := w.addr
+		addr.Zone = name
+		l, err := w.open(&addr)
+		if err != nil {
+			// Retried on the next scan
+			log.Warningf("Could not listen on %s: %v", name, err)
+			continue
+		}
+		log.Infof("Interface %s matches %s, listening on it", name, w.addr.Zone)
+		w.bound[name] = boundListener{index: index, l: l}
+		go func(name string) {
+			// Closing the listener stops it with an error too
+			if err := l.Serve(); err != nil {
+				log.Debugf("Listener on %s stopped: %v", name, err)
+			}
+		}(name)
+	}
+}
+
+func (w *watcher) unbind(name string) {
+	if err := w.bound[name].l.Close(); err != nil {
+		log.Warningf("Could not close the listener on %s: %v", name, err)
+	}
+	delete(w.bound, name)
+}